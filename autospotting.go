@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
+	"time"
 
 	autospotting "github.com/AutoSpotting/AutoSpotting/core"
 	"github.com/aws/aws-lambda-go/events"
@@ -17,6 +19,14 @@ import (
 
 type cfgData struct {
 	*autospotting.Config
+
+	// The values of the flags and the overrides given on the command line,
+	// as environment variables or in the configuration files, which the
+	// Parameter Store settings are applied on top of
+	baseline        map[string]string
+	regionOverrides map[string]map[string]string
+	tagOverrides    map[string]map[string]string
+	groupOverrides  map[string]map[string]string
 }
 
 var conf *cfgData
@@ -27,7 +37,10 @@ var Version = "number missing"
 func main() {
 	if os.Getenv("AWS_LAMBDA_FUNCTION_NAME") != "" {
 		lambda.Start(Handler)
+	} else if flag.NArg() > 0 {
+		runCommand(flag.Args())
 	} else {
+		conf.loadParameters()
 		run()
 	}
 }
@@ -51,7 +64,85 @@ func run() {
 		"instance_termination_method=%s "+
 		"termination_notification_action=%s "+
 		"cron_schedule=%s\n "+
-		"cron_schedule_state=%s\n",
+		"cron_schedule_state=%s\n "+
+		"cron_timezone=%s "+
+		"chaos_mode=%s "+
+		"chaos_percentage=%.2f "+
+		"command_queue_url=%s "+
+		"event_queue_url=%s "+
+		"cleanup_orphans=%t "+
+		"features=%s "+
+		"rollout_percentage=%.1f "+
+		"canary_asgs=%s "+
+		"max_concurrent_groups=%d "+
+		"notification_topic_arn=%s "+
+		"check_spot_quotas=%t "+
+		"spot_quota_warning_threshold=%.1f "+
+		"spot_quota_increase_percentage=%.1f "+
+		"batch_compute_environments=%s "+
+		"batch_spot_fleet_role=%s "+
+		"slack_signing_secret_set=%t "+
+		"change_webhook_url=%s "+
+		"change_webhook_template=%s "+
+		"wait_for_ssm=%t "+
+		"wait_for_cloud_init=%t "+
+		"subnet_selection=%s "+
+		"key_pair=%s "+
+		"include_ebs_costs=%t "+
+		"check_reservations=%t "+
+		"interruption_notice_endpoint=%s "+
+		"min_instance_uptime=%s "+
+		"deployment_freeze_tag=%s "+
+		"spot_request_type=%s "+
+		"price_hysteresis_runs=%d "+
+		"price_hysteresis_percentage=%.1f "+
+		"min_savings_percentage=%.1f "+
+		"audit_mode=%t "+
+		"notifications=%s "+
+		"notification_window=%s "+
+		"notification_rate_limit=%d "+
+		"conversion_mode=%s "+
+		"price_weight=%.2f "+
+		"interruption_weight=%.2f "+
+		"max_interruption_frequency=%.1f "+
+		"placement_weight=%.2f "+
+		"headroom_weight=%.2f "+
+		"replacement_batch_size=%d "+
+		"max_replacements_per_run=%d "+
+		"max_change_percentage_per_hour=%.1f "+
+		"max_size_strategy=%s "+
+		"run_artifact_location=%s "+
+		"metrics_namespace=%s "+
+		"correct_capacity_drift=%t "+
+		"notification_webhook_url=%s "+
+		"profiles='%s' "+
+		"parameter_store_path=%s "+
+		"ownership_tags='%s' "+
+		"cross_account_roles='%s' "+
+		"organization_accounts='%s' "+
+		"organization_role_name=%s "+
+		"arm64_amis='%s' "+
+		"drain_kubernetes_nodes=%t "+
+		"kubernetes_cluster=%s "+
+		"kubernetes_drain_timeout=%s "+
+		"drain_ecs_container_instances=%t "+
+		"ecs_cluster=%s "+
+		"ecs_drain_timeout=%s "+
+		"elastic_beanstalk_support=%t "+
+		"standalone_instance_support=%t "+
+		"check_launch_permissions=%t "+
+		"log_format=%s "+
+		"log_level=%s "+
+		"xray_tracing=%t "+
+		"action_log_table=%s "+
+		"interruption_threshold=%d "+
+		"interruption_window=%s "+
+		"interruption_cooldown=%s "+
+		"interruption_min_on_demand_percentage=%.1f "+
+		"interruption_table=%s "+
+		"lock_location=%s "+
+		"lock_ttl=%s "+
+		"region_failover_webhook_url=%s\n",
 		conf.Regions,
 		conf.MinOnDemandNumber,
 		conf.MinOnDemandPercentage,
@@ -67,6 +158,84 @@ func run() {
 		conf.TerminationNotificationAction,
 		conf.CronSchedule,
 		conf.CronScheduleState,
+		conf.CronTimezone,
+		conf.ChaosMode,
+		conf.ChaosPercentage,
+		conf.CommandQueueURL,
+		conf.EventQueueURL,
+		conf.CleanupOrphans,
+		conf.Features,
+		conf.RolloutPercentage,
+		conf.CanaryASGs,
+		conf.MaxConcurrentGroups,
+		conf.NotificationTopicARN,
+		conf.CheckSpotQuotas,
+		conf.SpotQuotaWarningThreshold,
+		conf.SpotQuotaIncreasePercentage,
+		conf.BatchComputeEnvironments,
+		conf.BatchSpotFleetRole,
+		conf.SlackSigningSecret != "",
+		conf.ChangeWebhookURL,
+		conf.ChangeWebhookTemplate,
+		conf.WaitForSSM,
+		conf.WaitForCloudInit,
+		conf.SubnetSelection,
+		conf.KeyPair,
+		conf.IncludeEBSCosts,
+		conf.CheckReservations,
+		conf.InterruptionNoticeEndpoint,
+		conf.MinInstanceUptime,
+		conf.DeploymentFreezeTag,
+		conf.SpotRequestType,
+		conf.PriceHysteresisRuns,
+		conf.PriceHysteresisPercentage,
+		conf.MinSavingsPercentage,
+		conf.AuditMode,
+		conf.Notifications,
+		conf.NotificationWindow,
+		conf.NotificationRateLimit,
+		conf.ConversionMode,
+		conf.PriceWeight,
+		conf.InterruptionWeight,
+		conf.MaxInterruptionFrequency,
+		conf.PlacementWeight,
+		conf.HeadroomWeight,
+		conf.ReplacementBatchSize,
+		conf.MaxReplacementsPerRun,
+		conf.MaxChangePercentagePerHour,
+		conf.MaxSizeStrategy,
+		conf.RunArtifactLocation,
+		conf.MetricsNamespace,
+		conf.CorrectCapacityDrift,
+		conf.NotificationWebhookURL,
+		conf.Profiles,
+		conf.ParameterStorePath,
+		conf.OwnershipTags,
+		conf.CrossAccountRoles,
+		conf.OrganizationAccounts,
+		conf.OrganizationRoleName,
+		conf.ARM64AMIs,
+		conf.DrainKubernetesNodes,
+		conf.KubernetesCluster,
+		conf.KubernetesDrainTimeout,
+		conf.DrainECSContainerInstances,
+		conf.ECSCluster,
+		conf.ECSDrainTimeout,
+		conf.ElasticBeanstalkSupport,
+		conf.StandaloneInstanceSupport,
+		conf.CheckLaunchPermissions,
+		conf.LogFormat,
+		conf.LogLevel,
+		conf.XRayTracing,
+		conf.ActionLogTable,
+		conf.InterruptionThreshold,
+		conf.InterruptionWindow,
+		conf.InterruptionCooldown,
+		conf.InterruptionMinOnDemandPercentage,
+		conf.InterruptionTable,
+		conf.LockLocation,
+		conf.LockTTL,
+		conf.RegionFailoverWebhookURL,
 	)
 
 	autospotting.Run(conf.Config)
@@ -85,7 +254,7 @@ func init() {
 	}
 
 	conf = &cfgData{
-		&autospotting.Config{
+		Config: &autospotting.Config{
 			LogFile:         os.Stdout,
 			LogFlag:         log.Ldate | log.Ltime | log.Lshortfile,
 			MainRegion:      region,
@@ -98,42 +267,215 @@ func init() {
 }
 
 // Handler implements the AWS Lambda handler
-func Handler(ctx context.Context, rawEvent json.RawMessage) {
+func Handler(ctx context.Context, rawEvent json.RawMessage) (interface{}, error) {
+	defer autospotting.StartXRayTrace(ctx, conf.Config, "AutoSpotting")(nil)
+
+	// Refresh the configuration before routing the event, so that the
+	// settings kept in the Parameter Store apply to all the events
+	conf.loadParameters()
 
+	var apiGatewayRequest events.APIGatewayProxyRequest
 	var snsEvent events.SNSEvent
+	var sqsEvent events.SQSEvent
 	var cloudwatchEvent events.CloudWatchEvent
 	parseEvent := rawEvent
 
-	// Try to parse event as an Sns Message
-	if err := json.Unmarshal(parseEvent, &snsEvent); err != nil {
-		log.Println(err.Error())
-		return
+	// Slack slash commands received through API Gateway
+	if err := json.Unmarshal(parseEvent, &apiGatewayRequest); err == nil &&
+		autospotting.IsSlackCommandRequest(apiGatewayRequest) {
+		if ignoredInAuditMode("Slack command") {
+			return nil, nil
+		}
+		return autospotting.HandleSlackCommand(conf.Config, apiGatewayRequest), nil
 	}
 
-	// If event is from Sns - extract Cloudwatch's one
-	if snsEvent.Records != nil {
-		snsRecord := snsEvent.Records[0]
-		parseEvent = []byte(snsRecord.SNS.Message)
+	// Commands and queued events delivered by an SQS event source mapping
+	if err := json.Unmarshal(parseEvent, &sqsEvent); err == nil &&
+		len(sqsEvent.Records) > 0 && sqsEvent.Records[0].EventSource == "aws:sqs" {
+		if !ignoredInAuditMode("queued commands and events") {
+			handleQueueMessages(sqsEvent)
+		}
+		return nil, nil
 	}
 
-	// Try to parse event as Cloudwatch Event Rule
-	if err := json.Unmarshal(parseEvent, &cloudwatchEvent); err != nil {
+	// Try to parse event as an Sns Message
+	if err := json.Unmarshal(parseEvent, &snsEvent); err != nil {
 		log.Println(err.Error())
-		return
+		return nil, nil
 	}
 
-	// If event is Instance Spot Interruption
-	if cloudwatchEvent.DetailType == "EC2 Spot Instance Interruption Warning" {
-		if instanceID, err := autospotting.GetInstanceIDDueForTermination(cloudwatchEvent); err != nil {
-			return
-		} else if instanceID != nil {
-			spotTermination := autospotting.NewSpotTermination(cloudwatchEvent.Region)
-			spotTermination.ExecuteAction(instanceID, conf.TerminationNotificationAction)
+	// If event is from Sns - extract the Cloudwatch ones of all its records,
+	// since batched deliveries may carry several interruption notices
+	var cloudwatchEvents []events.CloudWatchEvent
+	if snsEvent.Records != nil {
+		for _, snsRecord := range snsEvent.Records {
+			var e events.CloudWatchEvent
+			if err := json.Unmarshal([]byte(snsRecord.SNS.Message), &e); err != nil {
+				log.Println("Discarding invalid SNS message", snsRecord.SNS.MessageID, err.Error())
+				continue
+			}
+			cloudwatchEvents = append(cloudwatchEvents, e)
 		}
 	} else {
+		// Try to parse event as Cloudwatch Event Rule
+		if err := json.Unmarshal(parseEvent, &cloudwatchEvent); err != nil {
+			log.Println(err.Error())
+			return nil, nil
+		}
+		cloudwatchEvents = append(cloudwatchEvents, cloudwatchEvent)
+	}
+
+	router := newEventRouter(false)
+	scheduled := false
+	for _, e := range cloudwatchEvents {
+		if !router.Route(e) {
+			scheduled = true
+		}
+	}
+	if scheduled {
 		// Event is Autospotting Cron Scheduling
 		run()
 	}
+	return nil, nil
+}
+
+// newEventRouter returns the router of the CloudWatch events handled by
+// AutoSpotting. Unless they were received from the event queue, the
+// interruption and rebalance events are sent to the queue when it's
+// configured, in order to be handled one at a time for each group.
+func newEventRouter(queued bool) *autospotting.EventRouter {
+	router := autospotting.NewEventRouter()
+	router.Handle(autospotting.SpotInterruptionWarningEvent, func(e events.CloudWatchEvent) error {
+		return handleInterruption(e, queued)
+	})
+	router.Handle(autospotting.RebalanceRecommendationEvent, func(e events.CloudWatchEvent) error {
+		return handleRebalanceRecommendation(e, queued)
+	})
+	router.Handle(autospotting.InstanceStateChangeEvent, handleInstanceStateChange)
+	router.Handle(autospotting.TerminateLifecycleActionEvent, handleLifecycleAction)
+	router.Handle(autospotting.LaunchLifecycleActionEvent, handleLaunchLifecycleAction)
+	return router
+}
+
+func handleInterruption(cloudwatchEvent events.CloudWatchEvent, queued bool) error {
+	if ignoredInAuditMode(cloudwatchEvent.DetailType) {
+		return nil
+	}
+	instanceID, err := autospotting.GetInstanceIDDueForTermination(cloudwatchEvent)
+	if err != nil || instanceID == nil {
+		return err
+	}
+
+	spotTermination := autospotting.NewSpotTermination(cloudwatchEvent.Region)
+	spotTermination.SetOwnership(conf.Ownership())
+	if !queued && spotTermination.QueueEvent(conf.Config, instanceID,
+		autospotting.InterruptionNotice, cloudwatchEvent) {
+		return nil
+	}
+	unlock := spotTermination.LockGroup(conf.Config, instanceID)
+	defer unlock()
+	spotTermination.ForwardNotice(conf.Config, instanceID,
+		autospotting.InterruptionNotice, cloudwatchEvent.Time)
+	spotTermination.DrainNode(conf.Config, instanceID)
+	spotTermination.RecordCompositionChange(conf.Config, instanceID)
+	spotTermination.ExecuteAction(instanceID, conf.TerminationNotificationAction)
+	spotTermination.RecordInterruption(conf.Config, instanceID)
+	spotTermination.NotifyInterruption(conf.Config, instanceID, autospotting.InterruptionNotice)
+	return nil
+}
+
+func handleRebalanceRecommendation(cloudwatchEvent events.CloudWatchEvent, queued bool) error {
+	if !conf.FeatureEnabled(autospotting.RebalanceHandlingFeature) {
+		log.Println("Ignoring rebalance recommendation, the",
+			autospotting.RebalanceHandlingFeature, "feature is disabled")
+		return nil
+	}
+	if ignoredInAuditMode(cloudwatchEvent.DetailType) {
+		return nil
+	}
+	instanceID, err := autospotting.GetInstanceIDWithRebalanceRecommendation(cloudwatchEvent)
+	if err != nil || instanceID == nil {
+		return err
+	}
+
+	spotTermination := autospotting.NewSpotTermination(cloudwatchEvent.Region)
+	spotTermination.SetOwnership(conf.Ownership())
+	if !queued && spotTermination.QueueEvent(conf.Config, instanceID,
+		autospotting.RebalanceNotice, cloudwatchEvent) {
+		return nil
+	}
+	unlock := spotTermination.LockGroup(conf.Config, instanceID)
+	defer unlock()
+	spotTermination.ForwardNotice(conf.Config, instanceID,
+		autospotting.RebalanceNotice, cloudwatchEvent.Time)
+	spotTermination.DrainNode(conf.Config, instanceID)
+	spotTermination.RecordCompositionChange(conf.Config, instanceID)
+	spotTermination.ExecuteAction(instanceID, conf.TerminationNotificationAction)
+	spotTermination.NotifyInterruption(conf.Config, instanceID, autospotting.RebalanceNotice)
+	return nil
+}
+
+func handleInstanceStateChange(cloudwatchEvent events.CloudWatchEvent) error {
+	if !conf.FeatureEnabled(autospotting.StateChangeHandlingFeature) {
+		log.Println("Ignoring instance state change, the",
+			autospotting.StateChangeHandlingFeature, "feature is disabled")
+		return nil
+	}
+	if ignoredInAuditMode(cloudwatchEvent.DetailType) {
+		return nil
+	}
+
+	spotTermination := autospotting.NewSpotTermination(cloudwatchEvent.Region)
+	spotTermination.SetOwnership(conf.Ownership())
+	return spotTermination.HandleInstanceStateChange(conf.Config, cloudwatchEvent)
+}
+
+func handleLifecycleAction(cloudwatchEvent events.CloudWatchEvent) error {
+	if ignoredInAuditMode(cloudwatchEvent.DetailType) {
+		return nil
+	}
+
+	spotTermination := autospotting.NewSpotTermination(cloudwatchEvent.Region)
+	return spotTermination.HandleLifecycleAction(conf.Config, cloudwatchEvent)
+}
+
+func handleLaunchLifecycleAction(cloudwatchEvent events.CloudWatchEvent) error {
+	if ignoredInAuditMode(cloudwatchEvent.DetailType) {
+		return nil
+	}
+
+	spotTermination := autospotting.NewSpotTermination(cloudwatchEvent.Region)
+	spotTermination.SetOwnership(conf.Ownership())
+	return spotTermination.HandleLaunchLifecycleAction(conf.Config, cloudwatchEvent)
+}
+
+// ignoredInAuditMode tells if the given event has to be ignored, as all the
+// event handlers take actions right away
+func ignoredInAuditMode(event string) bool {
+	if conf.AuditMode {
+		log.Println("Ignoring", event, "in audit mode")
+	}
+	return conf.AuditMode
+}
+
+func handleQueueMessages(event events.SQSEvent) {
+	for _, m := range event.Records {
+		if cloudwatchEvent, ok := autospotting.ParseQueuedEvent(m.Body); ok {
+			if !newEventRouter(true).Route(cloudwatchEvent) {
+				log.Println("Discarding unexpected event", cloudwatchEvent.DetailType)
+			}
+			continue
+		}
+
+		c, err := autospotting.ParseCommand(m.Body)
+		if err != nil {
+			log.Println("Discarding invalid command", m.Body, err.Error())
+			continue
+		}
+		if err := autospotting.ExecuteCommand(conf.Config, c); err != nil {
+			log.Println("Command", m.Body, "failed:", err.Error())
+		}
+	}
 }
 
 // Configuration handling
@@ -148,19 +490,29 @@ func (c *cfgData) initialize() {
 	c.InstanceData = data
 }
 
+// secretHelp documents the flags which can reference secrets.
+const secretHelp = "\tCan be read from Secrets Manager or from a file, given as " +
+	"secretsmanager:<secret name or ARN>[#<JSON key>] or file:<path>.\n"
+
 func (c *cfgData) parseCommandLineFlags() {
 	flag.StringVar(&c.AllowedInstanceTypes, "allowed_instance_types", "",
 		"\n\tIf specified, the spot instances will be searched only among these types.\n\tIf missing, any instance type is allowed.\n"+
 			"\tAccepts a list of comma or whitespace separated instance types (supports globs).\n"+
-			"\tExample: ./AutoSpotting -allowed_instance_types 'c5.*,c4.xlarge'\n")
+			"\tExample: ./AutoSpotting -allowed_instance_types 'c5.*,c4.xlarge'\n"+
+			"\tCan be overridden on a per-group basis using the tag "+autospotting.AllowedInstanceTypesTag+
+			" or "+autospotting.AllowedInstanceTypesAliasTag+".\n")
 	flag.StringVar(&c.BiddingPolicy, "bidding_policy", autospotting.DefaultBiddingPolicy,
 		"\n\tPolicy choice for spot bid. If set to 'normal', we bid at the on-demand price(times the multiplier).\n"+
 			"\tIf set to 'aggressive', we bid at a percentage value above the spot price \n"+
-			"\tconfigurable using the spot_price_buffer_percentage.\n")
+			"\tconfigurable using the spot_price_buffer_percentage.\n"+
+			"\tCan be overridden on a per-group basis using the tag "+autospotting.BiddingPolicyTag+
+			" or "+autospotting.BiddingPolicyAliasTag+".\n")
 	flag.StringVar(&c.DisallowedInstanceTypes, "disallowed_instance_types", "",
 		"\n\tIf specified, the spot instances will _never_ be of these types.\n"+
 			"\tAccepts a list of comma or whitespace separated instance types (supports globs).\n"+
-			"\tExample: ./AutoSpotting -disallowed_instance_types 't2.*,c4.xlarge'\n")
+			"\tExample: ./AutoSpotting -disallowed_instance_types 't2.*,c4.xlarge'\n"+
+			"\tCan be overridden on a per-group basis using the tag "+autospotting.DisallowedInstanceTypesTag+
+			" or "+autospotting.DisallowedInstanceTypesAliasTag+".\n")
 	flag.StringVar(&c.InstanceTerminationMethod, "instance_termination_method", autospotting.DefaultInstanceTerminationMethod,
 		"\n\tInstance termination method.  Must be one of '"+autospotting.DefaultInstanceTerminationMethod+"' (default),\n"+
 			"\t or 'detach' (compatibility mode, not recommended)\n")
@@ -180,11 +532,55 @@ func (c *cfgData) parseCommandLineFlags() {
 	flag.Float64Var(&c.OnDemandPriceMultiplier, "on_demand_price_multiplier", 1.0,
 		"\n\tMultiplier for the on-demand price. Numbers less than 1.0 are useful for volume discounts.\n"+
 			"\tExample: ./AutoSpotting -on_demand_price_multiplier 0.6 will have the on-demand price "+
-			"considered at 60% of the actual value.\n")
+			"considered at 60% of the actual value.\n"+
+			"\tCan be overridden on a per-group basis using the tag "+autospotting.OnDemandPriceMultiplierTag+
+			" or "+autospotting.OnDemandPriceMultiplierAliasTag+".\n")
 	flag.StringVar(&c.Regions, "regions", "",
 		"\n\tRegions where it should be activated (separated by comma or whitespace, also supports globs).\n"+
 			"\tBy default it runs on all regions.\n"+
 			"\tExample: ./AutoSpotting -regions 'eu-*,us-east-1'\n")
+
+	flag.StringVar(&c.ParameterStorePath, "parameter_store_path", "",
+		"\n\tPath of the SSM Parameter Store where the configuration is read from before each run, such as\n"+
+			"\t/autospotting, with one parameter for each setting named after its flag, such as /autospotting/regions.\n"+
+			"\tThe settings overridden for the groups of a region or for a group are given as\n"+
+			"\t<path>/region_overrides/<region>/<setting> and <path>/group_overrides/<group>/<setting>.\n"+
+			"\tThe parameters take precedence over the flags and environment variables.\n")
+
+	flag.StringVar(&c.OwnershipTags, "ownership_tags", autospotting.DefaultOwnershipTags,
+		"\n\tTags identifying the spot instances and spot requests launched by AutoSpotting, given as space or\n"+
+			"\tcomma separated <key>[=<value>][:<group tag key>] entries. The first one is set on the launched\n"+
+			"\tresources together with the group tag, the others are also recognized, such as the tags set by older\n"+
+			"\tversions or forks. The resources having a tag given as !<key>[=<value>] are never considered\n"+
+			"\tlaunched by AutoSpotting. An empty value matches any value of the tag.\n"+
+			"\tExample: ./AutoSpotting -ownership_tags 'launched-by-autospotting=true:launched-for-asg "+
+			"spot-fork=enabled:spot-fork-asg !managed-by=terraform'\n")
+
+	flag.StringVar(&c.CrossAccountRoles, "cross_account_roles", "",
+		"\n\tIAM roles assumed for processing the accounts they belong to, given as space or comma separated\n"+
+			"\trole ARNs. Each account is processed in turn with the full replacement logic, and the CloudWatch\n"+
+			"\tmetrics are also published for each of them, with the account ID as the AccountId dimension.\n"+
+			"\tBy default only the account of the credentials is processed.\n"+
+			"\tExample: ./AutoSpotting -cross_account_roles "+
+			"'arn:aws:iam::111111111111:role/AutoSpotting arn:aws:iam::222222222222:role/AutoSpotting'\n")
+
+	flag.StringVar(&c.OrganizationAccounts, "organization_accounts", "",
+		"\n\tAccounts of the AWS Organization processed by assuming the role named organization_role_name in\n"+
+			"\teach of them, given as space or comma separated organizational unit or root IDs, which include the\n"+
+			"\taccounts of their nested units, and <tag key>=<tag value> account tags. The accounts are discovered\n"+
+			"\tbefore each run using the Organizations API, available to the management account and to delegated\n"+
+			"\tadministrators, and processed in addition to those of the cross_account_roles.\n"+
+			"\tExample: ./AutoSpotting -organization_accounts 'ou-ab12-34cd56ef autospotting=enabled'\n")
+
+	flag.StringVar(&c.OrganizationRoleName, "organization_role_name", autospotting.DefaultOrganizationRoleName,
+		"\n\tName of the IAM role assumed in the accounts discovered in the organization.\n")
+
+	flag.StringVar(&c.Profiles, "profiles", "",
+		"\n\tNamed profiles of the shared AWS configuration processed in turn during each run, given as\n"+
+			"\tspace or comma separated <profile>[:<main region>] entries, so that a single run covers the accounts\n"+
+			"\tof several partitions. The main region of each profile is used for listing the regions of its partition.\n"+
+			"\tBy default the default credentials are used.\n"+
+			"\tExample: ./AutoSpotting -profiles 'commercial:us-east-1 govcloud:us-gov-west-1'\n")
 	flag.Float64Var(&c.SpotPriceBufferPercentage, "spot_price_buffer_percentage", autospotting.DefaultSpotPriceBufferPercentage,
 		"\n\tBid a given percentage above the current spot price.\n\tProtects the group from running spot"+
 			"instances that got significantly more expensive than when they were initially launched\n"+
@@ -202,16 +598,531 @@ func (c *cfgData) parseCommandLineFlags() {
 		"\tExample: ./AutoSpotting --tag_filters 'spot-enabled=true,Environment=dev,Team=vision'\n")
 
 	flag.StringVar(&c.CronSchedule, "cron_schedule", "* *", "\n\tCron-like schedule in which to"+
-		"\tperform(or not) spot replacement actions. Format: hour day-of-week\n"+
-		"\tExample: ./AutoSpotting --cron_schedule '9-18 1-5' # workdays during the office hours \n")
+		"\tperform(or not) spot replacement actions. Format: hour day-of-week, or a standard cron\n"+
+		"\texpression: minute hour day-of-month month day-of-week, matching the minutes in which it fires\n"+
+		"\tExample: ./AutoSpotting --cron_schedule '9-18 1-5' # workdays during the office hours \n"+
+		"\tExample: ./AutoSpotting --cron_schedule '*/15 * * * 1-5' # every 15 minutes on workdays \n")
+
+	flag.StringVar(&c.CronTimezone, "cron_timezone", autospotting.DefaultCronTimezone, "\n\tTime zone in which "+
+		"the cron_schedule is evaluated, given as an IANA time zone name.\n"+
+		"\tThe tag "+autospotting.CronTimezoneTag+" can be used to override this on a group level.\n"+
+		"\tExample: ./AutoSpotting --cron_schedule '*/15 * * * 1-5' --cron_timezone 'Europe/Berlin'\n")
 
 	flag.StringVar(&c.CronScheduleState, "cron_schedule_state", "on", "\n\tControls whether to take actions "+
 		"inside or outside the schedule defined by cron_schedule. Allowed values: on|off\n"+
 		"\tExample: ./AutoSpotting --cron_schedule_state='off' --cron_schedule '9-18 1-5'  # would only take action outside the defined schedule\n")
 
+	flag.StringVar(&c.ChaosMode, "chaos_mode", autospotting.ChaosModeOff, "\n\tDeliberately interrupts "+
+		"some of the spot instances launched by AutoSpotting, for rehearsing the handling of spot interruptions.\n"+
+		"\tValid choices: "+autospotting.ChaosModeOff+" | "+autospotting.ChaosModeEvent+
+		" (simulated interruption warning, handled using the termination_notification_action) | "+
+		autospotting.ChaosModeTerminate+" (terminate without notice)\n"+
+		"\tExample: ./AutoSpotting --chaos_mode event --chaos_percentage 5\n")
+
+	flag.Float64Var(&c.ChaosPercentage, "chaos_percentage", 0.0, "\n\tPercentage of the spot instances "+
+		"launched by AutoSpotting interrupted on each run when the chaos_mode is enabled.\n")
+
+	flag.StringVar(&c.CommandQueueURL, "command_queue_url", "", "\n\tURL of an SQS queue from which "+
+		"commands for specific groups are consumed on each run, such as\n"+
+		"\t{\"action\":\"replace\",\"asg\":\"my-group\",\"region\":\"us-east-1\"}\n"+
+		"\tValid actions: "+autospotting.ReplaceCommand+" (immediately replace on-demand instances, "+
+		"ignoring the cron schedule) | "+autospotting.RevertCommand+" (go back to on-demand instances)\n")
+
+	flag.StringVar(&c.EventQueueURL, "event_queue_url", "", "\n\tURL of an SQS FIFO queue through which "+
+		"the spot interruption and rebalance events are handled, in order and one at a time for each group,\n"+
+		"\tdeduplicating the repeated events of the same instance. The queue has to trigger the Lambda function.\n")
+
+	flag.BoolVar(&c.CleanupOrphans, "cleanup_orphans", true, "\n\tReclaim the resources left behind by "+
+		"interrupted runs: spot instances launched by AutoSpotting but never attached to their group,\n"+
+		"\tunfulfilled spot requests and termination lifecycle actions pending for instances which are already gone.\n")
+
+	flag.StringVar(&c.Features, "features", "", "\n\tComma separated list of experimental features to enable.\n"+
+		"\tValid choices: "+strings.Join(autospotting.KnownFeatures(), " | ")+"\n"+
+		"\tExample: ./AutoSpotting -features "+autospotting.RebalanceHandlingFeature+"\n")
+
+	flag.Float64Var(&c.RolloutPercentage, "rollout_percentage", 100.0, "\n\tPercentage of the enabled groups "+
+		"AutoSpotting acts on, for gradually rolling it out across the fleet.\n"+
+		"\tThe groups are selected deterministically based on a hash of their name, so increasing the\n"+
+		"\tpercentage only adds new groups to the rollout.\n"+
+		"\tExample: ./AutoSpotting -rollout_percentage 5\n")
+
+	flag.StringVar(&c.CanaryASGs, "canary_asgs", "", "\n\tComma separated list of groups processed "+
+		"before all the others on each run.\n"+
+		"\tIf any of their replacements fails, the rest of the run is aborted and a notification is sent.\n"+
+		"\tExample: ./AutoSpotting -canary_asgs 'dev-web,dev-worker'\n")
+
+	flag.IntVar(&c.MaxConcurrentGroups, "max_concurrent_groups", 0, "\n\tMaximum number of groups "+
+		"processed in parallel in each region, by default there is no limit.\n"+
+		"\tThe groups are processed in the order given by their "+autospotting.PriorityTag+" tag, then by\n"+
+		"\tthe cost of their on-demand instances, so that the most important ones are handled first.\n")
+
+	flag.StringVar(&c.NotificationTopicARN, "notification_topic_arn", "", "\n\tARN of an SNS topic where "+
+		"notifications are published, such as when the run is aborted after canary failures.\n")
+
+	flag.StringVar(&c.Notifications, "notifications", "",
+		"\n\tDestinations of the notifications, as space or comma separated [<event>|<event>=]<kind>:<target>\n"+
+			"\tentries, where the events are "+autospotting.ErrorEvent+", "+autospotting.WarningEvent+", "+
+			autospotting.SummaryEvent+", "+autospotting.ReplacementEvent+", "+autospotting.LaunchFailureEvent+" or "+
+			autospotting.InterruptionEvent+",\n"+
+			"\tall of them except the last three when omitted,\n"+
+			"\tand the kinds are sns:<topic ARN>, slack:<incoming webhook URL>, webhook:<URL> or pagerduty:<routing key>.\n"+
+			"\tExample: ./AutoSpotting -notifications 'error=pagerduty:0123abcd summary|warning=slack:https://hooks.slack.com/...'\n"+
+			secretHelp)
+
+	flag.StringVar(&c.NotificationWebhookURL, "notification_webhook_url", "", "\n\tURL where a message is "+
+		"posted for each replacement, failed spot launch and spot interruption handled,\n"+
+		"\twith the group, region, old and new instance types and their prices.\n"+
+		"\tThe messages are formatted for Slack when given a Slack incoming webhook URL, otherwise they're posted as JSON.\n"+
+		secretHelp)
+
+	flag.StringVar(&c.ActionLogTable, "action_log_table", "", "\n\tName of a DynamoDB table where each "+
+		"replacement, failed spot launch and spot interruption handled is recorded,\n"+
+		"\twith its time, instances, prices and outcome. The table needs a 'group' partition key\n"+
+		"\tand a 'time' sort key, both strings.\n")
+
+	flag.StringVar(&c.RegionFailoverWebhookURL, "region_failover_webhook_url", "", "\n\tURL where a request is "+
+		"posted as JSON when a group tagged with "+autospotting.RegionFailoverTag+" couldn't be replaced\n"+
+		"\tfor lack of spot capacity, so that its capacity can be provisioned in the failover region instead.\n"+
+		secretHelp)
+
+	flag.BoolVar(&c.CheckSpotQuotas, "check_spot_quotas", true, "\n\tCheck the spot vCPU Service Quotas "+
+		"of the account before launching spot instances,\n"+
+		"\tand skip the instance types whose launch would exceed them.\n")
+
+	flag.BoolVar(&c.CheckLaunchPermissions, "check_launch_permissions", false,
+		"\n\tCheck with a dry run that the launch of each candidate instance type is allowed for the account,\n"+
+			"\tand skip the instance types denied by its guardrails, such as the service control policies of the\n"+
+			"\torganization restricting the instance types or the AMIs, before they fail at launch time.\n")
+
+	flag.Float64Var(&c.SpotQuotaWarningThreshold, "spot_quota_warning_threshold", 80.0, "\n\tPercentage "+
+		"of a spot vCPU quota above which a warning is logged and a notification is sent.\n"+
+		"\tSet to 0 to disable the warnings.\n")
+
+	flag.Float64Var(&c.SpotQuotaIncreasePercentage, "spot_quota_increase_percentage", 0, "\n\tPercentage "+
+		"by which a spot vCPU quota is automatically increased through a Service Quotas request\n"+
+		"\tonce its usage crosses the warning threshold. By default the increase is only suggested.\n"+
+		"\tExample: ./AutoSpotting -spot_quota_increase_percentage 50\n")
+
+	flag.StringVar(&c.BatchComputeEnvironments, "batch_compute_environments", "", "\n\tComma separated "+
+		"list of managed on-demand AWS Batch compute environments to be converted to spot.\n"+
+		"\tA spot clone of each of them is created and placed ahead of it in all the job queues using it,\n"+
+		"\tthe on-demand compute environment is kept as fallback capacity.\n"+
+		"\tExample: ./AutoSpotting -batch_compute_environments 'nightly-reports,ml-training'\n")
+
+	flag.StringVar(&c.BatchSpotFleetRole, "batch_spot_fleet_role", "", "\n\tARN of the IAM role "+
+		"used by the spot compute environments created for AWS Batch, needs the\n"+
+		"\tAmazonEC2SpotFleetTaggingRole managed policy.\n")
+
+	flag.StringVar(&c.SlackSigningSecret, "slack_signing_secret", "", "\n\tSigning secret of the Slack app "+
+		"sending /autospotting slash commands through API Gateway,\n"+
+		"\tused for verifying their signatures. The commands are rejected when not set.\n"+
+		secretHelp)
+
+	flag.StringVar(&c.ChangeWebhookURL, "change_webhook_url", "", "\n\tURL of a webhook receiving the "+
+		"replacements performed during each run, such as one creating change records\n"+
+		"\tin an ITSM system. Nothing is sent for runs without replacements.\n"+
+		"\tExample: ./AutoSpotting -change_webhook_url https://example.service-now.com/api/now/table/change_request\n"+
+		secretHelp)
+
+	flag.StringVar(&c.ChangeWebhookTemplate, "change_webhook_template", "generic", "\n\tTemplate of the "+
+		"change webhook payload. Valid choices: generic | servicenow | jira:<project key>,\n"+
+		"\tor a custom Go template rendering the .RunID, .Summary, .Description, .Start, .End and .Changes fields.\n")
+
+	flag.StringVar(&c.ChangeWebhookAuthorization, "change_webhook_authorization", "", "\n\tValue of the "+
+		"Authorization header sent to the change webhook, such as 'Basic <base64 credentials>'.\n"+
+		secretHelp)
+
+	flag.BoolVar(&c.WaitForSSM, "wait_for_ssm", false, "\n\tRequire the new spot instances to be "+
+		"Online in SSM before they replace on-demand instances,\n"+
+		"\tfor example when they're configured over SSM after launch.\n"+
+		"\tCan be overridden on a per-group basis using the "+autospotting.WaitForSSMTag+" tag.\n")
+
+	flag.BoolVar(&c.WaitForCloudInit, "wait_for_cloud_init", false, "\n\tRequire cloud-init to be done "+
+		"on the new spot instances before they replace on-demand instances,\n"+
+		"\tsince the EC2 status checks pass long before the user data finishes.\n"+
+		"\tThe instances need to be managed by SSM, which runs 'cloud-init status --wait' on them.\n"+
+		"\tCan be overridden on a per-group basis using the "+autospotting.WaitForCloudInitTag+" tag.\n")
+
+	flag.StringVar(&c.SubnetSelection, "subnet_selection", autospotting.DefaultSubnetSelection,
+		"\n\tSubnet used for the spot instances among the subnets of the group from the\n"+
+			"\tavailability zone of the replaced on-demand instances. Must be one of\n"+
+			"\t'"+autospotting.SameSubnetSelection+"' (default), keeping the subnet of the replaced instance,\n"+
+			"\t'"+autospotting.RoundRobinSubnetSelection+"', rotating over the subnets, or\n"+
+			"\t'"+autospotting.LeastUsedSubnetSelection+"', picking the subnet running the fewest instances of the group.\n"+
+			"\tCan be overridden on a per-group basis using the "+autospotting.SubnetSelectionTag+" tag.\n")
+
+	flag.StringVar(&c.SpotRequestType, "spot_request_type", autospotting.DefaultSpotRequestType,
+		"\n\tType of the spot requests used for launching the spot instances, either\n"+
+			"\t'"+autospotting.OneTimeSpotRequestType+"' (default), or '"+autospotting.PersistentSpotRequestType+
+			"', stopping the instances when interrupted and starting\n"+
+			"\tthem again once capacity is available. Persistent requests are cancelled when their instances\n"+
+			"\tare terminated or detached, or when their group is no longer enabled.\n"+
+			"\tCan be overridden on a per-group basis using the "+autospotting.SpotRequestTypeTag+" tag.\n")
+
+	flag.StringVar(&c.ConversionMode, "conversion_mode", autospotting.DefaultConversionMode,
+		"\n\tHow the groups are converted to spot, either '"+autospotting.ReplaceConversionMode+"' (default), replacing their\n"+
+			"\ton-demand instances with spot instances, or '"+autospotting.MIPConversionMode+"', converting them to a Mixed Instances\n"+
+			"\tPolicy using diversified spot instance types, and keeping it up to date on every run.\n"+
+			"\tCan be overridden on a per-group basis using the "+autospotting.ConversionModeTag+" tag.\n")
+
+	flag.Float64Var(&c.PriceWeight, "price_weight", 1,
+		"\n\tWeight of the savings when ranking the compatible spot instance types, which are ranked by\n"+
+			"\ta weighted score of their savings, interruption history, placement and spec headroom.\n"+
+			"\tWith the default weights they're only ranked by price.\n"+
+			"\tCan be overridden on a per-group basis using the "+autospotting.PriceWeightTag+" tag.\n")
+
+	flag.Float64Var(&c.InterruptionWeight, "interruption_weight", 0,
+		"\n\tWeight of the interruption frequency of the instance types over the last month, as reported\n"+
+			"\tby the Spot Instance Advisor, when ranking the compatible spot instance types.\n"+
+			"\tCan be overridden on a per-group basis using the "+autospotting.InterruptionWeightTag+" tag.\n")
+
+	flag.Float64Var(&c.MaxInterruptionFrequency, "max_interruption_frequency", 0,
+		"\n\tMaximum interruption frequency of the spot instance types over the last month, as a percentage,\n"+
+			"\tabove which they're not used. The instance types whose interruption frequency range reported by\n"+
+			"\tthe Spot Instance Advisor starts at or above it are excluded, for example 20 excludes the >20% range.\n"+
+			"\tThe instance types it doesn't know about are still used. 0 disables it.\n"+
+			"\tCan be overridden on a per-group basis using the "+autospotting.MaxInterruptionFrequencyTag+" tag.\n")
+
+	flag.Float64Var(&c.PlacementWeight, "placement_weight", 0,
+		"\n\tWeight of the spot capacity failures of the instance families in the availability zone seen\n"+
+			"\tduring the run when ranking the compatible spot instance types.\n"+
+			"\tCan be overridden on a per-group basis using the "+autospotting.PlacementWeightTag+" tag.\n")
+
+	flag.Float64Var(&c.HeadroomWeight, "headroom_weight", 0,
+		"\n\tWeight of the additional vCPUs and memory of the instance types compared to the replaced\n"+
+			"\tinstances when ranking the compatible spot instance types.\n"+
+			"\tCan be overridden on a per-group basis using the "+autospotting.HeadroomWeightTag+" tag.\n")
+
+	flag.IntVar(&c.ReplacementBatchSize, "replacement_batch_size", autospotting.DefaultReplacementBatchSize,
+		"\n\tMaximum number of on-demand instances of a group replaced on the same run, whose spot\n"+
+			"\treplacements are launched concurrently. The on-demand instances are still replaced one at a\n"+
+			"\ttime once their spot replacements are ready, and never below the on-demand minimum.\n"+
+			"\tCan be overridden on a per-group basis using the "+autospotting.ReplacementBatchSizeTag+" tag.\n")
+
+	flag.IntVar(&c.MaxReplacementsPerRun, "max_replacements_per_run", 0,
+		"\n\tMaximum number of on-demand instances replaced with spot instances on a single run, across all\n"+
+			"\tthe groups, protecting latency-sensitive fleets from many simultaneous replacements. 0 means no limit.\n"+
+			"\tCan be overridden on a per-group basis using the "+autospotting.MaxReplacementsPerRunTag+" or "+
+			autospotting.MaxReplacementsPerRunAliasTag+" tag,\n"+
+			"\tlimiting the replacements of the group within those of the run.\n")
+
+	flag.Float64Var(&c.MaxChangePercentagePerHour, "max_change_percentage_per_hour", 0,
+		"\n\tMaximum percentage of the instances of a group changed within an hour, counting both the\n"+
+			"\treplacements of on-demand instances and the spot interruptions, protecting quorum-based systems\n"+
+			"\tsuch as ZooKeeper or etcd from simultaneous member churn. At least one instance is changed per\n"+
+			"\thour. 0 means no limit.\n"+
+			"\tCan be overridden on a per-group basis using the "+autospotting.MaxChangePercentagePerHourTag+" or "+
+			autospotting.MaxChangePercentagePerHourAliasTag+" tag.\n")
+
+	flag.StringVar(&c.MaxSizeStrategy, "max_size_strategy", autospotting.DefaultMaxSizeStrategy,
+		"\n\tHow the groups are kept within their MinSize and MaxSize while replacing their instances, either\n"+
+			"\t'"+autospotting.RaiseMaxSizeStrategy+"' (default), attaching the spot instances first when the groups are at their\n"+
+			"\tminimum capacity and temporarily raising the MaxSize of the groups at their maximum capacity, or\n"+
+			"\t'"+autospotting.DetachFirstStrategy+"', always detaching the on-demand instances first and temporarily lowering\n"+
+			"\tthe MinSize of the groups at their minimum capacity, so that their MaxSize is never changed.\n"+
+			"\tCan be overridden on a per-group basis using the "+autospotting.MaxSizeStrategyTag+" tag.\n")
+
+	flag.StringVar(&c.KeyPair, "key_pair", "",
+		"\n\tSSH key pair used for the spot instances instead of the key pair of the replaced instances,\n"+
+			"\tor '"+autospotting.NoKeyPair+"' to launch them without a key pair, for example when using SSM Session Manager.\n"+
+			"\tCan be overridden on a per-group basis using the "+autospotting.KeyPairTag+" tag.\n")
+
+	flag.StringVar(&c.ARM64AMIs, "arm64_amis", "",
+		"\n\tAMIs of the ARM64 spot instances replacing the x86_64 on-demand instances of the groups tagged\n"+
+			"\twith "+autospotting.ArchConversionTag+"="+autospotting.ARM64ArchConversion+
+			", given as space or comma separated <x86_64 AMI ID>=<arm64 AMI ID>\n"+
+			"\tpairs mapping the AMI of the on-demand instances, also used the other way for the ARM64 instances of the\n"+
+			"\tgroups tagged with "+autospotting.ArchConversionTag+"="+autospotting.MultiArchConversion+
+			". The AMIs can also be given for a group using the\n"+
+			"\t"+autospotting.ARM64AMITag+" and "+autospotting.X8664AMITag+" tags. Without an AMI of the other\n"+
+			"\tarchitecture the instances are only replaced with ones of the same architecture.\n"+
+			"\tExample: ./AutoSpotting -arm64_amis 'ami-0123456789abcdef0=ami-0fedcba9876543210'\n")
+
+	flag.BoolVar(&c.IncludeEBSCosts, "include_ebs_costs", false, "\n\tInclude the costs of the EBS volumes "+
+		"in the price comparisons between the on-demand instances and their spot replacements,\n"+
+		"\tbased on the us-east-1 EBS prices, so that the savings estimates match the bill.\n")
+
+	flag.BoolVar(&c.CheckReservations, "check_reservations", true, "\n\tSkip the groups whose on-demand "+
+		"instances are fully covered by zonal reserved instances, which are already cheaper than spot.\n"+
+		"\tCan be overridden on a per-group basis using the "+autospotting.ReservedTag+" tag.\n")
+
+	flag.StringVar(&c.InterruptionNoticeEndpoint, "interruption_notice_endpoint", "",
+		"\n\tHTTP endpoint or SQS queue URL receiving the interruption notices of the spot instances as JSON,\n"+
+			"\twith their instance ID, private IP, group and interruption deadline, so that the workload\n"+
+			"\tcan checkpoint its state before being interrupted.\n"+
+			"\tCan be overridden on a per-group basis using the "+autospotting.InterruptionNoticeEndpointTag+" tag.\n")
+
+	flag.DurationVar(&c.MinInstanceUptime, "min_instance_uptime", 0,
+		"\n\tMinimum time the on-demand instances have been running before they are replaced, such as 15m,\n"+
+			"\tso that freshly launched instances still being configured by deployment tooling are left alone.\n")
+
+	flag.BoolVar(&c.DrainKubernetesNodes, "drain_kubernetes_nodes", false,
+		"\n\tDrain the instances which are Kubernetes nodes of EKS clusters before terminating them, when\n"+
+			"\treplacing them and when handling spot interruptions, by cordoning them and evicting their pods\n"+
+			"\twhile respecting their PodDisruptionBudgets. The nodes are detected by their eks:cluster-name\n"+
+			"\tor kubernetes.io/cluster/<cluster name> tags, and the IAM role of AutoSpotting needs to be\n"+
+			"\tallowed to drain nodes in the clusters.\n")
+
+	flag.StringVar(&c.KubernetesCluster, "kubernetes_cluster", "",
+		"\n\tName of the EKS cluster the drained instances are nodes of, when it isn't given by their tags.\n")
+
+	flag.DurationVar(&c.KubernetesDrainTimeout, "kubernetes_drain_timeout", autospotting.DefaultKubernetesDrainTimeout,
+		"\n\tMaximum time given to the pods of a drained Kubernetes node to be evicted, after which the\n"+
+			"\tinstance is terminated anyway.\n")
+
+	flag.BoolVar(&c.DrainECSContainerInstances, "drain_ecs_container_instances", false,
+		"\n\tSet the instances which are ECS container instances to DRAINING before terminating them, when\n"+
+			"\treplacing them and when handling spot interruptions, waiting for the tasks of their services to be\n"+
+			"\trescheduled on other container instances. The cluster is given by the ecs:cluster tag of the\n"+
+			"\tinstances or by the ecs_cluster option, otherwise the container instance is searched in all the\n"+
+			"\tclusters.\n")
+
+	flag.StringVar(&c.ECSCluster, "ecs_cluster", "",
+		"\n\tName of the ECS cluster the drained instances are registered to, when it isn't given by their tags.\n")
+
+	flag.DurationVar(&c.ECSDrainTimeout, "ecs_drain_timeout", autospotting.DefaultECSDrainTimeout,
+		"\n\tMaximum time given to the tasks of a draining ECS container instance to be rescheduled, after\n"+
+			"\twhich the instance is terminated anyway.\n")
+
+	flag.BoolVar(&c.ElasticBeanstalkSupport, "elastic_beanstalk_support", false,
+		"\n\tFollow the Elastic Beanstalk environments owning some of the groups: their groups are skipped\n"+
+			"\twhile the environments aren't Ready, such as during deployments or configuration updates which\n"+
+			"\tregenerate their launch configurations, and when the environments use the enhanced health reporting\n"+
+			"\tthe new spot instances only replace on-demand instances once they're healthy in the environments.\n")
+
+	flag.BoolVar(&c.StandaloneInstanceSupport, "standalone_instance_support", false,
+		"\n\tReplace the standalone EC2 instances which aren't part of any AutoScaling group and are tagged\n"+
+			"\twith "+autospotting.StandaloneTag+"=true with spot instances. The instances are stopped and replaced by\n"+
+			"\tspot instances launched from an AMI of their root volume, keeping their network interfaces, Elastic\n"+
+			"\tIP addresses and data volumes.\n")
+
+	flag.StringVar(&c.DeploymentFreezeTag, "deployment_freeze_tag", autospotting.DefaultDeploymentFreezeTag,
+		"\n\tKey of the tag set to true by CI/CD systems on the groups being deployed, which pauses\n"+
+			"\tall the replacements on these groups until the tag is removed or set to false.\n"+
+			"\tSet to an empty value to disable the deployment freeze detection.\n")
+
+	flag.IntVar(&c.PriceHysteresisRuns, "price_hysteresis_runs", 0,
+		"\n\tNumber of consecutive runs a cheaper instance type needs to beat the type of the current\n"+
+			"\tspot instances of a group before it is used for their replacements, so that groups don't\n"+
+			"\tkeep swapping between instance types whose prices cross repeatedly. 0 disables it.\n")
+
+	flag.Float64Var(&c.PriceHysteresisPercentage, "price_hysteresis_percentage", 5,
+		"\n\tMargin by which the cheaper instance type needs to beat the current one during each of\n"+
+			"\tthese runs, as a percentage of the current type's price.\n")
+
+	flag.Float64Var(&c.MinSavingsPercentage, "min_savings_percentage", 0,
+		"\n\tMinimum savings of the spot instances compared to the on-demand instances they replace,\n"+
+			"\tas a percentage of the on-demand price, so that replacements saving little are skipped.\n"+
+			"\tCan be overridden on a per-group basis using the "+autospotting.MinSavingsPercentageTag+" tag.\n")
+
+	flag.StringVar(&c.RunArtifactLocation, "run_artifact_location", "",
+		"\n\tS3 location given as s3://bucket/prefix where a JSON document describing each run is stored,\n"+
+			"\tincluding the replacements and, for each launch, all the instance types considered with their\n"+
+			"\tprices, scores and the reasons why they were excluded. Disabled by default.\n")
+
+	flag.StringVar(&c.MetricsNamespace, "metrics_namespace", "",
+		"\n\tCloudWatch namespace the metrics of each run are published to: InstancesReplaced,\n"+
+			"\tGroupsProcessed, GroupFailures, CapacityDrifts, EstimatedHourlySavings and RunDuration.\n"+
+			"\tDisabled by default.\n")
+
+	flag.BoolVar(&c.CorrectCapacityDrift, "correct_capacity_drift", false,
+		"\n\tSet the desired capacity of the groups back to what it was before a replacement when it\n"+
+			"\tchanged meanwhile, such as when AutoScaling backfilled a detached on-demand instance.\n"+
+			"\tThe drifts are always counted in the CapacityDrifts metric and notified as warnings.\n")
+
+	flag.BoolVar(&c.AuditMode, "audit_mode", false,
+		"\n\tEvaluate the groups and only report the actions that would be taken, without calling any of\n"+
+			"\tthe mutating AWS APIs, so that AutoSpotting can run with read-only permissions.\n"+
+			"\tThe interruption notices, commands and Slack requests are ignored in this mode.\n")
+
+	flag.BoolVar(&c.DryRun, "dry_run", false,
+		"\n\tSame as audit_mode, which it enables.\n")
+
+	flag.IntVar(&c.InterruptionThreshold, "interruption_threshold", 0,
+		"\n\tNumber of spot interruptions of a group within the interruption_window above which\n"+
+			"\tits on-demand capacity is kept for the interruption_cooldown. 0 disables it.\n")
+
+	flag.DurationVar(&c.InterruptionWindow, "interruption_window", autospotting.DefaultInterruptionWindow,
+		"\n\tPeriod in which the spot interruptions of a group are counted against the interruption_threshold.\n")
+
+	flag.DurationVar(&c.InterruptionCooldown, "interruption_cooldown", autospotting.DefaultInterruptionCooldown,
+		"\n\tPeriod during which the on-demand capacity of a group is kept after too many spot interruptions.\n")
+
+	flag.Float64Var(&c.InterruptionMinOnDemandPercentage, "interruption_min_on_demand_percentage", 0,
+		"\n\tPercentage of the capacity of a group brought back to on-demand during the interruption_cooldown.\n"+
+			"\tWith the default 0, the spot replacements of the group are only paused.\n")
+
+	flag.StringVar(&c.InterruptionTable, "interruption_table", "", "\n\tName of a DynamoDB table with a 'group' "+
+		"string partition key and a 'time' string sort key recording the spot interruptions\n"+
+		"\tcounted against the interruption_threshold, so that concurrent interruptions are all counted.\n"+
+		"\tBy default they're recorded in the "+autospotting.InterruptionsTag+" tag of the groups.\n")
+
+	flag.StringVar(&c.LockLocation, "lock_location", "", "\n\tWhere the locks keeping the overlapping "+
+		"invocations from taking actions on the same group are stored,\n"+
+		"\tgiven as dynamodb:<table> for a table with a 'lock' string partition key, or as s3://bucket/prefix.\n"+
+		"\tExample: ./AutoSpotting -lock_location dynamodb:autospotting-locks\n")
+
+	flag.DurationVar(&c.LockTTL, "lock_ttl", autospotting.DefaultLockTTL,
+		"\n\tTime after which the lock of a group is considered abandoned, such as after a Lambda timeout.\n")
+
+	flag.DurationVar(&c.NotificationWindow, "notification_window", time.Hour,
+		"\n\tPeriod during which the same error of a group is only notified once, and during which\n"+
+			"\tat most notification_rate_limit error notifications are sent. The errors of the groups\n"+
+			"\tare notified at the end of each run, with a single notification for each class of errors.\n")
+
+	flag.IntVar(&c.NotificationRateLimit, "notification_rate_limit", 10,
+		"\n\tMaximum number of error notifications sent during the notification_window, 0 means no limit.\n")
+
+	flag.String(flag.DefaultConfigFlagname, "", "\n\tPath of a configuration file, containing one '<flag> <value>'"+
+		" line for each of the other flags.\n")
+
+	flag.StringVar(&c.LogFormat, "log_format", autospotting.TextLogFormat,
+		"\n\tFormat of the logs, either "+autospotting.TextLogFormat+" or "+autospotting.JSONLogFormat+
+			", which logs a JSON object per line with the region, asg,\n"+
+			"\tinstance_id, action, spot_price and error fields found in the message, for querying them\n"+
+			"\tin CloudWatch Logs Insights or other log aggregators.\n")
+
+	flag.StringVar(&c.LogLevel, "log_level", autospotting.InfoLogLevel,
+		"\n\tMinimum level of the logged messages, one of "+autospotting.DebugLogLevel+", "+autospotting.InfoLogLevel+", "+
+			autospotting.WarnLogLevel+" or "+autospotting.ErrorLogLevel+". The "+autospotting.DebugLogLevel+
+			" level also logs every pricing\n"+
+			"\tcomparison and a summary of every AWS API call, like setting AUTOSPOTTING_DEBUG=true.\n")
+
+	flag.BoolVar(&c.XRayTracing, "xray_tracing", false,
+		"\n\tTrace the Lambda invocations with AWS X-Ray when they're sampled, recording the processing of each\n"+
+			"\tregion and each AWS API call, for seeing where the time is spent and diagnosing timeouts.\n")
+
+	configFile := flag.String("config_file", "", "\n\tPath of a YAML or JSON configuration file, setting the other flags "+
+		"by their names,\n"+
+		"\tand overriding the per-group settings for the groups of a region in its region_overrides block,\n"+
+		"\tfor the groups having a Key=Value tag, such as Team=payments, in its tag_overrides block,\n"+
+		"\tor for a group in its group_overrides block. The environment variables and flags take precedence.\n"+
+		"\tExample: ./AutoSpotting -config_file autospotting.yaml\n")
+
 	v := flag.Bool("version", false, "Print version number and exit.\n")
 	flag.Parse()
 	printVersion(v)
+
+	if *configFile != "" {
+		c.loadConfigFile(*configFile)
+	}
+
+	if c.ParameterStorePath != "" {
+		c.baseline = map[string]string{}
+		flag.VisitAll(func(f *flag.Flag) { c.baseline[f.Name] = f.Value.String() })
+		c.regionOverrides, c.tagOverrides, c.groupOverrides = c.RegionOverrides, c.TagOverrides, c.GroupOverrides
+	}
+
+	if c.DryRun {
+		c.AuditMode = true
+	}
+}
+
+// loadConfigFile sets the flags which weren't given on the command line or
+// as environment variables to their values from the given YAML or JSON
+// configuration file, and loads its per-region and per-group overrides.
+func (c *cfgData) loadConfigFile(path string) {
+	file, err := autospotting.LoadConfigFile(path)
+	if err != nil {
+		log.Fatalf("Couldn't load the configuration file %s: %s\n", path, err.Error())
+	}
+
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	for name, value := range file.Settings {
+		if set[name] {
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			log.Fatalf("Invalid setting %s in the configuration file %s: %s\n", name, path, err.Error())
+		}
+	}
+
+	for _, overrides := range []map[string]map[string]string{file.RegionOverrides, file.TagOverrides, file.GroupOverrides} {
+		for _, settings := range overrides {
+			for name := range settings {
+				if flag.Lookup(name) == nil {
+					log.Fatalf("Unknown overridden setting %s in the configuration file %s\n", name, path)
+				}
+			}
+		}
+	}
+	c.RegionOverrides = file.RegionOverrides
+	c.TagOverrides = file.TagOverrides
+	c.GroupOverrides = file.GroupOverrides
+}
+
+// loadParameters refreshes the configuration from the Parameter Store, on
+// top of the one given by the flags, environment variables and configuration
+// files, so that the removed parameters revert to it. The invalid settings
+// are logged and ignored, and the current configuration is kept if the
+// Parameter Store can't be read.
+func (c *cfgData) loadParameters() {
+	if c.ParameterStorePath == "" {
+		return
+	}
+
+	params, err := autospotting.LoadParameters(c.Config, c.ParameterStorePath)
+	if err != nil {
+		log.Println("Couldn't load the configuration from the Parameter Store, keeping the current one:",
+			err.Error())
+		return
+	}
+
+	for name, value := range c.baseline {
+		flag.Set(name, value)
+	}
+
+	for name, value := range params.Settings {
+		switch name {
+		case flag.DefaultConfigFlagname, "config_file", "parameter_store_path", "version":
+			log.Println("Ignoring the", name, "setting from the Parameter Store")
+			continue
+		}
+		if err := flag.Set(name, value); err != nil {
+			log.Printf("Ignoring invalid setting %s from the Parameter Store: %s\n", name, err.Error())
+		}
+	}
+
+	c.RegionOverrides = mergeOverrides(c.regionOverrides, params.RegionOverrides)
+	c.TagOverrides = mergeOverrides(c.tagOverrides, params.TagOverrides)
+	c.GroupOverrides = mergeOverrides(c.groupOverrides, params.GroupOverrides)
+
+	if c.DryRun {
+		c.AuditMode = true
+	}
+	log.Println("Loaded", len(params.Settings), "settings from the Parameter Store path", c.ParameterStorePath)
+}
+
+// mergeOverrides returns the overrides given in the configuration files,
+// overridden by the ones from the Parameter Store, ignoring the unknown
+// settings.
+func mergeOverrides(base, params map[string]map[string]string) map[string]map[string]string {
+	if len(params) == 0 {
+		return base
+	}
+
+	merged := map[string]map[string]string{}
+	for name, settings := range base {
+		merged[name] = map[string]string{}
+		for setting, value := range settings {
+			merged[name][setting] = value
+		}
+	}
+	for name, settings := range params {
+		if merged[name] == nil {
+			merged[name] = map[string]string{}
+		}
+		for setting, value := range settings {
+			if flag.Lookup(setting) == nil {
+				log.Println("Ignoring unknown overridden setting", setting, "from the Parameter Store")
+				continue
+			}
+			merged[name][setting] = value
+		}
+	}
+	return merged
 }
 
 func printVersion(v *bool) {
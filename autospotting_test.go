@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/AutoSpotting/AutoSpotting/core/autospottingtest"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+type parameterStore struct {
+	ssmiface.SSMAPI
+	parameters map[string]string
+}
+
+func (s parameterStore) GetParametersByPathPages(in *ssm.GetParametersByPathInput, fn func(*ssm.GetParametersByPathOutput, bool) bool) error {
+	out := &ssm.GetParametersByPathOutput{}
+	for name, value := range s.parameters {
+		out.Parameters = append(out.Parameters, &ssm.Parameter{Name: aws.String(name), Value: aws.String(value)})
+	}
+	fn(out, true)
+	return nil
+}
+
+type parameterStoreCloud struct {
+	*autospottingtest.Cloud
+	ssm parameterStore
+}
+
+func (c parameterStoreCloud) SSM(region string) ssmiface.SSMAPI {
+	return c.ssm
+}
+
+func TestHandlerLoadsParameters(t *testing.T) {
+	saved, savedConfig := *conf, *conf.Config
+	defer func() {
+		*conf.Config = savedConfig
+		*conf = saved
+	}()
+
+	cloud := autospottingtest.NewCloud()
+	conf.ParameterStorePath = "/autospotting"
+	conf.ClientProvider = parameterStoreCloud{
+		Cloud: cloud,
+		ssm:   parameterStore{parameters: map[string]string{"/autospotting/dry_run": "true"}},
+	}
+
+	event, _ := json.Marshal(events.SQSEvent{Records: []events.SQSMessage{
+		{EventSource: "aws:sqs", Body: `{"action":"revert","asg":"web"}`},
+	}})
+	if _, err := Handler(context.Background(), event); err != nil {
+		t.Fatalf("Handler() error = %v", err)
+	}
+
+	if !conf.DryRun || !conf.AuditMode {
+		t.Errorf("Handler() didn't load the dry run setting from the Parameter Store")
+	}
+	if calls := cloud.MutatingCalls(); len(calls) > 0 {
+		t.Errorf("Handler() made mutating calls in dry run mode: %v", calls)
+	}
+}
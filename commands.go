@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	autospotting "github.com/AutoSpotting/AutoSpotting/core"
+	"github.com/namsral/flag"
+)
+
+// Additional commands available when running AutoSpotting from the command
+// line, given as the first non-flag argument, such as:
+// ./AutoSpotting replay --cloudtrail s3://bucket/prefix
+func runCommand(args []string) {
+	switch args[0] {
+	case "replay":
+		replay(args[1:])
+	case "e2e":
+		e2e(args[1:])
+	case "agent":
+		agent(args[1:])
+	case "check-ami":
+		checkAMI(args[1:])
+	case "composition":
+		composition(args[1:])
+	case "backtest":
+		backtest(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command '%s', available commands: replay, e2e, agent, check-ami, "+
+			"composition, backtest\n", args[0])
+		os.Exit(2)
+	}
+}
+
+func parseTimeFlag(name, value string) time.Time {
+	if value == "" {
+		return time.Time{}
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		log.Fatalf("Invalid value for -%s, expected a RFC3339 timestamp such as "+
+			"2019-05-01T10:00:00Z: %s", name, err.Error())
+	}
+	return t
+}
+
+func replay(args []string) {
+	var source, from, to, principal, asg string
+
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.StringVar(&source, "cloudtrail", "",
+		"\n\tS3 location of the CloudTrail logs, given as s3://bucket/prefix\n")
+	fs.StringVar(&from, "from", "",
+		"\n\tOnly replay events newer than this RFC3339 timestamp\n")
+	fs.StringVar(&to, "to", "",
+		"\n\tOnly replay events older than this RFC3339 timestamp\n")
+	fs.StringVar(&principal, "principal", "",
+		"\n\tOnly replay events performed by IAM principals whose ARN contains this value,\n"+
+			"\tsuch as the name of the AutoSpotting IAM role\n")
+	fs.StringVar(&asg, "asg", "",
+		"\n\tOnly replay events affecting this AutoScaling group\n")
+	fs.Parse(args)
+
+	if source == "" {
+		fmt.Fprintln(os.Stderr, "Missing the -cloudtrail parameter")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	err := autospotting.Replay(autospotting.ReplayConfig{
+		Source:           source,
+		From:             parseTimeFlag("from", from),
+		To:               parseTimeFlag("to", to),
+		Principal:        principal,
+		AutoScalingGroup: asg,
+		Region:           conf.MainRegion,
+		Output:           os.Stdout,
+	})
+
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+func e2e(args []string) {
+	var e autospotting.EndToEndConfig
+
+	fs := flag.NewFlagSet("e2e", flag.ExitOnError)
+	fs.StringVar(&e.Endpoint, "endpoint", "",
+		"\n\tEndpoint of an emulated AWS API, such as http://localstack:4566\n")
+	fs.StringVar(&e.Region, "region", "us-east-1",
+		"\n\tRegion in which the synthetic AutoScaling groups are created\n")
+	fs.IntVar(&e.Groups, "groups", 2,
+		"\n\tNumber of synthetic AutoScaling groups\n")
+	fs.Int64Var(&e.Capacity, "capacity", 1,
+		"\n\tDesired capacity of each synthetic AutoScaling group\n")
+	fs.StringVar(&e.InstanceType, "instance_type", "m5.large",
+		"\n\tOn-demand instance type used by the synthetic AutoScaling groups\n")
+	fs.StringVar(&e.ImageID, "ami", "",
+		"\n\tAMI used by the synthetic AutoScaling groups, by default the first\n"+
+			"\tAMI available on the endpoint\n")
+	fs.IntVar(&e.Cycles, "cycles", 2,
+		"\n\tNumber of AutoSpotting runs executed against the synthetic groups\n")
+	fs.DurationVar(&e.Interval, "interval", 2*time.Second,
+		"\n\tPause between the AutoSpotting runs\n")
+	fs.Parse(args)
+
+	if e.Endpoint == "" {
+		fmt.Fprintln(os.Stderr, "Missing the -endpoint parameter")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	if err := autospotting.RunEndToEnd(conf.Config, e); err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+func agent(args []string) {
+	var a autospotting.AgentConfig
+
+	fs := flag.NewFlagSet("agent", flag.ExitOnError)
+	fs.DurationVar(&a.PollInterval, "poll_interval", 5*time.Second,
+		"\n\tHow often the instance metadata is checked for spot interruption notices\n")
+	fs.StringVar(&a.DrainCommand, "drain_command", "",
+		"\n\tShell command executed on the instance when a notice is received, before\n"+
+			"\tthe termination notification action. The notice type and the instance ID\n"+
+			"\tare available in the AUTOSPOTTING_NOTICE and AUTOSPOTTING_INSTANCE_ID\n"+
+			"\tenvironment variables.\n"+
+			"\tExample: ./AutoSpotting agent -drain_command 'kubectl drain $(hostname)'\n")
+	fs.BoolVar(&a.HandleRebalance, "handle_rebalance", false,
+		"\n\tAlso act on the rebalance recommendations, which are usually sent earlier\n"+
+			"\tthan the interruption notices\n")
+	fs.Parse(args)
+
+	if err := autospotting.RunAgent(conf.Config, a); err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+func checkAMI(args []string) {
+	c := autospotting.AMICheckConfig{Output: os.Stdout}
+
+	fs := flag.NewFlagSet("check-ami", flag.ExitOnError)
+	fs.StringVar(&c.ImageID, "ami_id", "",
+		"\n\tID of the AMI checked against the instance types of its region\n")
+	fs.StringVar(&c.Region, "region", conf.MainRegion,
+		"\n\tRegion of the AMI\n")
+	fs.Parse(args)
+
+	if c.ImageID == "" {
+		fmt.Fprintln(os.Stderr, "Missing the -ami_id parameter")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+
+	if err := autospotting.CheckAMI(c); err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+func composition(args []string) {
+	var from, to string
+	c := autospotting.CompositionConfig{Region: conf.MainRegion, Output: os.Stdout}
+
+	fs := flag.NewFlagSet("composition", flag.ExitOnError)
+	fs.StringVar(&c.Source, "run_artifacts", conf.RunArtifactLocation,
+		"\n\tS3 location of the run artifacts, given as s3://bucket/prefix, by default\n"+
+			"\tthe -run_artifact_location\n")
+	fs.StringVar(&from, "from", "",
+		"\n\tOnly report runs newer than this RFC3339 timestamp\n")
+	fs.StringVar(&to, "to", "",
+		"\n\tOnly report runs older than this RFC3339 timestamp\n")
+	fs.StringVar(&c.AutoScalingGroup, "asg", "",
+		"\n\tOnly report this AutoScaling group\n")
+	fs.Parse(args)
+
+	if c.Source == "" {
+		fmt.Fprintln(os.Stderr, "Missing the -run_artifacts parameter")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	c.From, c.To = parseTimeFlag("from", from), parseTimeFlag("to", to)
+
+	if err := autospotting.CompositionReport(c); err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+func backtest(args []string) {
+	var from, to string
+	b := autospotting.BacktestConfig{Region: conf.MainRegion, Output: os.Stdout}
+
+	fs := flag.NewFlagSet("backtest", flag.ExitOnError)
+	fs.StringVar(&b.Source, "run_artifacts", conf.RunArtifactLocation,
+		"\n\tS3 location of the run artifacts, given as s3://bucket/prefix, by default\n"+
+			"\tthe -run_artifact_location\n")
+	fs.StringVar(&from, "from", "",
+		"\n\tOnly replay launches newer than this RFC3339 timestamp\n")
+	fs.StringVar(&to, "to", "",
+		"\n\tOnly replay launches older than this RFC3339 timestamp\n")
+	fs.StringVar(&b.AutoScalingGroup, "asg", "",
+		"\n\tOnly replay the launches of this AutoScaling group\n")
+	fs.Parse(args)
+
+	if b.Source == "" {
+		fmt.Fprintln(os.Stderr, "Missing the -run_artifacts parameter")
+		fs.PrintDefaults()
+		os.Exit(2)
+	}
+	b.From, b.To = parseTimeFlag("from", from), parseTimeFlag("to", to)
+
+	if err := autospotting.Backtest(conf.Config, b); err != nil {
+		log.Fatal(err.Error())
+	}
+}
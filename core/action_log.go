@@ -0,0 +1,110 @@
+package autospotting
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// The outcomes of the actions recorded in the action log
+const (
+	succeededOutcome = "succeeded"
+	failedOutcome    = "failed"
+)
+
+// actionLogItem is the item written to the DynamoDB table of the action log
+// for each action. The table is keyed by the group, prefixed by its account
+// and region, and by the time of the action, so that the actions of a group
+// can be queried over a time interval.
+type actionLogItem struct {
+	Group            string  `dynamodbav:"group"`
+	Time             string  `dynamodbav:"time"`
+	Account          string  `dynamodbav:"account,omitempty"`
+	Region           string  `dynamodbav:"region"`
+	AutoScalingGroup string  `dynamodbav:"asg"`
+	RunID            string  `dynamodbav:"run_id,omitempty"`
+	Event            string  `dynamodbav:"event"`
+	Outcome          string  `dynamodbav:"outcome"`
+	Subject          string  `dynamodbav:"subject"`
+	OldInstanceID    string  `dynamodbav:"old_instance_id,omitempty"`
+	OldInstanceType  string  `dynamodbav:"old_instance_type,omitempty"`
+	OldPrice         float64 `dynamodbav:"old_price,omitempty"`
+	NewInstanceID    string  `dynamodbav:"new_instance_id,omitempty"`
+	NewInstanceType  string  `dynamodbav:"new_instance_type,omitempty"`
+	NewPrice         float64 `dynamodbav:"new_price,omitempty"`
+	Error            string  `dynamodbav:"error,omitempty"`
+}
+
+func newActionLogItem(n Notification, t time.Time) actionLogItem {
+	d := n.Action
+	group := d.Region + "/" + d.AutoScalingGroup
+	if d.Account != "" {
+		group = d.Account + "/" + group
+	}
+
+	outcome := succeededOutcome
+	if d.Error != "" || n.Event == LaunchFailureEvent {
+		outcome = failedOutcome
+	}
+
+	return actionLogItem{
+		Group:            group,
+		Time:             t.UTC().Format(time.RFC3339Nano),
+		Account:          d.Account,
+		Region:           d.Region,
+		AutoScalingGroup: d.AutoScalingGroup,
+		RunID:            n.RunID,
+		Event:            n.Event,
+		Outcome:          outcome,
+		Subject:          n.Subject,
+		OldInstanceID:    d.OldInstanceID,
+		OldInstanceType:  d.OldInstanceType,
+		OldPrice:         d.OldPrice,
+		NewInstanceID:    d.NewInstanceID,
+		NewInstanceType:  d.NewInstanceType,
+		NewPrice:         d.NewPrice,
+		Error:            d.Error,
+	}
+}
+
+// actionLogNotifier records the replacements, failed spot launches and spot
+// interruptions handled into a DynamoDB table, as an audit trail kept
+// independently of the retention of the logs.
+type actionLogNotifier struct {
+	cfg   *Config
+	table string
+	svc   dynamodbiface.DynamoDBAPI
+	now   func() time.Time
+}
+
+func newActionLogNotifier(cfg *Config, table string) *actionLogNotifier {
+	return &actionLogNotifier{cfg: cfg, table: table, now: time.Now}
+}
+
+func (l *actionLogNotifier) Notify(n Notification) error {
+	if n.Action == nil {
+		return nil
+	}
+
+	item, err := dynamodbattribute.MarshalMap(newActionLogItem(n, l.now()))
+	if err != nil {
+		return err
+	}
+
+	if l.svc == nil {
+		l.svc = dynamodb.New(newSession(l.cfg, l.cfg.MainRegion))
+	}
+
+	_, err = l.svc.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(l.table),
+		Item:      item,
+	})
+	if err != nil {
+		logger.Println("Failed to record the", n.Event, "action in the table", l.table, err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+func Test_actionLogNotifier(t *testing.T) {
+	now := time.Date(2019, 5, 1, 10, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		n        Notification
+		pierr    error
+		wantErr  bool
+		wantItem *actionLogItem
+	}{
+		{
+			name: "replacement",
+			n: Notification{
+				Event:   ReplacementEvent,
+				Subject: "AutoSpotting replaced an on-demand instance in web",
+				RunID:   "run-1",
+				Action: &ActionDetails{
+					Region:           "eu-west-1",
+					AutoScalingGroup: "web",
+					OldInstanceID:    "i-ondemand",
+					OldInstanceType:  "m5.large",
+					OldPrice:         0.107,
+					NewInstanceID:    "i-spot",
+					NewInstanceType:  "m5a.large",
+					NewPrice:         0.037,
+				},
+			},
+			wantItem: &actionLogItem{
+				Group:            "eu-west-1/web",
+				Time:             "2019-05-01T10:30:00Z",
+				Region:           "eu-west-1",
+				AutoScalingGroup: "web",
+				RunID:            "run-1",
+				Event:            ReplacementEvent,
+				Outcome:          succeededOutcome,
+				Subject:          "AutoSpotting replaced an on-demand instance in web",
+				OldInstanceID:    "i-ondemand",
+				OldInstanceType:  "m5.large",
+				OldPrice:         0.107,
+				NewInstanceID:    "i-spot",
+				NewInstanceType:  "m5a.large",
+				NewPrice:         0.037,
+			},
+		},
+		{
+			name: "launch failure in another account",
+			n: Notification{
+				Event:   LaunchFailureEvent,
+				Subject: "AutoSpotting couldn't launch a spot instance in batch",
+				Action: &ActionDetails{
+					Account:          "111111111111",
+					Region:           "us-east-1",
+					AutoScalingGroup: "batch",
+					OldInstanceID:    "i-ondemand",
+					Error:            "InsufficientInstanceCapacity",
+				},
+			},
+			wantItem: &actionLogItem{
+				Group:            "111111111111/us-east-1/batch",
+				Time:             "2019-05-01T10:30:00Z",
+				Account:          "111111111111",
+				Region:           "us-east-1",
+				AutoScalingGroup: "batch",
+				Event:            LaunchFailureEvent,
+				Outcome:          failedOutcome,
+				Subject:          "AutoSpotting couldn't launch a spot instance in batch",
+				OldInstanceID:    "i-ondemand",
+				Error:            "InsufficientInstanceCapacity",
+			},
+		},
+		{
+			name: "notification without action",
+			n:    Notification{Event: SummaryEvent, Subject: "summary"},
+		},
+		{
+			name: "failed write",
+			n: Notification{
+				Event:  InterruptionEvent,
+				Action: &ActionDetails{Region: "eu-west-1", AutoScalingGroup: "web"},
+			},
+			pierr:   errors.New("AccessDeniedException"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &mockDynamoDB{pierr: tt.pierr}
+			l := &actionLogNotifier{table: "actions", svc: svc, now: func() time.Time { return now }}
+
+			if err := l.Notify(tt.n); (err != nil) != tt.wantErr {
+				t.Fatalf("Notify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantItem == nil {
+				if !tt.wantErr && len(svc.pi) != 0 {
+					t.Errorf("Notify() wrote %d items, want none", len(svc.pi))
+				}
+				return
+			}
+
+			if len(svc.pi) != 1 || *svc.pi[0].TableName != "actions" {
+				t.Fatalf("Notify() wrote %v, want one item in the actions table", svc.pi)
+			}
+			var got actionLogItem
+			if err := dynamodbattribute.UnmarshalMap(svc.pi[0].Item, &got); err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if !reflect.DeepEqual(got, *tt.wantItem) {
+				t.Errorf("Notify() wrote %+v, want %+v", got, *tt.wantItem)
+			}
+			if _, ok := svc.pi[0].Item["account"]; ok != (tt.wantItem.Account != "") {
+				t.Errorf("Notify() wrote the empty attributes: %v", svc.pi[0].Item)
+			}
+		})
+	}
+}
+
+func Test_loadNotificationRoutes_actionLogTable(t *testing.T) {
+	routes := loadNotificationRoutes(&Config{ActionLogTable: "actions"})
+	if len(routes) != 1 {
+		t.Fatalf("loadNotificationRoutes() = %v, want the action log route", routes)
+	}
+	l, ok := routes[0].Notifier.(*actionLogNotifier)
+	if !ok || l.table != "actions" || !reflect.DeepEqual(routes[0].Events, actionEvents) {
+		t.Errorf("loadNotificationRoutes() = %+v, want the action log of the action events", routes[0])
+	}
+}
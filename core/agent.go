@@ -0,0 +1,269 @@
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// DefaultMetadataEndpoint is the address of the EC2 instance metadata service
+const DefaultMetadataEndpoint = "http://169.254.169.254"
+
+const (
+	// InterruptionNotice is reported when the spot instance is about to be
+	// interrupted
+	InterruptionNotice = "spot-interruption"
+
+	// RebalanceNotice is reported when the spot instance is at an elevated
+	// risk of interruption
+	RebalanceNotice = "rebalance-recommendation"
+
+	spotInstanceActionPath = "/latest/meta-data/spot/instance-action"
+	rebalancePath          = "/latest/meta-data/events/recommendations/rebalance"
+	instanceIDPath         = "/latest/meta-data/instance-id"
+	availabilityZonePath   = "/latest/meta-data/placement/availability-zone"
+	metadataTokenPath      = "/latest/api/token"
+)
+
+// AgentConfig stores the configuration of the agent running on the spot
+// instances.
+type AgentConfig struct {
+	// Address of the instance metadata service, only changed in tests
+	MetadataEndpoint string
+
+	// How often the instance metadata is polled for notices
+	PollInterval time.Duration
+
+	// Shell command executed locally once a notice is received, before the
+	// termination notification action, such as draining the node of a
+	// container orchestrator. The notice type and the instance ID are passed
+	// to it in the AUTOSPOTTING_NOTICE and AUTOSPOTTING_INSTANCE_ID
+	// environment variables.
+	DrainCommand string
+
+	// Also handle rebalance recommendations, not only interruption notices
+	HandleRebalance bool
+}
+
+// metadataClient queries the instance metadata service, using IMDSv2 session
+// tokens when available and falling back to IMDSv1 otherwise.
+type metadataClient struct {
+	endpoint string
+	client   *http.Client
+	token    string
+	expiry   time.Time
+}
+
+func (m *metadataClient) refreshToken() {
+	if m.token != "" && time.Now().Before(m.expiry) {
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodPut, m.endpoint+metadataTokenPath, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "21600")
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		debug.Println("Couldn't get an IMDSv2 token, falling back to IMDSv1:", err.Error())
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		debug.Println("Couldn't get an IMDSv2 token, falling back to IMDSv1:", resp.Status)
+		return
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+	m.token = string(body)
+	m.expiry = time.Now().Add(5 * time.Hour)
+}
+
+// get returns the metadata stored at the given path, and false if it isn't
+// set.
+func (m *metadataClient) get(path string) (string, bool, error) {
+	m.refreshToken()
+
+	req, err := http.NewRequest(http.MethodGet, m.endpoint+path, nil)
+	if err != nil {
+		return "", false, err
+	}
+	if m.token != "" {
+		req.Header.Set("X-aws-ec2-metadata-token", m.token)
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err == nil, err
+	case http.StatusNotFound:
+		return "", false, nil
+	case http.StatusUnauthorized:
+		// the token expired or was revoked
+		m.token = ""
+		return "", false, fmt.Errorf("unauthorized metadata request for %s", path)
+	default:
+		return "", false, fmt.Errorf("unexpected metadata response for %s: %s", path, resp.Status)
+	}
+}
+
+// agent monitors the instance metadata of the spot instance it runs on, and
+// handles the interruption notices locally.
+type agent struct {
+	conf       AgentConfig
+	cfg        *Config
+	action     string
+	metadata   *metadataClient
+	instanceID string
+	st         SpotTermination
+}
+
+// pollNotice returns the type of the notice currently reported by the
+// instance metadata, or an empty string if there is none.
+func (a *agent) pollNotice() (string, error) {
+	_, found, err := a.metadata.get(spotInstanceActionPath)
+	if err != nil {
+		return "", err
+	}
+	if found {
+		return InterruptionNotice, nil
+	}
+
+	if !a.conf.HandleRebalance {
+		return "", nil
+	}
+
+	_, found, err = a.metadata.get(rebalancePath)
+	if err != nil || !found {
+		return "", err
+	}
+	return RebalanceNotice, nil
+}
+
+// handleNotice forwards the notice to the application endpoint of the group,
+// drains the instance by running the configured local command and from its
+// Kubernetes cluster, then executes the termination notification action on
+// its group.
+func (a *agent) handleNotice(notice string) error {
+	logger.Println("Received", notice, "notice for", a.instanceID)
+
+	if a.cfg != nil {
+		defer a.st.LockGroup(a.cfg, aws.String(a.instanceID))()
+		a.st.ForwardNotice(a.cfg, aws.String(a.instanceID), notice, time.Now())
+	}
+
+	if a.conf.DrainCommand != "" {
+		logger.Println("Running drain command:", a.conf.DrainCommand)
+
+		cmd := exec.Command("/bin/sh", "-c", a.conf.DrainCommand)
+		cmd.Env = append(os.Environ(),
+			"AUTOSPOTTING_NOTICE="+notice,
+			"AUTOSPOTTING_INSTANCE_ID="+a.instanceID)
+		out, err := cmd.CombinedOutput()
+		logger.Print(string(out))
+
+		// still take the action, the instance will be interrupted anyway
+		if err != nil {
+			logger.Println("Drain command failed:", err.Error())
+		}
+	}
+
+	if a.cfg != nil {
+		a.st.DrainNode(a.cfg, aws.String(a.instanceID))
+	}
+
+	a.st.RecordCompositionChange(a.cfg, aws.String(a.instanceID))
+	err := a.st.ExecuteAction(aws.String(a.instanceID), a.action)
+
+	if a.cfg != nil && notice == InterruptionNotice {
+		a.st.RecordInterruption(a.cfg, aws.String(a.instanceID))
+	}
+	return err
+}
+
+// RunAgent runs on the spot instances themselves, polling the instance
+// metadata for spot interruption notices and optionally for rebalance
+// recommendations. Once a notice is received it runs the configured drain
+// command and executes the termination notification action, just like the
+// Lambda function would do when notified by CloudWatch Events. It returns
+// after handling the first notice.
+func RunAgent(cfg *Config, a AgentConfig) error {
+
+	setupLogging(cfg)
+
+	if a.MetadataEndpoint == "" {
+		a.MetadataEndpoint = DefaultMetadataEndpoint
+	}
+	if a.PollInterval == 0 {
+		a.PollInterval = 5 * time.Second
+	}
+
+	if cfg.FeatureEnabled(RebalanceHandlingFeature) {
+		a.HandleRebalance = true
+	}
+
+	ag := &agent{
+		conf:   a,
+		cfg:    cfg,
+		action: cfg.TerminationNotificationAction,
+		metadata: &metadataClient{
+			endpoint: strings.TrimSuffix(a.MetadataEndpoint, "/"),
+			client:   &http.Client{Timeout: 2 * time.Second},
+		},
+	}
+
+	instanceID, found, err := ag.metadata.get(instanceIDPath)
+	if err != nil || !found {
+		logger.Println("Couldn't determine the instance ID, is this running on EC2?")
+		return errors.New("instance ID not available from the instance metadata")
+	}
+	ag.instanceID = instanceID
+
+	az, found, err := ag.metadata.get(availabilityZonePath)
+	if err != nil || !found || len(az) < 2 {
+		logger.Println("Couldn't determine the availability zone of", instanceID)
+		return errors.New("availability zone not available from the instance metadata")
+	}
+	region := az[:len(az)-1]
+
+	if cfg.ClientProvider != nil {
+		ag.st = SpotTermination{
+			asSvc:  cfg.ClientProvider.AutoScaling(region),
+			ec2Svc: cfg.ClientProvider.EC2(region),
+		}
+	} else {
+		ag.st = NewSpotTermination(region)
+	}
+	ag.st.SetOwnership(cfg.Ownership())
+
+	logger.Println("Monitoring the instance metadata of", instanceID, "in", region)
+
+	for {
+		notice, err := ag.pollNotice()
+		if err != nil {
+			logger.Println("Failed to poll the instance metadata:", err.Error())
+		} else if notice != "" {
+			return ag.handleNotice(notice)
+		}
+		time.Sleep(a.PollInterval)
+	}
+}
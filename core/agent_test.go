@@ -0,0 +1,190 @@
+package autospotting
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/AutoSpotting/AutoSpotting/core/autospottingtest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// metadataServer emulates the instance metadata service, serving the given
+// paths and requiring an IMDSv2 token for all of them.
+func metadataServer(paths map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == metadataTokenPath {
+			if r.Method != http.MethodPut {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.Write([]byte("token"))
+			return
+		}
+
+		if r.Header.Get("X-aws-ec2-metadata-token") != "token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if value, ok := paths[r.URL.Path]; ok {
+			w.Write([]byte(value))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+}
+
+func Test_agent_pollNotice(t *testing.T) {
+	tests := []struct {
+		name            string
+		paths           map[string]string
+		handleRebalance bool
+		want            string
+	}{
+		{
+			name: "no notice",
+		},
+		{
+			name: "interruption notice",
+			paths: map[string]string{
+				spotInstanceActionPath: `{"action": "terminate", "time": "2019-05-01T10:00:00Z"}`,
+			},
+			want: InterruptionNotice,
+		},
+		{
+			name: "rebalance recommendation ignored",
+			paths: map[string]string{
+				rebalancePath: `{"noticeTime": "2019-05-01T10:00:00Z"}`,
+			},
+		},
+		{
+			name: "rebalance recommendation handled",
+			paths: map[string]string{
+				rebalancePath: `{"noticeTime": "2019-05-01T10:00:00Z"}`,
+			},
+			handleRebalance: true,
+			want:            RebalanceNotice,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := metadataServer(tt.paths)
+			defer srv.Close()
+
+			a := &agent{
+				conf:     AgentConfig{HandleRebalance: tt.handleRebalance},
+				metadata: &metadataClient{endpoint: srv.URL, client: srv.Client()},
+			}
+
+			got, err := a.pollNotice()
+			if err != nil {
+				t.Fatalf("pollNotice() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("pollNotice() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_metadataClient_get_IMDSv1(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == instanceIDPath {
+			w.Write([]byte("i-1"))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	m := &metadataClient{endpoint: srv.URL, client: srv.Client()}
+
+	got, found, err := m.get(instanceIDPath)
+	if err != nil || !found || got != "i-1" {
+		t.Errorf("get() = %q, %v, %v", got, found, err)
+	}
+}
+
+func TestRunAgent(t *testing.T) {
+	srv := metadataServer(map[string]string{
+		instanceIDPath:         "i-1",
+		availabilityZonePath:   "us-east-1a",
+		spotInstanceActionPath: `{"action": "terminate", "time": "2019-05-01T10:00:00Z"}`,
+	})
+	defer srv.Close()
+
+	cloud := autospottingtest.NewCloud()
+	r := cloud.Region("us-east-1")
+	r.AddInstance(&ec2.Instance{
+		InstanceId:        aws.String("i-1"),
+		InstanceType:      aws.String("m5.large"),
+		InstanceLifecycle: aws.String("spot"),
+		Tags: []*ec2.Tag{
+			{Key: aws.String("launched-for-asg"), Value: aws.String("asg")},
+		},
+	})
+	r.AddGroup(&autoscaling.Group{
+		AutoScalingGroupName: aws.String("asg"),
+		MinSize:              aws.Int64(1),
+		MaxSize:              aws.Int64(2),
+		DesiredCapacity:      aws.Int64(1),
+		Instances: []*autoscaling.Instance{{
+			InstanceId:       aws.String("i-1"),
+			AvailabilityZone: aws.String("us-east-1a"),
+		}},
+	})
+
+	dir, err := ioutil.TempDir("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	marker := filepath.Join(dir, "drained")
+
+	err = RunAgent(&Config{
+		LogFile:        ioutil.Discard,
+		ClientProvider: cloud,
+		AutoScalingConfig: AutoScalingConfig{
+			TerminationNotificationAction: AutoTerminationNotificationAction,
+		},
+	}, AgentConfig{
+		MetadataEndpoint: srv.URL,
+		PollInterval:     time.Millisecond,
+		DrainCommand:     "echo $AUTOSPOTTING_NOTICE $AUTOSPOTTING_INSTANCE_ID > " + marker,
+	})
+	if err != nil {
+		t.Fatalf("RunAgent() error = %v", err)
+	}
+
+	out, err := ioutil.ReadFile(marker)
+	if err != nil {
+		t.Fatalf("drain command wasn't executed: %v", err)
+	}
+	if got := strings.TrimSpace(string(out)); got != "spot-interruption i-1" {
+		t.Errorf("drain command got %q", got)
+	}
+
+	if g := r.Group("asg"); len(g.Instances) != 0 {
+		t.Errorf("instance wasn't detached from the group: %v", g.Instances)
+	}
+}
+
+func TestRunAgentOutsideEC2(t *testing.T) {
+	srv := metadataServer(nil)
+	defer srv.Close()
+
+	err := RunAgent(&Config{LogFile: ioutil.Discard},
+		AgentConfig{MetadataEndpoint: srv.URL})
+	if err == nil {
+		t.Error("RunAgent() expected an error without instance metadata")
+	}
+}
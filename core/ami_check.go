@@ -0,0 +1,169 @@
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// AMICheckConfig is the configuration of the AMI check.
+type AMICheckConfig struct {
+	// ID of the checked AMI
+	ImageID string
+
+	// Region of the AMI, whose instance types it's checked against
+	Region string
+
+	Output io.Writer
+}
+
+// CheckAMI checks on which instance types of its region an AMI can run, given
+// their supported architectures, virtualization types, boot modes, and their
+// need for ENA and NVMe drivers, and prints the compatible and incompatible
+// instance families. It can be used for fixing the AMIs of the groups before
+// enabling AutoSpotting on them.
+func CheckAMI(cfg AMICheckConfig) error {
+
+	if logger == nil {
+		disableLogging()
+	}
+
+	if cfg.ImageID == "" {
+		return errors.New("missing the ID of the checked AMI")
+	}
+
+	sess := session.Must(session.NewSession(&aws.Config{Region: aws.String(cfg.Region)}))
+	return checkAMI(bootModesClient{ec2.New(sess)}, cfg)
+}
+
+func checkAMI(svc bootModesAPI, cfg AMICheckConfig) error {
+	images, err := svc.DescribeImages(&describeImagesInput{ImageIds: []*string{aws.String(cfg.ImageID)}})
+	if err != nil {
+		return err
+	}
+	if len(images.Images) == 0 {
+		return fmt.Errorf("AMI %s not found in %s", cfg.ImageID, cfg.Region)
+	}
+	image := images.Images[0]
+
+	var types []*instanceTypeDescription
+	input := &describeInstanceTypesInput{}
+	for {
+		out, err := svc.DescribeInstanceTypes(input)
+		if err != nil {
+			return err
+		}
+		types = append(types, out.InstanceTypes...)
+		if aws.StringValue(out.NextToken) == "" {
+			break
+		}
+		input.NextToken = out.NextToken
+	}
+
+	writeAMICheck(cfg.Output, image, types)
+	return nil
+}
+
+// amiIncompatibility returns why the AMI can't run on the instance type, or
+// an empty string if it can.
+func amiIncompatibility(image *imageDescription, t *instanceTypeDescription) string {
+	arch := aws.StringValue(image.Architecture)
+	if t.ProcessorInfo != nil && indexOf(aws.StringValueSlice(t.ProcessorInfo.SupportedArchitectures), arch) < 0 {
+		return "only supports " + strings.Join(aws.StringValueSlice(t.ProcessorInfo.SupportedArchitectures), " and ") + " AMIs"
+	}
+
+	virtualization := aws.StringValue(image.VirtualizationType)
+	if len(t.SupportedVirtualizationTypes) > 0 &&
+		indexOf(aws.StringValueSlice(t.SupportedVirtualizationTypes), virtualization) < 0 {
+		return "doesn't support " + virtualization + " virtualization"
+	}
+
+	if t.NetworkInfo != nil && aws.StringValue(t.NetworkInfo.EnaSupport) == ec2.EnaSupportRequired &&
+		!aws.BoolValue(image.EnaSupport) {
+		return "needs ENA support"
+	}
+
+	bootMode := imageBootMode(image)
+	if len(t.SupportedBootModes) > 0 && bootMode != "uefi-preferred" &&
+		indexOf(aws.StringValueSlice(t.SupportedBootModes), bootMode) < 0 {
+		return "doesn't support " + bootMode + " boot"
+	}
+	return ""
+}
+
+// needsNVMe tells if the EBS volumes of the instance type are exposed as NVMe
+// devices, which the AMI needs drivers for.
+func needsNVMe(t *instanceTypeDescription) bool {
+	return t.EbsInfo != nil && aws.StringValue(t.EbsInfo.NvmeSupport) == ec2.EbsNvmeSupportRequired
+}
+
+// writeAMICheck prints the instance families the AMI can run on, those which
+// also need NVMe drivers, which can't be verified from the AMI metadata, and
+// the incompatible ones with the reason. A family is compatible when any of
+// its instance types is.
+func writeAMICheck(w io.Writer, image *imageDescription, types []*instanceTypeDescription) {
+	compatible := map[string]bool{}
+	nvme := map[string]bool{}
+	reasons := map[string]string{}
+
+	for _, t := range types {
+		family := strings.SplitN(aws.StringValue(t.InstanceType), ".", 2)[0]
+		if reason := amiIncompatibility(image, t); reason != "" {
+			if _, ok := reasons[family]; !ok {
+				reasons[family] = reason
+			}
+			continue
+		}
+		compatible[family] = true
+		if needsNVMe(t) {
+			nvme[family] = true
+		}
+	}
+
+	var ok, nvmeOK, incompatible []string
+	for family := range compatible {
+		if nvme[family] {
+			nvmeOK = append(nvmeOK, family)
+		} else {
+			ok = append(ok, family)
+		}
+	}
+	for family := range reasons {
+		if !compatible[family] {
+			incompatible = append(incompatible, family)
+		}
+	}
+	sort.Strings(ok)
+	sort.Strings(nvmeOK)
+	sort.Strings(incompatible)
+
+	ena := "disabled"
+	if aws.BoolValue(image.EnaSupport) {
+		ena = "enabled"
+	}
+	fmt.Fprintf(w, "AMI %s (%s): %s, %s virtualization, %s boot, ENA %s\n",
+		aws.StringValue(image.ImageID), aws.StringValue(image.Name), aws.StringValue(image.Architecture),
+		aws.StringValue(image.VirtualizationType), imageBootMode(image), ena)
+
+	fmt.Fprintf(w, "\nCompatible instance families (%d):\n", len(ok))
+	if len(ok) > 0 {
+		fmt.Fprintf(w, "  %s\n", strings.Join(ok, " "))
+	}
+
+	fmt.Fprintf(w, "\nCompatible instance families if the AMI has NVMe drivers, which can't be verified "+
+		"from its metadata (%d):\n", len(nvmeOK))
+	if len(nvmeOK) > 0 {
+		fmt.Fprintf(w, "  %s\n", strings.Join(nvmeOK, " "))
+	}
+
+	fmt.Fprintf(w, "\nIncompatible instance families (%d):\n", len(incompatible))
+	for _, family := range incompatible {
+		fmt.Fprintf(w, "  %s: %s\n", family, reasons[family])
+	}
+}
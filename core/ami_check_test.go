@@ -0,0 +1,133 @@
+package autospotting
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func testInstanceType(name, arch, ena, nvme string, bootModes ...string) *instanceTypeDescription {
+	return &instanceTypeDescription{
+		InstanceType:                 aws.String(name),
+		ProcessorInfo:                &ec2.ProcessorInfo{SupportedArchitectures: aws.StringSlice([]string{arch})},
+		NetworkInfo:                  &ec2.NetworkInfo{EnaSupport: aws.String(ena)},
+		EbsInfo:                      &ec2.EbsInfo{NvmeSupport: aws.String(nvme)},
+		SupportedVirtualizationTypes: aws.StringSlice([]string{"hvm"}),
+		SupportedBootModes:           aws.StringSlice(bootModes),
+	}
+}
+
+func TestAMIIncompatibility(t *testing.T) {
+	m4 := testInstanceType("m4.large", "x86_64", "supported", "unsupported", "legacy-bios")
+	m5 := testInstanceType("m5.large", "x86_64", "required", "required", "legacy-bios", "uefi")
+	m6g := testInstanceType("m6g.large", "arm64", "required", "required", "uefi")
+	t2 := testInstanceType("t2.micro", "x86_64", "unsupported", "unsupported")
+	t2.SupportedVirtualizationTypes = aws.StringSlice([]string{"hvm", "paravirtual"})
+
+	x86 := &imageDescription{
+		Architecture: aws.String("x86_64"), VirtualizationType: aws.String("hvm"), EnaSupport: aws.Bool(true),
+	}
+	noENA := &imageDescription{Architecture: aws.String("x86_64"), VirtualizationType: aws.String("hvm")}
+	uefi := &imageDescription{
+		Architecture: aws.String("x86_64"), VirtualizationType: aws.String("hvm"), EnaSupport: aws.Bool(true),
+		BootMode: aws.String("uefi"),
+	}
+	arm64 := &imageDescription{
+		Architecture: aws.String("arm64"), VirtualizationType: aws.String("hvm"), EnaSupport: aws.Bool(true),
+	}
+	paravirtual := &imageDescription{Architecture: aws.String("x86_64"), VirtualizationType: aws.String("paravirtual")}
+
+	tests := []struct {
+		name  string
+		image *imageDescription
+		t     *instanceTypeDescription
+		want  string
+	}{
+		{name: "compatible", image: x86, t: m5},
+		{name: "architecture", image: x86, t: m6g, want: "only supports arm64 AMIs"},
+		{name: "arm64", image: arm64, t: m6g},
+		{name: "ENA required", image: noENA, t: m5, want: "needs ENA support"},
+		{name: "ENA supported", image: noENA, t: m4},
+		{name: "UEFI", image: uefi, t: m4, want: "doesn't support uefi boot"},
+		{name: "UEFI supported", image: uefi, t: m5},
+		{name: "unknown boot modes", image: uefi, t: t2},
+		{name: "virtualization", image: paravirtual, t: m4, want: "doesn't support paravirtual virtualization"},
+		{name: "paravirtual", image: paravirtual, t: t2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := amiIncompatibility(tt.image, tt.t); got != tt.want {
+				t.Errorf("amiIncompatibility() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckAMI(t *testing.T) {
+	svc := &mockBootModes{
+		dimo: &describeImagesOutput{Images: []*imageDescription{{
+			ImageID: aws.String("ami-1"), Name: aws.String("web"), Architecture: aws.String("x86_64"),
+			VirtualizationType: aws.String("hvm"),
+		}}},
+		ditp: []*describeInstanceTypesOutput{
+			{InstanceTypes: []*instanceTypeDescription{
+				testInstanceType("m4.large", "x86_64", "supported", "unsupported", "legacy-bios"),
+				testInstanceType("m5.large", "x86_64", "required", "required", "legacy-bios", "uefi"),
+			}},
+			{InstanceTypes: []*instanceTypeDescription{
+				testInstanceType("m6g.large", "arm64", "required", "required", "uefi"),
+				testInstanceType("c5d.large", "x86_64", "supported", "required", "legacy-bios", "uefi"),
+				testInstanceType("t2.micro", "x86_64", "unsupported", "unsupported"),
+			}},
+		},
+	}
+
+	var out bytes.Buffer
+	if err := checkAMI(svc, AMICheckConfig{ImageID: "ami-1", Region: "us-east-1", Output: &out}); err != nil {
+		t.Fatalf("checkAMI() error = %v", err)
+	}
+
+	want := `AMI ami-1 (web): x86_64, hvm virtualization, legacy-bios boot, ENA disabled
+
+Compatible instance families (2):
+  m4 t2
+
+Compatible instance families if the AMI has NVMe drivers, which can't be verified from its metadata (1):
+  c5d
+
+Incompatible instance families (2):
+  m5: needs ENA support
+  m6g: only supports arm64 AMIs
+`
+	if out.String() != want {
+		t.Errorf("checkAMI() printed\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestCheckAMIErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		svc  *mockBootModes
+	}{
+		{name: "AMI not found", svc: &mockBootModes{dimo: &describeImagesOutput{}}},
+		{name: "describing the AMI failing", svc: &mockBootModes{dimerr: errors.New("InvalidAMIID.Malformed")}},
+		{
+			name: "describing the instance types failing",
+			svc: &mockBootModes{
+				dimo:   &describeImagesOutput{Images: []*imageDescription{{ImageID: aws.String("ami-1")}}},
+				diterr: errors.New("UnauthorizedOperation"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			if err := checkAMI(tt.svc, AMICheckConfig{ImageID: "ami-1", Output: &out}); err == nil {
+				t.Error("checkAMI() expected an error")
+			}
+		})
+	}
+}
@@ -0,0 +1,216 @@
+package autospotting
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+const (
+	// ARM64ArchConversion is the value of the ArchConversionTag replacing the
+	// x86_64 on-demand instances of a group with ARM64 spot instances.
+	ARM64ArchConversion = "arm64"
+
+	// MultiArchConversion is the value of the ArchConversionTag replacing the
+	// on-demand instances of a group with spot instances of either
+	// architecture, for portable workloads.
+	MultiArchConversion = "multi-arch"
+
+	// ssmImagePrefix prefixes the names of the SSM parameters giving the AMIs
+	// of an architecture, such as the public parameters of the latest Amazon
+	// Linux AMIs, the same way as in launch templates.
+	ssmImagePrefix = "resolve:ssm:"
+)
+
+// cpuArchitecture returns the architecture of the AMIs the instance types
+// with the given processor run.
+func cpuArchitecture(processor string) string {
+	if isARM(processor) {
+		return ec2.ArchitectureValuesArm64
+	}
+	return ec2.ArchitectureValuesX8664
+}
+
+// parseImageMapping parses a space or comma separated list of
+// <x86_64 AMI ID>=<arm64 AMI ID> pairs, logging and ignoring the invalid
+// ones.
+func parseImageMapping(spec string) map[string]string {
+	mapping := map[string]string{}
+	for _, entry := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ' ' }) {
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], "ami-") || !strings.HasPrefix(parts[1], "ami-") {
+			logger.Println("Ignoring invalid AMI mapping", entry)
+			continue
+		}
+		mapping[parts[0]] = parts[1]
+	}
+	return mapping
+}
+
+// conversionImages returns the AMIs of the spot instances of the other
+// architecture than the instance, by architecture, when its group is
+// converted by the ArchConversionTag. The ARM64 conversion only replaces
+// x86_64 instances, while the multi-arch conversion replaces the instances of
+// both architectures.
+func (i *instance) conversionImages() map[string]string {
+	if i.asg == nil {
+		return nil
+	}
+	conversion, key := i.asg.getFirstTagValue(ArchConversionTag, ArchConversionAliasTag)
+	if conversion == nil {
+		return nil
+	}
+
+	arch := cpuArchitecture(i.typeInfo.PhysicalProcessor)
+	switch *conversion {
+	case ARM64ArchConversion:
+		if arch != ec2.ArchitectureValuesX8664 {
+			return nil
+		}
+	case MultiArchConversion:
+	default:
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s\n", key, *conversion, i.asg.name)
+		return nil
+	}
+
+	other := ec2.ArchitectureValuesArm64
+	if arch == ec2.ArchitectureValuesArm64 {
+		other = ec2.ArchitectureValuesX8664
+	}
+	if image := i.conversionImage(other); image != "" {
+		return map[string]string{other: image}
+	}
+	return nil
+}
+
+// conversionImage returns the AMI of the given architecture the instance can
+// be replaced with, or an empty string. The AMI is given by the ARM64AMITag
+// or X8664AMITag of the group, as an AMI ID or as the name of an SSM
+// parameter, or mapped from the AMI of the instance by the ARM64AMIs
+// configuration, and needs to be built for this architecture.
+func (i *instance) conversionImage(arch string) string {
+	tag := ARM64AMITag
+	if arch == ec2.ArchitectureValuesX8664 {
+		tag = X8664AMITag
+	}
+
+	var image string
+	if value := i.asg.getTagValue(tag); value != nil {
+		image = *value
+	} else if i.region.conf != nil {
+		mapping := parseImageMapping(i.region.conf.ARM64AMIs)
+		if arch == ec2.ArchitectureValuesArm64 {
+			image = mapping[aws.StringValue(i.ImageId)]
+		}
+		for x86, arm64 := range mapping {
+			if arch == ec2.ArchitectureValuesX8664 && arm64 == aws.StringValue(i.ImageId) {
+				image = x86
+			}
+		}
+	}
+	if image == "" {
+		logger.Println(i.asg.name, "Not converting", aws.StringValue(i.InstanceId),
+			"to", arch+", no", arch, "AMI is given for", aws.StringValue(i.ImageId))
+		return ""
+	}
+
+	if strings.HasPrefix(image, ssmImagePrefix) {
+		parameter := strings.TrimPrefix(image, ssmImagePrefix)
+		var err error
+		if image, err = i.region.resolveImageParameter(parameter); err != nil {
+			logger.Println(i.asg.name, "Not converting", aws.StringValue(i.InstanceId),
+				"to", arch+", couldn't read the AMI from the SSM parameter", parameter+":", err.Error())
+			return ""
+		}
+	}
+
+	imageArch, err := i.region.imageArchitecture(image)
+	if err != nil {
+		logger.Println(i.asg.name, "Not converting", aws.StringValue(i.InstanceId),
+			"to", arch+", couldn't describe the AMI", image+":", err.Error())
+		return ""
+	}
+	if imageArch != arch {
+		logger.Println(i.asg.name, "Not converting", aws.StringValue(i.InstanceId),
+			"to", arch+", the AMI", image, "is built for", imageArch)
+		return ""
+	}
+
+	logger.Println(i.asg.name, "Converting", aws.StringValue(i.InstanceId), "to", arch, "using the AMI", image)
+	return image
+}
+
+// conversionImageOf returns the AMI of the candidate instance type when it
+// converts the instance to another architecture, or an empty string.
+func (i *instance) conversionImageOf(candidate instanceTypeInformation) string {
+	arch := cpuArchitecture(candidate.PhysicalProcessor)
+	if arch == cpuArchitecture(i.typeInfo.PhysicalProcessor) {
+		return ""
+	}
+	return i.archImages[arch]
+}
+
+// spotImage returns the AMI of the spot instance of the given type, which is
+// the conversion AMI of its architecture for the instance types converting
+// the instance to another architecture, and the AMI of the instance
+// otherwise.
+func (i *instance) spotImage(instanceType string) *string {
+	if image := i.conversionImageOf(i.region.instanceTypeInformation[instanceType]); image != "" {
+		return aws.String(image)
+	}
+	return i.ImageId
+}
+
+// resolveImageParameter returns the AMI ID stored in the SSM parameter, read
+// once per run.
+func (r *region) resolveImageParameter(name string) (string, error) {
+	r.imagesMu.Lock()
+	defer r.imagesMu.Unlock()
+
+	if image, ok := r.imageParameters[name]; ok {
+		return image, nil
+	}
+
+	out, err := r.services.ssm.GetParameter(&ssm.GetParameterInput{Name: aws.String(name)})
+	if err != nil {
+		return "", err
+	}
+
+	if r.imageParameters == nil {
+		r.imageParameters = map[string]string{}
+	}
+	image := aws.StringValue(out.Parameter.Value)
+	r.imageParameters[name] = image
+	return image, nil
+}
+
+// imageArchitecture returns the architecture of the AMI, described once per
+// run.
+func (r *region) imageArchitecture(id string) (string, error) {
+	r.imagesMu.Lock()
+	defer r.imagesMu.Unlock()
+
+	if arch, ok := r.imageArchitectures[id]; ok {
+		return arch, nil
+	}
+
+	out, err := r.services.ec2.DescribeImages(&ec2.DescribeImagesInput{
+		ImageIds: []*string{aws.String(id)},
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(out.Images) == 0 {
+		return "", fmt.Errorf("AMI %s not found", id)
+	}
+
+	if r.imageArchitectures == nil {
+		r.imageArchitectures = map[string]string{}
+	}
+	arch := aws.StringValue(out.Images[0].Architecture)
+	r.imageArchitectures[id] = arch
+	return arch, nil
+}
@@ -0,0 +1,203 @@
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestParseImageMapping(t *testing.T) {
+	got := parseImageMapping("ami-x86a=ami-arm64a, ami-x86b=ami-arm64b invalid ami-x86c=")
+	want := map[string]string{"ami-x86a": "ami-arm64a", "ami-x86b": "ami-arm64b"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseImageMapping() = %v, want %v", got, want)
+	}
+}
+
+// newARM64ConversionGroup returns a group of on-demand m4.large instances
+// running the ami-x86 AMI, tagged with the given tags, whose spot
+// replacements can also be m6g.large instances.
+func newARM64ConversionGroup(tags map[string]string, ec2Mock mockEC2) *autoScalingGroup {
+	a := newReplacementBatchGroup(1, ec2Mock)
+	a.region.conf.ARM64AMIs = "ami-x86=ami-mapped"
+	a.region.instanceTypeInformation["m6g.large"] = instanceTypeInformation{
+		instanceType: "m6g.large", vCPU: 2, memory: 8, PhysicalProcessor: "AWS Graviton2 Processor",
+		pricing: prices{spot: map[string]float64{"us-east-1a": 0.02}},
+	}
+	for k, v := range tags {
+		a.Tags = append(a.Tags, &autoscaling.TagDescription{Key: aws.String(k), Value: aws.String(v)})
+	}
+	a.instances.get("i-ondemand-0").ImageId = aws.String("ami-x86")
+	return a
+}
+
+func arm64Images(arch string) *ec2.DescribeImagesOutput {
+	return &ec2.DescribeImagesOutput{Images: []*ec2.Image{{Architecture: aws.String(arch)}}}
+}
+
+func TestConversionImages(t *testing.T) {
+	tests := []struct {
+		name      string
+		tags      map[string]string
+		instance  string
+		dimo      *ec2.DescribeImagesOutput
+		dimerr    error
+		parameter map[string]string
+		want      map[string]string
+	}{
+		{
+			name: "not converted",
+			dimo: arm64Images(ec2.ArchitectureValuesArm64),
+		},
+		{
+			name: "invalid conversion",
+			tags: map[string]string{ArchConversionTag: "riscv"},
+			dimo: arm64Images(ec2.ArchitectureValuesArm64),
+		},
+		{
+			name: "mapped AMI",
+			tags: map[string]string{ArchConversionTag: ARM64ArchConversion},
+			dimo: arm64Images(ec2.ArchitectureValuesArm64),
+			want: map[string]string{ec2.ArchitectureValuesArm64: "ami-mapped"},
+		},
+		{
+			name: "hyphenated conversion tag",
+			tags: map[string]string{ArchConversionAliasTag: ARM64ArchConversion},
+			dimo: arm64Images(ec2.ArchitectureValuesArm64),
+			want: map[string]string{ec2.ArchitectureValuesArm64: "ami-mapped"},
+		},
+		{
+			name: "AMI of the group",
+			tags: map[string]string{ArchConversionTag: ARM64ArchConversion, ARM64AMITag: "ami-group"},
+			dimo: arm64Images(ec2.ArchitectureValuesArm64),
+			want: map[string]string{ec2.ArchitectureValuesArm64: "ami-group"},
+		},
+		{
+			name: "AMI of an SSM parameter",
+			tags: map[string]string{
+				ArchConversionTag: MultiArchConversion,
+				ARM64AMITag:       "resolve:ssm:/ami/arm64",
+			},
+			dimo:      arm64Images(ec2.ArchitectureValuesArm64),
+			parameter: map[string]string{"/ami/arm64": "ami-parameter"},
+			want:      map[string]string{ec2.ArchitectureValuesArm64: "ami-parameter"},
+		},
+		{
+			name: "missing SSM parameter",
+			tags: map[string]string{
+				ArchConversionTag: MultiArchConversion,
+				ARM64AMITag:       "resolve:ssm:/ami/missing",
+			},
+			dimo: arm64Images(ec2.ArchitectureValuesArm64),
+		},
+		{
+			name:     "ARM64 instance converted to ARM64",
+			tags:     map[string]string{ArchConversionTag: ARM64ArchConversion},
+			instance: "m6g.large",
+			dimo:     arm64Images(ec2.ArchitectureValuesX8664),
+		},
+		{
+			name:     "multi-arch ARM64 instance",
+			tags:     map[string]string{ArchConversionTag: MultiArchConversion},
+			instance: "m6g.large",
+			dimo:     arm64Images(ec2.ArchitectureValuesX8664),
+			want:     map[string]string{ec2.ArchitectureValuesX8664: "ami-x86"},
+		},
+		{
+			name: "multi-arch ARM64 instance with the x86_64 AMI of the group",
+			tags: map[string]string{
+				ArchConversionTag: MultiArchConversion,
+				X8664AMITag:       "ami-group",
+			},
+			instance: "m6g.large",
+			dimo:     arm64Images(ec2.ArchitectureValuesX8664),
+			want:     map[string]string{ec2.ArchitectureValuesX8664: "ami-group"},
+		},
+		{
+			name: "x86_64 AMI",
+			tags: map[string]string{ArchConversionTag: ARM64ArchConversion},
+			dimo: arm64Images(ec2.ArchitectureValuesX8664),
+		},
+		{
+			name: "AMI not found",
+			tags: map[string]string{ArchConversionTag: ARM64ArchConversion},
+			dimo: &ec2.DescribeImagesOutput{},
+		},
+		{
+			name:   "describing the AMI failing",
+			tags:   map[string]string{ArchConversionTag: ARM64ArchConversion},
+			dimerr: errors.New("UnauthorizedOperation"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newARM64ConversionGroup(tt.tags, mockEC2{dimo: tt.dimo, dimerr: tt.dimerr})
+			a.region.services.ssm = &mockSSM{gp: tt.parameter}
+			i := a.instances.get("i-ondemand-0")
+			if tt.instance != "" {
+				i.typeInfo = a.region.instanceTypeInformation[tt.instance]
+				i.ImageId = aws.String("ami-mapped")
+			}
+			if got := i.conversionImages(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("conversionImages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("no mapping of the AMI", func(t *testing.T) {
+		a := newARM64ConversionGroup(map[string]string{ArchConversionTag: ARM64ArchConversion},
+			mockEC2{dimo: arm64Images(ec2.ArchitectureValuesArm64)})
+		a.region.conf.ARM64AMIs = "ami-other=ami-mapped"
+		if got := a.instances.get("i-ondemand-0").conversionImages(); got != nil {
+			t.Errorf("conversionImages() = %v, want none", got)
+		}
+	})
+}
+
+func TestLaunchARM64Replacement(t *testing.T) {
+	tests := []struct {
+		name      string
+		tags      map[string]string
+		wantType  string
+		wantImage string
+	}{
+		{
+			name:      "x86_64 replacement",
+			wantType:  "m5.large",
+			wantImage: "ami-x86",
+		},
+		{
+			name:      "ARM64 replacement",
+			tags:      map[string]string{ArchConversionTag: ARM64ArchConversion},
+			wantType:  "m6g.large",
+			wantImage: "ami-mapped",
+		},
+		{
+			name:      "multi-arch replacement",
+			tags:      map[string]string{ArchConversionTag: MultiArchConversion},
+			wantType:  "m6g.large",
+			wantImage: "ami-mapped",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := &runInstancesCalls{}
+			a := newARM64ConversionGroup(tt.tags,
+				mockEC2{ri: calls, dimo: arm64Images(ec2.ArchitectureValuesArm64)})
+
+			if err := a.instances.get("i-ondemand-0").launchSpotReplacement(); err != nil {
+				t.Fatalf("launchSpotReplacement() error = %v", err)
+			}
+			if want := []string{tt.wantType}; !reflect.DeepEqual(calls.instanceTypes, want) {
+				t.Errorf("launchSpotReplacement() launched %v, want %v", calls.instanceTypes, want)
+			}
+			if want := []string{tt.wantImage}; !reflect.DeepEqual(calls.images, want) {
+				t.Errorf("launchSpotReplacement() launched the AMIs %v, want %v", calls.images, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package autospotting
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+// auditModeErrorCode is the error code of the AWS API calls blocked in
+// audit mode
+const auditModeErrorCode = "AuditMode"
+
+// readOnlyOperationPrefixes are the prefixes of the names of the AWS API
+// operations which don't change anything, the only ones allowed in audit mode
+var readOnlyOperationPrefixes = []string{"Describe", "Get", "Head", "List"}
+
+// auditLog blocks the mutating AWS API calls performed in audit mode, and
+// collects them into the report of the actions AutoSpotting would have taken.
+type auditLog struct {
+	mu      sync.Mutex
+	actions []string
+}
+
+func newAuditLog() *auditLog {
+	return &auditLog{}
+}
+
+func isReadOnlyOperation(name string) bool {
+	for _, prefix := range readOnlyOperationPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAuditModeError tells if an AWS API call failed for being blocked in
+// audit mode.
+func isAuditModeError(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == auditModeErrorCode
+}
+
+// block is a request handler failing the mutating AWS API calls before they
+// are signed and sent, recording them instead.
+func (a *auditLog) block(r *request.Request) {
+	if isReadOnlyOperation(r.Operation.Name) {
+		return
+	}
+	// the dry runs only check the permissions of the calls
+	if in, ok := r.Params.(*ec2.RunInstancesInput); ok && aws.BoolValue(in.DryRun) {
+		return
+	}
+
+	action := fmt.Sprintf("%s %s:%s %s", aws.StringValue(r.Config.Region),
+		r.ClientInfo.ServiceName, r.Operation.Name,
+		strings.Join(strings.Fields(awsutil.Prettify(r.Params)), " "))
+
+	a.mu.Lock()
+	a.actions = append(a.actions, action)
+	a.mu.Unlock()
+
+	logger.Println("Audit mode, not calling", action)
+	r.Error = awserr.New(auditModeErrorCode,
+		fmt.Sprintf("%s:%s blocked in audit mode", r.ClientInfo.ServiceName, r.Operation.Name), nil)
+}
+
+// install makes the clients created from the session use the audit log,
+// nothing is done when the audit mode is disabled.
+func (a *auditLog) install(sess *session.Session) *session.Session {
+	if a != nil {
+		sess.Handlers.Validate.PushBack(a.block)
+	}
+	return sess
+}
+
+func (a *auditLog) list() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string(nil), a.actions...)
+}
+
+// report logs the actions blocked during the run.
+func (a *auditLog) report() {
+	actions := a.list()
+	logger.Printf("Audit mode report, %d actions would have been taken during this run\n", len(actions))
+	for _, action := range actions {
+		logger.Println("\t", action)
+	}
+}
+
+// newSession creates a session to the AWS APIs of the given region, using the
+// credential profile or the cross-account role being processed, whose
+// mutating calls are blocked when running in audit mode.
+func newSession(cfg *Config, region string) *session.Session {
+	return traceAPICalls(logAPICalls(cfg.audit.install(session.Must(newAccountSession(cfg.profile, cfg.credentials, region)))))
+}
+
+// newBucketSession creates a session like newSession does, in the region of
+// the given S3 bucket, or in the given region when the region of the bucket
+// can't be determined.
+func newBucketSession(cfg *Config, bucket, region string) *session.Session {
+	sess := newSession(cfg, region)
+	if bucketRegion, err := s3manager.GetBucketRegion(aws.BackgroundContext(), sess, bucket, region); err == nil && bucketRegion != region {
+		sess = newSession(cfg, bucketRegion)
+	}
+	return sess
+}
@@ -0,0 +1,103 @@
+package autospotting
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestIsReadOnlyOperation(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{name: "DescribeInstances", want: true},
+		{name: "GetServiceQuota", want: true},
+		{name: "ListTagsForResource", want: true},
+		{name: "HeadBucket", want: true},
+		{name: "RunInstances", want: false},
+		{name: "CreateOrUpdateTags", want: false},
+		{name: "ReceiveMessage", want: false},
+	}
+	for _, tt := range tests {
+		if got := isReadOnlyOperation(tt.name); got != tt.want {
+			t.Errorf("isReadOnlyOperation(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	audit := newAuditLog()
+	sess := audit.install(session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})))
+
+	// the mutating calls fail before being sent
+	_, err := ec2.New(sess).TerminateInstances(&ec2.TerminateInstancesInput{
+		InstanceIds: []*string{aws.String("i-0123456789")},
+	})
+	if !isAuditModeError(err) {
+		t.Errorf("TerminateInstances error = %v, expected it to be blocked", err)
+	}
+	_, err = autoscaling.New(sess).CreateOrUpdateTags(&autoscaling.CreateOrUpdateTagsInput{
+		Tags: []*autoscaling.Tag{{
+			ResourceId:        aws.String("asg"),
+			ResourceType:      aws.String("auto-scaling-group"),
+			Key:               aws.String("key"),
+			Value:             aws.String("value"),
+			PropagateAtLaunch: aws.Bool(false),
+		}},
+	})
+	if !isAuditModeError(err) {
+		t.Errorf("CreateOrUpdateTags error = %v, expected it to be blocked", err)
+	}
+
+	// the read-only calls are let through
+	req, _ := ec2.New(sess).DescribeInstancesRequest(&ec2.DescribeInstancesInput{})
+	if err := req.Build(); err != nil {
+		t.Errorf("DescribeInstances was blocked: %v", err)
+	}
+	req, _ = ec2.New(sess).RunInstancesRequest(&ec2.RunInstancesInput{
+		DryRun:       aws.Bool(true),
+		ImageId:      aws.String("ami-0123456789"),
+		InstanceType: aws.String("m5.large"),
+		MaxCount:     aws.Int64(1),
+		MinCount:     aws.Int64(1),
+	})
+	if err := req.Build(); err != nil {
+		t.Errorf("the RunInstances dry run was blocked: %v", err)
+	}
+
+	actions := audit.list()
+	if len(actions) != 2 {
+		t.Fatalf("recorded %d actions, want 2: %v", len(actions), actions)
+	}
+	if want := "us-east-1 ec2:TerminateInstances { InstanceIds: [\"i-0123456789\"] }"; actions[0] != want {
+		t.Errorf("recorded %q, want %q", actions[0], want)
+	}
+
+	if isAuditModeError(errors.New(auditModeErrorCode)) {
+		t.Errorf("isAuditModeError() matched an error not returned by the AWS SDK")
+	}
+}
+
+func TestAuditLogDisabled(t *testing.T) {
+	var audit *auditLog
+	sess := audit.install(session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("id", "secret", ""),
+	})))
+
+	req, _ := ec2.New(sess).TerminateInstancesRequest(&ec2.TerminateInstancesInput{
+		InstanceIds: []*string{aws.String("i-0123456789")},
+	})
+	if err := req.Build(); err != nil {
+		t.Errorf("TerminateInstances was blocked outside of audit mode: %v", err)
+	}
+}
@@ -16,9 +16,22 @@ type autoScalingGroup struct {
 	name                string
 	region              *region
 	launchConfiguration *launchConfiguration
+	launchTemplate      *launchTemplate
 	instances           instances
 	minOnDemand         int64
 	config              AutoScalingConfig
+
+	// set when the replacement was explicitly requested, in which case the
+	// cron schedule is not enforced
+	ignoreSchedule bool
+
+	// availability zones of the subnets used for the spot instances, loaded
+	// on demand
+	subnetZones map[string]string
+
+	// whether the spot instances can be launched using a cheaper instance
+	// type than the current one, decided once per run
+	priceSwapAllowed *bool
 }
 
 func (a *autoScalingGroup) loadLaunchConfiguration() error {
@@ -87,48 +100,98 @@ func (a *autoScalingGroup) allInstanceRunning() bool {
 	return totalRunning == a.instances.count64()
 }
 
-func (a *autoScalingGroup) process() {
+// process takes the replacement actions needed by the group on this run, and
+// returns an error if any of them failed.
+func (a *autoScalingGroup) process() (err error) {
+	defer func() { a.region.conf.metrics.groupProcessed(err) }()
+
+	unlock, locked := a.lock()
+	if !locked {
+		return nil
+	}
+	defer unlock()
+
 	var spotInstanceID string
 	a.scanInstances()
 	a.loadDefaultConfig()
 	a.loadConfigFromTags()
+	a.escalateOnDemand(time.Now())
+	a.recordComposition()
+
+	if a.recoverReplacements() {
+		logger.Println(a.region.name, a.name,
+			"Recovered interrupted replacements, leaving the rest to the next run")
+		return nil
+	}
+
+	if a.isReverting() {
+		return a.revertSpotInstances()
+	}
 
 	logger.Println("Finding spot instances created for", a.name)
 
 	spotInstance := a.findUnattachedInstanceLaunchedForThisASG()
 	debug.Println("Candidate Spot instance", spotInstance)
 
-	shouldRun := cronRunAction(time.Now(), a.config.CronSchedule, a.config.CronScheduleState)
+	shouldRun := a.ignoreSchedule ||
+		cronRunAction(time.Now(), a.config.CronSchedule, a.config.CronTimezone, a.config.CronScheduleState)
 	debug.Println(a.region.name, a.name, "Should take replacemnt actions:", shouldRun)
 
+	if a.config.ConversionMode == MIPConversionMode {
+		if !shouldRun {
+			logger.Println(a.region.name, a.name, "Outside the cron schedule, not converting to a Mixed Instances Policy")
+			return nil
+		}
+		return a.reconcileMixedInstancesPolicy()
+	}
+
 	if spotInstance == nil {
 		logger.Println("No spot instances were found for ", a.name)
 
-		onDemandInstance := a.getAnyUnprotectedOnDemandInstance()
+		onDemandInstance := a.onDemandInstanceToReplace()
 
 		if onDemandInstance == nil {
 			logger.Println(a.region.name, a.name,
 				"No running unprotected on-demand instances were found, nothing to do here...")
-			return
+			return nil
 		}
 
 		if !a.needReplaceOnDemandInstances() {
 			logger.Println("Not allowed to replace any of the running OD instances in ", a.name)
-			return
+			return nil
+		}
+
+		if reason, covered := a.isCoveredByReservations(); covered {
+			logger.Println(a.region.name, a.name, "Skipping group, already cheaper than spot:", reason)
+			return nil
 		}
 
 		if !shouldRun {
 			logger.Println(a.region.name, a.name,
 				"Skipping run, outside the enabled cron run schedule")
-			return
+			return nil
 		}
 
-		a.loadLaunchConfiguration()
-		err := onDemandInstance.launchSpotReplacement()
+		if a.replacementAllowance() == 0 {
+			logger.Println(a.region.name, a.name,
+				"Skipping group, reached the maximum number of replacements of this run or hour")
+			return nil
+		}
+
+		if !a.partitionsHealthy() {
+			return nil
+		}
+
+		if a.launchTemplateSpecification() != nil {
+			a.loadLaunchTemplate()
+		} else {
+			a.loadLaunchConfiguration()
+		}
+		err := a.launchSpotReplacements(a.onDemandInstancesToReplace(onDemandInstance))
 		if err != nil {
 			logger.Printf("Could not launch cheapest spot instance: %s", err)
 		}
-		return
+		return err
 	}
 
 	spotInstanceID = *spotInstance.InstanceId
@@ -137,18 +200,29 @@ func (a *autoScalingGroup) process() {
 		logger.Println("Spot instance", spotInstanceID, "is not need anymore by ASG",
 			a.name, "terminating the spot instance.")
 		spotInstance.terminate()
-		return
+		return nil
 	}
-	if !spotInstance.isReadyToAttach(a) {
+	if !spotInstance.isReadyToAttach(a) || !spotInstance.passesReadinessChecks(a) {
 		logger.Println("Waiting for next run while processing", a.name)
-		return
+		return nil
+	}
+
+	if !a.partitionsHealthy() {
+		logger.Println(a.region.name, a.name, "Leaving spot instance", spotInstanceID,
+			"for the next run, waiting for the partitions of the group to be healthy")
+		return nil
+	}
+
+	if a.replacementAllowance() == 0 || !a.region.conf.replacements.take() {
+		logger.Println(a.region.name, a.name, "Leaving spot instance", spotInstanceID,
+			"for the next run, reached the maximum number of replacements of this run or hour")
+		return nil
 	}
 
 	logger.Println(a.region.name, "Found spot instance:", spotInstanceID,
 		"Attaching it to", a.name)
 
-	a.replaceOnDemandInstanceWithSpot(spotInstanceID)
-
+	return a.replaceOnDemandInstanceWithSpot(spotInstanceID)
 }
 
 func (a *autoScalingGroup) scanInstances() instances {
@@ -187,13 +261,6 @@ func (a *autoScalingGroup) replaceOnDemandInstanceWithSpot(
 	minSize, maxSize := *a.MinSize, *a.MaxSize
 	desiredCapacity := *a.DesiredCapacity
 
-	// temporarily increase AutoScaling group in case it's of static size
-	if minSize == maxSize {
-		logger.Println(a.name, "Temporarily increasing MaxSize")
-		a.setAutoScalingMaxSize(maxSize + 1)
-		defer a.setAutoScalingMaxSize(maxSize)
-	}
-
 	// get the details of our spot instance so we can see its AZ
 	logger.Println(a.name, "Retrieving instance details for ", spotInstanceID)
 	spotInst := a.region.instances.get(spotInstanceID)
@@ -205,7 +272,7 @@ func (a *autoScalingGroup) replaceOnDemandInstanceWithSpot(
 	logger.Println(a.name, spotInstanceID, "is in the availability zone",
 		*az, "looking for an on-demand instance there")
 
-	odInst := a.getUnprotectedOnDemandInstanceInAZ(az)
+	odInst := a.onDemandInstanceReplacedBy(spotInst)
 
 	if odInst == nil {
 		logger.Println(a.name, "found no on-demand instances that could be",
@@ -216,8 +283,76 @@ func (a *autoScalingGroup) replaceOnDemandInstanceWithSpot(
 	}
 	logger.Println(a.name, "found on-demand instance", *odInst.InstanceId,
 		"replacing with new spot instance", *spotInst.InstanceId)
+
+	if err := a.handOverStatefulVolumes(odInst, spotInst); err != nil {
+		return err
+	}
+
+	// the group has to stay within its MinSize and MaxSize while the spot
+	// instance is attached and the on-demand instance is detached, so the
+	// spot instance is attached first only when the group is at its minimum
+	// capacity, raising its MaxSize if it's also at its maximum capacity,
+	// unless the group should be detached from first, lowering its MinSize
+	detachFirst := desiredCapacity > minSize || a.config.MaxSizeStrategy == DetachFirstStrategy
+
+	var originalMaxSize, originalMinSize *int64
+	if !detachFirst && desiredCapacity >= maxSize {
+		originalMaxSize = aws.Int64(maxSize)
+	}
+	if detachFirst && desiredCapacity <= minSize {
+		originalMinSize = aws.Int64(minSize)
+	}
+
+	// record the replacement before changing anything, so that the next run
+	// can finish it in case we get interrupted
+	if err := a.beginReplacement(spotInst, odInst, originalMaxSize, originalMinSize); err != nil {
+		return err
+	}
+
+	// the record is only cleared once all the deferred steps below succeeded,
+	// otherwise the next run completes or rolls back the replacement
+	completed := false
+	defer func() {
+		if completed {
+			a.endReplacement(spotInst)
+		}
+	}()
+
+	// runs after all the deferred steps below, once the group settled
+	defer func() {
+		if completed {
+			a.checkCapacityDrift(desiredCapacity)
+		}
+	}()
+
+	// temporarily increase AutoScaling group in case it's at its maximum size
+	if originalMaxSize != nil {
+		logger.Println(a.name, "Temporarily increasing MaxSize")
+		if err := a.setAutoScalingMaxSize(maxSize + 1); err != nil {
+			return err
+		}
+		defer func() {
+			if a.setAutoScalingMaxSize(maxSize) != nil {
+				completed = false
+			}
+		}()
+	}
+
+	// temporarily decrease AutoScaling group in case it's at its minimum size
+	if originalMinSize != nil {
+		logger.Println(a.name, "Temporarily decreasing MinSize")
+		if err := a.setAutoScalingMinSize(minSize - 1); err != nil {
+			return err
+		}
+		defer func() {
+			if a.setAutoScalingMinSize(minSize) != nil {
+				completed = false
+			}
+		}()
+	}
+
 	// revert attach/detach order when running on minimum capacity
-	if desiredCapacity == minSize {
+	if !detachFirst {
 		attachErr := a.attachSpotInstance(spotInstanceID)
 		if attachErr != nil {
 			logger.Println(a.name, "skipping detaching on-demand due to failure to",
@@ -225,15 +360,52 @@ func (a *autoScalingGroup) replaceOnDemandInstanceWithSpot(
 			return nil
 		}
 	} else {
-		defer a.attachSpotInstance(spotInstanceID)
+		defer func() {
+			if a.attachSpotInstance(spotInstanceID) != nil {
+				completed = false
+			}
+		}()
 	}
 
+	drainKubernetesNode(a.region.services.session, a.region.conf, odInst.Instance)
+	drainECSContainerInstance(a.region.services.session, a.region.conf, odInst.Instance)
+
+	var err error
 	switch a.config.TerminationMethod {
 	case DetachTerminationMethod:
-		return a.detachAndTerminateOnDemandInstance(odInst.InstanceId)
+		err = a.detachAndTerminateOnDemandInstance(odInst.InstanceId)
 	default:
-		return a.terminateInstanceInAutoScalingGroup(odInst.InstanceId)
-	}
+		err = a.terminateInstanceInAutoScalingGroup(odInst.InstanceId)
+	}
+	completed = err == nil
+
+	if err == nil {
+		a.recordCompositionChange(time.Now())
+		a.region.conf.changes.record(change{
+			Account:              a.region.conf.accountID,
+			Region:               a.region.name,
+			AutoScalingGroup:     a.name,
+			OnDemandInstanceID:   *odInst.InstanceId,
+			OnDemandInstanceType: *odInst.InstanceType,
+			SpotInstanceID:       spotInstanceID,
+			SpotInstanceType:     *spotInst.InstanceType,
+			HourlySavings:        odInst.price - spotInst.typeInfo.pricing.spot[*az],
+			Time:                 time.Now(),
+		})
+		notifyAction(a.region.conf, ReplacementEvent, "AutoSpotting replaced an on-demand instance in "+a.name,
+			ActionDetails{
+				Account:          a.region.conf.accountID,
+				Region:           a.region.name,
+				AutoScalingGroup: a.name,
+				OldInstanceID:    *odInst.InstanceId,
+				OldInstanceType:  *odInst.InstanceType,
+				OldPrice:         odInst.price,
+				NewInstanceID:    spotInstanceID,
+				NewInstanceType:  *spotInst.InstanceType,
+				NewPrice:         spotInst.typeInfo.pricing.spot[*az],
+			})
+	}
+	return err
 }
 
 // Returns the information about the first running instance found in
@@ -269,12 +441,27 @@ func (a *autoScalingGroup) getInstance(
 					"placed in a different AZ than what we're looking for")
 				continue
 			}
+
+			if onDemand && considerInstanceProtection && i.isYoungerThan(a.minInstanceUptime()) {
+				logger.Println(a.name, "skipping instance", *i.InstanceId,
+					"running for less than the minimum uptime of", a.minInstanceUptime())
+				continue
+			}
 			return i
 		}
 	}
 	return nil
 }
 
+// minInstanceUptime returns how long the on-demand instances have to be
+// running before being replaced.
+func (a *autoScalingGroup) minInstanceUptime() time.Duration {
+	if a.region == nil || a.region.conf == nil {
+		return 0
+	}
+	return a.region.conf.MinInstanceUptime
+}
+
 func (a *autoScalingGroup) getUnprotectedOnDemandInstanceInAZ(az *string) *instance {
 	return a.getInstance(az, true, true)
 }
@@ -300,65 +487,55 @@ func (a *autoScalingGroup) hasMemberInstance(inst *instance) bool {
 }
 
 func (a *autoScalingGroup) findUnattachedInstanceLaunchedForThisASG() *instance {
+	ownership := a.region.conf.Ownership()
 	for inst := range a.region.instances.instances() {
-		for _, tag := range inst.Tags {
-			if *tag.Key == "launched-for-asg" && *tag.Value == a.name {
-				if !a.hasMemberInstance(inst) {
-					return inst
-				}
-			}
+		if inst.ownerGroup(ownership) == a.name && !a.hasMemberInstance(inst) {
+			return inst
 		}
 	}
 	return nil
 }
 
+// getAllowedInstanceTypes returns the instance types allowed in the group,
+// given by its AllowedInstanceTypesTag or its hyphenated alias, which
+// override the global configuration.
 func (a *autoScalingGroup) getAllowedInstanceTypes(baseInstance *instance) []string {
-	var allowedInstanceTypesTag string
-
 	// By default take the command line parameter
-	allowed := strings.Replace(a.region.conf.AllowedInstanceTypes, " ", ",", -1)
-
-	// Check option of allowed instance types
-	// If we have that option we don't need to calculate the compatible instance type.
-	if tagValue := a.getTagValue(AllowedInstanceTypesTag); tagValue != nil {
-		allowedInstanceTypesTag = strings.Replace(*tagValue, " ", ",", -1)
-	}
+	allowed := a.region.conf.AllowedInstanceTypes
 
 	// ASG Tag config has a priority to override
-	if allowedInstanceTypesTag != "" {
-		allowed = allowedInstanceTypesTag
+	if tagValue, _ := a.getFirstTagValue(AllowedInstanceTypesTag, AllowedInstanceTypesAliasTag); tagValue != nil && *tagValue != "" {
+		allowed = *tagValue
 	}
 
-	if allowed == "current" {
-		return []string{baseInstance.typeInfo.instanceType}
-	}
-
-	// Simple trick to avoid returning list with empty elements
-	return strings.FieldsFunc(allowed, func(c rune) bool {
-		return c == ','
-	})
+	return instanceTypeList(allowed, baseInstance)
 }
 
-func (a *autoScalingGroup) getDisallowedInstanceTypes(baseInstance *instance) []string {
-	var disallowedInstanceTypesTag string
-
+// getDisallowedInstanceTypes returns the instance types disallowed in the
+// group, given by its DisallowedInstanceTypesTag or its hyphenated alias,
+// which override the global configuration.
+func (a *autoScalingGroup) getDisallowedInstanceTypes() []string {
 	// By default take the command line parameter
-	disallowed := strings.Replace(a.region.conf.DisallowedInstanceTypes, " ", ",", -1)
+	disallowed := a.region.conf.DisallowedInstanceTypes
 
-	// Check option of disallowed instance types
-	// If we have that option we don't need to calculate the compatible instance type.
-	if tagValue := a.getTagValue(DisallowedInstanceTypesTag); tagValue != nil {
-		disallowedInstanceTypesTag = strings.Replace(*tagValue, " ", ",", -1)
+	// ASG Tag config has a priority to override
+	if tagValue, _ := a.getFirstTagValue(DisallowedInstanceTypesTag, DisallowedInstanceTypesAliasTag); tagValue != nil && *tagValue != "" {
+		disallowed = *tagValue
 	}
 
-	// ASG Tag config has a priority to override
-	if disallowedInstanceTypesTag != "" {
-		disallowed = disallowedInstanceTypesTag
+	return instanceTypeList(disallowed, nil)
+}
+
+// instanceTypeList splits a comma or whitespace separated list of instance
+// types, where "current" stands for the type of the base instance, if given.
+func instanceTypeList(list string, baseInstance *instance) []string {
+	if list == "current" && baseInstance != nil {
+		return []string{baseInstance.typeInfo.instanceType}
 	}
 
 	// Simple trick to avoid returning list with empty elements
-	return strings.FieldsFunc(disallowed, func(c rune) bool {
-		return c == ','
+	return strings.FieldsFunc(list, func(c rune) bool {
+		return c == ',' || c == ' '
 	})
 }
 
@@ -380,6 +557,22 @@ func (a *autoScalingGroup) setAutoScalingMaxSize(maxSize int64) error {
 	return nil
 }
 
+func (a *autoScalingGroup) setAutoScalingMinSize(minSize int64) error {
+	svc := a.region.services.autoScaling
+
+	_, err := svc.UpdateAutoScalingGroup(
+		&autoscaling.UpdateAutoScalingGroupInput{
+			AutoScalingGroupName: aws.String(a.name),
+			MinSize:              aws.Int64(minSize),
+		})
+
+	if err != nil {
+		logger.Println(err.Error())
+		return err
+	}
+	return nil
+}
+
 func (a *autoScalingGroup) attachSpotInstance(spotInstanceID string) error {
 
 	svc := a.region.services.autoScaling
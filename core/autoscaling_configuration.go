@@ -3,6 +3,7 @@ package autospotting
 import (
 	"math"
 	"strconv"
+	"time"
 )
 
 const (
@@ -26,20 +27,160 @@ const (
 	// BiddingPolicyTag stores the bidding policy for the spot instance
 	BiddingPolicyTag = "autospotting_bidding_policy"
 
+	// BiddingPolicyAliasTag is the hyphenated alias of the BiddingPolicyTag
+	BiddingPolicyAliasTag = "autospotting-bidding-policy"
+
 	// SpotPriceBufferPercentageTag stores percentage value above the
 	// current spot price to place the bid
 	SpotPriceBufferPercentageTag = "autospotting_spot_price_buffer_percentage"
 
+	// OnDemandPriceMultiplierTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the OnDemandPriceMultiplier
+	// parameter
+	OnDemandPriceMultiplierTag = "autospotting_on_demand_price_multiplier"
+
+	// OnDemandPriceMultiplierAliasTag is the hyphenated alias of the
+	// OnDemandPriceMultiplierTag
+	OnDemandPriceMultiplierAliasTag = "autospotting-on-demand-price-multiplier"
+
 	// AllowedInstanceTypesTag is the name of a tag that can indicate which
 	// instance types are allowed in the current group
 	AllowedInstanceTypesTag = "autospotting_allowed_instance_types"
 
+	// AllowedInstanceTypesAliasTag is the hyphenated alias of the
+	// AllowedInstanceTypesTag
+	AllowedInstanceTypesAliasTag = "autospotting-allowed-instance-types"
+
 	// DisallowedInstanceTypesTag is the name of a tag that can indicate which
 	// instance types are not allowed in the current group
 	DisallowedInstanceTypesTag = "autospotting_disallowed_instance_types"
 
+	// DisallowedInstanceTypesAliasTag is the hyphenated alias of the
+	// DisallowedInstanceTypesTag
+	DisallowedInstanceTypesAliasTag = "autospotting-disallowed-instance-types"
+
+	// SnoozeUntilTag is the name of a tag that suspends all the actions taken
+	// on a group until the given RFC3339 timestamp, such as
+	// 2019-07-01T00:00:00Z, after which the group is processed again.
+	SnoozeUntilTag = "autospotting-snooze-until"
+
+	// RevertingTag is the name of the tag set by the revert command on the
+	// groups whose spot instances are being gradually replaced by on-demand
+	// instances, removed once the group has no spot instances left.
+	RevertingTag = "autospotting-reverting"
+
+	// RegionFailoverTag is the name of the tag marking a group as
+	// region-flexible, giving the region where its replacement capacity can
+	// be provisioned when its own region runs out of spot capacity.
+	RegionFailoverTag = "autospotting_failover_region"
+
+	// InterruptionsTag is the name of the tag where AutoSpotting records the
+	// times of the recent spot interruptions of a group, when the
+	// InterruptionThreshold is set.
+	InterruptionsTag = "autospotting-interruptions"
+
+	// InterruptionCooldownUntilTag is the name of the tag set by AutoSpotting
+	// on a group which got too many spot interruptions, keeping its on-demand
+	// capacity until the given RFC3339 timestamp.
+	InterruptionCooldownUntilTag = "autospotting-interruption-cooldown-until"
+
+	// CompositionChangesTag is the name of the tag where AutoSpotting records
+	// the times of the recent replacements and spot interruptions of a group,
+	// when its MaxChangePercentagePerHour is set.
+	CompositionChangesTag = "autospotting-composition-changes"
+
+	// PriorityTag is the name of a tag giving the processing priority of a
+	// group as an integer, the groups with higher priorities are processed
+	// first. Defaults to 0.
+	PriorityTag = "autospotting_priority"
+
+	// ReadinessURLTag is the name of a tag giving an HTTP(S) URL polled on
+	// the new spot instances before they replace on-demand instances, such as
+	// http://localhost:8080/health. The host is replaced with the private IP
+	// address of the spot instance.
+	ReadinessURLTag = "autospotting_readiness_url"
+
+	// ReadinessStatusTag is the name of a tag giving the HTTP status code
+	// expected from the readiness URL. Defaults to 200.
+	ReadinessStatusTag = "autospotting_readiness_status"
+
+	// ReadinessDocumentTag is the name of a tag giving an SSM document run
+	// on the new spot instances before they replace on-demand instances, which
+	// only happens once the command succeeded on the instance.
+	ReadinessDocumentTag = "autospotting_readiness_document"
+
+	// ReadinessDocumentParametersTag is the name of a tag giving the
+	// parameters of the readiness document as a JSON object, such as
+	// {"commands":["/opt/app/check.sh"]} for AWS-RunShellScript.
+	ReadinessDocumentParametersTag = "autospotting_readiness_document_parameters"
+
+	// StatefulVolumesTag is the name of a tag giving a comma-separated list of
+	// device names, such as /dev/sdf,/dev/sdg, of the non-root EBS volumes
+	// moved from the replaced on-demand instances to their spot replacements.
+	StatefulVolumesTag = "autospotting_stateful_volumes"
+
+	// PartitionTag is the name of a tag giving the key of the instance tag,
+	// such as cluster-role or partition, mapping the instances of a group to
+	// partitions of which at most one instance is replaced at a time, for
+	// quorum-based clustered workloads.
+	PartitionTag = "autospotting_partition_tag"
+
+	// SpotSubnetsTag is the name of a tag giving a comma-separated list of
+	// subnet IDs the spot instances are launched in, instead of the subnets
+	// of the group, such as to keep them out of subnets reserved for
+	// on-demand capacity.
+	SpotSubnetsTag = "autospotting_spot_subnets"
+
+	// SecurityGroupsTag is the name of a tag giving a comma-separated list of
+	// security group IDs used for the spot instances instead of those of the
+	// replaced on-demand instances.
+	SecurityGroupsTag = "autospotting_security_groups"
+
+	// AdditionalSecurityGroupsTag is the name of a tag giving a
+	// comma-separated list of security group IDs added to the spot instances,
+	// such as to allow the traffic of an interruption draining agent.
+	AdditionalSecurityGroupsTag = "autospotting_additional_security_groups"
+
+	// CPUCoreCountTag is the name of a tag giving the number of CPU cores of
+	// the spot instances, such as for workloads licensed per core.
+	CPUCoreCountTag = "autospotting_cpu_core_count"
+
+	// CPUThreadsPerCoreTag is the name of a tag giving the number of threads
+	// per CPU core of the spot instances, 1 disabling hyperthreading.
+	CPUThreadsPerCoreTag = "autospotting_cpu_threads_per_core"
+
+	// ArchConversionTag is the name of a tag set to arm64 for replacing the
+	// x86_64 on-demand instances of a group with ARM64 spot instances, or to
+	// multi-arch for replacing the on-demand instances with spot instances of
+	// either architecture, when an AMI is given for the other architecture.
+	ArchConversionTag = "autospotting_arch_conversion"
+
+	// ArchConversionAliasTag is the hyphenated alias of the ArchConversionTag
+	ArchConversionAliasTag = "autospotting-arch-conversion"
+
+	// ARM64AMITag is the name of a tag giving the arm64 AMI of the ARM64 spot
+	// instances of a group converted by its ArchConversionTag, instead of the
+	// one mapped from the AMI of the on-demand instances.
+	ARM64AMITag = "autospotting_arm64_ami"
+
+	// X8664AMITag is the name of a tag giving the x86_64 AMI of the x86_64
+	// spot instances replacing the ARM64 on-demand instances of a multi-arch
+	// group, instead of the one mapped from the AMI of the on-demand
+	// instances.
+	X8664AMITag = "autospotting_x86_64_ami"
+
+	// ReservedTag is the name of a tag overriding the detection of the groups
+	// fully covered by reservations, when set to true the group is considered
+	// covered, such as by a dedicated Savings Plan commitment, and when set
+	// to false its reserved instances are ignored.
+	ReservedTag = "autospotting_reserved"
+
 	// Default constant values should be defined below:
 
+	// DefaultDeploymentFreezeTag is the default key of the tag set by CI/CD
+	// systems while deploying a group
+	DefaultDeploymentFreezeTag = "deployment-in-progress"
+
 	// DefaultSpotProductDescription stores the default operating system
 	// to use when looking up spot price history in the market.
 	DefaultSpotProductDescription = "Linux/UNIX (Amazon VPC)"
@@ -60,18 +201,125 @@ const (
 	// method configuration option
 	DefaultInstanceTerminationMethod = AutoScalingTerminationMethod
 
+	// DefaultSubnetSelection is the default value for the subnet selection
+	// configuration option
+	DefaultSubnetSelection = SameSubnetSelection
+
+	// SubnetSelectionTag is the name of the tag set on the AutoScaling Group
+	// that can override the global value of the SubnetSelection parameter
+	SubnetSelectionTag = "autospotting_subnet_selection"
+
+	// DefaultSpotRequestType is the default value for the spot request type
+	// configuration option
+	DefaultSpotRequestType = OneTimeSpotRequestType
+
+	// SpotRequestTypeTag is the name of the tag set on the AutoScaling Group
+	// that can override the global value of the SpotRequestType parameter
+	SpotRequestTypeTag = "autospotting_spot_request_type"
+
+	// DefaultConversionMode is the default value for the conversion mode
+	// configuration option
+	DefaultConversionMode = ReplaceConversionMode
+
+	// ConversionModeTag is the name of the tag set on the AutoScaling Group
+	// that can override the global value of the ConversionMode parameter
+	ConversionModeTag = "autospotting_conversion_mode"
+
+	// KeyPairTag is the name of the tag set on the AutoScaling Group that can
+	// override the global value of the KeyPair parameter
+	KeyPairTag = "autospotting_key_pair"
+
+	// InterruptionNoticeEndpointTag is the name of the tag set on the
+	// AutoScaling Group that can override the global value of the
+	// InterruptionNoticeEndpoint parameter
+	InterruptionNoticeEndpointTag = "autospotting_interruption_notice_endpoint"
+
 	// ScheduleTag is the name of the tag set on the AutoScaling Group that
 	// can override the global value of the Schedule parameter
 	ScheduleTag = "autospotting_cron_schedule"
 
+	// CronScheduleAliasTag is the hyphenated alias of the ScheduleTag, matching
+	// the naming of the newer tags
+	CronScheduleAliasTag = "autospotting-cron-schedule"
+
 	// CronScheduleState controls whether to run or not to run during the time interval
 	// specified in the Schedule variable or its per-group tag overrides. It
 	// accepts "on|off" as valid values
 	CronScheduleState = "on"
 
+	// WaitForSSMTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the WaitForSSM parameter
+	WaitForSSMTag = "autospotting_wait_for_ssm"
+
+	// WaitForCloudInitTag is the name of the tag set on the AutoScaling Group
+	// that can override the global value of the WaitForCloudInit parameter
+	WaitForCloudInitTag = "autospotting_wait_for_cloud_init"
+
+	// MinSavingsPercentageTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the MinSavingsPercentage
+	// parameter
+	MinSavingsPercentageTag = "autospotting_min_savings_percentage"
+
+	// PriceWeightTag, InterruptionWeightTag, PlacementWeightTag and
+	// HeadroomWeightTag are the names of the tags set on the AutoScaling Group
+	// that can override the global values of the weights used for ranking the
+	// spot instance types
+	PriceWeightTag        = "autospotting_price_weight"
+	InterruptionWeightTag = "autospotting_interruption_weight"
+	PlacementWeightTag    = "autospotting_placement_weight"
+	HeadroomWeightTag     = "autospotting_headroom_weight"
+
+	// MaxInterruptionFrequencyTag is the name of the tag set on the
+	// AutoScaling Group that can override the global value of the
+	// MaxInterruptionFrequency parameter
+	MaxInterruptionFrequencyTag = "autospotting_max_interruption_frequency"
+
+	// DefaultMaxSizeStrategy is the default value for the max size strategy
+	// configuration option
+	DefaultMaxSizeStrategy = RaiseMaxSizeStrategy
+
+	// MaxSizeStrategyTag is the name of the tag set on the AutoScaling Group
+	// that can override the global value of the MaxSizeStrategy parameter
+	MaxSizeStrategyTag = "autospotting_max_size_strategy"
+
+	// DefaultReplacementBatchSize is the default value for the replacement
+	// batch size configuration option, replacing one instance per run
+	DefaultReplacementBatchSize = 1
+
+	// ReplacementBatchSizeTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the ReplacementBatchSize
+	// parameter
+	ReplacementBatchSizeTag = "autospotting_replacement_batch_size"
+
+	// MaxReplacementsPerRunTag is the name of the tag set on the AutoScaling
+	// Group that can override the global value of the MaxReplacementsPerRun
+	// parameter
+	MaxReplacementsPerRunTag = "autospotting_max_replacements_per_run"
+
+	// MaxReplacementsPerRunAliasTag is the hyphenated alias of the
+	// MaxReplacementsPerRunTag
+	MaxReplacementsPerRunAliasTag = "autospotting-max-replacements-per-run"
+
+	// MaxChangePercentagePerHourTag is the name of the tag set on the
+	// AutoScaling Group that can override the global value of the
+	// MaxChangePercentagePerHour parameter
+	MaxChangePercentagePerHourTag = "autospotting_max_change_percentage_per_hour"
+
+	// MaxChangePercentagePerHourAliasTag is the hyphenated alias of the
+	// MaxChangePercentagePerHourTag
+	MaxChangePercentagePerHourAliasTag = "autospotting-max-change-percentage-per-hour"
+
 	// CronScheduleStateTag is the name of the tag set on the AutoScaling Group that
 	// can override the global value of the CronScheduleState parameter
 	CronScheduleStateTag = "autospotting_cron_schedule_state"
+
+	// CronScheduleStateAliasTag is the hyphenated alias of the
+	// CronScheduleStateTag
+	CronScheduleStateAliasTag = "autospotting-cron-schedule-state"
+
+	// CronTimezoneTag is the name of the tag set on the AutoScaling Group that
+	// can override the global value of the CronTimezone parameter
+	CronTimezoneTag = "autospotting_cron_timezone"
 )
 
 // AutoScalingConfig stores some group-specific configurations that can override
@@ -99,6 +347,68 @@ type AutoScalingConfig struct {
 
 	CronSchedule      string
 	CronScheduleState string // "on" or "off", dictate whether to run inside the CronSchedule or not
+
+	// Time zone in which the CronSchedule is evaluated, such as Europe/Berlin
+	CronTimezone string
+
+	// Require the new spot instances to be Online in SSM before they replace
+	// on-demand instances
+	WaitForSSM bool
+
+	// Require cloud-init to be done on the new spot instances before they
+	// replace on-demand instances
+	WaitForCloudInit bool
+
+	// How the subnets of the spot instances are chosen among the subnets of
+	// the group
+	SubnetSelection string
+
+	// SSH key pair of the spot instances, NoKeyPair to launch them without a
+	// key pair, or empty to keep the key pair of the replaced instances
+	KeyPair string
+
+	// Whether the spot instances are launched using one-time or persistent
+	// spot requests
+	SpotRequestType string
+
+	// Minimum savings of the spot instances compared to the on-demand
+	// instances they replace, as a percentage of the on-demand price
+	MinSavingsPercentage float64
+
+	// Whether the groups are converted to spot by replacing their on-demand
+	// instances, or by converting them to a Mixed Instances Policy
+	ConversionMode string
+
+	// Weights of the savings, interruption history, placement and spec
+	// headroom of the instance types when ranking them for the spot
+	// instances, only the savings are considered by default
+	PriceWeight        float64
+	InterruptionWeight float64
+	PlacementWeight    float64
+	HeadroomWeight     float64
+
+	// Maximum interruption frequency of the spot instance types, as a
+	// percentage, above which they're not used according to the Spot Instance
+	// Advisor, 0 allowing all of them
+	MaxInterruptionFrequency float64
+
+	// How the replacements keep the groups within their MinSize and MaxSize,
+	// either by raising their MaxSize or by detaching the on-demand
+	// instances first
+	MaxSizeStrategy string
+
+	// Maximum number of on-demand instances whose spot replacements are
+	// launched concurrently on the same run
+	ReplacementBatchSize int
+
+	// Maximum number of on-demand instances replaced with spot instances on a
+	// single run, across all the groups. The per-group value only limits the
+	// replacements of the group. Zero means no limit.
+	MaxReplacementsPerRun int
+
+	// Maximum percentage of the instances of a group changed within an hour,
+	// either replaced with spot instances or interrupted. Zero means no limit.
+	MaxChangePercentagePerHour float64
 }
 
 func (a *autoScalingGroup) loadPercentageOnDemand(tagValue *string) (int64, bool) {
@@ -148,13 +458,11 @@ func (a *autoScalingGroup) loadNumberOnDemand(tagValue *string) (int64, bool) {
 	return DefaultMinOnDemandValue, false
 }
 
+// getTagValue returns the value of the given tag of the group, or else the
+// value overridden for the group in the configuration.
 func (a *autoScalingGroup) getTagValue(keyMatch string) *string {
-	for _, asgTag := range a.Tags {
-		if *asgTag.Key == keyMatch {
-			return asgTag.Value
-		}
-	}
-	return nil
+	tagValue, _ := a.getFirstTagValue(keyMatch)
+	return tagValue
 }
 
 func (a *autoScalingGroup) loadConfOnDemand() bool {
@@ -188,46 +496,323 @@ func (a *autoScalingGroup) loadBiddingPolicy(tagValue *string) (string, bool) {
 	return biddingPolicy, true
 }
 
+// getFirstTagValue returns the value of the first of the tags set on the group,
+// along with its key, or else the value overridden for the group in the
+// configuration for the first key. The aliases set on the group take
+// precedence over the overrides of the first key.
+func (a *autoScalingGroup) getFirstTagValue(keys ...string) (*string, string) {
+	for _, key := range keys {
+		for _, asgTag := range a.Tags {
+			if *asgTag.Key == key {
+				return asgTag.Value, key
+			}
+		}
+	}
+	if len(keys) == 0 || a.region == nil || a.region.conf == nil {
+		return nil, ""
+	}
+	if tagValue := a.region.conf.overriddenSetting(a.region.name, a.name, a.Tags, keys[0]); tagValue != nil {
+		return tagValue, keys[0]
+	}
+	return nil, ""
+}
+
+// LoadCronSchedule loads the schedule of the group from its tag, so that the
+// group can be processed in its own time window, falling back to the global
+// schedule when the tag is missing or isn't a valid schedule.
 func (a *autoScalingGroup) LoadCronSchedule() {
-	tagValue := a.getTagValue(ScheduleTag)
+	a.config.CronSchedule = a.region.conf.CronSchedule
 
-	if tagValue != nil {
-		logger.Printf("Loaded CronSchedule value %v from tag %v\n", *tagValue, ScheduleTag)
-		a.config.CronSchedule = *tagValue
+	tagValue, key := a.getFirstTagValue(ScheduleTag, CronScheduleAliasTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", ScheduleTag, "on the group", a.name, "using the default configuration")
 		return
 	}
 
-	debug.Println("Couldn't find tag", ScheduleTag, "on the group", a.name, "using the default configuration")
-	a.config.CronSchedule = a.region.conf.CronSchedule
+	if _, err := parseSchedule(*tagValue); err != nil {
+		logger.Printf("Ignoring invalid CronSchedule value %v from tag %v on the group %v, using the default configuration: %v\n",
+			*tagValue, key, a.name, err.Error())
+		return
+	}
+
+	logger.Printf("Loaded CronSchedule value %v from tag %v\n", *tagValue, key)
+	a.config.CronSchedule = *tagValue
 }
 
+// LoadCronScheduleState loads from its tag whether the group is processed
+// inside or outside of its schedule, falling back to the global value when
+// the tag is missing or is neither "on" nor "off".
 func (a *autoScalingGroup) LoadCronScheduleState() {
-	tagValue := a.getTagValue(CronScheduleStateTag)
+	a.config.CronScheduleState = a.region.conf.CronScheduleState
+
+	tagValue, key := a.getFirstTagValue(CronScheduleStateTag, CronScheduleStateAliasTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", CronScheduleStateTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	if !validScheduleState(*tagValue) {
+		logger.Printf("Ignoring invalid CronScheduleState value %v from tag %v on the group %v, using the default configuration\n",
+			*tagValue, key, a.name)
+		return
+	}
+
+	logger.Printf("Loaded CronScheduleState value %v from tag %v\n", *tagValue, key)
+	a.config.CronScheduleState = *tagValue
+}
+
+// LoadCronTimezone loads the time zone in which the schedule of the group is
+// evaluated from its tag, falling back to the global value when the tag is
+// missing or isn't a known time zone, and to UTC when the global value isn't
+// a known time zone either.
+func (a *autoScalingGroup) LoadCronTimezone() {
+	a.config.CronTimezone = a.region.conf.CronTimezone
+	if _, err := time.LoadLocation(a.config.CronTimezone); err != nil {
+		logger.Printf("Ignoring invalid CronTimezone value %v, using %v: %v\n",
+			a.config.CronTimezone, DefaultCronTimezone, err.Error())
+		a.config.CronTimezone = DefaultCronTimezone
+	}
+
+	tagValue := a.getTagValue(CronTimezoneTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", CronTimezoneTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	if _, err := time.LoadLocation(*tagValue); err != nil {
+		logger.Printf("Ignoring invalid CronTimezone value %v from tag %v on the group %v, using the default configuration: %v\n",
+			*tagValue, CronTimezoneTag, a.name, err.Error())
+		return
+	}
+
+	logger.Printf("Loaded CronTimezone value %v from tag %v\n", *tagValue, CronTimezoneTag)
+	a.config.CronTimezone = *tagValue
+}
+
+func (a *autoScalingGroup) LoadWaitForSSM() {
+	a.config.WaitForSSM = a.region.conf.WaitForSSM
+
+	tagValue := a.getTagValue(WaitForSSMTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", WaitForSSMTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	value, err := strconv.ParseBool(*tagValue)
+	if err != nil {
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s\n", WaitForSSMTag, *tagValue, a.name)
+		return
+	}
+	logger.Printf("Loaded WaitForSSM value %v from tag %v\n", value, WaitForSSMTag)
+	a.config.WaitForSSM = value
+}
+
+func (a *autoScalingGroup) LoadWaitForCloudInit() {
+	a.config.WaitForCloudInit = a.region.conf.WaitForCloudInit
+
+	tagValue := a.getTagValue(WaitForCloudInitTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", WaitForCloudInitTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	value, err := strconv.ParseBool(*tagValue)
+	if err != nil {
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s\n", WaitForCloudInitTag, *tagValue, a.name)
+		return
+	}
+	logger.Printf("Loaded WaitForCloudInit value %v from tag %v\n", value, WaitForCloudInitTag)
+	a.config.WaitForCloudInit = value
+}
+
+func (a *autoScalingGroup) LoadSubnetSelection() {
+	a.config.SubnetSelection = a.region.conf.SubnetSelection
+
+	tagValue := a.getTagValue(SubnetSelectionTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", SubnetSelectionTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	switch *tagValue {
+	case SameSubnetSelection, RoundRobinSubnetSelection, LeastUsedSubnetSelection:
+		logger.Printf("Loaded SubnetSelection value %v from tag %v\n", *tagValue, SubnetSelectionTag)
+		a.config.SubnetSelection = *tagValue
+	default:
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s\n", SubnetSelectionTag, *tagValue, a.name)
+	}
+}
+
+func (a *autoScalingGroup) LoadSpotRequestType() {
+	a.config.SpotRequestType = a.region.conf.SpotRequestType
+
+	tagValue := a.getTagValue(SpotRequestTypeTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", SpotRequestTypeTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	switch *tagValue {
+	case OneTimeSpotRequestType, PersistentSpotRequestType:
+		logger.Printf("Loaded SpotRequestType value %v from tag %v\n", *tagValue, SpotRequestTypeTag)
+		a.config.SpotRequestType = *tagValue
+	default:
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s\n", SpotRequestTypeTag, *tagValue, a.name)
+	}
+}
+
+func (a *autoScalingGroup) LoadConversionMode() {
+	a.config.ConversionMode = a.region.conf.ConversionMode
+
+	tagValue := a.getTagValue(ConversionModeTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", ConversionModeTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	switch *tagValue {
+	case ReplaceConversionMode, MIPConversionMode:
+		logger.Printf("Loaded ConversionMode value %v from tag %v\n", *tagValue, ConversionModeTag)
+		a.config.ConversionMode = *tagValue
+	default:
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s\n", ConversionModeTag, *tagValue, a.name)
+	}
+}
+
+func (a *autoScalingGroup) LoadMaxSizeStrategy() {
+	a.config.MaxSizeStrategy = a.region.conf.MaxSizeStrategy
+
+	tagValue := a.getTagValue(MaxSizeStrategyTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", MaxSizeStrategyTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	switch *tagValue {
+	case RaiseMaxSizeStrategy, DetachFirstStrategy:
+		logger.Printf("Loaded MaxSizeStrategy value %v from tag %v\n", *tagValue, MaxSizeStrategyTag)
+		a.config.MaxSizeStrategy = *tagValue
+	default:
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s\n", MaxSizeStrategyTag, *tagValue, a.name)
+	}
+}
+
+func (a *autoScalingGroup) LoadMinSavingsPercentage() {
+	a.config.MinSavingsPercentage = a.region.conf.MinSavingsPercentage
+
+	tagValue := a.getTagValue(MinSavingsPercentageTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", MinSavingsPercentageTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	value, err := strconv.ParseFloat(*tagValue, 64)
+	if err != nil || value < 0 || value >= 100 {
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s\n", MinSavingsPercentageTag, *tagValue, a.name)
+		return
+	}
+	logger.Printf("Loaded MinSavingsPercentage value %v from tag %v\n", value, MinSavingsPercentageTag)
+	a.config.MinSavingsPercentage = value
+}
+
+// LoadScoringWeights overrides the global weights used for ranking the spot
+// instance types of the group.
+func (a *autoScalingGroup) LoadScoringWeights() {
+	a.config.PriceWeight = a.loadWeight(PriceWeightTag, a.region.conf.PriceWeight)
+	a.config.InterruptionWeight = a.loadWeight(InterruptionWeightTag, a.region.conf.InterruptionWeight)
+	a.config.PlacementWeight = a.loadWeight(PlacementWeightTag, a.region.conf.PlacementWeight)
+	a.config.HeadroomWeight = a.loadWeight(HeadroomWeightTag, a.region.conf.HeadroomWeight)
+}
+
+// LoadMaxInterruptionFrequency overrides the global interruption frequency
+// above which the spot instance types aren't used for the group.
+func (a *autoScalingGroup) LoadMaxInterruptionFrequency() {
+	a.config.MaxInterruptionFrequency = a.region.conf.MaxInterruptionFrequency
+
+	tagValue := a.getTagValue(MaxInterruptionFrequencyTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", MaxInterruptionFrequencyTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	value, err := strconv.ParseFloat(*tagValue, 64)
+	if err != nil || value < 0 || value > 100 {
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s\n", MaxInterruptionFrequencyTag, *tagValue, a.name)
+		return
+	}
+	logger.Printf("Loaded MaxInterruptionFrequency value %v from tag %v\n", value, MaxInterruptionFrequencyTag)
+	a.config.MaxInterruptionFrequency = value
+}
+
+func (a *autoScalingGroup) loadWeight(tag string, global float64) float64 {
+	tagValue := a.getTagValue(tag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", tag, "on the group", a.name, "using the default configuration")
+		return global
+	}
+
+	value, err := strconv.ParseFloat(*tagValue, 64)
+	if err != nil || value < 0 {
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s\n", tag, *tagValue, a.name)
+		return global
+	}
+	logger.Printf("Loaded weight value %v from tag %v\n", value, tag)
+	return value
+}
+
+func (a *autoScalingGroup) LoadReplacementBatchSize() {
+	a.config.ReplacementBatchSize = a.region.conf.ReplacementBatchSize
+
+	tagValue := a.getTagValue(ReplacementBatchSizeTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", ReplacementBatchSizeTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	value, err := strconv.Atoi(*tagValue)
+	if err != nil || value < 1 {
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s\n", ReplacementBatchSizeTag, *tagValue, a.name)
+		return
+	}
+	logger.Printf("Loaded ReplacementBatchSize value %v from tag %v\n", value, ReplacementBatchSizeTag)
+	a.config.ReplacementBatchSize = value
+}
+
+func (a *autoScalingGroup) LoadKeyPair() {
+	tagValue := a.getTagValue(KeyPairTag)
 	if tagValue != nil {
-		logger.Printf("Loaded CronScheduleState value %v from tag %v\n", *tagValue, CronScheduleStateTag)
-		a.config.CronScheduleState = *tagValue
+		logger.Printf("Loaded KeyPair value %v from tag %v\n", *tagValue, KeyPairTag)
+		a.config.KeyPair = *tagValue
 		return
 	}
 
-	debug.Println("Couldn't find tag", CronScheduleStateTag, "on the group", a.name, "using the default configuration")
-	a.config.CronScheduleState = a.region.conf.CronScheduleState
+	debug.Println("Couldn't find tag", KeyPairTag, "on the group", a.name, "using the default configuration")
+	a.config.KeyPair = a.region.conf.KeyPair
 }
 
 func (a *autoScalingGroup) loadConfSpot() bool {
-	tagValue := a.getTagValue(BiddingPolicyTag)
+	a.config.BiddingPolicy = a.region.conf.BiddingPolicy
+
+	tagValue, _ := a.getFirstTagValue(BiddingPolicyTag, BiddingPolicyAliasTag)
 	if tagValue == nil {
 		debug.Println("Couldn't find tag", BiddingPolicyTag)
 		return false
 	}
 	if newValue, done := a.loadBiddingPolicy(tagValue); done {
-		a.region.conf.BiddingPolicy = newValue
-		logger.Println("BiddingPolicy =", a.region.conf.BiddingPolicy)
+		a.config.BiddingPolicy = newValue
+		logger.Println("BiddingPolicy =", a.config.BiddingPolicy)
 		return done
 	}
 	return false
 }
 
 func (a *autoScalingGroup) loadConfSpotPrice() bool {
+	// the default is only applied to the group's own copy of the
+	// configuration, which is shared by the groups processed in parallel
+	a.config.SpotPriceBufferPercentage = a.region.conf.SpotPriceBufferPercentage
+	if a.config.SpotPriceBufferPercentage <= 0 {
+		a.config.SpotPriceBufferPercentage = DefaultSpotPriceBufferPercentage
+	}
 
 	tagValue := a.getTagValue(SpotPriceBufferPercentageTag)
 	if tagValue == nil {
@@ -240,10 +825,46 @@ func (a *autoScalingGroup) loadConfSpotPrice() bool {
 		return false
 	}
 
-	a.region.conf.SpotPriceBufferPercentage = newValue
+	a.config.SpotPriceBufferPercentage = newValue
 	return done
 }
 
+// LoadOnDemandPriceMultiplier overrides the global on-demand price multiplier
+// for the group, and reprices its on-demand instances accordingly, since they
+// were priced using the global multiplier when scanning the group.
+func (a *autoScalingGroup) LoadOnDemandPriceMultiplier() {
+	a.config.OnDemandPriceMultiplier = a.region.conf.OnDemandPriceMultiplier
+
+	tagValue, key := a.getFirstTagValue(OnDemandPriceMultiplierTag, OnDemandPriceMultiplierAliasTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", OnDemandPriceMultiplierTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	value, err := strconv.ParseFloat(*tagValue, 64)
+	if err != nil || value <= 0 {
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s\n", key, *tagValue, a.name)
+		return
+	}
+	logger.Printf("Loaded OnDemandPriceMultiplier value %v from tag %v\n", value, key)
+	a.config.OnDemandPriceMultiplier = value
+
+	if a.instances == nil {
+		return
+	}
+
+	// a zero global multiplier means the actual on-demand prices were used
+	global := a.region.conf.OnDemandPriceMultiplier
+	if global == 0 {
+		global = 1.0
+	}
+	for i := range a.instances.instances() {
+		if !i.isSpot() && i.typeInfo.pricing.onDemand > 0 {
+			i.price = i.typeInfo.pricing.onDemand / global * value
+		}
+	}
+}
+
 // Add configuration of other elements here: prices, whitelisting, etc
 func (a *autoScalingGroup) loadConfigFromTags() bool {
 
@@ -253,8 +874,23 @@ func (a *autoScalingGroup) loadConfigFromTags() bool {
 
 	resSpotPriceConf := a.loadConfSpotPrice()
 
+	a.LoadOnDemandPriceMultiplier()
 	a.LoadCronSchedule()
 	a.LoadCronScheduleState()
+	a.LoadCronTimezone()
+	a.LoadWaitForSSM()
+	a.LoadWaitForCloudInit()
+	a.LoadSubnetSelection()
+	a.LoadKeyPair()
+	a.LoadSpotRequestType()
+	a.LoadMinSavingsPercentage()
+	a.LoadConversionMode()
+	a.LoadScoringWeights()
+	a.LoadMaxInterruptionFrequency()
+	a.LoadReplacementBatchSize()
+	a.LoadMaxReplacementsPerRun()
+	a.LoadMaxChangePercentagePerHour()
+	a.LoadMaxSizeStrategy()
 
 	if resOnDemandConf {
 		logger.Println("Found and applied configuration for OnDemand value")
@@ -297,10 +933,6 @@ func (a *autoScalingGroup) loadDefaultConfig() bool {
 	done := false
 	a.minOnDemand = DefaultMinOnDemandValue
 
-	if a.region.conf.SpotPriceBufferPercentage <= 0 {
-		a.region.conf.SpotPriceBufferPercentage = DefaultSpotPriceBufferPercentage
-	}
-
 	if a.region.conf.MinOnDemandNumber != 0 {
 		a.minOnDemand, done = a.loadDefaultConfigNumber()
 	}
@@ -1,10 +1,13 @@
 package autospotting
 
 import (
+	"math"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
 )
 
 func TestLoadSpotPriceBufferPercentage(t *testing.T) {
@@ -510,6 +513,16 @@ func TestLoadConfSpot(t *testing.T) {
 			loadingExpected: false,
 			valueExpected:   "normal",
 		},
+		{name: "Loading the hyphenated tag",
+			asgTags: []*autoscaling.TagDescription{
+				{
+					Key:   aws.String(BiddingPolicyAliasTag),
+					Value: aws.String("aggressive"),
+				},
+			},
+			loadingExpected: true,
+			valueExpected:   "aggressive",
+		},
 	}
 	for _, tt := range tests {
 		cfg := &Config{
@@ -526,8 +539,11 @@ func TestLoadConfSpot(t *testing.T) {
 		done := a.loadConfSpot()
 		if tt.loadingExpected != done {
 			t.Errorf("LoadSpotConf retured: %t expected %t", done, tt.loadingExpected)
-		} else if tt.valueExpected != a.region.conf.BiddingPolicy {
-			t.Errorf("LoadSpotConf loaded: %s expected %s", a.region.conf.BiddingPolicy, tt.valueExpected)
+		} else if tt.valueExpected != a.config.BiddingPolicy {
+			t.Errorf("LoadSpotConf loaded: %s expected %s", a.config.BiddingPolicy, tt.valueExpected)
+		}
+		if cfg.BiddingPolicy != "normal" {
+			t.Errorf("LoadSpotConf changed the global bidding policy to %s", cfg.BiddingPolicy)
 		}
 
 	}
@@ -595,11 +611,22 @@ func TestLoadConfSpotPrice(t *testing.T) {
 		done := a.loadConfSpotPrice()
 		if tt.loadingExpected != done {
 			t.Errorf("LoadSpotConf retured: %t expected %t", done, tt.loadingExpected)
-		} else if tt.valueExpected != a.region.conf.SpotPriceBufferPercentage {
-			t.Errorf("LoadSpotConf loaded: %f expected %f", a.region.conf.SpotPriceBufferPercentage, tt.valueExpected)
+		} else if tt.valueExpected != a.config.SpotPriceBufferPercentage {
+			t.Errorf("LoadSpotConf loaded: %f expected %f", a.config.SpotPriceBufferPercentage, tt.valueExpected)
+		}
+		if cfg.SpotPriceBufferPercentage != 10.0 {
+			t.Errorf("LoadSpotConf changed the global buffer to %f", cfg.SpotPriceBufferPercentage)
 		}
 
 	}
+
+	cfg := &Config{}
+	a := autoScalingGroup{Group: &autoscaling.Group{}, region: &region{name: "us-east-1", conf: cfg}}
+	a.loadConfSpotPrice()
+	if a.config.SpotPriceBufferPercentage != DefaultSpotPriceBufferPercentage || cfg.SpotPriceBufferPercentage != 0 {
+		t.Errorf("LoadSpotConf loaded %f into the group and %f into the global configuration, expected the default only in the group",
+			a.config.SpotPriceBufferPercentage, cfg.SpotPriceBufferPercentage)
+	}
 }
 
 func TestLoadConfigFromTags(t *testing.T) {
@@ -963,6 +990,82 @@ func Test_autoScalingGroup_LoadCronSchedule(t *testing.T) {
 			},
 			want: "3 4",
 		},
+		{
+			name: "Hyphenated tag set on the group",
+			Group: &autoscaling.Group{
+				Tags: []*autoscaling.TagDescription{
+					{
+						Key:   aws.String(CronScheduleAliasTag),
+						Value: aws.String("22-23 *"),
+					},
+				},
+			},
+			region: &region{
+				conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{
+						CronSchedule: "1 2",
+					},
+				},
+			},
+			want: "22-23 *",
+		},
+		{
+			name: "Cron expression tag set on the group",
+			Group: &autoscaling.Group{
+				Tags: []*autoscaling.TagDescription{
+					{
+						Key:   aws.String(CronScheduleAliasTag),
+						Value: aws.String("*/15 22-23,0-5 * * *"),
+					},
+				},
+			},
+			region: &region{
+				conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{
+						CronSchedule: "1 2",
+					},
+				},
+			},
+			want: "*/15 22-23,0-5 * * *",
+		},
+		{
+			name: "Invalid cron expression tag set on the group",
+			Group: &autoscaling.Group{
+				Tags: []*autoscaling.TagDescription{
+					{
+						Key:   aws.String(CronScheduleAliasTag),
+						Value: aws.String("61 * * * *"),
+					},
+				},
+			},
+			region: &region{
+				conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{
+						CronSchedule: "1 2",
+					},
+				},
+			},
+			want: "1 2",
+		},
+		{
+			name: "Invalid tag set on the group",
+			Group: &autoscaling.Group{
+				Tags: []*autoscaling.TagDescription{
+					{
+						Key:   aws.String(ScheduleTag),
+						Value: aws.String("at night"),
+					},
+				},
+			},
+			region: &region{
+				conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{
+						CronSchedule: "1 2",
+					},
+				},
+			},
+			want: "1 2",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1021,6 +1124,44 @@ func Test_autoScalingGroup_LoadCronScheduleState(t *testing.T) {
 			},
 			want: "off",
 		},
+		{
+			name: "Hyphenated tag set on the group",
+			Group: &autoscaling.Group{
+				Tags: []*autoscaling.TagDescription{
+					{
+						Key:   aws.String(CronScheduleStateAliasTag),
+						Value: aws.String("off"),
+					},
+				},
+			},
+			region: &region{
+				conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{
+						CronScheduleState: "on",
+					},
+				},
+			},
+			want: "off",
+		},
+		{
+			name: "Invalid tag set on the group",
+			Group: &autoscaling.Group{
+				Tags: []*autoscaling.TagDescription{
+					{
+						Key:   aws.String(CronScheduleStateTag),
+						Value: aws.String("disabled"),
+					},
+				},
+			},
+			region: &region{
+				conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{
+						CronScheduleState: "on",
+					},
+				},
+			},
+			want: "on",
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1037,3 +1178,635 @@ func Test_autoScalingGroup_LoadCronScheduleState(t *testing.T) {
 		})
 	}
 }
+
+func Test_autoScalingGroup_LoadCronTimezone(t *testing.T) {
+	if _, err := time.LoadLocation("Europe/Berlin"); err != nil {
+		t.Skip("time zone database unavailable:", err.Error())
+	}
+
+	tests := []struct {
+		name     string
+		tag      *string
+		timezone string
+		want     string
+	}{
+		{
+			name:     "No tag set on the group",
+			timezone: "America/New_York",
+			want:     "America/New_York",
+		},
+		{
+			name:     "Tag set on the group",
+			tag:      aws.String("Europe/Berlin"),
+			timezone: "UTC",
+			want:     "Europe/Berlin",
+		},
+		{
+			name:     "Invalid tag set on the group",
+			tag:      aws.String("Berlin"),
+			timezone: "America/New_York",
+			want:     "America/New_York",
+		},
+		{
+			name:     "Invalid global time zone",
+			timezone: "Mars/Olympus_Mons",
+			want:     DefaultCronTimezone,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{},
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{
+							CronTimezone: tt.timezone,
+						},
+					},
+				},
+			}
+			if tt.tag != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(CronTimezoneTag), Value: tt.tag}}
+			}
+			a.LoadCronTimezone()
+			if got := a.config.CronTimezone; got != tt.want {
+				t.Errorf("LoadCronTimezone got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_LoadWaitForSSM(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		tagValue *string
+		global   bool
+		want     bool
+	}{
+		{
+			name:   "No tag set on the group",
+			global: true,
+			want:   true,
+		},
+		{
+			name:     "Tag set on the group",
+			tagValue: aws.String("true"),
+			want:     true,
+		},
+		{
+			name:     "Tag overriding the global value",
+			tagValue: aws.String("false"),
+			global:   true,
+			want:     false,
+		},
+		{
+			name:     "Invalid tag value",
+			tagValue: aws.String("yes please"),
+			global:   true,
+			want:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{}
+			if tt.tagValue != nil {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(WaitForSSMTag), Value: tt.tagValue},
+				}
+			}
+			a := &autoScalingGroup{
+				Group: group,
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{WaitForSSM: tt.global},
+					},
+				},
+			}
+			a.LoadWaitForSSM()
+			if got := a.config.WaitForSSM; got != tt.want {
+				t.Errorf("LoadWaitForSSM got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_LoadSubnetSelection(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     string
+	}{
+		{
+			name: "No tag set on the group",
+			want: RoundRobinSubnetSelection,
+		},
+		{
+			name:     "Tag set on the group",
+			tagValue: aws.String(LeastUsedSubnetSelection),
+			want:     LeastUsedSubnetSelection,
+		},
+		{
+			name:     "Invalid tag value",
+			tagValue: aws.String("random"),
+			want:     RoundRobinSubnetSelection,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{}
+			if tt.tagValue != nil {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(SubnetSelectionTag), Value: tt.tagValue},
+				}
+			}
+			a := &autoScalingGroup{
+				Group: group,
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{SubnetSelection: RoundRobinSubnetSelection},
+					},
+				},
+			}
+			a.LoadSubnetSelection()
+			if got := a.config.SubnetSelection; got != tt.want {
+				t.Errorf("LoadSubnetSelection got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_LoadSpotRequestType(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     string
+	}{
+		{
+			name: "No tag set on the group",
+			want: OneTimeSpotRequestType,
+		},
+		{
+			name:     "Tag set on the group",
+			tagValue: aws.String(PersistentSpotRequestType),
+			want:     PersistentSpotRequestType,
+		},
+		{
+			name:     "Invalid tag value",
+			tagValue: aws.String("forever"),
+			want:     OneTimeSpotRequestType,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{}
+			if tt.tagValue != nil {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(SpotRequestTypeTag), Value: tt.tagValue},
+				}
+			}
+			a := &autoScalingGroup{
+				Group: group,
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{SpotRequestType: OneTimeSpotRequestType},
+					},
+				},
+			}
+			a.LoadSpotRequestType()
+			if got := a.config.SpotRequestType; got != tt.want {
+				t.Errorf("LoadSpotRequestType got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_LoadConversionMode(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     string
+	}{
+		{
+			name: "No tag set on the group",
+			want: ReplaceConversionMode,
+		},
+		{
+			name:     "Tag set on the group",
+			tagValue: aws.String(MIPConversionMode),
+			want:     MIPConversionMode,
+		},
+		{
+			name:     "Invalid tag value",
+			tagValue: aws.String("fleet"),
+			want:     ReplaceConversionMode,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{}
+			if tt.tagValue != nil {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(ConversionModeTag), Value: tt.tagValue},
+				}
+			}
+			a := &autoScalingGroup{
+				Group: group,
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{ConversionMode: ReplaceConversionMode},
+					},
+				},
+			}
+			a.LoadConversionMode()
+			if got := a.config.ConversionMode; got != tt.want {
+				t.Errorf("LoadConversionMode got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_LoadMinSavingsPercentage(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     float64
+	}{
+		{
+			name: "No tag set on the group",
+			want: 10,
+		},
+		{
+			name:     "Tag set on the group",
+			tagValue: aws.String("25"),
+			want:     25,
+		},
+		{
+			name:     "Tag disabling the threshold",
+			tagValue: aws.String("0"),
+			want:     0,
+		},
+		{
+			name:     "Invalid tag value",
+			tagValue: aws.String("a lot"),
+			want:     10,
+		},
+		{
+			name:     "Out of range tag value",
+			tagValue: aws.String("100"),
+			want:     10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{}
+			if tt.tagValue != nil {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(MinSavingsPercentageTag), Value: tt.tagValue},
+				}
+			}
+			a := &autoScalingGroup{
+				Group: group,
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{MinSavingsPercentage: 10},
+					},
+				},
+			}
+			a.LoadMinSavingsPercentage()
+			if got := a.config.MinSavingsPercentage; got != tt.want {
+				t.Errorf("LoadMinSavingsPercentage got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_LoadMaxInterruptionFrequency(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     float64
+	}{
+		{
+			name: "No tag set on the group",
+			want: 20,
+		},
+		{
+			name:     "Tag set on the group",
+			tagValue: aws.String("10"),
+			want:     10,
+		},
+		{
+			name:     "Tag disabling the limit",
+			tagValue: aws.String("0"),
+			want:     0,
+		},
+		{
+			name:     "Invalid tag value",
+			tagValue: aws.String("rarely"),
+			want:     20,
+		},
+		{
+			name:     "Out of range tag value",
+			tagValue: aws.String("150"),
+			want:     20,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{}
+			if tt.tagValue != nil {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(MaxInterruptionFrequencyTag), Value: tt.tagValue},
+				}
+			}
+			a := &autoScalingGroup{
+				Group: group,
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{MaxInterruptionFrequency: 20},
+					},
+				},
+			}
+			a.LoadMaxInterruptionFrequency()
+			if got := a.config.MaxInterruptionFrequency; got != tt.want {
+				t.Errorf("LoadMaxInterruptionFrequency got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_LoadScoringWeights(t *testing.T) {
+
+	tests := []struct {
+		name string
+		tags []*autoscaling.TagDescription
+		want AutoScalingConfig
+	}{
+		{
+			name: "No tags set on the group",
+			want: AutoScalingConfig{PriceWeight: 1, InterruptionWeight: 0.5},
+		},
+		{
+			name: "Tags set on the group",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(PriceWeightTag), Value: aws.String("2")},
+				{Key: aws.String(InterruptionWeightTag), Value: aws.String("0")},
+				{Key: aws.String(PlacementWeightTag), Value: aws.String("1")},
+				{Key: aws.String(HeadroomWeightTag), Value: aws.String("0.25")},
+			},
+			want: AutoScalingConfig{PriceWeight: 2, PlacementWeight: 1, HeadroomWeight: 0.25},
+		},
+		{
+			name: "Invalid tag values",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(PriceWeightTag), Value: aws.String("-1")},
+				{Key: aws.String(HeadroomWeightTag), Value: aws.String("high")},
+			},
+			want: AutoScalingConfig{PriceWeight: 1, InterruptionWeight: 0.5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{Tags: tt.tags},
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{PriceWeight: 1, InterruptionWeight: 0.5},
+					},
+				},
+			}
+			a.LoadScoringWeights()
+			if a.config != tt.want {
+				t.Errorf("LoadScoringWeights got %+v, expected %+v", a.config, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_LoadMaxSizeStrategy(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     string
+	}{
+		{
+			name: "No tag set on the group",
+			want: RaiseMaxSizeStrategy,
+		},
+		{
+			name:     "Tag set on the group",
+			tagValue: aws.String(DetachFirstStrategy),
+			want:     DetachFirstStrategy,
+		},
+		{
+			name:     "Invalid tag value",
+			tagValue: aws.String("fail"),
+			want:     RaiseMaxSizeStrategy,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{}
+			if tt.tagValue != nil {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(MaxSizeStrategyTag), Value: tt.tagValue},
+				}
+			}
+			a := &autoScalingGroup{
+				Group: group,
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{MaxSizeStrategy: RaiseMaxSizeStrategy},
+					},
+				},
+			}
+			a.LoadMaxSizeStrategy()
+			if got := a.config.MaxSizeStrategy; got != tt.want {
+				t.Errorf("LoadMaxSizeStrategy got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_LoadReplacementBatchSize(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     int
+	}{
+		{name: "No tag set on the group", want: 2},
+		{name: "Tag set on the group", tagValue: aws.String("5"), want: 5},
+		{name: "Invalid tag value", tagValue: aws.String("0"), want: 2},
+		{name: "Non-numeric tag value", tagValue: aws.String("all"), want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tags []*autoscaling.TagDescription
+			if tt.tagValue != nil {
+				tags = append(tags, &autoscaling.TagDescription{
+					Key: aws.String(ReplacementBatchSizeTag), Value: tt.tagValue})
+			}
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{Tags: tags},
+				region: &region{
+					conf: &Config{AutoScalingConfig: AutoScalingConfig{ReplacementBatchSize: 2}},
+				},
+			}
+			a.LoadReplacementBatchSize()
+			if a.config.ReplacementBatchSize != tt.want {
+				t.Errorf("LoadReplacementBatchSize got %d, expected %d", a.config.ReplacementBatchSize, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_LoadOnDemandPriceMultiplier(t *testing.T) {
+
+	tests := []struct {
+		name      string
+		global    float64
+		tagKey    string
+		tagValue  *string
+		want      float64
+		wantPrice float64
+	}{
+		{
+			name:      "No tag set on the group",
+			global:    2,
+			want:      2,
+			wantPrice: 0.2,
+		},
+		{
+			name:      "Tag set on the group",
+			global:    2,
+			tagValue:  aws.String("3"),
+			want:      3,
+			wantPrice: 0.3,
+		},
+		{
+			name:      "Hyphenated tag set on the group",
+			global:    2,
+			tagKey:    OnDemandPriceMultiplierAliasTag,
+			tagValue:  aws.String("4"),
+			want:      4,
+			wantPrice: 0.4,
+		},
+		{
+			name:      "Tag set on the group without a global multiplier",
+			tagValue:  aws.String("0.5"),
+			want:      0.5,
+			wantPrice: 0.05,
+		},
+		{
+			name:      "Invalid tag value",
+			global:    2,
+			tagValue:  aws.String("-1"),
+			want:      2,
+			wantPrice: 0.2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{}
+			if tt.tagValue != nil {
+				key := OnDemandPriceMultiplierTag
+				if tt.tagKey != "" {
+					key = tt.tagKey
+				}
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(key), Value: tt.tagValue},
+				}
+			}
+
+			// the prices of the scanned instances include the global multiplier
+			globalPrice := 0.1
+			if tt.global != 0 {
+				globalPrice *= tt.global
+			}
+			onDemand := &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("i-ondemand")},
+				typeInfo: instanceTypeInformation{pricing: prices{onDemand: globalPrice}},
+				price:    globalPrice,
+			}
+			spot := &instance{
+				Instance: &ec2.Instance{
+					InstanceId:        aws.String("i-spot"),
+					InstanceLifecycle: aws.String("spot"),
+				},
+				price: 0.03,
+			}
+
+			a := &autoScalingGroup{
+				Group: group,
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{OnDemandPriceMultiplier: tt.global},
+					},
+				},
+				instances: makeInstancesWithCatalog(instanceMap{
+					"i-ondemand": onDemand,
+					"i-spot":     spot,
+				}),
+			}
+			a.LoadOnDemandPriceMultiplier()
+			if got := a.config.OnDemandPriceMultiplier; got != tt.want {
+				t.Errorf("LoadOnDemandPriceMultiplier got %v, expected %v", got, tt.want)
+			}
+			if math.Abs(onDemand.price-tt.wantPrice) > 0.000001 {
+				t.Errorf("on-demand instance price %v, expected %v", onDemand.price, tt.wantPrice)
+			}
+			if spot.price != 0.03 {
+				t.Errorf("spot instance price changed to %v", spot.price)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_LoadKeyPair(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		tagValue *string
+		want     string
+	}{
+		{
+			name: "No tag set on the group",
+			want: "global-key",
+		},
+		{
+			name:     "Tag set on the group",
+			tagValue: aws.String(NoKeyPair),
+			want:     NoKeyPair,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{}
+			if tt.tagValue != nil {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(KeyPairTag), Value: tt.tagValue},
+				}
+			}
+			a := &autoScalingGroup{
+				Group: group,
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{KeyPair: "global-key"},
+					},
+				},
+			}
+			a.LoadKeyPair()
+			if got := a.config.KeyPair; got != tt.want {
+				t.Errorf("LoadKeyPair got %v, expected %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
@@ -2205,6 +2206,33 @@ func TestGetAllowedInstanceTypes(t *testing.T) {
 				},
 			},
 		},
+		{name: "Hyphenated tag precedence on command line",
+			expected: []string{"c5.large", "m5.large"},
+			instanceInfo: &instance{
+				typeInfo: instanceTypeInformation{
+					instanceType: "typeX",
+				},
+				region: &region{},
+			},
+			asg: &autoScalingGroup{
+				name: "TestASG",
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{
+							AllowedInstanceTypes: "c2.xlarge",
+						}},
+				},
+				Group: &autoscaling.Group{
+					DesiredCapacity: aws.Int64(4),
+				},
+			},
+			asgtags: []*autoscaling.TagDescription{
+				{
+					Key:   aws.String("autospotting-allowed-instance-types"),
+					Value: aws.String("c5.large m5.large"),
+				},
+			},
+		},
 		{name: "ASG 'current' precedence on command line",
 			expected: []string{"c2.xlarge"},
 			instanceInfo: &instance{
@@ -2420,6 +2448,33 @@ func TestGetDisallowedInstanceTypes(t *testing.T) {
 				},
 			},
 		},
+		{name: "Hyphenated tag precedence on command line",
+			expected: []string{"c5.large", "m5.large"},
+			instanceInfo: &instance{
+				typeInfo: instanceTypeInformation{
+					instanceType: "typeX",
+				},
+				region: &region{},
+			},
+			asg: &autoScalingGroup{
+				name: "TestASG",
+				region: &region{
+					conf: &Config{
+						AutoScalingConfig: AutoScalingConfig{
+							DisallowedInstanceTypes: "c2.xlarge",
+						}},
+				},
+				Group: &autoscaling.Group{
+					DesiredCapacity: aws.Int64(4),
+				},
+			},
+			asgtags: []*autoscaling.TagDescription{
+				{
+					Key:   aws.String("autospotting-disallowed-instance-types"),
+					Value: aws.String("c5.large m5.large"),
+				},
+			},
+		},
 		{name: "Comma separated list",
 			expected: []string{"c2.xlarge", "t2.medium", "c3.small"},
 			instanceInfo: &instance{
@@ -2514,8 +2569,7 @@ func TestGetDisallowedInstanceTypes(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			a := tt.asg
 			a.Tags = tt.asgtags
-			baseInstance := tt.instanceInfo
-			allowed := a.getDisallowedInstanceTypes(baseInstance)
+			allowed := a.getDisallowedInstanceTypes()
 			if !reflect.DeepEqual(allowed, tt.expected) {
 				t.Errorf("Disallowed Instance Types does not match, received: %+v, expected: %+v",
 					allowed, tt.expected)
@@ -2635,6 +2689,29 @@ func Test_autoScalingGroup_findUnattachedInstanceLaunchedForThisASG(t *testing.T
 				},
 			},
 			want: nil,
+		}, {
+			name: "instance tagged for current ASG but not launched by AutoSpotting",
+			asg: autoScalingGroup{
+				name: "mygroup",
+				region: &region{
+					instances: makeInstancesWithCatalog(
+						instanceMap{
+							"id-1": {
+								Instance: &ec2.Instance{
+									InstanceId: aws.String("id-1"),
+									Tags: []*ec2.Tag{
+										{
+											Key:   aws.String("launched-for-asg"),
+											Value: aws.String("mygroup"),
+										},
+									},
+								},
+							},
+						},
+					),
+				},
+			},
+			want: nil,
 		}, {
 			name: "instance launched for current ASG",
 			asg: autoScalingGroup{
@@ -2660,6 +2737,10 @@ func Test_autoScalingGroup_findUnattachedInstanceLaunchedForThisASG(t *testing.T
 								Instance: &ec2.Instance{
 									InstanceId: aws.String("id-2"),
 									Tags: []*ec2.Tag{
+										{
+											Key:   aws.String("launched-by-autospotting"),
+											Value: aws.String("true"),
+										},
 										{
 											Key:   aws.String("launched-for-asg"),
 											Value: aws.String("mygroup"),
@@ -2679,6 +2760,10 @@ func Test_autoScalingGroup_findUnattachedInstanceLaunchedForThisASG(t *testing.T
 				Instance: &ec2.Instance{
 					InstanceId: aws.String("id-2"),
 					Tags: []*ec2.Tag{
+						{
+							Key:   aws.String("launched-by-autospotting"),
+							Value: aws.String("true"),
+						},
 						{
 							Key:   aws.String("launched-for-asg"),
 							Value: aws.String("mygroup"),
@@ -2986,3 +3071,68 @@ func Test_autoScalingGroup_getAnyUnprotectedOnDemandInstance(t *testing.T) {
 		})
 	}
 }
+
+func Test_autoScalingGroup_getAnyUnprotectedOnDemandInstance_minUptime(t *testing.T) {
+	newInstance := func(id string, launched time.Time) *instance {
+		return &instance{
+			Instance: &ec2.Instance{
+				InstanceId: aws.String(id),
+				State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+				Placement:  &ec2.Placement{AvailabilityZone: aws.String("1a")},
+				LaunchTime: aws.Time(launched),
+			},
+			region: &region{services: connections{ec2: mockEC2{
+				diao: &ec2.DescribeInstanceAttributeOutput{
+					DisableApiTermination: &ec2.AttributeBooleanValue{Value: aws.Bool(false)},
+				},
+			}}},
+		}
+	}
+
+	tests := []struct {
+		name      string
+		minUptime time.Duration
+		instances []*instance
+		want      string
+	}{
+		{
+			name:      "no minimum uptime",
+			instances: []*instance{newInstance("fresh", time.Now().Add(-time.Minute))},
+			want:      "fresh",
+		},
+		{
+			name:      "only fresh instances",
+			minUptime: 10 * time.Minute,
+			instances: []*instance{newInstance("fresh", time.Now().Add(-5*time.Minute))},
+		},
+		{
+			name:      "old and fresh instances",
+			minUptime: 10 * time.Minute,
+			instances: []*instance{
+				newInstance("fresh", time.Now().Add(-5*time.Minute)),
+				newInstance("old", time.Now().Add(-time.Hour)),
+			},
+			want: "old",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name:      "asg",
+				Group:     &autoscaling.Group{},
+				region:    &region{conf: &Config{MinInstanceUptime: tt.minUptime}},
+				instances: makeInstances(),
+			}
+			for _, i := range tt.instances {
+				i.asg = a
+				a.instances.add(i)
+			}
+
+			got := a.getAnyUnprotectedOnDemandInstance()
+			if (got == nil && tt.want != "") || (got != nil && *got.InstanceId != tt.want) {
+				t.Errorf("getAnyUnprotectedOnDemandInstance() = %v, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,476 @@
+package autospottingtest
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// AutoScaling is a fake AutoScaling client operating on the in-memory state
+// of a region. Calling any of the methods not implemented here panics.
+type AutoScaling struct {
+	autoscalingiface.AutoScalingAPI
+	cloud  *Cloud
+	region string
+}
+
+func (a *AutoScaling) state() *Region {
+	return a.cloud.region(a.region)
+}
+
+func (a *AutoScaling) group(name *string) (*autoscaling.Group, error) {
+	g, ok := a.state().groups[aws.StringValue(name)]
+	if !ok {
+		return nil, fmt.Errorf("ValidationError: AutoScalingGroup name not found - %s",
+			aws.StringValue(name))
+	}
+	return g, nil
+}
+
+// groupOfInstance must be called with the lock held
+func (a *AutoScaling) groupOfInstance(id string) *autoscaling.Group {
+	for _, g := range a.state().groups {
+		for _, inst := range g.Instances {
+			if *inst.InstanceId == id {
+				return g
+			}
+		}
+	}
+	return nil
+}
+
+func removeGroupInstance(g *autoscaling.Group, id string) bool {
+	for i, inst := range g.Instances {
+		if *inst.InstanceId == id {
+			g.Instances = append(g.Instances[:i], g.Instances[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// DescribeAutoScalingGroupsPages passes all the groups, or those given by
+// name, to the given function in a single page.
+func (a *AutoScaling) DescribeAutoScalingGroupsPages(in *autoscaling.DescribeAutoScalingGroupsInput, fn func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool) error {
+	a.cloud.mu.Lock()
+	a.cloud.record(a.region, "DescribeAutoScalingGroupsPages")
+
+	names := map[string]bool{}
+	for _, n := range in.AutoScalingGroupNames {
+		names[*n] = true
+	}
+
+	out := &autoscaling.DescribeAutoScalingGroupsOutput{}
+	for name, g := range a.state().groups {
+		if len(names) == 0 || names[name] {
+			out.AutoScalingGroups = append(out.AutoScalingGroups, g)
+		}
+	}
+	a.cloud.mu.Unlock()
+
+	sort.Slice(out.AutoScalingGroups, func(i, j int) bool {
+		return *out.AutoScalingGroups[i].AutoScalingGroupName <
+			*out.AutoScalingGroups[j].AutoScalingGroupName
+	})
+
+	fn(out, true)
+	return nil
+}
+
+// DescribeLaunchConfigurations returns the launch configurations with the
+// given names.
+func (a *AutoScaling) DescribeLaunchConfigurations(in *autoscaling.DescribeLaunchConfigurationsInput) (*autoscaling.DescribeLaunchConfigurationsOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+	a.cloud.record(a.region, "DescribeLaunchConfigurations")
+
+	out := &autoscaling.DescribeLaunchConfigurationsOutput{}
+	for _, name := range in.LaunchConfigurationNames {
+		if lc, ok := a.state().launchConfigurations[*name]; ok {
+			out.LaunchConfigurations = append(out.LaunchConfigurations, lc)
+		}
+	}
+	return out, nil
+}
+
+// DescribeAutoScalingInstances returns the group membership of the given
+// instances.
+func (a *AutoScaling) DescribeAutoScalingInstances(in *autoscaling.DescribeAutoScalingInstancesInput) (*autoscaling.DescribeAutoScalingInstancesOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+	a.cloud.record(a.region, "DescribeAutoScalingInstances")
+
+	out := &autoscaling.DescribeAutoScalingInstancesOutput{}
+	for _, id := range in.InstanceIds {
+		if g := a.groupOfInstance(*id); g != nil {
+			out.AutoScalingInstances = append(out.AutoScalingInstances,
+				&autoscaling.InstanceDetails{
+					InstanceId:           id,
+					AutoScalingGroupName: g.AutoScalingGroupName,
+				})
+		}
+	}
+	return out, nil
+}
+
+// DescribeLifecycleHooks returns the lifecycle hooks of a group.
+func (a *AutoScaling) DescribeLifecycleHooks(in *autoscaling.DescribeLifecycleHooksInput) (*autoscaling.DescribeLifecycleHooksOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+	a.cloud.record(a.region, "DescribeLifecycleHooks")
+
+	return &autoscaling.DescribeLifecycleHooksOutput{
+		LifecycleHooks: a.state().lifecycleHooks[aws.StringValue(in.AutoScalingGroupName)],
+	}, nil
+}
+
+// UpdateAutoScalingGroup supports updating the size of a group. Like
+// AutoScaling, it keeps the desired capacity within the new size limits.
+func (a *AutoScaling) UpdateAutoScalingGroup(in *autoscaling.UpdateAutoScalingGroupInput) (*autoscaling.UpdateAutoScalingGroupOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+	a.cloud.record(a.region, "UpdateAutoScalingGroup")
+
+	g, err := a.group(in.AutoScalingGroupName)
+	if err != nil {
+		return nil, err
+	}
+
+	if in.MinSize != nil {
+		g.MinSize = in.MinSize
+	}
+	if in.MaxSize != nil {
+		g.MaxSize = in.MaxSize
+	}
+	if in.DesiredCapacity != nil {
+		g.DesiredCapacity = in.DesiredCapacity
+	}
+	if *g.DesiredCapacity < *g.MinSize {
+		g.DesiredCapacity = aws.Int64(*g.MinSize)
+	}
+	if *g.DesiredCapacity > *g.MaxSize {
+		g.DesiredCapacity = aws.Int64(*g.MaxSize)
+	}
+	return &autoscaling.UpdateAutoScalingGroupOutput{}, nil
+}
+
+// AttachInstances adds running instances to a group, increasing its desired
+// capacity. It fails if the group would exceed its maximum size.
+func (a *AutoScaling) AttachInstances(in *autoscaling.AttachInstancesInput) (*autoscaling.AttachInstancesOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+	a.cloud.record(a.region, "AttachInstances")
+
+	g, err := a.group(in.AutoScalingGroupName)
+	if err != nil {
+		return nil, err
+	}
+
+	if *g.DesiredCapacity+int64(len(in.InstanceIds)) > *g.MaxSize {
+		return nil, fmt.Errorf("ValidationError: Cannot attach instances, the "+
+			"desired capacity would exceed the max size of %d", *g.MaxSize)
+	}
+
+	for _, id := range in.InstanceIds {
+		inst, ok := a.state().instances[*id]
+		if !ok || *inst.State.Name != ec2.InstanceStateNameRunning {
+			return nil, fmt.Errorf("ValidationError: Instance %s is not in a valid state", *id)
+		}
+		g.Instances = append(g.Instances, &autoscaling.Instance{
+			InstanceId:           id,
+			AvailabilityZone:     inst.Placement.AvailabilityZone,
+			LifecycleState:       aws.String("InService"),
+			HealthStatus:         aws.String("Healthy"),
+			ProtectedFromScaleIn: aws.Bool(false),
+		})
+		*g.DesiredCapacity++
+	}
+	return &autoscaling.AttachInstancesOutput{}, nil
+}
+
+// DetachInstances removes instances from a group, optionally decrementing
+// its desired capacity. It fails if the group would go below its minimum size.
+func (a *AutoScaling) DetachInstances(in *autoscaling.DetachInstancesInput) (*autoscaling.DetachInstancesOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+	a.cloud.record(a.region, "DetachInstances")
+
+	g, err := a.group(in.AutoScalingGroupName)
+	if err != nil {
+		return nil, err
+	}
+
+	if aws.BoolValue(in.ShouldDecrementDesiredCapacity) &&
+		*g.DesiredCapacity-int64(len(in.InstanceIds)) < *g.MinSize {
+		return nil, fmt.Errorf("ValidationError: Cannot detach instances, the "+
+			"desired capacity would be below the min size of %d", *g.MinSize)
+	}
+
+	for _, id := range in.InstanceIds {
+		if !removeGroupInstance(g, *id) {
+			return nil, fmt.Errorf("ValidationError: Instance %s is not part of %s",
+				*id, *g.AutoScalingGroupName)
+		}
+		if aws.BoolValue(in.ShouldDecrementDesiredCapacity) {
+			*g.DesiredCapacity--
+		}
+	}
+	return &autoscaling.DetachInstancesOutput{}, nil
+}
+
+// TerminateInstanceInAutoScalingGroup terminates an instance and removes it
+// from its group, optionally decrementing the desired capacity. It fails if
+// the group would go below its minimum size.
+func (a *AutoScaling) TerminateInstanceInAutoScalingGroup(in *autoscaling.TerminateInstanceInAutoScalingGroupInput) (*autoscaling.TerminateInstanceInAutoScalingGroupOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+	a.cloud.record(a.region, "TerminateInstanceInAutoScalingGroup")
+
+	g := a.groupOfInstance(*in.InstanceId)
+	if g == nil {
+		return nil, fmt.Errorf("ValidationError: Instance Id not found - %s", *in.InstanceId)
+	}
+
+	if aws.BoolValue(in.ShouldDecrementDesiredCapacity) && *g.DesiredCapacity <= *g.MinSize {
+		return nil, fmt.Errorf("ValidationError: Currently, desiredSize equals minSize (%d). "+
+			"Terminating instance without replacement will violate group's min size constraint.",
+			*g.MinSize)
+	}
+
+	removeGroupInstance(g, *in.InstanceId)
+	if aws.BoolValue(in.ShouldDecrementDesiredCapacity) {
+		*g.DesiredCapacity--
+	}
+
+	if inst, ok := a.state().instances[*in.InstanceId]; ok {
+		inst.State = &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameTerminated)}
+	}
+
+	return &autoscaling.TerminateInstanceInAutoScalingGroupOutput{
+		Activity: &autoscaling.Activity{
+			AutoScalingGroupName: g.AutoScalingGroupName,
+			Description:          aws.String("Terminating EC2 instance: " + *in.InstanceId),
+		},
+	}, nil
+}
+
+// CreateLaunchConfiguration stores a new launch configuration.
+func (a *AutoScaling) CreateLaunchConfiguration(in *autoscaling.CreateLaunchConfigurationInput) (*autoscaling.CreateLaunchConfigurationOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+	a.cloud.record(a.region, "CreateLaunchConfiguration")
+
+	name := aws.StringValue(in.LaunchConfigurationName)
+	if _, ok := a.state().launchConfigurations[name]; ok {
+		return nil, fmt.Errorf("AlreadyExists: Launch Configuration by this name already exists - %s", name)
+	}
+
+	a.state().launchConfigurations[name] = &autoscaling.LaunchConfiguration{
+		LaunchConfigurationName:  in.LaunchConfigurationName,
+		ImageId:                  in.ImageId,
+		InstanceType:             in.InstanceType,
+		KeyName:                  in.KeyName,
+		SecurityGroups:           in.SecurityGroups,
+		UserData:                 in.UserData,
+		BlockDeviceMappings:      in.BlockDeviceMappings,
+		AssociatePublicIpAddress: in.AssociatePublicIpAddress,
+		EbsOptimized:             in.EbsOptimized,
+	}
+	return &autoscaling.CreateLaunchConfigurationOutput{}, nil
+}
+
+// DeleteLaunchConfiguration removes a launch configuration.
+func (a *AutoScaling) DeleteLaunchConfiguration(in *autoscaling.DeleteLaunchConfigurationInput) (*autoscaling.DeleteLaunchConfigurationOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+	a.cloud.record(a.region, "DeleteLaunchConfiguration")
+
+	delete(a.state().launchConfigurations, aws.StringValue(in.LaunchConfigurationName))
+	return &autoscaling.DeleteLaunchConfigurationOutput{}, nil
+}
+
+// CreateAutoScalingGroup stores a new group based on a launch configuration,
+// launching its desired number of on-demand instances in the first of its
+// availability zones.
+func (a *AutoScaling) CreateAutoScalingGroup(in *autoscaling.CreateAutoScalingGroupInput) (*autoscaling.CreateAutoScalingGroupOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+	a.cloud.record(a.region, "CreateAutoScalingGroup")
+
+	name := aws.StringValue(in.AutoScalingGroupName)
+	if _, ok := a.state().groups[name]; ok {
+		return nil, fmt.Errorf("AlreadyExists: AutoScalingGroup by this name already exists - %s", name)
+	}
+
+	lc, ok := a.state().launchConfigurations[aws.StringValue(in.LaunchConfigurationName)]
+	if !ok {
+		return nil, fmt.Errorf("ValidationError: Launch configuration name not found - %s",
+			aws.StringValue(in.LaunchConfigurationName))
+	}
+
+	if len(in.AvailabilityZones) == 0 {
+		return nil, fmt.Errorf("ValidationError: At least one Availability Zone is required")
+	}
+
+	desired := aws.Int64Value(in.DesiredCapacity)
+	if in.DesiredCapacity == nil {
+		desired = aws.Int64Value(in.MinSize)
+	}
+
+	g := &autoscaling.Group{
+		AutoScalingGroupName:    in.AutoScalingGroupName,
+		LaunchConfigurationName: in.LaunchConfigurationName,
+		AvailabilityZones:       in.AvailabilityZones,
+		MinSize:                 in.MinSize,
+		MaxSize:                 in.MaxSize,
+		DesiredCapacity:         aws.Int64(desired),
+		HealthCheckGracePeriod:  aws.Int64(aws.Int64Value(in.HealthCheckGracePeriod)),
+		VPCZoneIdentifier:       in.VPCZoneIdentifier,
+	}
+
+	for _, t := range in.Tags {
+		g.Tags = append(g.Tags, &autoscaling.TagDescription{
+			Key:               t.Key,
+			Value:             t.Value,
+			PropagateAtLaunch: t.PropagateAtLaunch,
+			ResourceId:        in.AutoScalingGroupName,
+			ResourceType:      aws.String("auto-scaling-group"),
+		})
+	}
+
+	for i := int64(0); i < desired; i++ {
+		inst := &ec2.Instance{
+			InstanceId:         aws.String(a.cloud.newInstanceID()),
+			InstanceType:       lc.InstanceType,
+			ImageId:            lc.ImageId,
+			KeyName:            lc.KeyName,
+			LaunchTime:         aws.Time(time.Now()),
+			Placement:          &ec2.Placement{AvailabilityZone: in.AvailabilityZones[0]},
+			State:              &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+			VirtualizationType: aws.String(ec2.VirtualizationTypeHvm),
+		}
+		a.state().instances[*inst.InstanceId] = inst
+
+		g.Instances = append(g.Instances, &autoscaling.Instance{
+			InstanceId:              inst.InstanceId,
+			AvailabilityZone:        in.AvailabilityZones[0],
+			LaunchConfigurationName: in.LaunchConfigurationName,
+			LifecycleState:          aws.String("InService"),
+			HealthStatus:            aws.String("Healthy"),
+			ProtectedFromScaleIn:    aws.Bool(false),
+		})
+	}
+
+	a.state().groups[name] = g
+	return &autoscaling.CreateAutoScalingGroupOutput{}, nil
+}
+
+// DeleteAutoScalingGroup removes a group, terminating its instances when
+// forced to do so.
+func (a *AutoScaling) DeleteAutoScalingGroup(in *autoscaling.DeleteAutoScalingGroupInput) (*autoscaling.DeleteAutoScalingGroupOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+	a.cloud.record(a.region, "DeleteAutoScalingGroup")
+
+	g, err := a.group(in.AutoScalingGroupName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(g.Instances) > 0 && !aws.BoolValue(in.ForceDelete) {
+		return nil, fmt.Errorf("ResourceInUse: You cannot delete an AutoScalingGroup while there are instances still in the group")
+	}
+
+	for _, member := range g.Instances {
+		if inst, ok := a.state().instances[*member.InstanceId]; ok {
+			inst.State = &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameTerminated)}
+		}
+	}
+
+	delete(a.state().groups, *g.AutoScalingGroupName)
+	return &autoscaling.DeleteAutoScalingGroupOutput{}, nil
+}
+
+// CreateOrUpdateTags adds or overwrites tags on AutoScaling groups.
+func (a *AutoScaling) CreateOrUpdateTags(in *autoscaling.CreateOrUpdateTagsInput) (*autoscaling.CreateOrUpdateTagsOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+	a.cloud.record(a.region, "CreateOrUpdateTags")
+
+	for _, t := range in.Tags {
+		g, err := a.group(t.ResourceId)
+		if err != nil {
+			return nil, err
+		}
+
+		updated := false
+		for _, existing := range g.Tags {
+			if *existing.Key == *t.Key {
+				existing.Value = t.Value
+				existing.PropagateAtLaunch = t.PropagateAtLaunch
+				updated = true
+			}
+		}
+		if !updated {
+			g.Tags = append(g.Tags, &autoscaling.TagDescription{
+				Key:               t.Key,
+				Value:             t.Value,
+				PropagateAtLaunch: t.PropagateAtLaunch,
+				ResourceId:        t.ResourceId,
+				ResourceType:      aws.String("auto-scaling-group"),
+			})
+		}
+	}
+	return &autoscaling.CreateOrUpdateTagsOutput{}, nil
+}
+
+// DeleteTags removes tags from AutoScaling groups.
+func (a *AutoScaling) DeleteTags(in *autoscaling.DeleteTagsInput) (*autoscaling.DeleteTagsOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+	a.cloud.record(a.region, "DeleteTags")
+
+	for _, t := range in.Tags {
+		g, err := a.group(t.ResourceId)
+		if err != nil {
+			return nil, err
+		}
+
+		var kept []*autoscaling.TagDescription
+		for _, existing := range g.Tags {
+			if *existing.Key != *t.Key {
+				kept = append(kept, existing)
+			}
+		}
+		g.Tags = kept
+	}
+	return &autoscaling.DeleteTagsOutput{}, nil
+}
+
+// CompleteLifecycleAction completes the lifecycle action of an instance
+// waiting to be terminated, removing it from its group.
+func (a *AutoScaling) CompleteLifecycleAction(in *autoscaling.CompleteLifecycleActionInput) (*autoscaling.CompleteLifecycleActionOutput, error) {
+	a.cloud.mu.Lock()
+	defer a.cloud.mu.Unlock()
+	a.cloud.record(a.region, "CompleteLifecycleAction")
+
+	g, err := a.group(in.AutoScalingGroupName)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, member := range g.Instances {
+		if *member.InstanceId == aws.StringValue(in.InstanceId) &&
+			aws.StringValue(member.LifecycleState) == autoscaling.LifecycleStateTerminatingWait {
+			removeGroupInstance(g, *member.InstanceId)
+			return &autoscaling.CompleteLifecycleActionOutput{}, nil
+		}
+	}
+	return nil, fmt.Errorf("ValidationError: No active Lifecycle Action found with instance ID %s",
+		aws.StringValue(in.InstanceId))
+}
@@ -0,0 +1,294 @@
+// Package autospottingtest provides an in-memory fake implementation of the
+// subset of the EC2, AutoScaling and CloudFormation APIs used by AutoSpotting.
+//
+// It can be plugged into the autospotting.Config as a ClientProvider, so that
+// the replacement logic can be exercised end to end without calling AWS:
+//
+//	cloud := autospottingtest.NewCloud()
+//	r := cloud.Region("us-east-1")
+//	r.AddInstance(...)
+//	r.AddGroup(...)
+//
+//	cfg.ClientProvider = cloud
+//	autospotting.Run(cfg)
+package autospottingtest
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// Cloud is an in-memory fake of the AWS APIs used by AutoSpotting, holding
+// the state of multiple regions. It implements the autospotting.ClientProvider
+// interface. It is safe for concurrent use.
+type Cloud struct {
+	mu      sync.Mutex
+	regions map[string]*Region
+	calls   []Call
+	nextID  int
+}
+
+// Call records an API call made against the fake cloud.
+type Call struct {
+	Region    string
+	Operation string
+}
+
+// Region holds the in-memory state of a single region.
+type Region struct {
+	cloud *Cloud
+	name  string
+
+	instances            map[string]*ec2.Instance
+	groups               map[string]*autoscaling.Group
+	launchConfigurations map[string]*autoscaling.LaunchConfiguration
+	lifecycleHooks       map[string][]*autoscaling.LifecycleHook
+	stacks               map[string]*cloudformation.Stack
+	spotPrices           []*ec2.SpotPrice
+	spotRequests         map[string]*ec2.SpotInstanceRequest
+	terminationProtected map[string]bool
+}
+
+// NewCloud returns an empty fake cloud.
+func NewCloud() *Cloud {
+	return &Cloud{regions: make(map[string]*Region)}
+}
+
+// Region returns the state of the region with the given name, creating it if
+// needed.
+func (c *Cloud) Region(name string) *Region {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.region(name)
+}
+
+func (c *Cloud) region(name string) *Region {
+	if r, ok := c.regions[name]; ok {
+		return r
+	}
+	r := &Region{
+		cloud:                c,
+		name:                 name,
+		instances:            make(map[string]*ec2.Instance),
+		groups:               make(map[string]*autoscaling.Group),
+		launchConfigurations: make(map[string]*autoscaling.LaunchConfiguration),
+		lifecycleHooks:       make(map[string][]*autoscaling.LifecycleHook),
+		stacks:               make(map[string]*cloudformation.Stack),
+		spotRequests:         make(map[string]*ec2.SpotInstanceRequest),
+		terminationProtected: make(map[string]bool),
+	}
+	c.regions[name] = r
+	return r
+}
+
+// Calls returns all the API calls made so far, in the order they were made.
+func (c *Cloud) Calls() []Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Call(nil), c.calls...)
+}
+
+// MutatingCalls returns the API calls made so far which would have changed
+// the state of the infrastructure.
+func (c *Cloud) MutatingCalls() []Call {
+	var result []Call
+	for _, call := range c.Calls() {
+		if mutatingOperations[call.Operation] {
+			result = append(result, call)
+		}
+	}
+	return result
+}
+
+var mutatingOperations = map[string]bool{
+	"RunInstances":                        true,
+	"TerminateInstances":                  true,
+	"DeleteTags":                          true,
+	"CreateTags":                          true,
+	"AttachInstances":                     true,
+	"DetachInstances":                     true,
+	"TerminateInstanceInAutoScalingGroup": true,
+	"UpdateAutoScalingGroup":              true,
+	"CreateLaunchConfiguration":           true,
+	"DeleteLaunchConfiguration":           true,
+	"CreateAutoScalingGroup":              true,
+	"DeleteAutoScalingGroup":              true,
+	"CreateOrUpdateTags":                  true,
+	"CancelSpotInstanceRequests":          true,
+	"CompleteLifecycleAction":             true,
+}
+
+// record must be called with the lock held
+func (c *Cloud) record(region, operation string) {
+	c.calls = append(c.calls, Call{Region: region, Operation: operation})
+}
+
+// must be called with the lock held
+func (c *Cloud) newInstanceID() string {
+	c.nextID++
+	return fmt.Sprintf("i-%017x", c.nextID)
+}
+
+// AutoScaling returns a fake AutoScaling client for the given region.
+func (c *Cloud) AutoScaling(region string) autoscalingiface.AutoScalingAPI {
+	return &AutoScaling{cloud: c, region: region}
+}
+
+// EC2 returns a fake EC2 client for the given region.
+func (c *Cloud) EC2(region string) ec2iface.EC2API {
+	return &EC2{cloud: c, region: region}
+}
+
+// CloudFormation returns a fake CloudFormation client for the given region.
+func (c *Cloud) CloudFormation(region string) cloudformationiface.CloudFormationAPI {
+	return &CloudFormation{cloud: c, region: region}
+}
+
+// AddInstance stores an instance in the region. Missing state is defaulted to
+// running.
+func (r *Region) AddInstance(inst *ec2.Instance) {
+	r.cloud.mu.Lock()
+	defer r.cloud.mu.Unlock()
+	if inst.State == nil {
+		inst.State = &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)}
+	}
+	r.instances[*inst.InstanceId] = inst
+}
+
+// Instance returns the instance with the given ID, or nil if not found.
+func (r *Region) Instance(id string) *ec2.Instance {
+	r.cloud.mu.Lock()
+	defer r.cloud.mu.Unlock()
+	return r.instances[id]
+}
+
+// Instances returns all the instances from the region, sorted by ID.
+func (r *Region) Instances() []*ec2.Instance {
+	r.cloud.mu.Lock()
+	defer r.cloud.mu.Unlock()
+	var result []*ec2.Instance
+	for _, inst := range r.instances {
+		result = append(result, inst)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return *result[i].InstanceId < *result[j].InstanceId
+	})
+	return result
+}
+
+// SetTerminationProtection sets the API termination protection of an
+// instance.
+func (r *Region) SetTerminationProtection(id string, protected bool) {
+	r.cloud.mu.Lock()
+	defer r.cloud.mu.Unlock()
+	r.terminationProtected[id] = protected
+}
+
+// AddGroup stores an AutoScaling group in the region.
+func (r *Region) AddGroup(group *autoscaling.Group) {
+	r.cloud.mu.Lock()
+	defer r.cloud.mu.Unlock()
+	r.groups[*group.AutoScalingGroupName] = group
+}
+
+// Group returns the AutoScaling group with the given name, or nil if not
+// found.
+func (r *Region) Group(name string) *autoscaling.Group {
+	r.cloud.mu.Lock()
+	defer r.cloud.mu.Unlock()
+	return r.groups[name]
+}
+
+// AddLaunchConfiguration stores a launch configuration in the region.
+func (r *Region) AddLaunchConfiguration(lc *autoscaling.LaunchConfiguration) {
+	r.cloud.mu.Lock()
+	defer r.cloud.mu.Unlock()
+	r.launchConfigurations[*lc.LaunchConfigurationName] = lc
+}
+
+// AddLifecycleHook stores a lifecycle hook for the given AutoScaling group.
+func (r *Region) AddLifecycleHook(group string, hook *autoscaling.LifecycleHook) {
+	r.cloud.mu.Lock()
+	defer r.cloud.mu.Unlock()
+	r.lifecycleHooks[group] = append(r.lifecycleHooks[group], hook)
+}
+
+// AddStack stores a CloudFormation stack in the region.
+func (r *Region) AddStack(stack *cloudformation.Stack) {
+	r.cloud.mu.Lock()
+	defer r.cloud.mu.Unlock()
+	r.stacks[*stack.StackName] = stack
+}
+
+// SetSpotPrice sets the current spot price of an instance type in a given
+// availability zone.
+func (r *Region) SetSpotPrice(instanceType, availabilityZone string, price float64) {
+	r.cloud.mu.Lock()
+	defer r.cloud.mu.Unlock()
+
+	for _, sp := range r.spotPrices {
+		if *sp.InstanceType == instanceType && *sp.AvailabilityZone == availabilityZone {
+			sp.SpotPrice = aws.String(fmt.Sprintf("%f", price))
+			return
+		}
+	}
+	r.spotPrices = append(r.spotPrices, &ec2.SpotPrice{
+		InstanceType:       aws.String(instanceType),
+		AvailabilityZone:   aws.String(availabilityZone),
+		SpotPrice:          aws.String(fmt.Sprintf("%f", price)),
+		ProductDescription: aws.String("Linux/UNIX (Amazon VPC)"),
+	})
+}
+
+// AddSpotInstanceRequest stores a spot request in the region.
+func (r *Region) AddSpotInstanceRequest(req *ec2.SpotInstanceRequest) {
+	r.cloud.mu.Lock()
+	defer r.cloud.mu.Unlock()
+	r.spotRequests[*req.SpotInstanceRequestId] = req
+}
+
+// SpotInstanceRequest returns the spot request with the given ID, or nil if
+// not found.
+func (r *Region) SpotInstanceRequest(id string) *ec2.SpotInstanceRequest {
+	r.cloud.mu.Lock()
+	defer r.cloud.mu.Unlock()
+	return r.spotRequests[id]
+}
+
+// CloudFormation is a fake CloudFormation client.
+type CloudFormation struct {
+	cloudformationiface.CloudFormationAPI
+	cloud  *Cloud
+	region string
+}
+
+// DescribeStacks returns the stack with the given name.
+func (cf *CloudFormation) DescribeStacks(in *cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error) {
+	cf.cloud.mu.Lock()
+	defer cf.cloud.mu.Unlock()
+	cf.cloud.record(cf.region, "DescribeStacks")
+
+	r := cf.cloud.region(cf.region)
+	if in.StackName == nil {
+		var stacks []*cloudformation.Stack
+		for _, s := range r.stacks {
+			stacks = append(stacks, s)
+		}
+		return &cloudformation.DescribeStacksOutput{Stacks: stacks}, nil
+	}
+
+	s, ok := r.stacks[*in.StackName]
+	if !ok {
+		return nil, fmt.Errorf("ValidationError: Stack with id %s does not exist", *in.StackName)
+	}
+	return &cloudformation.DescribeStacksOutput{Stacks: []*cloudformation.Stack{s}}, nil
+}
@@ -0,0 +1,235 @@
+package autospottingtest
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func testGroup(name string, instanceIDs ...string) *autoscaling.Group {
+	g := &autoscaling.Group{
+		AutoScalingGroupName: aws.String(name),
+		MinSize:              aws.Int64(1),
+		MaxSize:              aws.Int64(3),
+		DesiredCapacity:      aws.Int64(int64(len(instanceIDs))),
+	}
+	for _, id := range instanceIDs {
+		g.Instances = append(g.Instances, &autoscaling.Instance{
+			InstanceId:           aws.String(id),
+			AvailabilityZone:     aws.String("us-east-1a"),
+			ProtectedFromScaleIn: aws.Bool(false),
+		})
+	}
+	return g
+}
+
+func testInstance(id string) *ec2.Instance {
+	return &ec2.Instance{
+		InstanceId:   aws.String(id),
+		InstanceType: aws.String("m5.large"),
+		Placement:    &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+	}
+}
+
+func TestRunInstances(t *testing.T) {
+	c := NewCloud()
+	svc := c.EC2("us-east-1")
+
+	resp, err := svc.RunInstances(&ec2.RunInstancesInput{
+		InstanceType: aws.String("m5.large"),
+		Placement:    &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+		InstanceMarketOptions: &ec2.InstanceMarketOptionsRequest{
+			MarketType: aws.String("spot"),
+		},
+		TagSpecifications: []*ec2.TagSpecification{{
+			ResourceType: aws.String("instance"),
+			Tags:         []*ec2.Tag{{Key: aws.String("foo"), Value: aws.String("bar")}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("RunInstances() error = %v", err)
+	}
+
+	id := *resp.Instances[0].InstanceId
+	inst := c.Region("us-east-1").Instance(id)
+	if inst == nil {
+		t.Fatalf("RunInstances() didn't store the instance %s", id)
+	}
+	if aws.StringValue(inst.InstanceLifecycle) != "spot" {
+		t.Errorf("RunInstances() lifecycle = %v, want spot", aws.StringValue(inst.InstanceLifecycle))
+	}
+	if len(inst.Tags) != 1 || *inst.Tags[0].Key != "foo" {
+		t.Errorf("RunInstances() tags = %v", inst.Tags)
+	}
+
+	want := []Call{{Region: "us-east-1", Operation: "RunInstances"}}
+	if got := c.MutatingCalls(); !reflect.DeepEqual(got, want) {
+		t.Errorf("MutatingCalls() = %v, want %v", got, want)
+	}
+}
+
+func TestDescribeInstancesPagesFilters(t *testing.T) {
+	c := NewCloud()
+	r := c.Region("us-east-1")
+	r.AddInstance(testInstance("i-1"))
+	stopped := testInstance("i-2")
+	stopped.State = &ec2.InstanceState{Name: aws.String("stopped")}
+	r.AddInstance(stopped)
+
+	var got []string
+	c.EC2("us-east-1").DescribeInstancesPages(&ec2.DescribeInstancesInput{
+		Filters: []*ec2.Filter{{
+			Name:   aws.String("instance-state-name"),
+			Values: []*string{aws.String("running"), aws.String("pending")},
+		}},
+	}, func(page *ec2.DescribeInstancesOutput, last bool) bool {
+		for _, res := range page.Reservations {
+			for _, inst := range res.Instances {
+				got = append(got, *inst.InstanceId)
+			}
+		}
+		return true
+	})
+
+	if !reflect.DeepEqual(got, []string{"i-1"}) {
+		t.Errorf("DescribeInstancesPages() = %v, want [i-1]", got)
+	}
+}
+
+func TestAttachInstancesAboveMaxSize(t *testing.T) {
+	c := NewCloud()
+	r := c.Region("us-east-1")
+	r.AddInstance(testInstance("i-1"))
+	r.AddInstance(testInstance("i-2"))
+	g := testGroup("asg", "i-1")
+	g.MaxSize = aws.Int64(1)
+	r.AddGroup(g)
+
+	_, err := c.AutoScaling("us-east-1").AttachInstances(&autoscaling.AttachInstancesInput{
+		AutoScalingGroupName: aws.String("asg"),
+		InstanceIds:          []*string{aws.String("i-2")},
+	})
+	if err == nil {
+		t.Errorf("AttachInstances() expected an error when exceeding the max size")
+	}
+}
+
+func TestDetachInstancesBelowMinSize(t *testing.T) {
+	c := NewCloud()
+	r := c.Region("us-east-1")
+	r.AddInstance(testInstance("i-1"))
+	r.AddGroup(testGroup("asg", "i-1"))
+	svc := c.AutoScaling("us-east-1")
+
+	detach := &autoscaling.DetachInstancesInput{
+		AutoScalingGroupName:           aws.String("asg"),
+		InstanceIds:                    []*string{aws.String("i-1")},
+		ShouldDecrementDesiredCapacity: aws.Bool(true),
+	}
+	if _, err := svc.DetachInstances(detach); err == nil {
+		t.Errorf("DetachInstances() expected an error when going below the min size")
+	}
+
+	if _, err := svc.UpdateAutoScalingGroup(&autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String("asg"),
+		MinSize:              aws.Int64(0),
+	}); err != nil {
+		t.Fatalf("UpdateAutoScalingGroup() error = %v", err)
+	}
+	if _, err := svc.DetachInstances(detach); err != nil {
+		t.Errorf("DetachInstances() error = %v", err)
+	}
+
+	if _, err := svc.UpdateAutoScalingGroup(&autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String("asg"),
+		MinSize:              aws.Int64(1),
+	}); err != nil {
+		t.Fatalf("UpdateAutoScalingGroup() error = %v", err)
+	}
+	if g := r.Group("asg"); *g.DesiredCapacity != 1 {
+		t.Errorf("DesiredCapacity = %d, want it raised to the new min size", *g.DesiredCapacity)
+	}
+}
+
+func TestTerminateInstanceInAutoScalingGroup(t *testing.T) {
+	c := NewCloud()
+	r := c.Region("us-east-1")
+	r.AddInstance(testInstance("i-1"))
+	r.AddInstance(testInstance("i-2"))
+	r.AddGroup(testGroup("asg", "i-1", "i-2"))
+
+	_, err := c.AutoScaling("us-east-1").TerminateInstanceInAutoScalingGroup(
+		&autoscaling.TerminateInstanceInAutoScalingGroupInput{
+			InstanceId:                     aws.String("i-1"),
+			ShouldDecrementDesiredCapacity: aws.Bool(true),
+		})
+	if err != nil {
+		t.Fatalf("TerminateInstanceInAutoScalingGroup() error = %v", err)
+	}
+
+	g := r.Group("asg")
+	if *g.DesiredCapacity != 1 || len(g.Instances) != 1 || *g.Instances[0].InstanceId != "i-2" {
+		t.Errorf("unexpected group state after termination: %v", g)
+	}
+	if *r.Instance("i-1").State.Name != "terminated" {
+		t.Errorf("instance i-1 wasn't terminated")
+	}
+}
+
+func TestCreateAndDeleteAutoScalingGroup(t *testing.T) {
+	c := NewCloud()
+	r := c.Region("us-east-1")
+	as := c.AutoScaling("us-east-1")
+
+	if _, err := as.CreateLaunchConfiguration(&autoscaling.CreateLaunchConfigurationInput{
+		LaunchConfigurationName: aws.String("lc"),
+		ImageId:                 aws.String("ami-123"),
+		InstanceType:            aws.String("m5.large"),
+	}); err != nil {
+		t.Fatalf("CreateLaunchConfiguration() error = %v", err)
+	}
+
+	if _, err := as.CreateAutoScalingGroup(&autoscaling.CreateAutoScalingGroupInput{
+		AutoScalingGroupName:    aws.String("asg"),
+		LaunchConfigurationName: aws.String("lc"),
+		AvailabilityZones:       []*string{aws.String("us-east-1a")},
+		MinSize:                 aws.Int64(2),
+		MaxSize:                 aws.Int64(3),
+		Tags:                    []*autoscaling.Tag{{Key: aws.String("spot-enabled"), Value: aws.String("true")}},
+	}); err != nil {
+		t.Fatalf("CreateAutoScalingGroup() error = %v", err)
+	}
+
+	g := r.Group("asg")
+	if *g.DesiredCapacity != 2 || len(g.Instances) != 2 || len(r.Instances()) != 2 {
+		t.Fatalf("unexpected group state after creation: %v", g)
+	}
+	if *r.Instance(*g.Instances[0].InstanceId).InstanceType != "m5.large" {
+		t.Errorf("group instances weren't launched from the launch configuration")
+	}
+
+	if _, err := as.DeleteAutoScalingGroup(&autoscaling.DeleteAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String("asg"),
+	}); err == nil {
+		t.Errorf("DeleteAutoScalingGroup() expected an error for a group with instances")
+	}
+
+	if _, err := as.DeleteAutoScalingGroup(&autoscaling.DeleteAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String("asg"),
+		ForceDelete:          aws.Bool(true),
+	}); err != nil {
+		t.Fatalf("DeleteAutoScalingGroup() error = %v", err)
+	}
+
+	if r.Group("asg") != nil {
+		t.Errorf("group wasn't deleted")
+	}
+	for _, i := range r.Instances() {
+		if *i.State.Name != "terminated" {
+			t.Errorf("instance %s wasn't terminated", *i.InstanceId)
+		}
+	}
+}
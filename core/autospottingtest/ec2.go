@@ -0,0 +1,370 @@
+package autospottingtest
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// EC2 is a fake EC2 client operating on the in-memory state of a region.
+// Calling any of the methods not implemented here panics.
+type EC2 struct {
+	ec2iface.EC2API
+	cloud  *Cloud
+	region string
+}
+
+func (e *EC2) state() *Region {
+	return e.cloud.region(e.region)
+}
+
+// DescribeRegions returns all the regions known by the fake cloud.
+func (e *EC2) DescribeRegions(*ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error) {
+	e.cloud.mu.Lock()
+	defer e.cloud.mu.Unlock()
+	e.cloud.record(e.region, "DescribeRegions")
+
+	var names []string
+	for name := range e.cloud.regions {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	out := &ec2.DescribeRegionsOutput{}
+	for _, name := range names {
+		out.Regions = append(out.Regions, &ec2.Region{RegionName: aws.String(name)})
+	}
+	return out, nil
+}
+
+// instanceMatchesFilters supports the instance-id, instance-state-name and
+// tag:<key> filters, the other filters are ignored.
+func instanceMatchesFilters(inst *ec2.Instance, filters []*ec2.Filter) bool {
+	for _, f := range filters {
+		var value *string
+		switch name := aws.StringValue(f.Name); {
+		case name == "instance-id":
+			value = inst.InstanceId
+		case name == "instance-state-name":
+			value = inst.State.Name
+		case strings.HasPrefix(name, "tag:"):
+			for _, t := range inst.Tags {
+				if *t.Key == strings.TrimPrefix(name, "tag:") {
+					value = t.Value
+				}
+			}
+		default:
+			continue
+		}
+
+		if value == nil || !matchesAny(*value, f.Values) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchesAny(value string, patterns []*string) bool {
+	for _, p := range patterns {
+		if match, _ := filepath.Match(*p, value); match {
+			return true
+		}
+	}
+	return false
+}
+
+// DescribeInstancesPages passes all the instances matching the filters to the
+// given function in a single page.
+func (e *EC2) DescribeInstancesPages(in *ec2.DescribeInstancesInput, fn func(*ec2.DescribeInstancesOutput, bool) bool) error {
+	e.cloud.mu.Lock()
+	e.cloud.record(e.region, "DescribeInstancesPages")
+
+	ids := map[string]bool{}
+	for _, id := range in.InstanceIds {
+		ids[*id] = true
+	}
+
+	res := &ec2.Reservation{}
+	for _, inst := range e.state().instances {
+		if len(ids) > 0 && !ids[*inst.InstanceId] {
+			continue
+		}
+		if instanceMatchesFilters(inst, in.Filters) {
+			res.Instances = append(res.Instances, inst)
+		}
+	}
+	e.cloud.mu.Unlock()
+
+	sort.Slice(res.Instances, func(i, j int) bool {
+		return *res.Instances[i].InstanceId < *res.Instances[j].InstanceId
+	})
+
+	fn(&ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{res}}, true)
+	return nil
+}
+
+// DescribeInstanceAttribute only supports the disableApiTermination attribute.
+func (e *EC2) DescribeInstanceAttribute(in *ec2.DescribeInstanceAttributeInput) (*ec2.DescribeInstanceAttributeOutput, error) {
+	e.cloud.mu.Lock()
+	defer e.cloud.mu.Unlock()
+	e.cloud.record(e.region, "DescribeInstanceAttribute")
+
+	if _, ok := e.state().instances[*in.InstanceId]; !ok {
+		return nil, fmt.Errorf("InvalidInstanceID.NotFound: %s", *in.InstanceId)
+	}
+
+	return &ec2.DescribeInstanceAttributeOutput{
+		InstanceId: in.InstanceId,
+		DisableApiTermination: &ec2.AttributeBooleanValue{
+			Value: aws.Bool(e.state().terminationProtected[*in.InstanceId]),
+		},
+	}, nil
+}
+
+// DescribeSpotPriceHistory returns the current spot prices set in the region,
+// filtered by instance type and availability zone.
+func (e *EC2) DescribeSpotPriceHistory(in *ec2.DescribeSpotPriceHistoryInput) (*ec2.DescribeSpotPriceHistoryOutput, error) {
+	e.cloud.mu.Lock()
+	defer e.cloud.mu.Unlock()
+	e.cloud.record(e.region, "DescribeSpotPriceHistory")
+
+	out := &ec2.DescribeSpotPriceHistoryOutput{}
+	for _, sp := range e.state().spotPrices {
+		if in.AvailabilityZone != nil && *in.AvailabilityZone != *sp.AvailabilityZone {
+			continue
+		}
+		if len(in.InstanceTypes) > 0 && !matchesAny(*sp.InstanceType, in.InstanceTypes) {
+			continue
+		}
+		out.SpotPriceHistory = append(out.SpotPriceHistory, sp)
+	}
+	return out, nil
+}
+
+// DescribeSpotPriceHistoryPages passes the current spot prices set in the
+// region to the given function in a single page.
+func (e *EC2) DescribeSpotPriceHistoryPages(in *ec2.DescribeSpotPriceHistoryInput, fn func(*ec2.DescribeSpotPriceHistoryOutput, bool) bool) error {
+	out, err := e.DescribeSpotPriceHistory(in)
+	if err != nil {
+		return err
+	}
+	fn(out, true)
+	return nil
+}
+
+// RunInstances launches a single running instance based on the given input.
+func (e *EC2) RunInstances(in *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	e.cloud.mu.Lock()
+	defer e.cloud.mu.Unlock()
+	e.cloud.record(e.region, "RunInstances")
+
+	inst := &ec2.Instance{
+		InstanceId:         aws.String(e.cloud.newInstanceID()),
+		InstanceType:       in.InstanceType,
+		ImageId:            in.ImageId,
+		KeyName:            in.KeyName,
+		EbsOptimized:       in.EbsOptimized,
+		LaunchTime:         aws.Time(time.Now()),
+		Placement:          in.Placement,
+		SubnetId:           in.SubnetId,
+		State:              &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		VirtualizationType: aws.String(ec2.VirtualizationTypeHvm),
+	}
+
+	if in.InstanceMarketOptions != nil &&
+		aws.StringValue(in.InstanceMarketOptions.MarketType) == ec2.MarketTypeSpot {
+		inst.InstanceLifecycle = aws.String(ec2.InstanceLifecycleTypeSpot)
+	}
+
+	for _, ni := range in.NetworkInterfaces {
+		if aws.Int64Value(ni.DeviceIndex) == 0 && ni.SubnetId != nil {
+			inst.SubnetId = ni.SubnetId
+		}
+	}
+
+	for _, ts := range in.TagSpecifications {
+		if aws.StringValue(ts.ResourceType) == ec2.ResourceTypeInstance {
+			inst.Tags = append(inst.Tags, ts.Tags...)
+		}
+	}
+
+	e.state().instances[*inst.InstanceId] = inst
+
+	return &ec2.Reservation{Instances: []*ec2.Instance{inst}}, nil
+}
+
+// TerminateInstances sets the state of the given instances to terminated.
+func (e *EC2) TerminateInstances(in *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+	e.cloud.mu.Lock()
+	defer e.cloud.mu.Unlock()
+	e.cloud.record(e.region, "TerminateInstances")
+
+	out := &ec2.TerminateInstancesOutput{}
+	for _, id := range in.InstanceIds {
+		inst, ok := e.state().instances[*id]
+		if !ok {
+			return nil, fmt.Errorf("InvalidInstanceID.NotFound: %s", *id)
+		}
+		if e.state().terminationProtected[*id] {
+			return nil, fmt.Errorf("OperationNotPermitted: %s has termination protection", *id)
+		}
+		inst.State = &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameTerminated)}
+		out.TerminatingInstances = append(out.TerminatingInstances,
+			&ec2.InstanceStateChange{InstanceId: id, CurrentState: inst.State})
+	}
+	return out, nil
+}
+
+// CreateTags adds or overwrites tags on the given instances.
+func (e *EC2) CreateTags(in *ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	e.cloud.mu.Lock()
+	defer e.cloud.mu.Unlock()
+	e.cloud.record(e.region, "CreateTags")
+
+	for _, id := range in.Resources {
+		inst, ok := e.state().instances[*id]
+		if !ok {
+			return nil, fmt.Errorf("InvalidInstanceID.NotFound: %s", *id)
+		}
+		for _, tag := range in.Tags {
+			inst.Tags = setTag(inst.Tags, *tag.Key, aws.StringValue(tag.Value))
+		}
+	}
+	return &ec2.CreateTagsOutput{}, nil
+}
+
+// DeleteTags removes tags from the given instances.
+func (e *EC2) DeleteTags(in *ec2.DeleteTagsInput) (*ec2.DeleteTagsOutput, error) {
+	e.cloud.mu.Lock()
+	defer e.cloud.mu.Unlock()
+	e.cloud.record(e.region, "DeleteTags")
+
+	for _, id := range in.Resources {
+		inst, ok := e.state().instances[*id]
+		if !ok {
+			return nil, fmt.Errorf("InvalidInstanceID.NotFound: %s", *id)
+		}
+		for _, tag := range in.Tags {
+			inst.Tags = deleteTag(inst.Tags, *tag.Key)
+		}
+	}
+	return &ec2.DeleteTagsOutput{}, nil
+}
+
+func setTag(tags []*ec2.Tag, key, value string) []*ec2.Tag {
+	for _, t := range tags {
+		if *t.Key == key {
+			t.Value = aws.String(value)
+			return tags
+		}
+	}
+	return append(tags, &ec2.Tag{Key: aws.String(key), Value: aws.String(value)})
+}
+
+func deleteTag(tags []*ec2.Tag, key string) []*ec2.Tag {
+	var result []*ec2.Tag
+	for _, t := range tags {
+		if *t.Key != key {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// DescribeAvailabilityZones returns the availability zones of the region,
+// named after the region with the a, b and c suffixes.
+func (e *EC2) DescribeAvailabilityZones(in *ec2.DescribeAvailabilityZonesInput) (*ec2.DescribeAvailabilityZonesOutput, error) {
+	e.cloud.mu.Lock()
+	defer e.cloud.mu.Unlock()
+	e.cloud.record(e.region, "DescribeAvailabilityZones")
+
+	out := &ec2.DescribeAvailabilityZonesOutput{}
+	for _, suffix := range []string{"a", "b", "c"} {
+		out.AvailabilityZones = append(out.AvailabilityZones, &ec2.AvailabilityZone{
+			RegionName: aws.String(e.region),
+			ZoneName:   aws.String(e.region + suffix),
+			State:      aws.String(ec2.AvailabilityZoneStateAvailable),
+		})
+	}
+	return out, nil
+}
+
+// DescribeSpotInstanceRequests returns the spot requests of the region,
+// supporting the state, type, instance-id, tag-key and tag:<key> filters.
+func (e *EC2) DescribeSpotInstanceRequests(in *ec2.DescribeSpotInstanceRequestsInput) (*ec2.DescribeSpotInstanceRequestsOutput, error) {
+	e.cloud.mu.Lock()
+	defer e.cloud.mu.Unlock()
+	e.cloud.record(e.region, "DescribeSpotInstanceRequests")
+
+	var ids []string
+	for id := range e.state().spotRequests {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	out := &ec2.DescribeSpotInstanceRequestsOutput{}
+
+requests:
+	for _, id := range ids {
+		req := e.state().spotRequests[id]
+		for _, f := range in.Filters {
+			var value *string
+			switch name := aws.StringValue(f.Name); {
+			case name == "state":
+				value = req.State
+			case name == "type":
+				value = req.Type
+			case name == "instance-id":
+				value = req.InstanceId
+			case name == "tag-key":
+				for _, t := range req.Tags {
+					if matchesAny(*t.Key, f.Values) {
+						value = t.Key
+					}
+				}
+			case strings.HasPrefix(name, "tag:"):
+				for _, t := range req.Tags {
+					if *t.Key == strings.TrimPrefix(name, "tag:") {
+						value = t.Value
+					}
+				}
+			default:
+				continue
+			}
+			if value == nil || !matchesAny(*value, f.Values) {
+				continue requests
+			}
+		}
+		out.SpotInstanceRequests = append(out.SpotInstanceRequests, req)
+	}
+	return out, nil
+}
+
+// CancelSpotInstanceRequests sets the state of the given spot requests to
+// cancelled.
+func (e *EC2) CancelSpotInstanceRequests(in *ec2.CancelSpotInstanceRequestsInput) (*ec2.CancelSpotInstanceRequestsOutput, error) {
+	e.cloud.mu.Lock()
+	defer e.cloud.mu.Unlock()
+	e.cloud.record(e.region, "CancelSpotInstanceRequests")
+
+	out := &ec2.CancelSpotInstanceRequestsOutput{}
+	for _, id := range in.SpotInstanceRequestIds {
+		req, ok := e.state().spotRequests[*id]
+		if !ok {
+			return nil, fmt.Errorf("InvalidSpotInstanceRequestID.NotFound: %s", *id)
+		}
+		req.State = aws.String(ec2.SpotInstanceStateCancelled)
+		out.CancelledSpotInstanceRequests = append(out.CancelledSpotInstanceRequests,
+			&ec2.CancelledSpotInstanceRequest{
+				SpotInstanceRequestId: id,
+				State:                 aws.String(ec2.CancelSpotInstanceRequestStateCancelled),
+			})
+	}
+	return out, nil
+}
@@ -0,0 +1,281 @@
+package autospotting
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// BacktestConfig stores the configuration of the simulation of the past
+// launches against the current configuration.
+type BacktestConfig struct {
+	// Location of the run artifacts, given as s3://bucket/prefix
+	Source string
+
+	// Time interval of the simulated launches
+	From, To time.Time
+
+	// Only simulate the launches of this AutoScaling group
+	AutoScalingGroup string
+
+	// Region used for connecting to S3 if the bucket region can't be
+	// determined
+	Region string
+
+	Output io.Writer
+}
+
+// backtestResult is a launch recorded in a run artifact, together with the
+// instance type the current configuration would have chosen instead.
+type backtestResult struct {
+	trace *launchTrace
+
+	// the instance type chosen by the current configuration and its price,
+	// empty when the on-demand instance would have been kept
+	chosen string
+	price  float64
+}
+
+// Backtest simulates the spot instance launches recorded in the run
+// artifacts using the instance type selection settings of the given
+// configuration, such as the allowed instance types, the minimum savings,
+// the maximum interruption frequency and the scoring weights, and reports
+// the instance types which would have been chosen, with their savings and
+// their current interruption frequency range, next to the recorded ones. It
+// replays the spot prices seen by the recorded launches, so that the
+// settings can be tuned offline before being rolled out.
+func Backtest(cfg *Config, b BacktestConfig) error {
+
+	if logger == nil {
+		disableLogging()
+	}
+
+	if cfg == nil || cfg.InstanceData == nil {
+		return fmt.Errorf("missing instance type data in the configuration")
+	}
+
+	bucket, prefix, err := parseS3URL(b.Source)
+	if err != nil {
+		return err
+	}
+
+	sess := newBucketSession(cfg, bucket, b.Region)
+
+	artifacts, err := fetchRunArtifacts(s3.New(sess), bucket, prefix)
+	if err != nil {
+		return err
+	}
+
+	conf := backtestConfig(cfg)
+	var results []backtestResult
+	for _, t := range backtestLaunches(artifacts, b) {
+		chosen, price := conf.backtestLaunch(t)
+		results = append(results, backtestResult{trace: t, chosen: chosen, price: price})
+	}
+	return writeBacktestReport(b.Output, results, conf.spotAdvisor.load())
+}
+
+// backtestConfig keeps the instance type selection settings of the
+// configuration, without the ones depending on the live state of the
+// accounts, such as the placement weight, the EBS costs or the launch
+// permissions, which are already reflected by the recorded launches.
+func backtestConfig(cfg *Config) *Config {
+	conf := &Config{
+		AutoScalingConfig: cfg.AutoScalingConfig,
+		InstanceData:      cfg.InstanceData,
+		spotAdvisor:       &spotAdvisor{},
+	}
+	conf.PlacementWeight = 0
+	return conf
+}
+
+// backtestLaunches returns the recorded launches within the configured time
+// interval, in chronological order.
+func backtestLaunches(artifacts []runArtifact, b BacktestConfig) []*launchTrace {
+	var launches []*launchTrace
+	for _, artifact := range artifacts {
+		for _, t := range artifact.Launches {
+			if (!b.From.IsZero() && t.Time.Before(b.From)) || (!b.To.IsZero() && t.Time.After(b.To)) ||
+				(b.AutoScalingGroup != "" && t.AutoScalingGroup != b.AutoScalingGroup) {
+				continue
+			}
+			launches = append(launches, t)
+		}
+	}
+	sort.SliceStable(launches, func(x, y int) bool { return launches[x].Time.Before(launches[y].Time) })
+	return launches
+}
+
+// backtestLaunch returns the instance type and the price of the spot instance
+// which the configuration would have launched for the recorded launch, given
+// the spot prices of its candidates. The candidates which failed to launch at
+// the time, such as for lack of capacity, are skipped like they were then.
+func (cfg *Config) backtestLaunch(t *launchTrace) (string, float64) {
+	var prices []*ec2.SpotPrice
+	failed := map[string]bool{}
+	for _, c := range t.Candidates {
+		if c.Price > 0 {
+			prices = append(prices, &ec2.SpotPrice{
+				InstanceType:     aws.String(c.InstanceType),
+				AvailabilityZone: aws.String(t.AvailabilityZone),
+				SpotPrice:        aws.String(strconv.FormatFloat(c.Price, 'f', -1, 64)),
+			})
+		}
+		if c.Rank > 0 && len(c.Excluded) > 0 {
+			failed[c.InstanceType] = true
+		}
+	}
+
+	i, err := newOfflineInstance(cfg, t.Region, ec2.Instance{
+		InstanceType: aws.String(t.OnDemandInstanceType),
+		Placement:    &ec2.Placement{AvailabilityZone: aws.String(t.AvailabilityZone)},
+	}, prices)
+	if err != nil {
+		logger.Println("Skipping the launch of", t.AutoScalingGroup, "at", t.Time, err.Error())
+		return "", 0
+	}
+	i.price = t.OnDemandPrice
+	i.asg.name = t.AutoScalingGroup
+	i.asg.config = cfg.AutoScalingConfig
+
+	types, err := i.getCompatibleSpotInstanceTypesListSortedAscendingByPrice(
+		i.asg.getAllowedInstanceTypes(i), i.asg.getDisallowedInstanceTypes())
+	if err != nil {
+		return "", 0
+	}
+	for _, it := range types {
+		if !failed[it.instanceType] {
+			return it.instanceType, i.calculatePrice(it)
+		}
+	}
+	return "", 0
+}
+
+// backtestSummary aggregates the recorded and simulated launches of a group.
+type backtestSummary struct {
+	launches, changed int
+
+	// the on-demand prices of all the launches and the savings of the spot
+	// instances launched instead, per hour
+	onDemand, recordedSavings, backtestSavings float64
+
+	// the number of launches in each interruption frequency range
+	recordedRanges, backtestRanges map[string]int
+}
+
+func (s *backtestSummary) add(r backtestResult, recordedPrice float64, recordedRange, backtestRange string) {
+	s.launches++
+	if r.chosen != r.trace.Chosen {
+		s.changed++
+	}
+	s.onDemand += r.trace.OnDemandPrice
+	if r.trace.Chosen != "" {
+		s.recordedSavings += r.trace.OnDemandPrice - recordedPrice
+		s.recordedRanges[recordedRange]++
+	}
+	if r.chosen != "" {
+		s.backtestSavings += r.trace.OnDemandPrice - r.price
+		s.backtestRanges[backtestRange]++
+	}
+}
+
+func savingsPercentage(savings, onDemand float64) float64 {
+	if onDemand <= 0 {
+		return 0
+	}
+	return 100 * savings / onDemand
+}
+
+// formatRanges lists the number of launches of each interruption frequency
+// range, such as "<5%: 3, >20%: 1".
+func formatRanges(ranges map[string]int) string {
+	var labels []string
+	for label := range ranges {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+
+	var parts []string
+	for _, label := range labels {
+		parts = append(parts, fmt.Sprintf("%s: %d", label, ranges[label]))
+	}
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func writeBacktestReport(out io.Writer, results []backtestResult, advisor *spotAdvisorData) error {
+	groups := map[string][]backtestResult{}
+	var names []string
+	for _, r := range results {
+		name := r.trace.Region + " " + r.trace.AutoScalingGroup
+		if groups[name] == nil {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], r)
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		fmt.Fprintln(out, "No launches found")
+		return nil
+	}
+
+	interruptions := func(region, instanceType string) string {
+		if instanceType == "" {
+			return "-"
+		}
+		if label, ok := advisor.interruptionRange(region, "Linux", instanceType); ok {
+			return label
+		}
+		return "unknown"
+	}
+	orNone := func(instanceType string) string {
+		if instanceType == "" {
+			return "on-demand"
+		}
+		return instanceType
+	}
+
+	for _, name := range names {
+		summary := backtestSummary{recordedRanges: map[string]int{}, backtestRanges: map[string]int{}}
+		var table strings.Builder
+		w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "TIME\tON-DEMAND\tPRICE\tRECORDED\tPRICE\tINTERRUPTIONS\tBACKTEST\tPRICE\tINTERRUPTIONS")
+		for _, r := range groups[name] {
+			t := r.trace
+			var recordedPrice float64
+			if c := t.candidate(t.Chosen); c != nil {
+				recordedPrice = c.Price
+			}
+			recordedRange := interruptions(t.Region, t.Chosen)
+			backtestRange := interruptions(t.Region, r.chosen)
+			summary.add(r, recordedPrice, recordedRange, backtestRange)
+
+			fmt.Fprintf(w, "%s\t%s\t%.5f\t%s\t%.5f\t%s\t%s\t%.5f\t%s\n",
+				t.Time.UTC().Format(time.RFC3339), t.OnDemandInstanceType, t.OnDemandPrice,
+				orNone(t.Chosen), recordedPrice, recordedRange, orNone(r.chosen), r.price, backtestRange)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "%s: %d launches, %d decisions changed, savings %.1f%% recorded and %.1f%% backtested\n",
+			name, summary.launches, summary.changed,
+			savingsPercentage(summary.recordedSavings, summary.onDemand),
+			savingsPercentage(summary.backtestSavings, summary.onDemand))
+		fmt.Fprintf(out, "Interruption frequency ranges recorded: %s; backtested: %s\n",
+			formatRanges(summary.recordedRanges), formatRanges(summary.backtestRanges))
+		fmt.Fprintln(out, table.String())
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package autospotting
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testLaunchTrace(asg string, at time.Time, chosen string, candidates ...*traceCandidate) *launchTrace {
+	return &launchTrace{
+		Region:               "us-east-1",
+		AutoScalingGroup:     asg,
+		OnDemandInstanceType: "m5.large",
+		OnDemandPrice:        0.096,
+		AvailabilityZone:     "us-east-1a",
+		Time:                 at,
+		Chosen:               chosen,
+		Candidates:           candidates,
+	}
+}
+
+func TestBacktestLaunches(t *testing.T) {
+	at := func(hour int) time.Time { return time.Date(2020, 11, 1, hour, 0, 0, 0, time.UTC) }
+	artifacts := []runArtifact{
+		{Launches: []*launchTrace{testLaunchTrace("web", at(12), ""), testLaunchTrace("api", at(11), "")}},
+		{Launches: []*launchTrace{testLaunchTrace("web", at(10), ""), testLaunchTrace("web", at(8), "")}},
+	}
+
+	tests := []struct {
+		name string
+		b    BacktestConfig
+		want []time.Time
+	}{
+		{
+			name: "all launches in chronological order",
+			want: []time.Time{at(8), at(10), at(11), at(12)},
+		},
+		{
+			name: "single group within the interval",
+			b:    BacktestConfig{AutoScalingGroup: "web", From: at(9), To: at(12)},
+			want: []time.Time{at(10), at(12)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []time.Time
+			for _, l := range backtestLaunches(artifacts, tt.b) {
+				got = append(got, l.Time)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("backtestLaunches() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("backtestLaunches() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBacktestLaunch(t *testing.T) {
+	disableLogging()
+	at := time.Date(2020, 11, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		config     AutoScalingConfig
+		trace      *launchTrace
+		wantChosen string
+		wantPrice  float64
+	}{
+		{
+			name: "cheapest compatible candidate",
+			trace: testLaunchTrace("web", at, "m5.large",
+				&traceCandidate{InstanceType: "m5.large", Price: 0.04, Rank: 2},
+				&traceCandidate{InstanceType: "m5.xlarge", Price: 0.03, Rank: 1}),
+			wantChosen: "m5.xlarge",
+			wantPrice:  0.03,
+		},
+		{
+			name:   "disallowed instance type",
+			config: AutoScalingConfig{DisallowedInstanceTypes: "m5.xlarge"},
+			trace: testLaunchTrace("web", at, "m5.xlarge",
+				&traceCandidate{InstanceType: "m5.large", Price: 0.04, Rank: 2},
+				&traceCandidate{InstanceType: "m5.xlarge", Price: 0.03, Rank: 1}),
+			wantChosen: "m5.large",
+			wantPrice:  0.04,
+		},
+		{
+			name: "candidate which failed to launch",
+			trace: testLaunchTrace("web", at, "m5.large",
+				&traceCandidate{InstanceType: "m5.large", Price: 0.04, Rank: 2},
+				&traceCandidate{InstanceType: "m5.xlarge", Price: 0.03, Rank: 1,
+					Excluded: []string{"InsufficientInstanceCapacity"}}),
+			wantChosen: "m5.large",
+			wantPrice:  0.04,
+		},
+		{
+			name:  "no spot prices",
+			trace: testLaunchTrace("web", at, ""),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := backtestConfig(&Config{AutoScalingConfig: tt.config, InstanceData: testInstanceData()})
+			chosen, price := cfg.backtestLaunch(tt.trace)
+			if chosen != tt.wantChosen || price != tt.wantPrice {
+				t.Errorf("backtestLaunch() = %q, %v, want %q, %v", chosen, price, tt.wantChosen, tt.wantPrice)
+			}
+		})
+	}
+}
+
+func TestWriteBacktestReport(t *testing.T) {
+	var advisor spotAdvisorData
+	if err := json.Unmarshal([]byte(testSpotAdvisorData), &advisor); err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2020, 11, 1, 10, 0, 0, 0, time.UTC)
+
+	results := []backtestResult{
+		{
+			trace: testLaunchTrace("web", at, "c5.large",
+				&traceCandidate{InstanceType: "c5.large", Price: 0.048, Rank: 1}),
+			chosen: "m5.large",
+			price:  0.024,
+		},
+		{
+			trace:  testLaunchTrace("web", at.Add(time.Hour), ""),
+			chosen: "",
+		},
+	}
+
+	var out bytes.Buffer
+	if err := writeBacktestReport(&out, results, &advisor); err != nil {
+		t.Fatalf("writeBacktestReport() error = %v", err)
+	}
+	for _, want := range []string{
+		"us-east-1 web: 2 launches, 1 decisions changed, savings 25.0% recorded and 37.5% backtested",
+		"Interruption frequency ranges recorded: >20%: 1; backtested: <5%: 1",
+		"2020-11-01T10:00:00Z  m5.large   0.09600  c5.large   0.04800  >20%           m5.large   0.02400  <5%",
+		"2020-11-01T11:00:00Z  m5.large   0.09600  on-demand  0.00000  -              on-demand  0.00000  -",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("writeBacktestReport() printed:\n%s\nmissing %q", out.String(), want)
+		}
+	}
+
+	out.Reset()
+	writeBacktestReport(&out, nil, &advisor)
+	if got := out.String(); got != "No launches found\n" {
+		t.Errorf("writeBacktestReport() printed %q for no launches", got)
+	}
+}
@@ -0,0 +1,265 @@
+package autospotting
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/batch"
+	"github.com/aws/aws-sdk-go/service/batch/batchiface"
+)
+
+// The type of a Batch compute environment can't be changed after its
+// creation, so on-demand compute environments are converted by creating a
+// spot clone of them, which is then placed ahead of the original in all the
+// job queues using it. The original compute environment is kept as fallback
+// capacity for when spot instances aren't available.
+
+// batchSpotSuffix is appended to the name of an on-demand compute environment
+// for naming its spot clone.
+const batchSpotSuffix = "-autospotting"
+
+// maxComputeEnvironmentsPerQueue is the maximum number of compute
+// environments that can be attached to a Batch job queue.
+const maxComputeEnvironmentsPerQueue = 3
+
+// batchComputeEnvironments returns the names of the Batch compute
+// environments configured to be converted to spot.
+func (c *Config) batchComputeEnvironments() []string {
+	return strings.FieldsFunc(c.BatchComputeEnvironments, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+// processBatchComputeEnvironments converts the configured Batch compute
+// environments from all the enabled regions.
+func processBatchComputeEnvironments(regions []string, cfg *Config) {
+	for _, name := range regions {
+		r := region{name: name, conf: cfg}
+		if !r.enabled() {
+			continue
+		}
+		convertBatchComputeEnvironments(batch.New(newSession(cfg, name)), name, cfg)
+	}
+}
+
+func convertBatchComputeEnvironments(svc batchiface.BatchAPI, region string, cfg *Config) {
+	names := cfg.batchComputeEnvironments()
+
+	var environments []*batch.ComputeEnvironmentDetail
+	input := &batch.DescribeComputeEnvironmentsInput{}
+	for {
+		out, err := svc.DescribeComputeEnvironments(input)
+		if err != nil {
+			logger.Println(region, "Failed to describe the Batch compute environments", err.Error())
+			return
+		}
+		environments = append(environments, out.ComputeEnvironments...)
+		if out.NextToken == nil {
+			break
+		}
+		input.NextToken = out.NextToken
+	}
+
+	byName := make(map[string]*batch.ComputeEnvironmentDetail)
+	for _, ce := range environments {
+		byName[*ce.ComputeEnvironmentName] = ce
+	}
+
+	for _, name := range names {
+		ce, ok := byName[name]
+		if !ok {
+			debug.Println(region, "Batch compute environment", name, "not found")
+			continue
+		}
+		if !isConvertibleComputeEnvironment(ce) {
+			logger.Println(region, "Batch compute environment", name,
+				"is not a valid managed on-demand compute environment, skipping it")
+			continue
+		}
+
+		spot, ok := byName[name+batchSpotSuffix]
+		if !ok {
+			createSpotComputeEnvironment(svc, region, ce, cfg)
+			continue
+		}
+
+		if aws.StringValue(spot.Status) != batch.CEStatusValid {
+			logger.Println(region, "Spot compute environment", *spot.ComputeEnvironmentName,
+				"is", aws.StringValue(spot.Status), "not adding it to job queues yet")
+			continue
+		}
+
+		if err := prioritizeSpotComputeEnvironment(svc, region, ce, spot); err != nil {
+			logger.Println(region, "Failed to update the job queues of", name, err.Error())
+		}
+	}
+}
+
+func isConvertibleComputeEnvironment(ce *batch.ComputeEnvironmentDetail) bool {
+	return aws.StringValue(ce.Type) == batch.CETypeManaged &&
+		aws.StringValue(ce.Status) == batch.CEStatusValid &&
+		ce.ComputeResources != nil &&
+		aws.StringValue(ce.ComputeResources.Type) == batch.CRTypeEc2
+}
+
+// spotInstanceTypes returns the instance types of the on-demand compute
+// environment which aren't disallowed by the global configuration.
+func spotInstanceTypes(types []*string, cfg *Config) []*string {
+	disallowed := strings.Fields(strings.Replace(cfg.DisallowedInstanceTypes, ",", " ", -1))
+
+	var result []*string
+	for _, t := range types {
+		allowed := true
+		for _, d := range disallowed {
+			if match, _ := filepath.Match(d, *t); match {
+				allowed = false
+				break
+			}
+		}
+		if allowed {
+			result = append(result, t)
+		}
+	}
+
+	if len(result) == 0 {
+		return types
+	}
+	return result
+}
+
+func createSpotComputeEnvironment(svc batchiface.BatchAPI, region string,
+	ce *batch.ComputeEnvironmentDetail, cfg *Config) {
+
+	if cfg.BatchSpotFleetRole == "" {
+		logger.Println(region, "Can't convert Batch compute environment", *ce.ComputeEnvironmentName,
+			"without a spot fleet role, see the -batch_spot_fleet_role option")
+		return
+	}
+
+	od := ce.ComputeResources
+	name := *ce.ComputeEnvironmentName + batchSpotSuffix
+
+	resources := &batch.ComputeResource{
+		Type:             aws.String(batch.CRTypeSpot),
+		BidPercentage:    aws.Int64(100),
+		SpotIamFleetRole: aws.String(cfg.BatchSpotFleetRole),
+		InstanceTypes:    spotInstanceTypes(od.InstanceTypes, cfg),
+		InstanceRole:     od.InstanceRole,
+		MinvCpus:         aws.Int64(0),
+		MaxvCpus:         od.MaxvCpus,
+		DesiredvCpus:     aws.Int64(0),
+		Subnets:          od.Subnets,
+		SecurityGroupIds: od.SecurityGroupIds,
+		Ec2KeyPair:       od.Ec2KeyPair,
+		ImageId:          od.ImageId,
+		LaunchTemplate:   od.LaunchTemplate,
+		PlacementGroup:   od.PlacementGroup,
+		Tags:             od.Tags,
+	}
+
+	logger.Println(region, "Creating spot compute environment", name,
+		"for the Batch compute environment", *ce.ComputeEnvironmentName)
+
+	_, err := svc.CreateComputeEnvironment(&batch.CreateComputeEnvironmentInput{
+		ComputeEnvironmentName: aws.String(name),
+		ComputeResources:       resources,
+		ServiceRole:            ce.ServiceRole,
+		State:                  aws.String(batch.CEStateEnabled),
+		Type:                   aws.String(batch.CETypeManaged),
+	})
+	if err != nil {
+		logger.Println(region, "Failed to create spot compute environment", name, err.Error())
+	}
+}
+
+// prioritizeSpotComputeEnvironment places the spot compute environment right
+// before the on-demand one in all the job queues using the latter.
+func prioritizeSpotComputeEnvironment(svc batchiface.BatchAPI, region string,
+	onDemand, spot *batch.ComputeEnvironmentDetail) error {
+
+	var queues []*batch.JobQueueDetail
+	input := &batch.DescribeJobQueuesInput{}
+	for {
+		out, err := svc.DescribeJobQueues(input)
+		if err != nil {
+			return err
+		}
+		queues = append(queues, out.JobQueues...)
+		if out.NextToken == nil {
+			break
+		}
+		input.NextToken = out.NextToken
+	}
+
+	for _, q := range queues {
+		order, changed := spotComputeEnvironmentOrder(q.ComputeEnvironmentOrder,
+			*onDemand.ComputeEnvironmentArn, *spot.ComputeEnvironmentArn)
+		if !changed {
+			continue
+		}
+
+		if len(order) > maxComputeEnvironmentsPerQueue {
+			logger.Println(region, "Job queue", *q.JobQueueName, "already has",
+				len(q.ComputeEnvironmentOrder), "compute environments, can't add",
+				*spot.ComputeEnvironmentName)
+			continue
+		}
+
+		logger.Println(region, "Adding spot compute environment", *spot.ComputeEnvironmentName,
+			"to job queue", *q.JobQueueName)
+
+		if _, err := svc.UpdateJobQueue(&batch.UpdateJobQueueInput{
+			JobQueue:                q.JobQueueArn,
+			ComputeEnvironmentOrder: order,
+		}); err != nil {
+			return fmt.Errorf("updating job queue %s: %s", *q.JobQueueName, err.Error())
+		}
+	}
+	return nil
+}
+
+// spotComputeEnvironmentOrder computes the compute environment order of a job
+// queue with the spot compute environment inserted before the on-demand one.
+// It returns false if the queue doesn't use the on-demand compute environment
+// or already uses the spot one.
+func spotComputeEnvironmentOrder(current []*batch.ComputeEnvironmentOrder,
+	onDemandARN, spotARN string) ([]*batch.ComputeEnvironmentOrder, bool) {
+
+	sorted := make([]*batch.ComputeEnvironmentOrder, len(current))
+	copy(sorted, current)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return aws.Int64Value(sorted[i].Order) < aws.Int64Value(sorted[j].Order)
+	})
+
+	found := false
+	for _, o := range sorted {
+		switch aws.StringValue(o.ComputeEnvironment) {
+		case spotARN:
+			return nil, false
+		case onDemandARN:
+			found = true
+		}
+	}
+	if !found {
+		return nil, false
+	}
+
+	var order []*batch.ComputeEnvironmentOrder
+	add := func(arn string) {
+		order = append(order, &batch.ComputeEnvironmentOrder{
+			ComputeEnvironment: aws.String(arn),
+			Order:              aws.Int64(int64(len(order) + 1)),
+		})
+	}
+
+	for _, o := range sorted {
+		if aws.StringValue(o.ComputeEnvironment) == onDemandARN {
+			add(spotARN)
+		}
+		add(aws.StringValue(o.ComputeEnvironment))
+	}
+	return order, true
+}
@@ -0,0 +1,152 @@
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/batch"
+)
+
+func batchTestComputeEnvironment(name, crType, status string) *batch.ComputeEnvironmentDetail {
+	return &batch.ComputeEnvironmentDetail{
+		ComputeEnvironmentName: aws.String(name),
+		ComputeEnvironmentArn:  aws.String("arn:aws:batch:us-east-1:123456789012:compute-environment/" + name),
+		Type:                   aws.String(batch.CETypeManaged),
+		Status:                 aws.String(status),
+		ServiceRole:            aws.String("arn:aws:iam::123456789012:role/batch"),
+		ComputeResources: &batch.ComputeResource{
+			Type:          aws.String(crType),
+			InstanceTypes: aws.StringSlice([]string{"m5.large", "p3.2xlarge", "c5.large"}),
+			InstanceRole:  aws.String("ecsInstanceRole"),
+			MinvCpus:      aws.Int64(4),
+			MaxvCpus:      aws.Int64(256),
+			Subnets:       aws.StringSlice([]string{"subnet-1"}),
+		},
+	}
+}
+
+func TestConvertBatchComputeEnvironments(t *testing.T) {
+	cfg := &Config{
+		BatchComputeEnvironments: "reports, training,missing",
+		BatchSpotFleetRole:       "arn:aws:iam::123456789012:role/spot-fleet",
+		AutoScalingConfig: AutoScalingConfig{
+			DisallowedInstanceTypes: "p3.*",
+		},
+	}
+
+	reports := batchTestComputeEnvironment("reports", batch.CRTypeEc2, batch.CEStatusValid)
+	training := batchTestComputeEnvironment("training", batch.CRTypeEc2, batch.CEStatusValid)
+	trainingSpot := batchTestComputeEnvironment("training"+batchSpotSuffix, batch.CRTypeSpot, batch.CEStatusValid)
+
+	svc := &mockBatch{
+		dce: []*batch.ComputeEnvironmentDetail{reports, training, trainingSpot},
+		djq: []*batch.JobQueueDetail{
+			{
+				JobQueueName: aws.String("training-queue"),
+				JobQueueArn:  aws.String("arn:training-queue"),
+				ComputeEnvironmentOrder: []*batch.ComputeEnvironmentOrder{
+					{ComputeEnvironment: training.ComputeEnvironmentArn, Order: aws.Int64(1)},
+				},
+			},
+			{
+				JobQueueName: aws.String("other-queue"),
+				JobQueueArn:  aws.String("arn:other-queue"),
+				ComputeEnvironmentOrder: []*batch.ComputeEnvironmentOrder{
+					{ComputeEnvironment: aws.String("arn:other"), Order: aws.Int64(1)},
+				},
+			},
+		},
+	}
+
+	convertBatchComputeEnvironments(svc, "us-east-1", cfg)
+
+	if len(svc.cce) != 1 {
+		t.Fatalf("expected one spot compute environment to be created, got %d", len(svc.cce))
+	}
+	created := svc.cce[0]
+	if *created.ComputeEnvironmentName != "reports"+batchSpotSuffix ||
+		*created.ComputeResources.Type != batch.CRTypeSpot ||
+		*created.ComputeResources.SpotIamFleetRole != cfg.BatchSpotFleetRole ||
+		*created.ComputeResources.MinvCpus != 0 {
+		t.Errorf("unexpected compute environment: %v", created)
+	}
+	if got := aws.StringValueSlice(created.ComputeResources.InstanceTypes); !reflect.DeepEqual(got,
+		[]string{"m5.large", "c5.large"}) {
+		t.Errorf("disallowed instance types weren't removed: %v", got)
+	}
+
+	if len(svc.ujq) != 1 || *svc.ujq[0].JobQueue != "arn:training-queue" {
+		t.Fatalf("expected only the training queue to be updated, got %v", svc.ujq)
+	}
+	order := svc.ujq[0].ComputeEnvironmentOrder
+	if len(order) != 2 || *order[0].ComputeEnvironment != *trainingSpot.ComputeEnvironmentArn ||
+		*order[1].ComputeEnvironment != *training.ComputeEnvironmentArn {
+		t.Errorf("unexpected compute environment order: %v", order)
+	}
+}
+
+func TestConvertBatchComputeEnvironmentsWithoutFleetRole(t *testing.T) {
+	svc := &mockBatch{
+		dce: []*batch.ComputeEnvironmentDetail{
+			batchTestComputeEnvironment("reports", batch.CRTypeEc2, batch.CEStatusValid),
+			batchTestComputeEnvironment("spot", batch.CRTypeSpot, batch.CEStatusValid),
+		},
+	}
+
+	convertBatchComputeEnvironments(svc, "us-east-1", &Config{BatchComputeEnvironments: "reports,spot"})
+	if len(svc.cce) != 0 {
+		t.Errorf("unexpected compute environments created: %v", svc.cce)
+	}
+}
+
+func Test_spotComputeEnvironmentOrder(t *testing.T) {
+	ce := func(arn string, order int64) *batch.ComputeEnvironmentOrder {
+		return &batch.ComputeEnvironmentOrder{ComputeEnvironment: aws.String(arn), Order: aws.Int64(order)}
+	}
+	arns := func(order []*batch.ComputeEnvironmentOrder) []string {
+		var result []string
+		for _, o := range order {
+			result = append(result, *o.ComputeEnvironment)
+		}
+		return result
+	}
+
+	tests := []struct {
+		name        string
+		current     []*batch.ComputeEnvironmentOrder
+		want        []string
+		wantChanged bool
+	}{
+		{
+			name:        "inserted before the on-demand compute environment",
+			current:     []*batch.ComputeEnvironmentOrder{ce("od", 10), ce("first", 1)},
+			want:        []string{"first", "spot", "od"},
+			wantChanged: true,
+		},
+		{
+			name:    "already using the spot compute environment",
+			current: []*batch.ComputeEnvironmentOrder{ce("spot", 1), ce("od", 2)},
+		},
+		{
+			name:    "not using the on-demand compute environment",
+			current: []*batch.ComputeEnvironmentOrder{ce("other", 1)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, changed := spotComputeEnvironmentOrder(tt.current, "od", "spot")
+			if changed != tt.wantChanged {
+				t.Fatalf("changed = %t, want %t", changed, tt.wantChanged)
+			}
+			if !reflect.DeepEqual(arns(got), tt.want) {
+				t.Errorf("got %v, want %v", arns(got), tt.want)
+			}
+			for i, o := range got {
+				if *o.Order != int64(i+1) {
+					t.Errorf("unexpected order %d at position %d", *o.Order, i)
+				}
+			}
+		})
+	}
+}
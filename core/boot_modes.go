@@ -0,0 +1,179 @@
+package autospotting
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The version of the AWS SDK we use predates the boot modes of the AMIs and
+// instance types, so the DescribeImages and DescribeInstanceTypes calls
+// needing them are sent by the SDK's EC2 client with their own outputs,
+// limited to the fields we need.
+
+// bootModesAPI describes the AMIs and the instance types.
+type bootModesAPI interface {
+	DescribeImages(*describeImagesInput) (*describeImagesOutput, error)
+	DescribeInstanceTypes(*describeInstanceTypesInput) (*describeInstanceTypesOutput, error)
+}
+
+type describeImagesInput struct {
+	_ struct{} `type:"structure"`
+
+	ImageIds []*string `locationName:"ImageId" locationNameList:"ImageId" type:"list"`
+}
+
+type describeImagesOutput struct {
+	_ struct{} `type:"structure"`
+
+	Images []*imageDescription `locationName:"imagesSet" locationNameList:"item" type:"list"`
+}
+
+type imageDescription struct {
+	_ struct{} `type:"structure"`
+
+	Architecture       *string `locationName:"architecture" type:"string"`
+	BootMode           *string `locationName:"bootMode" type:"string"`
+	EnaSupport         *bool   `locationName:"enaSupport" type:"boolean"`
+	ImageID            *string `locationName:"imageId" type:"string"`
+	Name               *string `locationName:"name" type:"string"`
+	VirtualizationType *string `locationName:"virtualizationType" type:"string"`
+}
+
+type describeInstanceTypesInput struct {
+	_ struct{} `type:"structure"`
+
+	NextToken *string `type:"string"`
+}
+
+type describeInstanceTypesOutput struct {
+	_ struct{} `type:"structure"`
+
+	InstanceTypes []*instanceTypeDescription `locationName:"instanceTypeSet" locationNameList:"item" type:"list"`
+	NextToken     *string                    `locationName:"nextToken" type:"string"`
+}
+
+type instanceTypeDescription struct {
+	_ struct{} `type:"structure"`
+
+	EbsInfo                      *ec2.EbsInfo       `locationName:"ebsInfo" type:"structure"`
+	InstanceType                 *string            `locationName:"instanceType" type:"string"`
+	NetworkInfo                  *ec2.NetworkInfo   `locationName:"networkInfo" type:"structure"`
+	ProcessorInfo                *ec2.ProcessorInfo `locationName:"processorInfo" type:"structure"`
+	SupportedBootModes           []*string          `locationName:"supportedBootModes" locationNameList:"item" type:"list"`
+	SupportedVirtualizationTypes []*string          `locationName:"supportedVirtualizationTypes" locationNameList:"item" type:"list"`
+}
+
+type bootModesClient struct {
+	svc *ec2.EC2
+}
+
+// DescribeImages describes the given AMIs.
+func (c bootModesClient) DescribeImages(input *describeImagesInput) (*describeImagesOutput, error) {
+	output := &describeImagesOutput{}
+	return output, c.send("DescribeImages", input, output)
+}
+
+// DescribeInstanceTypes describes a page of the instance types offered in
+// the region.
+func (c bootModesClient) DescribeInstanceTypes(input *describeInstanceTypesInput) (*describeInstanceTypesOutput, error) {
+	output := &describeInstanceTypesOutput{}
+	return output, c.send("DescribeInstanceTypes", input, output)
+}
+
+func (c bootModesClient) send(name string, input, output interface{}) error {
+	op := &request.Operation{
+		Name:       name,
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+	return c.svc.NewRequest(op, input, output).Send()
+}
+
+// imageBootMode returns the boot mode of the AMI, which defaults to UEFI on
+// arm64 and to legacy BIOS on x86_64 when it isn't set.
+func imageBootMode(image *imageDescription) string {
+	if mode := aws.StringValue(image.BootMode); mode != "" {
+		return mode
+	}
+	if aws.StringValue(image.Architecture) == ec2.ArchitectureValuesArm64 {
+		return "uefi"
+	}
+	return "legacy-bios"
+}
+
+// bootModeCache keeps the boot modes of the AMIs and of the instance types of
+// a region, described once per run. The boot modes aren't checked for the
+// rest of the run once describing them failed.
+type bootModeCache struct {
+	mu            sync.Mutex
+	images        map[string]string
+	instanceTypes map[string][]string
+	disabled      bool
+}
+
+// bootModes returns the boot mode of the AMI and the boot modes supported by
+// the instance type, or false if they can't be determined.
+func (r *region) bootModes(image, instanceType string) (string, []string, bool) {
+	svc := r.services.bootModes
+	if svc == nil || image == "" {
+		return "", nil, false
+	}
+
+	c := &r.bootModeCache
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.disabled {
+		return "", nil, false
+	}
+
+	if c.instanceTypes == nil {
+		types := map[string][]string{}
+		input := &describeInstanceTypesInput{}
+		for {
+			out, err := svc.DescribeInstanceTypes(input)
+			if err != nil {
+				logger.Println(r.name, "Not checking the boot modes, failed to describe the instance types:",
+					err.Error())
+				c.disabled = true
+				return "", nil, false
+			}
+			for _, t := range out.InstanceTypes {
+				types[aws.StringValue(t.InstanceType)] = aws.StringValueSlice(t.SupportedBootModes)
+			}
+			if aws.StringValue(out.NextToken) == "" {
+				break
+			}
+			input.NextToken = out.NextToken
+		}
+		c.instanceTypes = types
+		c.images = map[string]string{}
+	}
+
+	mode, ok := c.images[image]
+	if !ok {
+		out, err := svc.DescribeImages(&describeImagesInput{ImageIds: []*string{aws.String(image)}})
+		if err != nil || len(out.Images) == 0 {
+			logger.Println(r.name, "Couldn't determine the boot mode of the AMI", image)
+		} else {
+			mode = imageBootMode(out.Images[0])
+		}
+		c.images[image] = mode
+	}
+	return mode, c.instanceTypes[instanceType], mode != ""
+}
+
+// isBootModeCompatible returns true if the candidate instance type can boot
+// the AMI of its spot instance, or if their boot modes aren't known.
+func (i *instance) isBootModeCompatible(spotCandidate instanceTypeInformation) bool {
+	mode, supported, ok := i.region.bootModes(aws.StringValue(i.spotImage(spotCandidate.instanceType)),
+		spotCandidate.instanceType)
+	if !ok || len(supported) == 0 || mode == "uefi-preferred" || indexOf(supported, mode) >= 0 {
+		return true
+	}
+	logger.Println("\tDoesn't support the", mode, "boot mode of the AMI")
+	return false
+}
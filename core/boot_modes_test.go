@@ -0,0 +1,88 @@
+package autospotting
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func TestIsBootModeCompatible(t *testing.T) {
+	types := &describeInstanceTypesOutput{InstanceTypes: []*instanceTypeDescription{
+		testInstanceType("m4.large", "x86_64", "supported", "unsupported", "legacy-bios"),
+		testInstanceType("m6i.large", "x86_64", "required", "required", "legacy-bios", "uefi"),
+		testInstanceType("t2.micro", "x86_64", "unsupported", "unsupported"),
+	}}
+	image := func(mode string) *describeImagesOutput {
+		return &describeImagesOutput{Images: []*imageDescription{{
+			Architecture: aws.String("x86_64"), BootMode: aws.String(mode),
+		}}}
+	}
+
+	tests := []struct {
+		name         string
+		svc          bootModesAPI
+		instanceType string
+		want         bool
+	}{
+		{
+			name:         "UEFI supported",
+			svc:          &mockBootModes{dimo: image("uefi"), ditp: []*describeInstanceTypesOutput{types}},
+			instanceType: "m6i.large",
+			want:         true,
+		},
+		{
+			name:         "UEFI not supported",
+			svc:          &mockBootModes{dimo: image("uefi"), ditp: []*describeInstanceTypesOutput{types}},
+			instanceType: "m4.large",
+		},
+		{
+			name:         "UEFI preferred",
+			svc:          &mockBootModes{dimo: image("uefi-preferred"), ditp: []*describeInstanceTypesOutput{types}},
+			instanceType: "m4.large",
+			want:         true,
+		},
+		{
+			name:         "legacy BIOS by default",
+			svc:          &mockBootModes{dimo: image(""), ditp: []*describeInstanceTypesOutput{types}},
+			instanceType: "m4.large",
+			want:         true,
+		},
+		{
+			name:         "boot modes of the instance type unknown",
+			svc:          &mockBootModes{dimo: image("uefi"), ditp: []*describeInstanceTypesOutput{types}},
+			instanceType: "t2.micro",
+			want:         true,
+		},
+		{
+			name:         "AMI not found",
+			svc:          &mockBootModes{dimo: &describeImagesOutput{}, ditp: []*describeInstanceTypesOutput{types}},
+			instanceType: "m4.large",
+			want:         true,
+		},
+		{
+			name:         "describing the instance types failing",
+			svc:          &mockBootModes{dimo: image("uefi"), diterr: errors.New("UnauthorizedOperation")},
+			instanceType: "m4.large",
+			want:         true,
+		},
+		{
+			name:         "no client",
+			instanceType: "m4.large",
+			want:         true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := newReplacementBatchGroup(1, mockEC2{}).instances.get("i-ondemand-0")
+			i.ImageId = aws.String("ami-1")
+			i.region.services.bootModes = tt.svc
+
+			for n := 0; n < 2; n++ {
+				if got := i.isBootModeCompatible(instanceTypeInformation{instanceType: tt.instanceType}); got != tt.want {
+					t.Errorf("isBootModeCompatible() = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package autospotting
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+const (
+	// surplusCreditPrice is the hourly price of a vCPU running above the
+	// baseline of a burstable instance in unlimited mode, for Linux.
+	surplusCreditPrice = 0.05
+
+	// cpuLoadPeriod is how far back the CPU utilization of the on-demand
+	// instances is averaged when estimating the CPU credit surcharge.
+	cpuLoadPeriod = 24 * time.Hour
+)
+
+// unlimitedBurstableFamilies are the burstable instance families launched in
+// unlimited mode by default, whose surplus CPU credits are charged.
+var unlimitedBurstableFamilies = map[string]bool{
+	"t3":  true,
+	"t3a": true,
+	"t4g": true,
+}
+
+// burstableBaselines is the baseline utilization per vCPU of the burstable
+// instance sizes, which can be sustained without spending surplus credits.
+var burstableBaselines = map[string]float64{
+	"nano":    0.05,
+	"micro":   0.10,
+	"small":   0.20,
+	"medium":  0.20,
+	"large":   0.30,
+	"xlarge":  0.40,
+	"2xlarge": 0.40,
+}
+
+// cpuCreditSurcharge returns the expected hourly cost of the surplus CPU
+// credits spent by an instance of the candidate type in unlimited mode when
+// running the load of this instance, or 0 for other instance types.
+func (i *instance) cpuCreditSurcharge(candidate instanceTypeInformation) float64 {
+	parts := strings.SplitN(candidate.instanceType, ".", 2)
+	if len(parts) != 2 || !unlimitedBurstableFamilies[parts[0]] || candidate.vCPU == 0 {
+		return 0
+	}
+
+	baseline, ok := burstableBaselines[parts[1]]
+	if !ok {
+		return 0
+	}
+
+	surplus := i.averageCPULoad() - baseline*float64(candidate.vCPU)
+	if surplus <= 0 {
+		return 0
+	}
+	return surplus * surplusCreditPrice
+}
+
+// averageCPULoad returns the average number of vCPUs used by the instance
+// over the last cpuLoadPeriod, according to its CloudWatch CPU utilization,
+// or 0 if it isn't available.
+func (i *instance) averageCPULoad() float64 {
+	if i.cpuLoad != nil {
+		return *i.cpuLoad
+	}
+	i.cpuLoad = aws.Float64(0)
+
+	if i.region == nil || i.region.services.cloudWatch == nil {
+		return 0
+	}
+	svc := i.region.services.cloudWatch
+
+	now := time.Now()
+	out, err := svc.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
+		Namespace:  aws.String("AWS/EC2"),
+		MetricName: aws.String("CPUUtilization"),
+		Dimensions: []*cloudwatch.Dimension{{
+			Name:  aws.String("InstanceId"),
+			Value: i.InstanceId,
+		}},
+		StartTime:  aws.Time(now.Add(-cpuLoadPeriod)),
+		EndTime:    aws.Time(now),
+		Period:     aws.Int64(int64(cpuLoadPeriod / time.Second)),
+		Statistics: []*string{aws.String(cloudwatch.StatisticAverage)},
+	})
+	if err != nil {
+		logger.Println("Failed to get the CPU utilization of", *i.InstanceId, err.Error())
+		return 0
+	}
+	if len(out.Datapoints) == 0 {
+		return 0
+	}
+
+	var sum float64
+	for _, d := range out.Datapoints {
+		sum += aws.Float64Value(d.Average)
+	}
+	load := sum / float64(len(out.Datapoints)) / 100 * float64(i.typeInfo.vCPU)
+
+	debug.Println("Average CPU load of", *i.InstanceId, "is", load, "vCPUs")
+	i.cpuLoad = aws.Float64(load)
+	return load
+}
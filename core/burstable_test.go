@@ -0,0 +1,69 @@
+package autospotting
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestInstanceCPUCreditSurcharge(t *testing.T) {
+	// an m5.large running at 60% uses 1.2 vCPUs on average
+	utilization := &cloudwatch.GetMetricStatisticsOutput{
+		Datapoints: []*cloudwatch.Datapoint{
+			{Average: aws.Float64(50)},
+			{Average: aws.Float64(70)},
+		},
+	}
+
+	tests := []struct {
+		name      string
+		candidate instanceTypeInformation
+		gmserr    error
+		want      float64
+	}{
+		{
+			name:      "not burstable",
+			candidate: instanceTypeInformation{instanceType: "m5.large", vCPU: 2},
+		},
+		{
+			name:      "standard mode by default",
+			candidate: instanceTypeInformation{instanceType: "t2.large", vCPU: 2},
+		},
+		{
+			name:      "above the baseline",
+			candidate: instanceTypeInformation{instanceType: "t3.large", vCPU: 2},
+			want:      (1.2 - 0.6) * surplusCreditPrice,
+		},
+		{
+			name:      "below the baseline",
+			candidate: instanceTypeInformation{instanceType: "t3.2xlarge", vCPU: 8},
+		},
+		{
+			name:      "metrics unavailable",
+			candidate: instanceTypeInformation{instanceType: "t3.large", vCPU: 2},
+			gmserr:    errors.New("AccessDenied"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cw := &mockCloudWatch{gms: utilization, gmserr: tt.gmserr}
+			i := &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("i-ondemand")},
+				typeInfo: instanceTypeInformation{instanceType: "m5.large", vCPU: 2},
+				region:   &region{services: connections{cloudWatch: cw}},
+			}
+
+			if got := i.cpuCreditSurcharge(tt.candidate); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("cpuCreditSurcharge() = %v, want %v", got, tt.want)
+			}
+			i.cpuCreditSurcharge(tt.candidate)
+			if cw.calls > 1 {
+				t.Errorf("expected the CPU load to be loaded once, got %d calls", cw.calls)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package autospotting
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// canaryGroups returns the names of the groups configured as canaries.
+func (c *Config) canaryGroups() []string {
+	return strings.FieldsFunc(c.CanaryASGs, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+// isCanary returns true if the group with the given name is a canary.
+func (c *Config) isCanary(name string) bool {
+	for _, g := range c.canaryGroups() {
+		if g == name {
+			return true
+		}
+	}
+	return false
+}
+
+// processCanaries processes the canary groups from all the enabled regions,
+// before any other group. It returns an error if any of their replacements
+// failed, in which case the rest of the run should be aborted.
+func processCanaries(regions []string, cfg *Config) error {
+	var failures []string
+
+	for _, name := range regions {
+		r := region{name: name, conf: cfg, services: connections{provider: cfg.ClientProvider, profile: cfg.profile, credentials: cfg.credentials, audit: cfg.audit}}
+		if !r.enabled() {
+			continue
+		}
+		for _, err := range r.processCanaryGroups() {
+			failures = append(failures, err.Error())
+		}
+	}
+
+	if len(failures) > 0 {
+		return fmt.Errorf("canary replacements failed: %s", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// processCanaryGroups processes the enabled canary groups of the region one
+// after the other, and returns the errors of their replacements, except those
+// blocked in audit mode.
+func (r *region) processCanaryGroups() []error {
+	r.services.connect(r.name)
+	r.setupAsgFilters()
+
+	var groups []*autoscaling.Group
+	err := r.services.autoScaling.DescribeAutoScalingGroupsPages(
+		&autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: aws.StringSlice(r.conf.canaryGroups()),
+		},
+		func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+			groups = append(groups, page.AutoScalingGroups...)
+			return true
+		},
+	)
+	if err != nil {
+		logger.Println(r.name, "Failed to describe the canary groups", err.Error())
+		return []error{fmt.Errorf("%s: %s", r.name, err.Error())}
+	}
+
+	r.enabledASGs = r.findMatchingASGsInPageOfResults(groups, r.tagsToFilterASGsBy)
+	if !r.hasEnabledAutoScalingGroups() {
+		return nil
+	}
+
+	r.determineInstanceTypeInformation(r.conf, r.enabledZones())
+	if err := r.scanInstances(); err != nil {
+		logger.Printf("Failed to scan instances in %s error: %s\n", r.name, err)
+		return []error{fmt.Errorf("%s: %s", r.name, err.Error())}
+	}
+
+	r.quotas = r.newSpotQuotas()
+
+	var errs []error
+	for _, asg := range r.enabledASGs {
+		asg.config = r.conf.AutoScalingConfig
+
+		logger.Println(r.name, "Processing canary group", asg.name)
+
+		// the replacements blocked in audit mode didn't fail
+		if err := asg.process(); err != nil && !isAuditModeError(err) {
+			errs = append(errs, fmt.Errorf("%s/%s: %s", r.name, asg.name, err.Error()))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,166 @@
+package autospotting
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/AutoSpotting/AutoSpotting/core/autospottingtest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+func TestConfig_isCanary(t *testing.T) {
+	cfg := &Config{CanaryASGs: "dev-web, dev-worker"}
+
+	for name, want := range map[string]bool{
+		"dev-web":    true,
+		"dev-worker": true,
+		"dev":        false,
+		"prod-web":   false,
+	} {
+		if got := cfg.isCanary(name); got != want {
+			t.Errorf("isCanary(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+// canaryTestCloud sets up a canary group and another group, each having an
+// on-demand instance. When failing is set, the canary group also has a spot
+// instance ready to be attached but placed in an availability zone without
+// on-demand instances, so its replacement fails.
+func canaryTestCloud(failing bool) *autospottingtest.Cloud {
+	cloud := autospottingtest.NewCloud()
+	fr := cloud.Region("us-east-1")
+	fr.SetSpotPrice("m5.large", "us-east-1a", 0.04)
+	fr.SetSpotPrice("m5.large", "us-east-1b", 0.04)
+	fr.AddLaunchConfiguration(&autoscaling.LaunchConfiguration{
+		LaunchConfigurationName: aws.String("lc"),
+		ImageId:                 aws.String("ami-123"),
+		InstanceType:            aws.String("m5.large"),
+	})
+
+	for _, name := range []string{"canary", "other"} {
+		id := "i-" + name
+		fr.AddInstance(&ec2.Instance{
+			InstanceId:         aws.String(id),
+			InstanceType:       aws.String("m5.large"),
+			ImageId:            aws.String("ami-123"),
+			Placement:          &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+			VirtualizationType: aws.String("hvm"),
+		})
+		fr.AddGroup(&autoscaling.Group{
+			AutoScalingGroupName:    aws.String(name),
+			LaunchConfigurationName: aws.String("lc"),
+			MinSize:                 aws.Int64(0),
+			MaxSize:                 aws.Int64(2),
+			DesiredCapacity:         aws.Int64(1),
+			HealthCheckGracePeriod:  aws.Int64(0),
+			Instances: []*autoscaling.Instance{{
+				InstanceId:           aws.String(id),
+				AvailabilityZone:     aws.String("us-east-1a"),
+				LifecycleState:       aws.String("InService"),
+				ProtectedFromScaleIn: aws.Bool(false),
+			}},
+			Tags: []*autoscaling.TagDescription{{
+				Key:          aws.String("spot-enabled"),
+				Value:        aws.String("true"),
+				ResourceId:   aws.String(name),
+				ResourceType: aws.String("auto-scaling-group"),
+			}},
+		})
+	}
+
+	if failing {
+		fr.AddInstance(&ec2.Instance{
+			InstanceId:         aws.String("i-canary-spot"),
+			InstanceType:       aws.String("m5.large"),
+			InstanceLifecycle:  aws.String("spot"),
+			LaunchTime:         aws.Time(time.Now().Add(-time.Hour)),
+			Placement:          &ec2.Placement{AvailabilityZone: aws.String("us-east-1b")},
+			VirtualizationType: aws.String("hvm"),
+			Tags: []*ec2.Tag{
+				{Key: aws.String("launched-by-autospotting"), Value: aws.String("true")},
+				{Key: aws.String("launched-for-asg"), Value: aws.String("canary")},
+			},
+		})
+	}
+	return cloud
+}
+
+func TestRunWithCanaries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failing      bool
+		wantLaunches int
+	}{
+		{name: "successful canary", failing: false, wantLaunches: 2},
+		{name: "failed canary aborts the run", failing: true, wantLaunches: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cloud := canaryTestCloud(tt.failing)
+
+			cfg := e2eTestConfig(cloud)
+			cfg.MainRegion = "us-east-1"
+			cfg.CanaryASGs = "canary"
+			Run(cfg)
+
+			launches := 0
+			for _, c := range cloud.MutatingCalls() {
+				if c.Operation == "RunInstances" {
+					launches++
+				}
+			}
+			if launches != tt.wantLaunches {
+				t.Errorf("got %d spot launches, expected %d", launches, tt.wantLaunches)
+			}
+		})
+	}
+}
+
+// auditModeCloud blocks the spot launches like the audit mode does for the
+// clients created from AWS sessions, counting them.
+type auditModeCloud struct {
+	*autospottingtest.Cloud
+	mu       sync.Mutex
+	launches int
+}
+
+func (c *auditModeCloud) EC2(region string) ec2iface.EC2API {
+	return auditModeEC2{EC2API: c.Cloud.EC2(region), cloud: c}
+}
+
+type auditModeEC2 struct {
+	ec2iface.EC2API
+	cloud *auditModeCloud
+}
+
+func (e auditModeEC2) RunInstances(in *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	if aws.BoolValue(in.DryRun) {
+		return e.EC2API.RunInstances(in)
+	}
+	e.cloud.mu.Lock()
+	e.cloud.launches++
+	e.cloud.mu.Unlock()
+	return nil, awserr.New(auditModeErrorCode, "ec2:RunInstances blocked in audit mode", nil)
+}
+
+func TestRunWithCanariesInAuditMode(t *testing.T) {
+	cloud := &auditModeCloud{Cloud: canaryTestCloud(false)}
+
+	cfg := e2eTestConfig(cloud.Cloud)
+	cfg.ClientProvider = cloud
+	cfg.MainRegion = "us-east-1"
+	cfg.CanaryASGs = "canary"
+	cfg.AuditMode = true
+	Run(cfg)
+
+	if cloud.launches != 2 {
+		t.Errorf("got %d spot launches, expected the canary not to abort the run in audit mode", cloud.launches)
+	}
+}
@@ -0,0 +1,58 @@
+package autospotting
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// checkCapacityDrift verifies that a replacement left the desired capacity of
+// the group as it was before. A drift, such as when AutoScaling backfilled an
+// on-demand instance detached without decrementing the desired capacity and
+// the spot instance was attached on top of it, is counted in the run metrics
+// and notified as a warning, and corrected when enabled.
+func (a *autoScalingGroup) checkCapacityDrift(expected int64) {
+	group, err := a.region.describeGroup(a.name)
+	if err != nil {
+		logger.Println(a.region.name, a.name, "Couldn't check the capacity after the replacement:", err.Error())
+		return
+	}
+
+	desired := aws.Int64Value(group.DesiredCapacity)
+	if desired == expected {
+		return
+	}
+	a.region.conf.metrics.capacityDrift()
+
+	message := fmt.Sprintf("The desired capacity of %s in %s is %d after replacing an instance, instead of %d.",
+		a.name, a.region.name, desired, expected)
+	logger.Println(a.region.name, a.name, message)
+
+	// the size limits may have been changed meanwhile
+	target := expected
+	if min := aws.Int64Value(group.MinSize); target < min {
+		target = min
+	}
+	if max := aws.Int64Value(group.MaxSize); target > max {
+		target = max
+	}
+
+	if !a.region.conf.CorrectCapacityDrift || target == desired {
+		notify(a.region.conf, WarningEvent, "AutoSpotting capacity drift in "+a.name, message)
+		return
+	}
+
+	logger.Println(a.region.name, a.name, "Correcting the desired capacity to", target)
+	if _, err := a.region.services.autoScaling.UpdateAutoScalingGroup(&autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: aws.String(a.name),
+		DesiredCapacity:      aws.Int64(target),
+	}); err != nil {
+		logger.Println(a.region.name, a.name, "Failed to correct the desired capacity:", err.Error())
+		notify(a.region.conf, WarningEvent, "AutoSpotting capacity drift in "+a.name,
+			message+" Correcting it failed: "+err.Error())
+		return
+	}
+	notify(a.region.conf, WarningEvent, "AutoSpotting capacity drift in "+a.name,
+		message+fmt.Sprintf(" It was corrected to %d.", target))
+}
@@ -0,0 +1,89 @@
+package autospotting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+func Test_autoScalingGroup_checkCapacityDrift(t *testing.T) {
+	tests := []struct {
+		name         string
+		desired, max int64
+		expected     int64
+		correct      bool
+		wantDrifts   int64
+		wantDesired  int64
+		wantNotified bool
+	}{
+		{
+			name:        "no drift",
+			desired:     2,
+			max:         3,
+			expected:    2,
+			wantDesired: 2,
+		},
+		{
+			name:         "drift notified",
+			desired:      3,
+			max:          3,
+			expected:     2,
+			wantDrifts:   1,
+			wantDesired:  3,
+			wantNotified: true,
+		},
+		{
+			name:         "drift corrected",
+			desired:      3,
+			max:          3,
+			expected:     2,
+			correct:      true,
+			wantDrifts:   1,
+			wantDesired:  2,
+			wantNotified: true,
+		},
+		{
+			name:         "drift corrected within the size limits",
+			desired:      1,
+			max:          2,
+			expected:     3,
+			correct:      true,
+			wantDrifts:   1,
+			wantDesired:  2,
+			wantNotified: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, fr := commandTestConfig()
+			g := fr.Group("asg")
+			g.DesiredCapacity, g.MaxSize, g.Instances = aws.Int64(tt.desired), aws.Int64(tt.max), g.Instances[:1]
+
+			a := &newTestRegion(cfg, "asg").enabledASGs[0]
+			a.scanInstances()
+			notifier := &recordingNotifier{}
+			a.region.conf.CorrectCapacityDrift = tt.correct
+			a.region.conf.metrics = newRunMetrics(time.Now())
+			a.region.conf.notificationRoutes = []NotificationRoute{{Notifier: notifier}}
+
+			a.checkCapacityDrift(tt.expected)
+
+			if a.region.conf.metrics.drifts != tt.wantDrifts {
+				t.Errorf("checkCapacityDrift() counted %d drifts, want %d",
+					a.region.conf.metrics.drifts, tt.wantDrifts)
+			}
+			if got := aws.Int64Value(fr.Group("asg").DesiredCapacity); got != tt.wantDesired {
+				t.Errorf("desired capacity = %d, want %d", got, tt.wantDesired)
+			}
+			if notified := len(notifier.sent) > 0; notified != tt.wantNotified {
+				t.Errorf("checkCapacityDrift() sent %v", notifier.sent)
+			}
+			for _, n := range notifier.sent {
+				if n.Event != WarningEvent {
+					t.Errorf("checkCapacityDrift() sent a %s notification", n.Event)
+				}
+			}
+		})
+	}
+}
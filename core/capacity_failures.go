@@ -0,0 +1,145 @@
+package autospotting
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// capacityFailureThreshold is the number of spot capacity failures of an
+// instance family in an availability zone after which the family is
+// considered short of capacity there for the rest of the run
+const capacityFailureThreshold = 2
+
+// capacityFailures remembers the spot capacity failures seen in a region
+// during the current run, so that once an availability zone ran out of spot
+// capacity for an instance family, all the groups of the region avoid it
+// instead of each of them rediscovering the same shortage.
+type capacityFailures struct {
+	mu sync.Mutex
+
+	// number of failures by availability zone and instance family
+	failures map[string]map[string]int
+}
+
+func newCapacityFailures() *capacityFailures {
+	return &capacityFailures{failures: make(map[string]map[string]int)}
+}
+
+func instanceFamily(instanceType string) string {
+	return strings.SplitN(instanceType, ".", 2)[0]
+}
+
+// record counts a spot capacity failure of the given instance type.
+func (c *capacityFailures) record(az, instanceType string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failures[az] == nil {
+		c.failures[az] = make(map[string]int)
+	}
+	family := instanceFamily(instanceType)
+	c.failures[az][family]++
+	if c.failures[az][family] == capacityFailureThreshold {
+		logger.Println(az, "is short of spot capacity for the", family,
+			"instance family, deprioritizing it for the rest of the run")
+	}
+}
+
+// isShort tells if the family of the given instance type repeatedly ran out
+// of spot capacity in the availability zone.
+func (c *capacityFailures) isShort(az, instanceType string) bool {
+	if c == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failures[az][instanceFamily(instanceType)] >= capacityFailureThreshold
+}
+
+// count returns the number of spot capacity failures of the family of the
+// given instance type in the availability zone.
+func (c *capacityFailures) count(az, instanceType string) int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.failures[az][instanceFamily(instanceType)]
+}
+
+// shortages returns the number of instance families that repeatedly ran out
+// of spot capacity in the availability zone.
+func (c *capacityFailures) shortages(az string) int {
+	if c == nil {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	count := 0
+	for _, failures := range c.failures[az] {
+		if failures >= capacityFailureThreshold {
+			count++
+		}
+	}
+	return count
+}
+
+// deprioritizeShortTypes moves the candidate instance types whose family is
+// short of spot capacity in the availability zone to the end of the list,
+// keeping the price order otherwise.
+func (c *capacityFailures) deprioritizeShortTypes(az string, candidates []instanceTypeInformation) []instanceTypeInformation {
+	var available, short []instanceTypeInformation
+	for _, candidate := range candidates {
+		if c.isShort(az, candidate.instanceType) {
+			short = append(short, candidate)
+			continue
+		}
+		available = append(available, candidate)
+	}
+	if len(short) > 0 {
+		debug.Println("Deprioritizing instance types short of spot capacity in", az, short)
+	}
+	return append(available, short...)
+}
+
+// onDemandInstanceToReplace returns an unprotected on-demand instance,
+// preferring the availability zones with the fewest spot capacity shortages
+// seen so far in the region.
+func (a *autoScalingGroup) onDemandInstanceToReplace() *instance {
+	inst := a.getAnyUnprotectedOnDemandInstance()
+	if inst == nil || a.region.capacity.shortages(aws.StringValue(inst.Placement.AvailabilityZone)) == 0 {
+		return inst
+	}
+
+	var zones []string
+	for _, az := range a.AvailabilityZones {
+		zones = append(zones, aws.StringValue(az))
+	}
+	sort.SliceStable(zones, func(i, j int) bool {
+		return a.region.capacity.shortages(zones[i]) < a.region.capacity.shortages(zones[j])
+	})
+
+	for _, az := range zones {
+		if a.region.capacity.shortages(az) >= a.region.capacity.shortages(*inst.Placement.AvailabilityZone) {
+			break
+		}
+		if candidate := a.getUnprotectedOnDemandInstanceInAZ(aws.String(az)); candidate != nil {
+			logger.Println(a.region.name, a.name, "Replacing", *candidate.InstanceId, "in", az,
+				"instead of", *inst.InstanceId, "in", *inst.Placement.AvailabilityZone,
+				"which is short of spot capacity")
+			return candidate
+		}
+	}
+	return inst
+}
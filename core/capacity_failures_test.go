@@ -0,0 +1,134 @@
+package autospotting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestCapacityFailures(t *testing.T) {
+	var disabled *capacityFailures
+	disabled.record("us-east-1a", "c5.large")
+	if disabled.isShort("us-east-1a", "c5.large") || disabled.shortages("us-east-1a") != 0 {
+		t.Errorf("nil capacityFailures reported a shortage")
+	}
+
+	c := newCapacityFailures()
+	c.record("us-east-1a", "c5.large")
+	if c.isShort("us-east-1a", "c5.xlarge") {
+		t.Errorf("a single failure was considered a shortage")
+	}
+
+	c.record("us-east-1a", "c5.2xlarge")
+	if !c.isShort("us-east-1a", "c5.xlarge") {
+		t.Errorf("repeated failures of the c5 family weren't considered a shortage")
+	}
+	if c.isShort("us-east-1b", "c5.xlarge") || c.isShort("us-east-1a", "m5.large") {
+		t.Errorf("the shortage leaked to other zones or families")
+	}
+	if got := c.shortages("us-east-1a"); got != 1 {
+		t.Errorf("shortages() = %d, want 1", got)
+	}
+
+	candidates := []instanceTypeInformation{
+		{instanceType: "c5.large"},
+		{instanceType: "m5.large"},
+		{instanceType: "c5.xlarge"},
+		{instanceType: "r5.large"},
+	}
+	var got []string
+	for _, it := range c.deprioritizeShortTypes("us-east-1a", candidates) {
+		got = append(got, it.instanceType)
+	}
+	want := []string{"m5.large", "r5.large", "c5.large", "c5.xlarge"}
+	for idx := range want {
+		if got[idx] != want[idx] {
+			t.Fatalf("deprioritizeShortTypes() = %v, want %v", got, want)
+		}
+	}
+}
+
+func TestOnDemandInstanceToReplace(t *testing.T) {
+	onDemand := func(id, az string) *instance {
+		return &instance{Instance: &ec2.Instance{
+			InstanceId: aws.String(id),
+			LaunchTime: aws.Time(time.Now().Add(-time.Hour)),
+			Placement:  &ec2.Placement{AvailabilityZone: aws.String(az)},
+			State:      &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		}}
+	}
+
+	tests := []struct {
+		name    string
+		members instanceMap
+		short   []string
+		want    string
+	}{
+		{
+			name:    "no shortages",
+			members: instanceMap{"i-a": onDemand("i-a", "us-east-1a")},
+			want:    "i-a",
+		},
+		{
+			name:    "only instance in a zone short of capacity",
+			members: instanceMap{"i-a": onDemand("i-a", "us-east-1a")},
+			short:   []string{"us-east-1a"},
+			want:    "i-a",
+		},
+		{
+			name: "instance in another zone",
+			members: instanceMap{
+				"i-a": onDemand("i-a", "us-east-1a"),
+				"i-b": onDemand("i-b", "us-east-1b"),
+			},
+			short: []string{"us-east-1a"},
+			want:  "i-b",
+		},
+		{
+			name: "zone with fewer shortages",
+			members: instanceMap{
+				"i-a": onDemand("i-a", "us-east-1a"),
+				"i-b": onDemand("i-b", "us-east-1b"),
+			},
+			short: []string{"us-east-1a", "us-east-1a", "us-east-1b"},
+			want:  "i-b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			capacity := newCapacityFailures()
+			families := map[string]int{}
+			for _, az := range tt.short {
+				// each entry makes a new family short of capacity in the zone
+				families[az]++
+				instanceType := string(rune('a'+families[az])) + "5.large"
+				capacity.record(az, instanceType)
+				capacity.record(az, instanceType)
+			}
+
+			asg := &autoScalingGroup{
+				name: "asg",
+				Group: &autoscaling.Group{AvailabilityZones: []*string{
+					aws.String("us-east-1a"), aws.String("us-east-1b"),
+				}},
+				instances: makeInstancesWithCatalog(tt.members),
+				region: &region{
+					capacity: capacity,
+					services: connections{ec2: mockEC2{diao: &ec2.DescribeInstanceAttributeOutput{}}},
+				},
+			}
+			for _, inst := range tt.members {
+				inst.asg = asg
+				inst.region = asg.region
+			}
+
+			got := asg.onDemandInstanceToReplace()
+			if got == nil || *got.InstanceId != tt.want {
+				t.Errorf("onDemandInstanceToReplace() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
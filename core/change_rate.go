@@ -0,0 +1,114 @@
+package autospotting
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+// changeRateWindow is the period over which the changes of the composition
+// of the groups are limited by their MaxChangePercentagePerHour
+const changeRateWindow = time.Hour
+
+// maxChangesPerHour returns the number of instances of the group which can be
+// changed within an hour, at least one so that the small groups are still
+// converted, or -1 when the changes aren't limited.
+func (a *autoScalingGroup) maxChangesPerHour() int {
+	limit := a.config.MaxChangePercentagePerHour
+	if limit <= 0 {
+		return -1
+	}
+	max := int(math.Floor(float64(a.instances.count()) * limit / 100.0))
+	if max < 1 {
+		max = 1
+	}
+	return max
+}
+
+// changeAllowance returns how many instances of the group can still be
+// replaced within the current hour, given the replacements and interruptions
+// recorded in its CompositionChangesTag, or -1 when they aren't limited.
+func (a *autoScalingGroup) changeAllowance(now time.Time) int {
+	max := a.maxChangesPerHour()
+	if max < 0 {
+		return -1
+	}
+
+	var recorded string
+	if value := getTagValueFromASGWithMatchingTag(a.Group, Tag{Key: CompositionChangesTag, Value: "*"}); value != nil {
+		recorded = *value
+	}
+	changes := len(recentInterruptions(recorded, now.Add(-changeRateWindow)))
+	if changes >= max {
+		logger.Println(a.region.name, a.name, "Changed", changes, "instances within the last",
+			changeRateWindow, "reaching its limit of", max)
+		return 0
+	}
+	return max - changes
+}
+
+// recordCompositionChange counts a replacement against the changes of the
+// group within the current hour, when they're limited.
+func (a *autoScalingGroup) recordCompositionChange(now time.Time) {
+	if a.config.MaxChangePercentagePerHour <= 0 {
+		return
+	}
+	recordTimeInTag(a.region.services.autoScaling, a.region.name, a.Group,
+		CompositionChangesTag, now, now.Add(-changeRateWindow))
+}
+
+// RecordCompositionChange counts the interruption of the instance against the
+// changes of its group within the current hour, when the group is limited by
+// its MaxChangePercentagePerHour, so that its on-demand instances are replaced
+// later instead of adding to the churn caused by the interruptions.
+func (s *SpotTermination) RecordCompositionChange(cfg *Config, instanceID *string) error {
+	if cfg == nil {
+		return nil
+	}
+
+	asgName, err := s.getAsgName(instanceID)
+	if err != nil {
+		logger.Printf("Failed get ASG name for %s with err: %s\n", *instanceID, err.Error())
+		return err
+	}
+
+	group, err := describeAutoScalingGroup(s.asSvc, s.region, asgName)
+	if err != nil {
+		return err
+	}
+
+	a := autoScalingGroup{
+		Group:  group,
+		name:   asgName,
+		region: &region{name: s.region, conf: cfg},
+	}
+	a.LoadMaxChangePercentagePerHour()
+	if a.config.MaxChangePercentagePerHour <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	_, err = recordTimeInTag(s.asSvc, s.region, group, CompositionChangesTag, now, now.Add(-changeRateWindow))
+	return err
+}
+
+// LoadMaxChangePercentagePerHour loads the maximum percentage of the instances
+// of the group changed within an hour from its tag, falling back to the global
+// value when the tag is missing or invalid.
+func (a *autoScalingGroup) LoadMaxChangePercentagePerHour() {
+	a.config.MaxChangePercentagePerHour = a.region.conf.MaxChangePercentagePerHour
+
+	tagValue, key := a.getFirstTagValue(MaxChangePercentagePerHourTag, MaxChangePercentagePerHourAliasTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", MaxChangePercentagePerHourTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	value, err := strconv.ParseFloat(*tagValue, 64)
+	if err != nil || value < 0 || value > 100 {
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s\n", key, *tagValue, a.name)
+		return
+	}
+	logger.Printf("Loaded MaxChangePercentagePerHour value %v from tag %v\n", value, key)
+	a.config.MaxChangePercentagePerHour = value
+}
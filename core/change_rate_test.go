@@ -0,0 +1,160 @@
+package autospotting
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func TestChangeAllowance(t *testing.T) {
+	now := time.Now()
+	recent := func(ago ...time.Duration) string {
+		var fields []string
+		for _, d := range ago {
+			fields = append(fields, fmt.Sprint(now.Add(-d).Unix()))
+		}
+		return strings.Join(fields, " ")
+	}
+
+	tests := []struct {
+		name       string
+		percentage float64
+		recorded   string
+		want       int
+	}{
+		{name: "unlimited", want: -1},
+		{name: "no changes", percentage: 50, want: 2},
+		{name: "at least one change", percentage: 10, want: 1},
+		{name: "recent change", percentage: 50, recorded: recent(10 * time.Minute), want: 1},
+		{name: "limit reached", percentage: 50, recorded: recent(10*time.Minute, 20*time.Minute), want: 0},
+		{name: "old changes expired", percentage: 50, recorded: recent(2*time.Hour, 90*time.Minute), want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newReplacementBatchGroup(4, mockEC2{})
+			a.config.MaxChangePercentagePerHour = tt.percentage
+			if tt.recorded != "" {
+				a.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(CompositionChangesTag), Value: aws.String(tt.recorded)},
+				}
+			}
+			if got := a.changeAllowance(now); got != tt.want {
+				t.Errorf("changeAllowance() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecordCompositionChange(t *testing.T) {
+	tags := map[string]string{}
+	a := newReplacementBatchGroup(4, mockEC2{})
+	a.region.services.autoScaling = mockASG{tags: tags}
+	a.config.MaxChangePercentagePerHour = 50
+
+	now := time.Now()
+	a.recordCompositionChange(now)
+	if got := a.changeAllowance(now); got != 1 {
+		t.Errorf("changeAllowance() = %d after a change, want 1", got)
+	}
+	a.recordCompositionChange(now)
+	if got := a.replacementAllowance(); got != 0 {
+		t.Errorf("replacementAllowance() = %d after two changes, want 0", got)
+	}
+	if got := len(strings.Fields(tags[CompositionChangesTag])); got != 2 {
+		t.Errorf("recorded %d changes in the tag, want 2", got)
+	}
+}
+
+func TestSpotTerminationRecordCompositionChange(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      *Config
+		tags     []*autoscaling.TagDescription
+		recorded bool
+	}{
+		{name: "unlimited", cfg: &Config{}},
+		{
+			name:     "global limit",
+			cfg:      &Config{AutoScalingConfig: AutoScalingConfig{MaxChangePercentagePerHour: 20}},
+			recorded: true,
+		},
+		{
+			name: "group limit",
+			cfg:  &Config{},
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(MaxChangePercentagePerHourTag), Value: aws.String("20")},
+			},
+			recorded: true,
+		},
+		{
+			name: "group without limit",
+			cfg:  &Config{AutoScalingConfig: AutoScalingConfig{MaxChangePercentagePerHour: 20}},
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(MaxChangePercentagePerHourTag), Value: aws.String("0")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags := map[string]string{}
+			s := &SpotTermination{
+				region: "us-east-1",
+				asSvc: mockASG{
+					dasio: &autoscaling.DescribeAutoScalingInstancesOutput{
+						AutoScalingInstances: []*autoscaling.InstanceDetails{
+							{AutoScalingGroupName: aws.String("mygroup")},
+						},
+					},
+					dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{
+						AutoScalingGroups: []*autoscaling.Group{
+							{AutoScalingGroupName: aws.String("mygroup"), Tags: tt.tags},
+						},
+					},
+					tags: tags,
+				},
+			}
+			if err := s.RecordCompositionChange(tt.cfg, aws.String("i-spot")); err != nil {
+				t.Fatalf("RecordCompositionChange() error = %v", err)
+			}
+			if _, got := tags[CompositionChangesTag]; got != tt.recorded {
+				t.Errorf("RecordCompositionChange() recorded the change: %v, want %v", got, tt.recorded)
+			}
+		})
+	}
+}
+
+func TestLoadMaxChangePercentagePerHour(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		tag  *string
+		want float64
+	}{
+		{name: "no tag", want: 25},
+		{name: "tag", key: MaxChangePercentagePerHourTag, tag: aws.String("10"), want: 10},
+		{name: "hyphenated tag", key: MaxChangePercentagePerHourAliasTag, tag: aws.String("15"), want: 15},
+		{name: "unlimited group", key: MaxChangePercentagePerHourTag, tag: aws.String("0"), want: 0},
+		{name: "invalid tag", key: MaxChangePercentagePerHourTag, tag: aws.String("150"), want: 25},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{},
+				region: &region{conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{MaxChangePercentagePerHour: 25},
+				}},
+			}
+			if tt.tag != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(tt.key), Value: tt.tag}}
+			}
+			a.LoadMaxChangePercentagePerHour()
+			if a.config.MaxChangePercentagePerHour != tt.want {
+				t.Errorf("LoadMaxChangePercentagePerHour() = %v, want %v", a.config.MaxChangePercentagePerHour, tt.want)
+			}
+		})
+	}
+}
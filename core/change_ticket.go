@@ -0,0 +1,197 @@
+package autospotting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// Built-in templates of the change records created through the change
+// webhook, the others are given as Go templates.
+const (
+	genericChangeTemplate = "generic"
+
+	serviceNowChangeTemplate = "servicenow"
+
+	// used as jira:<project key>
+	jiraChangeTemplate = "jira"
+)
+
+var builtinChangeTemplates = map[string]string{
+	genericChangeTemplate: `{"run_id":{{json .RunID}},"summary":{{json .Summary}},"changes":{{json .Changes}}}`,
+
+	serviceNowChangeTemplate: `{"type":"standard","category":"Hardware",` +
+		`"correlation_id":{{json .RunID}},` +
+		`"short_description":{{json .Summary}},` +
+		`"description":{{json .Description}},` +
+		`"start_date":{{json (utc .Start)}},"end_date":{{json (utc .End)}}}`,
+
+	jiraChangeTemplate: `{"fields":{"project":{"key":{{json .Project}}},` +
+		`"issuetype":{"name":"Change"},` +
+		`"summary":{{json .Summary}},` +
+		`"description":{{json .Description}},` +
+		`"labels":["autospotting",{{json .RunID}}]}}`,
+}
+
+// change is an instance replacement performed by AutoSpotting.
+type change struct {
+	Account              string    `json:"account,omitempty"`
+	Region               string    `json:"region"`
+	AutoScalingGroup     string    `json:"asg"`
+	OnDemandInstanceID   string    `json:"on_demand_instance_id"`
+	OnDemandInstanceType string    `json:"on_demand_instance_type"`
+	SpotInstanceID       string    `json:"spot_instance_id"`
+	SpotInstanceType     string    `json:"spot_instance_type"`
+	HourlySavings        float64   `json:"hourly_savings"`
+	Time                 time.Time `json:"time"`
+}
+
+func (c change) String() string {
+	var account string
+	if c.Account != "" {
+		account = "account " + c.Account + " "
+	}
+	return fmt.Sprintf("%s%s %s: replaced on-demand instance %s (%s) with spot instance %s (%s) at %s",
+		account, c.Region, c.AutoScalingGroup, c.OnDemandInstanceID, c.OnDemandInstanceType,
+		c.SpotInstanceID, c.SpotInstanceType, c.Time.UTC().Format(time.RFC3339))
+}
+
+// changeLog collects the replacements performed during a run, for reporting
+// them in a change record.
+type changeLog struct {
+	sync.Mutex
+	start   time.Time
+	changes []change
+}
+
+func newChangeLog() *changeLog {
+	return &changeLog{start: time.Now()}
+}
+
+// record adds a replacement to the log, if there is one.
+func (l *changeLog) record(c change) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.changes = append(l.changes, c)
+}
+
+func (l *changeLog) list() []change {
+	l.Lock()
+	defer l.Unlock()
+	return append([]change(nil), l.changes...)
+}
+
+// changeTicket is the data available to the change record templates.
+type changeTicket struct {
+	RunID       string
+	Project     string
+	Summary     string
+	Description string
+	Start       time.Time
+	End         time.Time
+	Changes     []change
+}
+
+// changeTemplate returns the template of the change records and its
+// argument, such as the Jira project key.
+func changeTemplate(name string) (*template.Template, string, error) {
+	if name == "" {
+		name = genericChangeTemplate
+	}
+
+	text, arg := name, ""
+	parts := strings.SplitN(name, ":", 2)
+	if builtin, ok := builtinChangeTemplates[parts[0]]; ok {
+		text = builtin
+		if len(parts) > 1 {
+			arg = parts[1]
+		}
+	}
+
+	t, err := template.New("change").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+		"utc": func(t time.Time) string {
+			return t.UTC().Format("2006-01-02 15:04:05")
+		},
+	}).Parse(text)
+	return t, arg, err
+}
+
+// submitChangeTicket sends the replacements performed during the run to the
+// configured change webhook, such as one creating change records in an ITSM
+// system.
+func submitChangeTicket(cfg *Config) {
+	if cfg.ChangeWebhookURL == "" || cfg.changes == nil {
+		return
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	if err := sendChangeTicket(client, cfg, cfg.changes); err != nil {
+		logger.Println("Failed to submit the change record:", err.Error())
+	}
+}
+
+func sendChangeTicket(client *http.Client, cfg *Config, log *changeLog) error {
+	changes := log.list()
+	if len(changes) == 0 {
+		return nil
+	}
+
+	t, arg, err := changeTemplate(cfg.ChangeWebhookTemplate)
+	if err != nil {
+		return fmt.Errorf("invalid change webhook template: %s", err.Error())
+	}
+
+	var lines []string
+	for _, c := range changes {
+		lines = append(lines, c.String())
+	}
+
+	ticket := changeTicket{
+		RunID:       cfg.runID,
+		Project:     arg,
+		Summary:     fmt.Sprintf("AutoSpotting replaced %d on-demand instances with spot instances", len(changes)),
+		Description: strings.Join(lines, "\n"),
+		Start:       log.start,
+		End:         time.Now(),
+		Changes:     changes,
+	}
+
+	var body bytes.Buffer
+	if err := t.Execute(&body, ticket); err != nil {
+		return fmt.Errorf("rendering the change record: %s", err.Error())
+	}
+
+	req, err := http.NewRequest(http.MethodPost, cfg.secret("change webhook URL", cfg.ChangeWebhookURL), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if authorization := cfg.secret("change webhook authorization", cfg.ChangeWebhookAuthorization); authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("change webhook returned %s", resp.Status)
+	}
+
+	logger.Println("Submitted the change record of run", cfg.runID, "for", len(changes), "replacements")
+	return nil
+}
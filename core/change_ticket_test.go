@@ -0,0 +1,150 @@
+package autospotting
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func changeTestLog() *changeLog {
+	l := newChangeLog()
+	l.record(change{
+		Region:               "us-east-1",
+		AutoScalingGroup:     "asg",
+		OnDemandInstanceID:   "i-ondemand",
+		OnDemandInstanceType: "m5.large",
+		SpotInstanceID:       "i-spot",
+		SpotInstanceType:     "m5.xlarge",
+		Time:                 time.Date(2019, 7, 1, 10, 0, 0, 0, time.UTC),
+	})
+	return l
+}
+
+func Test_sendChangeTicket(t *testing.T) {
+	tests := []struct {
+		template string
+		check    func(t *testing.T, payload map[string]interface{})
+	}{
+		{
+			template: "",
+			check: func(t *testing.T, payload map[string]interface{}) {
+				changes := payload["changes"].([]interface{})
+				if payload["run_id"] != "run-1" || len(changes) != 1 ||
+					changes[0].(map[string]interface{})["spot_instance_id"] != "i-spot" {
+					t.Errorf("unexpected generic payload: %v", payload)
+				}
+			},
+		},
+		{
+			template: serviceNowChangeTemplate,
+			check: func(t *testing.T, payload map[string]interface{}) {
+				if payload["correlation_id"] != "run-1" ||
+					!strings.Contains(payload["description"].(string), "i-ondemand (m5.large)") {
+					t.Errorf("unexpected ServiceNow payload: %v", payload)
+				}
+			},
+		},
+		{
+			template: "jira:OPS",
+			check: func(t *testing.T, payload map[string]interface{}) {
+				fields := payload["fields"].(map[string]interface{})
+				if fields["project"].(map[string]interface{})["key"] != "OPS" ||
+					!strings.Contains(fields["summary"].(string), "replaced 1 on-demand instances") {
+					t.Errorf("unexpected Jira payload: %v", payload)
+				}
+			},
+		},
+		{
+			template: `{"ticket":{{json .Summary}},"count":{{len .Changes}}}`,
+			check: func(t *testing.T, payload map[string]interface{}) {
+				if payload["count"] != float64(1) {
+					t.Errorf("unexpected custom payload: %v", payload)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			var payload map[string]interface{}
+			var authorization string
+
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				authorization = r.Header.Get("Authorization")
+				body, _ := ioutil.ReadAll(r.Body)
+				if err := json.Unmarshal(body, &payload); err != nil {
+					t.Errorf("invalid JSON payload %s: %s", body, err.Error())
+				}
+				w.WriteHeader(http.StatusCreated)
+			}))
+			defer server.Close()
+
+			cfg := &Config{
+				ChangeWebhookURL:           server.URL,
+				ChangeWebhookTemplate:      tt.template,
+				ChangeWebhookAuthorization: "Basic secret",
+				runID:                      "run-1",
+			}
+
+			if err := sendChangeTicket(server.Client(), cfg, changeTestLog()); err != nil {
+				t.Fatalf("sendChangeTicket() error = %v", err)
+			}
+			if authorization != "Basic secret" {
+				t.Errorf("unexpected Authorization header %q", authorization)
+			}
+			tt.check(t, payload)
+		})
+	}
+}
+
+func Test_sendChangeTicketFailures(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	cfg := &Config{ChangeWebhookURL: server.URL}
+
+	if err := sendChangeTicket(server.Client(), cfg, newChangeLog()); err != nil || calls != 0 {
+		t.Errorf("expected nothing to be sent without changes, got %v after %d calls", err, calls)
+	}
+	if err := sendChangeTicket(server.Client(), cfg, changeTestLog()); err == nil {
+		t.Error("expected an error for the rejected change record")
+	}
+
+	cfg.ChangeWebhookTemplate = "{{.Missing"
+	if err := sendChangeTicket(server.Client(), cfg, changeTestLog()); err == nil {
+		t.Error("expected an error for the invalid template")
+	}
+}
+
+func TestReplacementsAreRecorded(t *testing.T) {
+	enabled := &autoscaling.TagDescription{Key: aws.String("spot-enabled"), Value: aws.String("true")}
+	cfg, r := commandTestConfig(enabled)
+	cfg.changes = newChangeLog()
+
+	// the first command launches the spot instance, the second one attaches
+	// it, both executed as part of the same run
+	for i := 0; i < 2; i++ {
+		if err := executeCommand(cfg, Command{Action: ReplaceCommand, AutoScalingGroup: "asg"}); err != nil {
+			t.Fatalf("executeCommand() error = %v", err)
+		}
+		for _, inst := range r.Instances() {
+			inst.LaunchTime = aws.Time(time.Now().Add(-time.Hour))
+		}
+	}
+
+	changes := cfg.changes.list()
+	if len(changes) != 1 || changes[0].OnDemandInstanceID != "i-ondemand" || changes[0].AutoScalingGroup != "asg" {
+		t.Errorf("unexpected recorded changes: %v", changes)
+	}
+}
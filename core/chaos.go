@@ -0,0 +1,122 @@
+package autospotting
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// ChaosModeOff disables the chaos mode, which is the default.
+	ChaosModeOff = "off"
+
+	// ChaosModeEvent simulates spot interruption events for the randomly
+	// selected spot instances, executing the configured termination
+	// notification action just like for a real interruption warning.
+	ChaosModeEvent = "event"
+
+	// ChaosModeTerminate terminates the randomly selected spot instances using
+	// the EC2 API, without any prior notice.
+	ChaosModeTerminate = "terminate"
+)
+
+// chaosRandom returns a random number in [0.0, 100.0), it is overridden in
+// the tests.
+var chaosRandom = func() float64 {
+	return rand.Float64() * 100.0
+}
+
+// isManagedSpotInstance returns true for the spot instances launched by
+// AutoSpotting, identified by the given ownership tags.
+func (i *instance) isManagedSpotInstance(o Ownership) bool {
+	return i.isSpot() && i.isLaunchedByAutoSpotting(o)
+}
+
+// chaosCandidates returns the running spot instances launched by AutoSpotting
+// which are members of the enabled groups.
+func (r *region) chaosCandidates() []*instance {
+	var candidates []*instance
+	ownership := r.conf.Ownership()
+
+	for _, asg := range r.enabledASGs {
+		for _, member := range asg.Instances {
+			i := r.instances.get(*member.InstanceId)
+			if i == nil || *i.State.Name != ec2.InstanceStateNameRunning {
+				continue
+			}
+			if i.isManagedSpotInstance(ownership) {
+				candidates = append(candidates, i)
+			}
+		}
+	}
+	return candidates
+}
+
+// runChaos deliberately interrupts a configurable percentage of the spot
+// instances launched by AutoSpotting, so that users can rehearse the handling
+// of spot interruptions, such as their draining hooks, before a real market
+// event happens.
+func (r *region) runChaos() {
+	mode := r.conf.ChaosMode
+
+	if mode != ChaosModeEvent && mode != ChaosModeTerminate {
+		if mode != "" && mode != ChaosModeOff {
+			logger.Println(r.name, "Ignoring unknown chaos mode", mode)
+		}
+		return
+	}
+
+	st := SpotTermination{asSvc: r.services.autoScaling, ec2Svc: r.services.ec2, ownership: r.conf.Ownership()}
+
+	for _, i := range r.chaosCandidates() {
+
+		if chaosRandom() >= r.conf.ChaosPercentage {
+			continue
+		}
+
+		logger.Println(r.name, "Chaos mode", mode, "interrupting spot instance",
+			*i.InstanceId)
+
+		switch mode {
+		case ChaosModeEvent:
+			r.simulateInterruption(st, i)
+		case ChaosModeTerminate:
+			i.terminate()
+		}
+	}
+}
+
+// simulateInterruption handles a fake interruption warning of the given
+// instance using the same logic used for real interruption warnings. When the
+// instance is only detached from its group, it is then terminated, just like
+// AWS would do at the end of the two minutes notice period.
+func (r *region) simulateInterruption(st SpotTermination, i *instance) {
+	asgName, err := st.getAsgName(i.InstanceId)
+	if err != nil {
+		logger.Println(r.name, "Couldn't determine the group of", *i.InstanceId, err.Error())
+		return
+	}
+
+	st.ForwardNotice(r.conf, i.InstanceId, InterruptionNotice, time.Now())
+
+	action := st.chooseAction(asgName, r.conf.TerminationNotificationAction)
+	st.RecordCompositionChange(r.conf, i.InstanceId)
+
+	if err := st.ExecuteAction(i.InstanceId, action); err != nil {
+		logger.Println(r.name, "Failed to handle simulated interruption of",
+			*i.InstanceId, err.Error())
+		return
+	}
+
+	if action == DetachTerminationNotificationAction {
+		logger.Println(r.name, "Terminating detached instance", *i.InstanceId,
+			"to complete the simulated interruption")
+		if _, err := r.services.ec2.TerminateInstances(&ec2.TerminateInstancesInput{
+			InstanceIds: []*string{aws.String(*i.InstanceId)},
+		}); err != nil {
+			logger.Println(r.name, "Failed to terminate", *i.InstanceId, err.Error())
+		}
+	}
+}
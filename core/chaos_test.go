@@ -0,0 +1,142 @@
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AutoSpotting/AutoSpotting/core/autospottingtest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestRunChaos(t *testing.T) {
+	defer func(f func() float64) { chaosRandom = f }(chaosRandom)
+	chaosRandom = func() float64 { return 50.0 }
+
+	tests := []struct {
+		name       string
+		mode       string
+		percentage float64
+		want       []string
+	}{
+		{
+			name:       "disabled",
+			mode:       ChaosModeOff,
+			percentage: 100,
+		},
+		{
+			name:       "unknown mode",
+			mode:       "foo",
+			percentage: 100,
+		},
+		{
+			name:       "percentage not reached",
+			mode:       ChaosModeTerminate,
+			percentage: 10,
+		},
+		{
+			name:       "terminate mode",
+			mode:       ChaosModeTerminate,
+			percentage: 60,
+			want:       []string{"TerminateInstances"},
+		},
+		{
+			name:       "event mode",
+			mode:       ChaosModeEvent,
+			percentage: 60,
+			want:       []string{"DetachInstances", "DeleteTags", "TerminateInstances"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cloud := autospottingtest.NewCloud()
+			fr := cloud.Region("us-east-1")
+
+			group := &autoscaling.Group{
+				AutoScalingGroupName: aws.String("asg"),
+				MinSize:              aws.Int64(1),
+				MaxSize:              aws.Int64(5),
+				DesiredCapacity:      aws.Int64(3),
+			}
+			for _, id := range []string{"i-managed", "i-foreign-spot", "i-ondemand"} {
+				inst := newTestInstance(id, "m5.large", time.Hour).Instance
+				if id != "i-ondemand" {
+					inst.InstanceLifecycle = aws.String("spot")
+				}
+				if id != "i-foreign-spot" {
+					inst.Tags = tags("launched-by-autospotting", "true")
+				}
+				fr.AddInstance(inst)
+				group.Instances = append(group.Instances, &autoscaling.Instance{
+					InstanceId:       aws.String(id),
+					AvailabilityZone: aws.String("us-east-1a"),
+				})
+			}
+			fr.AddGroup(group)
+
+			r := newTestRegion(&Config{
+				ClientProvider:  cloud,
+				ChaosMode:       tt.mode,
+				ChaosPercentage: tt.percentage,
+				AutoScalingConfig: AutoScalingConfig{
+					TerminationNotificationAction: DetachTerminationNotificationAction,
+				},
+			}, "asg")
+			r.runChaos()
+
+			var got []string
+			for _, c := range cloud.MutatingCalls() {
+				got = append(got, c.Operation)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("runChaos() made the calls %v, want %v", got, tt.want)
+			}
+
+			if len(tt.want) > 0 {
+				state := cloud.Region("us-east-1").Instance("i-managed").State.Name
+				if *state != ec2.InstanceStateNameTerminated {
+					t.Errorf("runChaos() left i-managed in state %s", *state)
+				}
+			}
+		})
+	}
+}
+
+func Test_instance_isManagedSpotInstance(t *testing.T) {
+	tests := []struct {
+		name string
+		inst *ec2.Instance
+		want bool
+	}{
+		{
+			name: "on-demand instance",
+			inst: &ec2.Instance{Tags: []*ec2.Tag{
+				{Key: aws.String("launched-by-autospotting"), Value: aws.String("true")},
+			}},
+		},
+		{
+			name: "spot instance not launched by AutoSpotting",
+			inst: &ec2.Instance{InstanceLifecycle: aws.String("spot")},
+		},
+		{
+			name: "spot instance launched by AutoSpotting",
+			inst: &ec2.Instance{
+				InstanceLifecycle: aws.String("spot"),
+				Tags: []*ec2.Tag{
+					{Key: aws.String("launched-by-autospotting"), Value: aws.String("true")},
+				},
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{Instance: tt.inst}
+			if got := i.isManagedSpotInstance(defaultOwnership); got != tt.want {
+				t.Errorf("isManagedSpotInstance() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,283 @@
+package autospotting
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// Slack rejects the requests older than this, in order to prevent replays
+const slackRequestMaxAge = 5 * time.Minute
+
+const slackUsage = "Usage:\n" +
+	"`/autospotting status [group] [region] [account|profile]` shows the configuration or the state of a group\n" +
+	"`/autospotting pause <duration> <group> [region] [account|profile]` leaves a group alone for a while, such as 2h\n" +
+	"`/autospotting replace <group> [region] [account|profile]` replaces the on-demand instances of a group right away\n" +
+	"`/autospotting revert <group> [region] [account|profile]` goes back to on-demand instances in a group"
+
+// accountIDPattern matches the AWS account IDs given to the Slack commands.
+var accountIDPattern = regexp.MustCompile(`^[0-9]{12}$`)
+
+// IsSlackCommandRequest returns true for the HTTP requests received through
+// an API Gateway proxy integration, such as those sent by Slack for slash
+// commands.
+func IsSlackCommandRequest(req events.APIGatewayProxyRequest) bool {
+	return req.HTTPMethod != "" && req.Body != ""
+}
+
+// HandleSlackCommand verifies the signature of a Slack slash command request
+// received through API Gateway, executes the command and returns the reply
+// shown in Slack.
+func HandleSlackCommand(cfg *Config, req events.APIGatewayProxyRequest) events.APIGatewayProxyResponse {
+	if logger == nil {
+		setupLogging(cfg)
+	}
+
+	if cfg.SlackSigningSecret == "" {
+		logger.Println("Rejecting Slack command, the Slack signing secret isn't configured")
+		return slackResponse(http.StatusForbidden, "The Slack integration isn't configured")
+	}
+
+	body := req.Body
+	if req.IsBase64Encoded {
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			return slackResponse(http.StatusBadRequest, "Invalid request body")
+		}
+		body = string(decoded)
+	}
+
+	verify := func() error {
+		return verifySlackSignature(cfg.secret("Slack signing", cfg.SlackSigningSecret),
+			header(req.Headers, "X-Slack-Request-Timestamp"),
+			header(req.Headers, "X-Slack-Signature"),
+			body, time.Now())
+	}
+	err := verify()
+	if err != nil && isSecretReference(cfg.SlackSigningSecret) {
+		// the cached secret may have been rotated meanwhile
+		secrets.invalidate(cfg.SlackSigningSecret)
+		err = verify()
+	}
+	if err != nil {
+		logger.Println("Rejecting Slack command:", err.Error())
+		return slackResponse(http.StatusUnauthorized, "Invalid request signature")
+	}
+
+	form, err := url.ParseQuery(body)
+	if err != nil {
+		return slackResponse(http.StatusBadRequest, "Invalid request body")
+	}
+
+	logger.Printf("Slack command '%s' issued by %s\n", form.Get("text"), form.Get("user_name"))
+
+	var queue sqsiface.SQSAPI
+	if cfg.CommandQueueURL != "" {
+		region := queueRegion(cfg.CommandQueueURL)
+		if region == "" {
+			region = cfg.MainRegion
+		}
+		queue = sqs.New(newSession(cfg, region))
+	}
+
+	return slackResponse(http.StatusOK, runSlackCommand(cfg, form.Get("text"), queue))
+}
+
+// header looks up an HTTP header ignoring its case, since API Gateway passes
+// them as sent by the client.
+func header(headers map[string]string, name string) string {
+	for k, v := range headers {
+		if strings.EqualFold(k, name) {
+			return v
+		}
+	}
+	return ""
+}
+
+// verifySlackSignature checks the signature of a request sent by Slack, as
+// described on https://api.slack.com/docs/verifying-requests-from-slack
+func verifySlackSignature(secret, timestamp, signature, body string, now time.Time) error {
+	if timestamp == "" || signature == "" {
+		return errors.New("missing the Slack signature headers")
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid request timestamp '%s'", timestamp)
+	}
+	if age := now.Sub(time.Unix(ts, 0)); age > slackRequestMaxAge || age < -slackRequestMaxAge {
+		return fmt.Errorf("request timestamp %s is too far from the current time", timestamp)
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("signature mismatch")
+	}
+	return nil
+}
+
+func slackResponse(status int, text string) events.APIGatewayProxyResponse {
+	body, _ := json.Marshal(map[string]string{
+		"response_type": "ephemeral",
+		"text":          text,
+	})
+	return events.APIGatewayProxyResponse{
+		StatusCode: status,
+		Headers:    map[string]string{"Content-Type": "application/json"},
+		Body:       string(body),
+	}
+}
+
+// runSlackCommand executes the text of a slash command and returns the reply.
+// The long running commands are sent to the command queue when available,
+// since Slack only waits for a few seconds for the reply.
+func runSlackCommand(cfg *Config, text string, queue sqsiface.SQSAPI) string {
+	args := strings.Fields(text)
+	if len(args) == 0 {
+		return slackUsage
+	}
+
+	region := func(i int) string {
+		if len(args) > i {
+			return args[i]
+		}
+		return cfg.MainRegion
+	}
+
+	// the argument after the region is the account of the group when it's an
+	// account ID, or the credential profile used for reaching it otherwise
+	target := func(c Command, i int) Command {
+		if len(args) > i {
+			if accountIDPattern.MatchString(args[i]) {
+				c.Account = args[i]
+			} else {
+				c.Profile = args[i]
+			}
+		}
+		return c
+	}
+
+	switch args[0] {
+	case "status":
+		if len(args) == 1 {
+			return configurationStatus(cfg)
+		}
+		status, err := groupStatus(cfg, target(Command{AutoScalingGroup: args[1], Region: region(2)}, 3))
+		if err != nil {
+			return "Couldn't get the status of " + args[1] + ": " + err.Error()
+		}
+		return status
+
+	case "pause":
+		if len(args) < 3 {
+			return slackUsage
+		}
+		c := target(Command{Action: SnoozeCommand, Duration: args[1], AutoScalingGroup: args[2], Region: region(3)}, 4)
+		if err := c.validate(); err != nil {
+			return err.Error()
+		}
+		if err := ExecuteCommand(cfg, c); err != nil {
+			return "Couldn't pause " + c.AutoScalingGroup + ": " + err.Error()
+		}
+		return fmt.Sprintf("Paused %s in %s for %s", c.AutoScalingGroup, c.Region, c.Duration)
+
+	case ReplaceCommand, RevertCommand:
+		if len(args) < 2 {
+			return slackUsage
+		}
+		c := target(Command{Action: args[0], AutoScalingGroup: args[1], Region: region(2)}, 3)
+
+		if queue != nil {
+			if err := queueCommand(queue, cfg.CommandQueueURL, c); err != nil {
+				return "Couldn't queue the " + c.Action + " command: " + err.Error()
+			}
+			return fmt.Sprintf("Queued the %s command for %s in %s", c.Action, c.AutoScalingGroup, c.Region)
+		}
+
+		if err := ExecuteCommand(cfg, c); err != nil {
+			return fmt.Sprintf("The %s command for %s failed: %s", c.Action, c.AutoScalingGroup, err.Error())
+		}
+		return fmt.Sprintf("Executed the %s command for %s in %s", c.Action, c.AutoScalingGroup, c.Region)
+	}
+
+	return slackUsage
+}
+
+// configurationStatus summarizes the global configuration.
+func configurationStatus(cfg *Config) string {
+	regions := cfg.Regions
+	if regions == "" {
+		regions = "all"
+	}
+
+	status := fmt.Sprintf("Regions: %s\nGroups: %s (%s)\nOn-demand: %d instances or %.0f%% per group",
+		regions, cfg.FilterByTags, cfg.TagFilteringMode,
+		cfg.MinOnDemandNumber, cfg.MinOnDemandPercentage)
+
+	if cfg.RolloutPercentage > 0 && cfg.RolloutPercentage < 100 {
+		status += fmt.Sprintf("\nRollout: %.0f%% of the groups", cfg.RolloutPercentage)
+	}
+	if cfg.CanaryASGs != "" {
+		status += "\nCanaries: " + cfg.CanaryASGs
+	}
+	return status
+}
+
+// groupStatus summarizes the state of the group of the given command.
+func groupStatus(cfg *Config, c Command) (string, error) {
+	cfg, err := commandConfig(cfg, c)
+	if err != nil {
+		return "", err
+	}
+	asgName, regionName := c.AutoScalingGroup, c.Region
+
+	r := &region{name: regionName, conf: cfg, services: connections{provider: cfg.ClientProvider, profile: cfg.profile, credentials: cfg.credentials, audit: cfg.audit}}
+	r.services.connect(regionName)
+
+	group, err := r.describeGroup(asgName)
+	if err != nil {
+		return "", err
+	}
+
+	if err := r.scanInstances(); err != nil {
+		return "", err
+	}
+
+	var spot, onDemand int
+	for _, inst := range group.Instances {
+		if i := r.instances.get(*inst.InstanceId); i != nil && i.isSpot() {
+			spot++
+		} else {
+			onDemand++
+		}
+	}
+
+	status := fmt.Sprintf("%s in %s: %d spot and %d on-demand instances, desired capacity %d",
+		asgName, regionName, spot, onDemand, aws.Int64Value(group.DesiredCapacity))
+
+	if until, snoozed := isSnoozed(group, time.Now()); snoozed {
+		status += ", paused until " + until.UTC().Format(time.RFC3339)
+	}
+	if isDeploymentInProgress(group, cfg.DeploymentFreezeTag) {
+		status += ", paused during deployment"
+	}
+	return status, nil
+}
@@ -0,0 +1,187 @@
+package autospotting
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func slackSignature(secret, timestamp, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte("v0:" + timestamp + ":" + body))
+	return "v0=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func Test_verifySlackSignature(t *testing.T) {
+	now := time.Unix(1531420618, 0)
+	ts := strconv.FormatInt(now.Unix(), 10)
+	body := "command=%2Fautospotting&text=status"
+
+	tests := []struct {
+		name      string
+		timestamp string
+		signature string
+		now       time.Time
+		wantErr   bool
+	}{
+		{
+			name:      "valid",
+			timestamp: ts,
+			signature: slackSignature("secret", ts, body),
+			now:       now,
+		},
+		{
+			name:      "wrong secret",
+			timestamp: ts,
+			signature: slackSignature("other", ts, body),
+			now:       now,
+			wantErr:   true,
+		},
+		{
+			name:      "replayed",
+			timestamp: ts,
+			signature: slackSignature("secret", ts, body),
+			now:       now.Add(10 * time.Minute),
+			wantErr:   true,
+		},
+		{
+			name:    "missing headers",
+			now:     now,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifySlackSignature("secret", tt.timestamp, tt.signature, body, tt.now)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("verifySlackSignature() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHandleSlackCommand(t *testing.T) {
+	body := "command=%2Fautospotting&text=status&user_name=oncall"
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req := events.APIGatewayProxyRequest{
+		HTTPMethod: "POST",
+		Body:       body,
+		Headers: map[string]string{
+			"x-slack-request-timestamp": ts,
+			"x-slack-signature":         slackSignature("secret", ts, body),
+		},
+	}
+	if !IsSlackCommandRequest(req) {
+		t.Fatal("expected the request to be recognized as a Slack command")
+	}
+
+	cfg := &Config{SlackSigningSecret: "secret", Regions: "eu-west-1"}
+	resp := HandleSlackCommand(cfg, req)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(resp.Body, "eu-west-1") {
+		t.Errorf("unexpected response: %v", resp)
+	}
+
+	cfg.SlackSigningSecret = "other"
+	if resp := HandleSlackCommand(cfg, req); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected an invalid signature to be rejected, got %v", resp)
+	}
+
+	cfg.SlackSigningSecret = ""
+	if resp := HandleSlackCommand(cfg, req); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected the commands to be rejected when not configured, got %v", resp)
+	}
+}
+
+func Test_runSlackCommand(t *testing.T) {
+	enabled := &autoscaling.TagDescription{Key: aws.String("spot-enabled"), Value: aws.String("true")}
+
+	t.Run("status of a group", func(t *testing.T) {
+		cfg, _ := commandTestConfig(enabled)
+
+		got := runSlackCommand(cfg, "status asg", nil)
+		if want := "1 spot and 1 on-demand instances"; !strings.Contains(got, want) {
+			t.Errorf("runSlackCommand() = %q, want it to contain %q", got, want)
+		}
+	})
+
+	t.Run("pause", func(t *testing.T) {
+		cfg, r := commandTestConfig(enabled)
+
+		got := runSlackCommand(cfg, "pause 2h asg", nil)
+		if _, snoozed := isSnoozed(r.Group("asg"), time.Now()); !snoozed {
+			t.Errorf("group wasn't paused: %q", got)
+		}
+		if got := runSlackCommand(cfg, "status asg us-east-1", nil); !strings.Contains(got, "paused until") {
+			t.Errorf("status doesn't mention the pause: %q", got)
+		}
+	})
+
+	t.Run("invalid pause duration", func(t *testing.T) {
+		cfg, r := commandTestConfig(enabled)
+
+		runSlackCommand(cfg, "pause forever asg", nil)
+		if _, snoozed := isSnoozed(r.Group("asg"), time.Now()); snoozed {
+			t.Error("group was paused despite the invalid duration")
+		}
+	})
+
+	t.Run("replace is queued when possible", func(t *testing.T) {
+		cfg, _ := commandTestConfig(enabled)
+		cfg.CommandQueueURL = "https://sqs.us-east-1.amazonaws.com/123456789012/commands"
+		queue := &mockSQS{}
+
+		runSlackCommand(cfg, "replace asg", queue)
+		if len(queue.sm) != 1 || !strings.Contains(queue.sm[0], `"action":"replace"`) {
+			t.Errorf("replace command wasn't queued: %v", queue.sm)
+		}
+	})
+
+	t.Run("replace of the group of an account", func(t *testing.T) {
+		cfg, _ := commandTestConfig(enabled)
+		cfg.CommandQueueURL = "https://sqs.us-east-1.amazonaws.com/123456789012/commands"
+		queue := &mockSQS{}
+
+		runSlackCommand(cfg, "replace asg eu-west-1 111111111111", queue)
+		if len(queue.sm) != 1 || !strings.Contains(queue.sm[0], `"region":"eu-west-1","account":"111111111111"`) {
+			t.Errorf("replace command wasn't queued for the account: %v", queue.sm)
+		}
+	})
+
+	t.Run("status with an unknown profile", func(t *testing.T) {
+		cfg, _ := commandTestConfig(enabled)
+		cfg.ClientProvider = nil
+
+		got := runSlackCommand(cfg, "status asg us-east-1 missing", nil)
+		if want := "the credential profile missing isn't configured"; !strings.Contains(got, want) {
+			t.Errorf("runSlackCommand() = %q, want it to contain %q", got, want)
+		}
+	})
+
+	t.Run("revert is executed without a queue", func(t *testing.T) {
+		cfg, r := commandTestConfig(enabled)
+
+		runSlackCommand(cfg, "revert asg", nil)
+		if g := r.Group("asg"); len(g.Instances) != 1 {
+			t.Errorf("revert command wasn't executed: %v", g)
+		}
+	})
+
+	t.Run("usage", func(t *testing.T) {
+		cfg, _ := commandTestConfig()
+		for _, text := range []string{"", "help", "replace", "pause 2h"} {
+			if got := runSlackCommand(cfg, text, nil); got != slackUsage {
+				t.Errorf("runSlackCommand(%q) = %q, want the usage", text, got)
+			}
+		}
+	})
+}
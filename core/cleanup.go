@@ -0,0 +1,211 @@
+package autospotting
+
+import (
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// orphanGracePeriod is how long the resources launched by AutoSpotting are
+// left alone before being considered orphaned, in addition to the health
+// check grace period of their group.
+const orphanGracePeriod = time.Hour
+
+// cleanupReport lists the resources reclaimed by the cleanup pass.
+type cleanupReport struct {
+	instances        []string
+	spotRequests     []string
+	lifecycleActions []string
+}
+
+func (c cleanupReport) empty() bool {
+	return len(c.instances)+len(c.spotRequests)+len(c.lifecycleActions) == 0
+}
+
+// cleanupOrphans reclaims the resources left behind by interrupted runs: the
+// spot instances launched by AutoSpotting which never got attached to their
+// group, the spot requests which were never fulfilled and the lifecycle
+// actions still pending for instances which are already gone.
+func (r *region) cleanupOrphans() cleanupReport {
+	var report cleanupReport
+
+	if !r.conf.CleanupOrphans {
+		return report
+	}
+
+	report.instances = r.terminateOrphanInstances()
+	report.spotRequests = r.cancelDanglingSpotRequests()
+	report.lifecycleActions = r.completeStaleLifecycleActions()
+
+	if !report.empty() {
+		logger.Printf("%s Cleanup reclaimed %d orphan instances [%s], "+
+			"%d spot requests [%s], %d lifecycle actions [%s]\n", r.name,
+			len(report.instances), strings.Join(report.instances, " "),
+			len(report.spotRequests), strings.Join(report.spotRequests, " "),
+			len(report.lifecycleActions), strings.Join(report.lifecycleActions, " "))
+	}
+	return report
+}
+
+// groupGracePeriod returns the health check grace period of the given group
+// if it's enabled, otherwise zero.
+func (r *region) groupGracePeriod(name string) time.Duration {
+	for _, asg := range r.enabledASGs {
+		if asg.name == name && asg.HealthCheckGracePeriod != nil {
+			return time.Duration(*asg.HealthCheckGracePeriod) * time.Second
+		}
+	}
+	return 0
+}
+
+// orphanCandidates returns the running spot instances launched by AutoSpotting
+// for a group which didn't attach them long after their grace period.
+func (r *region) orphanCandidates(now time.Time) []*instance {
+	var candidates []*instance
+	ownership := r.conf.Ownership()
+
+	for i := range r.instances.instances() {
+		if !i.isManagedSpotInstance(ownership) || i.LaunchTime == nil {
+			continue
+		}
+
+		// instances without this tag were detached after an interruption
+		// notice and are already being taken care of
+		asgName := i.ownerGroup(ownership)
+		if asgName == "" {
+			continue
+		}
+
+		if now.Sub(*i.LaunchTime) > r.groupGracePeriod(asgName)+orphanGracePeriod {
+			candidates = append(candidates, i)
+		}
+	}
+	return candidates
+}
+
+func (r *region) terminateOrphanInstances() []string {
+	candidates := r.orphanCandidates(time.Now())
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	attached := make(map[string]bool)
+
+	// DescribeAutoScalingInstances accepts at most 50 instance IDs
+	for start := 0; start < len(candidates); start += 50 {
+		end := start + 50
+		if end > len(candidates) {
+			end = len(candidates)
+		}
+
+		var ids []*string
+		for _, i := range candidates[start:end] {
+			ids = append(ids, i.InstanceId)
+		}
+
+		out, err := r.services.autoScaling.DescribeAutoScalingInstances(
+			&autoscaling.DescribeAutoScalingInstancesInput{InstanceIds: ids})
+		if err != nil {
+			logger.Println(r.name, "Failed to determine the groups of the instances",
+				"launched by AutoSpotting, skipping cleanup:", err.Error())
+			return nil
+		}
+		for _, details := range out.AutoScalingInstances {
+			attached[*details.InstanceId] = true
+		}
+	}
+
+	var reclaimed []string
+	for _, i := range candidates {
+		if attached[*i.InstanceId] {
+			continue
+		}
+		logger.Println(r.name, "Terminating orphan instance", *i.InstanceId,
+			"launched at", i.LaunchTime.Format(time.RFC3339))
+		if err := i.terminate(); err == nil {
+			reclaimed = append(reclaimed, *i.InstanceId)
+		}
+	}
+	return reclaimed
+}
+
+func (r *region) cancelDanglingSpotRequests() []string {
+	requests, err := r.describeOwnedSpotRequests([]*ec2.Filter{
+		{Name: aws.String("state"), Values: []*string{aws.String(ec2.SpotInstanceStateOpen)}},
+	})
+	if err != nil {
+		logger.Println(r.name, "Failed to describe spot requests:", err.Error())
+		return nil
+	}
+
+	var ids []*string
+	for _, req := range requests {
+		if req.CreateTime != nil && time.Since(*req.CreateTime) > orphanGracePeriod {
+			ids = append(ids, req.SpotInstanceRequestId)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	logger.Println(r.name, "Cancelling dangling spot requests", strings.Join(aws.StringValueSlice(ids), " "))
+	if _, err := r.services.ec2.CancelSpotInstanceRequests(&ec2.CancelSpotInstanceRequestsInput{
+		SpotInstanceRequestIds: ids,
+	}); err != nil {
+		logger.Println(r.name, "Failed to cancel spot requests:", err.Error())
+		return nil
+	}
+	return aws.StringValueSlice(ids)
+}
+
+// completeStaleLifecycleActions continues the termination lifecycle actions
+// still waiting for instances which are no longer running, which may happen
+// when the process handling the hook crashed.
+func (r *region) completeStaleLifecycleActions() []string {
+	var reclaimed []string
+
+	for _, asg := range r.enabledASGs {
+		var stale []*string
+		for _, member := range asg.Instances {
+			if aws.StringValue(member.LifecycleState) == autoscaling.LifecycleStateTerminatingWait &&
+				r.instances.get(*member.InstanceId) == nil {
+				stale = append(stale, member.InstanceId)
+			}
+		}
+		if len(stale) == 0 {
+			continue
+		}
+
+		hooks, err := r.services.autoScaling.DescribeLifecycleHooks(&autoscaling.DescribeLifecycleHooksInput{
+			AutoScalingGroupName: aws.String(asg.name),
+		})
+		if err != nil {
+			logger.Println(r.name, asg.name, "Failed to describe lifecycle hooks:", err.Error())
+			continue
+		}
+
+		for _, hook := range hooks.LifecycleHooks {
+			if aws.StringValue(hook.LifecycleTransition) != "autoscaling:EC2_INSTANCE_TERMINATING" {
+				continue
+			}
+			for _, id := range stale {
+				logger.Println(r.name, asg.name, "Completing stale lifecycle action of",
+					*hook.LifecycleHookName, "for", *id)
+				if _, err := r.services.autoScaling.CompleteLifecycleAction(&autoscaling.CompleteLifecycleActionInput{
+					AutoScalingGroupName:  aws.String(asg.name),
+					LifecycleHookName:     hook.LifecycleHookName,
+					InstanceId:            id,
+					LifecycleActionResult: aws.String("CONTINUE"),
+				}); err != nil {
+					logger.Println(r.name, asg.name, "Failed to complete lifecycle action:", err.Error())
+					continue
+				}
+				reclaimed = append(reclaimed, *id)
+			}
+		}
+	}
+	return reclaimed
+}
@@ -0,0 +1,127 @@
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AutoSpotting/AutoSpotting/core/autospottingtest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_region_cleanupOrphans(t *testing.T) {
+	tests := []struct {
+		name           string
+		enabled        bool
+		want           cleanupReport
+		wantTerminated string
+		wantMembers    int
+	}{
+		{
+			name:    "enabled",
+			enabled: true,
+			want: cleanupReport{
+				instances:        []string{"i-orphan"},
+				spotRequests:     []string{"sir-old"},
+				lifecycleActions: []string{"i-gone"},
+			},
+			wantTerminated: "i-orphan",
+			wantMembers:    1,
+		},
+		{
+			name:        "disabled",
+			wantMembers: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cloud := autospottingtest.NewCloud()
+			fr := cloud.Region("us-east-1")
+
+			ago := func(d time.Duration) *time.Time {
+				return aws.Time(time.Now().Add(-d))
+			}
+
+			for _, inst := range []struct {
+				id       string
+				launched time.Duration
+				asg      string
+			}{
+				{id: "i-orphan", launched: 2 * time.Hour, asg: "asg"},
+				{id: "i-waiting", launched: 5 * time.Minute, asg: "asg"},
+				{id: "i-attached", launched: 2 * time.Hour, asg: "asg"},
+				{id: "i-detached", launched: 2 * time.Hour},
+				{id: "i-slow", launched: 150 * time.Minute, asg: "slow"},
+			} {
+				i := newTestInstance(inst.id, "m5.large", inst.launched).Instance
+				i.InstanceLifecycle = aws.String("spot")
+				i.Tags = tags("launched-by-autospotting", "true")
+				if inst.asg != "" {
+					i.Tags = append(i.Tags, tags("launched-for-asg", inst.asg)...)
+				}
+				fr.AddInstance(i)
+			}
+
+			group := &autoscaling.Group{
+				AutoScalingGroupName:   aws.String("asg"),
+				MinSize:                aws.Int64(1),
+				MaxSize:                aws.Int64(3),
+				DesiredCapacity:        aws.Int64(2),
+				HealthCheckGracePeriod: aws.Int64(60),
+				Instances: []*autoscaling.Instance{
+					{InstanceId: aws.String("i-attached"), LifecycleState: aws.String("InService")},
+					{InstanceId: aws.String("i-gone"), LifecycleState: aws.String(autoscaling.LifecycleStateTerminatingWait)},
+				},
+			}
+			slow := &autoscaling.Group{
+				AutoScalingGroupName:   aws.String("slow"),
+				MinSize:                aws.Int64(0),
+				MaxSize:                aws.Int64(1),
+				DesiredCapacity:        aws.Int64(0),
+				HealthCheckGracePeriod: aws.Int64(7200),
+			}
+			fr.AddGroup(group)
+			fr.AddGroup(slow)
+			fr.AddLifecycleHook("asg", &autoscaling.LifecycleHook{
+				LifecycleHookName:   aws.String("drain"),
+				LifecycleTransition: aws.String("autoscaling:EC2_INSTANCE_TERMINATING"),
+			})
+
+			requestTags := tags("launched-by-autospotting", "true")
+			for _, req := range []*ec2.SpotInstanceRequest{
+				{SpotInstanceRequestId: aws.String("sir-old"), CreateTime: ago(2 * time.Hour), Tags: requestTags},
+				{SpotInstanceRequestId: aws.String("sir-new"), CreateTime: ago(time.Minute), Tags: requestTags},
+				{SpotInstanceRequestId: aws.String("sir-foreign"), CreateTime: ago(2 * time.Hour)},
+			} {
+				req.State = aws.String(ec2.SpotInstanceStateOpen)
+				fr.AddSpotInstanceRequest(req)
+			}
+
+			r := newTestRegion(&Config{ClientProvider: cloud, CleanupOrphans: tt.enabled}, "asg", "slow")
+
+			if got := r.cleanupOrphans(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("cleanupOrphans() = %+v, want %+v", got, tt.want)
+			}
+
+			for _, i := range fr.Instances() {
+				wantState := "running"
+				if *i.InstanceId == tt.wantTerminated {
+					wantState = "terminated"
+				}
+				if *i.State.Name != wantState {
+					t.Errorf("instance %s is %s, expected %s", *i.InstanceId, *i.State.Name, wantState)
+				}
+			}
+
+			if state := *fr.SpotInstanceRequest("sir-foreign").State; state != "open" {
+				t.Errorf("spot request not launched by AutoSpotting was %s", state)
+			}
+
+			if g := fr.Group("asg"); len(g.Instances) != tt.wantMembers {
+				t.Errorf("group has the members %v, expected %d", g.Instances, tt.wantMembers)
+			}
+		})
+	}
+}
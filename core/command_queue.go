@@ -0,0 +1,457 @@
+package autospotting
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+const (
+	// ReplaceCommand immediately processes the given group, replacing its
+	// on-demand instances regardless of the cron schedule
+	ReplaceCommand = "replace"
+
+	// RevertCommand stops the replacement of the on-demand instances of the
+	// given group and gradually terminates its spot instances, so that the
+	// group replaces them with on-demand instances
+	RevertCommand = "revert"
+
+	// SnoozeCommand suspends the processing of the given group for the
+	// duration given in the command, by setting its snooze tag
+	SnoozeCommand = "snooze"
+
+	// processCommand processes the given group like a run would, within its
+	// cron schedule, only used internally by the event handlers
+	processCommand = "process"
+
+	// the maximum number of messages consumed from the queue in a single run
+	maxQueuedCommands = 100
+)
+
+// Command is an operation requested for a specific group by other automation,
+// such as deployment pipelines, for example:
+// {"action":"replace","asg":"my-group","region":"us-east-1"}
+type Command struct {
+	Action           string `json:"action"`
+	AutoScalingGroup string `json:"asg"`
+
+	// Defaults to the main region, or to the main region of the profile
+	Region string `json:"region"`
+
+	// The account of the group, reached through its cross-account role,
+	// defaults to the account AutoSpotting runs in
+	Account string `json:"account,omitempty"`
+
+	// The credential profile used for the group, defaults to the default
+	// credentials
+	Profile string `json:"profile,omitempty"`
+
+	// How long a group is snoozed, such as 2h, only used by snooze commands
+	Duration string `json:"for,omitempty"`
+}
+
+// ParseCommand decodes a JSON command and validates it.
+func ParseCommand(body string) (Command, error) {
+	var c Command
+	if err := json.Unmarshal([]byte(body), &c); err != nil {
+		return c, err
+	}
+
+	return c, c.validate()
+}
+
+func (c Command) validate() error {
+	switch c.Action {
+	case ReplaceCommand, RevertCommand:
+	case SnoozeCommand:
+		if d, err := time.ParseDuration(c.Duration); err != nil || d <= 0 {
+			return fmt.Errorf("invalid snooze duration '%s', expected a value such as 2h", c.Duration)
+		}
+	default:
+		return fmt.Errorf("unknown command action '%s'", c.Action)
+	}
+	if c.AutoScalingGroup == "" {
+		return errors.New("missing the asg field of the command")
+	}
+	return nil
+}
+
+// ExecuteCommand runs the given command against its group outside of a run,
+// such as when the Lambda function is triggered by the command queue, by Slack
+// or by an instance event. Like a run, each execution works on its own copy
+// of the configuration, with its own run ID, group locks, replacement limit
+// and change record, instead of reusing those left behind by the previous
+// invocations of the warm Lambda function.
+func ExecuteCommand(cfg *Config, c Command) error {
+
+	if logger == nil {
+		setupLogging(cfg)
+	}
+
+	run := *cfg
+	run.runID = newRunID()
+	logger.Println("Starting command execution", run.runID)
+
+	if run.DryRun {
+		run.AuditMode = true
+	}
+	run.audit = nil
+	if run.AuditMode {
+		run.audit = newAuditLog()
+		defer run.audit.report()
+	}
+
+	// the groups aren't changed in audit mode, so they aren't locked either
+	run.locks = nil
+	if !run.AuditMode {
+		run.locks = newGroupLocker(&run, run.runID)
+	}
+
+	run.replacements = newReplacementLimiter(run.MaxReplacementsPerRun)
+
+	run.changes = newChangeLog()
+	defer submitChangeTicket(&run)
+
+	return executeCommand(&run, c)
+}
+
+// commandConfig returns the configuration used for reaching the group of the
+// command, with the credentials of its profile and of the cross-account role
+// of its account, like the runs processing the group.
+func commandConfig(cfg *Config, c Command) (*Config, error) {
+	var err error
+	if c.Profile != "" {
+		if cfg, err = profileConfig(cfg, c.Profile); err != nil {
+			return nil, err
+		}
+	}
+	if c.Account != "" {
+		if cfg, err = accountConfig(cfg, c.Account); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// executeCommand runs the given command against its group, using the run ID,
+// the locks and the limits of the current run or command execution.
+func executeCommand(cfg *Config, c Command) error {
+	cfg, err := commandConfig(cfg, c)
+	if err != nil {
+		return err
+	}
+	if c.Region == "" {
+		c.Region = cfg.MainRegion
+	}
+
+	logger.Println(c.Region, "Executing", c.Action, "command for", c.AutoScalingGroup)
+
+	r := &region{name: c.Region, conf: cfg, services: connections{provider: cfg.ClientProvider, profile: cfg.profile, credentials: cfg.credentials, audit: cfg.audit}}
+	r.services.connect(c.Region)
+
+	group, err := r.describeGroup(c.AutoScalingGroup)
+	if err != nil {
+		return err
+	}
+
+	addDefaultFilteringMode(cfg)
+	addDefaultFilter(cfg)
+	r.setupAsgFilters()
+	if !r.matchesTagFilters(group, r.tagsToFilterASGsBy) {
+		return fmt.Errorf("group %s is not enabled for AutoSpotting", c.AutoScalingGroup)
+	}
+
+	if c.Action == SnoozeCommand {
+		d, _ := time.ParseDuration(c.Duration)
+		return snooze(r, c.AutoScalingGroup, time.Now().Add(d))
+	}
+
+	// unlike the other commands, replacing the instances of the group also
+	// honors its snooze, deployment and rollout settings
+	if c.Action != RevertCommand &&
+		len(r.findMatchingASGsInPageOfResults([]*autoscaling.Group{group}, r.tagsToFilterASGsBy)) == 0 {
+		return fmt.Errorf("group %s is not enabled for AutoSpotting", c.AutoScalingGroup)
+	}
+	r.determineInstanceTypeInformation(cfg, aws.StringValueSlice(group.AvailabilityZones))
+
+	if err := r.scanInstances(); err != nil {
+		logger.Println(c.Region, "Failed to scan instances", err.Error())
+		return err
+	}
+
+	addLogGroup(c.AutoScalingGroup)
+	asg := autoScalingGroup{
+		Group:          group,
+		name:           c.AutoScalingGroup,
+		region:         r,
+		config:         cfg.AutoScalingConfig,
+		ignoreSchedule: c.Action != processCommand,
+	}
+
+	if c.Action == RevertCommand {
+		return asg.revert()
+	}
+	return asg.process()
+}
+
+// describeGroup returns the group with the given name, or an error if it
+// doesn't exist.
+func (r *region) describeGroup(name string) (*autoscaling.Group, error) {
+	var group *autoscaling.Group
+	err := r.services.autoScaling.DescribeAutoScalingGroupsPages(
+		&autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: []*string{aws.String(name)},
+		},
+		func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+			if len(page.AutoScalingGroups) > 0 {
+				group = page.AutoScalingGroups[0]
+			}
+			return true
+		},
+	)
+	if err != nil {
+		logger.Println(r.name, "Failed to describe", name, err.Error())
+		return nil, err
+	}
+	if group == nil {
+		return nil, fmt.Errorf("group %s not found in %s", name, r.name)
+	}
+	return group, nil
+}
+
+// snooze tags the group so that it's left alone until the given moment.
+func snooze(r *region, asgName string, until time.Time) error {
+	logger.Println(r.name, asgName, "Snoozing until", until.UTC().Format(time.RFC3339))
+
+	_, err := r.services.autoScaling.CreateOrUpdateTags(&autoscaling.CreateOrUpdateTagsInput{
+		Tags: []*autoscaling.Tag{{
+			ResourceId:        aws.String(asgName),
+			ResourceType:      aws.String("auto-scaling-group"),
+			Key:               aws.String(SnoozeUntilTag),
+			Value:             aws.String(until.UTC().Format(time.RFC3339)),
+			PropagateAtLaunch: aws.Bool(false),
+		}},
+	})
+	if err != nil {
+		logger.Println(r.name, asgName, "Failed to tag group", err.Error())
+	}
+	return err
+}
+
+// revert keeps the group from being processed further by requiring all its
+// capacity to be on-demand, and marks it as being reverted, so that its spot
+// instances are terminated gradually by this and the next runs. It fails when
+// the group is locked by another invocation, so that the command queue retries
+// the command later.
+func (a *autoScalingGroup) revert() error {
+	unlock, locked := a.lock()
+	if !locked {
+		return fmt.Errorf("group %s is locked by another AutoSpotting invocation", a.name)
+	}
+	defer unlock()
+
+	a.scanInstances()
+	a.loadDefaultConfig()
+	a.loadConfigFromTags()
+
+	logger.Println(a.region.name, a.name, "Reverting to on-demand capacity")
+
+	_, err := a.region.services.autoScaling.CreateOrUpdateTags(&autoscaling.CreateOrUpdateTagsInput{
+		Tags: []*autoscaling.Tag{
+			{
+				ResourceId:        aws.String(a.name),
+				ResourceType:      aws.String("auto-scaling-group"),
+				Key:               aws.String(OnDemandPercentageTag),
+				Value:             aws.String("100"),
+				PropagateAtLaunch: aws.Bool(false),
+			},
+			{
+				ResourceId:        aws.String(a.name),
+				ResourceType:      aws.String("auto-scaling-group"),
+				Key:               aws.String(RevertingTag),
+				Value:             aws.String("true"),
+				PropagateAtLaunch: aws.Bool(false),
+			},
+		},
+	})
+	if err != nil {
+		logger.Println(a.region.name, a.name, "Failed to tag group", err.Error())
+		return err
+	}
+
+	return a.revertSpotInstances()
+}
+
+// isReverting tells if the group is being reverted to on-demand capacity by
+// the revert command.
+func (a *autoScalingGroup) isReverting() bool {
+	return getTagValueFromASGWithMatchingTag(a.Group, Tag{Key: RevertingTag, Value: "true"}) != nil
+}
+
+// revertSpotInstances terminates some of the spot instances of the group being
+// reverted, without decrementing its desired capacity so that the group
+// launches on-demand instances in their place. Like the replacements, they're
+// terminated within the batch size and the replacements allowed on this run,
+// at most one per partition, and only once the instances launched in place of
+// the previous ones are in service. The revert is complete when the group has
+// no spot instances left.
+func (a *autoScalingGroup) revertSpotInstances() error {
+	if spot := a.findUnattachedInstanceLaunchedForThisASG(); spot != nil {
+		logger.Println(a.region.name, a.name, "Terminating spot instance", *spot.InstanceId,
+			"not needed anymore by the group being reverted")
+		spot.terminate()
+	}
+
+	var spotInstances []*instance
+	for inst := range a.instances.instances() {
+		if inst.isSpot() {
+			spotInstances = append(spotInstances, inst)
+		}
+	}
+
+	if len(spotInstances) == 0 {
+		logger.Println(a.region.name, a.name, "Reverted to on-demand capacity")
+		_, err := a.region.services.autoScaling.DeleteTags(&autoscaling.DeleteTagsInput{
+			Tags: []*autoscaling.Tag{{
+				ResourceId:   aws.String(a.name),
+				ResourceType: aws.String("auto-scaling-group"),
+				Key:          aws.String(RevertingTag),
+			}},
+		})
+		if err != nil {
+			logger.Println(a.region.name, a.name, "Failed to delete the tag", RevertingTag, err.Error())
+		}
+		return err
+	}
+
+	if !a.membersHealthy() || !a.partitionsHealthy() {
+		logger.Println(a.region.name, a.name, "Waiting for the group to be healthy before",
+			"reverting any of its", len(spotInstances), "remaining spot instances")
+		return nil
+	}
+
+	size := a.config.ReplacementBatchSize
+	if size < 1 {
+		size = 1
+	}
+	if allowance := a.replacementAllowance(); allowance >= 0 && allowance < size {
+		size = allowance
+	}
+
+	spotInstances = a.distinctPartitions(spotInstances)
+	if len(spotInstances) > size {
+		spotInstances = spotInstances[:size]
+	}
+	if len(spotInstances) == 0 {
+		logger.Println(a.region.name, a.name, "Leaving the revert of the spot instances for the next run,",
+			"reached the maximum number of replacements of this run or hour")
+		return nil
+	}
+
+	for _, inst := range spotInstances {
+		if !a.region.conf.replacements.take() {
+			break
+		}
+
+		logger.Println(a.region.name, a.name, "Terminating spot instance", *inst.InstanceId)
+		_, err := a.region.services.autoScaling.TerminateInstanceInAutoScalingGroup(
+			&autoscaling.TerminateInstanceInAutoScalingGroupInput{
+				InstanceId:                     inst.InstanceId,
+				ShouldDecrementDesiredCapacity: aws.Bool(false),
+			})
+		if err != nil {
+			logger.Println(a.region.name, a.name, "Failed to terminate", *inst.InstanceId, err.Error())
+			return err
+		}
+		a.recordCompositionChange(time.Now())
+	}
+	return nil
+}
+
+// queueRegion determines the region of an SQS queue from its URL, such as
+// https://sqs.us-east-1.amazonaws.com/123456789012/queue
+func queueRegion(queueURL string) string {
+	u, err := url.Parse(queueURL)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(u.Host, ".")
+	if len(parts) > 2 && parts[0] == "sqs" {
+		return parts[1]
+	}
+	return ""
+}
+
+// ProcessCommandQueue consumes the commands currently available in the
+// configured SQS queue and executes them as part of the current run. Successfully executed and invalid
+// commands are deleted from the queue, the failed ones become visible again
+// after the queue's visibility timeout, so they can be retried.
+func ProcessCommandQueue(cfg *Config) {
+	region := queueRegion(cfg.CommandQueueURL)
+	if region == "" {
+		region = cfg.MainRegion
+	}
+
+	processCommandQueue(cfg, sqs.New(newSession(cfg, region)))
+}
+
+func processCommandQueue(cfg *Config, svc sqsiface.SQSAPI) {
+	for processed := 0; processed < maxQueuedCommands; {
+		out, err := svc.ReceiveMessage(&sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(cfg.CommandQueueURL),
+			MaxNumberOfMessages: aws.Int64(10),
+		})
+		if err != nil {
+			logger.Println("Failed to receive commands from", cfg.CommandQueueURL, err.Error())
+			return
+		}
+		if len(out.Messages) == 0 {
+			return
+		}
+
+		for _, m := range out.Messages {
+			processed++
+
+			c, err := ParseCommand(aws.StringValue(m.Body))
+			if err != nil {
+				logger.Println("Discarding invalid command", aws.StringValue(m.Body), err.Error())
+			} else if err := executeCommand(cfg, c); err != nil {
+				logger.Println("Command", aws.StringValue(m.Body), "failed, it will be retried:", err.Error())
+				continue
+			}
+
+			if _, err := svc.DeleteMessage(&sqs.DeleteMessageInput{
+				QueueUrl:      aws.String(cfg.CommandQueueURL),
+				ReceiptHandle: m.ReceiptHandle,
+			}); err != nil {
+				logger.Println("Failed to delete command from the queue", err.Error())
+			}
+		}
+	}
+}
+
+// queueCommand sends a command to the command queue, to be executed by the
+// next run or by the Lambda function triggered by the queue.
+func queueCommand(svc sqsiface.SQSAPI, queueURL string, c Command) error {
+	body, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	_, err = svc.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String(queueURL),
+		MessageBody: aws.String(string(body)),
+	})
+	if err != nil {
+		logger.Println("Failed to queue command", string(body), err.Error())
+	}
+	return err
+}
@@ -0,0 +1,346 @@
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AutoSpotting/AutoSpotting/core/autospottingtest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    Command
+		wantErr bool
+	}{
+		{
+			name: "replace",
+			body: `{"action":"replace","asg":"x","region":"us-east-1"}`,
+			want: Command{Action: ReplaceCommand, AutoScalingGroup: "x", Region: "us-east-1"},
+		},
+		{
+			name: "revert without region",
+			body: `{"action":"revert","asg":"y"}`,
+			want: Command{Action: RevertCommand, AutoScalingGroup: "y"},
+		},
+		{
+			name: "snooze",
+			body: `{"action":"snooze","asg":"x","for":"2h"}`,
+			want: Command{Action: SnoozeCommand, AutoScalingGroup: "x", Duration: "2h"},
+		},
+		{
+			name:    "snooze without duration",
+			body:    `{"action":"snooze","asg":"x"}`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown action",
+			body:    `{"action":"scale","asg":"y"}`,
+			wantErr: true,
+		},
+		{
+			name:    "missing group",
+			body:    `{"action":"replace"}`,
+			wantErr: true,
+		},
+		{
+			name:    "invalid JSON",
+			body:    `replace x`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseCommand(tt.body)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCommand() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseCommand() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_queueRegion(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{url: "https://sqs.eu-west-1.amazonaws.com/123456789012/commands", want: "eu-west-1"},
+		{url: "http://localhost:4566/000000000000/commands", want: ""},
+		{url: "", want: ""},
+	}
+
+	for _, tt := range tests {
+		if got := queueRegion(tt.url); got != tt.want {
+			t.Errorf("queueRegion(%q) = %q, want %q", tt.url, got, tt.want)
+		}
+	}
+}
+
+// commandTestConfig returns a fake cloud with a group running an on-demand
+// and a spot instance, outside of its cron schedule.
+func commandTestConfig(tags ...*autoscaling.TagDescription) (*Config, *autospottingtest.Region) {
+	cloud := autospottingtest.NewCloud()
+	r := cloud.Region("us-east-1")
+
+	r.AddInstance(&ec2.Instance{
+		InstanceId:         aws.String("i-ondemand"),
+		InstanceType:       aws.String("m5.large"),
+		LaunchTime:         aws.Time(time.Now().Add(-1 * time.Hour)),
+		Placement:          &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+		VirtualizationType: aws.String("hvm"),
+	})
+	r.AddInstance(&ec2.Instance{
+		InstanceId:         aws.String("i-spot"),
+		InstanceType:       aws.String("m5.large"),
+		InstanceLifecycle:  aws.String("spot"),
+		LaunchTime:         aws.Time(time.Now().Add(-1 * time.Hour)),
+		Placement:          &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+		VirtualizationType: aws.String("hvm"),
+	})
+	r.AddLaunchConfiguration(&autoscaling.LaunchConfiguration{
+		LaunchConfigurationName: aws.String("lc"),
+	})
+	r.AddGroup(&autoscaling.Group{
+		AutoScalingGroupName:    aws.String("asg"),
+		LaunchConfigurationName: aws.String("lc"),
+		MinSize:                 aws.Int64(1),
+		MaxSize:                 aws.Int64(3),
+		DesiredCapacity:         aws.Int64(2),
+		HealthCheckGracePeriod:  aws.Int64(60),
+		Instances: []*autoscaling.Instance{
+			{InstanceId: aws.String("i-ondemand"), AvailabilityZone: aws.String("us-east-1a"), ProtectedFromScaleIn: aws.Bool(false),
+				LifecycleState: aws.String("InService"), HealthStatus: aws.String("Healthy")},
+			{InstanceId: aws.String("i-spot"), AvailabilityZone: aws.String("us-east-1a"), ProtectedFromScaleIn: aws.Bool(false),
+				LifecycleState: aws.String("InService"), HealthStatus: aws.String("Healthy")},
+		},
+		Tags: tags,
+	})
+	r.SetSpotPrice("m5.large", "us-east-1a", 0.03)
+
+	cfg := e2eTestConfig(cloud)
+	cfg.MainRegion = "us-east-1"
+	// never run on the schedule, the commands should ignore it
+	cfg.CronScheduleState = "off"
+	return cfg, r
+}
+
+func TestExecuteCommand(t *testing.T) {
+	enabled := &autoscaling.TagDescription{Key: aws.String("spot-enabled"), Value: aws.String("true")}
+
+	t.Run("replace launches spot instance outside the schedule", func(t *testing.T) {
+		cfg, r := commandTestConfig(enabled)
+
+		if err := ExecuteCommand(cfg, Command{Action: ReplaceCommand, AutoScalingGroup: "asg"}); err != nil {
+			t.Fatalf("ExecuteCommand() error = %v", err)
+		}
+		if n := len(r.Instances()); n != 3 {
+			t.Errorf("expected a spot instance to be launched, got %d instances", n)
+		}
+	})
+
+	t.Run("replace of group not enabled", func(t *testing.T) {
+		cfg, r := commandTestConfig()
+
+		if err := ExecuteCommand(cfg, Command{Action: ReplaceCommand, AutoScalingGroup: "asg"}); err == nil {
+			t.Error("ExecuteCommand() expected an error for a group not enabled")
+		}
+		if n := len(r.Instances()); n != 2 {
+			t.Errorf("expected no instance to be launched, got %d instances", n)
+		}
+	})
+
+	t.Run("process respects the schedule", func(t *testing.T) {
+		cfg, r := commandTestConfig(enabled)
+
+		if err := ExecuteCommand(cfg, Command{Action: processCommand, AutoScalingGroup: "asg"}); err != nil {
+			t.Fatalf("ExecuteCommand() error = %v", err)
+		}
+		if n := len(r.Instances()); n != 2 {
+			t.Errorf("expected no instance to be launched outside the schedule, got %d instances", n)
+		}
+
+		cfg.CronScheduleState = "on"
+		if err := ExecuteCommand(cfg, Command{Action: processCommand, AutoScalingGroup: "asg"}); err != nil {
+			t.Fatalf("ExecuteCommand() error = %v", err)
+		}
+		if n := len(r.Instances()); n != 3 {
+			t.Errorf("expected a spot instance to be launched inside the schedule, got %d instances", n)
+		}
+	})
+
+	t.Run("revert", func(t *testing.T) {
+		cfg, r := commandTestConfig(enabled)
+
+		if err := ExecuteCommand(cfg, Command{Action: RevertCommand, AutoScalingGroup: "asg", Region: "us-east-1"}); err != nil {
+			t.Fatalf("ExecuteCommand() error = %v", err)
+		}
+
+		g := r.Group("asg")
+		if *g.DesiredCapacity != 2 || len(g.Instances) != 1 || *g.Instances[0].InstanceId != "i-ondemand" {
+			t.Errorf("unexpected group state after revert: %v", g)
+		}
+		if value := getTagValueFromASGWithMatchingTag(g, Tag{Key: OnDemandPercentageTag, Value: "100"}); value == nil {
+			t.Errorf("group wasn't tagged to keep its capacity on-demand: %v", g.Tags)
+		}
+	})
+
+	t.Run("revert of group not enabled", func(t *testing.T) {
+		cfg, r := commandTestConfig()
+
+		if err := ExecuteCommand(cfg, Command{Action: RevertCommand, AutoScalingGroup: "asg"}); err == nil {
+			t.Error("ExecuteCommand() expected an error for a group not enabled")
+		}
+		if g := r.Group("asg"); len(g.Instances) != 2 || len(g.Tags) != 0 {
+			t.Errorf("group not enabled was reverted: %v", g)
+		}
+	})
+
+	t.Run("revert is gradual", func(t *testing.T) {
+		cfg, r := commandTestConfig(enabled)
+		r.AddInstance(&ec2.Instance{
+			InstanceId:         aws.String("i-spot-2"),
+			InstanceType:       aws.String("m5.large"),
+			InstanceLifecycle:  aws.String("spot"),
+			LaunchTime:         aws.Time(time.Now().Add(-1 * time.Hour)),
+			Placement:          &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+			VirtualizationType: aws.String("hvm"),
+		})
+		g := r.Group("asg")
+		g.DesiredCapacity = aws.Int64(3)
+		g.Instances = append(g.Instances, &autoscaling.Instance{
+			InstanceId: aws.String("i-spot-2"), AvailabilityZone: aws.String("us-east-1a"), ProtectedFromScaleIn: aws.Bool(false),
+			LifecycleState: aws.String("InService"), HealthStatus: aws.String("Healthy"),
+		})
+
+		if err := ExecuteCommand(cfg, Command{Action: RevertCommand, AutoScalingGroup: "asg"}); err != nil {
+			t.Fatalf("ExecuteCommand() error = %v", err)
+		}
+		if n := len(r.Group("asg").Instances); n != 2 {
+			t.Fatalf("expected a single spot instance to be terminated, the group has %d instances", n)
+		}
+		if value := getTagValueFromASGWithMatchingTag(r.Group("asg"), Tag{Key: RevertingTag, Value: "true"}); value == nil {
+			t.Errorf("group wasn't tagged as being reverted: %v", r.Group("asg").Tags)
+		}
+
+		// the next run continues the revert, even outside the schedule
+		if err := ExecuteCommand(cfg, Command{Action: processCommand, AutoScalingGroup: "asg"}); err != nil {
+			t.Fatalf("ExecuteCommand() error = %v", err)
+		}
+		if g := r.Group("asg"); len(g.Instances) != 1 || *g.Instances[0].InstanceId != "i-ondemand" {
+			t.Errorf("unexpected group state after the next run: %v", g)
+		}
+
+		// and completes it once no spot instances are left
+		if err := ExecuteCommand(cfg, Command{Action: processCommand, AutoScalingGroup: "asg"}); err != nil {
+			t.Fatalf("ExecuteCommand() error = %v", err)
+		}
+		if value := getTagValueFromASGWithMatchingTag(r.Group("asg"), Tag{Key: RevertingTag, Value: "*"}); value != nil {
+			t.Errorf("group is still tagged as being reverted: %v", r.Group("asg").Tags)
+		}
+	})
+
+	t.Run("snooze", func(t *testing.T) {
+		cfg, r := commandTestConfig(enabled)
+
+		if err := ExecuteCommand(cfg, Command{Action: SnoozeCommand, AutoScalingGroup: "asg", Duration: "2h"}); err != nil {
+			t.Fatalf("ExecuteCommand() error = %v", err)
+		}
+
+		until, snoozed := isSnoozed(r.Group("asg"), time.Now())
+		if !snoozed || until.Before(time.Now().Add(119*time.Minute)) {
+			t.Errorf("group wasn't snoozed for two hours: %v", r.Group("asg").Tags)
+		}
+	})
+
+	t.Run("each execution gets its own run", func(t *testing.T) {
+		cfg, _ := commandTestConfig(enabled)
+		cfg.runID = "previous-run"
+		cfg.locks = &groupLocker{store: &fakeLockStore{}, owner: "previous-run"}
+		cfg.replacements = &replacementLimiter{max: 1, count: 1}
+		cfg.changes = newChangeLog()
+		locks, replacements, changes := cfg.locks, cfg.replacements, cfg.changes
+
+		if err := ExecuteCommand(cfg, Command{Action: SnoozeCommand, AutoScalingGroup: "asg", Duration: "1h"}); err != nil {
+			t.Fatalf("ExecuteCommand() error = %v", err)
+		}
+		if cfg.runID != "previous-run" || cfg.locks != locks || cfg.replacements != replacements || cfg.changes != changes {
+			t.Errorf("ExecuteCommand() changed the shared configuration: %+v", cfg)
+		}
+		if cfg.replacements.count != 1 {
+			t.Errorf("ExecuteCommand() used the replacement limit of the previous run")
+		}
+		if len(changes.changes) != 0 {
+			t.Errorf("ExecuteCommand() recorded its changes in the previous run: %v", changes.changes)
+		}
+	})
+
+	t.Run("unknown profile", func(t *testing.T) {
+		cfg, r := commandTestConfig(enabled)
+		cfg.ClientProvider = nil
+		cfg.Profiles = "commercial"
+
+		if err := ExecuteCommand(cfg, Command{Action: RevertCommand, AutoScalingGroup: "asg", Profile: "missing"}); err == nil {
+			t.Error("ExecuteCommand() expected an error for a profile which isn't configured")
+		}
+		if g := r.Group("asg"); len(g.Instances) != 2 || len(g.Tags) != 1 {
+			t.Errorf("group was changed using the default credentials: %v", g)
+		}
+	})
+
+	t.Run("missing group", func(t *testing.T) {
+		cfg, _ := commandTestConfig(enabled)
+
+		if err := ExecuteCommand(cfg, Command{Action: RevertCommand, AutoScalingGroup: "missing"}); err == nil {
+			t.Error("ExecuteCommand() expected an error for a missing group")
+		}
+	})
+}
+
+func Test_processCommandQueue(t *testing.T) {
+	cfg, r := commandTestConfig(&autoscaling.TagDescription{Key: aws.String("spot-enabled"), Value: aws.String("true")})
+	cfg.CommandQueueURL = "https://sqs.us-east-1.amazonaws.com/123456789012/commands"
+
+	svc := &mockSQS{rm: []*sqs.Message{
+		{Body: aws.String(`{"action":"revert","asg":"asg"}`), ReceiptHandle: aws.String("valid")},
+		{Body: aws.String(`not a command`), ReceiptHandle: aws.String("invalid")},
+		{Body: aws.String(`{"action":"revert","asg":"missing"}`), ReceiptHandle: aws.String("failed")},
+	}}
+
+	processCommandQueue(cfg, svc)
+
+	if want := []string{"valid", "invalid"}; !reflect.DeepEqual(svc.dm, want) {
+		t.Errorf("deleted messages = %v, want %v", svc.dm, want)
+	}
+	if g := r.Group("asg"); len(g.Instances) != 1 {
+		t.Errorf("revert command wasn't executed: %v", g)
+	}
+
+	// failing to receive messages is only logged
+	processCommandQueue(cfg, &mockSQS{rmerr: errors.New("AccessDenied")})
+}
+
+func Test_queueCommand(t *testing.T) {
+	svc := &mockSQS{}
+	c := Command{Action: ReplaceCommand, AutoScalingGroup: "asg", Region: "eu-west-1"}
+
+	if err := queueCommand(svc, "https://sqs.us-east-1.amazonaws.com/123456789012/commands", c); err != nil {
+		t.Fatalf("queueCommand() error = %v", err)
+	}
+	if len(svc.sm) != 1 {
+		t.Fatalf("expected one queued message, got %v", svc.sm)
+	}
+	if got, err := ParseCommand(svc.sm[0]); err != nil || got != c {
+		t.Errorf("queued command = %+v (%v), want %+v", got, err, c)
+	}
+}
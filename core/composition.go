@@ -0,0 +1,236 @@
+package autospotting
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// groupComposition is the number of running spot and on-demand instances of
+// a group, seen when processing it during a run.
+type groupComposition struct {
+	Account          string    `json:"account,omitempty"`
+	Region           string    `json:"region"`
+	AutoScalingGroup string    `json:"asg"`
+	Spot             int64     `json:"spot"`
+	OnDemand         int64     `json:"on_demand"`
+	Time             time.Time `json:"time"`
+}
+
+// spotPercentage returns the percentage of the running instances which are
+// spot instances.
+func (c groupComposition) spotPercentage() float64 {
+	if c.Spot+c.OnDemand == 0 {
+		return 0
+	}
+	return 100 * float64(c.Spot) / float64(c.Spot+c.OnDemand)
+}
+
+// compositionLog collects the compositions of the groups processed during a
+// run, stored in the run artifact and published as metrics.
+type compositionLog struct {
+	sync.Mutex
+	groups []groupComposition
+}
+
+func (l *compositionLog) record(c groupComposition) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.groups = append(l.groups, c)
+}
+
+func (l *compositionLog) list() []groupComposition {
+	if l == nil {
+		return nil
+	}
+	l.Lock()
+	defer l.Unlock()
+	return append([]groupComposition(nil), l.groups...)
+}
+
+// recordComposition counts the running spot and on-demand instances of the
+// group, before the replacements of this run.
+func (a *autoScalingGroup) recordComposition() {
+	c := groupComposition{
+		Account:          a.region.conf.accountID,
+		Region:           a.region.name,
+		AutoScalingGroup: a.name,
+		Time:             time.Now(),
+	}
+	for inst := range a.instances.instances() {
+		if inst.State == nil || aws.StringValue(inst.State.Name) != "running" {
+			continue
+		}
+		if inst.isSpot() {
+			c.Spot++
+		} else {
+			c.OnDemand++
+		}
+	}
+	a.region.conf.compositions.record(c)
+}
+
+// compositionData returns the metrics of the number of spot and on-demand
+// instances of each group.
+func compositionData(compositions []groupComposition, end time.Time) []*cloudwatch.MetricDatum {
+	var data []*cloudwatch.MetricDatum
+	for _, c := range compositions {
+		dimensions := []*cloudwatch.Dimension{
+			{Name: aws.String("Region"), Value: aws.String(c.Region)},
+			{Name: aws.String("AutoScalingGroupName"), Value: aws.String(c.AutoScalingGroup)},
+		}
+		if c.Account != "" {
+			dimensions = append(dimensions,
+				&cloudwatch.Dimension{Name: aws.String(accountDimension), Value: aws.String(c.Account)})
+		}
+		for _, d := range []*cloudwatch.MetricDatum{
+			metricDatum("SpotInstances", cloudwatch.StandardUnitCount, float64(c.Spot), end),
+			metricDatum("OnDemandInstances", cloudwatch.StandardUnitCount, float64(c.OnDemand), end),
+			metricDatum("SpotPercentage", cloudwatch.StandardUnitPercent, c.spotPercentage(), end),
+		} {
+			d.Dimensions = dimensions
+			data = append(data, d)
+		}
+	}
+	return data
+}
+
+// CompositionConfig stores the configuration of the report of the spot and
+// on-demand composition of the groups over time.
+type CompositionConfig struct {
+	// Location of the run artifacts, given as s3://bucket/prefix
+	Source string
+
+	// Time interval of the reported runs
+	From, To time.Time
+
+	// Only report this AutoScaling group
+	AutoScalingGroup string
+
+	// Region used for connecting to S3 if the bucket region can't be
+	// determined
+	Region string
+
+	Output io.Writer
+}
+
+// CompositionReport prints the number of spot and on-demand instances of the
+// groups over time, read from the run artifacts, so that the groups which
+// don't converge to their intended ratio or keep flipping between spot and
+// on-demand instances can be spotted.
+func CompositionReport(cfg CompositionConfig) error {
+
+	if logger == nil {
+		disableLogging()
+	}
+
+	bucket, prefix, err := parseS3URL(cfg.Source)
+	if err != nil {
+		return err
+	}
+
+	sess := newBucketSession(&Config{}, bucket, cfg.Region)
+
+	compositions, err := fetchCompositions(s3.New(sess), bucket, prefix, cfg)
+	if err != nil {
+		return err
+	}
+	return writeCompositionReport(cfg.Output, compositions)
+}
+
+// fetchCompositions reads the group compositions from the run artifacts
+// found under the given S3 prefix.
+func fetchCompositions(svc s3iface.S3API, bucket, prefix string, cfg CompositionConfig) ([]groupComposition, error) {
+	artifacts, err := fetchRunArtifacts(svc, bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var compositions []groupComposition
+	for _, artifact := range artifacts {
+		for _, c := range artifact.Groups {
+			if (!cfg.From.IsZero() && c.Time.Before(cfg.From)) || (!cfg.To.IsZero() && c.Time.After(cfg.To)) ||
+				(cfg.AutoScalingGroup != "" && c.AutoScalingGroup != cfg.AutoScalingGroup) {
+				continue
+			}
+			compositions = append(compositions, c)
+		}
+	}
+	return compositions, nil
+}
+
+// compositionTrend tells how the number of spot instances of a group changed
+// over the runs: stable when it never changed, converging when it only
+// changed in one direction, and thrashing when it went up and down several
+// times.
+func compositionTrend(series []groupComposition) (string, int) {
+	reversals, direction := 0, int64(0)
+	changed := false
+	for n := 1; n < len(series); n++ {
+		delta := series[n].Spot - series[n-1].Spot
+		if delta == 0 {
+			continue
+		}
+		changed = true
+		if direction != 0 && (delta > 0) != (direction > 0) {
+			reversals++
+		}
+		direction = delta
+	}
+
+	switch {
+	case !changed:
+		return "stable", reversals
+	case reversals >= 2:
+		return "thrashing", reversals
+	}
+	return "converging", reversals
+}
+
+func writeCompositionReport(out io.Writer, compositions []groupComposition) error {
+	groups := map[string][]groupComposition{}
+	var names []string
+	for _, c := range compositions {
+		name := c.Region + " " + c.AutoScalingGroup
+		if c.Account != "" {
+			name = c.Account + " " + name
+		}
+		if groups[name] == nil {
+			names = append(names, name)
+		}
+		groups[name] = append(groups[name], c)
+	}
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		fmt.Fprintln(out, "No group compositions found")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, name := range names {
+		series := groups[name]
+		sort.Slice(series, func(i, j int) bool { return series[i].Time.Before(series[j].Time) })
+
+		trend, reversals := compositionTrend(series)
+		fmt.Fprintf(w, "%s: %s, %d reversals over %d runs\n", name, trend, reversals, len(series))
+		fmt.Fprintln(w, "TIME\tSPOT\tON-DEMAND\tSPOT %")
+		for _, c := range series {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%.0f\n",
+				c.Time.UTC().Format(time.RFC3339), c.Spot, c.OnDemand, c.spotPercentage())
+		}
+		fmt.Fprintln(w)
+	}
+	return w.Flush()
+}
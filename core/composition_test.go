@@ -0,0 +1,134 @@
+package autospotting
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestRecordComposition(t *testing.T) {
+	a := newReplacementBatchGroup(2, mockEC2{})
+	a.region.conf.compositions = &compositionLog{}
+	a.instances.add(&instance{
+		Instance: &ec2.Instance{
+			InstanceId:        aws.String("i-spot"),
+			InstanceLifecycle: aws.String("spot"),
+			State:             &ec2.InstanceState{Name: aws.String("running")},
+		},
+	})
+	a.instances.add(&instance{
+		Instance: &ec2.Instance{
+			InstanceId:        aws.String("i-stopped"),
+			InstanceLifecycle: aws.String("spot"),
+			State:             &ec2.InstanceState{Name: aws.String("stopped")},
+		},
+	})
+
+	a.recordComposition()
+
+	got := a.region.conf.compositions.list()
+	if len(got) != 1 || got[0].Spot != 1 || got[0].OnDemand != 2 || got[0].AutoScalingGroup != a.name {
+		t.Errorf("recordComposition() recorded %+v, want 1 spot and 2 on-demand instances of %s", got, a.name)
+	}
+}
+
+func TestCompositionData(t *testing.T) {
+	end := time.Now()
+	data := compositionData([]groupComposition{
+		{Region: "us-east-1", AutoScalingGroup: "web", Spot: 3, OnDemand: 1},
+		{Account: "123456789012", Region: "us-east-1", AutoScalingGroup: "api"},
+	}, end)
+
+	if len(data) != 6 {
+		t.Fatalf("compositionData() returned %d metrics, want 6", len(data))
+	}
+	if name, value := aws.StringValue(data[2].MetricName), aws.Float64Value(data[2].Value); name != "SpotPercentage" || value != 75 {
+		t.Errorf("compositionData() returned %s = %v, want SpotPercentage = 75", name, value)
+	}
+	if n := len(data[3].Dimensions); n != 3 {
+		t.Errorf("compositionData() returned %d dimensions for another account, want 3", n)
+	}
+}
+
+func TestCompositionTrend(t *testing.T) {
+	series := func(spot ...int64) []groupComposition {
+		var result []groupComposition
+		for _, s := range spot {
+			result = append(result, groupComposition{Spot: s})
+		}
+		return result
+	}
+
+	tests := []struct {
+		name          string
+		series        []groupComposition
+		want          string
+		wantReversals int
+	}{
+		{"single run", series(2), "stable", 0},
+		{"stable", series(2, 2, 2), "stable", 0},
+		{"converging", series(0, 1, 1, 3), "converging", 0},
+		{"one reversal", series(0, 2, 1), "converging", 1},
+		{"thrashing", series(0, 2, 1, 2, 0), "thrashing", 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, reversals := compositionTrend(tt.series)
+			if got != tt.want || reversals != tt.wantReversals {
+				t.Errorf("compositionTrend() = %s, %d, want %s, %d", got, reversals, tt.want, tt.wantReversals)
+			}
+		})
+	}
+}
+
+func TestFetchCompositions(t *testing.T) {
+	disableLogging()
+	objects := map[string]string{
+		"runs/1.json": `{"run_id":"1","groups":[` +
+			`{"region":"us-east-1","asg":"web","spot":1,"on_demand":1,"time":"2020-11-01T10:00:00Z"},` +
+			`{"region":"us-east-1","asg":"api","spot":0,"on_demand":2,"time":"2020-11-01T10:00:00Z"}]}`,
+		"runs/2.json": `{"run_id":"2","groups":[` +
+			`{"region":"us-east-1","asg":"web","spot":2,"on_demand":0,"time":"2020-11-01T10:05:00Z"}]}`,
+		"runs/3.json": `{"run_id":"3","groups":[` +
+			`{"region":"us-east-1","asg":"web","spot":2,"on_demand":0,"time":"2020-11-02T10:00:00Z"}]}`,
+		"runs/bad.json": `not json`,
+	}
+	svc := mockS3{lo: &s3.ListObjectsV2Output{}, objects: map[string][]byte{}}
+	for key, body := range objects {
+		svc.lo.Contents = append(svc.lo.Contents, &s3.Object{Key: aws.String(key)})
+		svc.objects[key] = []byte(body)
+	}
+
+	got, err := fetchCompositions(svc, "bucket", "runs/", CompositionConfig{
+		AutoScalingGroup: "web",
+		To:               time.Date(2020, 11, 1, 23, 0, 0, 0, time.UTC),
+	})
+	if err != nil {
+		t.Fatalf("fetchCompositions() error = %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("fetchCompositions() returned %+v, want the 2 compositions of web on 2020-11-01", got)
+	}
+
+	var out bytes.Buffer
+	if err := writeCompositionReport(&out, got); err != nil {
+		t.Fatalf("writeCompositionReport() error = %v", err)
+	}
+	for _, want := range []string{"us-east-1 web: converging, 0 reversals over 2 runs",
+		"2020-11-01T10:05:00Z  2     0          100"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("writeCompositionReport() printed:\n%s\nmissing %q", out.String(), want)
+		}
+	}
+
+	out.Reset()
+	writeCompositionReport(&out, nil)
+	if !strings.Contains(out.String(), "No group compositions found") {
+		t.Errorf("writeCompositionReport() printed %q for no compositions", out.String())
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"io"
 	"time"
 
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	ec2instancesinfo "github.com/cristim/ec2-instances-info"
 )
 
@@ -34,6 +35,53 @@ const (
 	// terminate the spot instance (as TerminateTerminationNotificationAction), if not detach it.
 	AutoTerminationNotificationAction = "auto"
 
+	// SameSubnetSelection launches the spot instances in the subnet of the
+	// on-demand instances they replace.
+	SameSubnetSelection = "same-as-replaced"
+
+	// RoundRobinSubnetSelection launches the spot instances in turns in the
+	// subnets of the group from the availability zone of the replaced
+	// on-demand instances.
+	RoundRobinSubnetSelection = "round-robin"
+
+	// LeastUsedSubnetSelection launches the spot instances in the subnet of
+	// the group having the fewest instances of the group in the availability
+	// zone of the replaced on-demand instances.
+	LeastUsedSubnetSelection = "least-used"
+
+	// OneTimeSpotRequestType launches the spot instances using one-time spot
+	// requests, which are closed once the instance is launched.
+	OneTimeSpotRequestType = "one-time"
+
+	// PersistentSpotRequestType launches the spot instances using persistent
+	// spot requests, which stop the instances when interrupted and start them
+	// again once capacity is available.
+	PersistentSpotRequestType = "persistent"
+
+	// ReplaceConversionMode replaces the on-demand instances of the groups
+	// with spot instances, one at a time.
+	ReplaceConversionMode = "replace"
+
+	// MIPConversionMode converts the groups to a Mixed Instances Policy using
+	// diversified spot instance types, leaving the replacements to AutoScaling.
+	MIPConversionMode = "mip"
+
+	// RaiseMaxSizeStrategy attaches the spot instances before detaching the
+	// on-demand instances they replace when the groups are at their minimum
+	// capacity, temporarily raising the MaxSize of the groups which are also
+	// at their maximum capacity.
+	RaiseMaxSizeStrategy = "raise_max_size"
+
+	// DetachFirstStrategy always detaches the on-demand instances before
+	// attaching the spot instances replacing them, temporarily lowering the
+	// MinSize of the groups which are at their minimum capacity, so that the
+	// MaxSize of the groups is never changed.
+	DetachFirstStrategy = "detach_first"
+
+	// NoKeyPair is the KeyPair value launching the spot instances without any
+	// SSH key pair, such as when the access is done using SSM Session Manager.
+	NoKeyPair = "none"
+
 	// DefaultSchedule is the default value for the execution schedule in
 	// simplified Cron-style definition the cron format only accepts the hour and
 	// day of week fields, for example "9-18 1-5" would define the working week
@@ -56,12 +104,76 @@ type Config struct {
 	LogFile io.Writer
 	LogFlag int
 
+	// Format of the log lines, either text or json
+	LogFormat string
+
+	// Minimum level of the logged messages, one of debug, info, warn or error
+	LogLevel string
+
+	// Trace the Lambda invocations with X-Ray, when sampled by Lambda
+	XRayTracing bool
+
 	// The regions where it should be running
 	Regions string
 
 	// The region where the Lambda function is deployed
 	MainRegion string
 
+	// Optional factory for the AWS API clients, by default the real AWS APIs
+	// are used. Mainly useful when using AutoSpotting as a library.
+	ClientProvider ClientProvider
+
+	// Settings overridden for the groups of a region, keyed by region and
+	// by the names of the command-line flags of the settings which can be
+	// overridden by tags on a per-group level. The tags of the groups take
+	// precedence over them.
+	RegionOverrides map[string]map[string]string
+
+	// Settings overridden for the groups having a tag, such as the default
+	// settings of the groups of a team, keyed by the tag given as Key=Value.
+	// They take precedence over the RegionOverrides.
+	TagOverrides map[string]map[string]string
+
+	// Settings overridden for a group, keyed by group name, taking precedence
+	// over the TagOverrides and the RegionOverrides
+	GroupOverrides map[string]map[string]string
+
+	// Tags identifying the instances and spot requests launched by
+	// AutoSpotting, as parsed by ParseOwnership. Defaults to
+	// DefaultOwnershipTags.
+	OwnershipTags string
+
+	// Path of the SSM Parameter Store where the configuration is read from
+	// before each run, such as /autospotting, with one parameter for each
+	// setting, overriding the flags and environment variables
+	ParameterStorePath string
+
+	// Named profiles of the shared AWS configuration processed in turn during
+	// each run, given as space or comma separated <profile>[:<main region>]
+	// entries, such as "commercial:us-east-1 govcloud:us-gov-west-1", so that
+	// a single deployment covers accounts from several partitions. Empty uses
+	// the default credentials.
+	Profiles string
+
+	// IAM roles assumed for processing the accounts they belong to, given
+	// as space or comma separated role ARNs. Each account is processed in
+	// turn, and the metrics are also published for each of them, with the
+	// account ID as a dimension. Empty only processes the account of the
+	// credentials.
+	CrossAccountRoles string
+
+	// Selects the accounts of the organization processed by assuming the
+	// role named OrganizationRoleName in each of them, given as space or
+	// comma separated organizational unit or root IDs, including their
+	// nested units, and <tag key>=<tag value> account tags. The accounts
+	// matching any of them are processed, in addition to those of the
+	// CrossAccountRoles.
+	OrganizationAccounts string
+
+	// Name of the role assumed in the accounts discovered in the
+	// organization, defaults to DefaultOrganizationRoleName.
+	OrganizationRoleName string
+
 	// This is only here for tests, where we want to be able to somehow mock
 	// time.Sleep without actually sleeping. While testing it defaults to 0 (which won't sleep at all), in
 	// real-world usage it's expected to be set to 1
@@ -73,4 +185,293 @@ type Config struct {
 	// Controls how are the tags used to filter the groups.
 	// Available options: 'opt-in' and 'opt-out', default: 'opt-in'
 	TagFilteringMode string
+
+	// Chaos mode, deliberately interrupting some of the spot instances
+	// launched by AutoSpotting. Available options: 'off', 'event' and
+	// 'terminate', default: 'off'
+	ChaosMode string
+
+	// Percentage of the spot instances interrupted on each run when the chaos
+	// mode is enabled
+	ChaosPercentage float64
+
+	// URL of an SQS queue from which commands for specific groups are
+	// consumed on each run
+	CommandQueueURL string
+
+	// URL of an SQS FIFO queue through which the spot interruption and
+	// rebalance events are handled in order for each group
+	EventQueueURL string
+
+	// Reclaim the resources left behind by interrupted runs, such as spot
+	// instances launched by AutoSpotting but never attached to their group
+	CleanupOrphans bool
+
+	// Comma separated list of the experimental features to enable
+	Features string
+
+	// Percentage of the enabled groups AutoSpotting acts on, the groups are
+	// selected deterministically based on their name. Zero or 100 mean all
+	// the groups.
+	RolloutPercentage float64
+
+	// Comma separated list of groups processed before all the others, the
+	// rest of the run is aborted if any of their replacements fails
+	CanaryASGs string
+
+	// Maximum number of groups processed in parallel in each region, zero
+	// means no limit. The groups are started in the order of their priority.
+	MaxConcurrentGroups int
+
+	// ARN of the SNS topic where notifications are published, such as when
+	// the run is aborted because of canary failures
+	NotificationTopicARN string
+
+	// Notification destinations by event type, given as space or comma
+	// separated [<event>|<event>=]<kind>:<target> entries, such as
+	// error=pagerduty:<routing key> or summary=slack:<webhook URL>
+	Notifications string
+
+	// URL where a message is posted for each replacement, failed spot launch
+	// and spot interruption handled, formatted for Slack when it's a Slack
+	// incoming webhook
+	NotificationWebhookURL string
+
+	// Number of spot interruptions of a group within the InterruptionWindow
+	// above which its on-demand capacity is kept for the
+	// InterruptionCooldown, zero disables it
+	InterruptionThreshold int
+
+	// Period in which the spot interruptions of a group are counted
+	InterruptionWindow time.Duration
+
+	// Period during which the on-demand capacity of a group is kept after too
+	// many spot interruptions
+	InterruptionCooldown time.Duration
+
+	// Percentage of the capacity of a group kept on-demand during the
+	// InterruptionCooldown, when higher than its running on-demand capacity
+	InterruptionMinOnDemandPercentage float64
+
+	// Name of a DynamoDB table recording the spot interruptions of the
+	// groups, instead of their tags
+	InterruptionTable string
+
+	// Where the locks keeping the overlapping invocations from taking actions
+	// on the same group are stored, given as dynamodb:<table> or
+	// s3://bucket/prefix. Empty disables the locks.
+	LockLocation string
+
+	// Time after which the lock of a group is considered abandoned
+	LockTTL time.Duration
+
+	// Name of the DynamoDB table where each replacement, failed spot launch
+	// and spot interruption handled is recorded, as an audit trail of the
+	// actions
+	ActionLogTable string
+
+	// Period during which the same error of a group is only notified once,
+	// and during which at most NotificationRateLimit error notifications
+	// are sent
+	NotificationWindow time.Duration
+
+	// Maximum number of error notifications sent during the
+	// NotificationWindow, zero means no limit
+	NotificationRateLimit int
+
+	// Notifiers provided by library users, in addition to the ones given in
+	// the Notifications parameter
+	NotificationRoutes []NotificationRoute
+
+	// Skip the launches which would exceed the spot vCPU quotas of the account
+	CheckSpotQuotas bool
+
+	// Hook provided by library users, called when the replacement capacity
+	// of a region-flexible group could be provisioned in its failover region
+	RegionFailoverHook RegionFailoverHook
+
+	// URL where the failover requests of the region-flexible groups are
+	// posted as JSON, when their region ran out of spot capacity
+	RegionFailoverWebhookURL string
+
+	// Skip the instance types whose launch is denied by the guardrails of the
+	// account, such as service control policies, checked by dry runs
+	CheckLaunchPermissions bool
+
+	// Percentage of a spot vCPU quota above which a warning notification is
+	// sent, zero disables the warnings
+	SpotQuotaWarningThreshold float64
+
+	// Percentage by which the spot vCPU quotas are automatically increased
+	// through Service Quotas requests when reaching the warning threshold,
+	// zero only suggests the increase in the warning
+	SpotQuotaIncreasePercentage float64
+
+	// Comma separated list of the managed on-demand AWS Batch compute
+	// environments converted to spot
+	BatchComputeEnvironments string
+
+	// ARN of the IAM role used by the spot compute environments created for
+	// the converted AWS Batch compute environments
+	BatchSpotFleetRole string
+
+	// Signing secret of the Slack app sending slash commands, the commands
+	// are rejected when not set
+	SlackSigningSecret string
+
+	// URL of a webhook receiving the replacements performed during each run,
+	// such as one creating change records in an ITSM system
+	ChangeWebhookURL string
+
+	// Template of the change webhook payload, one of generic, servicenow and
+	// jira:<project key>, or a Go template
+	ChangeWebhookTemplate string
+
+	// Value of the Authorization header sent to the change webhook
+	ChangeWebhookAuthorization string
+
+	// Skip the groups whose on-demand instances are fully covered by zonal
+	// reserved instances, which are already cheaper than spot
+	CheckReservations bool
+
+	// Include the costs of the EBS volumes in the price comparisons between
+	// the on-demand instances and their spot replacements
+	IncludeEBSCosts bool
+
+	// HTTP endpoint or SQS queue URL receiving the interruption notices of
+	// the spot instances, so that the workload can checkpoint its state
+	InterruptionNoticeEndpoint string
+
+	// Minimum time the on-demand instances have been running before they
+	// can be replaced, so that instances still being configured by
+	// deployment tooling after a scale-out are left alone
+	MinInstanceUptime time.Duration
+
+	// Key of the tag set to true by CI/CD systems on the groups being
+	// deployed, pausing their processing until the tag is cleared. Empty
+	// disables the deployment freeze detection.
+	DeploymentFreezeTag string
+
+	// Number of consecutive runs a cheaper instance type needs to beat the
+	// type of the current spot instances of a group before the group is
+	// moved to it. Zero disables the price hysteresis.
+	PriceHysteresisRuns int
+
+	// Margin by which the cheaper instance type needs to beat the current
+	// one, as a percentage of the current type's price
+	PriceHysteresisPercentage float64
+
+	// S3 location given as s3://bucket/prefix where a JSON document
+	// describing each run is stored, including the instance types considered
+	// for each launch. Empty disables it.
+	RunArtifactLocation string
+
+	// CloudWatch namespace the metrics of each run are published to, such as
+	// the number of replaced instances and the estimated savings. Empty
+	// disables them.
+	MetricsNamespace string
+
+	// Set the desired capacity of the groups back to what it was before a
+	// replacement when it changed meanwhile, instead of only notifying it
+	CorrectCapacityDrift bool
+
+	// Drain the instances which are Kubernetes nodes of EKS clusters before
+	// terminating them, evicting their pods while respecting their
+	// PodDisruptionBudgets
+	DrainKubernetesNodes bool
+
+	// Name of the EKS cluster the drained instances are nodes of, when it
+	// isn't given by their tags
+	KubernetesCluster string
+
+	// Maximum time given to the pods of a drained Kubernetes node to be
+	// evicted, after which the instance is terminated anyway
+	KubernetesDrainTimeout time.Duration
+
+	// Set the instances which are ECS container instances to DRAINING before
+	// terminating them, waiting for their tasks to be rescheduled
+	DrainECSContainerInstances bool
+
+	// Name of the ECS cluster the drained instances are registered to, when
+	// it isn't given by their tags
+	ECSCluster string
+
+	// Maximum time given to the tasks of a draining ECS container instance to
+	// be rescheduled, after which the instance is terminated anyway
+	ECSDrainTimeout time.Duration
+
+	// Follow the Elastic Beanstalk environments owning some of the groups,
+	// skipping their groups while the environments are being updated and
+	// waiting for the new spot instances to be healthy in them
+	ElasticBeanstalkSupport bool
+
+	// Replace the standalone EC2 instances opted in by their StandaloneTag,
+	// which aren't part of any AutoScaling group, with spot instances keeping
+	// their network interfaces and EBS volumes
+	StandaloneInstanceSupport bool
+
+	// Space or comma separated list of <x86_64 AMI ID>=<arm64 AMI ID> pairs
+	// giving the AMIs of the ARM64 spot instances replacing the x86_64
+	// on-demand instances of the groups converted by their ArchConversionTag
+	ARM64AMIs string
+
+	// Evaluate the groups and report the actions that would be taken,
+	// without calling any of the mutating AWS APIs
+	AuditMode bool
+
+	// Same as AuditMode, which it enables
+	DryRun bool
+
+	// Identifies the current run, it's set on the launched spot instances
+	runID string
+
+	// The credential profile being processed, empty for the default
+	// credentials
+	profile string
+
+	// The cross-account role being processed and the account it belongs to,
+	// with its credentials, empty when processing the account of the
+	// credentials
+	role        string
+	accountID   string
+	credentials *credentials.Credentials
+
+	// The locks of the groups, nil when they're disabled
+	locks *groupLocker
+
+	// The replacements allowed during the current run, nil when unlimited
+	replacements *replacementLimiter
+
+	// The tags identifying the launched resources during the current run
+	ownership *Ownership
+
+	// Where the notifications of the current run are sent
+	notificationRoutes []NotificationRoute
+
+	// The errors of the groups, notified at the end of each run
+	groupErrors *notificationThrottle
+
+	// The mutating AWS API calls blocked during the current run, nil unless
+	// running in audit mode
+	audit *auditLog
+
+	// The replacements performed during the current run
+	changes *changeLog
+
+	// The groups processed during the current run
+	metrics *runMetrics
+
+	// The spot prices fetched during the current run
+	spotPrices *spotPriceCache
+
+	// The instance types considered for the launches of the current run
+	launchTraces *launchTraceLog
+
+	// The spot and on-demand instances of the groups processed during the
+	// current run
+	compositions *compositionLog
+
+	// The interruption history of the instance types, loaded once per run
+	// when needed
+	spotAdvisor *spotAdvisor
 }
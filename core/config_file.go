@@ -0,0 +1,205 @@
+package autospotting
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// tagPrefix is the prefix of the tags overriding a global setting on a
+// per-group level, followed by the name of the overridden command-line flag.
+const tagPrefix = "autospotting_"
+
+// ConfigFile is the configuration loaded from the Parameter Store or from a
+// YAML or JSON configuration file, such as:
+//
+//	min_on_demand_percentage: 10
+//	regions: [eu-west-1, us-east-1]
+//	region_overrides:
+//	  eu-west-1:
+//	    bidding_policy: aggressive
+//	tag_overrides:
+//	  Team=payments:
+//	    min_on_demand_number: 1
+//	group_overrides:
+//	  web:
+//	    min_on_demand_number: 2
+//
+// The settings are keyed by the names of their command-line flags. The lists
+// are given to the flags as comma separated values.
+type ConfigFile struct {
+	// The global settings
+	Settings map[string]string
+
+	// The settings overridden for the groups of a region, keyed by region
+	RegionOverrides map[string]map[string]string
+
+	// The settings overridden for the groups having a tag, keyed by the tag
+	// given as Key=Value
+	TagOverrides map[string]map[string]string
+
+	// The settings overridden for a group, keyed by group name
+	GroupOverrides map[string]map[string]string
+}
+
+type configFileContent struct {
+	Settings        map[string]interface{}            `yaml:",inline"`
+	RegionOverrides map[string]map[string]interface{} `yaml:"region_overrides"`
+	TagOverrides    map[string]map[string]interface{} `yaml:"tag_overrides"`
+	GroupOverrides  map[string]map[string]interface{} `yaml:"group_overrides"`
+}
+
+// LoadConfigFile reads the YAML or JSON configuration file at the given path.
+func LoadConfigFile(path string) (*ConfigFile, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseConfigFile(data)
+}
+
+// parseConfigFile parses the content of a configuration file, JSON documents
+// being valid YAML.
+func parseConfigFile(data []byte) (*ConfigFile, error) {
+	var content configFileContent
+	if err := yaml.UnmarshalStrict(data, &content); err != nil {
+		return nil, err
+	}
+
+	settings, err := configFileSettings(content.Settings)
+	if err != nil {
+		return nil, err
+	}
+	f := &ConfigFile{Settings: settings}
+
+	if f.RegionOverrides, err = configFileOverrides(content.RegionOverrides); err != nil {
+		return nil, fmt.Errorf("region_overrides: %s", err.Error())
+	}
+	if f.TagOverrides, err = configFileOverrides(content.TagOverrides); err != nil {
+		return nil, fmt.Errorf("tag_overrides: %s", err.Error())
+	}
+	for tag := range f.TagOverrides {
+		if _, _, ok := splitTagOverride(tag); !ok {
+			return nil, fmt.Errorf("tag_overrides: %s isn't given as Key=Value", tag)
+		}
+	}
+	if f.GroupOverrides, err = configFileOverrides(content.GroupOverrides); err != nil {
+		return nil, fmt.Errorf("group_overrides: %s", err.Error())
+	}
+	return f, nil
+}
+
+func configFileOverrides(overrides map[string]map[string]interface{}) (map[string]map[string]string, error) {
+	if len(overrides) == 0 {
+		return nil, nil
+	}
+	result := make(map[string]map[string]string, len(overrides))
+	for name, values := range overrides {
+		settings, err := configFileSettings(values)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err.Error())
+		}
+		result[name] = settings
+	}
+	return result, nil
+}
+
+// configFileSettings converts the settings to the string values given to
+// their flags.
+func configFileSettings(values map[string]interface{}) (map[string]string, error) {
+	settings := make(map[string]string, len(values))
+	for name, value := range values {
+		switch v := value.(type) {
+		case nil:
+			settings[name] = ""
+		case []interface{}:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				if !isScalar(item) {
+					return nil, fmt.Errorf("unsupported value of %s: %v", name, value)
+				}
+				items = append(items, fmt.Sprint(item))
+			}
+			settings[name] = strings.Join(items, ",")
+		default:
+			if !isScalar(v) {
+				return nil, fmt.Errorf("unsupported value of %s: %v", name, value)
+			}
+			settings[name] = fmt.Sprint(v)
+		}
+	}
+	return settings, nil
+}
+
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case string, bool, int, int64, uint64, float64:
+		return true
+	}
+	return false
+}
+
+// overriddenSetting returns the value of the given per-group setting from the
+// overrides of the group, or else from the overrides of its tags, or else
+// from the overrides of its region.
+func (cfg *Config) overriddenSetting(regionName, groupName string, tags []*autoscaling.TagDescription, tagKey string) *string {
+	if !strings.HasPrefix(tagKey, tagPrefix) {
+		return nil
+	}
+	name := strings.TrimPrefix(tagKey, tagPrefix)
+
+	if v, ok := cfg.GroupOverrides[groupName][name]; ok {
+		return &v
+	}
+	if v := cfg.tagOverriddenSetting(tags, name); v != nil {
+		return v
+	}
+	if v, ok := cfg.RegionOverrides[regionName][name]; ok {
+		return &v
+	}
+	return nil
+}
+
+// tagOverriddenSetting returns the value of the given setting from the
+// overrides of the first of the tags of the group having any, in the
+// alphabetical order of the overridden tags.
+func (cfg *Config) tagOverriddenSetting(tags []*autoscaling.TagDescription, name string) *string {
+	if len(cfg.TagOverrides) == 0 {
+		return nil
+	}
+
+	overridden := make([]string, 0, len(cfg.TagOverrides))
+	for tag := range cfg.TagOverrides {
+		overridden = append(overridden, tag)
+	}
+	sort.Strings(overridden)
+
+	for _, tag := range overridden {
+		v, ok := cfg.TagOverrides[tag][name]
+		if !ok {
+			continue
+		}
+		key, value, _ := splitTagOverride(tag)
+		for _, t := range tags {
+			if aws.StringValue(t.Key) == key && aws.StringValue(t.Value) == value {
+				return &v
+			}
+		}
+	}
+	return nil
+}
+
+// splitTagOverride returns the key and the value of a tag given as
+// Key=Value.
+func splitTagOverride(tag string) (string, string, bool) {
+	parts := strings.SplitN(tag, "=", 2)
+	if len(parts) != 2 || parts[0] == "" {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
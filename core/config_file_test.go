@@ -0,0 +1,186 @@
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func Test_parseConfigFile(t *testing.T) {
+	want := &ConfigFile{
+		Settings: map[string]string{
+			"min_on_demand_percentage":     "10",
+			"regions":                      "eu-west-1,us-east-1",
+			"audit_mode":                   "true",
+			"spot_price_buffer_percentage": "12.5",
+		},
+		RegionOverrides: map[string]map[string]string{
+			"eu-west-1": {"bidding_policy": "aggressive"},
+		},
+		TagOverrides: map[string]map[string]string{
+			"Team=payments": {"min_on_demand_percentage": "50"},
+		},
+		GroupOverrides: map[string]map[string]string{
+			"web": {"min_on_demand_number": "2", "allowed_instance_types": "m5.*,c5.large"},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		data    string
+		want    *ConfigFile
+		wantErr bool
+	}{
+		{
+			name: "yaml",
+			data: `
+min_on_demand_percentage: 10
+regions: [eu-west-1, us-east-1]
+audit_mode: true
+spot_price_buffer_percentage: 12.5
+region_overrides:
+  eu-west-1:
+    bidding_policy: aggressive
+tag_overrides:
+  Team=payments:
+    min_on_demand_percentage: 50
+group_overrides:
+  web:
+    min_on_demand_number: 2
+    allowed_instance_types:
+      - m5.*
+      - c5.large
+`,
+			want: want,
+		},
+		{
+			name: "json",
+			data: `{
+  "min_on_demand_percentage": 10,
+  "regions": ["eu-west-1", "us-east-1"],
+  "audit_mode": true,
+  "spot_price_buffer_percentage": 12.5,
+  "region_overrides": {"eu-west-1": {"bidding_policy": "aggressive"}},
+  "tag_overrides": {"Team=payments": {"min_on_demand_percentage": 50}},
+  "group_overrides": {"web": {"min_on_demand_number": 2, "allowed_instance_types": ["m5.*", "c5.large"]}}
+}`,
+			want: want,
+		},
+		{
+			name: "empty",
+			data: ``,
+			want: &ConfigFile{Settings: map[string]string{}},
+		},
+		{
+			name:    "nested settings",
+			data:    "regions:\n  eu-west-1: true\n",
+			wantErr: true,
+		},
+		{
+			name:    "nested overrides",
+			data:    "group_overrides:\n  web:\n    bidding_policy: [{a: b}]\n",
+			wantErr: true,
+		},
+		{
+			name:    "tag without value",
+			data:    "tag_overrides:\n  Team:\n    bidding_policy: aggressive\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid document",
+			data:    "regions: [eu-west-1",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseConfigFile([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseConfigFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseConfigFile() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_getTagValueOverrides(t *testing.T) {
+	cfg := &Config{
+		RegionOverrides: map[string]map[string]string{
+			"eu-west-1": {"bidding_policy": "aggressive", "min_on_demand_number": "1"},
+		},
+		TagOverrides: map[string]map[string]string{
+			"Team=payments":  {"bidding_policy": "normal", "min_on_demand_percentage": "50"},
+			"Env=production": {"min_on_demand_percentage": "25", "allowed_instance_types": "m5.*"},
+			"Team=search":    {"priority": "1"},
+		},
+		GroupOverrides: map[string]map[string]string{
+			"web": {"min_on_demand_number": "2", "spot_price_buffer_percentage": "5"},
+		},
+	}
+	a := &autoScalingGroup{
+		name:   "web",
+		region: &region{name: "eu-west-1", conf: cfg},
+		Group: &autoscaling.Group{
+			Tags: []*autoscaling.TagDescription{
+				{Key: aws.String(SpotPriceBufferPercentageTag), Value: aws.String("15")},
+				{Key: aws.String("Team"), Value: aws.String("payments")},
+				{Key: aws.String("Env"), Value: aws.String("production")},
+			},
+		},
+	}
+
+	tests := []struct {
+		tag  string
+		want *string
+	}{
+		{tag: BiddingPolicyTag, want: aws.String("normal")},
+		{tag: OnDemandNumberLong, want: aws.String("2")},
+		{tag: SpotPriceBufferPercentageTag, want: aws.String("15")},
+		{tag: OnDemandPercentageTag, want: aws.String("25")},
+		{tag: AllowedInstanceTypesTag, want: aws.String("m5.*")},
+		{tag: PriorityTag, want: nil},
+	}
+	for _, tt := range tests {
+		if got := a.getTagValue(tt.tag); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("getTagValue(%s) = %v, want %v", tt.tag, aws.StringValue(got), aws.StringValue(tt.want))
+		}
+	}
+
+	a.name = "api"
+	if got := a.getTagValue(OnDemandNumberLong); aws.StringValue(got) != "1" {
+		t.Errorf("getTagValue() = %v, want the region's override", aws.StringValue(got))
+	}
+}
+
+func Test_autoScalingGroup_getFirstTagValueOverrides(t *testing.T) {
+	cfg := &Config{
+		TagOverrides: map[string]map[string]string{
+			"Team=payments": {"bidding_policy": "aggressive"},
+		},
+	}
+	a := &autoScalingGroup{
+		name:   "web",
+		region: &region{name: "eu-west-1", conf: cfg},
+		Group: &autoscaling.Group{
+			Tags: []*autoscaling.TagDescription{
+				{Key: aws.String(BiddingPolicyAliasTag), Value: aws.String("normal")},
+				{Key: aws.String("Team"), Value: aws.String("payments")},
+			},
+		},
+	}
+
+	got, key := a.getFirstTagValue(BiddingPolicyTag, BiddingPolicyAliasTag)
+	if aws.StringValue(got) != "normal" || key != BiddingPolicyAliasTag {
+		t.Errorf("getFirstTagValue() = %v from %s, want the alias tag of the group", aws.StringValue(got), key)
+	}
+
+	a.Tags = a.Tags[1:]
+	got, key = a.getFirstTagValue(BiddingPolicyTag, BiddingPolicyAliasTag)
+	if aws.StringValue(got) != "aggressive" || key != BiddingPolicyTag {
+		t.Errorf("getFirstTagValue() = %v from %s, want the override of the first key", aws.StringValue(got), key)
+	}
+}
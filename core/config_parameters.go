@@ -0,0 +1,88 @@
+package autospotting
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
+)
+
+// LoadParameters reads the configuration stored in the SSM Parameter Store
+// of the main region under the given path, such as /autospotting, where each
+// parameter is named after the flag of its setting, for example
+// /autospotting/min_on_demand_percentage. The settings overridden for the
+// groups of a region, for the groups having a tag or for a group are stored
+// as /autospotting/region_overrides/<region>/<setting>,
+// /autospotting/tag_overrides/<tag key>/<tag value>/<setting> and
+// /autospotting/group_overrides/<group>/<setting>.
+func LoadParameters(cfg *Config, path string) (*ConfigFile, error) {
+	var svc ssmiface.SSMAPI
+	if cfg.ClientProvider == nil {
+		svc = ssm.New(newSession(cfg, cfg.MainRegion))
+	} else if p, ok := cfg.ClientProvider.(SSMClientProvider); ok {
+		svc = p.SSM(cfg.MainRegion)
+	} else {
+		return nil, fmt.Errorf("the client provider doesn't create SSM clients")
+	}
+	return loadParameters(svc, path)
+}
+
+func loadParameters(svc ssmiface.SSMAPI, path string) (*ConfigFile, error) {
+	path = "/" + strings.Trim(path, "/")
+	f := &ConfigFile{Settings: map[string]string{}}
+
+	var invalid []string
+	err := svc.GetParametersByPathPages(&ssm.GetParametersByPathInput{
+		Path:           aws.String(path),
+		Recursive:      aws.Bool(true),
+		WithDecryption: aws.Bool(true),
+	}, func(page *ssm.GetParametersByPathOutput, lastPage bool) bool {
+		for _, p := range page.Parameters {
+			name := strings.TrimPrefix(aws.StringValue(p.Name), path+"/")
+			if !f.set(strings.Split(name, "/"), aws.StringValue(p.Value)) {
+				invalid = append(invalid, aws.StringValue(p.Name))
+			}
+		}
+		return true
+	})
+	if err != nil {
+		logger.Println("Failed to read the parameters under", path, err.Error())
+		return nil, err
+	}
+
+	if len(invalid) > 0 {
+		logger.Println("Ignoring the parameters not matching any setting:", strings.Join(invalid, ", "))
+	}
+	return f, nil
+}
+
+// set stores the value of a setting given by the path of its parameter,
+// relative to the configuration's path.
+func (f *ConfigFile) set(path []string, value string) bool {
+	switch {
+	case len(path) == 1:
+		f.Settings[path[0]] = value
+	case len(path) == 3 && path[0] == "region_overrides":
+		f.RegionOverrides = setOverride(f.RegionOverrides, path[1], path[2], value)
+	case len(path) == 4 && path[0] == "tag_overrides" && path[1] != "":
+		f.TagOverrides = setOverride(f.TagOverrides, path[1]+"="+path[2], path[3], value)
+	case len(path) == 3 && path[0] == "group_overrides":
+		f.GroupOverrides = setOverride(f.GroupOverrides, path[1], path[2], value)
+	default:
+		return false
+	}
+	return true
+}
+
+func setOverride(overrides map[string]map[string]string, name, setting, value string) map[string]map[string]string {
+	if overrides == nil {
+		overrides = map[string]map[string]string{}
+	}
+	if overrides[name] == nil {
+		overrides[name] = map[string]string{}
+	}
+	overrides[name][setting] = value
+	return overrides
+}
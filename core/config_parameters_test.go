@@ -0,0 +1,64 @@
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func Test_loadParameters(t *testing.T) {
+	param := func(name, value string) *ssm.Parameter {
+		return &ssm.Parameter{Name: aws.String(name), Value: aws.String(value)}
+	}
+
+	svc := &mockSSM{gpbp: []*ssm.GetParametersByPathOutput{
+		{Parameters: []*ssm.Parameter{
+			param("/autospotting/min_on_demand_percentage", "10"),
+			param("/autospotting/regions", "eu-west-1,us-east-1"),
+		}},
+		{Parameters: []*ssm.Parameter{
+			param("/autospotting/region_overrides/eu-west-1/bidding_policy", "aggressive"),
+			param("/autospotting/tag_overrides/Team/payments/min_on_demand_percentage", "50"),
+			param("/autospotting/group_overrides/web/min_on_demand_number", "2"),
+			param("/autospotting/group_overrides/web/spot_price_buffer_percentage", "5"),
+			param("/autospotting/unknown/nested", "ignored"),
+		}},
+	}}
+
+	got, err := loadParameters(svc, "/autospotting/")
+	if err != nil {
+		t.Fatalf("loadParameters() error = %v", err)
+	}
+	want := &ConfigFile{
+		Settings: map[string]string{
+			"min_on_demand_percentage": "10",
+			"regions":                  "eu-west-1,us-east-1",
+		},
+		RegionOverrides: map[string]map[string]string{
+			"eu-west-1": {"bidding_policy": "aggressive"},
+		},
+		TagOverrides: map[string]map[string]string{
+			"Team=payments": {"min_on_demand_percentage": "50"},
+		},
+		GroupOverrides: map[string]map[string]string{
+			"web": {"min_on_demand_number": "2", "spot_price_buffer_percentage": "5"},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("loadParameters() = %+v, want %+v", got, want)
+	}
+
+	if _, err := loadParameters(&mockSSM{gpbperr: errors.New("AccessDenied")}, "autospotting"); err == nil {
+		t.Error("expected the Parameter Store error to be returned")
+	}
+}
+
+func TestLoadParametersWithoutSSMClients(t *testing.T) {
+	cfg := &Config{ClientProvider: mockClientProvider{}}
+	if _, err := LoadParameters(cfg, "/autospotting"); err == nil {
+		t.Error("expected an error when the client provider doesn't create SSM clients")
+	}
+}
@@ -4,33 +4,123 @@
 package autospotting
 
 import (
-	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/elasticbeanstalk"
+	"github.com/aws/aws-sdk-go/service/elasticbeanstalk/elasticbeanstalkiface"
+	"github.com/aws/aws-sdk-go/service/licensemanager"
+	"github.com/aws/aws-sdk-go/service/licensemanager/licensemanageriface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 )
 
+// ClientProvider creates the AWS API clients used while processing a given
+// region. When not set in the Config, AutoSpotting connects to the real AWS
+// APIs, but library users can provide their own implementation, for example
+// in order to inject mocked clients or clients using custom credentials.
+type ClientProvider interface {
+	AutoScaling(region string) autoscalingiface.AutoScalingAPI
+	EC2(region string) ec2iface.EC2API
+	CloudFormation(region string) cloudformationiface.CloudFormationAPI
+}
+
+// SSMClientProvider can optionally be implemented by a ClientProvider which
+// also creates SSM clients, needed for the features relying on SSM. These
+// features are disabled when using a ClientProvider not implementing it.
+type SSMClientProvider interface {
+	SSM(region string) ssmiface.SSMAPI
+}
+
+// CloudWatchClientProvider can optionally be implemented by a ClientProvider
+// which also creates CloudWatch clients, needed for the features relying on
+// CloudWatch metrics. These features are disabled when using a ClientProvider
+// not implementing it.
+type CloudWatchClientProvider interface {
+	CloudWatch(region string) cloudwatchiface.CloudWatchAPI
+}
+
+// LicenseManagerClientProvider can optionally be implemented by a
+// ClientProvider which also creates License Manager clients, needed for
+// taking the license configurations of the instances into account. These are
+// ignored when using a ClientProvider not implementing it.
+type LicenseManagerClientProvider interface {
+	LicenseManager(region string) licensemanageriface.LicenseManagerAPI
+}
+
+// ElasticBeanstalkClientProvider can optionally be implemented by a
+// ClientProvider which also creates Elastic Beanstalk clients, needed for
+// following the status and the health of the Elastic Beanstalk environments.
+// Their groups are handled like the others when using a ClientProvider not
+// implementing it.
+type ElasticBeanstalkClientProvider interface {
+	ElasticBeanstalk(region string) elasticbeanstalkiface.ElasticBeanstalkAPI
+}
+
 type connections struct {
-	session        *session.Session
-	autoScaling    autoscalingiface.AutoScalingAPI
-	ec2            ec2iface.EC2API
-	cloudFormation cloudformationiface.CloudFormationAPI
-	region         string
+	session             *session.Session
+	provider            ClientProvider
+	autoScaling         autoscalingiface.AutoScalingAPI
+	ec2                 ec2iface.EC2API
+	cloudFormation      cloudformationiface.CloudFormationAPI
+	serviceQuotas       serviceQuotasAPI
+	spotPlacementScores spotPlacementScoresAPI
+	bootModes           bootModesAPI
+	ssm                 ssmiface.SSMAPI
+	cloudWatch          cloudwatchiface.CloudWatchAPI
+	licenseManager      licensemanageriface.LicenseManagerAPI
+	elasticBeanstalk    elasticbeanstalkiface.ElasticBeanstalkAPI
+	region              string
+
+	// the credential profile used for the AWS APIs, empty for the default
+	// credentials
+	profile string
+
+	// the credentials of the cross-account role used for the AWS APIs, nil
+	// for those of the profile
+	credentials *credentials.Credentials
+
+	// blocks the mutating calls in audit mode, nil otherwise
+	audit *auditLog
 }
 
 func (c *connections) setSession(region string) {
-	c.session = session.Must(
-		session.NewSession(&aws.Config{Region: aws.String(region)}))
+	c.session = traceAPICalls(logAPICalls(c.audit.install(
+		session.Must(newAccountSession(c.profile, c.credentials, region)))))
 }
 
 func (c *connections) connect(region string) {
 
 	logger.Println("Creating Service connections in", region)
 
+	if c.provider != nil {
+		c.autoScaling = c.provider.AutoScaling(region)
+		c.ec2 = c.provider.EC2(region)
+		c.cloudFormation = c.provider.CloudFormation(region)
+		if p, ok := c.provider.(SSMClientProvider); ok {
+			c.ssm = p.SSM(region)
+		}
+		if p, ok := c.provider.(CloudWatchClientProvider); ok {
+			c.cloudWatch = p.CloudWatch(region)
+		}
+		if p, ok := c.provider.(LicenseManagerClientProvider); ok {
+			c.licenseManager = p.LicenseManager(region)
+		}
+		if p, ok := c.provider.(ElasticBeanstalkClientProvider); ok {
+			c.elasticBeanstalk = p.ElasticBeanstalk(region)
+		}
+		c.region = region
+		logger.Println("Created custom service connections in", region)
+		return
+	}
+
 	if c.session == nil {
 		c.setSession(region)
 	}
@@ -44,6 +134,13 @@ func (c *connections) connect(region string) {
 	go func() { cloudformationConn <- cloudformation.New(c.session) }()
 
 	c.autoScaling, c.ec2, c.cloudFormation, c.region = <-asConn, <-ec2Conn, <-cloudformationConn, region
+	c.serviceQuotas = newServiceQuotas(c.session)
+	c.spotPlacementScores = spotPlacementScores{ec2.New(c.session)}
+	c.bootModes = bootModesClient{ec2.New(c.session)}
+	c.ssm = ssm.New(c.session)
+	c.cloudWatch = cloudwatch.New(c.session)
+	c.licenseManager = licensemanager.New(c.session)
+	c.elasticBeanstalk = elasticbeanstalk.New(c.session)
 
 	logger.Println("Created service connections in", region)
 }
@@ -2,29 +2,63 @@ package autospotting
 
 import (
 	"testing"
+
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 )
 
+type mockClientProvider struct {
+	asg mockASG
+	ec2 mockEC2
+	cf  mockCloudFormation
+}
+
+func (m mockClientProvider) AutoScaling(region string) autoscalingiface.AutoScalingAPI {
+	return m.asg
+}
+
+func (m mockClientProvider) EC2(region string) ec2iface.EC2API {
+	return m.ec2
+}
+
+func (m mockClientProvider) CloudFormation(region string) cloudformationiface.CloudFormationAPI {
+	return m.cf
+}
+
 func Test_connections_connect(t *testing.T) {
 
 	tests := []struct {
-		name   string
-		region string
-		match  bool
+		name     string
+		region   string
+		provider ClientProvider
+		match    bool
 	}{
 		{
 			name:   "connect to region foo",
 			region: "foo",
 			match:  true,
 		},
+		{
+			name:     "connect to region bar using a custom provider",
+			region:   "bar",
+			provider: mockClientProvider{},
+			match:    true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			c := &connections{}
+			c := &connections{provider: tt.provider}
 			c.connect(tt.region)
 			if (c.region == tt.region) != tt.match {
 				t.Errorf("connections.connect() c.region = %v, expected %v",
 					c.region, tt.region)
 			}
+			if tt.provider != nil {
+				if _, ok := c.ec2.(mockEC2); !ok {
+					t.Errorf("connections.connect() didn't use the custom EC2 client")
+				}
+			}
 		})
 	}
 }
@@ -0,0 +1,62 @@
+package autospotting
+
+import (
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// cpuOptionTag returns the value of an integer CPU option tag set on the
+// group, or nil if it isn't set or invalid.
+func (a *autoScalingGroup) cpuOptionTag(key string) *int64 {
+	value := a.getTagValue(key)
+	if value == nil {
+		return nil
+	}
+
+	n, err := strconv.ParseInt(*value, 10, 64)
+	if err != nil || n < 1 {
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s\n", key, *value, a.name)
+		return nil
+	}
+	return aws.Int64(n)
+}
+
+// hasCustomCPUOptions returns true if the instance runs with fewer vCPUs than
+// its instance type provides, which means its launch template set CPU
+// options, such as disabling hyperthreading.
+func (i *instance) hasCustomCPUOptions() bool {
+	if i.CpuOptions == nil || i.CpuOptions.CoreCount == nil ||
+		i.CpuOptions.ThreadsPerCore == nil || i.typeInfo.vCPU == 0 {
+		return false
+	}
+	return *i.CpuOptions.CoreCount**i.CpuOptions.ThreadsPerCore < int64(i.typeInfo.vCPU)
+}
+
+// spotCPUOptions returns the CPU options of the spot instance, copied from
+// the on-demand instance when it has custom CPU options and overridden by the
+// CPU option tags of its group, or nil to use the defaults of the instance
+// type.
+func (i *instance) spotCPUOptions() *ec2.CpuOptionsRequest {
+	var options ec2.CpuOptionsRequest
+
+	if i.hasCustomCPUOptions() {
+		options.CoreCount = i.CpuOptions.CoreCount
+		options.ThreadsPerCore = i.CpuOptions.ThreadsPerCore
+	}
+
+	if i.asg != nil {
+		if n := i.asg.cpuOptionTag(CPUCoreCountTag); n != nil {
+			options.CoreCount = n
+		}
+		if n := i.asg.cpuOptionTag(CPUThreadsPerCoreTag); n != nil {
+			options.ThreadsPerCore = n
+		}
+	}
+
+	if options.CoreCount == nil && options.ThreadsPerCore == nil {
+		return nil
+	}
+	return &options
+}
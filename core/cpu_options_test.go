@@ -0,0 +1,62 @@
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestInstanceSpotCPUOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *ec2.CpuOptions
+		tags    map[string]string
+		want    *ec2.CpuOptionsRequest
+	}{
+		{
+			name:    "default options",
+			options: &ec2.CpuOptions{CoreCount: aws.Int64(4), ThreadsPerCore: aws.Int64(2)},
+		},
+		{
+			name:    "hyperthreading disabled",
+			options: &ec2.CpuOptions{CoreCount: aws.Int64(4), ThreadsPerCore: aws.Int64(1)},
+			want:    &ec2.CpuOptionsRequest{CoreCount: aws.Int64(4), ThreadsPerCore: aws.Int64(1)},
+		},
+		{
+			name:    "fewer cores",
+			options: &ec2.CpuOptions{CoreCount: aws.Int64(2), ThreadsPerCore: aws.Int64(2)},
+			want:    &ec2.CpuOptionsRequest{CoreCount: aws.Int64(2), ThreadsPerCore: aws.Int64(2)},
+		},
+		{
+			name:    "hyperthreading disabled by tag",
+			options: &ec2.CpuOptions{CoreCount: aws.Int64(4), ThreadsPerCore: aws.Int64(2)},
+			tags:    map[string]string{CPUThreadsPerCoreTag: "1"},
+			want:    &ec2.CpuOptionsRequest{ThreadsPerCore: aws.Int64(1)},
+		},
+		{
+			name:    "tags overriding the custom options",
+			options: &ec2.CpuOptions{CoreCount: aws.Int64(2), ThreadsPerCore: aws.Int64(1)},
+			tags:    map[string]string{CPUCoreCountTag: "3"},
+			want:    &ec2.CpuOptionsRequest{CoreCount: aws.Int64(3), ThreadsPerCore: aws.Int64(1)},
+		},
+		{
+			name:    "invalid tag",
+			options: &ec2.CpuOptions{CoreCount: aws.Int64(4), ThreadsPerCore: aws.Int64(2)},
+			tags:    map[string]string{CPUThreadsPerCoreTag: "off"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{CpuOptions: tt.options},
+				typeInfo: instanceTypeInformation{vCPU: 8},
+				asg:      newTestGroup(tt.tags),
+			}
+			if got := i.spotCPUOptions(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("spotCPUOptions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package autospotting
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// crossAccountRole is an IAM role assumed for processing the account it
+// belongs to.
+type crossAccountRole struct {
+	arn       string
+	accountID string
+}
+
+// parseCrossAccountRoles parses the CrossAccountRoles parameter, given as
+// space or comma separated IAM role ARNs. The invalid ARNs are logged and
+// skipped.
+func parseCrossAccountRoles(spec string) []crossAccountRole {
+	var roles []crossAccountRole
+
+	for _, entry := range strings.FieldsFunc(spec, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	}) {
+		a, err := arn.Parse(entry)
+		if err != nil || a.Service != "iam" || !strings.HasPrefix(a.Resource, "role/") || a.AccountID == "" {
+			logger.Printf("Ignoring invalid cross-account role '%s'\n", entry)
+			continue
+		}
+		roles = append(roles, crossAccountRole{arn: entry, accountID: a.AccountID})
+	}
+	return roles
+}
+
+// newAccountSession creates a session in the given region, using the
+// credentials of the given profile, or the given credentials of an assumed
+// role when set.
+func newAccountSession(profile string, creds *credentials.Credentials, region string) (*session.Session, error) {
+	sess, err := newProfileSession(profile, region)
+	if err != nil || creds == nil {
+		return sess, err
+	}
+	return sess.Copy(&aws.Config{Credentials: creds}), nil
+}
+
+// assumeRole returns the credentials of the given role, assumed with the
+// credentials of the given configuration and cached until they expire.
+func assumeRole(cfg *Config, role crossAccountRole) (*credentials.Credentials, error) {
+	sess, err := newProfileSession(cfg.profile, cfg.MainRegion)
+	if err != nil {
+		return nil, err
+	}
+	creds := stscreds.NewCredentials(sess, role.arn, func(p *stscreds.AssumeRoleProvider) {
+		p.RoleSessionName = "AutoSpotting-" + cfg.runID
+	})
+	if _, err := creds.Get(); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// accountConfigs returns the configurations used for processing each of the
+// accounts of the cross-account roles, configured or discovered in the
+// organization, which share the state of the run and count their metrics
+// separately, or the given configuration when no roles are configured or the
+// AWS API clients are created by a ClientProvider. The roles which can't be
+// assumed are logged and skipped.
+func accountConfigs(cfg *Config) []*Config {
+	if !crossAccount(cfg) {
+		return []*Config{cfg}
+	}
+
+	var configs []*Config
+	for _, role := range accountRoles(cfg) {
+		c, err := roleConfig(cfg, role)
+		if err != nil {
+			logger.Println("Ignoring cross-account role", role.arn, err.Error())
+			continue
+		}
+		configs = append(configs, c)
+	}
+	return configs
+}
+
+// accountConfig returns the configuration used for processing the given
+// account, assuming only its own cross-account role, or the given
+// configuration when no roles are configured or the AWS API clients are
+// created by a ClientProvider.
+func accountConfig(cfg *Config, accountID string) (*Config, error) {
+	if !crossAccount(cfg) {
+		return cfg, nil
+	}
+
+	for _, role := range accountRoles(cfg) {
+		if role.accountID == accountID {
+			return roleConfig(cfg, role)
+		}
+	}
+	return nil, fmt.Errorf("no cross-account role is configured for the account %s", accountID)
+}
+
+// crossAccount tells if the accounts are processed using cross-account roles.
+func crossAccount(cfg *Config) bool {
+	return (strings.TrimSpace(cfg.CrossAccountRoles) != "" || strings.TrimSpace(cfg.OrganizationAccounts) != "") &&
+		cfg.ClientProvider == nil
+}
+
+// accountRoles returns the configured cross-account roles, along with those
+// discovered in the organization.
+func accountRoles(cfg *Config) []crossAccountRole {
+	roles := parseCrossAccountRoles(cfg.CrossAccountRoles)
+	discovered, err := organizationRoles(cfg)
+	if err != nil {
+		logger.Println("Failed to discover the accounts of the organization:", err.Error())
+	}
+	return mergeRoles(roles, discovered)
+}
+
+// roleConfig returns the configuration used for processing the account of
+// the given role, once assumed.
+func roleConfig(cfg *Config, role crossAccountRole) (*Config, error) {
+	creds, err := assumeRole(cfg, role)
+	if err != nil {
+		return nil, err
+	}
+	c := *cfg
+	c.role, c.accountID, c.credentials = role.arn, role.accountID, creds
+	c.metrics = cfg.metrics.account(role.accountID)
+	return &c, nil
+}
+
+// mergeRoles appends the discovered roles of the accounts which don't have a
+// configured role.
+func mergeRoles(configured, discovered []crossAccountRole) []crossAccountRole {
+	accounts := map[string]bool{}
+	for _, role := range configured {
+		accounts[role.accountID] = true
+	}
+	for _, role := range discovered {
+		if !accounts[role.accountID] {
+			accounts[role.accountID] = true
+			configured = append(configured, role)
+		}
+	}
+	return configured
+}
+
+// logAccountSummaries logs the groups processed and the instances replaced in
+// each of the accounts of the cross-account roles.
+func logAccountSummaries(cfg *Config) {
+	if cfg.metrics == nil {
+		return
+	}
+	cfg.metrics.mu.Lock()
+	defer cfg.metrics.mu.Unlock()
+	if len(cfg.metrics.accounts) == 0 {
+		return
+	}
+
+	replaced := map[string]int{}
+	if cfg.changes != nil {
+		for _, c := range cfg.changes.list() {
+			replaced[c.Account]++
+		}
+	}
+
+	var ids []string
+	for id := range cfg.metrics.accounts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		m := cfg.metrics.accounts[id]
+		logger.Printf("Account %s: %d groups processed, %d failed, %d instances replaced\n",
+			id, atomic.LoadInt64(&m.groups), atomic.LoadInt64(&m.failures), replaced[id])
+	}
+}
@@ -0,0 +1,105 @@
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/AutoSpotting/AutoSpotting/core/autospottingtest"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+)
+
+func Test_parseCrossAccountRoles(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []crossAccountRole
+	}{
+		{spec: "", want: nil},
+		{
+			spec: "arn:aws:iam::111111111111:role/AutoSpotting, arn:aws-us-gov:iam::222222222222:role/path/AutoSpotting",
+			want: []crossAccountRole{
+				{arn: "arn:aws:iam::111111111111:role/AutoSpotting", accountID: "111111111111"},
+				{arn: "arn:aws-us-gov:iam::222222222222:role/path/AutoSpotting", accountID: "222222222222"},
+			},
+		},
+		{
+			spec: "AutoSpotting arn:aws:iam::111111111111:user/admin arn:aws:s3:::bucket",
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			if got := parseCrossAccountRoles(tt.spec); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseCrossAccountRoles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newAccountSession(t *testing.T) {
+	defer useSharedConfig(t, "commercial")()
+
+	sess, err := newAccountSession("commercial", nil, "us-east-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds, err := sess.Config.Credentials.Get(); err != nil || creds.AccessKeyID != "AKID-commercial" {
+		t.Errorf("newAccountSession() credentials = %v, %v", creds, err)
+	}
+
+	role := credentials.NewStaticCredentials("AKID-role", "SECRET", "TOKEN")
+	sess, err = newAccountSession("commercial", role, "eu-west-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creds, err := sess.Config.Credentials.Get(); err != nil || creds.AccessKeyID != "AKID-role" {
+		t.Errorf("newAccountSession() credentials = %v, %v", creds, err)
+	}
+	if *sess.Config.Region != "eu-west-1" {
+		t.Errorf("newAccountSession() region = %s", *sess.Config.Region)
+	}
+}
+
+func Test_accountConfigs(t *testing.T) {
+	defer useSharedConfig(t)()
+
+	cfg := &Config{MainRegion: "us-east-1", metrics: newRunMetrics(time.Now())}
+	if got := accountConfigs(cfg); len(got) != 1 || got[0] != cfg {
+		t.Errorf("accountConfigs() without roles = %v, want the run's configuration", got)
+	}
+
+	// no credentials are available for assuming the role
+	cfg.CrossAccountRoles = "arn:aws:iam::111111111111:role/AutoSpotting"
+	if got := accountConfigs(cfg); len(got) != 0 {
+		t.Errorf("accountConfigs() = %v, want the role to be skipped", got)
+	}
+
+	cfg.ClientProvider = autospottingtest.NewCloud()
+	if got := accountConfigs(cfg); len(got) != 1 || got[0] != cfg {
+		t.Errorf("accountConfigs() with a client provider = %v, want the run's configuration", got)
+	}
+}
+
+func Test_accountConfig(t *testing.T) {
+	defer useSharedConfig(t)()
+
+	cfg := &Config{MainRegion: "us-east-1", metrics: newRunMetrics(time.Now())}
+	if got, err := accountConfig(cfg, "111111111111"); err != nil || got != cfg {
+		t.Errorf("accountConfig() without roles = %v, %v, want the run's configuration", got, err)
+	}
+
+	cfg.CrossAccountRoles = "arn:aws:iam::111111111111:role/AutoSpotting"
+	if _, err := accountConfig(cfg, "222222222222"); err == nil {
+		t.Error("accountConfig() expected an error for an account without a role")
+	}
+
+	// no credentials are available for assuming the role
+	if _, err := accountConfig(cfg, "111111111111"); err == nil {
+		t.Error("accountConfig() expected an error when the role can't be assumed")
+	}
+
+	cfg.ClientProvider = autospottingtest.NewCloud()
+	if got, err := accountConfig(cfg, "222222222222"); err != nil || got != cfg {
+		t.Errorf("accountConfig() with a client provider = %v, %v, want the run's configuration", got, err)
+	}
+}
@@ -0,0 +1,21 @@
+/*
+Package autospotting implements the AutoSpotting algorithm, which replaces the
+on-demand instances from the enabled AutoScaling groups with compatible and
+cheaper spot instances.
+
+Besides being used by the AutoSpotting binary, the package can also be
+embedded in other Go tools. The main entry points are:
+
+  - Run, which processes all the enabled regions and AutoScaling groups based
+    on the given Config, just like the AutoSpotting Lambda function does.
+  - FindSpotCandidates, which exposes the instance type selection and pricing
+    logic without calling any AWS APIs.
+  - NewSpotTermination and GetInstanceIDDueForTermination, which handle the
+    spot instance termination notifications.
+
+The AWS API clients used by Run can be replaced by setting the ClientProvider
+field of the Config, for example in order to use mocked clients or clients
+configured with custom credentials. The autospottingtest subpackage provides
+an in-memory fake of the AWS APIs which can be used for this purpose.
+*/
+package autospotting
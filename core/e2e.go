@@ -0,0 +1,414 @@
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/cloudformation"
+	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// The tag set on the synthetic groups, used for only processing them during
+// the end-to-end tests.
+const e2eTag = "autospotting-e2e"
+
+// EndToEndConfig stores the configuration of the end-to-end test harness.
+type EndToEndConfig struct {
+	// Endpoint of an emulated AWS API, such as http://localstack:4566
+	Endpoint string
+
+	// Region in which the synthetic groups are created
+	Region string
+
+	// Number of synthetic AutoScaling groups
+	Groups int
+
+	// Desired capacity of each synthetic group
+	Capacity int64
+
+	// On-demand instance type and AMI used by the synthetic groups. When the
+	// AMI is not set, the first image available on the endpoint is used.
+	InstanceType string
+	ImageID      string
+
+	// Number of AutoSpotting runs, and the pause between them which allows the
+	// launched spot instances to exceed the grace period of their group.
+	Cycles   int
+	Interval time.Duration
+}
+
+func (e *EndToEndConfig) setDefaults() {
+	if e.Region == "" {
+		e.Region = "us-east-1"
+	}
+	if e.Groups == 0 {
+		e.Groups = 2
+	}
+	if e.Capacity == 0 {
+		e.Capacity = 1
+	}
+	if e.InstanceType == "" {
+		e.InstanceType = "m5.large"
+	}
+	if e.Cycles == 0 {
+		e.Cycles = 2
+	}
+	if e.Interval == 0 {
+		e.Interval = 2 * time.Second
+	}
+}
+
+// endpointProvider connects to all the services using a custom endpoint and
+// dummy credentials, as expected by AWS API emulators such as LocalStack.
+type endpointProvider struct {
+	sess *session.Session
+}
+
+func newEndpointProvider(endpoint, region string) (endpointProvider, error) {
+	sess, err := session.NewSession(&aws.Config{
+		Endpoint:    aws.String(endpoint),
+		Region:      aws.String(region),
+		Credentials: credentials.NewStaticCredentials("test", "test", ""),
+	})
+	return endpointProvider{sess: sess}, err
+}
+
+func (p endpointProvider) AutoScaling(region string) autoscalingiface.AutoScalingAPI {
+	return autoscaling.New(p.sess, aws.NewConfig().WithRegion(region))
+}
+
+func (p endpointProvider) EC2(region string) ec2iface.EC2API {
+	return ec2.New(p.sess, aws.NewConfig().WithRegion(region))
+}
+
+func (p endpointProvider) CloudFormation(region string) cloudformationiface.CloudFormationAPI {
+	return cloudformation.New(p.sess, aws.NewConfig().WithRegion(region))
+}
+
+// groupSnapshot is the state of a synthetic group relevant for the
+// invariants checked after each run.
+type groupSnapshot struct {
+	name            string
+	desired         int64
+	members         []string
+	onDemandMembers int
+	unattachedSpot  int
+	notRunning      []string
+}
+
+// e2eHarness seeds and checks the synthetic groups.
+type e2eHarness struct {
+	conf   EndToEndConfig
+	asSvc  autoscalingiface.AutoScalingAPI
+	ec2Svc ec2iface.EC2API
+	runID  string
+	groups []string
+}
+
+// RunEndToEnd seeds synthetic AutoScaling groups against an emulated AWS API,
+// runs a full replacement cycle on them and asserts that the replacement
+// didn't violate any of the invariants AutoSpotting should maintain, such as
+// never decreasing the capacity of the groups. It is meant for validating
+// changes without running them against a real AWS account.
+//
+// The clients are taken from cfg.ClientProvider when set, otherwise they
+// connect to the configured endpoint.
+func RunEndToEnd(cfg *Config, e2e EndToEndConfig) error {
+
+	setupLogging(cfg)
+	e2e.setDefaults()
+
+	if cfg.ClientProvider == nil {
+		if e2e.Endpoint == "" {
+			return errors.New("missing the endpoint of the emulated AWS API")
+		}
+		p, err := newEndpointProvider(e2e.Endpoint, e2e.Region)
+		if err != nil {
+			return err
+		}
+		cfg.ClientProvider = p
+	}
+
+	h := &e2eHarness{
+		conf:   e2e,
+		asSvc:  cfg.ClientProvider.AutoScaling(e2e.Region),
+		ec2Svc: cfg.ClientProvider.EC2(e2e.Region),
+		runID:  fmt.Sprintf("%d", time.Now().Unix()),
+	}
+
+	defer h.cleanup()
+
+	if err := h.seed(); err != nil {
+		logger.Println("Failed to seed the synthetic groups:", err.Error())
+		return err
+	}
+
+	// only process the synthetic groups of this run
+	cfg.MainRegion = e2e.Region
+	cfg.Regions = e2e.Region
+	cfg.TagFilteringMode = "opt-in"
+	cfg.FilterByTags = "spot-enabled=true," + e2eTag + "=" + h.runID
+
+	initial, err := h.snapshot()
+	if err != nil {
+		return err
+	}
+
+	var violations []string
+
+	for cycle := 1; cycle <= e2e.Cycles; cycle++ {
+		logger.Println("End-to-end cycle", cycle, "of", e2e.Cycles)
+		Run(cfg)
+
+		current, err := h.snapshot()
+		if err != nil {
+			return err
+		}
+		for _, v := range checkInvariants(initial, current) {
+			violations = append(violations, fmt.Sprintf("cycle %d: %s", cycle, v))
+		}
+
+		if cycle < e2e.Cycles {
+			time.Sleep(e2e.Interval)
+		}
+	}
+
+	final, err := h.snapshot()
+	if err != nil {
+		return err
+	}
+	for _, g := range final {
+		logger.Printf("Group %s: %d members, %d on-demand, %d unattached spot\n",
+			g.name, len(g.members), g.onDemandMembers, g.unattachedSpot)
+	}
+
+	if len(violations) > 0 {
+		for _, v := range violations {
+			logger.Println("Invariant violated:", v)
+		}
+		return fmt.Errorf("%d invariant violations found", len(violations))
+	}
+
+	logger.Println("All invariants held")
+	return nil
+}
+
+// seed creates the launch configurations and the AutoScaling groups.
+func (h *e2eHarness) seed() error {
+
+	if h.conf.ImageID == "" {
+		out, err := h.ec2Svc.DescribeImages(&ec2.DescribeImagesInput{})
+		if err != nil {
+			return err
+		}
+		if len(out.Images) == 0 {
+			return errors.New("no AMIs available, please specify one")
+		}
+		h.conf.ImageID = *out.Images[0].ImageId
+	}
+
+	azs, err := h.ec2Svc.DescribeAvailabilityZones(&ec2.DescribeAvailabilityZonesInput{})
+	if err != nil {
+		return err
+	}
+	if len(azs.AvailabilityZones) == 0 {
+		return errors.New("no availability zones available")
+	}
+
+	for n := 1; n <= h.conf.Groups; n++ {
+		name := fmt.Sprintf("%s-%s-%d", e2eTag, h.runID, n)
+
+		if _, err := h.asSvc.CreateLaunchConfiguration(&autoscaling.CreateLaunchConfigurationInput{
+			LaunchConfigurationName: aws.String(name),
+			ImageId:                 aws.String(h.conf.ImageID),
+			InstanceType:            aws.String(h.conf.InstanceType),
+		}); err != nil {
+			return err
+		}
+
+		// the groups are tracked for cleanup even if their creation fails
+		h.groups = append(h.groups, name)
+
+		if _, err := h.asSvc.CreateAutoScalingGroup(&autoscaling.CreateAutoScalingGroupInput{
+			AutoScalingGroupName:    aws.String(name),
+			LaunchConfigurationName: aws.String(name),
+			AvailabilityZones:       []*string{azs.AvailabilityZones[0].ZoneName},
+			MinSize:                 aws.Int64(h.conf.Capacity),
+			MaxSize:                 aws.Int64(h.conf.Capacity + 1),
+			DesiredCapacity:         aws.Int64(h.conf.Capacity),
+			HealthCheckGracePeriod:  aws.Int64(0),
+			Tags: []*autoscaling.Tag{
+				{Key: aws.String("spot-enabled"), Value: aws.String("true")},
+				{Key: aws.String(e2eTag), Value: aws.String(h.runID)},
+			},
+		}); err != nil {
+			return err
+		}
+		logger.Println("Created synthetic group", name)
+	}
+	return nil
+}
+
+// snapshot collects the current state of the synthetic groups.
+func (h *e2eHarness) snapshot() ([]groupSnapshot, error) {
+	var result []groupSnapshot
+
+	for _, name := range h.groups {
+		var g *autoscaling.Group
+		err := h.asSvc.DescribeAutoScalingGroupsPages(&autoscaling.DescribeAutoScalingGroupsInput{
+			AutoScalingGroupNames: []*string{aws.String(name)},
+		}, func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+			if len(page.AutoScalingGroups) > 0 {
+				g = page.AutoScalingGroups[0]
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		if g == nil {
+			return nil, fmt.Errorf("group %s not found", name)
+		}
+
+		s := groupSnapshot{name: name, desired: aws.Int64Value(g.DesiredCapacity)}
+		members := make(map[string]bool)
+		for _, i := range g.Instances {
+			s.members = append(s.members, *i.InstanceId)
+			members[*i.InstanceId] = true
+		}
+
+		err = h.ec2Svc.DescribeInstancesPages(&ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{
+				{Name: aws.String("tag:launched-for-asg"), Values: []*string{aws.String(name)}},
+			},
+		}, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, r := range page.Reservations {
+				for _, i := range r.Instances {
+					if !members[*i.InstanceId] && *i.State.Name == ec2.InstanceStateNameRunning {
+						s.unattachedSpot++
+					}
+				}
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if len(s.members) > 0 {
+			err = h.ec2Svc.DescribeInstancesPages(&ec2.DescribeInstancesInput{
+				InstanceIds: aws.StringSlice(s.members),
+			}, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+				for _, r := range page.Reservations {
+					for _, i := range r.Instances {
+						if i.InstanceLifecycle == nil {
+							s.onDemandMembers++
+						}
+						if *i.State.Name != ec2.InstanceStateNameRunning {
+							s.notRunning = append(s.notRunning, *i.InstanceId)
+						}
+					}
+				}
+				return true
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		result = append(result, s)
+	}
+	return result, nil
+}
+
+// checkInvariants compares the current state of the groups against their
+// initial state, returning a description of each violated invariant.
+func checkInvariants(initial, current []groupSnapshot) []string {
+	var violations []string
+
+	before := make(map[string]groupSnapshot)
+	for _, g := range initial {
+		before[g.name] = g
+	}
+
+	for _, g := range current {
+		b := before[g.name]
+
+		if g.desired != b.desired {
+			violations = append(violations, fmt.Sprintf(
+				"%s: desired capacity changed from %d to %d", g.name, b.desired, g.desired))
+		}
+		if int64(len(g.members)) < g.desired {
+			violations = append(violations, fmt.Sprintf(
+				"%s: only %d members for a desired capacity of %d", g.name, len(g.members), g.desired))
+		}
+		if len(g.notRunning) > 0 {
+			violations = append(violations, fmt.Sprintf(
+				"%s: members not running: %s", g.name, strings.Join(g.notRunning, ",")))
+		}
+		if g.onDemandMembers > b.onDemandMembers {
+			violations = append(violations, fmt.Sprintf(
+				"%s: on-demand members increased from %d to %d", g.name, b.onDemandMembers, g.onDemandMembers))
+		}
+		// each run launches at most one spot instance per group
+		if g.unattachedSpot > 1 {
+			violations = append(violations, fmt.Sprintf(
+				"%s: %d spot instances launched but not attached", g.name, g.unattachedSpot))
+		}
+	}
+	return violations
+}
+
+// cleanup deletes the synthetic groups and their launch configurations, and
+// terminates the spot instances launched for them which weren't attached yet.
+func (h *e2eHarness) cleanup() {
+	for _, name := range h.groups {
+		var ids []*string
+		err := h.ec2Svc.DescribeInstancesPages(&ec2.DescribeInstancesInput{
+			Filters: []*ec2.Filter{
+				{Name: aws.String("tag:launched-for-asg"), Values: []*string{aws.String(name)}},
+				{Name: aws.String("instance-state-name"), Values: []*string{aws.String(ec2.InstanceStateNameRunning)}},
+			},
+		}, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+			for _, r := range page.Reservations {
+				for _, i := range r.Instances {
+					ids = append(ids, i.InstanceId)
+				}
+			}
+			return true
+		})
+		if err != nil {
+			logger.Println("Failed to list the spot instances of", name, err.Error())
+		}
+
+		if _, err := h.asSvc.DeleteAutoScalingGroup(&autoscaling.DeleteAutoScalingGroupInput{
+			AutoScalingGroupName: aws.String(name),
+			ForceDelete:          aws.Bool(true),
+		}); err != nil {
+			logger.Println("Failed to delete synthetic group", name, err.Error())
+		}
+
+		if _, err := h.asSvc.DeleteLaunchConfiguration(&autoscaling.DeleteLaunchConfigurationInput{
+			LaunchConfigurationName: aws.String(name),
+		}); err != nil {
+			logger.Println("Failed to delete launch configuration", name, err.Error())
+		}
+
+		if len(ids) > 0 {
+			if _, err := h.ec2Svc.TerminateInstances(&ec2.TerminateInstancesInput{
+				InstanceIds: ids,
+			}); err != nil {
+				logger.Println("Failed to terminate the spot instances of", name, err.Error())
+			}
+		}
+	}
+}
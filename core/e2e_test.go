@@ -0,0 +1,164 @@
+package autospotting
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/AutoSpotting/AutoSpotting/core/autospottingtest"
+	"github.com/aws/aws-sdk-go/aws"
+	ec2instancesinfo "github.com/cristim/ec2-instances-info"
+)
+
+func e2eTestConfig(cloud *autospottingtest.Cloud) *Config {
+	pricing := func(price float64) map[string]ec2instancesinfo.RegionPrices {
+		return map[string]ec2instancesinfo.RegionPrices{
+			"us-east-1": {Linux: ec2instancesinfo.LinuxPricing{OnDemand: price}},
+		}
+	}
+
+	return &Config{
+		LogFile:        ioutil.Discard,
+		ClientProvider: cloud,
+		InstanceData: &ec2instancesinfo.InstanceData{
+			0: {InstanceType: "m5.large", VCPU: 2, Memory: 8, PhysicalProcessor: "Intel", Pricing: pricing(0.096)},
+			1: {InstanceType: "m5.xlarge", VCPU: 4, Memory: 16, PhysicalProcessor: "Intel", Pricing: pricing(0.192)},
+		},
+		AutoScalingConfig: AutoScalingConfig{
+			OnDemandPriceMultiplier: 1.0,
+			BiddingPolicy:           DefaultBiddingPolicy,
+			SpotProductDescription:  DefaultSpotProductDescription,
+			CronSchedule:            DefaultSchedule,
+			CronScheduleState:       "on",
+		},
+	}
+}
+
+func TestRunEndToEnd(t *testing.T) {
+	cloud := autospottingtest.NewCloud()
+	r := cloud.Region("us-east-1")
+	r.SetSpotPrice("m5.large", "us-east-1a", 0.04)
+	r.SetSpotPrice("m5.xlarge", "us-east-1a", 0.03)
+
+	err := RunEndToEnd(e2eTestConfig(cloud), EndToEndConfig{
+		Region:  "us-east-1",
+		ImageID: "ami-123",
+		Cycles:  1,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+
+	var onDemand, spot int
+	for _, i := range r.Instances() {
+		if i.InstanceLifecycle == nil {
+			onDemand++
+		} else if *i.InstanceType == "m5.xlarge" {
+			spot++
+		}
+	}
+	if onDemand != 2 || spot != 2 {
+		t.Errorf("expected 2 on-demand and 2 spot instances, got %d and %d",
+			onDemand, spot)
+	}
+
+	for _, i := range r.Instances() {
+		if *i.State.Name != "terminated" {
+			t.Errorf("instance %s wasn't cleaned up", *i.InstanceId)
+		}
+	}
+}
+
+func TestRunEndToEndMissingEndpoint(t *testing.T) {
+	err := RunEndToEnd(&Config{LogFile: ioutil.Discard}, EndToEndConfig{})
+	if err == nil {
+		t.Error("expected an error when no endpoint is given")
+	}
+}
+
+func Test_checkInvariants(t *testing.T) {
+	initial := []groupSnapshot{{
+		name:            "asg",
+		desired:         2,
+		members:         []string{"i-1", "i-2"},
+		onDemandMembers: 2,
+	}}
+
+	tests := []struct {
+		name    string
+		current groupSnapshot
+		want    []string
+	}{
+		{
+			name: "replacement in progress",
+			current: groupSnapshot{
+				name:            "asg",
+				desired:         2,
+				members:         []string{"i-1", "i-3"},
+				onDemandMembers: 1,
+				unattachedSpot:  1,
+			},
+		},
+		{
+			name: "capacity lost",
+			current: groupSnapshot{
+				name:            "asg",
+				desired:         1,
+				members:         []string{"i-1"},
+				onDemandMembers: 1,
+			},
+			want: []string{"asg: desired capacity changed from 2 to 1"},
+		},
+		{
+			name: "missing and stopped members",
+			current: groupSnapshot{
+				name:            "asg",
+				desired:         2,
+				members:         []string{"i-1"},
+				onDemandMembers: 1,
+				notRunning:      []string{"i-1"},
+			},
+			want: []string{
+				"asg: only 1 members for a desired capacity of 2",
+				"asg: members not running: i-1",
+			},
+		},
+		{
+			name: "runaway spot launches",
+			current: groupSnapshot{
+				name:            "asg",
+				desired:         2,
+				members:         []string{"i-1", "i-2"},
+				onDemandMembers: 3,
+				unattachedSpot:  2,
+			},
+			want: []string{
+				"asg: on-demand members increased from 2 to 3",
+				"asg: 2 spot instances launched but not attached",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := checkInvariants(initial, []groupSnapshot{tt.current})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("checkInvariants() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_newEndpointProvider(t *testing.T) {
+	p, err := newEndpointProvider("http://localhost:4566", "us-east-1")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if got := aws.StringValue(p.sess.Config.Endpoint); got != "http://localhost:4566" {
+		t.Errorf("endpoint = %s", got)
+	}
+	if p.EC2("eu-west-1") == nil || p.AutoScaling("eu-west-1") == nil ||
+		p.CloudFormation("eu-west-1") == nil {
+		t.Error("expected all clients to be created")
+	}
+}
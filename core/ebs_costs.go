@@ -0,0 +1,144 @@
+package autospotting
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// hoursPerMonth converts the monthly EBS prices to hourly ones.
+const hoursPerMonth = 730
+
+// ebsVolume describes the billed attributes of an EBS volume.
+type ebsVolume struct {
+	volumeType string
+	size       int64
+	iops       int64
+	throughput int64
+}
+
+// hourlyCost returns the hourly cost of the volume, based on the us-east-1
+// list prices, which are close enough for comparing instance types.
+func (v ebsVolume) hourlyCost() float64 {
+	size := float64(v.size)
+	var monthly float64
+
+	switch v.volumeType {
+	case ec2.VolumeTypeGp3:
+		monthly = 0.08*size +
+			0.005*float64(max64(v.iops-3000, 0)) +
+			0.04*float64(max64(v.throughput-125, 0))
+	case ec2.VolumeTypeIo1, ec2.VolumeTypeIo2:
+		monthly = 0.125*size + 0.065*float64(v.iops)
+	case ec2.VolumeTypeSt1:
+		monthly = 0.045 * size
+	case ec2.VolumeTypeSc1:
+		monthly = 0.015 * size
+	case ec2.VolumeTypeStandard:
+		monthly = 0.05 * size
+	default:
+		monthly = 0.10 * size
+	}
+	return monthly / hoursPerMonth
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// includesEBSCosts returns true if the EBS costs are included in the price
+// comparisons.
+func (i *instance) includesEBSCosts() bool {
+	return i.region != nil && i.region.conf != nil && i.region.conf.IncludeEBSCosts
+}
+
+// attachedVolumes returns the EBS volumes attached to the instance, indexed by
+// device name, which are loaded once from the EC2 API.
+func (i *instance) attachedVolumes() map[string]ebsVolume {
+	if i.volumes != nil {
+		return i.volumes
+	}
+	i.volumes = make(map[string]ebsVolume)
+
+	devices := make(map[string]string)
+	var ids []*string
+	for _, bdm := range i.BlockDeviceMappings {
+		if bdm.Ebs != nil && bdm.Ebs.VolumeId != nil {
+			devices[*bdm.Ebs.VolumeId] = aws.StringValue(bdm.DeviceName)
+			ids = append(ids, bdm.Ebs.VolumeId)
+		}
+	}
+	if len(ids) == 0 || i.region.services.ec2 == nil {
+		return i.volumes
+	}
+
+	out, err := i.region.services.ec2.DescribeVolumes(&ec2.DescribeVolumesInput{VolumeIds: ids})
+	if err != nil {
+		logger.Println("Failed to describe the volumes of", *i.InstanceId, err.Error())
+		return i.volumes
+	}
+
+	for _, v := range out.Volumes {
+		i.volumes[devices[*v.VolumeId]] = ebsVolume{
+			volumeType: aws.StringValue(v.VolumeType),
+			size:       aws.Int64Value(v.Size),
+			iops:       aws.Int64Value(v.Iops),
+			throughput: aws.Int64Value(v.Throughput),
+		}
+	}
+	return i.volumes
+}
+
+// onDemandEBSCost returns the hourly cost of the EBS volumes attached to the
+// on-demand instance, if the EBS costs are included in the price comparisons.
+func (i *instance) onDemandEBSCost() float64 {
+	if !i.includesEBSCosts() {
+		return 0
+	}
+
+	var cost float64
+	for _, v := range i.attachedVolumes() {
+		cost += v.hourlyCost()
+	}
+	return cost
+}
+
+// spotEBSCost returns the hourly cost of the EBS volumes of the spot instance
+// replacing this on-demand instance, if the EBS costs are included in the
+// price comparisons. These are the volumes of the on-demand instance, except
+// for those launched from the block device mappings of the launch template or
+// launch configuration, which may have been provisioned differently.
+func (i *instance) spotEBSCost() float64 {
+	if !i.includesEBSCosts() {
+		return 0
+	}
+
+	volumes := make(map[string]ebsVolume)
+	for device, v := range i.attachedVolumes() {
+		volumes[device] = v
+	}
+
+	for _, bdm := range i.launchBlockDeviceMappings() {
+		if bdm.Ebs == nil || bdm.Ebs.VolumeSize == nil {
+			continue
+		}
+		v := ebsVolume{
+			volumeType: aws.StringValue(bdm.Ebs.VolumeType),
+			size:       *bdm.Ebs.VolumeSize,
+			iops:       aws.Int64Value(bdm.Ebs.Iops),
+			throughput: aws.Int64Value(bdm.Ebs.Throughput),
+		}
+		if v.volumeType == ec2.VolumeTypeGp3 && v.throughput == 0 {
+			v.throughput = 125
+		}
+		volumes[aws.StringValue(bdm.DeviceName)] = v
+	}
+
+	var cost float64
+	for _, v := range volumes {
+		cost += v.hourlyCost()
+	}
+	return cost
+}
@@ -0,0 +1,84 @@
+package autospotting
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestEBSVolumeHourlyCost(t *testing.T) {
+	tests := []struct {
+		volume ebsVolume
+		want   float64
+	}{
+		{volume: ebsVolume{volumeType: "gp2", size: 100}, want: 10},
+		{volume: ebsVolume{volumeType: "gp3", size: 100, iops: 3000, throughput: 125}, want: 8},
+		{volume: ebsVolume{volumeType: "gp3", size: 100, iops: 4000, throughput: 250}, want: 8 + 5 + 5},
+		{volume: ebsVolume{volumeType: "io1", size: 100, iops: 1000}, want: 12.5 + 65},
+		{volume: ebsVolume{volumeType: "sc1", size: 1000}, want: 15},
+	}
+	for _, tt := range tests {
+		t.Run(tt.volume.volumeType, func(t *testing.T) {
+			if got := tt.volume.hourlyCost() * hoursPerMonth; math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("monthly cost = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstanceEBSCosts(t *testing.T) {
+	volumes := &ec2.DescribeVolumesOutput{Volumes: []*ec2.Volume{
+		{VolumeId: aws.String("vol-root"), VolumeType: aws.String("gp2"), Size: aws.Int64(8)},
+		{VolumeId: aws.String("vol-data"), VolumeType: aws.String("gp2"), Size: aws.Int64(100)},
+	}}
+
+	newInstance := func(include bool, lc *launchConfiguration, err error) *instance {
+		return &instance{
+			Instance: &ec2.Instance{
+				InstanceId: aws.String("i-ondemand"),
+				BlockDeviceMappings: []*ec2.InstanceBlockDeviceMapping{
+					{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-root")}},
+					{DeviceName: aws.String("/dev/sdf"), Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-data")}},
+				},
+			},
+			region: &region{
+				conf:     &Config{IncludeEBSCosts: include},
+				services: connections{ec2: mockEC2{dvo: volumes, dvoerr: err}},
+			},
+			asg: &autoScalingGroup{Group: &autoscaling.Group{}, launchConfiguration: lc},
+		}
+	}
+	monthly := func(f func() float64) float64 { return f() * hoursPerMonth }
+
+	if i := newInstance(false, nil, nil); i.onDemandEBSCost() != 0 || i.spotEBSCost() != 0 {
+		t.Error("expected the EBS costs not to be included by default")
+	}
+
+	i := newInstance(true, nil, nil)
+	if got := monthly(i.onDemandEBSCost); math.Abs(got-10.8) > 1e-9 {
+		t.Errorf("onDemandEBSCost() = %v per month, want 10.8", got)
+	}
+	if got := monthly(i.spotEBSCost); math.Abs(got-10.8) > 1e-9 {
+		t.Errorf("spotEBSCost() = %v per month, want the same volumes", got)
+	}
+
+	// the launch configuration now provisions a bigger gp3 data volume
+	lc := &launchConfiguration{LaunchConfiguration: &autoscaling.LaunchConfiguration{
+		BlockDeviceMappings: []*autoscaling.BlockDeviceMapping{{
+			DeviceName: aws.String("/dev/sdf"),
+			Ebs:        &autoscaling.Ebs{VolumeType: aws.String("gp3"), VolumeSize: aws.Int64(200)},
+		}},
+	}}
+	i = newInstance(true, lc, nil)
+	if got := monthly(i.spotEBSCost); math.Abs(got-(0.8+16)) > 1e-9 {
+		t.Errorf("spotEBSCost() = %v per month, want 16.8", got)
+	}
+
+	if i := newInstance(true, nil, errors.New("UnauthorizedOperation")); i.onDemandEBSCost() != 0 {
+		t.Error("expected no EBS costs when the volumes can't be described")
+	}
+}
@@ -0,0 +1,156 @@
+package autospotting
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+)
+
+const (
+	// DefaultECSDrainTimeout is the default time given to the tasks of a
+	// draining ECS container instance to be rescheduled before the instance is
+	// terminated anyway, short enough for spot interruptions.
+	DefaultECSDrainTimeout = 90 * time.Second
+
+	// ecsClusterTag is the name of a tag giving the ECS cluster the instance
+	// is registered to, saving the search of its container instance through
+	// all the clusters.
+	ecsClusterTag = "ecs:cluster"
+
+	// ecsDrainPollInterval is how often the tasks left on a draining container
+	// instance are checked.
+	ecsDrainPollInterval = 5 * time.Second
+)
+
+// ecsCluster returns the ECS cluster of the instance, given by its tags or
+// otherwise by the ECSCluster configuration, or an empty string when its
+// container instance needs to be searched in all the clusters.
+func ecsCluster(cfg *Config, tags []*ec2.Tag) string {
+	for _, t := range tags {
+		if aws.StringValue(t.Key) == ecsClusterTag {
+			return aws.StringValue(t.Value)
+		}
+	}
+	return cfg.ECSCluster
+}
+
+// drainECSContainerInstance sets the ECS container instance running on the
+// instance to DRAINING and waits for its tasks to be rescheduled before the
+// instance is terminated. The instance is terminated anyway when the tasks
+// are still running after the ECSDrainTimeout, so the errors are only logged.
+func drainECSContainerInstance(sess *session.Session, cfg *Config, i *ec2.Instance) {
+	if cfg == nil || !cfg.DrainECSContainerInstances {
+		return
+	}
+	instanceID := aws.StringValue(i.InstanceId)
+	if cfg.AuditMode {
+		logger.Println("Audit mode, would drain", instanceID, "from its ECS cluster")
+		return
+	}
+	if sess == nil {
+		logger.Println("Not draining", instanceID, "from its ECS cluster without an AWS session")
+		return
+	}
+
+	timeout := cfg.ECSDrainTimeout
+	if timeout <= 0 {
+		timeout = DefaultECSDrainTimeout
+	}
+	d := &ecsDrainer{svc: ecs.New(sess), poll: ecsDrainPollInterval}
+	if err := d.drainInstance(ecsCluster(cfg, i.Tags), instanceID, timeout); err != nil {
+		logger.Println("Couldn't drain", instanceID, "from its ECS cluster:", err.Error())
+	}
+}
+
+// ecsDrainer drains the ECS container instances of EC2 instances.
+type ecsDrainer struct {
+	svc  ecsiface.ECSAPI
+	poll time.Duration
+}
+
+// containerInstance returns the cluster and the ARN of the container instance
+// running on the instance, searched in the given cluster or otherwise in all
+// the clusters, or empty strings if the instance isn't registered to ECS.
+func (d *ecsDrainer) containerInstance(cluster, instanceID string) (string, string, error) {
+	clusters := []*string{aws.String(cluster)}
+	if cluster == "" {
+		clusters = nil
+		err := d.svc.ListClustersPages(&ecs.ListClustersInput{},
+			func(page *ecs.ListClustersOutput, lastPage bool) bool {
+				clusters = append(clusters, page.ClusterArns...)
+				return true
+			})
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	for _, c := range clusters {
+		out, err := d.svc.ListContainerInstances(&ecs.ListContainerInstancesInput{
+			Cluster: c,
+			Filter:  aws.String("ec2InstanceId == " + instanceID),
+		})
+		if err != nil {
+			return "", "", err
+		}
+		if len(out.ContainerInstanceArns) > 0 {
+			return aws.StringValue(c), aws.StringValue(out.ContainerInstanceArns[0]), nil
+		}
+	}
+	return "", "", nil
+}
+
+// drainInstance sets the container instance running on the instance to
+// DRAINING, so that ECS reschedules the tasks of its services on the other
+// container instances, and waits until no tasks are running on it or the
+// timeout expires.
+func (d *ecsDrainer) drainInstance(cluster, instanceID string, timeout time.Duration) error {
+	cluster, arn, err := d.containerInstance(cluster, instanceID)
+	if err != nil {
+		return err
+	}
+	if arn == "" {
+		logger.Println("Not draining", instanceID, "which isn't an ECS container instance")
+		return nil
+	}
+
+	logger.Println("Draining the ECS container instance", arn, "of", instanceID, "in the cluster", cluster)
+	if _, err := d.svc.UpdateContainerInstancesState(&ecs.UpdateContainerInstancesStateInput{
+		Cluster:            aws.String(cluster),
+		ContainerInstances: []*string{aws.String(arn)},
+		Status:             aws.String(ecs.ContainerInstanceStatusDraining),
+	}); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		out, err := d.svc.DescribeContainerInstances(&ecs.DescribeContainerInstancesInput{
+			Cluster:            aws.String(cluster),
+			ContainerInstances: []*string{aws.String(arn)},
+		})
+		if err != nil {
+			return err
+		}
+		if len(out.ContainerInstances) == 0 {
+			return nil
+		}
+
+		running := aws.Int64Value(out.ContainerInstances[0].RunningTasksCount)
+		if running == 0 {
+			logger.Println("Drained the ECS container instance", arn)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d tasks still running on the container instance %s after %s",
+				running, arn, timeout)
+		}
+		debug.Println(running, "tasks still running on the ECS container instance", arn)
+		time.Sleep(d.poll)
+	}
+}
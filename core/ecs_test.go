@@ -0,0 +1,120 @@
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestECSCluster(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *Config
+		tags []*ec2.Tag
+		want string
+	}{
+		{
+			name: "cluster tag",
+			cfg:  &Config{ECSCluster: "prod"},
+			tags: []*ec2.Tag{{Key: aws.String(ecsClusterTag), Value: aws.String("staging")}},
+			want: "staging",
+		},
+		{
+			name: "configured cluster",
+			cfg:  &Config{ECSCluster: "prod"},
+			tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("web")}},
+			want: "prod",
+		},
+		{
+			name: "unknown cluster",
+			cfg:  &Config{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ecsCluster(tt.cfg, tt.tags); got != tt.want {
+				t.Errorf("ecsCluster() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestECSDrainInstance(t *testing.T) {
+	containerInstances := map[string]map[string]string{
+		"staging": {"i-other": "arn:staging/other"},
+		"prod":    {"i-1": "arn:prod/1"},
+	}
+
+	tests := []struct {
+		name        string
+		cluster     string
+		ecs         *mockECS
+		wantDrained []string
+		wantErr     bool
+	}{
+		{
+			name:    "given cluster",
+			cluster: "prod",
+			ecs: &mockECS{
+				containerInstances: containerInstances,
+				running:            []int64{2, 1, 0},
+			},
+			wantDrained: []string{"arn:prod/1"},
+		},
+		{
+			name: "cluster searched",
+			ecs: &mockECS{
+				clusters:           []string{"staging", "prod"},
+				containerInstances: containerInstances,
+				running:            []int64{0},
+			},
+			wantDrained: []string{"arn:prod/1"},
+		},
+		{
+			name:    "not a container instance",
+			cluster: "staging",
+			ecs:     &mockECS{containerInstances: containerInstances},
+		},
+		{
+			name:    "tasks still running",
+			cluster: "prod",
+			ecs: &mockECS{
+				containerInstances: containerInstances,
+				running:            []int64{1},
+			},
+			wantDrained: []string{"arn:prod/1"},
+			wantErr:     true,
+		},
+		{
+			name:    "listing the container instances failing",
+			cluster: "prod",
+			ecs:     &mockECS{lcierr: errors.New("AccessDeniedException")},
+			wantErr: true,
+		},
+		{
+			name:    "draining failing",
+			cluster: "prod",
+			ecs: &mockECS{
+				containerInstances: containerInstances,
+				ucierr:             errors.New("AccessDeniedException"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &ecsDrainer{svc: tt.ecs, poll: time.Millisecond}
+			err := d.drainInstance(tt.cluster, "i-1", 10*time.Millisecond)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("drainInstance() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(tt.ecs.drained, tt.wantDrained) {
+				t.Errorf("drainInstance() drained %v, want %v", tt.ecs.drained, tt.wantDrained)
+			}
+		})
+	}
+}
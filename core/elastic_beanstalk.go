@@ -0,0 +1,97 @@
+package autospotting
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/elasticbeanstalk"
+)
+
+// elasticBeanstalkEnvironmentTag is set by Elastic Beanstalk on the groups of
+// its environments.
+const elasticBeanstalkEnvironmentTag = "elasticbeanstalk:environment-id"
+
+// elasticBeanstalkEnvironment returns the ID of the Elastic Beanstalk
+// environment owning the group, or nil for the other groups.
+func elasticBeanstalkEnvironment(group *autoscaling.Group) *string {
+	return getTagValueFromASGWithMatchingTag(group, Tag{Key: elasticBeanstalkEnvironmentTag, Value: "*"})
+}
+
+// isEnvironmentUpdating returns the status of the Elastic Beanstalk
+// environment and true unless it's Ready, such as while it's deploying a new
+// version or regenerating the launch configuration of its group.
+func (r *region) isEnvironmentUpdating(environmentID *string) (string, bool) {
+	svc := r.services.elasticBeanstalk
+	if svc == nil {
+		return "", false
+	}
+
+	out, err := svc.DescribeEnvironments(&elasticbeanstalk.DescribeEnvironmentsInput{
+		EnvironmentIds: []*string{environmentID},
+	})
+	if err != nil {
+		logger.Println("Failed to describe Elastic Beanstalk environment", *environmentID, "with error:", err.Error())
+		return "", false
+	}
+
+	for _, env := range out.Environments {
+		if status := aws.StringValue(env.Status); status != elasticbeanstalk.EnvironmentStatusReady {
+			return status, true
+		}
+	}
+	return "", false
+}
+
+// isHealthyInElasticBeanstalk returns true if the enhanced health reporting
+// of the Elastic Beanstalk environment owning the group sees the instance as
+// healthy, so that the on-demand instances are only replaced once the spot
+// instances serve the environment. The groups of the environments using the
+// basic health reporting only rely on the health checks of the group.
+func (i *instance) isHealthyInElasticBeanstalk(asg *autoScalingGroup) bool {
+	if !i.region.conf.ElasticBeanstalkSupport {
+		return true
+	}
+	environmentID := elasticBeanstalkEnvironment(asg.Group)
+	svc := i.region.services.elasticBeanstalk
+	if environmentID == nil || svc == nil {
+		return true
+	}
+
+	input := &elasticbeanstalk.DescribeInstancesHealthInput{
+		EnvironmentId:  environmentID,
+		AttributeNames: []*string{aws.String(elasticbeanstalk.InstancesHealthAttributeHealthStatus)},
+	}
+	for {
+		out, err := svc.DescribeInstancesHealth(input)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == elasticbeanstalk.ErrCodeInvalidRequestException {
+			debug.Println(asg.name, "No enhanced health reporting for Elastic Beanstalk environment",
+				*environmentID, aerr.Message())
+			return true
+		}
+		if err != nil {
+			logger.Println(asg.name, "Failed to get the Elastic Beanstalk health of", *i.InstanceId, err.Error())
+			return false
+		}
+
+		for _, h := range out.InstanceHealthList {
+			if aws.StringValue(h.InstanceId) != *i.InstanceId {
+				continue
+			}
+			if status := aws.StringValue(h.HealthStatus); status != "Ok" {
+				logger.Println(asg.name, "The spot instance", *i.InstanceId,
+					"isn't healthy in Elastic Beanstalk yet:", status)
+				return false
+			}
+			logger.Println(asg.name, "The spot instance", *i.InstanceId, "is healthy in Elastic Beanstalk")
+			return true
+		}
+
+		if out.NextToken == nil {
+			break
+		}
+		input.NextToken = out.NextToken
+	}
+
+	logger.Println(asg.name, "The spot instance", *i.InstanceId, "isn't reported by Elastic Beanstalk yet")
+	return false
+}
@@ -0,0 +1,146 @@
+package autospotting
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/elasticbeanstalk"
+)
+
+func TestIsEnvironmentUpdating(t *testing.T) {
+	tests := []struct {
+		name   string
+		svc    *mockElasticBeanstalk
+		status string
+		want   bool
+	}{
+		{
+			name: "environment ready",
+			svc: &mockElasticBeanstalk{deo: &elasticbeanstalk.EnvironmentDescriptionsMessage{
+				Environments: []*elasticbeanstalk.EnvironmentDescription{{Status: aws.String("Ready")}},
+			}},
+		},
+		{
+			name: "environment updating",
+			svc: &mockElasticBeanstalk{deo: &elasticbeanstalk.EnvironmentDescriptionsMessage{
+				Environments: []*elasticbeanstalk.EnvironmentDescription{{Status: aws.String("Updating")}},
+			}},
+			status: "Updating",
+			want:   true,
+		},
+		{
+			name: "Elastic Beanstalk unavailable",
+			svc:  &mockElasticBeanstalk{deerr: errors.New("Throttling")},
+		},
+		{
+			name: "no Elastic Beanstalk client",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{}
+			if tt.svc != nil {
+				r.services.elasticBeanstalk = tt.svc
+			}
+			status, got := r.isEnvironmentUpdating(aws.String("e-123"))
+			if got != tt.want || status != tt.status {
+				t.Errorf("isEnvironmentUpdating() = %q, %v, want %q, %v", status, got, tt.status, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsHealthyInElasticBeanstalk(t *testing.T) {
+	health := func(id, status string) *elasticbeanstalk.SingleInstanceHealth {
+		return &elasticbeanstalk.SingleInstanceHealth{InstanceId: aws.String(id), HealthStatus: aws.String(status)}
+	}
+	environmentTags := []*autoscaling.TagDescription{
+		{Key: aws.String(elasticBeanstalkEnvironmentTag), Value: aws.String("e-123")},
+	}
+
+	tests := []struct {
+		name     string
+		disabled bool
+		tags     []*autoscaling.TagDescription
+		svc      *mockElasticBeanstalk
+		want     bool
+	}{
+		{
+			name:     "support disabled",
+			disabled: true,
+			tags:     environmentTags,
+			svc:      &mockElasticBeanstalk{diherr: errors.New("unexpected call")},
+			want:     true,
+		},
+		{
+			name: "group outside of any environment",
+			svc:  &mockElasticBeanstalk{diherr: errors.New("unexpected call")},
+			want: true,
+		},
+		{
+			name: "healthy instance",
+			tags: environmentTags,
+			svc: &mockElasticBeanstalk{diho: []*elasticbeanstalk.DescribeInstancesHealthOutput{
+				{InstanceHealthList: []*elasticbeanstalk.SingleInstanceHealth{health("i-0", "Ok"), health("i-1", "Ok")}},
+			}},
+			want: true,
+		},
+		{
+			name: "instance still starting",
+			tags: environmentTags,
+			svc: &mockElasticBeanstalk{diho: []*elasticbeanstalk.DescribeInstancesHealthOutput{
+				{InstanceHealthList: []*elasticbeanstalk.SingleInstanceHealth{health("i-1", "Pending")}},
+			}},
+		},
+		{
+			name: "instance on the next page",
+			tags: environmentTags,
+			svc: &mockElasticBeanstalk{diho: []*elasticbeanstalk.DescribeInstancesHealthOutput{
+				{
+					InstanceHealthList: []*elasticbeanstalk.SingleInstanceHealth{health("i-0", "Ok")},
+					NextToken:          aws.String("1"),
+				},
+				{InstanceHealthList: []*elasticbeanstalk.SingleInstanceHealth{health("i-1", "Ok")}},
+			}},
+			want: true,
+		},
+		{
+			name: "instance not reported yet",
+			tags: environmentTags,
+			svc: &mockElasticBeanstalk{diho: []*elasticbeanstalk.DescribeInstancesHealthOutput{
+				{InstanceHealthList: []*elasticbeanstalk.SingleInstanceHealth{health("i-0", "Ok")}},
+			}},
+		},
+		{
+			name: "basic health reporting",
+			tags: environmentTags,
+			svc: &mockElasticBeanstalk{diherr: awserr.New(elasticbeanstalk.ErrCodeInvalidRequestException,
+				"Enhanced health reporting is not enabled", nil)},
+			want: true,
+		},
+		{
+			name: "Elastic Beanstalk unavailable",
+			tags: environmentTags,
+			svc:  &mockElasticBeanstalk{diherr: errors.New("Throttling")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("i-1")},
+				region: &region{
+					conf:     &Config{ElasticBeanstalkSupport: !tt.disabled},
+					services: connections{elasticBeanstalk: tt.svc},
+				},
+			}
+			asg := &autoScalingGroup{name: "awseb-e-123-stack-AWSEBAutoScalingGroup", Group: &autoscaling.Group{Tags: tt.tags}}
+			if got := i.isHealthyInElasticBeanstalk(asg); got != tt.want {
+				t.Errorf("isHealthyInElasticBeanstalk() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
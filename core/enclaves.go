@@ -0,0 +1,60 @@
+package autospotting
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// enclaveIncompatibleFamilies lists the instance families which don't support
+// Nitro Enclaves, being either burstable or not built on the Nitro System.
+var enclaveIncompatibleFamilies = map[string]bool{
+	"a1": true, "c1": true, "c3": true, "c4": true, "cc2": true, "cr1": true,
+	"d2": true, "f1": true, "g2": true, "g3": true, "g3s": true, "h1": true,
+	"hs1": true, "i2": true, "i3": true, "m1": true, "m2": true, "m3": true,
+	"m4": true, "mac1": true, "p2": true, "p3": true, "r3": true, "r4": true,
+	"t1": true, "t2": true, "t3": true, "t3a": true, "t4g": true, "x1": true,
+	"x1e": true,
+}
+
+// supportsEnclaves returns true if Nitro Enclaves can be enabled on instances
+// of the given type, which need to be built on the Nitro System and to have
+// enough vCPUs left for the parent instance once some are given to the
+// enclave.
+func supportsEnclaves(candidate instanceTypeInformation) bool {
+	family := strings.SplitN(candidate.instanceType, ".", 2)[0]
+	if enclaveIncompatibleFamilies[family] || strings.HasPrefix(family, "u-") {
+		return false
+	}
+
+	minVCPU := 4
+	if isARM(candidate.PhysicalProcessor) {
+		minVCPU = 2
+	}
+	return candidate.vCPU >= minVCPU
+}
+
+// hasEnclaves returns true if Nitro Enclaves are enabled on the instance.
+func (i *instance) hasEnclaves() bool {
+	return i.EnclaveOptions != nil && aws.BoolValue(i.EnclaveOptions.Enabled)
+}
+
+// isEnclaveCompatible returns true if the candidate instance type supports
+// Nitro Enclaves, when they are enabled on the instance.
+func (i *instance) isEnclaveCompatible(spotCandidate instanceTypeInformation) bool {
+	if !i.hasEnclaves() || supportsEnclaves(spotCandidate) {
+		return true
+	}
+	logger.Println("\tNot compatible with Nitro Enclaves")
+	return false
+}
+
+// spotEnclaveOptions enables Nitro Enclaves on the spot instance if they are
+// enabled on the on-demand instance it replaces.
+func (i *instance) spotEnclaveOptions() *ec2.EnclaveOptionsRequest {
+	if !i.hasEnclaves() {
+		return nil
+	}
+	return &ec2.EnclaveOptionsRequest{Enabled: aws.Bool(true)}
+}
@@ -0,0 +1,48 @@
+package autospotting
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestSupportsEnclaves(t *testing.T) {
+	tests := []struct {
+		candidate instanceTypeInformation
+		want      bool
+	}{
+		{candidate: instanceTypeInformation{instanceType: "m5.xlarge", vCPU: 4, PhysicalProcessor: "Intel Xeon"}, want: true},
+		{candidate: instanceTypeInformation{instanceType: "m5.large", vCPU: 2, PhysicalProcessor: "Intel Xeon"}},
+		{candidate: instanceTypeInformation{instanceType: "m6g.large", vCPU: 2, PhysicalProcessor: "AWS Graviton2 Processor"}, want: true},
+		{candidate: instanceTypeInformation{instanceType: "m4.xlarge", vCPU: 4, PhysicalProcessor: "Intel Xeon"}},
+		{candidate: instanceTypeInformation{instanceType: "t3.xlarge", vCPU: 4, PhysicalProcessor: "Intel Xeon"}},
+		{candidate: instanceTypeInformation{instanceType: "u-6tb1.metal", vCPU: 448, PhysicalProcessor: "Intel Xeon"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.candidate.instanceType, func(t *testing.T) {
+			if got := supportsEnclaves(tt.candidate); got != tt.want {
+				t.Errorf("supportsEnclaves() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstanceEnclaves(t *testing.T) {
+	small := instanceTypeInformation{instanceType: "c5.large", vCPU: 2, PhysicalProcessor: "Intel Xeon"}
+
+	plain := &instance{Instance: &ec2.Instance{}}
+	if !plain.isEnclaveCompatible(small) || plain.spotEnclaveOptions() != nil {
+		t.Error("expected instances without enclaves not to be restricted")
+	}
+
+	enclave := &instance{Instance: &ec2.Instance{
+		EnclaveOptions: &ec2.EnclaveOptions{Enabled: aws.Bool(true)},
+	}}
+	if enclave.isEnclaveCompatible(small) {
+		t.Error("expected c5.large not to be compatible with enclaves")
+	}
+	if o := enclave.spotEnclaveOptions(); o == nil || !aws.BoolValue(o.Enabled) {
+		t.Errorf("expected enclaves to be enabled on the spot instance, got %v", o)
+	}
+}
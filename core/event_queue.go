@@ -0,0 +1,77 @@
+package autospotting
+
+import (
+	"encoding/json"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// QueueEvent sends the interruption or rebalance event of the instance to the
+// SQS FIFO queue configured in the EventQueueURL, instead of handling it right
+// away. The events of the same group share a message group, so they're handled
+// in order and one at a time, and the repeated events of the same instance are
+// deduplicated by the queue, so that concurrent handlers don't both act on the
+// capacity of the group. It returns whether the event was queued, the event
+// having to be handled right away otherwise.
+func (s *SpotTermination) QueueEvent(cfg *Config, instanceID *string, noticeType string,
+	event events.CloudWatchEvent) bool {
+	if cfg == nil || cfg.EventQueueURL == "" {
+		return false
+	}
+
+	body, err := json.Marshal(event)
+	if err != nil {
+		logger.Println("Failed to encode the", noticeType, "event of", *instanceID, err.Error())
+		return false
+	}
+
+	// the events of instances outside of groups are handled separately
+	group := *instanceID
+	if asgName, err := s.getAsgName(instanceID); err == nil {
+		group = lockKey("", s.region, asgName)
+	}
+
+	svc := s.sqsSvc
+	if svc == nil {
+		region := queueRegion(cfg.EventQueueURL)
+		if region == "" {
+			region = s.region
+		}
+		svc = sqs.New(newSession(cfg, region))
+	}
+
+	_, err = svc.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:               aws.String(cfg.EventQueueURL),
+		MessageBody:            aws.String(string(body)),
+		MessageGroupId:         aws.String(group),
+		MessageDeduplicationId: aws.String(*instanceID + ":" + noticeType),
+	})
+	if err != nil {
+		logger.Println("Failed to queue the", noticeType, "event of", *instanceID,
+			"handling it right away:", err.Error())
+		return false
+	}
+
+	logger.Println(s.region, group, "Queued the", noticeType, "event of", *instanceID)
+	return true
+}
+
+// ParseQueuedEvent decodes the event carried by an SQS message, either sent
+// by QueueEvent or by EventBridge, or wrapped in the notification of an SNS
+// topic the queue is subscribed to. It returns false when the message isn't an
+// event, such as a command.
+func ParseQueuedEvent(body string) (events.CloudWatchEvent, bool) {
+	var notification events.SNSEntity
+	if err := json.Unmarshal([]byte(body), &notification); err == nil &&
+		notification.Type == "Notification" && notification.Message != "" {
+		body = notification.Message
+	}
+
+	var event events.CloudWatchEvent
+	if err := json.Unmarshal([]byte(body), &event); err != nil || event.DetailType == "" {
+		return event, false
+	}
+	return event, true
+}
@@ -0,0 +1,96 @@
+package autospotting
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func TestQueueEvent(t *testing.T) {
+	event := events.CloudWatchEvent{
+		ID:         "event-1",
+		DetailType: "EC2 Spot Instance Interruption Warning",
+		Region:     "us-east-1",
+	}
+
+	tests := []struct {
+		name      string
+		cfg       *Config
+		smerr     error
+		want      bool
+		wantGroup string
+	}{
+		{
+			name: "no queue configured",
+			cfg:  &Config{},
+		},
+		{
+			name:      "queued",
+			cfg:       &Config{EventQueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/events.fifo"},
+			want:      true,
+			wantGroup: "us-east-1/web",
+		},
+		{
+			name:      "queue unavailable",
+			cfg:       &Config{EventQueueURL: "https://sqs.us-east-1.amazonaws.com/123456789012/events.fifo"},
+			smerr:     errors.New("AccessDenied"),
+			wantGroup: "us-east-1/web",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := &mockSQS{smerr: tt.smerr}
+			s := &SpotTermination{
+				region: "us-east-1",
+				asSvc: mockASG{dasio: &autoscaling.DescribeAutoScalingInstancesOutput{
+					AutoScalingInstances: []*autoscaling.InstanceDetails{
+						{AutoScalingGroupName: aws.String("web")},
+					},
+				}},
+				sqsSvc: queue,
+			}
+
+			if got := s.QueueEvent(tt.cfg, aws.String("i-spot"), InterruptionNotice, event); got != tt.want {
+				t.Errorf("QueueEvent() = %t, want %t", got, tt.want)
+			}
+			if tt.wantGroup == "" {
+				if len(queue.smi) != 0 {
+					t.Errorf("QueueEvent() sent %v, want nothing", queue.sm)
+				}
+				return
+			}
+			if len(queue.smi) != 1 {
+				t.Fatalf("QueueEvent() sent %v, want the event", queue.sm)
+			}
+			in := queue.smi[0]
+			if aws.StringValue(in.MessageGroupId) != tt.wantGroup ||
+				aws.StringValue(in.MessageDeduplicationId) != "i-spot:"+InterruptionNotice {
+				t.Errorf("QueueEvent() sent the group %q and deduplication ID %q",
+					aws.StringValue(in.MessageGroupId), aws.StringValue(in.MessageDeduplicationId))
+			}
+
+			queued, ok := ParseQueuedEvent(aws.StringValue(in.MessageBody))
+			if !ok || queued.ID != event.ID || queued.DetailType != event.DetailType {
+				t.Errorf("ParseQueuedEvent() = %+v, %t, want the queued event", queued, ok)
+			}
+		})
+	}
+}
+
+func TestParseQueuedEvent(t *testing.T) {
+	if _, ok := ParseQueuedEvent(`{"action":"replace","asg":"web"}`); ok {
+		t.Errorf("ParseQueuedEvent() took a command for an event")
+	}
+	if _, ok := ParseQueuedEvent("not json"); ok {
+		t.Errorf("ParseQueuedEvent() took an invalid message for an event")
+	}
+
+	notification := `{"Type":"Notification","MessageId":"m-1","Message":` +
+		`"{\"id\":\"event-1\",\"detail-type\":\"EC2 Spot Instance Interruption Warning\"}"}`
+	if event, ok := ParseQueuedEvent(notification); !ok || event.ID != "event-1" {
+		t.Errorf("ParseQueuedEvent() = %+v, %t, want the event of the SNS notification", event, ok)
+	}
+}
@@ -0,0 +1,63 @@
+package autospotting
+
+import (
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// The detail types of the CloudWatch events handled by AutoSpotting
+const (
+	// SpotInterruptionWarningEvent is sent two minutes before a spot instance
+	// is interrupted
+	SpotInterruptionWarningEvent = "EC2 Spot Instance Interruption Warning"
+
+	// RebalanceRecommendationEvent is sent when a spot instance is at an
+	// elevated risk of interruption
+	RebalanceRecommendationEvent = "EC2 Instance Rebalance Recommendation"
+
+	// InstanceStateChangeEvent is sent when an instance changes its state,
+	// such as when it starts running
+	InstanceStateChangeEvent = "EC2 Instance State-change Notification"
+
+	// TerminateLifecycleActionEvent is sent by AutoScaling when an instance
+	// is about to be terminated by a group having a termination lifecycle hook
+	TerminateLifecycleActionEvent = "EC2 Instance-terminate Lifecycle Action"
+
+	// LaunchLifecycleActionEvent is sent by AutoScaling when an instance is
+	// launched by or attached to a group having a launch lifecycle hook
+	LaunchLifecycleActionEvent = "EC2 Instance-launch Lifecycle Action"
+)
+
+// EventHandler handles a CloudWatch event of the detail type it was
+// registered for.
+type EventHandler func(event events.CloudWatchEvent) error
+
+// EventRouter dispatches the CloudWatch events to the handlers registered for
+// their detail type, so that new event sources only need a new handler.
+type EventRouter struct {
+	handlers map[string]EventHandler
+}
+
+// NewEventRouter returns a router without any handlers.
+func NewEventRouter() *EventRouter {
+	return &EventRouter{handlers: map[string]EventHandler{}}
+}
+
+// Handle registers the handler of the events of the given detail type,
+// replacing the previous one.
+func (r *EventRouter) Handle(detailType string, h EventHandler) {
+	r.handlers[detailType] = h
+}
+
+// Route passes the event to the handler registered for its detail type,
+// returning false when there isn't any, such as for the scheduled events.
+func (r *EventRouter) Route(event events.CloudWatchEvent) bool {
+	h, ok := r.handlers[event.DetailType]
+	if !ok {
+		return false
+	}
+
+	if err := h(event); err != nil {
+		logger.Println("Failed to handle the", event.DetailType, "event", event.ID+":", err.Error())
+	}
+	return true
+}
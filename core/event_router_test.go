@@ -0,0 +1,35 @@
+package autospotting
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestEventRouter(t *testing.T) {
+	var handled []string
+	router := NewEventRouter()
+	router.Handle(SpotInterruptionWarningEvent, func(e events.CloudWatchEvent) error {
+		handled = append(handled, e.ID)
+		return nil
+	})
+	router.Handle(InstanceStateChangeEvent, func(e events.CloudWatchEvent) error {
+		handled = append(handled, e.ID)
+		return errors.New("DescribeInstances failed")
+	})
+
+	if !router.Route(events.CloudWatchEvent{ID: "event-1", DetailType: SpotInterruptionWarningEvent}) {
+		t.Errorf("Route() didn't handle the interruption warning")
+	}
+	if !router.Route(events.CloudWatchEvent{ID: "event-2", DetailType: InstanceStateChangeEvent}) {
+		t.Errorf("Route() didn't report the failed state change as handled")
+	}
+	if router.Route(events.CloudWatchEvent{ID: "event-3", DetailType: "Scheduled Event"}) {
+		t.Errorf("Route() handled the scheduled event")
+	}
+
+	if len(handled) != 2 || handled[0] != "event-1" || handled[1] != "event-2" {
+		t.Errorf("Route() handled %v, want the first two events", handled)
+	}
+}
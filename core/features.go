@@ -0,0 +1,70 @@
+package autospotting
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Experimental features, which are disabled by default and can be enabled
+// individually using the Features option, such as "-features rebalance-handling"
+const (
+	// RebalanceHandlingFeature also acts on the rebalance recommendations
+	// received for the spot instances, just like on interruption warnings
+	RebalanceHandlingFeature = "rebalance-handling"
+
+	// StateChangeHandlingFeature processes the group of an instance as soon
+	// as the instance starts running, when receiving its state-change event
+	StateChangeHandlingFeature = "state-change-handling"
+)
+
+// knownFeatures lists all the experimental features, along with their
+// description
+var knownFeatures = map[string]string{
+	RebalanceHandlingFeature:   "handle the rebalance recommendations like interruption warnings",
+	StateChangeHandlingFeature: "process the groups of the instances as soon as they start running",
+}
+
+// parseFeatures splits a comma or whitespace separated list of features.
+func parseFeatures(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+}
+
+// ValidateFeatures returns an error if the given list of features contains
+// unknown ones.
+func ValidateFeatures(list string) error {
+	var unknown []string
+	for _, f := range parseFeatures(list) {
+		if _, ok := knownFeatures[f]; !ok {
+			unknown = append(unknown, f)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown features: %s, the supported ones are: %s",
+			strings.Join(unknown, ", "), strings.Join(KnownFeatures(), ", "))
+	}
+	return nil
+}
+
+// KnownFeatures returns the sorted names of the experimental features.
+func KnownFeatures() []string {
+	var names []string
+	for name := range knownFeatures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// FeatureEnabled returns true if the given experimental feature was enabled
+// in the configuration.
+func (c *Config) FeatureEnabled(name string) bool {
+	for _, f := range parseFeatures(c.Features) {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
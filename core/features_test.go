@@ -0,0 +1,49 @@
+package autospotting
+
+import (
+	"testing"
+)
+
+func TestValidateFeatures(t *testing.T) {
+	tests := []struct {
+		name    string
+		list    string
+		wantErr bool
+	}{
+		{name: "empty", list: ""},
+		{name: "known feature", list: RebalanceHandlingFeature},
+		{name: "separators", list: " " + RebalanceHandlingFeature + ",\t"},
+		{name: "unknown feature", list: RebalanceHandlingFeature + ",time-travel", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateFeatures(tt.list); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateFeatures() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestConfig_FeatureEnabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		features string
+		feature  string
+		want     bool
+	}{
+		{name: "no features", features: "", feature: RebalanceHandlingFeature, want: false},
+		{name: "enabled", features: "foo, " + RebalanceHandlingFeature, feature: RebalanceHandlingFeature, want: true},
+		{name: "prefix doesn't match", features: "rebalance", feature: RebalanceHandlingFeature, want: false},
+		{name: "other feature", features: RebalanceHandlingFeature, feature: "foo", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Config{Features: tt.features}
+			if got := c.FeatureEnabled(tt.feature); got != tt.want {
+				t.Errorf("FeatureEnabled() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"math"
 	"path/filepath"
 	"sort"
-	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -101,6 +100,24 @@ type instance struct {
 	region    *region
 	protected bool
 	asg       *autoScalingGroup
+
+	// average CPU load in vCPUs, loaded on demand from CloudWatch
+	cpuLoad *float64
+
+	// attached EBS volumes by device name, loaded on demand
+	volumes map[string]ebsVolume
+
+	// the instance types considered for its spot replacement
+	trace *launchTrace
+
+	// the AMIs of its spot replacements of the other architecture, by
+	// architecture, empty unless its group is converted by its
+	// ArchConversionTag
+	archImages map[string]string
+
+	// the subnet of its spot replacement, assigned in advance when it's
+	// launched concurrently with the other replacements of a batch
+	spotSubnetID *string
 }
 
 type acceptableInstance struct {
@@ -133,6 +150,16 @@ func (i *instance) calculatePrice(spotCandidate instanceTypeInformation) float64
 		debug.Println("\tEBS Surcharge : ", spotCandidate.pricing.ebsSurcharge)
 	}
 
+	if surcharge := i.cpuCreditSurcharge(spotCandidate); surcharge > 0 {
+		spotPrice += surcharge
+		debug.Println("\tCPU credit surcharge : ", surcharge)
+	}
+
+	if ebsCost := i.spotEBSCost(); ebsCost > 0 {
+		spotPrice += ebsCost
+		debug.Println("\tEBS volumes cost : ", ebsCost)
+	}
+
 	debug.Println("\tSpot price: ", spotPrice)
 	debug.Println("\tInstance price: ", i.price)
 	return spotPrice
@@ -163,6 +190,12 @@ func (i *instance) isProtectedFromTermination() bool {
 	return false
 }
 
+// isYoungerThan returns true if the instance was launched less than the given
+// duration ago.
+func (i *instance) isYoungerThan(d time.Duration) bool {
+	return d > 0 && i.LaunchTime != nil && time.Since(*i.LaunchTime) < d
+}
+
 func (i *instance) isProtectedFromScaleIn() bool {
 	if i.asg == nil {
 		return false
@@ -188,6 +221,9 @@ func (i *instance) canTerminate() bool {
 func (i *instance) terminate() error {
 	svc := i.region.services.ec2
 	if i.canTerminate() {
+		if i.isSpot() {
+			cancelPersistentSpotRequest(svc, i.InstanceId)
+		}
 		_, err := svc.TerminateInstances(&ec2.TerminateInstancesInput{
 			InstanceIds: []*string{i.InstanceId},
 		})
@@ -205,12 +241,26 @@ func (i *instance) isPriceCompatible(spotPrice float64) bool {
 		return false
 	}
 
-	if spotPrice <= i.price {
-		return true
+	onDemandPrice := i.price + i.onDemandEBSCost()
+	if spotPrice > onDemandPrice {
+		logger.Printf("\tNot price compatible")
+		return false
 	}
 
-	logger.Printf("\tNot price compatible")
-	return false
+	if minSavings := i.minSavingsPercentage(); spotPrice > onDemandPrice*(1-minSavings/100) {
+		logger.Printf("\tSaving less than %.1f%% of the on-demand price", minSavings)
+		return false
+	}
+	return true
+}
+
+// minSavingsPercentage returns the minimum savings, as a percentage of the
+// on-demand price, required for replacing the instances of the group.
+func (i *instance) minSavingsPercentage() float64 {
+	if i.asg == nil {
+		return 0
+	}
+	return i.asg.config.MinSavingsPercentage
 }
 
 func (i *instance) isClassCompatible(spotCandidate instanceTypeInformation) bool {
@@ -237,7 +287,8 @@ func (i *instance) isSameArch(other instanceTypeInformation) bool {
 	otherCPU := other.PhysicalProcessor
 
 	ret := (isIntelCompatible(thisCPU) && isIntelCompatible(otherCPU)) ||
-		(isARM(thisCPU) && isARM(otherCPU))
+		(isARM(thisCPU) && isARM(otherCPU)) ||
+		i.conversionImageOf(other) != ""
 
 	if !ret {
 		logger.Println("\tInstance CPU architecture mismatch, current CPU architecture",
@@ -346,6 +397,37 @@ func (i *instance) isAllowed(instanceType string, allowedList []string, disallow
 	return true
 }
 
+// incompatibility returns why the candidate instance type can't replace the
+// instance, or an empty string if it's compatible.
+func (i *instance) incompatibility(candidate instanceTypeInformation, candidatePrice float64,
+	attachedVolumes int, allowedList []string, disallowedList []string) string {
+	switch {
+	case !i.isPriceCompatible(candidatePrice):
+		return "not price compatible"
+	case !i.isEBSCompatible(candidate):
+		return "insufficient EBS throughput"
+	case !i.isClassCompatible(candidate):
+		return "not class compatible (CPU/memory/GPU)"
+	case !i.isStorageCompatible(candidate, attachedVolumes):
+		return "not storage compatible"
+	case !i.isVirtualizationCompatible(candidate.virtualizationTypes):
+		return "not virtualization compatible"
+	case !i.isBootModeCompatible(candidate):
+		return "not boot mode compatible"
+	case !i.isEnclaveCompatible(candidate):
+		return "not compatible with Nitro Enclaves"
+	case !i.isLicenseCompatible(candidate):
+		return "not compatible with the license configurations"
+	case !i.isAllowed(candidate.instanceType, allowedList, disallowedList):
+		return "not allowed"
+	case !i.isInterruptionFrequencyAcceptable(candidate):
+		return "interrupted too often"
+	case !i.isLaunchAllowed(candidate):
+		return "denied by the account guardrails"
+	}
+	return ""
+}
+
 func (i *instance) getCompatibleSpotInstanceTypesListSortedAscendingByPrice(allowedList []string,
 	disallowedList []string) ([]instanceTypeInformation, error) {
 	current := i.typeInfo
@@ -355,7 +437,8 @@ func (i *instance) getCompatibleSpotInstanceTypesListSortedAscendingByPrice(allo
 	// device mappings, this number is used later when comparing with each
 	// instance type.
 
-	usedMappings := i.asg.launchConfiguration.countLaunchConfigEphemeralVolumes()
+	usedMappings := i.asg.launchConfiguration.countLaunchConfigEphemeralVolumes() +
+		i.asg.launchTemplate.countLaunchTemplateEphemeralVolumes()
 	attachedVolumesNumber := min(usedMappings, current.instanceStoreDeviceCount)
 
 	// Iterate alphabetically by instance type
@@ -370,15 +453,13 @@ func (i *instance) getCompatibleSpotInstanceTypesListSortedAscendingByPrice(allo
 		candidate := i.region.instanceTypeInformation[k]
 
 		candidatePrice := i.calculatePrice(candidate)
-		logger.Println("Comparing current type", current.instanceType, "with price", i.price,
+		debug.Println("Comparing current type", current.instanceType, "with price", i.price,
 			"with candidate", candidate.instanceType, "with price", candidatePrice)
 
-		if i.isPriceCompatible(candidatePrice) &&
-			i.isEBSCompatible(candidate) &&
-			i.isClassCompatible(candidate) &&
-			i.isStorageCompatible(candidate, attachedVolumesNumber) &&
-			i.isVirtualizationCompatible(candidate.virtualizationTypes) &&
-			i.isAllowed(candidate.instanceType, allowedList, disallowedList) {
+		reason := i.incompatibility(candidate, candidatePrice, attachedVolumesNumber, allowedList, disallowedList)
+		i.trace.add(candidate.instanceType, candidatePrice, reason)
+
+		if reason == "" {
 			acceptableInstanceTypes = append(acceptableInstanceTypes, acceptableInstance{candidate, candidatePrice})
 			logger.Println("\tMATCH FOUND, added", candidate.instanceType, "to launch candiates list")
 		} else if candidate.instanceType != "" {
@@ -390,6 +471,7 @@ func (i *instance) getCompatibleSpotInstanceTypesListSortedAscendingByPrice(allo
 		sort.Slice(acceptableInstanceTypes, func(i, j int) bool {
 			return acceptableInstanceTypes[i].price < acceptableInstanceTypes[j].price
 		})
+		acceptableInstanceTypes = i.rankByScore(acceptableInstanceTypes)
 		debug.Println("List of cheapest compatible spot instances found, sorted ascending by price: ",
 			acceptableInstanceTypes)
 		var result []instanceTypeInformation
@@ -403,14 +485,78 @@ func (i *instance) getCompatibleSpotInstanceTypesListSortedAscendingByPrice(allo
 }
 
 func (i *instance) launchSpotReplacement() error {
+	instanceTypes, err := i.spotReplacementCandidates()
+	if err == nil {
+		err = i.launchSpotInstance(instanceTypes)
+	}
+	i.trace.finish(i.region.conf)
+	return err
+}
+
+// spotReplacementCandidates returns the instance types of the spot instance
+// replacing the instance, in the order they should be tried.
+func (i *instance) spotReplacementCandidates() ([]instanceTypeInformation, error) {
+	if err := i.checkLicenses(); err != nil {
+		logger.Println(i.asg.name, "Not replacing instance due to its licenses:", err.Error())
+		return nil, err
+	}
+
+	i.trace = i.newLaunchTrace()
+	i.archImages = i.conversionImages()
+
+	allowed := i.asg.getAllowedInstanceTypes(i)
+	overrides := i.asg.mixedInstancesOverrides(i.typeInfo.instanceType)
+	if overrides != nil {
+		logger.Println(i.asg.name, "Using the Mixed Instances Policy overrides of the group as candidates:",
+			strings.Join(overrides, ", "))
+		if allowed = i.overridesAllowedBy(overrides, allowed); len(allowed) == 0 {
+			err := fmt.Errorf("none of the Mixed Instances Policy overrides can replace the instance")
+			logger.Println(i.asg.name, "Couldn't launch spot instance:", err.Error())
+			return nil, err
+		}
+	}
+
 	instanceTypes, err := i.getCompatibleSpotInstanceTypesListSortedAscendingByPrice(
-		i.asg.getAllowedInstanceTypes(i),
-		i.asg.getDisallowedInstanceTypes(i))
+		allowed,
+		i.asg.getDisallowedInstanceTypes())
 
 	if err != nil {
 		logger.Println("Couldn't determine the cheapest compatible spot instance type")
-		return err
+		return nil, err
+	}
+
+	if overrides != nil {
+		instanceTypes = prioritizeOverrides(instanceTypes, overrides)
+	} else {
+		instanceTypes = i.applyPriceHysteresis(instanceTypes)
 	}
+	instanceTypes = i.region.capacity.deprioritizeShortTypes(*i.Placement.AvailabilityZone, instanceTypes)
+	i.trace.rank(instanceTypes)
+
+	if err := i.asg.checkSpotSubnets(*i.Placement.AvailabilityZone); err != nil {
+		logger.Println(i.asg.name, "Couldn't launch spot instance:", err.Error())
+		return nil, err
+	}
+	return instanceTypes, nil
+}
+
+// overridesAllowedBy returns the override instance types also allowed by the
+// global configuration.
+func (i *instance) overridesAllowedBy(overrides, allowed []string) []string {
+	var result []string
+	for _, t := range overrides {
+		if i.isAllowed(t, allowed, nil) {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// launchSpotInstance launches the spot instance replacing the instance, using
+// the first of the instance types that can be launched.
+func (i *instance) launchSpotInstance(instanceTypes []instanceTypeInformation) error {
+	var err error
+	var attempts, shortages int
 
 	//Go through all compatible instances until one type launches or we are out of options.
 	for _, instanceType := range instanceTypes {
@@ -418,14 +564,35 @@ func (i *instance) launchSpotReplacement() error {
 		bidPrice := i.getPricetoBid(i.price,
 			instanceType.pricing.spot[az])
 
+		if !i.region.quotas.reserve(instanceType.instanceType, instanceType.vCPU) {
+			err = fmt.Errorf("launching %s would exceed its spot vCPU quota", instanceType.instanceType)
+			i.trace.exclude(instanceType.instanceType, "spot vCPU quota exceeded")
+			continue
+		}
+
 		runInstancesInput := i.createRunInstancesInput(instanceType.instanceType, bidPrice)
 		logger.Println(az, i.asg.name, "Launching spot instance of type", instanceType.instanceType, "with bid price", bidPrice)
 		logger.Println(az, i.asg.name)
-		resp, err := i.region.services.ec2.RunInstances(runInstancesInput)
+		var resp *ec2.Reservation
+		resp, err = i.region.services.ec2.RunInstances(runInstancesInput)
+		attempts++
 
 		if err != nil {
-			if strings.Contains(err.Error(), "InsufficientInstanceCapacity") {
+			i.region.quotas.release(instanceType.instanceType, instanceType.vCPU)
+			i.trace.exclude(instanceType.instanceType, "launch failed: "+err.Error())
+			if isAuditModeError(err) {
+				logger.Println(az, i.asg.name, "Would replace on-demand instance", *i.InstanceId,
+					"of type", *i.InstanceType, "with a spot instance of type", instanceType.instanceType,
+					"with bid price", bidPrice)
+				return err
+			} else if isSpotQuotaError(err) {
+				logger.Println("Couldn't launch spot instance because the spot vCPU quota of the account",
+					"was reached, trying next instance type:", err.Error())
+				i.region.quotas.exhausted(instanceType.instanceType)
+			} else if strings.Contains(err.Error(), "InsufficientInstanceCapacity") {
 				logger.Println("Couldn't launch spot instance due to lack of capcity, trying next instance type:", err.Error())
+				i.region.capacity.record(az, instanceType.instanceType)
+				shortages++
 			} else {
 				logger.Println("Couldn't launch spot instance:", err.Error(), "trying next instance type")
 				debug.Println(runInstancesInput)
@@ -437,26 +604,52 @@ func (i *instance) launchSpotReplacement() error {
 				"with bid price", bidPrice,
 				"current spot price", instanceType.pricing.spot[az])
 
+			i.trace.choose(instanceType.instanceType)
+			i.consumeLicenses(instanceType)
+
+			if i.includesEBSCosts() {
+				onDemandCost := i.price + i.onDemandEBSCost()
+				spotCost := i.calculatePrice(instanceType)
+				logger.Printf("%s Estimated hourly cost including EBS volumes: %.4f on-demand, %.4f spot, saving %.1f%%\n",
+					i.asg.name, onDemandCost, spotCost, 100*(onDemandCost-spotCost)/onDemandCost)
+			}
+
 			debug.Println("RunInstances response:", spew.Sdump(resp))
 			return nil
 		}
 	}
 
 	logger.Println(i.asg.name, "Exhausted all compatible instance types without launch success. Aborting.")
+	if err != nil {
+		notifyAction(i.region.conf, LaunchFailureEvent, "AutoSpotting couldn't launch a spot instance in "+i.asg.name,
+			ActionDetails{
+				Account:          i.region.conf.accountID,
+				Region:           i.region.name,
+				AutoScalingGroup: i.asg.name,
+				OldInstanceID:    *i.InstanceId,
+				OldInstanceType:  *i.InstanceType,
+				OldPrice:         i.price,
+				Error:            err.Error(),
+			})
+
+		if attempts > 0 && shortages == attempts {
+			i.proposeFailover(instanceTypes, err)
+		}
+	}
 	return err
 }
 
 func (i *instance) getPricetoBid(
 	baseOnDemandPrice float64, currentSpotPrice float64) float64 {
 
-	logger.Println("BiddingPolicy: ", i.region.conf.BiddingPolicy)
+	logger.Println("BiddingPolicy: ", i.asg.config.BiddingPolicy)
 
-	if i.region.conf.BiddingPolicy == DefaultBiddingPolicy {
+	if i.asg.config.BiddingPolicy == DefaultBiddingPolicy {
 		logger.Println("Bidding base on demand price", baseOnDemandPrice)
 		return baseOnDemandPrice
 	}
 
-	bufferPrice := math.Min(baseOnDemandPrice, currentSpotPrice*(1.0+i.region.conf.SpotPriceBufferPercentage/100.0))
+	bufferPrice := math.Min(baseOnDemandPrice, currentSpotPrice*(1.0+i.asg.config.SpotPriceBufferPercentage/100.0))
 	logger.Println("Bidding buffer-based price", bufferPrice)
 	return bufferPrice
 }
@@ -468,8 +661,18 @@ func (i *instance) convertBlockDeviceMappings(lc *launchConfiguration) []*ec2.Bl
 		return bds
 	}
 
+	var stateful map[string]bool
+	if i.asg != nil {
+		stateful = i.asg.statefulDevices()
+	}
+
 	for _, lcBDM := range lc.BlockDeviceMappings {
 
+		// the stateful volumes are attached from the replaced instance instead
+		if stateful[aws.StringValue(lcBDM.DeviceName)] {
+			continue
+		}
+
 		ec2BDM := &ec2.BlockDeviceMapping{
 			DeviceName:  lcBDM.DeviceName,
 			VirtualName: lcBDM.VirtualName,
@@ -497,32 +700,81 @@ func (i *instance) convertBlockDeviceMappings(lc *launchConfiguration) []*ec2.Bl
 	return bds
 }
 
+// spotKeyName returns the SSH key pair of the spot instance, which is the one
+// of the on-demand instance unless overridden by the KeyPair configuration of
+// its group.
+func (i *instance) spotKeyName() *string {
+	if i.asg == nil {
+		return i.KeyName
+	}
+
+	switch keyPair := i.asg.config.KeyPair; keyPair {
+	case "":
+		return i.KeyName
+	case NoKeyPair:
+		return nil
+	default:
+		return aws.String(keyPair)
+	}
+}
+
+// convertSecurityGroups returns the security groups of the spot instance,
+// which are those of the on-demand instance unless replaced by the
+// SecurityGroupsTag of its group, followed by the ones from the
+// AdditionalSecurityGroupsTag.
 func (i *instance) convertSecurityGroups() []*string {
 	groupIDs := []*string{}
 	for _, sg := range i.SecurityGroups {
 		groupIDs = append(groupIDs, sg.GroupId)
 	}
+
+	if i.asg == nil {
+		return groupIDs
+	}
+
+	if replacement := i.asg.getTagValue(SecurityGroupsTag); replacement != nil {
+		groupIDs = aws.StringSlice(splitSecurityGroups(*replacement))
+	}
+
+	if additional := i.asg.getTagValue(AdditionalSecurityGroupsTag); additional != nil {
+		for _, id := range splitSecurityGroups(*additional) {
+			if indexOf(aws.StringValueSlice(groupIDs), id) < 0 {
+				groupIDs = append(groupIDs, aws.String(id))
+			}
+		}
+	}
 	return groupIDs
 }
 
+// splitSecurityGroups splits a comma or whitespace separated list of security
+// group IDs.
+func splitSecurityGroups(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	})
+}
+
 func (i *instance) createRunInstancesInput(instanceType string, price float64) *ec2.RunInstancesInput {
 	var retval ec2.RunInstancesInput
 
+	subnetID := i.spotSubnetID
+	if subnetID == nil {
+		subnetID = i.spotSubnet(nil)
+	}
+
 	retval = ec2.RunInstancesInput{
 
+		CpuOptions:   i.spotCPUOptions(),
 		EbsOptimized: i.EbsOptimized,
 
-		ImageId: i.ImageId,
+		EnclaveOptions: i.spotEnclaveOptions(),
 
-		InstanceMarketOptions: &ec2.InstanceMarketOptionsRequest{
-			MarketType: aws.String("spot"),
-			SpotOptions: &ec2.SpotMarketOptions{
-				MaxPrice: aws.String(strconv.FormatFloat(price, 'g', 10, 64)),
-			},
-		},
+		ImageId: i.spotImage(instanceType),
+
+		InstanceMarketOptions: i.spotMarketOptions(price),
 
 		InstanceType: aws.String(instanceType),
-		KeyName:      i.KeyName,
+		KeyName:      i.spotKeyName(),
 		MaxCount:     aws.Int64(1),
 		MinCount:     aws.Int64(1),
 
@@ -530,8 +782,8 @@ func (i *instance) createRunInstancesInput(instanceType string, price float64) *
 
 		SecurityGroupIds: i.convertSecurityGroups(),
 
-		SubnetId:          i.SubnetId,
-		TagSpecifications: i.generateTagsList(),
+		SubnetId:          subnetID,
+		TagSpecifications: append(i.generateTagsList(), i.generateSpotRequestTags()),
 	}
 
 	if i.IamInstanceProfile != nil {
@@ -540,10 +792,34 @@ func (i *instance) createRunInstancesInput(instanceType string, price float64) *
 		}
 	}
 
-	if i.asg.LaunchTemplate != nil {
+	if lt := i.asg.launchTemplate; lt != nil {
+		retval.LaunchTemplate = lt.specification()
+
+		BDMs := i.convertLaunchTemplateBlockDeviceMappings(lt)
+
+		if len(BDMs) > 0 {
+			retval.BlockDeviceMappings = BDMs
+		}
+
+		if mo := lt.LaunchTemplateData.MetadataOptions; mo != nil {
+			retval.MetadataOptions = &ec2.InstanceMetadataOptionsRequest{
+				HttpEndpoint:            mo.HttpEndpoint,
+				HttpPutResponseHopLimit: mo.HttpPutResponseHopLimit,
+				HttpTokens:              mo.HttpTokens,
+			}
+		}
+
+		// the subnet and security groups of the template's network interfaces
+		// can't be combined with those of the instance
+		if len(lt.LaunchTemplateData.NetworkInterfaces) > 0 {
+			retval.NetworkInterfaces = i.convertLaunchTemplateNetworkInterfaces(lt, subnetID)
+			retval.SubnetId, retval.SecurityGroupIds = nil, nil
+		}
+	} else if spec := i.asg.launchTemplateSpecification(); spec != nil {
 		retval.LaunchTemplate = &ec2.LaunchTemplateSpecification{
-			LaunchTemplateId:   i.asg.LaunchTemplate.LaunchTemplateId,
-			LaunchTemplateName: i.asg.LaunchTemplate.LaunchTemplateName,
+			LaunchTemplateId:   spec.LaunchTemplateId,
+			LaunchTemplateName: spec.LaunchTemplateName,
+			Version:            spec.Version,
 		}
 	}
 
@@ -571,7 +847,7 @@ func (i *instance) createRunInstancesInput(instanceType string, price float64) *
 				{
 					AssociatePublicIpAddress: lc.AssociatePublicIpAddress,
 					DeviceIndex:              aws.Int64(0),
-					SubnetId:                 i.SubnetId,
+					SubnetId:                 subnetID,
 					Groups:                   sgIDs,
 				},
 			}
@@ -579,26 +855,162 @@ func (i *instance) createRunInstancesInput(instanceType string, price float64) *
 		}
 	}
 
+	i.setIPv6Addressing(&retval)
+
 	return &retval
 }
 
+// ipv6AddressCount returns the number of IPv6 addresses of the primary
+// network interface of the instance, as set by its launch configuration or
+// launch template and by the subnet settings.
+func (i *instance) ipv6AddressCount() int64 {
+	for _, ni := range i.NetworkInterfaces {
+		if ni.Attachment != nil && aws.Int64Value(ni.Attachment.DeviceIndex) == 0 {
+			return int64(len(ni.Ipv6Addresses))
+		}
+	}
+	return 0
+}
+
+// isIPv6Only returns true for instances running in IPv6-only subnets, which
+// have no IPv4 address.
+func (i *instance) isIPv6Only() bool {
+	return i.PrivateIpAddress == nil && i.ipv6AddressCount() > 0
+}
+
+// privateAddress returns the private IPv4 address of the instance, or its
+// first IPv6 address for instances running in IPv6-only subnets.
+func (i *instance) privateAddress() string {
+	if i.PrivateIpAddress != nil {
+		return *i.PrivateIpAddress
+	}
+	for _, ni := range i.NetworkInterfaces {
+		if ni.Attachment != nil && aws.Int64Value(ni.Attachment.DeviceIndex) == 0 &&
+			len(ni.Ipv6Addresses) > 0 {
+			return aws.StringValue(ni.Ipv6Addresses[0].Ipv6Address)
+		}
+	}
+	return ""
+}
+
+// setIPv6Addressing gives the spot instance as many IPv6 addresses as the
+// on-demand instance it replaces, and avoids assigning public IPv4 addresses
+// in IPv6-only subnets.
+func (i *instance) setIPv6Addressing(input *ec2.RunInstancesInput) {
+	count := i.ipv6AddressCount()
+	if count == 0 {
+		return
+	}
+
+	if len(input.NetworkInterfaces) == 0 {
+		input.Ipv6AddressCount = aws.Int64(count)
+		return
+	}
+
+	ni := input.NetworkInterfaces[0]
+	ni.Ipv6AddressCount = aws.Int64(count)
+	if i.isIPv6Only() {
+		ni.AssociatePublicIpAddress = nil
+	}
+}
+
+const (
+	// launchedByTag marks the resources launched by AutoSpotting
+	launchedByTag = "launched-by-autospotting"
+
+	// launchedForASGTag names the group the spot instance was launched for
+	launchedForASGTag = "launched-for-asg"
+
+	// runIDTag identifies the run which launched the spot instance
+	runIDTag = "autospotting-run-id"
+
+	// sourceInstanceTag names the on-demand instance the spot instance was
+	// launched to replace
+	sourceInstanceTag = "autospotting-source-instance"
+)
+
+// identificationTags are set on the spot instances launched to replace this
+// on-demand instance and on their spot requests.
+func (i *instance) identificationTags() []*ec2.Tag {
+	ownership := i.ownership()
+	return []*ec2.Tag{
+		{
+			Key:   aws.String(ownership.Tag.Key),
+			Value: aws.String(ownership.Tag.Value),
+		},
+		{
+			Key:   aws.String(ownership.Tag.GroupKey),
+			Value: aws.String(i.asg.name),
+		},
+		{
+			Key:   aws.String(runIDTag),
+			Value: aws.String(i.region.conf.runID),
+		},
+		{
+			Key:   aws.String(sourceInstanceTag),
+			Value: i.InstanceId,
+		},
+	}
+}
+
+// ownerGroup returns the name of the group the instance was launched for by
+// AutoSpotting, based on the given identification tags, or an empty string if
+// it wasn't launched by AutoSpotting or was meanwhile detached from its group.
+// The tags are given by the caller, since the instances of the region are
+// scanned concurrently by the goroutines of their groups.
+func (i *instance) ownerGroup(o Ownership) string {
+	_, group := o.owner(i.Tags)
+	return group
+}
+
+// isLaunchedByAutoSpotting tells if the instance has the given ownership tags
+// of the instances launched by AutoSpotting.
+func (i *instance) isLaunchedByAutoSpotting(o Ownership) bool {
+	owned, _ := o.owner(i.Tags)
+	return owned
+}
+
+// ownership returns the tags identifying the instances launched by
+// AutoSpotting.
+func (i *instance) ownership() Ownership {
+	if i.region == nil {
+		return defaultOwnership
+	}
+	return i.region.conf.Ownership()
+}
+
+// getTagValue returns the value of the given tag of the instance, or an empty
+// string if it isn't set.
+func (i *instance) getTagValue(key string) string {
+	for _, tag := range i.Tags {
+		if *tag.Key == key {
+			return aws.StringValue(tag.Value)
+		}
+	}
+	return ""
+}
+
+// setTag updates the given tag in the local copy of the instance, after it
+// was set on the instance itself.
+func (i *instance) setTag(tag *ec2.Tag) {
+	for _, t := range i.Tags {
+		if *t.Key == *tag.Key {
+			t.Value = tag.Value
+			return
+		}
+	}
+	i.Tags = append(i.Tags, tag)
+}
+
 func (i *instance) generateTagsList() []*ec2.TagSpecification {
 	tags := ec2.TagSpecification{
 		ResourceType: aws.String("instance"),
-		Tags: []*ec2.Tag{
+		Tags: append([]*ec2.Tag{
 			{
 				Key:   aws.String("LaunchConfigurationName"),
 				Value: i.asg.LaunchConfigurationName,
 			},
-			{
-				Key:   aws.String("launched-by-autospotting"),
-				Value: aws.String("true"),
-			},
-			{
-				Key:   aws.String("launched-for-asg"),
-				Value: aws.String(i.asg.name),
-			},
-		},
+		}, i.identificationTags()...),
 	}
 
 	for _, tag := range i.Tags {
@@ -609,6 +1021,15 @@ func (i *instance) generateTagsList() []*ec2.TagSpecification {
 	return []*ec2.TagSpecification{&tags}
 }
 
+// generateSpotRequestTags tags the spot request, so that it can be cancelled
+// if it's never fulfilled.
+func (i *instance) generateSpotRequestTags() *ec2.TagSpecification {
+	return &ec2.TagSpecification{
+		ResourceType: aws.String(ec2.ResourceTypeSpotInstancesRequest),
+		Tags:         i.identificationTags(),
+	}
+}
+
 // returns an instance ID as *string, set to nil if we need to wait for the next
 // run in case there are no spot instances
 func (i *instance) isReadyToAttach(asg *autoScalingGroup) bool {
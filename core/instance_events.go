@@ -0,0 +1,152 @@
+package autospotting
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// LifecycleHookPrefix starts the names of the termination lifecycle hooks
+// whose actions are completed by AutoSpotting, after draining the instance.
+const LifecycleHookPrefix = "autospotting"
+
+// instanceStateChange is the detail of the instance state-change events.
+type instanceStateChange struct {
+	InstanceID string `json:"instance-id"`
+	State      string `json:"state"`
+}
+
+// lifecycleAction is the detail of the AutoScaling lifecycle action events.
+type lifecycleAction struct {
+	LifecycleActionToken string
+	AutoScalingGroupName string
+	LifecycleHookName    string
+	EC2InstanceID        string `json:"EC2InstanceId"`
+	LifecycleTransition  string
+}
+
+// HandleInstanceStateChange processes the group of an instance which just
+// started running right away, instead of waiting for the next run, so that
+// the on-demand instances launched by a scale-out are replaced sooner and the
+// spot instances launched by AutoSpotting are attached sooner. The schedule
+// of the group is still respected.
+func (s *SpotTermination) HandleInstanceStateChange(cfg *Config, event events.CloudWatchEvent) error {
+	var detail instanceStateChange
+	if err := json.Unmarshal(event.Detail, &detail); err != nil {
+		return err
+	}
+	if detail.State != ec2.InstanceStateNameRunning || detail.InstanceID == "" {
+		return nil
+	}
+
+	asgName := s.groupOf(&detail.InstanceID)
+	if asgName == "" {
+		debug.Println("Instance", detail.InstanceID, "doesn't belong to any group, ignoring it")
+		return nil
+	}
+
+	logger.Println(s.region, asgName, "Processing the group of the running instance", detail.InstanceID)
+	return ExecuteCommand(cfg, Command{Action: processCommand, AutoScalingGroup: asgName, Region: s.region})
+}
+
+// groupOf returns the name of the group of the instance, either attached to
+// it or launched for it by AutoSpotting, or an empty string.
+func (s *SpotTermination) groupOf(instanceID *string) string {
+	out, err := s.asSvc.DescribeAutoScalingInstances(&autoscaling.DescribeAutoScalingInstancesInput{
+		InstanceIds: []*string{instanceID},
+	})
+	if err != nil {
+		logger.Println("Failed to describe the group of", *instanceID, err.Error())
+		return ""
+	}
+	if len(out.AutoScalingInstances) > 0 {
+		return aws.StringValue(out.AutoScalingInstances[0].AutoScalingGroupName)
+	}
+
+	if i := s.describeInstance(instanceID); i != nil {
+		if owned, asgName := s.ownership.owner(i.Tags); owned {
+			return asgName
+		}
+	}
+	return ""
+}
+
+// HandleLifecycleAction drains the instances terminated by their groups from
+// their Kubernetes or ECS clusters, then lets the termination proceed. Only
+// the actions of the lifecycle hooks named with the LifecycleHookPrefix are
+// completed, leaving the other hooks to their owners.
+func (s *SpotTermination) HandleLifecycleAction(cfg *Config, event events.CloudWatchEvent) error {
+	var detail lifecycleAction
+	if err := json.Unmarshal(event.Detail, &detail); err != nil {
+		return err
+	}
+	if detail.LifecycleTransition != "autoscaling:EC2_INSTANCE_TERMINATING" ||
+		!strings.HasPrefix(detail.LifecycleHookName, LifecycleHookPrefix) {
+		debug.Println("Ignoring the lifecycle action of the hook", detail.LifecycleHookName)
+		return nil
+	}
+
+	logger.Println(s.region, detail.AutoScalingGroupName, "Draining", detail.EC2InstanceID,
+		"before its termination by the lifecycle hook", detail.LifecycleHookName)
+	s.DrainNode(cfg, &detail.EC2InstanceID)
+
+	_, err := s.asSvc.CompleteLifecycleAction(&autoscaling.CompleteLifecycleActionInput{
+		AutoScalingGroupName:  aws.String(detail.AutoScalingGroupName),
+		LifecycleHookName:     aws.String(detail.LifecycleHookName),
+		LifecycleActionToken:  aws.String(detail.LifecycleActionToken),
+		InstanceId:            aws.String(detail.EC2InstanceID),
+		LifecycleActionResult: aws.String("CONTINUE"),
+	})
+	if err != nil {
+		logger.Println(s.region, detail.AutoScalingGroupName, "Failed to complete the lifecycle action of",
+			detail.EC2InstanceID, err.Error())
+	}
+	return err
+}
+
+// HandleLaunchLifecycleAction completes the launch lifecycle actions of the
+// spot instances attached to their groups by AutoSpotting. These instances
+// already booted before being attached, so whatever completes the launch
+// hooks from within the instances already ran and the hooks would otherwise
+// time out, abandoning the launch and terminating the new instance. The
+// launch actions of the other instances are left to the owners of the hooks.
+func (s *SpotTermination) HandleLaunchLifecycleAction(cfg *Config, event events.CloudWatchEvent) error {
+	var detail lifecycleAction
+	if err := json.Unmarshal(event.Detail, &detail); err != nil {
+		return err
+	}
+	if detail.LifecycleTransition != "autoscaling:EC2_INSTANCE_LAUNCHING" || detail.EC2InstanceID == "" {
+		return nil
+	}
+
+	i := s.describeInstance(&detail.EC2InstanceID)
+	if i == nil {
+		debug.Println("Couldn't describe", detail.EC2InstanceID, "ignoring its launch lifecycle action")
+		return nil
+	}
+	owned, asgName := s.ownership.owner(i.Tags)
+	if !owned || (asgName != "" && asgName != detail.AutoScalingGroupName) {
+		debug.Println("Instance", detail.EC2InstanceID, "wasn't launched by AutoSpotting for",
+			detail.AutoScalingGroupName, "ignoring its launch lifecycle action")
+		return nil
+	}
+
+	logger.Println(s.region, detail.AutoScalingGroupName, "Completing the launch lifecycle action of",
+		detail.LifecycleHookName, "for the attached instance", detail.EC2InstanceID)
+	_, err := s.asSvc.CompleteLifecycleAction(&autoscaling.CompleteLifecycleActionInput{
+		AutoScalingGroupName:  aws.String(detail.AutoScalingGroupName),
+		LifecycleHookName:     aws.String(detail.LifecycleHookName),
+		LifecycleActionToken:  aws.String(detail.LifecycleActionToken),
+		InstanceId:            aws.String(detail.EC2InstanceID),
+		LifecycleActionResult: aws.String("CONTINUE"),
+	})
+	if err != nil {
+		logger.Println(s.region, detail.AutoScalingGroupName, "Failed to complete the launch lifecycle action of",
+			detail.EC2InstanceID, err.Error())
+	}
+	return err
+}
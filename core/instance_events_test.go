@@ -0,0 +1,209 @@
+package autospotting
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestGroupOf(t *testing.T) {
+	tests := []struct {
+		name    string
+		members []*autoscaling.InstanceDetails
+		dasierr error
+		tags    []*ec2.Tag
+		want    string
+	}{
+		{
+			name:    "attached to a group",
+			members: []*autoscaling.InstanceDetails{{AutoScalingGroupName: aws.String("web")}},
+			want:    "web",
+		},
+		{
+			name: "launched for a group by AutoSpotting",
+			tags: []*ec2.Tag{
+				{Key: aws.String("launched-by-autospotting"), Value: aws.String("true")},
+				{Key: aws.String("launched-for-asg"), Value: aws.String("web")},
+			},
+			want: "web",
+		},
+		{
+			name: "outside of any group",
+			tags: []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("bastion")}},
+		},
+		{
+			name:    "AutoScaling unavailable",
+			dasierr: errors.New("Throttling"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &SpotTermination{
+				region: "us-east-1",
+				asSvc: mockASG{
+					dasio:   &autoscaling.DescribeAutoScalingInstancesOutput{AutoScalingInstances: tt.members},
+					dasierr: tt.dasierr,
+				},
+				ec2Svc: mockEC2{dio: &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{
+					Instances: []*ec2.Instance{{InstanceId: aws.String("i-1"), Tags: tt.tags}},
+				}}}},
+				ownership: defaultOwnership,
+			}
+			if got := s.groupOf(aws.String("i-1")); got != tt.want {
+				t.Errorf("groupOf() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleInstanceStateChangeIgnoresOtherStates(t *testing.T) {
+	detail, _ := json.Marshal(instanceStateChange{InstanceID: "i-1", State: ec2.InstanceStateNameStopping})
+	s := &SpotTermination{region: "us-east-1"}
+
+	// the AutoScaling and EC2 clients are nil, so any API call would panic
+	if err := s.HandleInstanceStateChange(&Config{}, events.CloudWatchEvent{
+		DetailType: InstanceStateChangeEvent,
+		Detail:     detail,
+	}); err != nil {
+		t.Errorf("HandleInstanceStateChange() = %v, want the stopping instance ignored", err)
+	}
+}
+
+func TestHandleLifecycleAction(t *testing.T) {
+	tests := []struct {
+		name       string
+		hook       string
+		transition string
+		want       bool
+	}{
+		{
+			name:       "hook of AutoSpotting",
+			hook:       "autospotting-drain",
+			transition: "autoscaling:EC2_INSTANCE_TERMINATING",
+			want:       true,
+		},
+		{
+			name:       "hook of another tool",
+			hook:       "backup",
+			transition: "autoscaling:EC2_INSTANCE_TERMINATING",
+		},
+		{
+			name:       "launch hook",
+			hook:       "autospotting-drain",
+			transition: "autoscaling:EC2_INSTANCE_LAUNCHING",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detail, _ := json.Marshal(lifecycleAction{
+				LifecycleActionToken: "token",
+				AutoScalingGroupName: "web",
+				LifecycleHookName:    tt.hook,
+				EC2InstanceID:        "i-1",
+				LifecycleTransition:  tt.transition,
+			})
+			var completed []*autoscaling.CompleteLifecycleActionInput
+			s := &SpotTermination{region: "us-east-1", asSvc: mockASG{clai: &completed}}
+
+			if err := s.HandleLifecycleAction(&Config{}, events.CloudWatchEvent{
+				DetailType: TerminateLifecycleActionEvent,
+				Detail:     detail,
+			}); err != nil {
+				t.Fatalf("HandleLifecycleAction() unexpected error: %s", err.Error())
+			}
+
+			if !tt.want {
+				if len(completed) != 0 {
+					t.Errorf("HandleLifecycleAction() completed %v, want nothing", completed)
+				}
+				return
+			}
+			if len(completed) != 1 || aws.StringValue(completed[0].LifecycleActionToken) != "token" ||
+				aws.StringValue(completed[0].InstanceId) != "i-1" ||
+				aws.StringValue(completed[0].LifecycleActionResult) != "CONTINUE" {
+				t.Errorf("HandleLifecycleAction() completed %v, want the action continued", completed)
+			}
+		})
+	}
+}
+
+func TestHandleLaunchLifecycleAction(t *testing.T) {
+	launchedFor := func(asg string) []*ec2.Tag {
+		return []*ec2.Tag{
+			{Key: aws.String("launched-by-autospotting"), Value: aws.String("true")},
+			{Key: aws.String("launched-for-asg"), Value: aws.String(asg)},
+		}
+	}
+	tests := []struct {
+		name       string
+		transition string
+		tags       []*ec2.Tag
+		want       bool
+	}{
+		{
+			name:       "attached by AutoSpotting",
+			transition: "autoscaling:EC2_INSTANCE_LAUNCHING",
+			tags:       launchedFor("web"),
+			want:       true,
+		},
+		{
+			name:       "launched by AutoSpotting for another group",
+			transition: "autoscaling:EC2_INSTANCE_LAUNCHING",
+			tags:       launchedFor("api"),
+		},
+		{
+			name:       "launched by the group",
+			transition: "autoscaling:EC2_INSTANCE_LAUNCHING",
+			tags:       []*ec2.Tag{{Key: aws.String("Name"), Value: aws.String("web")}},
+		},
+		{
+			name:       "termination hook",
+			transition: "autoscaling:EC2_INSTANCE_TERMINATING",
+			tags:       launchedFor("web"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detail, _ := json.Marshal(lifecycleAction{
+				LifecycleActionToken: "token",
+				AutoScalingGroupName: "web",
+				LifecycleHookName:    "bootstrap",
+				EC2InstanceID:        "i-1",
+				LifecycleTransition:  tt.transition,
+			})
+			var completed []*autoscaling.CompleteLifecycleActionInput
+			s := &SpotTermination{
+				region: "us-east-1",
+				asSvc:  mockASG{clai: &completed},
+				ec2Svc: mockEC2{dio: &ec2.DescribeInstancesOutput{Reservations: []*ec2.Reservation{{
+					Instances: []*ec2.Instance{{InstanceId: aws.String("i-1"), Tags: tt.tags}},
+				}}}},
+				ownership: defaultOwnership,
+			}
+
+			if err := s.HandleLaunchLifecycleAction(&Config{}, events.CloudWatchEvent{
+				DetailType: LaunchLifecycleActionEvent,
+				Detail:     detail,
+			}); err != nil {
+				t.Fatalf("HandleLaunchLifecycleAction() unexpected error: %s", err.Error())
+			}
+
+			if !tt.want {
+				if len(completed) != 0 {
+					t.Errorf("HandleLaunchLifecycleAction() completed %v, want nothing", completed)
+				}
+				return
+			}
+			if len(completed) != 1 || aws.StringValue(completed[0].LifecycleHookName) != "bootstrap" ||
+				aws.StringValue(completed[0].InstanceId) != "i-1" ||
+				aws.StringValue(completed[0].LifecycleActionResult) != "CONTINUE" {
+				t.Errorf("HandleLaunchLifecycleAction() completed %v, want the action continued", completed)
+			}
+		})
+	}
+}
@@ -319,6 +319,7 @@ func TestIsPriceCompatible(t *testing.T) {
 		availabilityZone *string
 		instancePrice    float64
 		bestPrice        float64
+		minSavings       float64
 		expected         bool
 	}{
 		{name: "No spot price for such availability zone",
@@ -360,6 +361,28 @@ func TestIsPriceCompatible(t *testing.T) {
 			bestPrice:        0.7,
 			expected:         false,
 		},
+		{name: "Spot price saves enough",
+			spotPrices: prices{
+				spot: map[string]float64{
+					"eu-west-1": 0.5,
+				},
+			},
+			availabilityZone: aws.String("eu-west-1"),
+			instancePrice:    1.0,
+			minSavings:       40,
+			expected:         true,
+		},
+		{name: "Spot price doesn't save enough",
+			spotPrices: prices{
+				spot: map[string]float64{
+					"eu-west-1": 0.98,
+				},
+			},
+			availabilityZone: aws.String("eu-west-1"),
+			instancePrice:    1.0,
+			minSavings:       5,
+			expected:         false,
+		},
 	}
 
 	for _, tt := range tests {
@@ -369,6 +392,9 @@ func TestIsPriceCompatible(t *testing.T) {
 					AvailabilityZone: tt.availabilityZone,
 				}},
 				price: tt.instancePrice,
+				asg: &autoScalingGroup{
+					config: AutoScalingConfig{MinSavingsPercentage: tt.minSavings},
+				},
 			}
 			candidate := instanceTypeInformation{pricing: prices{}}
 			candidate.pricing = tt.spotPrices
@@ -1141,6 +1167,9 @@ func TestGetPricetoBid(t *testing.T) {
 				name: "us-east-1",
 				conf: cfg,
 			},
+			asg: &autoScalingGroup{
+				config: cfg.AutoScalingConfig,
+			},
 		}
 
 		currentSpotPrice := tt.currentSpotPrice
@@ -1265,6 +1294,14 @@ func TestGenerateTagList(t *testing.T) {
 							Key:   aws.String("launched-for-asg"),
 							Value: aws.String("myASG"),
 						},
+						{
+							Key:   aws.String("autospotting-run-id"),
+							Value: aws.String("run-1"),
+						},
+						{
+							Key:   aws.String("autospotting-source-instance"),
+							Value: aws.String("i-ondemand"),
+						},
 					},
 				},
 			},
@@ -1298,6 +1335,14 @@ func TestGenerateTagList(t *testing.T) {
 							Key:   aws.String("launched-for-asg"),
 							Value: aws.String("myASG"),
 						},
+						{
+							Key:   aws.String("autospotting-run-id"),
+							Value: aws.String("run-1"),
+						},
+						{
+							Key:   aws.String("autospotting-source-instance"),
+							Value: aws.String("i-ondemand"),
+						},
 						{
 							Key:   aws.String("foo"),
 							Value: aws.String("bar"),
@@ -1317,8 +1362,10 @@ func TestGenerateTagList(t *testing.T) {
 
 			i := instance{
 				Instance: &ec2.Instance{
-					Tags: tt.instanceTags,
+					InstanceId: aws.String("i-ondemand"),
+					Tags:       tt.instanceTags,
 				},
+				region: &region{conf: &Config{runID: "run-1"}},
 				asg: &autoScalingGroup{
 					name: tt.ASGName,
 					Group: &autoscaling.Group{
@@ -1503,6 +1550,49 @@ func Test_instance_convertSecurityGroups(t *testing.T) {
 			},
 			want: []*string{aws.String("sg-123"), aws.String("sg-456")},
 		},
+		{
+			name: "replaced SGs",
+			inst: instance{
+				Instance: &ec2.Instance{
+					SecurityGroups: []*ec2.GroupIdentifier{{
+						GroupId: aws.String("sg-123"),
+					}},
+				},
+				asg: newTestGroup(map[string]string{
+					SecurityGroupsTag: "sg-456, sg-789",
+				}),
+			},
+			want: []*string{aws.String("sg-456"), aws.String("sg-789")},
+		},
+		{
+			name: "additional SGs",
+			inst: instance{
+				Instance: &ec2.Instance{
+					SecurityGroups: []*ec2.GroupIdentifier{{
+						GroupId: aws.String("sg-123"),
+					}},
+				},
+				asg: newTestGroup(map[string]string{
+					AdditionalSecurityGroupsTag: "sg-123,sg-drain",
+				}),
+			},
+			want: []*string{aws.String("sg-123"), aws.String("sg-drain")},
+		},
+		{
+			name: "replaced and additional SGs",
+			inst: instance{
+				Instance: &ec2.Instance{
+					SecurityGroups: []*ec2.GroupIdentifier{{
+						GroupId: aws.String("sg-123"),
+					}},
+				},
+				asg: newTestGroup(map[string]string{
+					SecurityGroupsTag:           "sg-456",
+					AdditionalSecurityGroupsTag: "sg-drain",
+				}),
+			},
+			want: []*string{aws.String("sg-456"), aws.String("sg-drain")},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -1529,6 +1619,7 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 		{
 			name: "create run instances input without launch-configuration",
 			inst: instance{
+				region: &region{conf: &Config{runID: "run-1"}},
 				asg: &autoScalingGroup{
 					name: "mygroup",
 					Group: &autoscaling.Group{
@@ -1541,6 +1632,7 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 					launchConfiguration: nil,
 				},
 				Instance: &ec2.Instance{
+					InstanceId:   aws.String("i-ondemand"),
 					EbsOptimized: aws.Bool(true),
 
 					IamInstanceProfile: &ec2.IamInstanceProfile{
@@ -1626,14 +1718,44 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 							Key:   aws.String("launched-for-asg"),
 							Value: aws.String("mygroup"),
 						},
+						{
+							Key:   aws.String("autospotting-run-id"),
+							Value: aws.String("run-1"),
+						},
+						{
+							Key:   aws.String("autospotting-source-instance"),
+							Value: aws.String("i-ondemand"),
+						},
 					},
 				},
+					{
+						ResourceType: aws.String("spot-instances-request"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							},
+							{
+								Key:   aws.String("autospotting-run-id"),
+								Value: aws.String("run-1"),
+							},
+							{
+								Key:   aws.String("autospotting-source-instance"),
+								Value: aws.String("i-ondemand"),
+							},
+						},
+					},
 				},
 			},
 		},
 		{
 			name: "create run instances input with simple LC",
 			inst: instance{
+				region: &region{conf: &Config{runID: "run-1"}},
 				asg: &autoScalingGroup{
 					name: "mygroup",
 					Group: &autoscaling.Group{
@@ -1653,6 +1775,7 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 					},
 				},
 				Instance: &ec2.Instance{
+					InstanceId:   aws.String("i-ondemand"),
 					EbsOptimized: aws.Bool(true),
 
 					IamInstanceProfile: &ec2.IamInstanceProfile{
@@ -1738,8 +1861,37 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 							Key:   aws.String("launched-for-asg"),
 							Value: aws.String("mygroup"),
 						},
+						{
+							Key:   aws.String("autospotting-run-id"),
+							Value: aws.String("run-1"),
+						},
+						{
+							Key:   aws.String("autospotting-source-instance"),
+							Value: aws.String("i-ondemand"),
+						},
 					},
 				},
+					{
+						ResourceType: aws.String("spot-instances-request"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							},
+							{
+								Key:   aws.String("autospotting-run-id"),
+								Value: aws.String("run-1"),
+							},
+							{
+								Key:   aws.String("autospotting-source-instance"),
+								Value: aws.String("i-ondemand"),
+							},
+						},
+					},
 				},
 				UserData: aws.String("userdata"),
 			},
@@ -1748,6 +1900,7 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 		{
 			name: "create run instances input with full launch configuration",
 			inst: instance{
+				region: &region{conf: &Config{runID: "run-1"}},
 				asg: &autoScalingGroup{
 					name: "mygroup",
 					Group: &autoscaling.Group{
@@ -1774,6 +1927,7 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 					},
 				},
 				Instance: &ec2.Instance{
+					InstanceId:   aws.String("i-ondemand"),
 					EbsOptimized: aws.Bool(true),
 
 					IamInstanceProfile: &ec2.IamInstanceProfile{
@@ -1873,8 +2027,37 @@ func Test_instance_createRunInstancesInput(t *testing.T) {
 							Key:   aws.String("launched-for-asg"),
 							Value: aws.String("mygroup"),
 						},
+						{
+							Key:   aws.String("autospotting-run-id"),
+							Value: aws.String("run-1"),
+						},
+						{
+							Key:   aws.String("autospotting-source-instance"),
+							Value: aws.String("i-ondemand"),
+						},
 					},
 				},
+					{
+						ResourceType: aws.String("spot-instances-request"),
+						Tags: []*ec2.Tag{
+							{
+								Key:   aws.String("launched-by-autospotting"),
+								Value: aws.String("true"),
+							},
+							{
+								Key:   aws.String("launched-for-asg"),
+								Value: aws.String("mygroup"),
+							},
+							{
+								Key:   aws.String("autospotting-run-id"),
+								Value: aws.String("run-1"),
+							},
+							{
+								Key:   aws.String("autospotting-source-instance"),
+								Value: aws.String("i-ondemand"),
+							},
+						},
+					},
 				},
 				UserData: aws.String("userdata"),
 			},
@@ -2129,3 +2312,102 @@ func Test_instance_isSameArch(t *testing.T) {
 		})
 	}
 }
+
+func Test_instance_setIPv6Addressing(t *testing.T) {
+	primary := func(ipv6 ...string) []*ec2.InstanceNetworkInterface {
+		ni := &ec2.InstanceNetworkInterface{
+			Attachment: &ec2.InstanceNetworkInterfaceAttachment{DeviceIndex: aws.Int64(0)},
+		}
+		for _, a := range ipv6 {
+			ni.Ipv6Addresses = append(ni.Ipv6Addresses, &ec2.InstanceIpv6Address{Ipv6Address: aws.String(a)})
+		}
+		return []*ec2.InstanceNetworkInterface{ni}
+	}
+	vpcInput := func() *ec2.RunInstancesInput {
+		return &ec2.RunInstancesInput{
+			NetworkInterfaces: []*ec2.InstanceNetworkInterfaceSpecification{{
+				AssociatePublicIpAddress: aws.Bool(true),
+				DeviceIndex:              aws.Int64(0),
+			}},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		inst       *ec2.Instance
+		input      *ec2.RunInstancesInput
+		wantCount  *int64
+		wantPublic *bool
+	}{
+		{
+			name:       "IPv4 only",
+			inst:       &ec2.Instance{PrivateIpAddress: aws.String("10.0.0.1"), NetworkInterfaces: primary()},
+			input:      vpcInput(),
+			wantPublic: aws.Bool(true),
+		},
+		{
+			name: "dual-stack",
+			inst: &ec2.Instance{
+				PrivateIpAddress:  aws.String("10.0.0.1"),
+				NetworkInterfaces: primary("2001:db8::1", "2001:db8::2"),
+			},
+			input:      vpcInput(),
+			wantCount:  aws.Int64(2),
+			wantPublic: aws.Bool(true),
+		},
+		{
+			name:      "IPv6 only",
+			inst:      &ec2.Instance{NetworkInterfaces: primary("2001:db8::1")},
+			input:     vpcInput(),
+			wantCount: aws.Int64(1),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{Instance: tt.inst}
+			i.setIPv6Addressing(tt.input)
+
+			ni := tt.input.NetworkInterfaces[0]
+			if !reflect.DeepEqual(ni.Ipv6AddressCount, tt.wantCount) {
+				t.Errorf("Ipv6AddressCount = %v, want %v", ni.Ipv6AddressCount, tt.wantCount)
+			}
+			if !reflect.DeepEqual(ni.AssociatePublicIpAddress, tt.wantPublic) {
+				t.Errorf("AssociatePublicIpAddress = %v, want %v", ni.AssociatePublicIpAddress, tt.wantPublic)
+			}
+		})
+	}
+
+	// launch templates without network interfaces get the top-level count
+	input := &ec2.RunInstancesInput{}
+	i := &instance{Instance: &ec2.Instance{NetworkInterfaces: primary("2001:db8::1")}}
+	i.setIPv6Addressing(input)
+	if aws.Int64Value(input.Ipv6AddressCount) != 1 {
+		t.Errorf("Ipv6AddressCount = %v, want 1", input.Ipv6AddressCount)
+	}
+	if i.privateAddress() != "2001:db8::1" {
+		t.Errorf("privateAddress() = %s, want the IPv6 address", i.privateAddress())
+	}
+}
+
+func Test_instance_spotKeyName(t *testing.T) {
+	tests := []struct {
+		name    string
+		keyPair string
+		want    *string
+	}{
+		{name: "keep the key pair", want: aws.String("old-key")},
+		{name: "different key pair", keyPair: "new-key", want: aws.String("new-key")},
+		{name: "no key pair", keyPair: NoKeyPair},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{KeyName: aws.String("old-key")},
+				asg:      &autoScalingGroup{config: AutoScalingConfig{KeyPair: tt.keyPair}},
+			}
+			if got := i.spotKeyName(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("spotKeyName() = %v, want %v", aws.StringValue(got), aws.StringValue(tt.want))
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package autospotting_test
+
+import (
+	"io/ioutil"
+	"testing"
+	"time"
+
+	autospotting "github.com/AutoSpotting/AutoSpotting/core"
+	"github.com/AutoSpotting/AutoSpotting/core/autospottingtest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	ec2instancesinfo "github.com/cristim/ec2-instances-info"
+)
+
+const testRegion = "us-east-1"
+
+func newTestConfig(cloud *autospottingtest.Cloud) *autospotting.Config {
+	pricing := func(price float64) map[string]ec2instancesinfo.RegionPrices {
+		return map[string]ec2instancesinfo.RegionPrices{
+			testRegion: {Linux: ec2instancesinfo.LinuxPricing{OnDemand: price}},
+		}
+	}
+
+	return &autospotting.Config{
+		LogFile:        ioutil.Discard,
+		MainRegion:     testRegion,
+		Regions:        testRegion,
+		ClientProvider: cloud,
+		InstanceData: &ec2instancesinfo.InstanceData{
+			0: {InstanceType: "m5.large", VCPU: 2, Memory: 8, PhysicalProcessor: "Intel", Pricing: pricing(0.096)},
+			1: {InstanceType: "m5.xlarge", VCPU: 4, Memory: 16, PhysicalProcessor: "Intel", Pricing: pricing(0.192)},
+		},
+		AutoScalingConfig: autospotting.AutoScalingConfig{
+			OnDemandPriceMultiplier: 1.0,
+			BiddingPolicy:           autospotting.DefaultBiddingPolicy,
+			SpotProductDescription:  autospotting.DefaultSpotProductDescription,
+			CronSchedule:            autospotting.DefaultSchedule,
+			CronScheduleState:       "on",
+		},
+	}
+}
+
+func seedGroup(r *autospottingtest.Region) {
+	r.AddInstance(&ec2.Instance{
+		InstanceId:         aws.String("i-ondemand"),
+		InstanceType:       aws.String("m5.large"),
+		ImageId:            aws.String("ami-123"),
+		LaunchTime:         aws.Time(time.Now().Add(-1 * time.Hour)),
+		Placement:          &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+		SubnetId:           aws.String("subnet-1"),
+		VirtualizationType: aws.String("hvm"),
+	})
+	r.AddLaunchConfiguration(&autoscaling.LaunchConfiguration{
+		LaunchConfigurationName: aws.String("lc"),
+	})
+	r.AddGroup(&autoscaling.Group{
+		AutoScalingGroupName:    aws.String("asg"),
+		LaunchConfigurationName: aws.String("lc"),
+		MinSize:                 aws.Int64(1),
+		MaxSize:                 aws.Int64(2),
+		DesiredCapacity:         aws.Int64(1),
+		HealthCheckGracePeriod:  aws.Int64(60),
+		Instances: []*autoscaling.Instance{{
+			InstanceId:           aws.String("i-ondemand"),
+			AvailabilityZone:     aws.String("us-east-1a"),
+			ProtectedFromScaleIn: aws.Bool(false),
+		}},
+		Tags: []*autoscaling.TagDescription{{
+			Key:   aws.String("spot-enabled"),
+			Value: aws.String("true"),
+		}},
+	})
+	r.SetSpotPrice("m5.large", "us-east-1a", 0.04)
+	r.SetSpotPrice("m5.xlarge", "us-east-1a", 0.03)
+}
+
+func TestRunReplacesOnDemandInstance(t *testing.T) {
+	cloud := autospottingtest.NewCloud()
+	r := cloud.Region(testRegion)
+	seedGroup(r)
+
+	// the first run launches a spot instance for the group
+	autospotting.Run(newTestConfig(cloud))
+
+	var spot *ec2.Instance
+	for _, inst := range r.Instances() {
+		if aws.StringValue(inst.InstanceLifecycle) == "spot" {
+			spot = inst
+		}
+	}
+	if spot == nil {
+		t.Fatalf("no spot instance was launched, calls: %v", cloud.Calls())
+	}
+	if *spot.InstanceType != "m5.xlarge" {
+		t.Errorf("launched %s, expected the cheapest type m5.xlarge", *spot.InstanceType)
+	}
+
+	// once out of the grace period, the next run swaps it with the on-demand
+	// instance
+	spot.LaunchTime = aws.Time(time.Now().Add(-5 * time.Minute))
+	autospotting.Run(newTestConfig(cloud))
+
+	g := r.Group("asg")
+	if len(g.Instances) != 1 || *g.Instances[0].InstanceId != *spot.InstanceId {
+		t.Errorf("expected the group to only contain %s, got %v", *spot.InstanceId, g.Instances)
+	}
+	if *g.DesiredCapacity != 1 {
+		t.Errorf("desired capacity = %d, want 1", *g.DesiredCapacity)
+	}
+	if *r.Instance("i-ondemand").State.Name != ec2.InstanceStateNameTerminated {
+		t.Errorf("the on-demand instance wasn't terminated")
+	}
+}
@@ -0,0 +1,176 @@
+package autospotting
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+const (
+	// DefaultInterruptionWindow is the default period in which the spot
+	// interruptions of a group are counted
+	DefaultInterruptionWindow = time.Hour
+
+	// DefaultInterruptionCooldown is the default period during which the
+	// on-demand capacity of a group is kept after too many interruptions
+	DefaultInterruptionCooldown = 6 * time.Hour
+
+	// maxTagValueLength is the maximum length of the value of an AutoScaling
+	// group tag
+	maxTagValueLength = 256
+)
+
+// recentInterruptions parses the times of the interruptions recorded in the
+// InterruptionsTag, given as space separated Unix timestamps, keeping those
+// after the given moment.
+func recentInterruptions(value string, after time.Time) []time.Time {
+	var times []time.Time
+	for _, field := range strings.Fields(value) {
+		sec, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			continue
+		}
+		if t := time.Unix(sec, 0); t.After(after) {
+			times = append(times, t)
+		}
+	}
+	return times
+}
+
+// formatInterruptions formats the times of the interruptions for the
+// InterruptionsTag, dropping the oldest ones which don't fit in a tag value.
+func formatInterruptions(times []time.Time) string {
+	var fields []string
+	for _, t := range times {
+		fields = append(fields, strconv.FormatInt(t.Unix(), 10))
+	}
+	value := strings.Join(fields, " ")
+	for len(value) > maxTagValueLength {
+		fields = fields[1:]
+		value = strings.Join(fields, " ")
+	}
+	return value
+}
+
+// RecordInterruption counts the spot interruption of the instance against its
+// group, and when the group got more than InterruptionThreshold interruptions
+// within the InterruptionWindow, starts a cooldown during which its on-demand
+// capacity isn't replaced with spot instances. The interruptions are stored in
+// a tag of the group, or in the InterruptionTable when it's configured, and
+// the end of the cooldown in another tag of the group, so that they're kept
+// across the Lambda invocations.
+func (s *SpotTermination) RecordInterruption(cfg *Config, instanceID *string) error {
+	if cfg == nil || cfg.InterruptionThreshold <= 0 {
+		return nil
+	}
+
+	asgName, err := s.getAsgName(instanceID)
+	if err != nil {
+		logger.Printf("Failed get ASG name for %s with err: %s\n", *instanceID, err.Error())
+		return err
+	}
+
+	now := time.Now()
+	window := cfg.InterruptionWindow
+	if window <= 0 {
+		window = DefaultInterruptionWindow
+	}
+
+	store := s.interruptions
+	if store == nil {
+		store = s.newInterruptionStore(cfg)
+	}
+	count, err := store.record(asgName, *instanceID, now, now.Add(-window))
+	if err != nil {
+		return err
+	}
+
+	if count <= cfg.InterruptionThreshold {
+		return nil
+	}
+
+	cooldown := cfg.InterruptionCooldown
+	if cooldown <= 0 {
+		cooldown = DefaultInterruptionCooldown
+	}
+	until := now.Add(cooldown)
+
+	_, err = s.asSvc.CreateOrUpdateTags(&autoscaling.CreateOrUpdateTagsInput{
+		Tags: []*autoscaling.Tag{{
+			ResourceId:        aws.String(asgName),
+			ResourceType:      aws.String("auto-scaling-group"),
+			Key:               aws.String(InterruptionCooldownUntilTag),
+			Value:             aws.String(until.UTC().Format(time.RFC3339)),
+			PropagateAtLaunch: aws.Bool(false),
+		}},
+	})
+	if err != nil {
+		logger.Println(s.region, asgName, "Failed to tag group", err.Error())
+		return err
+	}
+
+	logger.Println(s.region, asgName, "Got", count, "spot interruptions within", window,
+		"keeping its on-demand capacity until", until.UTC().Format(time.RFC3339))
+
+	if cfg.notificationRoutes == nil {
+		cfg.notificationRoutes = loadNotificationRoutes(cfg)
+	}
+	notify(cfg, WarningEvent, "AutoSpotting paused spot replacements in "+asgName,
+		fmt.Sprintf("The group %s in %s got %d spot interruptions within %s, its on-demand "+
+			"capacity is kept until %s", asgName, s.region, count, window,
+			until.UTC().Format(time.RFC3339)))
+	return nil
+}
+
+// escalateOnDemand raises the minimum on-demand capacity of the group while
+// it's in the cooldown started after too many spot interruptions, at least to
+// the currently running on-demand instances so that none of them is replaced,
+// or to the InterruptionMinOnDemandPercentage of the group when higher. The
+// cooldown tag is removed once it expired, restoring the usual minimum.
+func (a *autoScalingGroup) escalateOnDemand(now time.Time) {
+	value := a.getTagValue(InterruptionCooldownUntilTag)
+	if value == nil {
+		return
+	}
+
+	until, err := time.Parse(time.RFC3339, strings.TrimSpace(*value))
+	if err != nil {
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s, expected "+
+			"a RFC3339 timestamp such as 2019-07-01T00:00:00Z\n",
+			InterruptionCooldownUntilTag, *value, a.name)
+		return
+	}
+
+	if !now.Before(until) {
+		logger.Println(a.region.name, a.name, "Interruption cooldown ended, restoring the minimum on-demand capacity")
+		_, err := a.region.services.autoScaling.DeleteTags(&autoscaling.DeleteTagsInput{
+			Tags: []*autoscaling.Tag{{
+				ResourceId:   aws.String(a.name),
+				ResourceType: aws.String("auto-scaling-group"),
+				Key:          aws.String(InterruptionCooldownUntilTag),
+			}},
+		})
+		if err != nil {
+			logger.Println(a.region.name, a.name, "Failed to delete the tag", InterruptionCooldownUntilTag, err.Error())
+		}
+		return
+	}
+
+	minOnDemand, _ := a.alreadyRunningInstanceCount(false, "")
+	if percentage := a.region.conf.InterruptionMinOnDemandPercentage; percentage > 0 {
+		if n := int64(math.Ceil(float64(a.instances.count()) * percentage / 100.0)); n > minOnDemand {
+			minOnDemand = n
+		}
+	}
+	if minOnDemand > a.minOnDemand {
+		a.minOnDemand = minOnDemand
+	}
+
+	logger.Println(a.region.name, a.name, "Keeping", a.minOnDemand,
+		"on-demand instances after too many spot interruptions, until", until.UTC().Format(time.RFC3339))
+}
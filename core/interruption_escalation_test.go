@@ -0,0 +1,249 @@
+package autospotting
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestRecentInterruptions(t *testing.T) {
+	now := time.Unix(1560000000, 0)
+	value := fmt.Sprintf("%d invalid %d %d", now.Add(-2*time.Hour).Unix(),
+		now.Add(-30*time.Minute).Unix(), now.Add(-time.Minute).Unix())
+
+	got := recentInterruptions(value, now.Add(-time.Hour))
+	if len(got) != 2 || !got[0].Equal(now.Add(-30*time.Minute)) {
+		t.Errorf("recentInterruptions() = %v, want the last two interruptions", got)
+	}
+
+	var times []time.Time
+	for n := 0; n < 30; n++ {
+		times = append(times, now.Add(time.Duration(n)*time.Second))
+	}
+	formatted := formatInterruptions(times)
+	if len(formatted) > maxTagValueLength || !strings.HasSuffix(formatted, fmt.Sprint(now.Unix()+29)) {
+		t.Errorf("formatInterruptions() = %q, want the most recent ones fitting in a tag", formatted)
+	}
+}
+
+func TestRecordInterruption(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name         string
+		cfg          *Config
+		recorded     string
+		wantRecorded int
+		wantCooldown bool
+	}{
+		{
+			name: "disabled",
+			cfg:  &Config{},
+		},
+		{
+			name:         "first interruption",
+			cfg:          &Config{InterruptionThreshold: 2},
+			wantRecorded: 1,
+		},
+		{
+			name: "old interruptions expired",
+			cfg:  &Config{InterruptionThreshold: 2},
+			recorded: fmt.Sprintf("%d %d", now.Add(-3*time.Hour).Unix(),
+				now.Add(-2*time.Hour).Unix()),
+			wantRecorded: 1,
+		},
+		{
+			name: "threshold exceeded",
+			cfg:  &Config{InterruptionThreshold: 2, InterruptionWindow: time.Hour},
+			recorded: fmt.Sprintf("%d %d", now.Add(-40*time.Minute).Unix(),
+				now.Add(-10*time.Minute).Unix()),
+			wantRecorded: 3,
+			wantCooldown: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags := map[string]string{}
+			group := &autoscaling.Group{AutoScalingGroupName: aws.String("mygroup")}
+			if tt.recorded != "" {
+				group.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(InterruptionsTag), Value: aws.String(tt.recorded)},
+				}
+			}
+			s := &SpotTermination{
+				region: "us-east-1",
+				asSvc: mockASG{
+					dasio: &autoscaling.DescribeAutoScalingInstancesOutput{
+						AutoScalingInstances: []*autoscaling.InstanceDetails{
+							{AutoScalingGroupName: aws.String("mygroup")},
+						},
+					},
+					dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{
+						AutoScalingGroups: []*autoscaling.Group{group},
+					},
+					tags: tags,
+				},
+			}
+
+			if err := s.RecordInterruption(tt.cfg, aws.String("i-spot")); err != nil {
+				t.Fatalf("RecordInterruption() unexpected error: %s", err.Error())
+			}
+
+			if got := len(strings.Fields(tags[InterruptionsTag])); got != tt.wantRecorded {
+				t.Errorf("RecordInterruption() recorded %d interruptions, want %d", got, tt.wantRecorded)
+			}
+			until, ok := tags[InterruptionCooldownUntilTag]
+			if ok != tt.wantCooldown {
+				t.Fatalf("RecordInterruption() set the cooldown %q, want %t", until, tt.wantCooldown)
+			}
+			if ok {
+				if end, err := time.Parse(time.RFC3339, until); err != nil ||
+					end.Before(now.Add(DefaultInterruptionCooldown-time.Minute)) {
+					t.Errorf("RecordInterruption() set the cooldown until %q, want in %s", until, DefaultInterruptionCooldown)
+				}
+			}
+		})
+	}
+}
+
+func TestRecordInterruptionInDynamoDB(t *testing.T) {
+	tests := []struct {
+		name         string
+		count        int64
+		wantCooldown bool
+	}{
+		{name: "below the threshold", count: 2},
+		{name: "threshold exceeded", count: 3, wantCooldown: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags := map[string]string{}
+			svc := &mockDynamoDB{qo: &dynamodb.QueryOutput{Count: aws.Int64(tt.count)}}
+			s := &SpotTermination{
+				region: "us-east-1",
+				asSvc: mockASG{
+					dasio: &autoscaling.DescribeAutoScalingInstancesOutput{
+						AutoScalingInstances: []*autoscaling.InstanceDetails{
+							{AutoScalingGroupName: aws.String("mygroup")},
+						},
+					},
+					tags: tags,
+				},
+				interruptions: &dynamoDBInterruptionStore{svc: svc, table: "interruptions", region: "us-east-1"},
+			}
+
+			cfg := &Config{InterruptionThreshold: 2, InterruptionTable: "interruptions"}
+			if err := s.RecordInterruption(cfg, aws.String("i-spot")); err != nil {
+				t.Fatalf("RecordInterruption() unexpected error: %s", err.Error())
+			}
+
+			if len(svc.pi) != 1 || aws.StringValue(svc.pi[0].Item["group"].S) != "us-east-1/mygroup" ||
+				!strings.HasSuffix(aws.StringValue(svc.pi[0].Item["time"].S), " i-spot") {
+				t.Errorf("RecordInterruption() wrote %v, want the interruption of the group", svc.pi)
+			}
+			if len(svc.q) != 1 || aws.StringValue(svc.q[0].ExpressionAttributeValues[":g"].S) != "us-east-1/mygroup" {
+				t.Errorf("RecordInterruption() queried %v, want the interruptions of the group", svc.q)
+			}
+			if _, ok := tags[InterruptionsTag]; ok {
+				t.Errorf("RecordInterruption() tagged the group with the interruptions")
+			}
+			if _, ok := tags[InterruptionCooldownUntilTag]; ok != tt.wantCooldown {
+				t.Errorf("RecordInterruption() set the cooldown: %t, want %t", ok, tt.wantCooldown)
+			}
+		})
+	}
+}
+
+func TestInterruptionTimeFormat(t *testing.T) {
+	whole := time.Date(2019, time.May, 9, 10, 0, 0, 0, time.UTC).Format(interruptionTimeFormat)
+	fraction := time.Date(2019, time.May, 9, 10, 0, 0, 500, time.UTC).Format(interruptionTimeFormat)
+	if !(whole < fraction) {
+		t.Errorf("%q sorts after %q", whole, fraction)
+	}
+}
+
+func TestEscalateOnDemand(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		cooldown    string
+		percentage  float64
+		minOnDemand int64
+		want        int64
+		wantDeleted bool
+	}{
+		{
+			name:        "no cooldown",
+			minOnDemand: 1,
+			want:        1,
+		},
+		{
+			name:        "invalid cooldown",
+			cooldown:    "tomorrow",
+			minOnDemand: 1,
+			want:        1,
+		},
+		{
+			name:     "cooldown keeps the running on-demand instances",
+			cooldown: now.Add(time.Hour).UTC().Format(time.RFC3339),
+			want:     2,
+		},
+		{
+			name:       "cooldown brings back a percentage to on-demand",
+			cooldown:   now.Add(time.Hour).UTC().Format(time.RFC3339),
+			percentage: 100,
+			want:       3,
+		},
+		{
+			name:        "higher minimum kept",
+			cooldown:    now.Add(time.Hour).UTC().Format(time.RFC3339),
+			minOnDemand: 3,
+			want:        3,
+		},
+		{
+			name:        "expired cooldown",
+			cooldown:    now.Add(-time.Minute).UTC().Format(time.RFC3339),
+			want:        0,
+			wantDeleted: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newReplacementBatchGroup(2, mockEC2{})
+			a.instances.add(&instance{
+				Instance: &ec2.Instance{
+					InstanceId:        aws.String("i-spot"),
+					InstanceLifecycle: aws.String("spot"),
+					Placement:         &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+					State:             &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+				},
+			})
+			tags := map[string]string{}
+			if tt.cooldown != "" {
+				tags[InterruptionCooldownUntilTag] = tt.cooldown
+				a.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(InterruptionCooldownUntilTag), Value: aws.String(tt.cooldown)},
+				}
+			}
+			a.region.services.autoScaling = mockASG{tags: tags}
+			a.region.conf.InterruptionMinOnDemandPercentage = tt.percentage
+			a.minOnDemand = tt.minOnDemand
+
+			a.escalateOnDemand(now)
+
+			if a.minOnDemand != tt.want {
+				t.Errorf("escalateOnDemand() set the minimum on-demand to %d, want %d", a.minOnDemand, tt.want)
+			}
+			if _, ok := tags[InterruptionCooldownUntilTag]; ok == tt.wantDeleted && tt.cooldown != "" {
+				t.Errorf("escalateOnDemand() kept the cooldown tag: %t, want %t", ok, !tt.wantDeleted)
+			}
+		})
+	}
+}
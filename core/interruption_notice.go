@@ -0,0 +1,209 @@
+package autospotting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+)
+
+// spotInterruptionWarning is how long before the interruption of a spot
+// instance its interruption warning is sent.
+const spotInterruptionWarning = 2 * time.Minute
+
+// ForwardedNotice is forwarded to the application endpoint of a group when
+// one of its spot instances is about to be interrupted, so that the workload
+// can checkpoint its state in time, for example:
+// {"notice":"spot-interruption","instance-id":"i-0123456789abcdef0",
+// "private-ip":"10.0.0.1","asg":"my-group","deadline":"2020-11-20T08:22:00Z"}
+type ForwardedNotice struct {
+	Notice           string `json:"notice"`
+	InstanceID       string `json:"instance-id"`
+	PrivateIP        string `json:"private-ip,omitempty"`
+	AutoScalingGroup string `json:"asg"`
+
+	// When the instance is interrupted, not set for rebalance
+	// recommendations
+	Deadline string `json:"deadline,omitempty"`
+}
+
+// noticeEndpoint returns the endpoint interruption notices are forwarded to
+// for the given group, either set on the group using the
+// InterruptionNoticeEndpointTag or globally.
+func (s *SpotTermination) noticeEndpoint(asgName string, cfg *Config) string {
+	endpoint := cfg.InterruptionNoticeEndpoint
+
+	err := s.asSvc.DescribeAutoScalingGroupsPages(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(asgName)},
+	}, func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+		for _, g := range page.AutoScalingGroups {
+			for _, tag := range g.Tags {
+				if aws.StringValue(tag.Key) == InterruptionNoticeEndpointTag {
+					endpoint = aws.StringValue(tag.Value)
+				}
+			}
+		}
+		return true
+	})
+	if err != nil {
+		logger.Println("Failed to describe group", asgName, err.Error())
+	}
+	return endpoint
+}
+
+// describeInstance returns the given instance, or nil if it can't be
+// described.
+func (s *SpotTermination) describeInstance(instanceID *string) *ec2.Instance {
+	var instance *ec2.Instance
+
+	err := s.ec2Svc.DescribeInstancesPages(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{instanceID},
+	}, func(page *ec2.DescribeInstancesOutput, lastPage bool) bool {
+		for _, r := range page.Reservations {
+			for _, i := range r.Instances {
+				instance = i
+			}
+		}
+		return true
+	})
+	if err != nil {
+		logger.Println("Failed to describe instance", *instanceID, err.Error())
+	}
+	return instance
+}
+
+// privateIP returns the private IP address of the given instance, or an
+// empty string if it can't be determined.
+func (s *SpotTermination) privateIP(instanceID *string) string {
+	if i := s.describeInstance(instanceID); i != nil {
+		return aws.StringValue(i.PrivateIpAddress)
+	}
+	return ""
+}
+
+// ForwardNotice sends the given notice about a spot instance to the
+// application endpoint of its group, if any, before the termination
+// notification action is executed. Interruption notices are received the
+// given time, two minutes before the interruption.
+func (s *SpotTermination) ForwardNotice(cfg *Config, instanceID *string, notice string, received time.Time) error {
+	asgName, err := s.getAsgName(instanceID)
+	if err != nil {
+		logger.Printf("Failed get ASG name for %s with err: %s\n", *instanceID, err.Error())
+		return err
+	}
+
+	endpoint := s.noticeEndpoint(asgName, cfg)
+	if endpoint == "" {
+		return nil
+	}
+
+	n := ForwardedNotice{
+		Notice:           notice,
+		InstanceID:       *instanceID,
+		PrivateIP:        s.privateIP(instanceID),
+		AutoScalingGroup: asgName,
+	}
+	if notice == InterruptionNotice {
+		n.Deadline = received.Add(spotInterruptionWarning).UTC().Format(time.RFC3339)
+	}
+
+	logger.Println(asgName, "Forwarding", notice, "notice of", *instanceID, "to", endpoint)
+	return forwardNotice(endpoint, n)
+}
+
+// NotifyInterruption sends an interruption notification about the given
+// notice received for a spot instance, to the destinations configured for
+// it, such as the NotificationWebhookURL.
+func (s *SpotTermination) NotifyInterruption(cfg *Config, instanceID *string, notice string) {
+	if cfg.notificationRoutes == nil {
+		cfg.notificationRoutes = loadNotificationRoutes(cfg)
+	}
+
+	asgName, err := s.getAsgName(instanceID)
+	if err != nil {
+		logger.Printf("Failed get ASG name for %s with err: %s\n", *instanceID, err.Error())
+		return
+	}
+
+	details := ActionDetails{
+		Region:           s.region,
+		AutoScalingGroup: asgName,
+		OldInstanceID:    *instanceID,
+	}
+	if i := s.describeInstance(instanceID); i != nil {
+		details.OldInstanceType = aws.StringValue(i.InstanceType)
+	}
+
+	notifyAction(cfg, InterruptionEvent,
+		fmt.Sprintf("AutoSpotting handled a %s notice in %s", notice, asgName), details)
+}
+
+// sqsQueueRegion returns the region of the given SQS queue URL, such as
+// https://sqs.us-east-1.amazonaws.com/123456789012/queue, or an empty string
+// if it isn't an SQS queue URL.
+func sqsQueueRegion(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return ""
+	}
+	parts := strings.Split(u.Hostname(), ".")
+	if len(parts) < 4 || parts[0] != "sqs" || !strings.HasSuffix(u.Hostname(), ".amazonaws.com") {
+		return ""
+	}
+	return parts[1]
+}
+
+// forwardNotice sends the notice to an SQS queue or as a POST request to an
+// HTTP endpoint, depending on the endpoint URL.
+func forwardNotice(endpoint string, n ForwardedNotice) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	if region := sqsQueueRegion(endpoint); region != "" {
+		sess := session.Must(session.NewSession(&aws.Config{Region: aws.String(region)}))
+		return sendNoticeToQueue(sqs.New(sess), endpoint, body)
+	}
+
+	// the notice is only useful within the two minutes warning
+	return postNotice(&http.Client{Timeout: 10 * time.Second}, endpoint, body)
+}
+
+func sendNoticeToQueue(svc sqsiface.SQSAPI, queueURL string, body []byte) error {
+	_, err := svc.SendMessage(&sqs.SendMessageInput{
+		QueueUrl:    aws.String(queueURL),
+		MessageBody: aws.String(string(body)),
+	})
+	if err != nil {
+		logger.Println("Failed to send notice to", queueURL, err.Error())
+		return err
+	}
+	return nil
+}
+
+func postNotice(client *http.Client, endpoint string, body []byte) error {
+	resp, err := client.Post(endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logger.Println("Failed to send notice to", endpoint, err.Error())
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		err = fmt.Errorf("unexpected response from %s: %s", endpoint, resp.Status)
+		logger.Println("Failed to send notice:", err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package autospotting
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/AutoSpotting/AutoSpotting/core/autospottingtest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestSQSQueueRegion(t *testing.T) {
+	tests := map[string]string{
+		"https://sqs.eu-west-1.amazonaws.com/123456789012/notices": "eu-west-1",
+		"https://example.com/notices":                              "",
+		"https://sqs.example.com/notices":                          "",
+		"not a url\x7f":                                            "",
+	}
+	for endpoint, want := range tests {
+		if got := sqsQueueRegion(endpoint); got != want {
+			t.Errorf("sqsQueueRegion(%q) = %q, want %q", endpoint, got, want)
+		}
+	}
+}
+
+func TestSendNoticeToQueue(t *testing.T) {
+	svc := &mockSQS{}
+	if err := sendNoticeToQueue(svc, "queue", []byte(`{"notice":"spot-interruption"}`)); err != nil {
+		t.Fatalf("sendNoticeToQueue() error = %v", err)
+	}
+	if len(svc.sm) != 1 || svc.sm[0] != `{"notice":"spot-interruption"}` {
+		t.Errorf("unexpected messages %v", svc.sm)
+	}
+
+	svc = &mockSQS{smerr: errors.New("denied")}
+	if err := sendNoticeToQueue(svc, "queue", []byte("{}")); err == nil {
+		t.Error("expected the SQS error to be returned")
+	}
+}
+
+func TestForwardNotice(t *testing.T) {
+	var received []ForwardedNotice
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var n ForwardedNotice
+		if err := json.Unmarshal(body, &n); err != nil || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s", body)
+		}
+		received = append(received, n)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	cloud := autospottingtest.NewCloud()
+	r := cloud.Region("us-east-1")
+	r.AddInstance(&ec2.Instance{
+		InstanceId:       aws.String("i-1"),
+		PrivateIpAddress: aws.String("10.0.0.1"),
+	})
+	r.AddGroup(&autoscaling.Group{
+		AutoScalingGroupName: aws.String("asg"),
+		Instances:            []*autoscaling.Instance{{InstanceId: aws.String("i-1")}},
+	})
+	st := SpotTermination{asSvc: cloud.AutoScaling("us-east-1"), ec2Svc: cloud.EC2("us-east-1")}
+	receivedAt := time.Date(2020, 11, 20, 8, 20, 0, 0, time.UTC)
+
+	if err := st.ForwardNotice(&Config{}, aws.String("i-1"), InterruptionNotice, receivedAt); err != nil || len(received) != 0 {
+		t.Fatalf("expected no notice without an endpoint, got %v %v", err, received)
+	}
+
+	cfg := &Config{InterruptionNoticeEndpoint: srv.URL}
+	if err := st.ForwardNotice(cfg, aws.String("i-1"), InterruptionNotice, receivedAt); err != nil {
+		t.Fatalf("ForwardNotice() error = %v", err)
+	}
+	want := ForwardedNotice{
+		Notice:           InterruptionNotice,
+		InstanceID:       "i-1",
+		PrivateIP:        "10.0.0.1",
+		AutoScalingGroup: "asg",
+		Deadline:         "2020-11-20T08:22:00Z",
+	}
+	if len(received) != 1 || received[0] != want {
+		t.Errorf("received %v, want %v", received, want)
+	}
+
+	if err := st.ForwardNotice(cfg, aws.String("i-1"), RebalanceNotice, receivedAt); err != nil {
+		t.Fatalf("ForwardNotice() error = %v", err)
+	}
+	if len(received) != 2 || received[1].Deadline != "" {
+		t.Errorf("expected rebalance notices without deadline, got %v", received)
+	}
+
+	r.Group("asg").Tags = []*autoscaling.TagDescription{
+		{Key: aws.String(InterruptionNoticeEndpointTag), Value: aws.String(srv.URL + "/asg")},
+	}
+	status = http.StatusInternalServerError
+	if err := st.ForwardNotice(&Config{}, aws.String("i-1"), InterruptionNotice, receivedAt); err == nil {
+		t.Error("expected the failed response to be reported")
+	}
+	if len(received) != 3 {
+		t.Errorf("expected the group's endpoint to be used, got %v", received)
+	}
+}
+
+func TestSpotTermination_NotifyInterruption(t *testing.T) {
+	cloud := autospottingtest.NewCloud()
+	r := cloud.Region("us-east-1")
+	r.AddInstance(&ec2.Instance{
+		InstanceId:   aws.String("i-1"),
+		InstanceType: aws.String("m5.large"),
+	})
+	r.AddGroup(&autoscaling.Group{
+		AutoScalingGroupName: aws.String("asg"),
+		Instances:            []*autoscaling.Instance{{InstanceId: aws.String("i-1")}},
+	})
+	st := SpotTermination{region: "us-east-1", asSvc: cloud.AutoScaling("us-east-1"), ec2Svc: cloud.EC2("us-east-1")}
+
+	notifier := &recordingNotifier{}
+	cfg := &Config{NotificationRoutes: []NotificationRoute{
+		{Events: []string{InterruptionEvent}, Notifier: notifier},
+	}}
+	st.NotifyInterruption(cfg, aws.String("i-1"), InterruptionNotice)
+
+	if len(notifier.sent) != 1 || notifier.sent[0].Event != InterruptionEvent {
+		t.Fatalf("expected an interruption notification, got %v", notifier.sent)
+	}
+	want := ActionDetails{
+		Region:           "us-east-1",
+		AutoScalingGroup: "asg",
+		OldInstanceID:    "i-1",
+		OldInstanceType:  "m5.large",
+	}
+	if got := *notifier.sent[0].Action; got != want {
+		t.Errorf("NotifyInterruption() sent %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,175 @@
+package autospotting
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// interruptionTimeFormat sorts the interruption times of the DynamoDB items
+// lexicographically, unlike time.RFC3339Nano which trims the trailing zeros
+const interruptionTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+// interruptionStore records the spot interruptions of the groups, kept across
+// the Lambda invocations.
+type interruptionStore interface {
+	// record adds the interruption of the instance of the group, returning the
+	// number of interruptions of the group since the given moment, including
+	// the new one
+	record(asgName, instanceID string, now, since time.Time) (int, error)
+}
+
+// newInterruptionStore returns the DynamoDB store when the InterruptionTable
+// is configured, otherwise the tags of the groups store the interruptions.
+func (s *SpotTermination) newInterruptionStore(cfg *Config) interruptionStore {
+	if cfg.InterruptionTable != "" {
+		return &dynamoDBInterruptionStore{svc: dynamodb.New(newSession(cfg, cfg.MainRegion)), table: cfg.InterruptionTable, region: s.region}
+	}
+	return &tagInterruptionStore{svc: s.asSvc, region: s.region}
+}
+
+// tagInterruptionStore records the times of the interruptions in the
+// InterruptionsTag of the groups. Concurrent interruptions of the same group
+// may overwrite each other's records.
+type tagInterruptionStore struct {
+	svc    autoscalingiface.AutoScalingAPI
+	region string
+}
+
+func (t *tagInterruptionStore) record(asgName, instanceID string, now, since time.Time) (int, error) {
+	group, err := describeAutoScalingGroup(t.svc, t.region, asgName)
+	if err != nil {
+		return 0, err
+	}
+	times, err := recordTimeInTag(t.svc, t.region, group, InterruptionsTag, now, since)
+	return len(times), err
+}
+
+// describeAutoScalingGroup returns the group with the given name, or an error
+// if it doesn't exist.
+func describeAutoScalingGroup(svc autoscalingiface.AutoScalingAPI, region, asgName string) (*autoscaling.Group, error) {
+	var group *autoscaling.Group
+	err := svc.DescribeAutoScalingGroupsPages(&autoscaling.DescribeAutoScalingGroupsInput{
+		AutoScalingGroupNames: []*string{aws.String(asgName)},
+	}, func(page *autoscaling.DescribeAutoScalingGroupsOutput, lastPage bool) bool {
+		if len(page.AutoScalingGroups) > 0 {
+			group = page.AutoScalingGroups[0]
+		}
+		return true
+	})
+	if err != nil {
+		logger.Println(region, asgName, "Failed to describe the group:", err.Error())
+		return nil, err
+	}
+	if group == nil {
+		return nil, fmt.Errorf("group %s not found in %s", asgName, region)
+	}
+	return group, nil
+}
+
+// recordTimeInTag adds the given moment to the times recorded in a tag of the
+// group, given as space separated Unix timestamps, dropping those before the
+// since moment. It returns the recorded times, and also updates the tags of
+// the group in memory so that it can be called again on the same run.
+func recordTimeInTag(svc autoscalingiface.AutoScalingAPI, region string, group *autoscaling.Group,
+	key string, now, since time.Time) ([]time.Time, error) {
+
+	asgName := aws.StringValue(group.AutoScalingGroupName)
+
+	var recorded string
+	if value := getTagValueFromASGWithMatchingTag(group, Tag{Key: key, Value: "*"}); value != nil {
+		recorded = *value
+	}
+	times := append(recentInterruptions(recorded, since), now)
+	value := formatInterruptions(times)
+
+	_, err := svc.CreateOrUpdateTags(&autoscaling.CreateOrUpdateTagsInput{
+		Tags: []*autoscaling.Tag{{
+			ResourceId:        aws.String(asgName),
+			ResourceType:      aws.String("auto-scaling-group"),
+			Key:               aws.String(key),
+			Value:             aws.String(value),
+			PropagateAtLaunch: aws.Bool(false),
+		}},
+	})
+	if err != nil {
+		logger.Println(region, asgName, "Failed to tag group", err.Error())
+		return nil, err
+	}
+
+	for _, tag := range group.Tags {
+		if aws.StringValue(tag.Key) == key {
+			tag.Value = aws.String(value)
+			return times, nil
+		}
+	}
+	group.Tags = append(group.Tags, &autoscaling.TagDescription{
+		ResourceId:        aws.String(asgName),
+		ResourceType:      aws.String("auto-scaling-group"),
+		Key:               aws.String(key),
+		Value:             aws.String(value),
+		PropagateAtLaunch: aws.Bool(false),
+	})
+	return times, nil
+}
+
+// dynamoDBInterruptionStore records each interruption as an item of a
+// DynamoDB table with a "group" string partition key and a "time" string sort
+// key, so that concurrent interruptions are all counted. The items have an
+// "expires" attribute which can be used as the TTL attribute of the table.
+type dynamoDBInterruptionStore struct {
+	svc    dynamodbiface.DynamoDBAPI
+	table  string
+	region string
+}
+
+func (d *dynamoDBInterruptionStore) record(asgName, instanceID string, now, since time.Time) (int, error) {
+	group := lockKey("", d.region, asgName)
+
+	// the interruption isn't counted anymore after the window
+	expires := now.Add(now.Sub(since))
+
+	_, err := d.svc.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"group":    {S: aws.String(group)},
+			"time":     {S: aws.String(now.UTC().Format(interruptionTimeFormat) + " " + instanceID)},
+			"instance": {S: aws.String(instanceID)},
+			"expires":  {N: aws.String(strconv.FormatInt(expires.Unix(), 10))},
+		},
+	})
+	if err != nil {
+		logger.Println(d.region, asgName, "Failed to record the interruption in", d.table, err.Error())
+		return 0, err
+	}
+
+	var count int
+	err = d.svc.QueryPages(&dynamodb.QueryInput{
+		TableName:              aws.String(d.table),
+		KeyConditionExpression: aws.String("#g = :g AND #t > :since"),
+		ExpressionAttributeNames: map[string]*string{
+			"#g": aws.String("group"),
+			"#t": aws.String("time"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":g":     {S: aws.String(group)},
+			":since": {S: aws.String(since.UTC().Format(interruptionTimeFormat))},
+		},
+		Select:         aws.String(dynamodb.SelectCount),
+		ConsistentRead: aws.Bool(true),
+	}, func(page *dynamodb.QueryOutput, lastPage bool) bool {
+		count += int(aws.Int64Value(page.Count))
+		return true
+	})
+	if err != nil {
+		logger.Println(d.region, asgName, "Failed to count the interruptions in", d.table, err.Error())
+		return 0, err
+	}
+	return count, nil
+}
@@ -0,0 +1,347 @@
+package autospotting
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/eks"
+	"github.com/aws/aws-sdk-go/service/eks/eksiface"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+const (
+	// DefaultKubernetesDrainTimeout is the default time given to the pods of
+	// a Kubernetes node to be evicted before the instance is terminated
+	// anyway, short enough for spot interruptions.
+	DefaultKubernetesDrainTimeout = 90 * time.Second
+
+	// eksClusterNameTag is set by EKS on the instances of its managed node
+	// groups.
+	eksClusterNameTag = "eks:cluster-name"
+
+	// kubernetesClusterTagPrefix is followed by the cluster name in the key
+	// of a tag set to owned or shared on the nodes of most Kubernetes
+	// clusters, as required by the cloud provider integration.
+	kubernetesClusterTagPrefix = "kubernetes.io/cluster/"
+
+	// kubernetesDrainPollInterval is how often the pods left on a node being
+	// drained are checked.
+	kubernetesDrainPollInterval = 5 * time.Second
+)
+
+// kubernetesCluster returns the name of the EKS cluster the instance is a node
+// of, given by its tags or otherwise by the KubernetesCluster configuration,
+// or an empty string if it's not drained as a Kubernetes node.
+func kubernetesCluster(cfg *Config, tags []*ec2.Tag) string {
+	if cfg == nil || !cfg.DrainKubernetesNodes {
+		return ""
+	}
+	for _, t := range tags {
+		if aws.StringValue(t.Key) == eksClusterNameTag {
+			return aws.StringValue(t.Value)
+		}
+	}
+	for _, t := range tags {
+		key, value := aws.StringValue(t.Key), aws.StringValue(t.Value)
+		if strings.HasPrefix(key, kubernetesClusterTagPrefix) && (value == "owned" || value == "shared") {
+			return strings.TrimPrefix(key, kubernetesClusterTagPrefix)
+		}
+	}
+	return cfg.KubernetesCluster
+}
+
+// drainKubernetesNode cordons the Kubernetes node running on the instance and
+// evicts its pods, respecting their PodDisruptionBudgets, before the instance
+// is terminated. The instance is terminated anyway when the pods couldn't be
+// evicted within the KubernetesDrainTimeout, so the errors are only logged.
+func drainKubernetesNode(sess *session.Session, cfg *Config, i *ec2.Instance) {
+	cluster := kubernetesCluster(cfg, i.Tags)
+	if cluster == "" {
+		return
+	}
+	instanceID := aws.StringValue(i.InstanceId)
+	if cfg.AuditMode {
+		logger.Println("Audit mode, would drain", instanceID, "from the Kubernetes cluster", cluster)
+		return
+	}
+	if sess == nil {
+		logger.Println("Not draining", instanceID, "from the Kubernetes cluster", cluster,
+			"without an AWS session")
+		return
+	}
+
+	k, err := newEKSClient(eks.New(sess), sts.New(sess), cluster)
+	if err != nil {
+		logger.Println("Couldn't connect to the Kubernetes cluster", cluster, "for draining", instanceID+":",
+			err.Error())
+		return
+	}
+
+	timeout := cfg.KubernetesDrainTimeout
+	if timeout <= 0 {
+		timeout = DefaultKubernetesDrainTimeout
+	}
+	if err := k.drainInstance(instanceID, timeout); err != nil {
+		logger.Println("Couldn't drain", instanceID, "from the Kubernetes cluster", cluster+":", err.Error())
+	}
+}
+
+// kubernetesClient is a minimal client of the Kubernetes API, only
+// implementing the calls needed for draining nodes.
+type kubernetesClient struct {
+	endpoint string
+	token    string
+	client   *http.Client
+	poll     time.Duration
+}
+
+// newEKSClient connects to the API of the EKS cluster using the IAM identity
+// of the session, which needs to be mapped to a Kubernetes user allowed to
+// drain the nodes.
+func newEKSClient(eksSvc eksiface.EKSAPI, stsSvc *sts.STS, cluster string) (*kubernetesClient, error) {
+	out, err := eksSvc.DescribeCluster(&eks.DescribeClusterInput{Name: aws.String(cluster)})
+	if err != nil {
+		return nil, err
+	}
+
+	if out.Cluster == nil || out.Cluster.CertificateAuthority == nil {
+		return nil, errors.New("missing certificate authority of the cluster")
+	}
+	ca, err := base64.StdEncoding.DecodeString(aws.StringValue(out.Cluster.CertificateAuthority.Data))
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(ca) {
+		return nil, errors.New("invalid certificate authority of the cluster")
+	}
+
+	token, err := eksToken(stsSvc, cluster)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kubernetesClient{
+		endpoint: aws.StringValue(out.Cluster.Endpoint),
+		token:    token,
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+		},
+		poll: kubernetesDrainPollInterval,
+	}, nil
+}
+
+// eksToken returns a bearer token of the EKS cluster, which is a presigned
+// STS GetCallerIdentity request identifying the cluster.
+func eksToken(svc *sts.STS, cluster string) (string, error) {
+	req, _ := svc.GetCallerIdentityRequest(&sts.GetCallerIdentityInput{})
+	req.HTTPRequest.Header.Add("x-k8s-aws-id", cluster)
+	presigned, err := req.Presign(time.Minute)
+	if err != nil {
+		return "", err
+	}
+	return "k8s-aws-v1." + base64.RawURLEncoding.EncodeToString([]byte(presigned)), nil
+}
+
+// call sends a request to the Kubernetes API, decoding the response into out
+// when given, and returns the HTTP status code.
+func (k *kubernetesClient) call(method, path, contentType string, body, out interface{}) (int, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return 0, err
+		}
+	}
+
+	req, err := http.NewRequest(method, k.endpoint+path, bytes.NewReader(payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+k.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("%s %s returned %s: %s", method, path, resp.Status,
+			strings.TrimSpace(string(data)))
+	}
+	if out != nil {
+		return resp.StatusCode, json.Unmarshal(data, out)
+	}
+	return resp.StatusCode, nil
+}
+
+type kubernetesMetadata struct {
+	Name              string            `json:"name"`
+	Namespace         string            `json:"namespace,omitempty"`
+	Annotations       map[string]string `json:"annotations,omitempty"`
+	DeletionTimestamp *string           `json:"deletionTimestamp,omitempty"`
+	OwnerReferences   []struct {
+		Kind string `json:"kind"`
+	} `json:"ownerReferences,omitempty"`
+}
+
+type kubernetesNode struct {
+	Metadata kubernetesMetadata `json:"metadata"`
+	Spec     struct {
+		ProviderID string `json:"providerID"`
+	} `json:"spec"`
+}
+
+type kubernetesPod struct {
+	Metadata kubernetesMetadata `json:"metadata"`
+	Status   struct {
+		Phase string `json:"phase"`
+	} `json:"status"`
+}
+
+// nodeName returns the name of the node running on the instance, whose
+// provider ID is aws:///<availability zone>/<instance ID>, or an empty
+// string if the instance isn't a node of the cluster.
+func (k *kubernetesClient) nodeName(instanceID string) (string, error) {
+	var nodes struct {
+		Items []kubernetesNode `json:"items"`
+	}
+	if _, err := k.call(http.MethodGet, "/api/v1/nodes", "", nil, &nodes); err != nil {
+		return "", err
+	}
+	for _, n := range nodes.Items {
+		if strings.HasSuffix(n.Spec.ProviderID, "/"+instanceID) {
+			return n.Metadata.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// cordon marks the node as unschedulable.
+func (k *kubernetesClient) cordon(node string) error {
+	patch := map[string]interface{}{"spec": map[string]interface{}{"unschedulable": true}}
+	_, err := k.call(http.MethodPatch, "/api/v1/nodes/"+url.PathEscape(node),
+		"application/strategic-merge-patch+json", patch, nil)
+	return err
+}
+
+// evictablePods returns the pods of the node which are evicted when draining
+// it, skipping the pods of the DaemonSets, which are recreated on the node
+// anyway, the static pods and the completed pods.
+func (k *kubernetesClient) evictablePods(node string) ([]kubernetesPod, error) {
+	var pods struct {
+		Items []kubernetesPod `json:"items"`
+	}
+	path := "/api/v1/pods?fieldSelector=" + url.QueryEscape("spec.nodeName="+node)
+	if _, err := k.call(http.MethodGet, path, "", nil, &pods); err != nil {
+		return nil, err
+	}
+
+	var result []kubernetesPod
+	for _, p := range pods.Items {
+		if p.Status.Phase == "Succeeded" || p.Status.Phase == "Failed" {
+			continue
+		}
+		if _, mirror := p.Metadata.Annotations["kubernetes.io/config.mirror"]; mirror {
+			continue
+		}
+		daemon := false
+		for _, o := range p.Metadata.OwnerReferences {
+			daemon = daemon || o.Kind == "DaemonSet"
+		}
+		if !daemon {
+			result = append(result, p)
+		}
+	}
+	return result, nil
+}
+
+// evict requests the eviction of the pod, returning false when it's
+// currently not allowed by its PodDisruptionBudget.
+func (k *kubernetesClient) evict(p kubernetesPod) (bool, error) {
+	eviction := map[string]interface{}{
+		"apiVersion": "policy/v1",
+		"kind":       "Eviction",
+		"metadata":   map[string]string{"name": p.Metadata.Name, "namespace": p.Metadata.Namespace},
+	}
+	status, err := k.call(http.MethodPost, fmt.Sprintf("/api/v1/namespaces/%s/pods/%s/eviction",
+		url.PathEscape(p.Metadata.Namespace), url.PathEscape(p.Metadata.Name)), "application/json", eviction, nil)
+	switch status {
+	case http.StatusTooManyRequests:
+		return false, nil
+	case http.StatusNotFound:
+		return true, nil
+	}
+	return err == nil, err
+}
+
+// drainInstance cordons the node running on the instance and evicts its pods,
+// retrying the evictions blocked by PodDisruptionBudgets until all the pods
+// are gone or the timeout expires.
+func (k *kubernetesClient) drainInstance(instanceID string, timeout time.Duration) error {
+	node, err := k.nodeName(instanceID)
+	if err != nil {
+		return err
+	}
+	if node == "" {
+		logger.Println("Not draining", instanceID, "which isn't a node of the Kubernetes cluster")
+		return nil
+	}
+
+	logger.Println("Draining the Kubernetes node", node, "of", instanceID)
+	if err := k.cordon(node); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		pods, err := k.evictablePods(node)
+		if err != nil {
+			return err
+		}
+		if len(pods) == 0 {
+			logger.Println("Drained the Kubernetes node", node)
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%d pods still running on the node %s after %s", len(pods), node, timeout)
+		}
+
+		for _, p := range pods {
+			if p.Metadata.DeletionTimestamp != nil {
+				continue
+			}
+			evicted, err := k.evict(p)
+			if err != nil {
+				logger.Println("Couldn't evict the pod", p.Metadata.Namespace+"/"+p.Metadata.Name+":", err.Error())
+			} else if !evicted {
+				debug.Println("The eviction of the pod", p.Metadata.Namespace+"/"+p.Metadata.Name,
+					"is blocked by its PodDisruptionBudget, retrying")
+			}
+		}
+		time.Sleep(k.poll)
+	}
+}
@@ -0,0 +1,242 @@
+package autospotting
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+func TestKubernetesCluster(t *testing.T) {
+	tags := func(kv ...string) []*ec2.Tag {
+		var result []*ec2.Tag
+		for n := 0; n < len(kv); n += 2 {
+			result = append(result, &ec2.Tag{Key: aws.String(kv[n]), Value: aws.String(kv[n+1])})
+		}
+		return result
+	}
+
+	tests := []struct {
+		name string
+		cfg  *Config
+		tags []*ec2.Tag
+		want string
+	}{
+		{
+			name: "draining disabled",
+			cfg:  &Config{KubernetesCluster: "prod"},
+			tags: tags(eksClusterNameTag, "prod"),
+		},
+		{
+			name: "EKS managed node",
+			cfg:  &Config{DrainKubernetesNodes: true},
+			tags: tags("Name", "node", eksClusterNameTag, "prod"),
+			want: "prod",
+		},
+		{
+			name: "cluster tag",
+			cfg:  &Config{DrainKubernetesNodes: true},
+			tags: tags("kubernetes.io/cluster/staging", "owned"),
+			want: "staging",
+		},
+		{
+			name: "cluster tag of another resource kind",
+			cfg:  &Config{DrainKubernetesNodes: true},
+			tags: tags("kubernetes.io/cluster/staging", "other"),
+		},
+		{
+			name: "configured cluster",
+			cfg:  &Config{DrainKubernetesNodes: true, KubernetesCluster: "prod"},
+			tags: tags("Name", "node"),
+			want: "prod",
+		},
+		{
+			name: "not a node",
+			cfg:  &Config{DrainKubernetesNodes: true},
+			tags: tags("Name", "web"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := kubernetesCluster(tt.cfg, tt.tags); got != tt.want {
+				t.Errorf("kubernetesCluster() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEKSToken(t *testing.T) {
+	sess := session.Must(session.NewSession(&aws.Config{
+		Region:      aws.String("us-east-1"),
+		Credentials: credentials.NewStaticCredentials("AKID", "SECRET", ""),
+	}))
+
+	token, err := eksToken(sts.New(sess), "prod")
+	if err != nil {
+		t.Fatalf("eksToken() error = %v", err)
+	}
+	if !strings.HasPrefix(token, "k8s-aws-v1.") {
+		t.Fatalf("eksToken() = %s", token)
+	}
+	presigned, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(token, "k8s-aws-v1."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Action=GetCallerIdentity", "x-k8s-aws-id"} {
+		if !strings.Contains(string(presigned), want) {
+			t.Errorf("eksToken() presigned %s, missing %s", presigned, want)
+		}
+	}
+}
+
+// fakeKubernetes is a Kubernetes API serving a node and its pods, which are
+// deleted when evicted unless their eviction is blocked.
+type fakeKubernetes struct {
+	sync.Mutex
+	pods []kubernetesPod
+	// evictions blocked by the PodDisruptionBudgets, by pod name
+	blocked map[string]int
+
+	cordoned bool
+	evicted  []string
+}
+
+func newFakeKubernetes() *fakeKubernetes {
+	f := &fakeKubernetes{blocked: map[string]int{}}
+	for _, p := range []struct{ name, owner, phase string }{
+		{"web-1", "ReplicaSet", "Running"},
+		{"web-2", "ReplicaSet", "Running"},
+		{"logs", "DaemonSet", "Running"},
+		{"job", "Job", "Succeeded"},
+	} {
+		var pod kubernetesPod
+		pod.Metadata.Name, pod.Metadata.Namespace, pod.Status.Phase = p.name, "default", p.phase
+		pod.Metadata.OwnerReferences = append(pod.Metadata.OwnerReferences, struct {
+			Kind string `json:"kind"`
+		}{p.owner})
+		f.pods = append(f.pods, pod)
+	}
+	return f
+}
+
+func (f *fakeKubernetes) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/nodes":
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": []interface{}{
+			map[string]interface{}{
+				"metadata": map[string]string{"name": "ip-10-0-0-1.ec2.internal"},
+				"spec":     map[string]string{"providerID": "aws:///us-east-1a/i-node"},
+			},
+		}})
+	case r.Method == http.MethodPatch && r.URL.Path == "/api/v1/nodes/ip-10-0-0-1.ec2.internal":
+		f.cordoned = r.Header.Get("Content-Type") == "application/strategic-merge-patch+json"
+		w.Write([]byte("{}"))
+	case r.Method == http.MethodGet && r.URL.Path == "/api/v1/pods":
+		if r.URL.Query().Get("fieldSelector") != "spec.nodeName=ip-10-0-0-1.ec2.internal" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"items": f.pods})
+	case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/eviction"):
+		name := strings.Split(r.URL.Path, "/")[6]
+		if f.blocked[name] > 0 {
+			f.blocked[name]--
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		f.evicted = append(f.evicted, name)
+		for n, p := range f.pods {
+			if p.Metadata.Name == name {
+				f.pods = append(f.pods[:n], f.pods[n+1:]...)
+				break
+			}
+		}
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func TestDrainInstance(t *testing.T) {
+	tests := []struct {
+		name        string
+		instanceID  string
+		blocked     map[string]int
+		wantEvicted []string
+		wantErr     bool
+	}{
+		{
+			name:        "drained",
+			instanceID:  "i-node",
+			wantEvicted: []string{"web-1", "web-2"},
+		},
+		{
+			name:        "eviction blocked by a PodDisruptionBudget",
+			instanceID:  "i-node",
+			blocked:     map[string]int{"web-1": 2},
+			wantEvicted: []string{"web-2", "web-1"},
+		},
+		{
+			name:        "eviction blocked until the timeout",
+			instanceID:  "i-node",
+			blocked:     map[string]int{"web-1": 1000},
+			wantEvicted: []string{"web-2"},
+			wantErr:     true,
+		},
+		{
+			name:       "not a node",
+			instanceID: "i-other",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFakeKubernetes()
+			for name, n := range tt.blocked {
+				f.blocked[name] = n
+			}
+			server := httptest.NewServer(f)
+			defer server.Close()
+
+			k := &kubernetesClient{endpoint: server.URL, token: "token", client: server.Client(), poll: time.Millisecond}
+			err := k.drainInstance(tt.instanceID, 50*time.Millisecond)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("drainInstance() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if f.cordoned != (tt.instanceID == "i-node") {
+				t.Errorf("drainInstance() cordoned the node: %v", f.cordoned)
+			}
+			if !reflect.DeepEqual(f.evicted, tt.wantEvicted) {
+				t.Errorf("drainInstance() evicted %v, want %v", f.evicted, tt.wantEvicted)
+			}
+		})
+	}
+}
+
+func TestDrainInstanceUnauthorized(t *testing.T) {
+	server := httptest.NewServer(newFakeKubernetes())
+	defer server.Close()
+
+	k := &kubernetesClient{endpoint: server.URL, token: "wrong", client: server.Client(), poll: time.Millisecond}
+	if err := k.drainInstance("i-node", time.Second); err == nil {
+		t.Error("drainInstance() expected an error")
+	}
+}
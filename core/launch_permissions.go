@@ -0,0 +1,67 @@
+package autospotting
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+const (
+	// dryRunErrorCode is returned by the EC2 dry runs of the calls which
+	// would have succeeded.
+	dryRunErrorCode = "DryRunOperation"
+
+	// unauthorizedErrorCode is returned by the EC2 calls denied by the IAM
+	// policies, including the service control policies of the organization.
+	unauthorizedErrorCode = "UnauthorizedOperation"
+)
+
+// launchPermissionCache keeps whether the spot instances of the groups are
+// allowed to be launched with each instance type, checked once per run.
+type launchPermissionCache struct {
+	mu      sync.Mutex
+	allowed map[string]bool
+}
+
+// isLaunchAllowed tells if the organization guardrails of the account, such
+// as the service control policies restricting the instance types or the AMIs,
+// allow launching the spot replacement of the instance with the candidate
+// instance type. It's checked by a dry run of the launch, so that the types
+// which would be rejected at launch time aren't proposed. Any other error of
+// the dry run is only logged, leaving the launch to report it.
+func (i *instance) isLaunchAllowed(candidate instanceTypeInformation) bool {
+	if i.region.conf == nil || !i.region.conf.CheckLaunchPermissions {
+		return true
+	}
+
+	c := &i.region.launchPermissions
+	key := i.asg.name + "/" + candidate.instanceType
+	c.mu.Lock()
+	allowed, ok := c.allowed[key]
+	c.mu.Unlock()
+	if ok {
+		return allowed
+	}
+
+	input := i.createRunInstancesInput(candidate.instanceType, i.price)
+	input.DryRun = aws.Bool(true)
+	_, err := i.region.services.ec2.RunInstances(input)
+
+	allowed = true
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == unauthorizedErrorCode {
+		logger.Println(i.asg.name, "Launching", candidate.instanceType, "is denied for the account:", aerr.Message())
+		allowed = false
+	} else if err != nil && (!ok || aerr.Code() != dryRunErrorCode) {
+		logger.Println(i.asg.name, "Couldn't check the permission to launch", candidate.instanceType+":",
+			err.Error())
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.allowed == nil {
+		c.allowed = map[string]bool{}
+	}
+	c.allowed[key] = allowed
+	return allowed
+}
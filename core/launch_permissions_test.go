@@ -0,0 +1,75 @@
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestIsLaunchAllowed(t *testing.T) {
+	denied := map[string]error{
+		"c5.large": awserr.New(unauthorizedErrorCode, "You are not authorized to perform this operation.", nil),
+		"m5.large": errors.New("RequestLimitExceeded"),
+	}
+
+	tests := []struct {
+		name  string
+		check bool
+		rierr map[string]error
+		want  []string
+	}{
+		{
+			name:  "not checked",
+			rierr: denied,
+			want:  []string{"m5.large", "c5.large"},
+		},
+		{
+			name:  "all allowed",
+			check: true,
+			want:  []string{"m5.large", "c5.large"},
+		},
+		{
+			name:  "denied instance type",
+			check: true,
+			rierr: denied,
+			want:  []string{"m5.large"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newReplacementBatchGroup(1, mockEC2{rierr: tt.rierr})
+			a.region.conf.CheckLaunchPermissions = tt.check
+
+			candidates, err := a.instances.get("i-ondemand-0").spotReplacementCandidates()
+			if err != nil {
+				t.Fatalf("spotReplacementCandidates() error = %v", err)
+			}
+			var got []string
+			for _, c := range candidates {
+				got = append(got, c.instanceType)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("spotReplacementCandidates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsLaunchAllowedCached(t *testing.T) {
+	a := newReplacementBatchGroup(1, mockEC2{})
+	a.region.conf.CheckLaunchPermissions = true
+	i := a.instances.get("i-ondemand-0")
+	candidate := a.region.instanceTypeInformation["c5.large"]
+
+	if !i.isLaunchAllowed(candidate) {
+		t.Fatalf("isLaunchAllowed() = false, want true")
+	}
+	a.region.services.ec2 = mockEC2{rierr: map[string]error{
+		"c5.large": awserr.New(unauthorizedErrorCode, "denied", nil),
+	}}
+	if !i.isLaunchAllowed(candidate) {
+		t.Errorf("isLaunchAllowed() checked the launch permission again during the run")
+	}
+}
@@ -0,0 +1,196 @@
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// launchTemplate is the version of the launch template used by a group
+type launchTemplate struct {
+	*ec2.LaunchTemplateVersion
+}
+
+// launchTemplateSpecification returns the launch template of the group, set
+// either directly or in its Mixed Instances Policy.
+func (a *autoScalingGroup) launchTemplateSpecification() *autoscaling.LaunchTemplateSpecification {
+	if a.LaunchTemplate != nil {
+		return a.LaunchTemplate
+	}
+	if a.MixedInstancesPolicy != nil && a.MixedInstancesPolicy.LaunchTemplate != nil {
+		return a.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification
+	}
+	return nil
+}
+
+// loadLaunchTemplate loads the version of the launch template used by the
+// group, resolving the $Latest and $Default versions.
+func (a *autoScalingGroup) loadLaunchTemplate() error {
+	//already done
+	if a.launchTemplate != nil {
+		return nil
+	}
+
+	spec := a.launchTemplateSpecification()
+	if spec == nil {
+		return errors.New("missing launch template")
+	}
+
+	// the groups without an explicit version use the default one
+	version := aws.StringValue(spec.Version)
+	if version == "" {
+		version = "$Default"
+	}
+
+	resp, err := a.region.services.ec2.DescribeLaunchTemplateVersions(&ec2.DescribeLaunchTemplateVersionsInput{
+		LaunchTemplateId:   spec.LaunchTemplateId,
+		LaunchTemplateName: spec.LaunchTemplateName,
+		Versions:           []*string{aws.String(version)},
+	})
+	if err != nil {
+		logger.Println(err.Error())
+		return err
+	}
+
+	if len(resp.LaunchTemplateVersions) == 0 {
+		return fmt.Errorf("missing version %s of the launch template", version)
+	}
+
+	a.launchTemplate = &launchTemplate{
+		LaunchTemplateVersion: resp.LaunchTemplateVersions[0],
+	}
+	return nil
+}
+
+// specification refers to the exact version of the launch template, so the
+// spot instances are launched using the same version as the rest of the group
+// even if the template changes in the meantime.
+func (lt *launchTemplate) specification() *ec2.LaunchTemplateSpecification {
+	return &ec2.LaunchTemplateSpecification{
+		LaunchTemplateId: lt.LaunchTemplateId,
+		Version:          aws.String(strconv.FormatInt(aws.Int64Value(lt.VersionNumber), 10)),
+	}
+}
+
+func (lt *launchTemplate) countLaunchTemplateEphemeralVolumes() int {
+	count := 0
+
+	if lt == nil || lt.LaunchTemplateData == nil {
+		return count
+	}
+
+	for _, mapping := range lt.LaunchTemplateData.BlockDeviceMappings {
+		if mapping.VirtualName != nil &&
+			strings.Contains(*mapping.VirtualName, "ephemeral") {
+			debug.Println("Found ephemeral device mapping", *mapping.VirtualName)
+			count++
+		}
+	}
+
+	logger.Printf("Launch template would attach %d ephemeral volumes if available", count)
+
+	return count
+}
+
+func (i *instance) convertLaunchTemplateBlockDeviceMappings(lt *launchTemplate) []*ec2.BlockDeviceMapping {
+	bds := []*ec2.BlockDeviceMapping{}
+	if lt == nil || lt.LaunchTemplateData == nil || len(lt.LaunchTemplateData.BlockDeviceMappings) == 0 {
+		debug.Println("Missing block device mappings")
+		return bds
+	}
+
+	var stateful map[string]bool
+	if i.asg != nil {
+		stateful = i.asg.statefulDevices()
+	}
+
+	for _, ltBDM := range lt.LaunchTemplateData.BlockDeviceMappings {
+
+		// the stateful volumes are attached from the replaced instance instead,
+		// so they need to be suppressed from the launch template
+		if stateful[aws.StringValue(ltBDM.DeviceName)] {
+			bds = append(bds, &ec2.BlockDeviceMapping{
+				DeviceName: ltBDM.DeviceName,
+				NoDevice:   aws.String(""),
+			})
+			continue
+		}
+
+		ec2BDM := &ec2.BlockDeviceMapping{
+			DeviceName:  ltBDM.DeviceName,
+			NoDevice:    ltBDM.NoDevice,
+			VirtualName: ltBDM.VirtualName,
+		}
+
+		if ltBDM.Ebs != nil {
+			ec2BDM.Ebs = &ec2.EbsBlockDevice{
+				DeleteOnTermination: ltBDM.Ebs.DeleteOnTermination,
+				Encrypted:           ltBDM.Ebs.Encrypted,
+				Iops:                ltBDM.Ebs.Iops,
+				KmsKeyId:            ltBDM.Ebs.KmsKeyId,
+				SnapshotId:          ltBDM.Ebs.SnapshotId,
+				Throughput:          ltBDM.Ebs.Throughput,
+				VolumeSize:          ltBDM.Ebs.VolumeSize,
+				VolumeType:          ltBDM.Ebs.VolumeType,
+			}
+		}
+
+		bds = append(bds, ec2BDM)
+	}
+	return bds
+}
+
+// convertLaunchTemplateNetworkInterfaces returns the network interfaces of the
+// launch template, with the primary one placed in the subnet of the spot
+// instance and using its security groups. The addresses and the existing
+// network interfaces set in the template can't be used by more than one
+// instance, so they're left out.
+func (i *instance) convertLaunchTemplateNetworkInterfaces(lt *launchTemplate, subnetID *string) []*ec2.InstanceNetworkInterfaceSpecification {
+	var nis []*ec2.InstanceNetworkInterfaceSpecification
+
+	for _, ltNI := range lt.LaunchTemplateData.NetworkInterfaces {
+		ni := &ec2.InstanceNetworkInterfaceSpecification{
+			AssociateCarrierIpAddress:      ltNI.AssociateCarrierIpAddress,
+			AssociatePublicIpAddress:       ltNI.AssociatePublicIpAddress,
+			DeleteOnTermination:            ltNI.DeleteOnTermination,
+			Description:                    ltNI.Description,
+			DeviceIndex:                    ltNI.DeviceIndex,
+			Groups:                         ltNI.Groups,
+			InterfaceType:                  ltNI.InterfaceType,
+			Ipv6AddressCount:               ltNI.Ipv6AddressCount,
+			NetworkCardIndex:               ltNI.NetworkCardIndex,
+			SecondaryPrivateIpAddressCount: ltNI.SecondaryPrivateIpAddressCount,
+			SubnetId:                       ltNI.SubnetId,
+		}
+
+		if aws.Int64Value(ltNI.DeviceIndex) == 0 {
+			ni.DeviceIndex = aws.Int64(0)
+			ni.SubnetId = subnetID
+			ni.Groups = i.convertSecurityGroups()
+		}
+
+		nis = append(nis, ni)
+	}
+	return nis
+}
+
+// launchBlockDeviceMappings returns the block device mappings used for
+// launching the spot instance, from the launch template or the launch
+// configuration of its group.
+func (i *instance) launchBlockDeviceMappings() []*ec2.BlockDeviceMapping {
+	if i.asg == nil {
+		return nil
+	}
+	if i.asg.launchTemplate != nil {
+		return i.convertLaunchTemplateBlockDeviceMappings(i.asg.launchTemplate)
+	}
+	if i.asg.launchConfiguration != nil {
+		return i.convertBlockDeviceMappings(i.asg.launchConfiguration)
+	}
+	return nil
+}
@@ -0,0 +1,270 @@
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestLoadLaunchTemplate(t *testing.T) {
+	version := &ec2.LaunchTemplateVersion{
+		LaunchTemplateId: aws.String("lt-1"),
+		VersionNumber:    aws.Int64(7),
+	}
+
+	tests := []struct {
+		name        string
+		group       *autoscaling.Group
+		dltvo       *ec2.DescribeLaunchTemplateVersionsOutput
+		dltverr     error
+		wantVersion string
+		wantErr     bool
+	}{
+		{
+			name: "launch template without version",
+			group: &autoscaling.Group{LaunchTemplate: &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateId: aws.String("lt-1"),
+			}},
+			dltvo:       &ec2.DescribeLaunchTemplateVersionsOutput{LaunchTemplateVersions: []*ec2.LaunchTemplateVersion{version}},
+			wantVersion: "$Default",
+		},
+		{
+			name: "latest version of the launch template",
+			group: &autoscaling.Group{LaunchTemplate: &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateName: aws.String("web"),
+				Version:            aws.String("$Latest"),
+			}},
+			dltvo:       &ec2.DescribeLaunchTemplateVersionsOutput{LaunchTemplateVersions: []*ec2.LaunchTemplateVersion{version}},
+			wantVersion: "$Latest",
+		},
+		{
+			name: "launch template of the Mixed Instances Policy",
+			group: &autoscaling.Group{MixedInstancesPolicy: &autoscaling.MixedInstancesPolicy{
+				LaunchTemplate: &autoscaling.LaunchTemplate{
+					LaunchTemplateSpecification: &autoscaling.LaunchTemplateSpecification{
+						LaunchTemplateId: aws.String("lt-1"),
+						Version:          aws.String("7"),
+					},
+				},
+			}},
+			dltvo:       &ec2.DescribeLaunchTemplateVersionsOutput{LaunchTemplateVersions: []*ec2.LaunchTemplateVersion{version}},
+			wantVersion: "7",
+		},
+		{
+			name:    "group using a launch configuration",
+			group:   &autoscaling.Group{LaunchConfigurationName: aws.String("lc")},
+			wantErr: true,
+		},
+		{
+			name: "missing version",
+			group: &autoscaling.Group{LaunchTemplate: &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateId: aws.String("lt-1"),
+				Version:          aws.String("8"),
+			}},
+			dltvo:       &ec2.DescribeLaunchTemplateVersionsOutput{},
+			wantVersion: "8",
+			wantErr:     true,
+		},
+		{
+			name: "API failure",
+			group: &autoscaling.Group{LaunchTemplate: &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateId: aws.String("lt-1"),
+			}},
+			dltverr:     errors.New("denied"),
+			wantVersion: "$Default",
+			wantErr:     true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requested []string
+			a := &autoScalingGroup{
+				Group: tt.group,
+				region: &region{services: connections{ec2: mockEC2{
+					dltvo:   tt.dltvo,
+					dltverr: tt.dltverr,
+					dltv:    &requested,
+				}}},
+			}
+
+			err := a.loadLaunchTemplate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("loadLaunchTemplate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantVersion != "" && !reflect.DeepEqual(requested, []string{tt.wantVersion}) {
+				t.Errorf("loadLaunchTemplate() requested versions %v, want %s", requested, tt.wantVersion)
+			}
+			if tt.wantErr {
+				if a.launchTemplate != nil {
+					t.Errorf("loadLaunchTemplate() loaded %v on failure", a.launchTemplate)
+				}
+				return
+			}
+
+			want := &ec2.LaunchTemplateSpecification{
+				LaunchTemplateId: aws.String("lt-1"),
+				Version:          aws.String("7"),
+			}
+			if got := a.launchTemplate.specification(); !reflect.DeepEqual(got, want) {
+				t.Errorf("specification() = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestCreateRunInstancesInputFromLaunchTemplate(t *testing.T) {
+	lt := &launchTemplate{LaunchTemplateVersion: &ec2.LaunchTemplateVersion{
+		LaunchTemplateId: aws.String("lt-1"),
+		VersionNumber:    aws.Int64(3),
+		LaunchTemplateData: &ec2.ResponseLaunchTemplateData{
+			BlockDeviceMappings: []*ec2.LaunchTemplateBlockDeviceMapping{
+				{
+					DeviceName: aws.String("/dev/xvda"),
+					Ebs: &ec2.LaunchTemplateEbsBlockDevice{
+						VolumeSize: aws.Int64(20),
+						VolumeType: aws.String("gp3"),
+						Throughput: aws.Int64(250),
+					},
+				},
+				{
+					DeviceName: aws.String("/dev/xvdf"),
+					Ebs:        &ec2.LaunchTemplateEbsBlockDevice{VolumeSize: aws.Int64(100)},
+				},
+				{DeviceName: aws.String("/dev/xvdb"), VirtualName: aws.String("ephemeral0")},
+			},
+			MetadataOptions: &ec2.LaunchTemplateInstanceMetadataOptions{
+				HttpEndpoint:            aws.String("enabled"),
+				HttpPutResponseHopLimit: aws.Int64(2),
+				HttpTokens:              aws.String("required"),
+				State:                   aws.String("applied"),
+			},
+			NetworkInterfaces: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecification{
+				{
+					AssociatePublicIpAddress: aws.Bool(true),
+					DeviceIndex:              aws.Int64(0),
+					Groups:                   aws.StringSlice([]string{"sg-template"}),
+					PrivateIpAddress:         aws.String("10.0.0.10"),
+					SubnetId:                 aws.String("subnet-template"),
+				},
+				{
+					DeviceIndex: aws.Int64(1),
+					SubnetId:    aws.String("subnet-secondary"),
+				},
+			},
+		},
+	}}
+
+	i := &instance{
+		region: &region{conf: &Config{runID: "run-1"}},
+		asg: &autoScalingGroup{
+			name: "mygroup",
+			Group: &autoscaling.Group{
+				LaunchTemplate: &autoscaling.LaunchTemplateSpecification{
+					LaunchTemplateName: aws.String("web"),
+					Version:            aws.String("$Latest"),
+				},
+				Tags: []*autoscaling.TagDescription{
+					{Key: aws.String(StatefulVolumesTag), Value: aws.String("/dev/xvdf")},
+				},
+			},
+			launchTemplate: lt,
+		},
+		Instance: &ec2.Instance{
+			InstanceId:     aws.String("i-ondemand"),
+			ImageId:        aws.String("ami-123"),
+			Placement:      &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+			SecurityGroups: []*ec2.GroupIdentifier{{GroupId: aws.String("sg-123")}},
+			SubnetId:       aws.String("subnet-123"),
+		},
+	}
+
+	got := i.createRunInstancesInput("m5.large", 0.1)
+
+	wantTemplate := &ec2.LaunchTemplateSpecification{
+		LaunchTemplateId: aws.String("lt-1"),
+		Version:          aws.String("3"),
+	}
+	if !reflect.DeepEqual(got.LaunchTemplate, wantTemplate) {
+		t.Errorf("LaunchTemplate = %v, want %v", got.LaunchTemplate, wantTemplate)
+	}
+
+	wantBDMs := []*ec2.BlockDeviceMapping{
+		{
+			DeviceName: aws.String("/dev/xvda"),
+			Ebs: &ec2.EbsBlockDevice{
+				VolumeSize: aws.Int64(20),
+				VolumeType: aws.String("gp3"),
+				Throughput: aws.Int64(250),
+			},
+		},
+		{DeviceName: aws.String("/dev/xvdf"), NoDevice: aws.String("")},
+		{DeviceName: aws.String("/dev/xvdb"), VirtualName: aws.String("ephemeral0")},
+	}
+	if !reflect.DeepEqual(got.BlockDeviceMappings, wantBDMs) {
+		t.Errorf("BlockDeviceMappings = %v, want %v", got.BlockDeviceMappings, wantBDMs)
+	}
+
+	wantMetadata := &ec2.InstanceMetadataOptionsRequest{
+		HttpEndpoint:            aws.String("enabled"),
+		HttpPutResponseHopLimit: aws.Int64(2),
+		HttpTokens:              aws.String("required"),
+	}
+	if !reflect.DeepEqual(got.MetadataOptions, wantMetadata) {
+		t.Errorf("MetadataOptions = %v, want %v", got.MetadataOptions, wantMetadata)
+	}
+
+	wantNIs := []*ec2.InstanceNetworkInterfaceSpecification{
+		{
+			AssociatePublicIpAddress: aws.Bool(true),
+			DeviceIndex:              aws.Int64(0),
+			Groups:                   aws.StringSlice([]string{"sg-123"}),
+			SubnetId:                 aws.String("subnet-123"),
+		},
+		{
+			DeviceIndex: aws.Int64(1),
+			SubnetId:    aws.String("subnet-secondary"),
+		},
+	}
+	if !reflect.DeepEqual(got.NetworkInterfaces, wantNIs) {
+		t.Errorf("NetworkInterfaces = %v, want %v", got.NetworkInterfaces, wantNIs)
+	}
+	if got.SubnetId != nil || got.SecurityGroupIds != nil {
+		t.Errorf("SubnetId = %v, SecurityGroupIds = %v, want them set on the network interface",
+			got.SubnetId, got.SecurityGroupIds)
+	}
+
+	if count := lt.countLaunchTemplateEphemeralVolumes(); count != 1 {
+		t.Errorf("countLaunchTemplateEphemeralVolumes() = %d, want 1", count)
+	}
+}
+
+func TestCreateRunInstancesInputWithUnloadedLaunchTemplate(t *testing.T) {
+	i := &instance{
+		region: &region{conf: &Config{runID: "run-1"}},
+		asg: &autoScalingGroup{
+			name: "mygroup",
+			Group: &autoscaling.Group{
+				LaunchTemplate: &autoscaling.LaunchTemplateSpecification{
+					LaunchTemplateName: aws.String("web"),
+					Version:            aws.String("$Latest"),
+				},
+			},
+		},
+		Instance: &ec2.Instance{
+			InstanceId: aws.String("i-ondemand"),
+			Placement:  &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+		},
+	}
+
+	want := &ec2.LaunchTemplateSpecification{
+		LaunchTemplateName: aws.String("web"),
+		Version:            aws.String("$Latest"),
+	}
+	if got := i.createRunInstancesInput("m5.large", 0.1).LaunchTemplate; !reflect.DeepEqual(got, want) {
+		t.Errorf("LaunchTemplate = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,315 @@
+package autospotting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// launchTrace records why the instance type of a spot instance was chosen:
+// all the instance types considered for replacing an on-demand instance, why
+// they were excluded, and the order in which the others were tried.
+type launchTrace struct {
+	Region               string            `json:"region"`
+	AutoScalingGroup     string            `json:"asg"`
+	OnDemandInstanceID   string            `json:"on_demand_instance_id"`
+	OnDemandInstanceType string            `json:"on_demand_instance_type"`
+	OnDemandPrice        float64           `json:"on_demand_price"`
+	AvailabilityZone     string            `json:"availability_zone"`
+	Time                 time.Time         `json:"time"`
+	Chosen               string            `json:"chosen,omitempty"`
+	Candidates           []*traceCandidate `json:"candidates"`
+}
+
+// traceCandidate is an instance type considered for a launch.
+type traceCandidate struct {
+	InstanceType string   `json:"instance_type"`
+	Price        float64  `json:"price"`
+	Score        *float64 `json:"score,omitempty"`
+	Rank         int      `json:"rank,omitempty"`
+	Excluded     []string `json:"excluded,omitempty"`
+}
+
+func (i *instance) newLaunchTrace() *launchTrace {
+	return &launchTrace{
+		Region:               i.region.name,
+		AutoScalingGroup:     i.asg.name,
+		OnDemandInstanceID:   aws.StringValue(i.InstanceId),
+		OnDemandInstanceType: aws.StringValue(i.InstanceType),
+		OnDemandPrice:        i.price,
+		AvailabilityZone:     aws.StringValue(i.Placement.AvailabilityZone),
+		Time:                 time.Now(),
+	}
+}
+
+func (t *launchTrace) candidate(instanceType string) *traceCandidate {
+	for _, c := range t.Candidates {
+		if c.InstanceType == instanceType {
+			return c
+		}
+	}
+	return nil
+}
+
+// add records a candidate, with the reason why it was excluded if any.
+func (t *launchTrace) add(instanceType string, price float64, excluded string) {
+	if t == nil || instanceType == "" {
+		return
+	}
+	c := &traceCandidate{InstanceType: instanceType, Price: price}
+	if excluded != "" {
+		c.Excluded = []string{excluded}
+	}
+	t.Candidates = append(t.Candidates, c)
+}
+
+func (t *launchTrace) score(instanceType string, score float64) {
+	if t == nil {
+		return
+	}
+	if c := t.candidate(instanceType); c != nil {
+		c.Score = aws.Float64(score)
+	}
+}
+
+// rank records the order in which the compatible candidates are tried.
+func (t *launchTrace) rank(candidates []instanceTypeInformation) {
+	if t == nil {
+		return
+	}
+	for idx, it := range candidates {
+		if c := t.candidate(it.instanceType); c != nil {
+			c.Rank = idx + 1
+		}
+	}
+}
+
+// exclude records why a compatible candidate couldn't be launched.
+func (t *launchTrace) exclude(instanceType string, reason string) {
+	if t == nil {
+		return
+	}
+	if c := t.candidate(instanceType); c != nil {
+		c.Excluded = append(c.Excluded, reason)
+	}
+}
+
+func (t *launchTrace) choose(instanceType string) {
+	if t == nil {
+		return
+	}
+	t.Chosen = instanceType
+}
+
+// table formats the candidates, the compatible ones first in the order they
+// were tried.
+func (t *launchTrace) table() string {
+	var ranked, excluded []*traceCandidate
+	for _, c := range t.Candidates {
+		if c.Rank > 0 {
+			ranked = append(ranked, c)
+			continue
+		}
+		excluded = append(excluded, c)
+	}
+	sort.Slice(ranked, func(x, y int) bool { return ranked[x].Rank < ranked[y].Rank })
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RANK\tTYPE\tPRICE\tSCORE\tEXCLUDED")
+	for _, c := range append(ranked, excluded...) {
+		rank, score := "-", "-"
+		if c.Rank > 0 {
+			rank = fmt.Sprint(c.Rank)
+		}
+		if c.Score != nil {
+			score = fmt.Sprintf("%.3f", *c.Score)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.5f\t%s\t%s\n", rank, c.InstanceType, c.Price, score,
+			strings.Join(c.Excluded, ", "))
+	}
+	w.Flush()
+	return b.String()
+}
+
+// finish logs the candidates of the launch and keeps the trace for the run
+// artifact.
+func (t *launchTrace) finish(cfg *Config) {
+	if t == nil {
+		return
+	}
+	chosen := t.Chosen
+	if chosen == "" {
+		chosen = "none"
+	}
+	debug.Printf("%s %s Candidates for replacing %s (%s) in %s, launched %s:\n%s",
+		t.Region, t.AutoScalingGroup, t.OnDemandInstanceID, t.OnDemandInstanceType,
+		t.AvailabilityZone, chosen, t.table())
+	cfg.launchTraces.record(t)
+}
+
+// launchTraceLog collects the launch traces of a run.
+type launchTraceLog struct {
+	sync.Mutex
+	traces []*launchTrace
+}
+
+func (l *launchTraceLog) record(t *launchTrace) {
+	if l == nil {
+		return
+	}
+	l.Lock()
+	defer l.Unlock()
+	l.traces = append(l.traces, t)
+}
+
+func (l *launchTraceLog) list() []*launchTrace {
+	if l == nil {
+		return nil
+	}
+	l.Lock()
+	defer l.Unlock()
+	return append([]*launchTrace(nil), l.traces...)
+}
+
+// runArtifact is the document describing a run, stored in S3 at the end of
+// the run.
+type runArtifact struct {
+	RunID    string         `json:"run_id"`
+	Start    time.Time      `json:"start"`
+	End      time.Time      `json:"end"`
+	Changes  []change       `json:"changes"`
+	Launches []*launchTrace `json:"launches"`
+
+	// the spot and on-demand instances of the processed groups
+	Groups []groupComposition `json:"groups"`
+}
+
+func newRunArtifact(cfg *Config, end time.Time) runArtifact {
+	artifact := runArtifact{
+		RunID:    cfg.runID,
+		End:      end,
+		Launches: cfg.launchTraces.list(),
+		Groups:   cfg.compositions.list(),
+	}
+	if cfg.changes != nil {
+		artifact.Start = cfg.changes.start
+		artifact.Changes = cfg.changes.list()
+	}
+	return artifact
+}
+
+// fetchRunArtifacts reads the run artifacts found under the given S3 prefix,
+// skipping the ones which can't be parsed.
+func fetchRunArtifacts(svc s3iface.S3API, bucket, prefix string) ([]runArtifact, error) {
+	var keys []string
+
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, o := range page.Contents {
+			if strings.HasSuffix(*o.Key, ".json") {
+				keys = append(keys, *o.Key)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Println("Found", len(keys), "run artifacts in", bucket, prefix)
+
+	var artifacts []runArtifact
+	for _, key := range keys {
+		out, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		var artifact runArtifact
+		err = json.NewDecoder(out.Body).Decode(&artifact)
+		out.Body.Close()
+		if err != nil {
+			logger.Println("Skipping unparseable run artifact", key, err.Error())
+			continue
+		}
+		artifacts = append(artifacts, artifact)
+	}
+	return artifacts, nil
+}
+
+// storeRunArtifact writes the run artifact to the configured S3 location. In
+// audit mode the artifact is only logged, along with the location it would
+// be written to.
+func storeRunArtifact(cfg *Config) {
+	if cfg.RunArtifactLocation == "" {
+		return
+	}
+
+	bucket, prefix, err := parseS3URL(cfg.RunArtifactLocation)
+	if err != nil {
+		logger.Println("Invalid run artifact location", cfg.RunArtifactLocation, err.Error())
+		return
+	}
+
+	artifact := newRunArtifact(cfg, time.Now())
+	if cfg.AuditMode {
+		logRunArtifact(bucket, prefix, artifact)
+		return
+	}
+
+	sess := newBucketSession(cfg, bucket, cfg.MainRegion)
+	if err := putRunArtifact(s3.New(sess), bucket, prefix, artifact); err != nil {
+		logger.Println("Failed to store the run artifact:", err.Error())
+	}
+}
+
+func runArtifactKey(prefix string, artifact runArtifact) string {
+	return path.Join(prefix, artifact.RunID+".json")
+}
+
+// logRunArtifact logs the run artifact instead of storing it in audit mode.
+func logRunArtifact(bucket, prefix string, artifact runArtifact) {
+	body, err := json.Marshal(artifact)
+	if err != nil {
+		logger.Println("Failed to encode the run artifact:", err.Error())
+		return
+	}
+	logger.Printf("Audit mode, not storing the run artifact at s3://%s/%s: %s\n",
+		bucket, runArtifactKey(prefix, artifact), body)
+}
+
+func putRunArtifact(svc s3iface.S3API, bucket, prefix string, artifact runArtifact) error {
+	body, err := json.Marshal(artifact)
+	if err != nil {
+		return err
+	}
+
+	key := runArtifactKey(prefix, artifact)
+	_, err = svc.PutObject(&s3.PutObjectInput{
+		Bucket:      aws.String(bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return err
+	}
+	logger.Printf("Stored the run artifact at s3://%s/%s\n", bucket, key)
+	return nil
+}
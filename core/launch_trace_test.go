@@ -0,0 +1,161 @@
+package autospotting
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func TestLaunchTrace(t *testing.T) {
+	i := newTestInstance("i-ondemand", "m4.large", time.Hour)
+	i.typeInfo, i.price = newTestTypeInfo("m4.large", 2, 0.1, 0), 0.1
+	i.asg = &autoScalingGroup{name: "mygroup", Group: &autoscaling.Group{}}
+	i.region = &region{
+		name: "us-east-1",
+		conf: &Config{launchTraces: &launchTraceLog{}},
+		instanceTypeInformation: map[string]instanceTypeInformation{
+			"c5.large":  newTestTypeInfo("c5.large", 2, 0, 0.03),
+			"m5.large":  newTestTypeInfo("m5.large", 2, 0, 0.04),
+			"m5.xlarge": newTestTypeInfo("m5.xlarge", 4, 0, 0.2),
+			"t3.small":  newTestTypeInfo("t3.small", 1, 0, 0.01),
+			"r5.large":  newTestTypeInfo("r5.large", 2, 0, 0.05),
+		},
+	}
+	i.trace = i.newLaunchTrace()
+
+	candidates, err := i.getCompatibleSpotInstanceTypesListSortedAscendingByPrice(nil, []string{"r5.*"})
+	if err != nil {
+		t.Fatalf("getCompatibleSpotInstanceTypesListSortedAscendingByPrice() error = %v", err)
+	}
+	i.trace.rank(candidates)
+	i.trace.exclude("c5.large", "launch failed: InsufficientInstanceCapacity")
+	i.trace.choose("m5.large")
+	i.trace.finish(i.region.conf)
+
+	got := map[string]traceCandidate{}
+	for _, c := range i.trace.Candidates {
+		got[c.InstanceType] = *c
+	}
+	want := map[string]traceCandidate{
+		"c5.large": {InstanceType: "c5.large", Price: 0.03, Rank: 1,
+			Excluded: []string{"launch failed: InsufficientInstanceCapacity"}},
+		"m5.large":  {InstanceType: "m5.large", Price: 0.04, Rank: 2},
+		"m5.xlarge": {InstanceType: "m5.xlarge", Price: 0.2, Excluded: []string{"not price compatible"}},
+		"r5.large":  {InstanceType: "r5.large", Price: 0.05, Excluded: []string{"not allowed"}},
+		"t3.small": {InstanceType: "t3.small", Price: 0.01,
+			Excluded: []string{"not class compatible (CPU/memory/GPU)"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("launch trace candidates = %+v, want %+v", got, want)
+	}
+
+	lines := strings.Split(strings.TrimSpace(i.trace.table()), "\n")
+	if len(lines) != 6 || !strings.HasPrefix(lines[1], "1 ") || !strings.Contains(lines[1], "c5.large") ||
+		!strings.HasPrefix(lines[2], "2 ") || !strings.Contains(lines[2], "m5.large") {
+		t.Errorf("table() = \n%s", i.trace.table())
+	}
+
+	traces := i.region.conf.launchTraces.list()
+	if len(traces) != 1 || traces[0].Chosen != "m5.large" || traces[0].OnDemandInstanceID != "i-ondemand" {
+		t.Errorf("recorded launch traces = %+v", traces)
+	}
+}
+
+func TestLaunchTraceScores(t *testing.T) {
+	i := newTestInstance("i-ondemand", "m4.large", time.Hour)
+	i.typeInfo, i.price = newTestTypeInfo("m4.large", 2, 0.1, 0), 0.1
+	i.asg = &autoScalingGroup{name: "mygroup", Group: &autoscaling.Group{}}
+	i.region = &region{
+		name: "us-east-1",
+		conf: &Config{launchTraces: &launchTraceLog{}},
+		instanceTypeInformation: map[string]instanceTypeInformation{
+			"c5.large":  newTestTypeInfo("c5.large", 2, 0, 0.03),
+			"m5.large":  newTestTypeInfo("m5.large", 2, 0, 0.04),
+			"m5.xlarge": newTestTypeInfo("m5.xlarge", 4, 0, 0.2),
+			"t3.small":  newTestTypeInfo("t3.small", 1, 0, 0.01),
+			"r5.large":  newTestTypeInfo("r5.large", 2, 0, 0.05),
+		},
+	}
+	i.asg.config = AutoScalingConfig{PriceWeight: 1, HeadroomWeight: 1}
+	i.region.instanceTypeInformation["m5.xlarge"] = instanceTypeInformation{
+		instanceType:      "m5.xlarge",
+		vCPU:              4,
+		memory:            16,
+		PhysicalProcessor: "Intel",
+		pricing:           prices{spot: map[string]float64{"us-east-1a": 0.06}},
+	}
+	i.trace = i.newLaunchTrace()
+
+	if _, err := i.getCompatibleSpotInstanceTypesListSortedAscendingByPrice(nil, nil); err != nil {
+		t.Fatalf("getCompatibleSpotInstanceTypesListSortedAscendingByPrice() error = %v", err)
+	}
+
+	for _, c := range i.trace.Candidates {
+		if (c.Score != nil) != (c.Excluded == nil) {
+			t.Errorf("candidate %s has score %v and exclusions %v", c.InstanceType, c.Score, c.Excluded)
+		}
+	}
+	if c := i.trace.candidate("m5.xlarge"); c == nil || c.Score == nil || math.Abs(*c.Score-0.7) > 1e-9 {
+		t.Errorf("m5.xlarge candidate = %+v, want score 0.7", c)
+	}
+}
+
+func TestPutRunArtifact(t *testing.T) {
+	cfg := &Config{
+		runID:        "20201120T082200Z-1a2b",
+		changes:      &changeLog{start: time.Date(2020, 11, 20, 8, 22, 0, 0, time.UTC)},
+		launchTraces: &launchTraceLog{},
+	}
+	cfg.changes.record(change{Region: "us-east-1", AutoScalingGroup: "mygroup", SpotInstanceType: "m5.large"})
+	cfg.launchTraces.record(&launchTrace{Region: "us-east-1", AutoScalingGroup: "mygroup", Chosen: "m5.large"})
+
+	svc := mockS3{objects: map[string][]byte{}}
+	artifact := newRunArtifact(cfg, time.Date(2020, 11, 20, 8, 25, 0, 0, time.UTC))
+	if err := putRunArtifact(svc, "bucket", "autospotting/", artifact); err != nil {
+		t.Fatalf("putRunArtifact() error = %v", err)
+	}
+
+	body, ok := svc.objects["autospotting/20201120T082200Z-1a2b.json"]
+	if !ok {
+		t.Fatalf("putRunArtifact() stored %v", svc.objects)
+	}
+	var stored runArtifact
+	if err := json.Unmarshal(body, &stored); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(stored, artifact) {
+		t.Errorf("stored artifact = %+v, want %+v", stored, artifact)
+	}
+
+	svc.poerr = errors.New("AccessDenied")
+	if err := putRunArtifact(svc, "bucket", "", artifact); err == nil {
+		t.Error("putRunArtifact() expected an error")
+	}
+}
+
+func TestStoreRunArtifactInAuditMode(t *testing.T) {
+	defer disableLogging()
+
+	var out bytes.Buffer
+	setupLogging(&Config{LogFile: &out})
+
+	// no client is created in audit mode, so nothing can be written to S3
+	storeRunArtifact(&Config{
+		AuditMode:           true,
+		RunArtifactLocation: "s3://bucket/autospotting",
+		runID:               "20201120T082200Z-1a2b",
+		changes:             &changeLog{},
+		launchTraces:        &launchTraceLog{},
+	})
+
+	if !strings.Contains(out.String(), "not storing the run artifact at s3://bucket/autospotting/20201120T082200Z-1a2b.json") {
+		t.Errorf("storeRunArtifact() didn't log the artifact in audit mode: %q", out.String())
+	}
+}
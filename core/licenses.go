@@ -0,0 +1,236 @@
+package autospotting
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/licensemanager"
+)
+
+// The License Manager rules constraining the instances a license can be used
+// on, set as "#rule=value" strings on the license configurations
+const (
+	minimumVcpusRule          = "minimumVcpus"
+	maximumVcpusRule          = "maximumVcpus"
+	minimumCoresRule          = "minimumCores"
+	maximumCoresRule          = "maximumCores"
+	allowedTenancyRule        = "allowedTenancy"
+	licenseAffinityToHostRule = "licenseAffinityToHost"
+
+	defaultTenancy = "EC2-Default"
+)
+
+// licenseConfiguration is a License Manager license configuration associated
+// with some of the on-demand instances.
+type licenseConfiguration struct {
+	name         string
+	countingType string
+
+	// number of licenses, zero when not limited
+	count     int64
+	hardLimit bool
+	consumed  int64
+
+	rules map[string]string
+}
+
+// parseLicenseRules converts the "#rule=value" rules of a license
+// configuration into a map.
+func parseLicenseRules(rules []*string) map[string]string {
+	result := make(map[string]string)
+	for _, rule := range rules {
+		kv := strings.SplitN(strings.TrimPrefix(aws.StringValue(rule), "#"), "=", 2)
+		if len(kv) == 2 {
+			result[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return result
+}
+
+// intRule returns the value of an integer rule, or false if it isn't set.
+func (l *licenseConfiguration) intRule(name string) (int64, bool) {
+	value, ok := l.rules[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		logger.Printf("Ignoring invalid %s rule '%s' of license configuration %s\n", name, value, l.name)
+		return 0, false
+	}
+	return n, true
+}
+
+// requiresDedicatedHost returns true if the licenses can only be used on
+// Dedicated Hosts, where spot instances can't be launched.
+func (l *licenseConfiguration) requiresDedicatedHost() bool {
+	if _, ok := l.rules[licenseAffinityToHostRule]; ok {
+		return true
+	}
+	tenancy, ok := l.rules[allowedTenancyRule]
+	if !ok {
+		return false
+	}
+	for _, t := range strings.FieldsFunc(tenancy, func(r rune) bool { return r == '|' || r == ',' }) {
+		if strings.TrimSpace(t) == defaultTenancy {
+			return false
+		}
+	}
+	return true
+}
+
+// licenseConfiguration returns the license configuration with the given ARN,
+// fetched once per run and shared by all the instances of the region. It
+// returns nil if it couldn't be fetched.
+func (r *region) licenseConfiguration(arn string) *licenseConfiguration {
+	r.licensesMu.Lock()
+	defer r.licensesMu.Unlock()
+
+	if l, ok := r.licenses[arn]; ok {
+		return l
+	}
+
+	if r.licenses == nil {
+		r.licenses = make(map[string]*licenseConfiguration)
+	}
+	r.licenses[arn] = nil
+
+	if r.services.licenseManager == nil {
+		return nil
+	}
+
+	out, err := r.services.licenseManager.GetLicenseConfiguration(
+		&licensemanager.GetLicenseConfigurationInput{LicenseConfigurationArn: aws.String(arn)})
+	if err != nil {
+		logger.Println(r.name, "Failed to get license configuration", arn, err.Error())
+		return nil
+	}
+
+	l := &licenseConfiguration{
+		name:         aws.StringValue(out.Name),
+		countingType: aws.StringValue(out.LicenseCountingType),
+		count:        aws.Int64Value(out.LicenseCount),
+		hardLimit:    aws.BoolValue(out.LicenseCountHardLimit),
+		consumed:     aws.Int64Value(out.ConsumedLicenses),
+		rules:        parseLicenseRules(out.LicenseRules),
+	}
+	r.licenses[arn] = l
+	return l
+}
+
+// licenseConfigurations returns the License Manager license configurations
+// associated with the instance.
+func (i *instance) licenseConfigurations() []*licenseConfiguration {
+	var result []*licenseConfiguration
+	for _, lc := range i.Licenses {
+		if l := i.region.licenseConfiguration(aws.StringValue(lc.LicenseConfigurationArn)); l != nil {
+			result = append(result, l)
+		}
+	}
+	return result
+}
+
+// checkLicenses returns an error if the licenses of the instance can't be
+// used by any spot instance, such as the bring your own license ones bound to
+// Dedicated Hosts.
+func (i *instance) checkLicenses() error {
+	if i.Placement != nil && aws.StringValue(i.Placement.Tenancy) == "host" {
+		return fmt.Errorf("instance %s runs on a Dedicated Host, which is not supported by spot instances",
+			*i.InstanceId)
+	}
+	for _, l := range i.licenseConfigurations() {
+		if l.requiresDedicatedHost() {
+			return fmt.Errorf("license configuration %s of instance %s requires Dedicated Hosts",
+				l.name, *i.InstanceId)
+		}
+	}
+	return nil
+}
+
+// spotVCPUsAndCores estimates the number of vCPUs and CPU cores of a spot
+// instance of the candidate type, considering its CPU options.
+func (i *instance) spotVCPUsAndCores(candidate instanceTypeInformation) (int64, int64) {
+	threads := int64(2)
+	if isARM(candidate.PhysicalProcessor) || candidate.vCPU == 1 {
+		threads = 1
+	}
+	cores := int64(candidate.vCPU) / threads
+
+	if options := i.spotCPUOptions(); options != nil {
+		if options.CoreCount != nil {
+			cores = *options.CoreCount
+		}
+		if options.ThreadsPerCore != nil {
+			threads = *options.ThreadsPerCore
+		}
+	}
+	return cores * threads, cores
+}
+
+// licensesUsed returns the number of licenses of the given configuration a
+// spot instance of the candidate type would consume.
+func (i *instance) licensesUsed(l *licenseConfiguration, candidate instanceTypeInformation) int64 {
+	vCPUs, cores := i.spotVCPUsAndCores(candidate)
+	switch l.countingType {
+	case licensemanager.LicenseCountingTypeVCpu:
+		return vCPUs
+	case licensemanager.LicenseCountingTypeCore:
+		return cores
+	case licensemanager.LicenseCountingTypeInstance:
+		return 1
+	}
+	// sockets are only counted on Dedicated Hosts
+	return 0
+}
+
+// isLicenseCompatible returns true if a spot instance of the candidate type
+// would satisfy the rules of the license configurations of the instance and
+// stay within their hard limits, considering that the on-demand instance
+// keeps consuming its licenses until it's terminated.
+func (i *instance) isLicenseCompatible(spotCandidate instanceTypeInformation) bool {
+	licenses := i.licenseConfigurations()
+	if len(licenses) == 0 {
+		return true
+	}
+
+	vCPUs, cores := i.spotVCPUsAndCores(spotCandidate)
+	for _, l := range licenses {
+		if n, ok := l.intRule(minimumVcpusRule); ok && vCPUs < n {
+			logger.Println("\tLicense configuration", l.name, "requires at least", n, "vCPUs")
+			return false
+		}
+		if n, ok := l.intRule(maximumVcpusRule); ok && vCPUs > n {
+			logger.Println("\tLicense configuration", l.name, "allows at most", n, "vCPUs")
+			return false
+		}
+		if n, ok := l.intRule(minimumCoresRule); ok && cores < n {
+			logger.Println("\tLicense configuration", l.name, "requires at least", n, "cores")
+			return false
+		}
+		if n, ok := l.intRule(maximumCoresRule); ok && cores > n {
+			logger.Println("\tLicense configuration", l.name, "allows at most", n, "cores")
+			return false
+		}
+
+		i.region.licensesMu.Lock()
+		exceeded := l.hardLimit && l.count > 0 && l.consumed+i.licensesUsed(l, spotCandidate) > l.count
+		i.region.licensesMu.Unlock()
+		if exceeded {
+			logger.Println("\tWould exceed the", l.count, l.countingType, "licenses of license configuration", l.name)
+			return false
+		}
+	}
+	return true
+}
+
+// consumeLicenses accounts for the licenses consumed by a newly launched spot
+// instance, so that the following replacements don't exceed the hard limits.
+func (i *instance) consumeLicenses(spotCandidate instanceTypeInformation) {
+	for _, l := range i.licenseConfigurations() {
+		i.region.licensesMu.Lock()
+		l.consumed += i.licensesUsed(l, spotCandidate)
+		i.region.licensesMu.Unlock()
+	}
+}
@@ -0,0 +1,141 @@
+package autospotting
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/licensemanager"
+)
+
+func TestParseLicenseRules(t *testing.T) {
+	rules := parseLicenseRules([]*string{
+		aws.String("#minimumVcpus=2"),
+		aws.String("#allowedTenancy=EC2-Default|EC2-DedicatedInstance"),
+		aws.String("invalid"),
+	})
+	if len(rules) != 2 || rules[minimumVcpusRule] != "2" ||
+		rules[allowedTenancyRule] != "EC2-Default|EC2-DedicatedInstance" {
+		t.Errorf("unexpected rules %v", rules)
+	}
+}
+
+func TestLicenseConfigurationRequiresDedicatedHost(t *testing.T) {
+	tests := []struct {
+		rules map[string]string
+		want  bool
+	}{
+		{rules: map[string]string{}, want: false},
+		{rules: map[string]string{allowedTenancyRule: "EC2-Default|EC2-DedicatedInstance"}, want: false},
+		{rules: map[string]string{allowedTenancyRule: "EC2-DedicatedHost"}, want: true},
+		{rules: map[string]string{licenseAffinityToHostRule: "90"}, want: true},
+	}
+	for _, tt := range tests {
+		l := &licenseConfiguration{name: "lc", rules: tt.rules}
+		if got := l.requiresDedicatedHost(); got != tt.want {
+			t.Errorf("requiresDedicatedHost() with rules %v = %v, want %v", tt.rules, got, tt.want)
+		}
+	}
+}
+
+func TestInstanceLicenses(t *testing.T) {
+	arn := "arn:aws:license-manager:us-east-1:123456789012:license-configuration:lic-1"
+
+	newInstance := func(out *licensemanager.GetLicenseConfigurationOutput, err error) (*instance, *mockLicenseManager) {
+		lm := &mockLicenseManager{
+			glc:    map[string]*licensemanager.GetLicenseConfigurationOutput{arn: out},
+			glcerr: err,
+		}
+		return &instance{
+			Instance: &ec2.Instance{
+				InstanceId: aws.String("i-ondemand"),
+				Placement:  &ec2.Placement{Tenancy: aws.String("default")},
+				Licenses:   []*ec2.LicenseConfiguration{{LicenseConfigurationArn: aws.String(arn)}},
+			},
+			region: &region{name: "us-east-1", services: connections{licenseManager: lm}},
+		}, lm
+	}
+	small := instanceTypeInformation{instanceType: "m5.large", vCPU: 2}
+	large := instanceTypeInformation{instanceType: "m5.4xlarge", vCPU: 16}
+	graviton := instanceTypeInformation{instanceType: "m6g.large", vCPU: 2, PhysicalProcessor: "AWS Graviton2 Processor"}
+
+	t.Run("rules", func(t *testing.T) {
+		i, lm := newInstance(&licensemanager.GetLicenseConfigurationOutput{
+			Name:                aws.String("sql"),
+			LicenseCountingType: aws.String(licensemanager.LicenseCountingTypeCore),
+			LicenseRules:        []*string{aws.String("#minimumCores=2"), aws.String("#maximumVcpus=8")},
+		}, nil)
+		if i.isLicenseCompatible(small) {
+			t.Error("expected m5.large with a single core to be rejected")
+		}
+		if !i.isLicenseCompatible(graviton) {
+			t.Error("expected m6g.large with two cores to be accepted")
+		}
+		if i.isLicenseCompatible(large) {
+			t.Error("expected m5.4xlarge with 16 vCPUs to be rejected")
+		}
+		if lm.calls != 1 {
+			t.Errorf("expected the license configuration to be fetched once, got %d calls", lm.calls)
+		}
+	})
+
+	t.Run("hard limit", func(t *testing.T) {
+		i, _ := newInstance(&licensemanager.GetLicenseConfigurationOutput{
+			Name:                  aws.String("sql"),
+			LicenseCountingType:   aws.String(licensemanager.LicenseCountingTypeVCpu),
+			LicenseCount:          aws.Int64(10),
+			LicenseCountHardLimit: aws.Bool(true),
+			ConsumedLicenses:      aws.Int64(6),
+		}, nil)
+		if !i.isLicenseCompatible(small) {
+			t.Error("expected m5.large to fit within the remaining licenses")
+		}
+		if i.isLicenseCompatible(large) {
+			t.Error("expected m5.4xlarge to exceed the remaining licenses")
+		}
+
+		i.consumeLicenses(small)
+		i.consumeLicenses(small)
+		if i.isLicenseCompatible(small) {
+			t.Error("expected the licenses consumed by the new spot instances to be accounted for")
+		}
+	})
+
+	t.Run("soft limit", func(t *testing.T) {
+		i, _ := newInstance(&licensemanager.GetLicenseConfigurationOutput{
+			LicenseCountingType: aws.String(licensemanager.LicenseCountingTypeInstance),
+			LicenseCount:        aws.Int64(1),
+			ConsumedLicenses:    aws.Int64(1),
+		}, nil)
+		if !i.isLicenseCompatible(large) {
+			t.Error("expected soft limits not to constrain the instance types")
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		i, _ := newInstance(nil, errors.New("denied"))
+		if !i.isLicenseCompatible(large) || i.checkLicenses() != nil {
+			t.Error("expected license configurations which can't be fetched to be ignored")
+		}
+	})
+
+	t.Run("dedicated hosts", func(t *testing.T) {
+		i, _ := newInstance(&licensemanager.GetLicenseConfigurationOutput{
+			Name:         aws.String("windows"),
+			LicenseRules: []*string{aws.String("#allowedTenancy=EC2-DedicatedHost")},
+		}, nil)
+		if i.checkLicenses() == nil {
+			t.Error("expected licenses bound to Dedicated Hosts to prevent the replacement")
+		}
+
+		i, _ = newInstance(&licensemanager.GetLicenseConfigurationOutput{}, nil)
+		if i.checkLicenses() != nil {
+			t.Error("expected licenses without tenancy rules to allow the replacement")
+		}
+		i.Placement.Tenancy = aws.String("host")
+		if i.checkLicenses() == nil {
+			t.Error("expected instances running on Dedicated Hosts not to be replaced")
+		}
+	})
+}
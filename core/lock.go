@@ -0,0 +1,353 @@
+package autospotting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+const (
+	// DefaultLockTTL is the default time after which the lock of a group is
+	// considered abandoned, such as after a Lambda timeout, matching the
+	// maximum duration of a Lambda invocation
+	DefaultLockTTL = 15 * time.Minute
+
+	// dynamoDBLockPrefix precedes the name of the DynamoDB table storing the
+	// locks in the LockLocation, given as dynamodb:<table>
+	dynamoDBLockPrefix = "dynamodb:"
+
+	// interruptionLockWait is how long the handling of a spot interruption
+	// waits for the lock of its group, after which it proceeds anyway since
+	// the instance is going to be interrupted
+	interruptionLockWait = 30 * time.Second
+
+	lockPollInterval = 2 * time.Second
+)
+
+// lockStore stores the locks of the groups, each of them held by an owner
+// until it's released or it expires.
+type lockStore interface {
+	// acquire takes the lock unless it's held by another owner and not
+	// expired yet, returning whether it was taken
+	acquire(key, owner string, now, expires time.Time) (bool, error)
+
+	// release removes the lock if it's still held by the owner
+	release(key, owner string) error
+}
+
+// groupLocker keeps the overlapping invocations of AutoSpotting, such as a
+// long scheduled run and the handling of a spot interruption, from taking
+// actions on the same group at the same time.
+type groupLocker struct {
+	store lockStore
+	owner string
+	ttl   time.Duration
+	now   func() time.Time
+}
+
+// newGroupLocker returns the locker configured by the LockLocation, or nil
+// when locking is disabled or the location is invalid.
+func newGroupLocker(cfg *Config, owner string) *groupLocker {
+	if cfg.LockLocation == "" {
+		return nil
+	}
+
+	store, err := newLockStore(cfg)
+	if err != nil {
+		logger.Println("Not locking the groups, invalid lock location", cfg.LockLocation, err.Error())
+		return nil
+	}
+
+	ttl := cfg.LockTTL
+	if ttl <= 0 {
+		ttl = DefaultLockTTL
+	}
+	return &groupLocker{store: store, owner: owner, ttl: ttl, now: time.Now}
+}
+
+func newLockStore(cfg *Config) (lockStore, error) {
+	if strings.HasPrefix(cfg.LockLocation, dynamoDBLockPrefix) {
+		table := strings.TrimPrefix(cfg.LockLocation, dynamoDBLockPrefix)
+		if table == "" {
+			return nil, fmt.Errorf("missing DynamoDB table name")
+		}
+		return &dynamoDBLockStore{svc: dynamodb.New(newSession(cfg, cfg.MainRegion)), table: table}, nil
+	}
+
+	bucket, prefix, err := parseS3URL(cfg.LockLocation)
+	if err != nil {
+		return nil, fmt.Errorf("expected dynamodb:<table> or s3://bucket/prefix")
+	}
+	sess := newBucketSession(cfg, bucket, cfg.MainRegion)
+	return &s3LockStore{svc: s3.New(sess), bucket: bucket, prefix: prefix}, nil
+}
+
+// lockKey identifies the lock of a group, prefixed by its account when it's
+// processed through a cross-account role.
+func lockKey(account, region, asgName string) string {
+	key := region + "/" + asgName
+	if account != "" {
+		key = account + "/" + key
+	}
+	return key
+}
+
+// lock takes the lock of the group, returning the function releasing it and
+// whether it was taken. Groups are only processed when their lock was taken,
+// so they're also skipped when the lock store can't be reached.
+func (l *groupLocker) lock(key string) (func(), bool) {
+	if l == nil {
+		return func() {}, true
+	}
+
+	now := l.now()
+	ok, err := l.store.acquire(key, l.owner, now, now.Add(l.ttl))
+	if err != nil {
+		logger.Println(key, "Failed to acquire the lock:", err.Error())
+		return func() {}, false
+	}
+	if !ok {
+		return func() {}, false
+	}
+
+	debug.Println(key, "Acquired the lock for", l.owner)
+	return func() {
+		if err := l.store.release(key, l.owner); err != nil {
+			logger.Println(key, "Failed to release the lock:", err.Error())
+		}
+	}, true
+}
+
+// lockWaiting takes the lock of the group, waiting for it to be released for
+// at most the given duration.
+func (l *groupLocker) lockWaiting(key string, wait time.Duration) (func(), bool) {
+	deadline := l.now().Add(wait)
+	for {
+		unlock, ok := l.lock(key)
+		if ok || !l.now().Add(lockPollInterval).Before(deadline) {
+			return unlock, ok
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// lock takes the lock of the group for the current run.
+func (a *autoScalingGroup) lock() (func(), bool) {
+	unlock, ok := a.region.conf.locks.lock(lockKey(a.region.conf.accountID, a.region.name, a.name))
+	if !ok {
+		logger.Println(a.region.name, a.name, "Skipping group, locked by another AutoSpotting invocation")
+	}
+	return unlock, ok
+}
+
+// LockGroup takes the lock of the group of the interrupted instance, so that
+// the handling of the interruption doesn't overlap with a run replacing
+// instances of the same group. It waits for a while if the group is locked,
+// then proceeds anyway. It returns the function releasing the lock.
+func (s *SpotTermination) LockGroup(cfg *Config, instanceID *string) func() {
+	if cfg == nil || cfg.LockLocation == "" {
+		return func() {}
+	}
+
+	asgName, err := s.getAsgName(instanceID)
+	if err != nil {
+		logger.Printf("Failed get ASG name for %s with err: %s\n", *instanceID, err.Error())
+		return func() {}
+	}
+
+	l := s.locker
+	if l == nil {
+		l = newGroupLocker(cfg, "interruption-"+*instanceID)
+	}
+	if l == nil {
+		return func() {}
+	}
+
+	unlock, ok := l.lockWaiting(lockKey("", s.region, asgName), interruptionLockWait)
+	if !ok {
+		logger.Println(s.region, asgName, "Handling the interruption of", *instanceID,
+			"although the group is locked by another AutoSpotting invocation")
+	}
+	return unlock
+}
+
+// dynamoDBLockStore stores the locks as the items of a DynamoDB table with a
+// "lock" partition key.
+type dynamoDBLockStore struct {
+	svc   dynamodbiface.DynamoDBAPI
+	table string
+}
+
+func (d *dynamoDBLockStore) acquire(key, owner string, now, expires time.Time) (bool, error) {
+	_, err := d.svc.PutItem(&dynamodb.PutItemInput{
+		TableName: aws.String(d.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"lock":    {S: aws.String(key)},
+			"owner":   {S: aws.String(owner)},
+			"expires": {N: aws.String(strconv.FormatInt(expires.Unix(), 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#l) OR #e < :now OR #o = :owner"),
+		ExpressionAttributeNames: map[string]*string{
+			"#l": aws.String("lock"),
+			"#e": aws.String("expires"),
+			"#o": aws.String("owner"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now":   {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+			":owner": {S: aws.String(owner)},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (d *dynamoDBLockStore) release(key, owner string) error {
+	_, err := d.svc.DeleteItem(&dynamodb.DeleteItemInput{
+		TableName:                aws.String(d.table),
+		Key:                      map[string]*dynamodb.AttributeValue{"lock": {S: aws.String(key)}},
+		ConditionExpression:      aws.String("#o = :owner"),
+		ExpressionAttributeNames: map[string]*string{"#o": aws.String("owner")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":owner": {S: aws.String(owner)},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil
+	}
+	return err
+}
+
+// s3LockStore stores the locks as S3 objects, created with conditional
+// writes so that only one owner can create each of them. The expired locks
+// are taken over and the locks are released with writes and deletes bound to
+// the ETag of the lock object which was read, so that a lock taken over by
+// another owner in the meantime is left alone.
+type s3LockStore struct {
+	svc    s3iface.S3API
+	bucket string
+	prefix string
+}
+
+// s3Lock is the content of the S3 object of a lock.
+type s3Lock struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires"`
+}
+
+func (s *s3LockStore) objectKey(key string) string {
+	return path.Join(s.prefix, key+".lock")
+}
+
+func (s *s3LockStore) acquire(key, owner string, now, expires time.Time) (bool, error) {
+	body, err := json.Marshal(s3Lock{Owner: owner, Expires: expires})
+	if err != nil {
+		return false, err
+	}
+
+	// once more when the lock was released in the meantime
+	for attempt := 0; attempt < 2; attempt++ {
+		err = s.put(key, body, "If-None-Match", "*")
+		if err == nil {
+			return true, nil
+		}
+		if !conditionFailed(err) {
+			return false, err
+		}
+
+		held, etag, err := s.get(key)
+		if isNoSuchKey(err) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if held.Owner != owner && held.Expires.After(now) {
+			return false, nil
+		}
+
+		// take over the expired lock, unless another owner did it first
+		err = s.put(key, body, "If-Match", etag)
+		if err == nil {
+			return true, nil
+		}
+		if conditionFailed(err) {
+			return false, nil
+		}
+		if !isNoSuchKey(err) {
+			return false, err
+		}
+	}
+	return false, nil
+}
+
+func (s *s3LockStore) release(key, owner string) error {
+	held, etag, err := s.get(key)
+	if err != nil {
+		return err
+	}
+	if held.Owner != owner {
+		return nil
+	}
+
+	_, err = s.svc.DeleteObjectWithContext(aws.BackgroundContext(), &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+	}, request.WithSetRequestHeaders(map[string]string{"If-Match": etag}))
+	if conditionFailed(err) || isNoSuchKey(err) {
+		// taken over by another owner after expiring
+		return nil
+	}
+	return err
+}
+
+// put writes the lock object if the given condition header holds.
+func (s *s3LockStore) put(key string, body []byte, header, value string) error {
+	_, err := s.svc.PutObjectWithContext(aws.BackgroundContext(), &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s.objectKey(key)),
+		Body:        bytes.NewReader(body),
+		ContentType: aws.String("application/json"),
+	}, request.WithSetRequestHeaders(map[string]string{header: value}))
+	return err
+}
+
+// get returns the lock along with the ETag of its object.
+func (s *s3LockStore) get(key string) (s3Lock, string, error) {
+	var l s3Lock
+	out, err := s.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.objectKey(key)),
+	})
+	if err != nil {
+		return l, "", err
+	}
+	defer out.Body.Close()
+	err = json.NewDecoder(out.Body).Decode(&l)
+	return l, aws.StringValue(out.ETag), err
+}
+
+// conditionFailed tells if a conditional S3 request failed because the lock
+// object was changed by another owner.
+func conditionFailed(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && (aerr.Code() == "PreconditionFailed" || aerr.Code() == "ConditionalRequestConflict")
+}
+
+func isNoSuchKey(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == s3.ErrCodeNoSuchKey
+}
@@ -0,0 +1,260 @@
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+func TestLockKey(t *testing.T) {
+	if got := lockKey("", "us-east-1", "web"); got != "us-east-1/web" {
+		t.Errorf("lockKey() = %q, want us-east-1/web", got)
+	}
+	if got := lockKey("123456789012", "us-east-1", "web"); got != "123456789012/us-east-1/web" {
+		t.Errorf("lockKey() = %q, want 123456789012/us-east-1/web", got)
+	}
+}
+
+func TestS3LockStore(t *testing.T) {
+	now := time.Now()
+	objects := map[string][]byte{}
+	store := &s3LockStore{svc: mockS3{objects: objects}, bucket: "bucket", prefix: "locks"}
+
+	if ok, err := store.acquire("us-east-1/web", "run-1", now, now.Add(time.Minute)); !ok || err != nil {
+		t.Fatalf("acquire() = %t, %v, want the lock taken", ok, err)
+	}
+	if _, ok := objects["locks/us-east-1/web.lock"]; !ok {
+		t.Errorf("acquire() stored %v, want the locks/us-east-1/web.lock object", objects)
+	}
+
+	if ok, err := store.acquire("us-east-1/web", "run-2", now, now.Add(time.Minute)); ok || err != nil {
+		t.Errorf("acquire() = %t, %v, want the lock held by another owner", ok, err)
+	}
+	if ok, err := store.acquire("us-east-1/web", "run-1", now, now.Add(time.Minute)); !ok || err != nil {
+		t.Errorf("acquire() = %t, %v, want the lock taken again by its owner", ok, err)
+	}
+	if ok, err := store.acquire("us-east-1/web", "run-2", now.Add(2*time.Minute), now.Add(3*time.Minute)); !ok || err != nil {
+		t.Errorf("acquire() = %t, %v, want the expired lock taken over", ok, err)
+	}
+
+	if err := store.release("us-east-1/web", "run-1"); err != nil || len(objects) != 1 {
+		t.Errorf("release() = %v, want the lock of another owner kept", err)
+	}
+	if err := store.release("us-east-1/web", "run-2"); err != nil || len(objects) != 0 {
+		t.Errorf("release() = %v, want the lock removed", err)
+	}
+}
+
+// racingS3 serializes the calls to the mocked S3 objects, and makes the
+// takers of a lock wait for each other after reading it, so that they all
+// try to take over the same expired lock.
+type racingS3 struct {
+	mockS3
+	mu      *sync.Mutex
+	readers *sync.WaitGroup
+}
+
+func (r racingS3) GetObject(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	r.mu.Lock()
+	out, err := r.mockS3.GetObject(in)
+	r.mu.Unlock()
+	r.readers.Done()
+	r.readers.Wait()
+	return out, err
+}
+
+func (r racingS3) PutObjectWithContext(ctx aws.Context, in *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.mockS3.PutObjectWithContext(ctx, in, opts...)
+}
+
+func TestS3LockStoreStaleLockTakers(t *testing.T) {
+	const takers = 2
+	now := time.Now()
+	objects := map[string][]byte{}
+	stale := &s3LockStore{svc: mockS3{objects: objects}, bucket: "bucket", prefix: "locks"}
+	if ok, err := stale.acquire("us-east-1/web", "run-0", now.Add(-time.Hour), now.Add(-time.Minute)); !ok || err != nil {
+		t.Fatalf("acquire() = %t, %v, want the lock taken", ok, err)
+	}
+
+	readers := &sync.WaitGroup{}
+	readers.Add(takers)
+	store := &s3LockStore{
+		svc:    racingS3{mockS3: mockS3{objects: objects}, mu: &sync.Mutex{}, readers: readers},
+		bucket: "bucket",
+		prefix: "locks",
+	}
+
+	taken := make(chan string, takers)
+	var wg sync.WaitGroup
+	for n := 1; n <= takers; n++ {
+		wg.Add(1)
+		go func(owner string) {
+			defer wg.Done()
+			ok, err := store.acquire("us-east-1/web", owner, now, now.Add(time.Minute))
+			if err != nil {
+				t.Errorf("acquire() error = %v", err)
+			}
+			if ok {
+				taken <- owner
+			}
+		}(fmt.Sprintf("run-%d", n))
+	}
+	wg.Wait()
+	close(taken)
+
+	var owners []string
+	for owner := range taken {
+		owners = append(owners, owner)
+	}
+	if len(owners) != 1 {
+		t.Fatalf("the expired lock was taken over by %v, want a single owner", owners)
+	}
+	held, _, err := stale.get("us-east-1/web")
+	if err != nil || held.Owner != owners[0] {
+		t.Errorf("the lock is held by %q, %v, want %s", held.Owner, err, owners[0])
+	}
+}
+
+func TestDynamoDBLockStore(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		pierr   error
+		want    bool
+		wantErr bool
+	}{
+		{name: "lock taken", want: true},
+		{
+			name:  "lock held",
+			pierr: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil),
+		},
+		{name: "table unavailable", pierr: errors.New("ResourceNotFoundException"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &mockDynamoDB{pierr: tt.pierr}
+			store := &dynamoDBLockStore{svc: svc, table: "locks"}
+
+			got, err := store.acquire("us-east-1/web", "run-1", now, now.Add(time.Minute))
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("acquire() = %t, %v, want %t, error %t", got, err, tt.want, tt.wantErr)
+			}
+			if len(svc.pi) != 1 || aws.StringValue(svc.pi[0].Item["lock"].S) != "us-east-1/web" ||
+				aws.StringValue(svc.pi[0].ConditionExpression) == "" {
+				t.Errorf("acquire() wrote %v, want a conditional write of the lock", svc.pi)
+			}
+		})
+	}
+
+	svc := &mockDynamoDB{dierr: awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "", nil)}
+	store := &dynamoDBLockStore{svc: svc, table: "locks"}
+	if err := store.release("us-east-1/web", "run-1"); err != nil || len(svc.di) != 1 {
+		t.Errorf("release() = %v, want the lock of another owner ignored", err)
+	}
+}
+
+// fakeLockStore holds the locks in memory.
+type fakeLockStore struct {
+	owners map[string]string
+	err    error
+}
+
+func (f *fakeLockStore) acquire(key, owner string, now, expires time.Time) (bool, error) {
+	if f.err != nil {
+		return false, f.err
+	}
+	if held, ok := f.owners[key]; ok && held != owner {
+		return false, nil
+	}
+	f.owners[key] = owner
+	return true, nil
+}
+
+func (f *fakeLockStore) release(key, owner string) error {
+	if f.owners[key] == owner {
+		delete(f.owners, key)
+	}
+	return nil
+}
+
+func TestGroupLocker(t *testing.T) {
+	var disabled *groupLocker
+	if _, ok := disabled.lock("us-east-1/web"); !ok {
+		t.Errorf("lock() on a disabled locker didn't let the group be processed")
+	}
+
+	store := &fakeLockStore{owners: map[string]string{"us-east-1/web": "interruption-i-spot"}}
+	l := &groupLocker{store: store, owner: "run-1", ttl: DefaultLockTTL, now: time.Now}
+
+	if _, ok := l.lockWaiting("us-east-1/web", 0); ok {
+		t.Errorf("lockWaiting() took the lock held by another owner")
+	}
+
+	unlock, ok := l.lock("us-east-1/api")
+	if !ok || store.owners["us-east-1/api"] != "run-1" {
+		t.Fatalf("lock() = %t, want the free lock taken", ok)
+	}
+	unlock()
+	if _, held := store.owners["us-east-1/api"]; held {
+		t.Errorf("unlock() didn't release the lock")
+	}
+
+	store.err = errors.New("AccessDenied")
+	if _, ok := l.lock("us-east-1/api"); ok {
+		t.Errorf("lock() let the group be processed although the lock store failed")
+	}
+}
+
+func TestProcessSkipsLockedGroups(t *testing.T) {
+	a := newReplacementBatchGroup(1, mockEC2{})
+	a.region.conf.locks = &groupLocker{
+		store: &fakeLockStore{owners: map[string]string{"us-east-1/mygroup": "interruption-i-spot"}},
+		owner: "run-1",
+		ttl:   DefaultLockTTL,
+		now:   time.Now,
+	}
+	a.region.services.autoScaling = mockASG{}
+
+	if err := a.process(); err != nil {
+		t.Errorf("process() = %v, want the locked group skipped", err)
+	}
+}
+
+func TestLockGroup(t *testing.T) {
+	store := &fakeLockStore{owners: map[string]string{}}
+	s := &SpotTermination{
+		region: "us-east-1",
+		asSvc: mockASG{dasio: &autoscaling.DescribeAutoScalingInstancesOutput{
+			AutoScalingInstances: []*autoscaling.InstanceDetails{
+				{AutoScalingGroupName: aws.String("web")},
+			},
+		}},
+		locker: &groupLocker{store: store, owner: "interruption-i-spot", ttl: DefaultLockTTL, now: time.Now},
+	}
+
+	s.LockGroup(&Config{}, aws.String("i-spot"))()
+	if len(store.owners) != 0 {
+		t.Errorf("LockGroup() locked the group although the locks are disabled")
+	}
+
+	unlock := s.LockGroup(&Config{LockLocation: "dynamodb:locks"}, aws.String("i-spot"))
+	if store.owners["us-east-1/web"] != "interruption-i-spot" {
+		t.Errorf("LockGroup() locks %v, want the group locked", store.owners)
+	}
+	unlock()
+	if len(store.owners) != 0 {
+		t.Errorf("LockGroup() didn't release the lock")
+	}
+}
@@ -0,0 +1,159 @@
+package autospotting
+
+import (
+	"encoding/json"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// TextLogFormat logs plain text lines.
+	TextLogFormat = "text"
+
+	// JSONLogFormat logs a JSON object per line, with the fields extracted
+	// from the messages, for querying them in CloudWatch Logs Insights or
+	// other log aggregators.
+	JSONLogFormat = "json"
+)
+
+var (
+	// matches the names of the regions and of their availability zones
+	regionPattern = regexp.MustCompile(`^([a-z]{2}(?:-gov|-iso[a-z]?)?-[a-z]+-[0-9])[a-z]?$`)
+
+	instanceIDPattern = regexp.MustCompile(`\bi-(?:[0-9a-f]{17}|[0-9a-f]{8})\b`)
+
+	spotPricePattern = regexp.MustCompile(`(?i)\bprice:?\s+([0-9]+(?:\.[0-9]+)?(?:e-?[0-9]+)?)\b`)
+
+	errorPattern = regexp.MustCompile(`(?i)\b(?:error|failed|failure|couldn't|unable)\b`)
+
+	// sourcePattern matches the file name and line number prefixed by the
+	// log.Lshortfile and log.Llongfile flags.
+	sourcePattern = regexp.MustCompile(`^(\S+\.go:[0-9]+): `)
+)
+
+// logActions are the actions logged by the words of the messages.
+var logActions = map[string]string{
+	"launching":   "launch",
+	"launched":    "launch",
+	"replacing":   "replace",
+	"replaced":    "replace",
+	"terminating": "terminate",
+	"terminated":  "terminate",
+	"attaching":   "attach",
+	"attached":    "attach",
+	"detaching":   "detach",
+	"detached":    "detach",
+	"draining":    "drain",
+	"drained":     "drain",
+	"converting":  "convert",
+	"tagging":     "tag",
+	"skipping":    "skip",
+	"reverting":   "revert",
+}
+
+// logGroups are the names of the groups processed during the run, found in
+// the log messages by the log writers.
+var logGroups sync.Map
+
+// addLogGroup makes the log writers detect the group in the messages.
+func addLogGroup(name string) {
+	logGroups.Store(name, true)
+}
+
+// jsonLogEntry is a line logged in the JSON format.
+type jsonLogEntry struct {
+	Time       string `json:"time"`
+	Level      string `json:"level"`
+	Message    string `json:"message"`
+	Source     string `json:"source,omitempty"`
+	Region     string `json:"region,omitempty"`
+	ASG        string `json:"asg,omitempty"`
+	InstanceID string `json:"instance_id,omitempty"`
+	Action     string `json:"action,omitempty"`
+	SpotPrice  string `json:"spot_price,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// logWriter receives the messages of a log.Logger and writes those at or
+// above the minimum log level to the underlying writer, as they are or as
+// JSON objects.
+type logWriter struct {
+	out io.Writer
+	// the level of the messages of the logger, raised to warn or error by
+	// their content
+	level string
+	min   string
+	json  bool
+	now   func() time.Time
+}
+
+func newLogWriter(out io.Writer, level, min, format string) *logWriter {
+	return &logWriter{out: out, level: level, min: min, json: format == JSONLogFormat, now: time.Now}
+}
+
+func (w *logWriter) Write(p []byte) (int, error) {
+	level := messageLevel(w.level, string(p))
+	if !isLogLevelEnabled(level, w.min) {
+		return len(p), nil
+	}
+	if !w.json {
+		return w.out.Write(p)
+	}
+
+	data, err := json.Marshal(w.entry(level, string(p)))
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.out.Write(append(data, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// entry extracts the fields of the message, which are left empty when they
+// aren't found in it.
+func (w *logWriter) entry(level, message string) jsonLogEntry {
+	e := jsonLogEntry{
+		Time:    w.now().UTC().Format(time.RFC3339Nano),
+		Level:   level,
+		Message: strings.TrimRight(message, "\n"),
+	}
+
+	if m := sourcePattern.FindStringSubmatch(e.Message); m != nil {
+		e.Source = m[1]
+		e.Message = strings.TrimPrefix(e.Message, m[0])
+	}
+
+	for _, word := range strings.FieldsFunc(e.Message, func(r rune) bool {
+		return r == ' ' || r == '\t' || r == '\n' || r == ',' || r == ':' || r == '"' || r == '\''
+	}) {
+		if m := regionPattern.FindStringSubmatch(word); m != nil && e.Region == "" {
+			e.Region = m[1]
+		}
+		if _, ok := logGroups.Load(word); ok && e.ASG == "" {
+			e.ASG = word
+		}
+		if action, ok := logActions[strings.ToLower(word)]; ok && e.Action == "" {
+			e.Action = action
+		}
+	}
+
+	e.InstanceID = instanceIDPattern.FindString(e.Message)
+	if m := spotPricePattern.FindStringSubmatch(e.Message); m != nil {
+		e.SpotPrice = m[1]
+	}
+
+	if errorPattern.MatchString(e.Message) {
+		e.Error = e.Message
+		if n := strings.LastIndex(e.Message, ": "); n >= 0 {
+			e.Error = strings.TrimSpace(e.Message[n+2:])
+		}
+		if e.Error == "" {
+			e.Error = e.Message
+		}
+	}
+	return e
+}
@@ -0,0 +1,78 @@
+package autospotting
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestJSONLogWriter(t *testing.T) {
+	addLogGroup("web-asg")
+
+	tests := []struct {
+		name    string
+		message string
+		want    jsonLogEntry
+	}{
+		{
+			name:    "launch",
+			message: "us-east-1a web-asg Launching spot instance of type m5.large with bid price 0.05",
+			want: jsonLogEntry{
+				Region:    "us-east-1",
+				ASG:       "web-asg",
+				Action:    "launch",
+				SpotPrice: "0.05",
+			},
+		},
+		{
+			name:    "failure",
+			message: "eu-west-1 web-asg Couldn't terminate i-0123456789abcdef0: UnauthorizedOperation",
+			want: jsonLogEntry{
+				Region:     "eu-west-1",
+				ASG:        "web-asg",
+				InstanceID: "i-0123456789abcdef0",
+				Level:      ErrorLogLevel,
+				Error:      "UnauthorizedOperation",
+			},
+		},
+		{
+			name:    "unknown group",
+			message: "Replacing i-01234567 of other-asg",
+			want: jsonLogEntry{
+				InstanceID: "i-01234567",
+				Action:     "replace",
+			},
+		},
+		{
+			name:    "no fields",
+			message: "Starting run 42",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			w := newLogWriter(&out, InfoLogLevel, InfoLogLevel, JSONLogFormat)
+			w.now = func() time.Time { return time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC) }
+			log.New(w, "", log.Lshortfile).Println(tt.message)
+
+			var got jsonLogEntry
+			if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+				t.Fatalf("logged invalid JSON %q: %v", out.String(), err)
+			}
+			if got.Source == "" {
+				t.Errorf("logged no source in %q", out.String())
+			}
+			tt.want.Time = "2021-01-02T03:04:05Z"
+			if tt.want.Level == "" {
+				tt.want.Level = InfoLogLevel
+			}
+			tt.want.Message = tt.message
+			tt.want.Source = got.Source
+			if got != tt.want {
+				t.Errorf("logged %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package autospotting
+
+import (
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+const (
+	// DebugLogLevel also logs every pricing comparison and a summary of
+	// every AWS API call.
+	DebugLogLevel = "debug"
+
+	// InfoLogLevel logs the decisions taken, and is the default log level.
+	InfoLogLevel = "info"
+
+	// WarnLogLevel only logs the warnings, such as the skipped groups and
+	// instances, and the errors.
+	WarnLogLevel = "warn"
+
+	// ErrorLogLevel only logs the errors.
+	ErrorLogLevel = "error"
+
+	// maxAPICallParamsLength is the length after which the parameters of the
+	// AWS API calls are truncated in the debug logs.
+	maxAPICallParamsLength = 500
+)
+
+// logLevels orders the log levels by their severity.
+var logLevels = map[string]int{
+	DebugLogLevel: 0,
+	InfoLogLevel:  1,
+	WarnLogLevel:  2,
+	ErrorLogLevel: 3,
+}
+
+// warningPattern matches the messages logged at the info level which are
+// warnings.
+var warningPattern = regexp.MustCompile(`(?i)\b(?:warning|skipping|ignoring|not replacing|not converting|not draining|would exceed)\b`)
+
+// debugEnabled tells if the debug messages are logged, so that they are only
+// formatted when needed.
+var debugEnabled bool
+
+// messageLevel returns the level of a message logged at the given level,
+// raised to error or warn by its content, since the messages of the logger
+// aren't leveled otherwise.
+func messageLevel(level, message string) string {
+	if level != InfoLogLevel {
+		return level
+	}
+	if errorPattern.MatchString(message) {
+		return ErrorLogLevel
+	}
+	if warningPattern.MatchString(message) {
+		return WarnLogLevel
+	}
+	return InfoLogLevel
+}
+
+// isLogLevelEnabled tells if the messages of the level are logged when only
+// logging the messages at or above the min level.
+func isLogLevelEnabled(level, min string) bool {
+	return logLevels[level] >= logLevels[min]
+}
+
+// logAPICalls makes the clients created from the session log a summary of
+// their AWS API calls at the debug level.
+func logAPICalls(sess *session.Session) *session.Session {
+	sess.Handlers.Complete.PushBack(logAPICall)
+	return sess
+}
+
+// logAPICall logs the request parameters, the HTTP status and the error of an
+// AWS API call, with its duration.
+func logAPICall(r *request.Request) {
+	if !debugEnabled {
+		return
+	}
+
+	params := strings.Join(strings.Fields(awsutil.Prettify(r.Params)), " ")
+	if len(params) > maxAPICallParamsLength {
+		params = params[:maxAPICallParamsLength] + "..."
+	}
+
+	status := 0
+	if r.HTTPResponse != nil {
+		status = r.HTTPResponse.StatusCode
+	}
+	result := "succeeded"
+	if r.Error != nil {
+		result = "failed: " + r.Error.Error()
+	}
+
+	debug.Printf("API call %s %s:%s took %s, status %d, request ID %s, %s, request %s\n",
+		aws.StringValue(r.Config.Region), r.ClientInfo.ServiceName, r.Operation.Name,
+		time.Since(r.AttemptTime).Round(time.Millisecond), status, r.RequestID, result, params)
+}
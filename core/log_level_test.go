@@ -0,0 +1,115 @@
+package autospotting
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestMessageLevel(t *testing.T) {
+	tests := []struct {
+		level   string
+		message string
+		want    string
+	}{
+		{InfoLogLevel, "Launching spot instance of type m5.large", InfoLogLevel},
+		{InfoLogLevel, "Skipping group web because it's suspended", WarnLogLevel},
+		{InfoLogLevel, "Couldn't drain i-0123456789abcdef0: timeout", ErrorLogLevel},
+		{DebugLogLevel, "Couldn't describe the instance types, retrying", DebugLogLevel},
+	}
+	for _, tt := range tests {
+		if got := messageLevel(tt.level, tt.message); got != tt.want {
+			t.Errorf("messageLevel(%s, %q) = %s, want %s", tt.level, tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestLogWriterLevels(t *testing.T) {
+	messages := []string{
+		"Launching spot instance of type m5.large",
+		"Skipping group web because it's suspended",
+		"Failed to attach i-0123456789abcdef0",
+	}
+
+	tests := []struct {
+		min  string
+		want int
+	}{
+		{DebugLogLevel, 3},
+		{InfoLogLevel, 3},
+		{WarnLogLevel, 2},
+		{ErrorLogLevel, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.min, func(t *testing.T) {
+			var out bytes.Buffer
+			l := log.New(newLogWriter(&out, InfoLogLevel, tt.min, TextLogFormat), "", 0)
+			for _, m := range messages {
+				l.Println(m)
+			}
+			if got := strings.Count(out.String(), "\n"); got != tt.want {
+				t.Errorf("logged %d messages at the %s level, want %d:\n%s", got, tt.min, tt.want, out.String())
+			}
+		})
+	}
+}
+
+func TestSetupLoggingLevel(t *testing.T) {
+	defer disableLogging()
+
+	var out bytes.Buffer
+	setupLogging(&Config{LogFile: &out, LogLevel: DebugLogLevel})
+	debug.Println("comparing prices")
+	if !debugEnabled || !strings.Contains(out.String(), "comparing prices") {
+		t.Errorf("the debug level didn't log the debug messages: %q", out.String())
+	}
+
+	out.Reset()
+	setupLogging(&Config{LogFile: &out, LogLevel: "verbose"})
+	debug.Println("comparing prices")
+	logger.Println("replacing")
+	if debugEnabled || out.String() != "replacing\n" {
+		t.Errorf("an invalid log level didn't default to info: %q", out.String())
+	}
+}
+
+func TestLogAPICall(t *testing.T) {
+	defer disableLogging()
+
+	var out bytes.Buffer
+	setupLogging(&Config{LogFile: &out, LogLevel: DebugLogLevel})
+
+	r := &request.Request{
+		Config:       aws.Config{Region: aws.String("us-east-1")},
+		ClientInfo:   metadata.ClientInfo{ServiceName: "ec2"},
+		Operation:    &request.Operation{Name: "DescribeInstances"},
+		Params:       &ec2.DescribeInstancesInput{InstanceIds: []*string{aws.String("i-0123456789abcdef0")}},
+		HTTPResponse: &http.Response{StatusCode: 403},
+		RequestID:    "req-1",
+		Error:        errors.New("UnauthorizedOperation"),
+	}
+	logAPICall(r)
+
+	for _, want := range []string{"us-east-1 ec2:DescribeInstances", "status 403", "request ID req-1",
+		"failed: UnauthorizedOperation", "i-0123456789abcdef0"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("logAPICall() logged %q, missing %q", out.String(), want)
+		}
+	}
+
+	out.Reset()
+	setupLogging(&Config{LogFile: ioutil.Discard})
+	logAPICall(r)
+	if out.Len() != 0 {
+		t.Errorf("logAPICall() logged %q above the debug level", out.String())
+	}
+}
@@ -1,14 +1,15 @@
 package autospotting
 
 import (
+	"fmt"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"strings"
 	"sync"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
 )
@@ -22,23 +23,118 @@ func Run(cfg *Config) {
 
 	setupLogging(cfg)
 
-	debug.Println(*cfg)
+	cfg.runID = newRunID()
+	logger.Println("Starting run", cfg.runID)
 
-	// use this only to list all the other regions
-	ec2Conn := connectEC2(cfg.MainRegion)
+	cfg.changes = newChangeLog()
+	defer submitChangeTicket(cfg)
+
+	cfg.metrics = newRunMetrics(cfg.changes.start)
+	defer publishRunMetrics(cfg)
+
+	cfg.replacements = newReplacementLimiter(cfg.MaxReplacementsPerRun)
+
+	cfg.launchTraces = &launchTraceLog{}
+	cfg.compositions = &compositionLog{}
+	defer storeRunArtifact(cfg)
+
+	cfg.spotAdvisor = &spotAdvisor{}
+	cfg.spotPrices = &spotPriceCache{}
+
+	cfg.notificationRoutes = loadNotificationRoutes(cfg)
+	defer notifyRunSummary(cfg)
+
+	if cfg.groupErrors == nil {
+		cfg.groupErrors = newNotificationThrottle(cfg.NotificationWindow, cfg.NotificationRateLimit)
+	}
+	defer func() { cfg.groupErrors.flush(cfg, time.Now()) }()
+
+	if cfg.DryRun {
+		cfg.AuditMode = true
+	}
+
+	if cfg.AuditMode {
+		logger.Println("Running in audit mode, the mutating AWS API calls are only reported")
+		cfg.audit = newAuditLog()
+		defer cfg.audit.report()
+	}
+
+	// the groups aren't changed in audit mode, so they aren't locked either
+	if !cfg.AuditMode {
+		cfg.locks = newGroupLocker(cfg, cfg.runID)
+	}
+
+	if err := ValidateFeatures(cfg.Features); err != nil {
+		logger.Println("Ignoring", err.Error())
+	}
+
+	ownership := cfg.parseOwnership()
+	cfg.ownership = &ownership
+
+	debug.Println(*cfg)
 
 	addDefaultFilteringMode(cfg)
 	addDefaultFilter(cfg)
 
+	if cfg.CommandQueueURL != "" {
+		ProcessCommandQueue(cfg)
+	}
+
+	defer logAccountSummaries(cfg)
+
+	for _, p := range profileConfigs(cfg) {
+		for _, c := range accountConfigs(p) {
+			if err := processPartition(c); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// processPartition processes the regions available with the credentials of
+// the given configuration. It returns an error when the rest of the run has
+// to be aborted.
+func processPartition(cfg *Config) error {
+	if cfg.profile != "" {
+		logger.Println("Processing the regions of the credential profile", cfg.profile)
+	}
+	if cfg.accountID != "" {
+		logger.Println("Processing the regions of account", cfg.accountID, "using the role", cfg.role)
+	}
+
+	// use this only to list all the other regions
+	ec2Conn := connectEC2(cfg)
+
 	allRegions, err := getRegions(ec2Conn)
 
 	if err != nil {
 		logger.Println(err.Error())
-		return
+		return nil
+	}
+
+	if cfg.CanaryASGs != "" {
+		if err := processCanaries(allRegions, cfg); err != nil {
+			logger.Println("Aborting the run,", err.Error())
+			notify(cfg, ErrorEvent, "AutoSpotting run aborted after canary failures",
+				fmt.Sprintf("Run %s was aborted before processing the remaining groups: %s",
+					cfg.runID, err.Error()))
+			return err
+		}
+	}
+
+	if cfg.BatchComputeEnvironments != "" {
+		processBatchComputeEnvironments(allRegions, cfg)
 	}
 
 	processRegions(allRegions, cfg)
+	return nil
+}
 
+// newRunID generates the identifier of a run, tagged on the spot instances
+// launched during that run.
+func newRunID() string {
+	return fmt.Sprintf("%s-%04x", time.Now().UTC().Format("20060102T150405Z"),
+		rand.Intn(0x10000))
 }
 
 func addDefaultFilteringMode(cfg *Config) {
@@ -67,14 +163,27 @@ func disableLogging() {
 }
 
 func setupLogging(cfg *Config) {
-	logger = log.New(cfg.LogFile, "", cfg.LogFlag)
-
+	min := cfg.LogLevel
 	if os.Getenv("AUTOSPOTTING_DEBUG") == "true" {
-		debug = log.New(cfg.LogFile, "", cfg.LogFlag)
+		min = DebugLogLevel
+	} else if _, ok := logLevels[min]; !ok {
+		min = InfoLogLevel
+	}
+
+	flag := cfg.LogFlag
+	if cfg.LogFormat == JSONLogFormat {
+		// the time is a field of the JSON objects
+		flag &= log.Lshortfile | log.Llongfile
+	}
+
+	logger = log.New(newLogWriter(cfg.LogFile, InfoLogLevel, min, cfg.LogFormat), "", flag)
+
+	if min == DebugLogLevel {
+		debug = log.New(newLogWriter(cfg.LogFile, DebugLogLevel, min, cfg.LogFormat), "", flag)
 	} else {
 		debug = log.New(ioutil.Discard, "", 0)
 	}
-
+	debugEnabled = min == DebugLogLevel
 }
 
 // processAllRegions iterates all regions in parallel, and replaces instances
@@ -87,13 +196,15 @@ func processRegions(regions []string, cfg *Config) {
 	for _, r := range regions {
 
 		wg.Add(1)
-		r := region{name: r, conf: cfg}
+		r := region{name: r, conf: cfg, services: connections{provider: cfg.ClientProvider, profile: cfg.profile, credentials: cfg.credentials, audit: cfg.audit}}
 
 		go func() {
 
 			if r.enabled() {
 				logger.Printf("Enabled to run in %s, processing region.\n", r.name)
+				endTrace := traceRegion(r.name)
 				r.processRegion()
+				endTrace()
 			} else {
 				debug.Println("Not enabled to run in", r.name)
 				debug.Println("List of enabled regions:", cfg.Regions)
@@ -105,15 +216,13 @@ func processRegions(regions []string, cfg *Config) {
 	wg.Wait()
 }
 
-func connectEC2(region string) *ec2.EC2 {
+func connectEC2(cfg *Config) ec2iface.EC2API {
 
-	sess, err := session.NewSession()
-	if err != nil {
-		panic(err)
+	if cfg.ClientProvider != nil {
+		return cfg.ClientProvider.EC2(cfg.MainRegion)
 	}
 
-	return ec2.New(sess,
-		aws.NewConfig().WithRegion(region))
+	return ec2.New(newSession(cfg, cfg.MainRegion))
 }
 
 // getRegions generates a list of AWS regions.
@@ -0,0 +1,184 @@
+package autospotting
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+)
+
+// accountDimension is the dimension of the metrics of the accounts processed
+// with cross-account roles.
+const accountDimension = "AccountId"
+
+// maxMetricDataPerCall is the number of metrics sent by each PutMetricData
+// call.
+const maxMetricDataPerCall = 20
+
+// runMetrics counts the groups processed during a run and the capacity
+// drifts seen after the replacements, published as CloudWatch metrics at the
+// end of the run together with the replacements.
+type runMetrics struct {
+	start    time.Time
+	groups   int64
+	failures int64
+	drifts   int64
+
+	// the totals the metrics of an account are also counted in
+	parent *runMetrics
+
+	// the metrics of each of the accounts processed with cross-account
+	// roles, by account ID
+	mu       sync.Mutex
+	accounts map[string]*runMetrics
+}
+
+func newRunMetrics(start time.Time) *runMetrics {
+	return &runMetrics{start: start}
+}
+
+// groupProcessed counts a processed group, and whether its processing failed.
+func (m *runMetrics) groupProcessed(err error) {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.groups, 1)
+	if err != nil && !isAuditModeError(err) {
+		atomic.AddInt64(&m.failures, 1)
+	}
+	m.parent.groupProcessed(err)
+}
+
+// capacityDrift counts a group whose desired capacity changed during a
+// replacement.
+func (m *runMetrics) capacityDrift() {
+	if m == nil {
+		return
+	}
+	atomic.AddInt64(&m.drifts, 1)
+	m.parent.capacityDrift()
+}
+
+// account returns the metrics of the given account, also counted in the
+// totals.
+func (m *runMetrics) account(id string) *runMetrics {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.accounts == nil {
+		m.accounts = map[string]*runMetrics{}
+	}
+	if m.accounts[id] == nil {
+		m.accounts[id] = &runMetrics{start: m.start, parent: m}
+	}
+	return m.accounts[id]
+}
+
+// metricData returns the metrics of the run, given the replacements it did,
+// followed by those of each account processed with a cross-account role.
+func (m *runMetrics) metricData(changes []change, end time.Time) []*cloudwatch.MetricDatum {
+	data := append(m.countData(changes, end),
+		metricDatum("RunDuration", cloudwatch.StandardUnitSeconds, end.Sub(m.start).Seconds(), end))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var ids []string
+	for id := range m.accounts {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		var accountChanges []change
+		for _, c := range changes {
+			if c.Account == id {
+				accountChanges = append(accountChanges, c)
+			}
+		}
+		dimension := &cloudwatch.Dimension{Name: aws.String(accountDimension), Value: aws.String(id)}
+		for _, d := range m.accounts[id].countData(accountChanges, end) {
+			d.Dimensions = []*cloudwatch.Dimension{dimension}
+			data = append(data, d)
+		}
+	}
+	return data
+}
+
+// countData returns the counters of the metrics, given the replacements.
+func (m *runMetrics) countData(changes []change, end time.Time) []*cloudwatch.MetricDatum {
+	savings := 0.0
+	for _, c := range changes {
+		savings += c.HourlySavings
+	}
+
+	return []*cloudwatch.MetricDatum{
+		metricDatum("InstancesReplaced", cloudwatch.StandardUnitCount, float64(len(changes)), end),
+		metricDatum("GroupsProcessed", cloudwatch.StandardUnitCount, float64(atomic.LoadInt64(&m.groups)), end),
+		metricDatum("GroupFailures", cloudwatch.StandardUnitCount, float64(atomic.LoadInt64(&m.failures)), end),
+		metricDatum("CapacityDrifts", cloudwatch.StandardUnitCount, float64(atomic.LoadInt64(&m.drifts)), end),
+		metricDatum("EstimatedHourlySavings", cloudwatch.StandardUnitNone, savings, end),
+	}
+}
+
+func metricDatum(name, unit string, value float64, end time.Time) *cloudwatch.MetricDatum {
+	return &cloudwatch.MetricDatum{
+		MetricName: aws.String(name),
+		Timestamp:  aws.Time(end),
+		Unit:       aws.String(unit),
+		Value:      aws.Float64(value),
+	}
+}
+
+// publishRunMetrics sends the metrics of the run to CloudWatch, when a
+// metrics namespace is configured.
+func publishRunMetrics(cfg *Config) {
+	if cfg.MetricsNamespace == "" || cfg.metrics == nil {
+		return
+	}
+
+	var svc cloudwatchiface.CloudWatchAPI
+	if cfg.ClientProvider == nil {
+		svc = cloudwatch.New(newSession(cfg, cfg.MainRegion))
+	} else if p, ok := cfg.ClientProvider.(CloudWatchClientProvider); ok {
+		svc = p.CloudWatch(cfg.MainRegion)
+	} else {
+		logger.Println("Not publishing the run metrics, the client provider doesn't create CloudWatch clients")
+		return
+	}
+
+	var changes []change
+	if cfg.changes != nil {
+		changes = cfg.changes.list()
+	}
+
+	end := time.Now()
+	data := append(cfg.metrics.metricData(changes, end), compositionData(cfg.compositions.list(), end)...)
+	if err := putRunMetrics(svc, cfg.MetricsNamespace, data); err != nil {
+		logger.Println("Failed to publish the run metrics:", err.Error())
+	}
+}
+
+func putRunMetrics(svc cloudwatchiface.CloudWatchAPI, namespace string, data []*cloudwatch.MetricDatum) error {
+	for start := 0; start < len(data); start += maxMetricDataPerCall {
+		end := start + maxMetricDataPerCall
+		if end > len(data) {
+			end = len(data)
+		}
+		_, err := svc.PutMetricData(&cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(namespace),
+			MetricData: data[start:end],
+		})
+		if err != nil {
+			return err
+		}
+	}
+	logger.Println("Published", len(data), "run metrics to the CloudWatch namespace", namespace)
+	return nil
+}
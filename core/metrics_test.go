@@ -0,0 +1,122 @@
+package autospotting
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func TestRunMetrics(t *testing.T) {
+	start := time.Date(2020, 11, 20, 8, 20, 0, 0, time.UTC)
+	m := newRunMetrics(start)
+
+	m.groupProcessed(nil)
+	m.groupProcessed(errors.New("failed"))
+	m.groupProcessed(awserr.New(auditModeErrorCode, "blocked", nil))
+	m.capacityDrift()
+
+	var missing *runMetrics
+	missing.groupProcessed(nil)
+	missing.capacityDrift()
+
+	changes := []change{{HourlySavings: 0.05}, {HourlySavings: 0.1}}
+	data := m.metricData(changes, start.Add(90*time.Second))
+
+	want := map[string]float64{
+		"InstancesReplaced":      2,
+		"GroupsProcessed":        3,
+		"GroupFailures":          1,
+		"CapacityDrifts":         1,
+		"EstimatedHourlySavings": 0.15,
+		"RunDuration":            90,
+	}
+	if len(data) != len(want) {
+		t.Fatalf("metricData() returned %d metrics, want %d", len(data), len(want))
+	}
+	for _, d := range data {
+		name := aws.StringValue(d.MetricName)
+		if got := aws.Float64Value(d.Value); got < want[name]-1e-9 || got > want[name]+1e-9 {
+			t.Errorf("metric %s = %v, want %v", name, got, want[name])
+		}
+	}
+
+	svc := &mockCloudWatch{}
+	if err := putRunMetrics(svc, "AutoSpotting", data); err != nil {
+		t.Fatalf("putRunMetrics() error = %v", err)
+	}
+	if len(svc.pmd) != 1 || aws.StringValue(svc.pmd[0].Namespace) != "AutoSpotting" ||
+		len(svc.pmd[0].MetricData) != len(want) {
+		t.Errorf("putRunMetrics() sent %v", svc.pmd)
+	}
+
+	svc.pmderr = errors.New("AccessDenied")
+	if err := putRunMetrics(svc, "AutoSpotting", data); err == nil {
+		t.Error("putRunMetrics() expected an error")
+	}
+}
+
+func TestRunMetricsOfAccounts(t *testing.T) {
+	start := time.Date(2020, 11, 20, 8, 20, 0, 0, time.UTC)
+	m := newRunMetrics(start)
+
+	m.account("111111111111").groupProcessed(nil)
+	m.account("111111111111").capacityDrift()
+	m.account("222222222222").groupProcessed(errors.New("failed"))
+	m.groupProcessed(nil)
+
+	var missing *runMetrics
+	missing.account("111111111111").groupProcessed(nil)
+
+	changes := []change{
+		{Account: "111111111111", HourlySavings: 0.05},
+		{Account: "111111111111", HourlySavings: 0.1},
+		{Account: "222222222222", HourlySavings: 0.2},
+	}
+	data := m.metricData(changes, start.Add(90*time.Second))
+
+	want := map[string]float64{
+		"InstancesReplaced":                   3,
+		"GroupsProcessed":                     3,
+		"GroupFailures":                       1,
+		"CapacityDrifts":                      1,
+		"EstimatedHourlySavings":              0.35,
+		"RunDuration":                         90,
+		"111111111111/InstancesReplaced":      2,
+		"111111111111/GroupsProcessed":        1,
+		"111111111111/GroupFailures":          0,
+		"111111111111/CapacityDrifts":         1,
+		"111111111111/EstimatedHourlySavings": 0.15,
+		"222222222222/InstancesReplaced":      1,
+		"222222222222/GroupsProcessed":        1,
+		"222222222222/GroupFailures":          1,
+		"222222222222/CapacityDrifts":         0,
+		"222222222222/EstimatedHourlySavings": 0.2,
+	}
+	if len(data) != len(want) {
+		t.Fatalf("metricData() returned %d metrics, want %d", len(data), len(want))
+	}
+	for _, d := range data {
+		name := aws.StringValue(d.MetricName)
+		for _, dim := range d.Dimensions {
+			if aws.StringValue(dim.Name) == accountDimension {
+				name = aws.StringValue(dim.Value) + "/" + name
+			}
+		}
+		if got := aws.Float64Value(d.Value); got < want[name]-1e-9 || got > want[name]+1e-9 {
+			t.Errorf("metric %s = %v, want %v", name, got, want[name])
+		}
+	}
+
+	svc := &mockCloudWatch{}
+	data = append(data, data...)
+	if err := putRunMetrics(svc, "AutoSpotting", data); err != nil {
+		t.Fatalf("putRunMetrics() error = %v", err)
+	}
+	if len(svc.pmd) != 2 || len(svc.pmd[0].MetricData) != maxMetricDataPerCall ||
+		len(svc.pmd[1].MetricData) != len(data)-maxMetricDataPerCall {
+		t.Errorf("putRunMetrics() sent %d calls", len(svc.pmd))
+	}
+}
@@ -0,0 +1,376 @@
+package autospotting
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// mixedInstancesMaxOverrides is the maximum number of instance types set in
+// the Mixed Instances Policy of a group
+const mixedInstancesMaxOverrides = 20
+
+// mixedInstancesLaunchTemplatePrefix prefixes the names of the launch
+// templates created from the launch configurations of the converted groups
+const mixedInstancesLaunchTemplatePrefix = "AutoSpotting-"
+
+// launchTemplateNotFoundErrorCode is returned when describing a launch
+// template by a name which doesn't exist
+const launchTemplateNotFoundErrorCode = "InvalidLaunchTemplateName.NotFoundException"
+
+// invalidLaunchTemplateNameChars matches the characters not allowed in the
+// names of the launch templates
+var invalidLaunchTemplateNameChars = regexp.MustCompile(`[^a-zA-Z0-9().\-/_]`)
+
+// reconcileMixedInstancesPolicy converts the group to a Mixed Instances Policy
+// running spot instances of diversified types above its minimum on-demand
+// capacity, and keeps it up to date with the configuration on the next runs.
+// From then on the spot instances are launched and replaced by AutoScaling.
+func (a *autoScalingGroup) reconcileMixedInstancesPolicy() error {
+	policy, err := a.desiredMixedInstancesPolicy()
+	if err != nil || policy == nil {
+		return err
+	}
+
+	if a.MixedInstancesPolicy != nil && sameMixedInstancesPolicy(a.MixedInstancesPolicy, policy) {
+		debug.Println(a.region.name, a.name, "Mixed Instances Policy already up to date")
+		return nil
+	}
+
+	logger.Println(a.region.name, a.name, "Updating the Mixed Instances Policy to", policy)
+	_, err = a.region.services.autoScaling.UpdateAutoScalingGroup(&autoscaling.UpdateAutoScalingGroupInput{
+		AutoScalingGroupName: a.AutoScalingGroupName,
+		MixedInstancesPolicy: policy,
+	})
+	if err != nil {
+		logger.Println(a.region.name, a.name, "Failed to update the Mixed Instances Policy:", err.Error())
+		return err
+	}
+	a.MixedInstancesPolicy = policy
+	return nil
+}
+
+// desiredMixedInstancesPolicy returns the Mixed Instances Policy of the group
+// according to its configuration, or nil when it can't be determined yet.
+func (a *autoScalingGroup) desiredMixedInstancesPolicy() (*autoscaling.MixedInstancesPolicy, error) {
+	instanceTypes := a.mixedInstancesTypes()
+	if len(instanceTypes) == 0 {
+		logger.Println(a.region.name, a.name,
+			"No instance types found for the Mixed Instances Policy, retrying on the next run")
+		return nil, nil
+	}
+
+	launchTemplate, err := a.mixedInstancesLaunchTemplate()
+	if err != nil {
+		return nil, err
+	}
+
+	var overrides []*autoscaling.LaunchTemplateOverrides
+	for _, instanceType := range instanceTypes {
+		overrides = append(overrides, &autoscaling.LaunchTemplateOverrides{
+			InstanceType: aws.String(instanceType),
+		})
+	}
+
+	return &autoscaling.MixedInstancesPolicy{
+		InstancesDistribution: &autoscaling.InstancesDistribution{
+			OnDemandAllocationStrategy:          aws.String("prioritized"),
+			OnDemandBaseCapacity:                aws.Int64(a.minOnDemand),
+			OnDemandPercentageAboveBaseCapacity: aws.Int64(0),
+			SpotAllocationStrategy:              aws.String("capacity-optimized"),
+		},
+		LaunchTemplate: &autoscaling.LaunchTemplate{
+			LaunchTemplateSpecification: launchTemplate,
+			Overrides:                   overrides,
+		},
+	}, nil
+}
+
+// mixedInstancesTypes returns the instance types of the Mixed Instances Policy,
+// starting with the current instance type of the group, used for its
+// on-demand capacity, followed by the cheapest compatible spot instance types.
+// These are sorted by name, so that the policy only changes when the cheapest
+// instance types change.
+func (a *autoScalingGroup) mixedInstancesTypes() []string {
+	inst := a.getAnyOnDemandInstance()
+	if inst == nil {
+		inst = a.getAnySpotInstance()
+	}
+	if inst == nil {
+		return nil
+	}
+
+	// the spot instance types are compared with the on-demand price, even when
+	// the group already runs spot instances
+	base := *inst
+	base.price = base.typeInfo.pricing.onDemand
+
+	instanceTypes := []string{base.typeInfo.instanceType}
+
+	candidates, err := base.getCompatibleSpotInstanceTypesListSortedAscendingByPrice(
+		a.getAllowedInstanceTypes(&base), a.getDisallowedInstanceTypes())
+	if err != nil {
+		logger.Println(a.region.name, a.name, err.Error())
+	}
+
+	for _, candidate := range candidates {
+		if len(instanceTypes) >= mixedInstancesMaxOverrides {
+			break
+		}
+		if indexOf(instanceTypes, candidate.instanceType) < 0 {
+			instanceTypes = append(instanceTypes, candidate.instanceType)
+		}
+	}
+	sort.Strings(instanceTypes[1:])
+	return instanceTypes
+}
+
+// mixedInstancesLaunchTemplate returns the launch template of the Mixed
+// Instances Policy, which is the one already used by the group, or else one
+// created from its launch configuration.
+func (a *autoScalingGroup) mixedInstancesLaunchTemplate() (*autoscaling.LaunchTemplateSpecification, error) {
+	if a.MixedInstancesPolicy != nil && a.MixedInstancesPolicy.LaunchTemplate != nil {
+		return a.MixedInstancesPolicy.LaunchTemplate.LaunchTemplateSpecification, nil
+	}
+	if a.LaunchTemplate != nil {
+		return a.LaunchTemplate, nil
+	}
+	return a.launchTemplateFromLaunchConfiguration()
+}
+
+// mixedInstancesOverrides returns the instance types of the overrides of the
+// Mixed Instances Policy the group already has, in their priority order, or
+// nil when it has no overrides. These are the spot candidates of its
+// instances, instead of all the instance types of the region. The overrides
+// using their own launch template, or having a different weight than the
+// given instance type, are left out since the spot instances are launched
+// from the launch template of the group and replace its instances one for
+// one.
+func (a *autoScalingGroup) mixedInstancesOverrides(instanceType string) []string {
+	if a.MixedInstancesPolicy == nil || a.MixedInstancesPolicy.LaunchTemplate == nil ||
+		len(a.MixedInstancesPolicy.LaunchTemplate.Overrides) == 0 {
+		return nil
+	}
+	overrides := a.MixedInstancesPolicy.LaunchTemplate.Overrides
+
+	weight := func(o *autoscaling.LaunchTemplateOverrides) string {
+		if w := aws.StringValue(o.WeightedCapacity); w != "" {
+			return w
+		}
+		return "1"
+	}
+	instanceWeight := "1"
+	for _, o := range overrides {
+		if aws.StringValue(o.InstanceType) == instanceType {
+			instanceWeight = weight(o)
+		}
+	}
+
+	instanceTypes := []string{}
+	for _, o := range overrides {
+		t := aws.StringValue(o.InstanceType)
+		switch {
+		case t == "" || indexOf(instanceTypes, t) >= 0:
+			continue
+		case o.LaunchTemplateSpecification != nil:
+			debug.Println(a.region.name, a.name, "Skipping the override", t, "using its own launch template")
+		case weight(o) != instanceWeight:
+			debug.Println(a.region.name, a.name, "Skipping the override", t, "weighted", weight(o),
+				"instead of", instanceWeight)
+		default:
+			instanceTypes = append(instanceTypes, t)
+		}
+	}
+	return instanceTypes
+}
+
+// prioritizeOverrides sorts the candidates in the priority order of the
+// overrides of the Mixed Instances Policy.
+func prioritizeOverrides(candidates []instanceTypeInformation, overrides []string) []instanceTypeInformation {
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return indexOf(overrides, candidates[i].instanceType) < indexOf(overrides, candidates[j].instanceType)
+	})
+	return candidates
+}
+
+// launchTemplateName returns the name of the launch template created from a
+// launch configuration.
+func launchTemplateName(launchConfigurationName string) string {
+	name := invalidLaunchTemplateNameChars.ReplaceAllString(
+		mixedInstancesLaunchTemplatePrefix+launchConfigurationName, "-")
+	if len(name) > 128 {
+		name = name[:128]
+	}
+	return name
+}
+
+// launchTemplateFromLaunchConfiguration creates a launch template with the
+// settings of the launch configuration of the group, unless it was already
+// created on a previous run.
+func (a *autoScalingGroup) launchTemplateFromLaunchConfiguration() (*autoscaling.LaunchTemplateSpecification, error) {
+	if err := a.loadLaunchConfiguration(); err != nil {
+		return nil, err
+	}
+
+	svc := a.region.services.ec2
+	name := launchTemplateName(aws.StringValue(a.launchConfiguration.LaunchConfigurationName))
+
+	resp, err := svc.DescribeLaunchTemplates(&ec2.DescribeLaunchTemplatesInput{
+		LaunchTemplateNames: []*string{aws.String(name)},
+	})
+	if err == nil && len(resp.LaunchTemplates) > 0 {
+		return &autoscaling.LaunchTemplateSpecification{
+			LaunchTemplateId: resp.LaunchTemplates[0].LaunchTemplateId,
+			Version:          aws.String("$Default"),
+		}, nil
+	}
+	if aerr, ok := err.(awserr.Error); err != nil && (!ok || aerr.Code() != launchTemplateNotFoundErrorCode) {
+		logger.Println(a.region.name, a.name, "Failed to describe the launch template", name, err.Error())
+		return nil, err
+	}
+
+	logger.Println(a.region.name, a.name, "Creating the launch template", name,
+		"from the launch configuration", *a.launchConfiguration.LaunchConfigurationName)
+
+	created, err := svc.CreateLaunchTemplate(&ec2.CreateLaunchTemplateInput{
+		LaunchTemplateName: aws.String(name),
+		LaunchTemplateData: a.launchConfiguration.launchTemplateData(),
+	})
+	if err != nil {
+		logger.Println(a.region.name, a.name, "Failed to create the launch template", name, err.Error())
+		return nil, err
+	}
+
+	return &autoscaling.LaunchTemplateSpecification{
+		LaunchTemplateId: created.LaunchTemplate.LaunchTemplateId,
+		Version:          aws.String("$Default"),
+	}, nil
+}
+
+// launchTemplateData converts the launch configuration to the data of a
+// launch template.
+func (lc *launchConfiguration) launchTemplateData() *ec2.RequestLaunchTemplateData {
+	data := &ec2.RequestLaunchTemplateData{
+		EbsOptimized: lc.EbsOptimized,
+		ImageId:      lc.ImageId,
+		InstanceType: lc.InstanceType,
+		UserData:     lc.UserData,
+	}
+
+	if aws.StringValue(lc.KeyName) != "" {
+		data.KeyName = lc.KeyName
+	}
+
+	if profile := aws.StringValue(lc.IamInstanceProfile); profile != "" {
+		data.IamInstanceProfile = &ec2.LaunchTemplateIamInstanceProfileSpecificationRequest{}
+		if strings.HasPrefix(profile, "arn:") {
+			data.IamInstanceProfile.Arn = aws.String(profile)
+		} else {
+			data.IamInstanceProfile.Name = aws.String(profile)
+		}
+	}
+
+	if lc.InstanceMonitoring != nil {
+		data.Monitoring = &ec2.LaunchTemplatesMonitoringRequest{
+			Enabled: lc.InstanceMonitoring.Enabled,
+		}
+	}
+
+	if lc.PlacementTenancy != nil {
+		data.Placement = &ec2.LaunchTemplatePlacementRequest{Tenancy: lc.PlacementTenancy}
+	}
+
+	if lc.MetadataOptions != nil {
+		data.MetadataOptions = &ec2.LaunchTemplateInstanceMetadataOptionsRequest{
+			HttpEndpoint:            lc.MetadataOptions.HttpEndpoint,
+			HttpPutResponseHopLimit: lc.MetadataOptions.HttpPutResponseHopLimit,
+			HttpTokens:              lc.MetadataOptions.HttpTokens,
+		}
+	}
+
+	for _, bdm := range lc.BlockDeviceMappings {
+		mapping := &ec2.LaunchTemplateBlockDeviceMappingRequest{
+			DeviceName:  bdm.DeviceName,
+			VirtualName: bdm.VirtualName,
+		}
+		if bdm.Ebs != nil {
+			mapping.Ebs = &ec2.LaunchTemplateEbsBlockDeviceRequest{
+				DeleteOnTermination: bdm.Ebs.DeleteOnTermination,
+				Encrypted:           bdm.Ebs.Encrypted,
+				Iops:                bdm.Ebs.Iops,
+				SnapshotId:          bdm.Ebs.SnapshotId,
+				VolumeSize:          bdm.Ebs.VolumeSize,
+				VolumeType:          bdm.Ebs.VolumeType,
+			}
+		}
+		// launch templates suppress the devices using an empty string
+		if aws.BoolValue(bdm.NoDevice) {
+			mapping.NoDevice = aws.String("")
+		}
+		data.BlockDeviceMappings = append(data.BlockDeviceMappings, mapping)
+	}
+
+	var securityGroupIDs, securityGroupNames []*string
+	for _, sg := range lc.SecurityGroups {
+		if strings.HasPrefix(aws.StringValue(sg), "sg-") {
+			securityGroupIDs = append(securityGroupIDs, sg)
+		} else {
+			securityGroupNames = append(securityGroupNames, sg)
+		}
+	}
+
+	if lc.AssociatePublicIpAddress != nil {
+		// the public IP address can only be set on the network interface, which
+		// then also needs the security groups
+		data.NetworkInterfaces = []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
+			{
+				AssociatePublicIpAddress: lc.AssociatePublicIpAddress,
+				DeviceIndex:              aws.Int64(0),
+				Groups:                   securityGroupIDs,
+			},
+		}
+	} else {
+		data.SecurityGroupIds = securityGroupIDs
+		data.SecurityGroups = securityGroupNames
+	}
+
+	return data
+}
+
+// sameMixedInstancesPolicy tells if the current Mixed Instances Policy of a
+// group already has the settings managed by AutoSpotting.
+func sameMixedInstancesPolicy(current, desired *autoscaling.MixedInstancesPolicy) bool {
+	if current.InstancesDistribution == nil || current.LaunchTemplate == nil ||
+		current.LaunchTemplate.LaunchTemplateSpecification == nil {
+		return false
+	}
+
+	cd, dd := current.InstancesDistribution, desired.InstancesDistribution
+	if aws.Int64Value(cd.OnDemandBaseCapacity) != aws.Int64Value(dd.OnDemandBaseCapacity) ||
+		aws.Int64Value(cd.OnDemandPercentageAboveBaseCapacity) != aws.Int64Value(dd.OnDemandPercentageAboveBaseCapacity) ||
+		aws.StringValue(cd.SpotAllocationStrategy) != aws.StringValue(dd.SpotAllocationStrategy) {
+		return false
+	}
+
+	cl, dl := current.LaunchTemplate.LaunchTemplateSpecification, desired.LaunchTemplate.LaunchTemplateSpecification
+	if aws.StringValue(cl.LaunchTemplateId) != aws.StringValue(dl.LaunchTemplateId) ||
+		aws.StringValue(cl.LaunchTemplateName) != aws.StringValue(dl.LaunchTemplateName) ||
+		aws.StringValue(cl.Version) != aws.StringValue(dl.Version) {
+		return false
+	}
+
+	if len(current.LaunchTemplate.Overrides) != len(desired.LaunchTemplate.Overrides) {
+		return false
+	}
+	for i, override := range current.LaunchTemplate.Overrides {
+		if aws.StringValue(override.InstanceType) != aws.StringValue(desired.LaunchTemplate.Overrides[i].InstanceType) {
+			return false
+		}
+	}
+	return true
+}
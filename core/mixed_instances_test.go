@@ -0,0 +1,383 @@
+package autospotting
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestLaunchTemplateName(t *testing.T) {
+	tests := []struct {
+		lcName string
+		want   string
+	}{
+		{lcName: "web-lc", want: "AutoSpotting-web-lc"},
+		{lcName: "web lc:v2", want: "AutoSpotting-web-lc-v2"},
+	}
+	for _, tt := range tests {
+		if got := launchTemplateName(tt.lcName); got != tt.want {
+			t.Errorf("launchTemplateName(%q) = %q, want %q", tt.lcName, got, tt.want)
+		}
+	}
+
+	if got := launchTemplateName(strings.Repeat("a", 200)); len(got) != 128 {
+		t.Errorf("launchTemplateName() returned %d characters, want 128", len(got))
+	}
+}
+
+func TestLaunchTemplateData(t *testing.T) {
+	lc := &launchConfiguration{LaunchConfiguration: &autoscaling.LaunchConfiguration{
+		LaunchConfigurationName:  aws.String("web-lc"),
+		ImageId:                  aws.String("ami-123"),
+		InstanceType:             aws.String("m5.large"),
+		KeyName:                  aws.String(""),
+		UserData:                 aws.String("IyEvYmluL3No"),
+		IamInstanceProfile:       aws.String("arn:aws:iam::123456789012:instance-profile/web"),
+		AssociatePublicIpAddress: aws.Bool(true),
+		SecurityGroups:           aws.StringSlice([]string{"sg-1", "sg-2"}),
+		InstanceMonitoring:       &autoscaling.InstanceMonitoring{Enabled: aws.Bool(true)},
+		BlockDeviceMappings: []*autoscaling.BlockDeviceMapping{
+			{
+				DeviceName: aws.String("/dev/xvda"),
+				Ebs:        &autoscaling.Ebs{VolumeSize: aws.Int64(20), VolumeType: aws.String("gp2")},
+			},
+			{DeviceName: aws.String("/dev/xvdb"), NoDevice: aws.Bool(true)},
+		},
+	}}
+
+	want := &ec2.RequestLaunchTemplateData{
+		ImageId:      aws.String("ami-123"),
+		InstanceType: aws.String("m5.large"),
+		UserData:     aws.String("IyEvYmluL3No"),
+		IamInstanceProfile: &ec2.LaunchTemplateIamInstanceProfileSpecificationRequest{
+			Arn: aws.String("arn:aws:iam::123456789012:instance-profile/web"),
+		},
+		Monitoring: &ec2.LaunchTemplatesMonitoringRequest{Enabled: aws.Bool(true)},
+		BlockDeviceMappings: []*ec2.LaunchTemplateBlockDeviceMappingRequest{
+			{
+				DeviceName: aws.String("/dev/xvda"),
+				Ebs: &ec2.LaunchTemplateEbsBlockDeviceRequest{
+					VolumeSize: aws.Int64(20),
+					VolumeType: aws.String("gp2"),
+				},
+			},
+			{DeviceName: aws.String("/dev/xvdb"), NoDevice: aws.String("")},
+		},
+		NetworkInterfaces: []*ec2.LaunchTemplateInstanceNetworkInterfaceSpecificationRequest{
+			{
+				AssociatePublicIpAddress: aws.Bool(true),
+				DeviceIndex:              aws.Int64(0),
+				Groups:                   aws.StringSlice([]string{"sg-1", "sg-2"}),
+			},
+		},
+	}
+
+	if got := lc.launchTemplateData(); !reflect.DeepEqual(got, want) {
+		t.Errorf("launchTemplateData() = %v, want %v", got, want)
+	}
+
+	lc.AssociatePublicIpAddress = nil
+	lc.IamInstanceProfile = aws.String("web")
+	lc.SecurityGroups = aws.StringSlice([]string{"sg-1", "default"})
+	got := lc.launchTemplateData()
+	if got.NetworkInterfaces != nil ||
+		!reflect.DeepEqual(got.SecurityGroupIds, aws.StringSlice([]string{"sg-1"})) ||
+		!reflect.DeepEqual(got.SecurityGroups, aws.StringSlice([]string{"default"})) {
+		t.Errorf("launchTemplateData() security groups %v %v %v, want them set on the instance",
+			got.NetworkInterfaces, got.SecurityGroupIds, got.SecurityGroups)
+	}
+	if aws.StringValue(got.IamInstanceProfile.Name) != "web" || got.IamInstanceProfile.Arn != nil {
+		t.Errorf("launchTemplateData() instance profile %v, want it set by name", got.IamInstanceProfile)
+	}
+}
+
+func TestReconcileMixedInstancesPolicy(t *testing.T) {
+	notFound := awserr.New(launchTemplateNotFoundErrorCode, "not found", nil)
+
+	desiredDistribution := &autoscaling.InstancesDistribution{
+		OnDemandAllocationStrategy:          aws.String("prioritized"),
+		OnDemandBaseCapacity:                aws.Int64(1),
+		OnDemandPercentageAboveBaseCapacity: aws.Int64(0),
+		SpotAllocationStrategy:              aws.String("capacity-optimized"),
+	}
+	desiredOverrides := []*autoscaling.LaunchTemplateOverrides{
+		{InstanceType: aws.String("m5.large")},
+		{InstanceType: aws.String("m4.large")},
+		{InstanceType: aws.String("r5.large")},
+	}
+
+	tests := []struct {
+		name         string
+		group        func(a *autoScalingGroup)
+		dlto         *ec2.DescribeLaunchTemplatesOutput
+		dlterr       error
+		wantTemplate *autoscaling.LaunchTemplateSpecification
+		wantCreated  bool
+		wantUpdated  bool
+		wantErr      bool
+	}{
+		{
+			name:         "launch configuration converted to a new launch template",
+			dlterr:       notFound,
+			wantTemplate: &autoscaling.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-new"), Version: aws.String("$Default")},
+			wantCreated:  true,
+			wantUpdated:  true,
+		},
+		{
+			name: "launch template created on a previous run",
+			dlto: &ec2.DescribeLaunchTemplatesOutput{LaunchTemplates: []*ec2.LaunchTemplate{
+				{LaunchTemplateId: aws.String("lt-old")},
+			}},
+			wantTemplate: &autoscaling.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-old"), Version: aws.String("$Default")},
+			wantUpdated:  true,
+		},
+		{
+			name:    "failure describing the launch template",
+			dlterr:  awserr.New("UnauthorizedOperation", "denied", nil),
+			wantErr: true,
+		},
+		{
+			name: "group using a launch template",
+			group: func(a *autoScalingGroup) {
+				a.LaunchConfigurationName = nil
+				a.LaunchTemplate = &autoscaling.LaunchTemplateSpecification{
+					LaunchTemplateName: aws.String("web"), Version: aws.String("$Latest"),
+				}
+			},
+			wantTemplate: &autoscaling.LaunchTemplateSpecification{LaunchTemplateName: aws.String("web"), Version: aws.String("$Latest")},
+			wantUpdated:  true,
+		},
+		{
+			name: "policy already up to date",
+			group: func(a *autoScalingGroup) {
+				a.LaunchConfigurationName = nil
+				a.MixedInstancesPolicy = &autoscaling.MixedInstancesPolicy{
+					InstancesDistribution: desiredDistribution,
+					LaunchTemplate: &autoscaling.LaunchTemplate{
+						LaunchTemplateSpecification: &autoscaling.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-1")},
+						Overrides:                   desiredOverrides,
+					},
+				}
+			},
+		},
+		{
+			name: "policy with a different base capacity",
+			group: func(a *autoScalingGroup) {
+				a.LaunchConfigurationName = nil
+				a.MixedInstancesPolicy = &autoscaling.MixedInstancesPolicy{
+					InstancesDistribution: &autoscaling.InstancesDistribution{OnDemandBaseCapacity: aws.Int64(3)},
+					LaunchTemplate: &autoscaling.LaunchTemplate{
+						LaunchTemplateSpecification: &autoscaling.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-1")},
+						Overrides:                   desiredOverrides,
+					},
+				}
+			},
+			wantTemplate: &autoscaling.LaunchTemplateSpecification{LaunchTemplateId: aws.String("lt-1")},
+			wantUpdated:  true,
+		},
+		{
+			name: "no instances to determine the instance types",
+			group: func(a *autoScalingGroup) {
+				a.instances = makeInstancesWithCatalog(instanceMap{})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var updates []*autoscaling.UpdateAutoScalingGroupInput
+			var created []*ec2.CreateLaunchTemplateInput
+
+			r := &region{
+				name: "us-east-1",
+				conf: &Config{},
+				instanceTypeInformation: map[string]instanceTypeInformation{
+					"m5.large":  newTestTypeInfo("m5.large", 2, 0.096, 0.035),
+					"r5.large":  newTestTypeInfo("r5.large", 2, 0.126, 0.030),
+					"m4.large":  newTestTypeInfo("m4.large", 2, 0.1, 0.04),
+					"c5.xlarge": newTestTypeInfo("c5.xlarge", 2, 0.17, 0.2),
+				},
+				services: connections{
+					autoScaling: mockASG{
+						uasg: &updates,
+						dlco: &autoscaling.DescribeLaunchConfigurationsOutput{
+							LaunchConfigurations: []*autoscaling.LaunchConfiguration{
+								{LaunchConfigurationName: aws.String("web-lc"), ImageId: aws.String("ami-123")},
+							},
+						},
+					},
+					ec2: mockEC2{
+						dlto:   tt.dlto,
+						dlterr: tt.dlterr,
+						clt:    &created,
+						clto: &ec2.CreateLaunchTemplateOutput{
+							LaunchTemplate: &ec2.LaunchTemplate{LaunchTemplateId: aws.String("lt-new")},
+						},
+					},
+				},
+			}
+			a := &autoScalingGroup{
+				name:   "asg",
+				region: r,
+				Group: &autoscaling.Group{
+					AutoScalingGroupName:    aws.String("asg"),
+					LaunchConfigurationName: aws.String("web-lc"),
+				},
+				minOnDemand: 1,
+			}
+			onDemand := newTestInstance("i-ondemand", "m5.large", time.Hour)
+			onDemand.typeInfo, onDemand.price = r.instanceTypeInformation["m5.large"], 0.096
+			onDemand.region, onDemand.asg = r, a
+			a.instances = makeInstancesWithCatalog(instanceMap{"i-ondemand": onDemand})
+
+			if tt.group != nil {
+				tt.group(a)
+			}
+
+			err := a.reconcileMixedInstancesPolicy()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("reconcileMixedInstancesPolicy() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if tt.wantCreated != (len(created) == 1) {
+				t.Errorf("created launch templates %v, want created %v", created, tt.wantCreated)
+			}
+			if tt.wantCreated && aws.StringValue(created[0].LaunchTemplateName) != "AutoSpotting-web-lc" {
+				t.Errorf("created launch template %s", aws.StringValue(created[0].LaunchTemplateName))
+			}
+
+			if !tt.wantUpdated {
+				if len(updates) > 0 {
+					t.Errorf("unexpected group update %v", updates)
+				}
+				return
+			}
+			if len(updates) != 1 {
+				t.Fatalf("got %d group updates, want 1", len(updates))
+			}
+			policy := updates[0].MixedInstancesPolicy
+			if !reflect.DeepEqual(policy.LaunchTemplate.LaunchTemplateSpecification, tt.wantTemplate) {
+				t.Errorf("launch template %v, want %v", policy.LaunchTemplate.LaunchTemplateSpecification, tt.wantTemplate)
+			}
+			if !reflect.DeepEqual(policy.LaunchTemplate.Overrides, desiredOverrides) {
+				t.Errorf("overrides %v, want %v", policy.LaunchTemplate.Overrides, desiredOverrides)
+			}
+			if !reflect.DeepEqual(policy.InstancesDistribution, desiredDistribution) {
+				t.Errorf("instances distribution %v, want %v", policy.InstancesDistribution, desiredDistribution)
+			}
+		})
+	}
+}
+
+func TestMixedInstancesOverrides(t *testing.T) {
+	override := func(instanceType, weight string) *autoscaling.LaunchTemplateOverrides {
+		o := &autoscaling.LaunchTemplateOverrides{InstanceType: aws.String(instanceType)}
+		if weight != "" {
+			o.WeightedCapacity = aws.String(weight)
+		}
+		return o
+	}
+
+	tests := []struct {
+		name         string
+		overrides    []*autoscaling.LaunchTemplateOverrides
+		instanceType string
+		want         []string
+	}{
+		{name: "no overrides", instanceType: "m5.large"},
+		{
+			name: "priority order",
+			overrides: []*autoscaling.LaunchTemplateOverrides{
+				override("m5a.large", ""), override("m5.large", ""), override("m5a.large", ""),
+				override("c5.large", "1"),
+			},
+			instanceType: "m5.large",
+			want:         []string{"m5a.large", "m5.large", "c5.large"},
+		},
+		{
+			name: "weights",
+			overrides: []*autoscaling.LaunchTemplateOverrides{
+				override("m5.large", "2"), override("m5.xlarge", "4"), override("c5.large", "2"),
+			},
+			instanceType: "m5.large",
+			want:         []string{"m5.large", "c5.large"},
+		},
+		{
+			name: "own launch template",
+			overrides: []*autoscaling.LaunchTemplateOverrides{
+				override("m5.large", ""),
+				{
+					InstanceType: aws.String("m6g.large"),
+					LaunchTemplateSpecification: &autoscaling.LaunchTemplateSpecification{
+						LaunchTemplateName: aws.String("arm64"),
+					},
+				},
+			},
+			instanceType: "m5.large",
+			want:         []string{"m5.large"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name:   "asg",
+				region: &region{name: "us-east-1"},
+				Group: &autoscaling.Group{MixedInstancesPolicy: &autoscaling.MixedInstancesPolicy{
+					LaunchTemplate: &autoscaling.LaunchTemplate{Overrides: tt.overrides},
+				}},
+			}
+			got := a.mixedInstancesOverrides(tt.instanceType)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mixedInstancesOverrides() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpotReplacementCandidatesFromOverrides(t *testing.T) {
+	tests := []struct {
+		name      string
+		overrides []string
+		allowed   string
+		want      []string
+		wantErr   bool
+	}{
+		{name: "cheapest first without overrides", want: []string{"m5.large", "c5.large"}},
+		{name: "override priority", overrides: []string{"c5.large", "m5.large"}, want: []string{"c5.large", "m5.large"}},
+		{name: "overrides restrict the candidates", overrides: []string{"r5.large", "c5.large"}, want: []string{"c5.large"}},
+		{name: "globally allowed overrides", overrides: []string{"c5.large", "m5.large"}, allowed: "m5.*", want: []string{"m5.large"}},
+		{name: "no allowed override", overrides: []string{"c5.large"}, allowed: "m5.*", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newReplacementBatchGroup(1, mockEC2{})
+			a.region.conf.AllowedInstanceTypes = tt.allowed
+			if tt.overrides != nil {
+				var overrides []*autoscaling.LaunchTemplateOverrides
+				for _, o := range tt.overrides {
+					overrides = append(overrides, &autoscaling.LaunchTemplateOverrides{InstanceType: aws.String(o)})
+				}
+				a.MixedInstancesPolicy = &autoscaling.MixedInstancesPolicy{
+					LaunchTemplate: &autoscaling.LaunchTemplate{Overrides: overrides},
+				}
+			}
+
+			candidates, err := a.instances.get("i-ondemand-0").spotReplacementCandidates()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("spotReplacementCandidates() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			var got []string
+			for _, c := range candidates {
+				got = append(got, c.instanceType)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("spotReplacementCandidates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
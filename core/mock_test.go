@@ -1,17 +1,53 @@
 package autospotting
 
 import (
+	"bytes"
+	"crypto/md5"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/AutoSpotting/AutoSpotting/core/autospottingtest"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
+	"github.com/aws/aws-sdk-go/service/batch"
+	"github.com/aws/aws-sdk-go/service/batch/batchiface"
 	"github.com/aws/aws-sdk-go/service/cloudformation"
 	"github.com/aws/aws-sdk-go/service/cloudformation/cloudformationiface"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/cloudwatch/cloudwatchiface"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/ecs"
+	"github.com/aws/aws-sdk-go/service/ecs/ecsiface"
+	"github.com/aws/aws-sdk-go/service/elasticbeanstalk"
+	"github.com/aws/aws-sdk-go/service/elasticbeanstalk/elasticbeanstalkiface"
+	"github.com/aws/aws-sdk-go/service/licensemanager"
+	"github.com/aws/aws-sdk-go/service/licensemanager/licensemanageriface"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/ssm/ssmiface"
 )
 
 func CheckErrors(t *testing.T, err error, expected error) {
@@ -29,6 +65,8 @@ type mockEC2 struct {
 	// Describe Spot Price History
 	dspho   *ec2.DescribeSpotPriceHistoryOutput
 	dspherr error
+	// DescribeSpotPriceHistoryPages, dspho is used as a single page if unset
+	dsphp []*ec2.DescribeSpotPriceHistoryOutput
 
 	// DescribeInstancesOutput
 	dio *ec2.DescribeInstancesOutput
@@ -48,15 +86,99 @@ type mockEC2 struct {
 	dro   *ec2.DescribeRegionsOutput
 	drerr error
 
+	// Describe Availability Zones
+	dazo   *ec2.DescribeAvailabilityZonesOutput
+	dazerr error
+
+	// Describe Images
+	dimo   *ec2.DescribeImagesOutput
+	dimerr error
+
 	// Delete Tags
 	dto   *ec2.DeleteTagsOutput
 	dterr error
+
+	// Create Tags
+	cto   *ec2.CreateTagsOutput
+	cterr error
+
+	// Describe Subnets
+	dso   *ec2.DescribeSubnetsOutput
+	dserr error
+	// Describe Subnets by ID, failing like EC2 when any of them is unknown
+	dsbyid map[string]*ec2.Subnet
+
+	// Describe Volumes
+	dvo    *ec2.DescribeVolumesOutput
+	dvoerr error
+
+	// Describe/Cancel Spot Instance Requests, recording the cancelled IDs
+	dsiro   *ec2.DescribeSpotInstanceRequestsOutput
+	dsirerr error
+	csirerr error
+	csir    *[]string
+
+	// Describe Reserved Instances
+	drio   *ec2.DescribeReservedInstancesOutput
+	drierr error
+
+	// Attach/Detach Volume, recording the calls as "attach|detach vol inst"
+	averr error
+	dverr error
+	vcall *[]string
+
+	// Describe/Create Launch Templates, recording the created templates
+	dlto   *ec2.DescribeLaunchTemplatesOutput
+	dlterr error
+	clto   *ec2.CreateLaunchTemplateOutput
+	clterr error
+	clt    *[]*ec2.CreateLaunchTemplateInput
+
+	// Describe Launch Template Versions, recording the requested versions
+	dltvo   *ec2.DescribeLaunchTemplateVersionsOutput
+	dltverr error
+	dltv    *[]string
+
+	// Run Instances, recording the launched instance types, and failing the
+	// launches of the instance types found in rierr, also for the dry runs
+	rierr map[string]error
+	ri    *runInstancesCalls
+
+	// Standalone instance replacement, recording the calls as
+	// "<operation> <resource ID>"
+	dao   *ec2.DescribeAddressesOutput
+	sierr error
+	cierr error
+	calls *[]string
+}
+
+// runInstancesCalls records the RunInstances calls, which may be concurrent.
+type runInstancesCalls struct {
+	sync.Mutex
+	instanceTypes []string
+	images        []string
+	subnets       []string
 }
 
 func (m mockEC2) DescribeSpotPriceHistory(in *ec2.DescribeSpotPriceHistoryInput) (*ec2.DescribeSpotPriceHistoryOutput, error) {
 	return m.dspho, m.dspherr
 }
 
+func (m mockEC2) DescribeSpotPriceHistoryPages(in *ec2.DescribeSpotPriceHistoryInput, f func(*ec2.DescribeSpotPriceHistoryOutput, bool) bool) error {
+	if m.dspherr != nil {
+		return m.dspherr
+	}
+	for i, page := range m.dsphp {
+		if !f(page, i == len(m.dsphp)-1) {
+			return nil
+		}
+	}
+	if m.dsphp == nil && m.dspho != nil {
+		f(m.dspho, true)
+	}
+	return nil
+}
+
 func (m mockEC2) DescribeInstancesPages(in *ec2.DescribeInstancesInput, f func(*ec2.DescribeInstancesOutput, bool) bool) error {
 	f(m.dio, true)
 	return nil
@@ -66,18 +188,207 @@ func (m mockEC2) DescribeInstanceAttribute(in *ec2.DescribeInstanceAttributeInpu
 	return m.diao, m.diaerr
 }
 
-func (m mockEC2) TerminateInstances(*ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+func (m mockEC2) TerminateInstances(in *ec2.TerminateInstancesInput) (*ec2.TerminateInstancesOutput, error) {
+	if len(in.InstanceIds) > 0 {
+		m.record("TerminateInstances", *in.InstanceIds[0])
+	}
 	return m.tio, m.tierr
 }
 
+func (m mockEC2) record(operation, id string) {
+	if m.calls != nil {
+		*m.calls = append(*m.calls, operation+" "+id)
+	}
+}
+
+func (m mockEC2) DescribeAddresses(*ec2.DescribeAddressesInput) (*ec2.DescribeAddressesOutput, error) {
+	if m.dao == nil {
+		return &ec2.DescribeAddressesOutput{}, nil
+	}
+	return m.dao, nil
+}
+
+func (m mockEC2) AssociateAddress(in *ec2.AssociateAddressInput) (*ec2.AssociateAddressOutput, error) {
+	m.record("AssociateAddress", *in.AllocationId)
+	return &ec2.AssociateAddressOutput{}, nil
+}
+
+func (m mockEC2) StopInstances(in *ec2.StopInstancesInput) (*ec2.StopInstancesOutput, error) {
+	m.record("StopInstances", *in.InstanceIds[0])
+	return &ec2.StopInstancesOutput{}, m.sierr
+}
+
+func (m mockEC2) StartInstances(in *ec2.StartInstancesInput) (*ec2.StartInstancesOutput, error) {
+	m.record("StartInstances", *in.InstanceIds[0])
+	return &ec2.StartInstancesOutput{}, nil
+}
+
+func (m mockEC2) CreateImage(in *ec2.CreateImageInput) (*ec2.CreateImageOutput, error) {
+	m.record("CreateImage", *in.InstanceId)
+	if m.cierr != nil {
+		return nil, m.cierr
+	}
+	return &ec2.CreateImageOutput{ImageId: aws.String("ami-standalone")}, nil
+}
+
+func (m mockEC2) DeregisterImage(in *ec2.DeregisterImageInput) (*ec2.DeregisterImageOutput, error) {
+	m.record("DeregisterImage", *in.ImageId)
+	return &ec2.DeregisterImageOutput{}, nil
+}
+
+func (m mockEC2) DeleteSnapshot(in *ec2.DeleteSnapshotInput) (*ec2.DeleteSnapshotOutput, error) {
+	m.record("DeleteSnapshot", *in.SnapshotId)
+	return &ec2.DeleteSnapshotOutput{}, nil
+}
+
+func (m mockEC2) ModifyInstanceAttribute(in *ec2.ModifyInstanceAttributeInput) (*ec2.ModifyInstanceAttributeOutput, error) {
+	m.record("ModifyInstanceAttribute", *in.InstanceId)
+	return &ec2.ModifyInstanceAttributeOutput{}, nil
+}
+
+func (m mockEC2) ModifyNetworkInterfaceAttribute(in *ec2.ModifyNetworkInterfaceAttributeInput) (*ec2.ModifyNetworkInterfaceAttributeOutput, error) {
+	m.record("ModifyNetworkInterfaceAttribute", *in.NetworkInterfaceId)
+	return &ec2.ModifyNetworkInterfaceAttributeOutput{}, nil
+}
+
+func (m mockEC2) WaitUntilInstanceStopped(*ec2.DescribeInstancesInput) error {
+	return nil
+}
+
+func (m mockEC2) WaitUntilInstanceTerminated(*ec2.DescribeInstancesInput) error {
+	return nil
+}
+
+func (m mockEC2) WaitUntilInstanceRunning(*ec2.DescribeInstancesInput) error {
+	return nil
+}
+
+func (m mockEC2) WaitUntilImageAvailable(*ec2.DescribeImagesInput) error {
+	return nil
+}
+
+func (m mockEC2) WaitUntilNetworkInterfaceAvailable(*ec2.DescribeNetworkInterfacesInput) error {
+	return nil
+}
+
 func (m mockEC2) DescribeRegions(*ec2.DescribeRegionsInput) (*ec2.DescribeRegionsOutput, error) {
 	return m.dro, m.drerr
 }
 
+func (m mockEC2) DescribeImages(*ec2.DescribeImagesInput) (*ec2.DescribeImagesOutput, error) {
+	return m.dimo, m.dimerr
+}
+
+func (m mockEC2) DescribeAvailabilityZones(*ec2.DescribeAvailabilityZonesInput) (*ec2.DescribeAvailabilityZonesOutput, error) {
+	return m.dazo, m.dazerr
+}
+
 func (m mockEC2) DeleteTags(*ec2.DeleteTagsInput) (*ec2.DeleteTagsOutput, error) {
 	return m.dto, m.dterr
 }
 
+func (m mockEC2) AttachVolume(in *ec2.AttachVolumeInput) (*ec2.VolumeAttachment, error) {
+	if m.vcall != nil {
+		*m.vcall = append(*m.vcall, "attach "+*in.VolumeId+" "+*in.InstanceId)
+	}
+	return &ec2.VolumeAttachment{}, m.averr
+}
+
+func (m mockEC2) DetachVolume(in *ec2.DetachVolumeInput) (*ec2.VolumeAttachment, error) {
+	if m.vcall != nil {
+		*m.vcall = append(*m.vcall, "detach "+*in.VolumeId+" "+*in.InstanceId)
+	}
+	return &ec2.VolumeAttachment{}, m.dverr
+}
+
+func (m mockEC2) WaitUntilVolumeAvailable(*ec2.DescribeVolumesInput) error {
+	return nil
+}
+
+func (m mockEC2) WaitUntilVolumeInUse(*ec2.DescribeVolumesInput) error {
+	return nil
+}
+
+func (m mockEC2) DescribeSubnets(in *ec2.DescribeSubnetsInput) (*ec2.DescribeSubnetsOutput, error) {
+	if m.dsbyid == nil {
+		return m.dso, m.dserr
+	}
+	out := &ec2.DescribeSubnetsOutput{}
+	for _, id := range in.SubnetIds {
+		s, ok := m.dsbyid[*id]
+		if !ok {
+			return nil, awserr.New("InvalidSubnetID.NotFound", "The subnet ID '"+*id+"' does not exist", nil)
+		}
+		out.Subnets = append(out.Subnets, s)
+	}
+	return out, nil
+}
+
+func (m mockEC2) DescribeVolumes(*ec2.DescribeVolumesInput) (*ec2.DescribeVolumesOutput, error) {
+	return m.dvo, m.dvoerr
+}
+
+func (m mockEC2) DescribeSpotInstanceRequests(*ec2.DescribeSpotInstanceRequestsInput) (*ec2.DescribeSpotInstanceRequestsOutput, error) {
+	return m.dsiro, m.dsirerr
+}
+
+func (m mockEC2) CancelSpotInstanceRequests(in *ec2.CancelSpotInstanceRequestsInput) (*ec2.CancelSpotInstanceRequestsOutput, error) {
+	if m.csir != nil {
+		*m.csir = append(*m.csir, aws.StringValueSlice(in.SpotInstanceRequestIds)...)
+	}
+	return &ec2.CancelSpotInstanceRequestsOutput{}, m.csirerr
+}
+
+func (m mockEC2) DescribeReservedInstances(*ec2.DescribeReservedInstancesInput) (*ec2.DescribeReservedInstancesOutput, error) {
+	return m.drio, m.drierr
+}
+
+func (m mockEC2) CreateTags(*ec2.CreateTagsInput) (*ec2.CreateTagsOutput, error) {
+	return m.cto, m.cterr
+}
+
+func (m mockEC2) DescribeLaunchTemplates(*ec2.DescribeLaunchTemplatesInput) (*ec2.DescribeLaunchTemplatesOutput, error) {
+	return m.dlto, m.dlterr
+}
+
+func (m mockEC2) DescribeLaunchTemplateVersions(in *ec2.DescribeLaunchTemplateVersionsInput) (*ec2.DescribeLaunchTemplateVersionsOutput, error) {
+	if m.dltv != nil {
+		*m.dltv = append(*m.dltv, aws.StringValueSlice(in.Versions)...)
+	}
+	return m.dltvo, m.dltverr
+}
+
+func (m mockEC2) RunInstances(in *ec2.RunInstancesInput) (*ec2.Reservation, error) {
+	instanceType := aws.StringValue(in.InstanceType)
+	if err := m.rierr[instanceType]; err != nil {
+		return nil, err
+	}
+	if aws.BoolValue(in.DryRun) {
+		return nil, awserr.New(dryRunErrorCode, "Request would have succeeded, but DryRun flag is set.", nil)
+	}
+	if m.ri == nil {
+		return &ec2.Reservation{Instances: []*ec2.Instance{{
+			InstanceId: aws.String("i-spot"), InstanceType: in.InstanceType}}}, nil
+	}
+
+	m.ri.Lock()
+	defer m.ri.Unlock()
+	m.ri.instanceTypes = append(m.ri.instanceTypes, instanceType)
+	m.ri.images = append(m.ri.images, aws.StringValue(in.ImageId))
+	m.ri.subnets = append(m.ri.subnets, aws.StringValue(in.SubnetId))
+	return &ec2.Reservation{Instances: []*ec2.Instance{{
+		InstanceId:   aws.String(fmt.Sprintf("i-spot-%d", len(m.ri.instanceTypes))),
+		InstanceType: in.InstanceType,
+	}}}, nil
+}
+
+func (m mockEC2) CreateLaunchTemplate(in *ec2.CreateLaunchTemplateInput) (*ec2.CreateLaunchTemplateOutput, error) {
+	if m.clt != nil {
+		*m.clt = append(*m.clt, in)
+	}
+	return m.clto, m.clterr
+}
+
 // For testing we "convert" the SecurityGroupIDs/SecurityGroupNames by
 // prefixing the original name/id with "sg-" if not present already. We
 // also fill up the rest of the string to the length of a typical ID with
@@ -134,9 +445,10 @@ type mockASG struct {
 	// Describe Launch Config
 	dlco   *autoscaling.DescribeLaunchConfigurationsOutput
 	dlcerr error
-	// Update AutoScaling Group
+	// Update AutoScaling Group, recording the updates
 	uasgo   *autoscaling.UpdateAutoScalingGroupOutput
 	uasgerr error
+	uasg    *[]*autoscaling.UpdateAutoScalingGroupInput
 	// Describe Tags
 	dto *autoscaling.DescribeTagsOutput
 
@@ -150,6 +462,21 @@ type mockASG struct {
 	// DescribeLifecycleHooks
 	dlho   *autoscaling.DescribeLifecycleHooksOutput
 	dlherr error
+
+	// CreateOrUpdateTags and DeleteTags, recording the tags of the group
+	tags   map[string]string
+	tagerr error
+
+	// CompleteLifecycleAction, recording the completed actions
+	clai   *[]*autoscaling.CompleteLifecycleActionInput
+	claerr error
+}
+
+func (m mockASG) CompleteLifecycleAction(in *autoscaling.CompleteLifecycleActionInput) (*autoscaling.CompleteLifecycleActionOutput, error) {
+	if m.clai != nil {
+		*m.clai = append(*m.clai, in)
+	}
+	return &autoscaling.CompleteLifecycleActionOutput{}, m.claerr
 }
 
 func (m mockASG) DetachInstances(*autoscaling.DetachInstancesInput) (*autoscaling.DetachInstancesOutput, error) {
@@ -168,7 +495,10 @@ func (m mockASG) DescribeLaunchConfigurations(*autoscaling.DescribeLaunchConfigu
 	return m.dlco, m.dlcerr
 }
 
-func (m mockASG) UpdateAutoScalingGroup(*autoscaling.UpdateAutoScalingGroupInput) (*autoscaling.UpdateAutoScalingGroupOutput, error) {
+func (m mockASG) UpdateAutoScalingGroup(in *autoscaling.UpdateAutoScalingGroupInput) (*autoscaling.UpdateAutoScalingGroupOutput, error) {
+	if m.uasg != nil {
+		*m.uasg = append(*m.uasg, in)
+	}
 	return m.uasgo, m.uasgerr
 }
 
@@ -178,6 +508,10 @@ func (m mockASG) DescribeTagsPages(input *autoscaling.DescribeTagsInput, functio
 }
 
 func (m mockASG) DescribeAutoScalingGroupsPages(input *autoscaling.DescribeAutoScalingGroupsInput, function func(*autoscaling.DescribeAutoScalingGroupsOutput, bool) bool) error {
+	if m.dasgo == nil {
+		function(&autoscaling.DescribeAutoScalingGroupsOutput{}, true)
+		return nil
+	}
 	function(m.dasgo, true)
 	return nil
 }
@@ -190,6 +524,24 @@ func (m mockASG) DescribeLifecycleHooks(*autoscaling.DescribeLifecycleHooksInput
 	return m.dlho, m.dlherr
 }
 
+func (m mockASG) CreateOrUpdateTags(in *autoscaling.CreateOrUpdateTagsInput) (*autoscaling.CreateOrUpdateTagsOutput, error) {
+	if m.tags != nil && m.tagerr == nil {
+		for _, t := range in.Tags {
+			m.tags[*t.Key] = *t.Value
+		}
+	}
+	return &autoscaling.CreateOrUpdateTagsOutput{}, m.tagerr
+}
+
+func (m mockASG) DeleteTags(in *autoscaling.DeleteTagsInput) (*autoscaling.DeleteTagsOutput, error) {
+	if m.tags != nil && m.tagerr == nil {
+		for _, t := range in.Tags {
+			delete(m.tags, *t.Key)
+		}
+	}
+	return &autoscaling.DeleteTagsOutput{}, m.tagerr
+}
+
 // All fields are composed of the abbreviation of their method
 // This is useful when methods are doing multiple calls to AWS API
 type mockCloudFormation struct {
@@ -202,3 +554,600 @@ type mockCloudFormation struct {
 func (m mockCloudFormation) DescribeStacks(*cloudformation.DescribeStacksInput) (*cloudformation.DescribeStacksOutput, error) {
 	return m.dso, m.dserr
 }
+
+// All fields are composed of the abbreviation of their method
+// This is useful when methods are doing multiple calls to AWS API
+type mockS3 struct {
+	s3iface.S3API
+	// ListObjectsV2Pages
+	lo    *s3.ListObjectsV2Output
+	loerr error
+	// GetObject, keyed by object key, also storing the PutObject bodies
+	objects map[string][]byte
+	// PutObject
+	poerr error
+}
+
+func (m mockS3) ListObjectsV2Pages(in *s3.ListObjectsV2Input, f func(*s3.ListObjectsV2Output, bool) bool) error {
+	if m.loerr != nil {
+		return m.loerr
+	}
+	f(m.lo, true)
+	return nil
+}
+
+func (m mockS3) PutObject(in *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	if m.poerr != nil {
+		return nil, m.poerr
+	}
+	data, err := ioutil.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	m.objects[*in.Key] = data
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (m mockS3) GetObject(in *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	data, ok := m.objects[*in.Key]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(data)), ETag: aws.String(mockETag(data))}, nil
+}
+
+func mockETag(data []byte) string {
+	return fmt.Sprintf("%q", fmt.Sprintf("%x", md5.Sum(data)))
+}
+
+// checkConditions returns the error of a conditional request whose If-Match
+// or If-None-Match headers, set by the request options, don't hold.
+func (m mockS3) checkConditions(key string, opts []request.Option) error {
+	r := &request.Request{HTTPRequest: &http.Request{Header: http.Header{}}}
+	r.ApplyOptions(opts...)
+	data, ok := m.objects[key]
+	if ok && r.HTTPRequest.Header.Get("If-None-Match") == "*" {
+		return awserr.New("PreconditionFailed", "At least one of the pre-conditions you specified did not hold", nil)
+	}
+	if etag := r.HTTPRequest.Header.Get("If-Match"); etag != "" {
+		if !ok {
+			return awserr.New(s3.ErrCodeNoSuchKey, "The specified key does not exist.", nil)
+		}
+		if etag != mockETag(data) {
+			return awserr.New("PreconditionFailed", "At least one of the pre-conditions you specified did not hold", nil)
+		}
+	}
+	return nil
+}
+
+// PutObjectWithContext is a PutObject honoring the If-Match and If-None-Match
+// headers set by the request options.
+func (m mockS3) PutObjectWithContext(ctx aws.Context, in *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	if err := m.checkConditions(*in.Key, opts); err != nil {
+		return nil, err
+	}
+	return m.PutObject(in)
+}
+
+func (m mockS3) DeleteObject(in *s3.DeleteObjectInput) (*s3.DeleteObjectOutput, error) {
+	delete(m.objects, *in.Key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+// DeleteObjectWithContext is a DeleteObject honoring the If-Match header set
+// by the request options.
+func (m mockS3) DeleteObjectWithContext(ctx aws.Context, in *s3.DeleteObjectInput, opts ...request.Option) (*s3.DeleteObjectOutput, error) {
+	if err := m.checkConditions(*in.Key, opts); err != nil {
+		return nil, err
+	}
+	return m.DeleteObject(in)
+}
+
+// All fields are composed of the abbreviation of their method
+// This is useful when methods are doing multiple calls to AWS API
+type mockSQS struct {
+	sqsiface.SQSAPI
+	// ReceiveMessage, the messages are returned only once
+	rm    []*sqs.Message
+	rmerr error
+	// DeleteMessage, records the deleted receipt handles
+	dm []string
+	// SendMessage, records the sent message bodies and inputs
+	sm    []string
+	smi   []*sqs.SendMessageInput
+	smerr error
+}
+
+func (m *mockSQS) ReceiveMessage(in *sqs.ReceiveMessageInput) (*sqs.ReceiveMessageOutput, error) {
+	if m.rmerr != nil {
+		return nil, m.rmerr
+	}
+	out := &sqs.ReceiveMessageOutput{Messages: m.rm}
+	m.rm = nil
+	return out, nil
+}
+
+func (m *mockSQS) DeleteMessage(in *sqs.DeleteMessageInput) (*sqs.DeleteMessageOutput, error) {
+	m.dm = append(m.dm, *in.ReceiptHandle)
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func (m *mockSQS) SendMessage(in *sqs.SendMessageInput) (*sqs.SendMessageOutput, error) {
+	m.sm = append(m.sm, *in.MessageBody)
+	m.smi = append(m.smi, in)
+	return &sqs.SendMessageOutput{}, m.smerr
+}
+
+type mockSNS struct {
+	snsiface.SNSAPI
+	// Publish, records the published messages
+	p    []*sns.PublishInput
+	perr error
+}
+
+func (m *mockSNS) Publish(in *sns.PublishInput) (*sns.PublishOutput, error) {
+	m.p = append(m.p, in)
+	return &sns.PublishOutput{}, m.perr
+}
+
+type mockDynamoDB struct {
+	dynamodbiface.DynamoDBAPI
+	// PutItem, records the written items
+	pi    []*dynamodb.PutItemInput
+	pierr error
+	// DeleteItem, records the deleted keys
+	di    []*dynamodb.DeleteItemInput
+	dierr error
+	// QueryPages, records the queries and returns qo as a single page
+	q    []*dynamodb.QueryInput
+	qo   *dynamodb.QueryOutput
+	qerr error
+}
+
+func (m *mockDynamoDB) QueryPages(in *dynamodb.QueryInput, fn func(*dynamodb.QueryOutput, bool) bool) error {
+	m.q = append(m.q, in)
+	if m.qerr != nil {
+		return m.qerr
+	}
+	fn(m.qo, true)
+	return nil
+}
+
+func (m *mockDynamoDB) PutItem(in *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+	m.pi = append(m.pi, in)
+	if m.pierr != nil {
+		return nil, m.pierr
+	}
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (m *mockDynamoDB) DeleteItem(in *dynamodb.DeleteItemInput) (*dynamodb.DeleteItemOutput, error) {
+	m.di = append(m.di, in)
+	return &dynamodb.DeleteItemOutput{}, m.dierr
+}
+
+type mockSecretsManager struct {
+	secretsmanageriface.SecretsManagerAPI
+	// GetSecretValue, values by secret ID
+	gsv    map[string]string
+	gsverr error
+	// number of GetSecretValue calls
+	calls int
+}
+
+func (m *mockSecretsManager) GetSecretValue(in *secretsmanager.GetSecretValueInput) (*secretsmanager.GetSecretValueOutput, error) {
+	m.calls++
+	if m.gsverr != nil {
+		return nil, m.gsverr
+	}
+	value, ok := m.gsv[aws.StringValue(in.SecretId)]
+	if !ok {
+		return nil, errors.New("ResourceNotFoundException")
+	}
+	return &secretsmanager.GetSecretValueOutput{SecretString: aws.String(value)}, nil
+}
+
+type mockServiceQuotas struct {
+	// GetServiceQuota, values by quota code
+	gsq    map[string]float64
+	gsqerr error
+	// number of GetServiceQuota calls
+	calls int
+	// RequestServiceQuotaIncrease, records the requests
+	rsqi    []*requestServiceQuotaIncreaseInput
+	rsqierr error
+}
+
+func (m *mockServiceQuotas) GetServiceQuota(in *getServiceQuotaInput) (*getServiceQuotaOutput, error) {
+	m.calls++
+	if m.gsqerr != nil {
+		return nil, m.gsqerr
+	}
+	v, ok := m.gsq[*in.QuotaCode]
+	if !ok {
+		return &getServiceQuotaOutput{}, nil
+	}
+	return &getServiceQuotaOutput{
+		Quota: &serviceQuota{QuotaCode: in.QuotaCode, Value: &v},
+	}, nil
+}
+
+func (m *mockServiceQuotas) RequestServiceQuotaIncrease(in *requestServiceQuotaIncreaseInput) (*requestServiceQuotaIncreaseOutput, error) {
+	m.rsqi = append(m.rsqi, in)
+	if m.rsqierr != nil {
+		return nil, m.rsqierr
+	}
+	return &requestServiceQuotaIncreaseOutput{
+		RequestedQuota: &requestedServiceQuotaChange{Id: aws.String("request-1")},
+	}, nil
+}
+
+type mockSpotPlacementScores struct {
+	// GetSpotPlacementScores, scores by instance type and availability zone ID
+	gsps    map[string]map[string]int64
+	gspserr error
+	// instance types of the GetSpotPlacementScores calls
+	calls []string
+}
+
+func (m *mockSpotPlacementScores) GetSpotPlacementScores(in *getSpotPlacementScoresInput) (*getSpotPlacementScoresOutput, error) {
+	instanceType := aws.StringValue(in.InstanceTypes[0])
+	m.calls = append(m.calls, instanceType)
+	if m.gspserr != nil {
+		return nil, m.gspserr
+	}
+	out := &getSpotPlacementScoresOutput{}
+	for zone, score := range m.gsps[instanceType] {
+		out.SpotPlacementScores = append(out.SpotPlacementScores, &spotPlacementScore{
+			AvailabilityZoneID: aws.String(zone),
+			Region:             in.RegionNames[0],
+			Score:              aws.Int64(score),
+		})
+	}
+	return out, nil
+}
+
+type mockBootModes struct {
+	// DescribeImages
+	dimo   *describeImagesOutput
+	dimerr error
+	// DescribeInstanceTypes, one output per page
+	ditp   []*describeInstanceTypesOutput
+	diterr error
+}
+
+func (m *mockBootModes) DescribeImages(*describeImagesInput) (*describeImagesOutput, error) {
+	return m.dimo, m.dimerr
+}
+
+func (m *mockBootModes) DescribeInstanceTypes(in *describeInstanceTypesInput) (*describeInstanceTypesOutput, error) {
+	if m.diterr != nil {
+		return nil, m.diterr
+	}
+	page := 0
+	if in.NextToken != nil {
+		fmt.Sscanf(*in.NextToken, "%d", &page)
+	}
+	out := *m.ditp[page]
+	if page+1 < len(m.ditp) {
+		out.NextToken = aws.String(fmt.Sprint(page + 1))
+	}
+	return &out, nil
+}
+
+type mockBatch struct {
+	batchiface.BatchAPI
+	// DescribeComputeEnvironments
+	dce    []*batch.ComputeEnvironmentDetail
+	dceerr error
+	// CreateComputeEnvironment, records the created compute environments
+	cce []*batch.CreateComputeEnvironmentInput
+	// DescribeJobQueues
+	djq []*batch.JobQueueDetail
+	// UpdateJobQueue, records the updates
+	ujq []*batch.UpdateJobQueueInput
+}
+
+func (m *mockBatch) DescribeComputeEnvironments(in *batch.DescribeComputeEnvironmentsInput) (*batch.DescribeComputeEnvironmentsOutput, error) {
+	return &batch.DescribeComputeEnvironmentsOutput{ComputeEnvironments: m.dce}, m.dceerr
+}
+
+func (m *mockBatch) CreateComputeEnvironment(in *batch.CreateComputeEnvironmentInput) (*batch.CreateComputeEnvironmentOutput, error) {
+	m.cce = append(m.cce, in)
+	return &batch.CreateComputeEnvironmentOutput{}, nil
+}
+
+func (m *mockBatch) DescribeJobQueues(in *batch.DescribeJobQueuesInput) (*batch.DescribeJobQueuesOutput, error) {
+	return &batch.DescribeJobQueuesOutput{JobQueues: m.djq}, nil
+}
+
+func (m *mockBatch) UpdateJobQueue(in *batch.UpdateJobQueueInput) (*batch.UpdateJobQueueOutput, error) {
+	m.ujq = append(m.ujq, in)
+	return &batch.UpdateJobQueueOutput{}, nil
+}
+
+type mockSSM struct {
+	ssmiface.SSMAPI
+	// DescribeInstanceInformation
+	dii    *ssm.DescribeInstanceInformationOutput
+	diierr error
+	// SendCommand
+	sc    []*ssm.SendCommandInput
+	scerr error
+	// GetCommandInvocation
+	gci    *ssm.GetCommandInvocationOutput
+	gcierr error
+	// GetParameter
+	gp    map[string]string
+	gperr error
+	// GetParametersByPathPages, returned as one page per output
+	gpbp    []*ssm.GetParametersByPathOutput
+	gpbperr error
+}
+
+func (m *mockSSM) GetParametersByPathPages(in *ssm.GetParametersByPathInput, fn func(*ssm.GetParametersByPathOutput, bool) bool) error {
+	if m.gpbperr != nil {
+		return m.gpbperr
+	}
+	for i, page := range m.gpbp {
+		if !fn(page, i == len(m.gpbp)-1) {
+			break
+		}
+	}
+	return nil
+}
+
+func (m *mockSSM) GetParameter(in *ssm.GetParameterInput) (*ssm.GetParameterOutput, error) {
+	if m.gperr != nil {
+		return nil, m.gperr
+	}
+	value, ok := m.gp[aws.StringValue(in.Name)]
+	if !ok {
+		return nil, errors.New("ParameterNotFound")
+	}
+	return &ssm.GetParameterOutput{Parameter: &ssm.Parameter{Value: aws.String(value)}}, nil
+}
+
+func (m *mockSSM) DescribeInstanceInformation(in *ssm.DescribeInstanceInformationInput) (*ssm.DescribeInstanceInformationOutput, error) {
+	return m.dii, m.diierr
+}
+
+func (m *mockSSM) SendCommand(in *ssm.SendCommandInput) (*ssm.SendCommandOutput, error) {
+	if m.scerr != nil {
+		return nil, m.scerr
+	}
+	m.sc = append(m.sc, in)
+	return &ssm.SendCommandOutput{
+		Command: &ssm.Command{CommandId: aws.String(fmt.Sprintf("command-%d", len(m.sc)))},
+	}, nil
+}
+
+func (m *mockSSM) GetCommandInvocation(in *ssm.GetCommandInvocationInput) (*ssm.GetCommandInvocationOutput, error) {
+	return m.gci, m.gcierr
+}
+
+type mockCloudWatch struct {
+	cloudwatchiface.CloudWatchAPI
+	// GetMetricStatistics
+	gms    *cloudwatch.GetMetricStatisticsOutput
+	gmserr error
+	calls  int
+	// PutMetricData, records the inputs
+	pmd    []*cloudwatch.PutMetricDataInput
+	pmderr error
+}
+
+func (m *mockCloudWatch) PutMetricData(in *cloudwatch.PutMetricDataInput) (*cloudwatch.PutMetricDataOutput, error) {
+	m.pmd = append(m.pmd, in)
+	return &cloudwatch.PutMetricDataOutput{}, m.pmderr
+}
+
+func (m *mockCloudWatch) GetMetricStatistics(in *cloudwatch.GetMetricStatisticsInput) (*cloudwatch.GetMetricStatisticsOutput, error) {
+	m.calls++
+	return m.gms, m.gmserr
+}
+
+type mockLicenseManager struct {
+	licensemanageriface.LicenseManagerAPI
+	// GetLicenseConfiguration, by license configuration ARN
+	glc    map[string]*licensemanager.GetLicenseConfigurationOutput
+	glcerr error
+	calls  int
+}
+
+func (m *mockLicenseManager) GetLicenseConfiguration(in *licensemanager.GetLicenseConfigurationInput) (*licensemanager.GetLicenseConfigurationOutput, error) {
+	m.calls++
+	return m.glc[*in.LicenseConfigurationArn], m.glcerr
+}
+
+type mockElasticBeanstalk struct {
+	elasticbeanstalkiface.ElasticBeanstalkAPI
+	// DescribeEnvironments
+	deo   *elasticbeanstalk.EnvironmentDescriptionsMessage
+	deerr error
+	// DescribeInstancesHealth, one output per page
+	diho   []*elasticbeanstalk.DescribeInstancesHealthOutput
+	diherr error
+}
+
+func (m mockElasticBeanstalk) DescribeEnvironments(*elasticbeanstalk.DescribeEnvironmentsInput) (*elasticbeanstalk.EnvironmentDescriptionsMessage, error) {
+	return m.deo, m.deerr
+}
+
+func (m mockElasticBeanstalk) DescribeInstancesHealth(in *elasticbeanstalk.DescribeInstancesHealthInput) (*elasticbeanstalk.DescribeInstancesHealthOutput, error) {
+	if m.diherr != nil {
+		return nil, m.diherr
+	}
+	page := 0
+	if in.NextToken != nil {
+		page, _ = strconv.Atoi(*in.NextToken)
+	}
+	return m.diho[page], nil
+}
+
+type mockOrganizations struct {
+	organizationsiface.OrganizationsAPI
+	// ListAccounts
+	accounts []*organizations.Account
+	// ListAccountsForParent and ListOrganizationalUnitsForParent, by parent ID
+	parentAccounts map[string][]*organizations.Account
+	parentUnits    map[string][]string
+	// ListTagsForResource, by account ID
+	tags    map[string]map[string]string
+	listerr error
+}
+
+func (m *mockOrganizations) ListAccountsPages(in *organizations.ListAccountsInput, fn func(*organizations.ListAccountsOutput, bool) bool) error {
+	if m.listerr != nil {
+		return m.listerr
+	}
+	fn(&organizations.ListAccountsOutput{Accounts: m.accounts}, true)
+	return nil
+}
+
+func (m *mockOrganizations) ListAccountsForParentPages(in *organizations.ListAccountsForParentInput, fn func(*organizations.ListAccountsForParentOutput, bool) bool) error {
+	if m.listerr != nil {
+		return m.listerr
+	}
+	fn(&organizations.ListAccountsForParentOutput{Accounts: m.parentAccounts[*in.ParentId]}, true)
+	return nil
+}
+
+func (m *mockOrganizations) ListOrganizationalUnitsForParentPages(in *organizations.ListOrganizationalUnitsForParentInput, fn func(*organizations.ListOrganizationalUnitsForParentOutput, bool) bool) error {
+	out := &organizations.ListOrganizationalUnitsForParentOutput{}
+	for _, id := range m.parentUnits[*in.ParentId] {
+		out.OrganizationalUnits = append(out.OrganizationalUnits, &organizations.OrganizationalUnit{Id: aws.String(id)})
+	}
+	fn(out, true)
+	return nil
+}
+
+func (m *mockOrganizations) ListTagsForResourcePages(in *organizations.ListTagsForResourceInput, fn func(*organizations.ListTagsForResourceOutput, bool) bool) error {
+	out := &organizations.ListTagsForResourceOutput{}
+	for k, v := range m.tags[*in.ResourceId] {
+		out.Tags = append(out.Tags, &organizations.Tag{Key: aws.String(k), Value: aws.String(v)})
+	}
+	fn(out, true)
+	return nil
+}
+
+type mockECS struct {
+	ecsiface.ECSAPI
+	// ListClustersPages
+	clusters []string
+	// container instance ARNs by cluster, filtered by the EC2 instance ID
+	// they run on
+	containerInstances map[string]map[string]string
+	lcierr             error
+	// UpdateContainerInstancesState
+	drained []string
+	ucierr  error
+	// DescribeContainerInstances, the running tasks counts returned by the
+	// successive calls, the last one being repeated
+	running []int64
+	calls   int
+}
+
+func (m *mockECS) ListClustersPages(in *ecs.ListClustersInput, fn func(*ecs.ListClustersOutput, bool) bool) error {
+	fn(&ecs.ListClustersOutput{ClusterArns: aws.StringSlice(m.clusters)}, true)
+	return nil
+}
+
+func (m *mockECS) ListContainerInstances(in *ecs.ListContainerInstancesInput) (*ecs.ListContainerInstancesOutput, error) {
+	if m.lcierr != nil {
+		return nil, m.lcierr
+	}
+	out := &ecs.ListContainerInstancesOutput{}
+	for instanceID, arn := range m.containerInstances[aws.StringValue(in.Cluster)] {
+		if aws.StringValue(in.Filter) == "ec2InstanceId == "+instanceID {
+			out.ContainerInstanceArns = append(out.ContainerInstanceArns, aws.String(arn))
+		}
+	}
+	return out, nil
+}
+
+func (m *mockECS) UpdateContainerInstancesState(in *ecs.UpdateContainerInstancesStateInput) (*ecs.UpdateContainerInstancesStateOutput, error) {
+	if m.ucierr != nil {
+		return nil, m.ucierr
+	}
+	m.drained = append(m.drained, aws.StringValueSlice(in.ContainerInstances)...)
+	return &ecs.UpdateContainerInstancesStateOutput{}, nil
+}
+
+func (m *mockECS) DescribeContainerInstances(in *ecs.DescribeContainerInstancesInput) (*ecs.DescribeContainerInstancesOutput, error) {
+	n := m.calls
+	if n >= len(m.running) {
+		n = len(m.running) - 1
+	}
+	m.calls++
+	return &ecs.DescribeContainerInstancesOutput{ContainerInstances: []*ecs.ContainerInstance{{
+		ContainerInstanceArn: in.ContainerInstances[0],
+		RunningTasksCount:    aws.Int64(m.running[n]),
+	}}}, nil
+}
+
+// The fixtures shared by the tests, adjusted by each test as needed.
+
+// newTestInstance returns a running HVM instance of the given type in
+// us-east-1a, launched the given duration ago.
+func newTestInstance(id, instanceType string, launched time.Duration) *instance {
+	return &instance{Instance: &ec2.Instance{
+		InstanceId:         aws.String(id),
+		InstanceType:       aws.String(instanceType),
+		VirtualizationType: aws.String("hvm"),
+		LaunchTime:         aws.Time(time.Now().Add(-launched)),
+		Placement:          &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+		State:              &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+	}}
+}
+
+// newTestTypeInfo returns an Intel HVM instance type with 8GB of memory, with
+// the given prices in us-east-1a.
+func newTestTypeInfo(instanceType string, vCPU int, onDemand, spot float64) instanceTypeInformation {
+	return instanceTypeInformation{
+		instanceType:        instanceType,
+		vCPU:                vCPU,
+		memory:              8,
+		PhysicalProcessor:   "Intel",
+		virtualizationTypes: []string{"HVM"},
+		pricing: prices{
+			onDemand: onDemand,
+			spot:     spotPriceMap{"us-east-1a": spot},
+		},
+	}
+}
+
+// newTestGroup returns a group named asg having the given tags.
+func newTestGroup(tags map[string]string) *autoScalingGroup {
+	var asgTags []*autoscaling.TagDescription
+	for k, v := range tags {
+		asgTags = append(asgTags, &autoscaling.TagDescription{Key: aws.String(k), Value: aws.String(v)})
+	}
+	return &autoScalingGroup{
+		name:  "asg",
+		Group: &autoscaling.Group{Tags: asgTags},
+	}
+}
+
+// newTestRegion connects to us-east-1 using the fake cloud set as the
+// ClientProvider of the configuration and scans its instances, enabling the
+// given groups of the region.
+func newTestRegion(cfg *Config, groups ...string) *region {
+	r := &region{
+		name:     "us-east-1",
+		conf:     cfg,
+		services: connections{provider: cfg.ClientProvider},
+	}
+	r.services.connect(r.name)
+	r.scanInstances()
+
+	cloud := cfg.ClientProvider.(*autospottingtest.Cloud)
+	for _, name := range groups {
+		r.enabledASGs = append(r.enabledASGs, autoScalingGroup{
+			Group:  cloud.Region(r.name).Group(name),
+			name:   name,
+			region: r,
+		})
+	}
+	return r
+}
@@ -0,0 +1,389 @@
+package autospotting
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+	"github.com/aws/aws-sdk-go/service/sns/snsiface"
+)
+
+// The types of the events AutoSpotting sends notifications about
+const (
+	// ErrorEvent is sent when a run fails, such as when it is aborted after
+	// canary failures
+	ErrorEvent = "error"
+
+	// WarningEvent is sent when a problem needs attention, such as when
+	// getting close to the spot vCPU quotas
+	WarningEvent = "warning"
+
+	// SummaryEvent is sent at the end of the runs which replaced instances
+	SummaryEvent = "summary"
+
+	// ReplacementEvent is sent for each on-demand instance replaced with a
+	// spot instance
+	ReplacementEvent = "replacement"
+
+	// LaunchFailureEvent is sent when none of the compatible instance types
+	// of a spot instance could be launched
+	LaunchFailureEvent = "launch_failure"
+
+	// InterruptionEvent is sent for each spot interruption handled
+	InterruptionEvent = "interruption"
+)
+
+// actionEvents are sent for each action taken, so they are only sent to the
+// destinations which explicitly list them.
+var actionEvents = []string{ReplacementEvent, LaunchFailureEvent, InterruptionEvent}
+
+// The kinds of the notification destinations configured by the
+// Notifications parameter, given as <kind>:<target>
+const (
+	snsNotifierKind       = "sns"
+	slackNotifierKind     = "slack"
+	webhookNotifierKind   = "webhook"
+	pagerDutyNotifierKind = "pagerduty"
+)
+
+// pagerDutyEventsURL is the endpoint of the PagerDuty Events API v2
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// Notification is an event AutoSpotting notifies about.
+type Notification struct {
+	Event   string `json:"event"`
+	Subject string `json:"subject"`
+	Message string `json:"message"`
+	RunID   string `json:"run_id"`
+
+	// The details of the replacement, launch failure or interruption
+	Action *ActionDetails `json:"action,omitempty"`
+}
+
+// ActionDetails describes the instances involved in an action, the old one
+// being the on-demand instance being replaced or the interrupted spot
+// instance, and the new one the spot instance replacing it.
+type ActionDetails struct {
+	Account          string  `json:"account,omitempty"`
+	Region           string  `json:"region"`
+	AutoScalingGroup string  `json:"asg"`
+	OldInstanceID    string  `json:"old_instance_id,omitempty"`
+	OldInstanceType  string  `json:"old_instance_type,omitempty"`
+	OldPrice         float64 `json:"old_price,omitempty"`
+	NewInstanceID    string  `json:"new_instance_id,omitempty"`
+	NewInstanceType  string  `json:"new_instance_type,omitempty"`
+	NewPrice         float64 `json:"new_price,omitempty"`
+	Error            string  `json:"error,omitempty"`
+}
+
+func (d ActionDetails) String() string {
+	var lines []string
+	if d.Account != "" {
+		lines = append(lines, "Account: "+d.Account)
+	}
+	lines = append(lines,
+		"Region: "+d.Region,
+		"AutoScaling group: "+d.AutoScalingGroup,
+	)
+	instance := func(id, instanceType string, price float64) string {
+		if price > 0 {
+			return fmt.Sprintf("%s (%s, %.5f/hour)", id, instanceType, price)
+		}
+		return fmt.Sprintf("%s (%s)", id, instanceType)
+	}
+	if d.OldInstanceID != "" || d.OldInstanceType != "" {
+		lines = append(lines, "Old instance: "+instance(d.OldInstanceID, d.OldInstanceType, d.OldPrice))
+	}
+	if d.NewInstanceID != "" || d.NewInstanceType != "" {
+		lines = append(lines, "New instance: "+instance(d.NewInstanceID, d.NewInstanceType, d.NewPrice))
+	}
+	if d.Error != "" {
+		lines = append(lines, "Error: "+d.Error)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Notifier delivers notifications to a destination, such as an SNS topic or a
+// Slack channel. Library users can implement it for their own destinations
+// and route events to them using the NotificationRoutes of the Config.
+type Notifier interface {
+	Notify(n Notification) error
+}
+
+// NotificationRoute sends the notifications of the given event types, or of
+// all of them except the ones sent for each action when no event types are
+// given, to a Notifier.
+type NotificationRoute struct {
+	Events   []string
+	Notifier Notifier
+}
+
+func (r NotificationRoute) matches(event string) bool {
+	if len(r.Events) == 0 {
+		return indexOf(actionEvents, event) < 0
+	}
+	return indexOf(r.Events, event) >= 0
+}
+
+// topicRegion determines the region of an SNS topic from its ARN, such as
+// arn:aws:sns:us-east-1:123456789012:topic
+func topicRegion(topicARN string) string {
+	parts := strings.Split(topicARN, ":")
+	if len(parts) == 6 && parts[2] == "sns" {
+		return parts[3]
+	}
+	return ""
+}
+
+// parseNotificationRoute parses a notification destination given as
+// [<event>|<event>=]<kind>:<target>, such as error=pagerduty:<routing key>
+// or summary|warning=slack:https://hooks.slack.com/services/...
+func parseNotificationRoute(cfg *Config, spec string) (NotificationRoute, error) {
+	var route NotificationRoute
+
+	colon := strings.Index(spec, ":")
+	if colon < 0 {
+		return route, fmt.Errorf("missing notification destination in %q", spec)
+	}
+	if eq := strings.Index(spec, "="); eq >= 0 && eq < colon {
+		for _, event := range strings.Split(spec[:eq], "|") {
+			switch event {
+			case ErrorEvent, WarningEvent, SummaryEvent, ReplacementEvent, LaunchFailureEvent, InterruptionEvent:
+				route.Events = append(route.Events, event)
+			default:
+				return route, fmt.Errorf("unknown notification event %q in %q", event, spec)
+			}
+		}
+		spec = spec[eq+1:]
+		colon -= eq + 1
+	}
+
+	kind, target := spec[:colon], spec[colon+1:]
+	if target == "" {
+		return route, fmt.Errorf("missing notification destination in %q", spec)
+	}
+
+	client := &http.Client{Timeout: 10 * time.Second}
+	switch kind {
+	case snsNotifierKind:
+		route.Notifier = &snsNotifier{cfg: cfg, topicARN: target}
+	case slackNotifierKind:
+		route.Notifier = &slackNotifier{client: client, url: target}
+	case webhookNotifierKind:
+		route.Notifier = &webhookNotifier{client: client, url: target}
+	case pagerDutyNotifierKind:
+		route.Notifier = &pagerDutyNotifier{client: client, url: pagerDutyEventsURL, routingKey: target}
+	default:
+		return route, fmt.Errorf("unknown notification destination %q", kind)
+	}
+	return route, nil
+}
+
+// loadNotificationRoutes determines where the notifications of the run are
+// sent, from the routes set by library users, the Notifications parameter,
+// the NotificationTopicARN parameter and the ActionLogTable parameter. The
+// invalid destinations are logged and ignored.
+func loadNotificationRoutes(cfg *Config) []NotificationRoute {
+	routes := append([]NotificationRoute(nil), cfg.NotificationRoutes...)
+
+	if cfg.NotificationTopicARN != "" {
+		routes = append(routes, NotificationRoute{
+			Notifier: &snsNotifier{cfg: cfg, topicARN: cfg.NotificationTopicARN},
+		})
+	}
+
+	if url := cfg.secret("notification webhook URL", cfg.NotificationWebhookURL); url != "" {
+		routes = append(routes, NotificationRoute{
+			Events:   actionEvents,
+			Notifier: newWebhookNotifier(url),
+		})
+	}
+
+	if cfg.ActionLogTable != "" {
+		routes = append(routes, NotificationRoute{
+			Events:   actionEvents,
+			Notifier: newActionLogNotifier(cfg, cfg.ActionLogTable),
+		})
+	}
+
+	for _, spec := range strings.FieldsFunc(cfg.secret("notifications", cfg.Notifications), func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	}) {
+		route, err := parseNotificationRoute(cfg, spec)
+		if err != nil {
+			logger.Println("Ignoring invalid notification destination:", err.Error())
+			continue
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
+// notify sends a notification to all the destinations configured for its
+// event type.
+func notify(cfg *Config, event, subject, message string) {
+	sendNotification(cfg, Notification{Event: event, Subject: subject, Message: message})
+}
+
+// notifyAction sends a notification about an action, with its details.
+func notifyAction(cfg *Config, event, subject string, details ActionDetails) {
+	sendNotification(cfg, Notification{
+		Event:   event,
+		Subject: subject,
+		Message: details.String(),
+		Action:  &details,
+	})
+}
+
+func sendNotification(cfg *Config, n Notification) {
+	n.RunID = cfg.runID
+	for _, route := range cfg.notificationRoutes {
+		if !route.matches(n.Event) {
+			continue
+		}
+		if err := route.Notifier.Notify(n); err != nil {
+			logger.Println("Failed to send the", n.Event, "notification:", err.Error())
+		}
+	}
+}
+
+// notifyRunSummary sends the summary of the replacements performed during the
+// run, if any.
+func notifyRunSummary(cfg *Config) {
+	if cfg.changes == nil {
+		return
+	}
+
+	changes := cfg.changes.list()
+	if len(changes) == 0 {
+		return
+	}
+
+	var lines []string
+	for _, c := range changes {
+		lines = append(lines, c.String())
+	}
+	notify(cfg, SummaryEvent,
+		fmt.Sprintf("AutoSpotting replaced %d on-demand instances with spot instances", len(changes)),
+		strings.Join(lines, "\n"))
+}
+
+// snsNotifier publishes the notifications to an SNS topic.
+type snsNotifier struct {
+	cfg      *Config
+	topicARN string
+	svc      snsiface.SNSAPI
+}
+
+func (s *snsNotifier) Notify(n Notification) error {
+	svc := s.svc
+	if svc == nil {
+		region := topicRegion(s.topicARN)
+		if region == "" {
+			region = s.cfg.MainRegion
+		}
+		svc = sns.New(newSession(s.cfg, region))
+	}
+	return publishNotification(svc, s.topicARN, n.Subject, n.Message)
+}
+
+func publishNotification(svc snsiface.SNSAPI, topicARN, subject, message string) error {
+	// SNS rejects subjects longer than 100 characters
+	if len(subject) > 100 {
+		subject = subject[:100]
+	}
+
+	_, err := svc.Publish(&sns.PublishInput{
+		TopicArn: aws.String(topicARN),
+		Subject:  aws.String(subject),
+		Message:  aws.String(message),
+	})
+	if err != nil {
+		logger.Println("Failed to publish notification to", topicARN, err.Error())
+		return err
+	}
+	return nil
+}
+
+// slackNotifier posts the notifications to a Slack incoming webhook.
+type slackNotifier struct {
+	client *http.Client
+	url    string
+}
+
+func (s *slackNotifier) Notify(n Notification) error {
+	return postNotification(s.client, s.url, map[string]string{
+		"text": fmt.Sprintf("*%s*\n%s", n.Subject, n.Message),
+	})
+}
+
+// newWebhookNotifier returns a notifier posting to the given URL, formatting
+// the notifications for Slack when it's a Slack incoming webhook.
+func newWebhookNotifier(url string) Notifier {
+	client := &http.Client{Timeout: 10 * time.Second}
+	if strings.HasPrefix(url, "https://hooks.slack.com/") {
+		return &slackNotifier{client: client, url: url}
+	}
+	return &webhookNotifier{client: client, url: url}
+}
+
+// webhookNotifier posts the notifications as JSON to an HTTP endpoint.
+type webhookNotifier struct {
+	client *http.Client
+	url    string
+}
+
+func (w *webhookNotifier) Notify(n Notification) error {
+	return postNotification(w.client, w.url, n)
+}
+
+// pagerDutyNotifier triggers PagerDuty incidents using the Events API v2.
+type pagerDutyNotifier struct {
+	client     *http.Client
+	url        string
+	routingKey string
+}
+
+func (p *pagerDutyNotifier) Notify(n Notification) error {
+	severity := "info"
+	switch n.Event {
+	case ErrorEvent:
+		severity = "error"
+	case WarningEvent:
+		severity = "warning"
+	}
+
+	return postNotification(p.client, p.url, map[string]interface{}{
+		"routing_key":  p.routingKey,
+		"event_action": "trigger",
+		"payload": map[string]interface{}{
+			"summary":        n.Subject,
+			"source":         "autospotting",
+			"severity":       severity,
+			"custom_details": map[string]string{"message": n.Message, "run_id": n.RunID},
+		},
+	})
+}
+
+func postNotification(client *http.Client, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected response from the notification endpoint: %s", resp.Status)
+	}
+	return nil
+}
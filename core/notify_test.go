@@ -0,0 +1,278 @@
+package autospotting
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_topicRegion(t *testing.T) {
+	tests := []struct {
+		arn  string
+		want string
+	}{
+		{arn: "arn:aws:sns:eu-west-1:123456789012:autospotting", want: "eu-west-1"},
+		{arn: "arn:aws:sqs:eu-west-1:123456789012:autospotting", want: ""},
+		{arn: "autospotting", want: ""},
+	}
+	for _, tt := range tests {
+		if got := topicRegion(tt.arn); got != tt.want {
+			t.Errorf("topicRegion(%s) = %s, want %s", tt.arn, got, tt.want)
+		}
+	}
+}
+
+func Test_publishNotification(t *testing.T) {
+	svc := &mockSNS{}
+	longSubject := strings.Repeat("s", 120)
+
+	if err := publishNotification(svc, "arn:topic", longSubject, "message"); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(svc.p) != 1 {
+		t.Fatalf("expected one published message, got %d", len(svc.p))
+	}
+	if got := *svc.p[0].Subject; len(got) != 100 {
+		t.Errorf("subject wasn't truncated to 100 characters: %d", len(got))
+	}
+	if *svc.p[0].TopicArn != "arn:topic" || *svc.p[0].Message != "message" {
+		t.Errorf("unexpected notification: %v", svc.p[0])
+	}
+
+	svc = &mockSNS{perr: errors.New("denied")}
+	if err := publishNotification(svc, "arn:topic", "subject", "message"); err == nil {
+		t.Error("expected the publishing error to be returned")
+	}
+}
+
+type recordingNotifier struct {
+	sent []Notification
+	err  error
+}
+
+func (r *recordingNotifier) Notify(n Notification) error {
+	r.sent = append(r.sent, n)
+	return r.err
+}
+
+func Test_parseNotificationRoute(t *testing.T) {
+	tests := []struct {
+		spec       string
+		wantEvents []string
+		wantKind   interface{}
+		wantErr    bool
+	}{
+		{spec: "sns:arn:aws:sns:eu-west-1:123456789012:autospotting", wantKind: &snsNotifier{}},
+		{spec: "error=pagerduty:0123abcd", wantEvents: []string{ErrorEvent}, wantKind: &pagerDutyNotifier{}},
+		{
+			spec:       "summary|warning=slack:https://hooks.slack.com/services/T0/B0/x?a=b",
+			wantEvents: []string{SummaryEvent, WarningEvent},
+			wantKind:   &slackNotifier{},
+		},
+		{spec: "webhook:https://example.com/hook?token=abc", wantKind: &webhookNotifier{}},
+		{
+			spec:       "replacement|interruption=webhook:https://example.com/hook",
+			wantEvents: []string{ReplacementEvent, InterruptionEvent},
+			wantKind:   &webhookNotifier{},
+		},
+		{spec: "error=email:ops@example.com", wantErr: true},
+		{spec: "outage=sns:arn", wantErr: true},
+		{spec: "slack:", wantErr: true},
+		{spec: "pagerduty", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseNotificationRoute(&Config{}, tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseNotificationRoute() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if !reflect.DeepEqual(got.Events, tt.wantEvents) {
+				t.Errorf("parseNotificationRoute() events = %v, want %v", got.Events, tt.wantEvents)
+			}
+			if reflect.TypeOf(got.Notifier) != reflect.TypeOf(tt.wantKind) {
+				t.Errorf("parseNotificationRoute() notifier = %T, want %T", got.Notifier, tt.wantKind)
+			}
+		})
+	}
+
+	route, _ := parseNotificationRoute(&Config{}, "summary=webhook:https://example.com/hook?token=abc")
+	if url := route.Notifier.(*webhookNotifier).url; url != "https://example.com/hook?token=abc" {
+		t.Errorf("parseNotificationRoute() url = %s", url)
+	}
+}
+
+func Test_notify(t *testing.T) {
+	all := &recordingNotifier{}
+	pager := &recordingNotifier{err: errors.New("unreachable")}
+	summaries := &recordingNotifier{}
+
+	cfg := &Config{
+		NotificationRoutes: []NotificationRoute{
+			{Notifier: all},
+			{Events: []string{ErrorEvent}, Notifier: pager},
+			{Events: []string{SummaryEvent}, Notifier: summaries},
+		},
+		Notifications: "warning=slack:, error=webhook:http://127.0.0.1:1/hook",
+		runID:         "run-1",
+	}
+	cfg.notificationRoutes = loadNotificationRoutes(cfg)
+	if len(cfg.notificationRoutes) != 4 {
+		t.Fatalf("loaded %d notification routes, want 4", len(cfg.notificationRoutes))
+	}
+	// the webhook can't be reached, the error is only logged
+	cfg.notificationRoutes = cfg.notificationRoutes[:3]
+
+	notify(cfg, ErrorEvent, "subject", "message")
+	notify(cfg, WarningEvent, "quota", "close to the quota")
+
+	if len(all.sent) != 2 || len(pager.sent) != 1 || len(summaries.sent) != 0 {
+		t.Errorf("unexpected notifications sent: all=%v pager=%v summaries=%v",
+			all.sent, pager.sent, summaries.sent)
+	}
+	want := Notification{Event: ErrorEvent, Subject: "subject", Message: "message", RunID: "run-1"}
+	if pager.sent[0] != want {
+		t.Errorf("sent %+v, want %+v", pager.sent[0], want)
+	}
+
+	notifyRunSummary(cfg)
+	if len(summaries.sent) != 0 {
+		t.Errorf("summary sent without any changes")
+	}
+	cfg.changes = newChangeLog()
+	cfg.changes.record(change{Region: "eu-west-1", AutoScalingGroup: "asg"})
+	notifyRunSummary(cfg)
+	if len(summaries.sent) != 1 || summaries.sent[0].Event != SummaryEvent {
+		t.Errorf("expected a summary notification, got %v", summaries.sent)
+	}
+}
+
+func Test_notifyAction(t *testing.T) {
+	all := &recordingNotifier{}
+	replacements := &recordingNotifier{}
+
+	cfg := &Config{
+		NotificationRoutes: []NotificationRoute{
+			{Notifier: all},
+			{Events: []string{ReplacementEvent}, Notifier: replacements},
+		},
+		NotificationWebhookURL: "https://hooks.slack.com/services/T0/B0/x",
+		runID:                  "run-1",
+	}
+	cfg.notificationRoutes = loadNotificationRoutes(cfg)
+	if len(cfg.notificationRoutes) != 3 {
+		t.Fatalf("loaded %d notification routes, want 3", len(cfg.notificationRoutes))
+	}
+	webhook := cfg.notificationRoutes[2]
+	if _, ok := webhook.Notifier.(*slackNotifier); !ok {
+		t.Errorf("notification webhook URL loaded as %T, want a Slack notifier", webhook.Notifier)
+	}
+	if !reflect.DeepEqual(webhook.Events, actionEvents) {
+		t.Errorf("notification webhook URL receives %v, want %v", webhook.Events, actionEvents)
+	}
+	cfg.notificationRoutes = cfg.notificationRoutes[:2]
+
+	details := ActionDetails{
+		Account:          "111111111111",
+		Region:           "eu-west-1",
+		AutoScalingGroup: "asg",
+		OldInstanceID:    "i-ondemand",
+		OldInstanceType:  "m5.large",
+		OldPrice:         0.096,
+		NewInstanceID:    "i-spot",
+		NewInstanceType:  "m5a.large",
+		NewPrice:         0.035,
+	}
+	notifyAction(cfg, ReplacementEvent, "replaced", details)
+	notifyAction(cfg, LaunchFailureEvent, "failed", ActionDetails{Error: "InsufficientInstanceCapacity"})
+
+	// the routes without event types don't receive the action notifications
+	if len(all.sent) != 0 || len(replacements.sent) != 1 {
+		t.Fatalf("unexpected notifications sent: all=%v replacements=%v", all.sent, replacements.sent)
+	}
+	n := replacements.sent[0]
+	if n.Event != ReplacementEvent || n.RunID != "run-1" || !reflect.DeepEqual(*n.Action, details) {
+		t.Errorf("unexpected notification %+v", n)
+	}
+	for _, line := range []string{
+		"Account: 111111111111",
+		"AutoScaling group: asg",
+		"Old instance: i-ondemand (m5.large, 0.09600/hour)",
+		"New instance: i-spot (m5a.large, 0.03500/hour)",
+	} {
+		if !strings.Contains(n.Message, line) {
+			t.Errorf("notification message %q doesn't contain %q", n.Message, line)
+		}
+	}
+
+	if webhook := newWebhookNotifier("https://example.com/hook"); reflect.TypeOf(webhook) != reflect.TypeOf(&webhookNotifier{}) {
+		t.Errorf("newWebhookNotifier() = %T, want a webhook notifier", webhook)
+	}
+}
+
+func Test_snsNotifier(t *testing.T) {
+	svc := &mockSNS{}
+	n := &snsNotifier{topicARN: "arn:topic", svc: svc}
+	if err := n.Notify(Notification{Subject: "subject", Message: "message"}); err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if len(svc.p) != 1 || *svc.p[0].Subject != "subject" {
+		t.Errorf("unexpected notification: %v", svc.p)
+	}
+}
+
+func Test_httpNotifiers(t *testing.T) {
+	var received map[string]interface{}
+	status := http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = nil
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	n := Notification{Event: ErrorEvent, Subject: "subject", Message: "message", RunID: "run-1"}
+
+	if err := (&slackNotifier{client: srv.Client(), url: srv.URL}).Notify(n); err != nil {
+		t.Fatalf("slack: unexpected error: %s", err.Error())
+	}
+	if received["text"] != "*subject*\nmessage" {
+		t.Errorf("slack: unexpected payload %v", received)
+	}
+
+	if err := (&webhookNotifier{client: srv.Client(), url: srv.URL}).Notify(n); err != nil {
+		t.Fatalf("webhook: unexpected error: %s", err.Error())
+	}
+	if received["event"] != ErrorEvent || received["run_id"] != "run-1" {
+		t.Errorf("webhook: unexpected payload %v", received)
+	}
+
+	n.Action = &ActionDetails{Region: "eu-west-1", AutoScalingGroup: "asg", NewInstanceType: "m5a.large"}
+	if err := (&webhookNotifier{client: srv.Client(), url: srv.URL}).Notify(n); err != nil {
+		t.Fatalf("webhook: unexpected error: %s", err.Error())
+	}
+	action, _ := received["action"].(map[string]interface{})
+	if action["asg"] != "asg" || action["new_instance_type"] != "m5a.large" || action["old_instance_id"] != nil {
+		t.Errorf("webhook: unexpected action payload %v", received)
+	}
+	n.Action = nil
+
+	if err := (&pagerDutyNotifier{client: srv.Client(), url: srv.URL, routingKey: "key"}).Notify(n); err != nil {
+		t.Fatalf("pagerduty: unexpected error: %s", err.Error())
+	}
+	payload, _ := received["payload"].(map[string]interface{})
+	if received["routing_key"] != "key" || payload["severity"] != "error" || payload["summary"] != "subject" {
+		t.Errorf("pagerduty: unexpected payload %v", received)
+	}
+
+	status = http.StatusForbidden
+	if err := (&webhookNotifier{client: srv.Client(), url: srv.URL}).Notify(n); err == nil {
+		t.Error("expected the rejected notification to return an error")
+	}
+}
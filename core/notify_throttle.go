@@ -0,0 +1,123 @@
+package autospotting
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+// notificationThrottle collects the errors of the groups during a run and
+// sends them as a single error notification for each error class, so that a
+// region-wide problem such as API throttling doesn't produce an alert for each
+// group. The same error of a group is only notified once within the window,
+// and at most limit error notifications are sent within the window. It is
+// kept in the Config, so across the runs of a warm Lambda function.
+type notificationThrottle struct {
+	mu     sync.Mutex
+	window time.Duration
+	limit  int
+
+	// when each error class was last notified for each group
+	notified map[string]time.Time
+
+	// when the error notifications were sent within the window
+	sent []time.Time
+
+	// the errors of the groups collected during the current run, by class
+	pending map[string][]string
+}
+
+func newNotificationThrottle(window time.Duration, limit int) *notificationThrottle {
+	return &notificationThrottle{
+		window:   window,
+		limit:    limit,
+		notified: make(map[string]time.Time),
+		pending:  make(map[string][]string),
+	}
+}
+
+// errorClass groups similar errors, using the code of the AWS API errors.
+func errorClass(err error) string {
+	if aerr, ok := err.(awserr.Error); ok {
+		return aerr.Code()
+	}
+	return err.Error()
+}
+
+// add collects the error of a group, unless it was already notified within
+// the window.
+func (t *notificationThrottle) add(region, group string, err error, now time.Time) {
+	class := errorClass(err)
+	key := strings.Join([]string{class, region, group}, "/")
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if last, ok := t.notified[key]; ok && now.Sub(last) < t.window {
+		debug.Println(region, group, "Not notifying again about", class, "notified at", last)
+		return
+	}
+	t.notified[key] = now
+	t.pending[class] = append(t.pending[class],
+		fmt.Sprintf("%s %s: %s", region, group, err.Error()))
+}
+
+// flush sends an error notification for each class of the errors collected
+// during the run, as long as the rate limit allows it.
+func (t *notificationThrottle) flush(cfg *Config, now time.Time) {
+	t.mu.Lock()
+
+	for key, last := range t.notified {
+		if now.Sub(last) >= t.window {
+			delete(t.notified, key)
+		}
+	}
+	var recent []time.Time
+	for _, sent := range t.sent {
+		if now.Sub(sent) < t.window {
+			recent = append(recent, sent)
+		}
+	}
+	t.sent = recent
+
+	var classes []string
+	for class := range t.pending {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+
+	type notification struct{ subject, message string }
+	var notifications []notification
+	for _, class := range classes {
+		groups := t.pending[class]
+		if t.limit > 0 && len(t.sent) >= t.limit {
+			logger.Println("Not notifying about", class, "errors in", len(groups),
+				"groups, more than", t.limit, "error notifications were sent in the last", t.window)
+			continue
+		}
+		t.sent = append(t.sent, now)
+		notifications = append(notifications, notification{
+			subject: fmt.Sprintf("AutoSpotting failed to process %d groups: %s", len(groups), class),
+			message: strings.Join(groups, "\n"),
+		})
+	}
+	t.pending = make(map[string][]string)
+	t.mu.Unlock()
+
+	for _, n := range notifications {
+		notify(cfg, ErrorEvent, n.subject, n.message)
+	}
+}
+
+// reportGroupError collects the error of a group for the error notification
+// sent at the end of the run.
+func (r *region) reportGroupError(group string, err error) {
+	if err == nil || isAuditModeError(err) || r.conf.groupErrors == nil {
+		return
+	}
+	r.conf.groupErrors.add(r.name, group, err, time.Now())
+}
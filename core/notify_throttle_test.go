@@ -0,0 +1,88 @@
+package autospotting
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+)
+
+func Test_errorClass(t *testing.T) {
+	if got := errorClass(awserr.New("Throttling", "Rate exceeded", nil)); got != "Throttling" {
+		t.Errorf("errorClass() = %s, want Throttling", got)
+	}
+	if got := errorClass(errors.New("couldn't find ondemand instance to replace")); got != "couldn't find ondemand instance to replace" {
+		t.Errorf("errorClass() = %s", got)
+	}
+}
+
+func Test_notificationThrottle(t *testing.T) {
+	notifier := &recordingNotifier{}
+	cfg := &Config{}
+	cfg.notificationRoutes = []NotificationRoute{{Notifier: notifier}}
+
+	throttle := newNotificationThrottle(time.Hour, 2)
+	start := time.Now()
+	throttled := awserr.New("Throttling", "Rate exceeded", nil)
+
+	// a region-wide throttling event, along with an unrelated error
+	for _, group := range []string{"asg-1", "asg-2", "asg-3"} {
+		throttle.add("us-east-1", group, throttled, start)
+	}
+	throttle.add("us-east-1", "asg-1", throttled, start)
+	throttle.add("us-east-1", "asg-4", errors.New("boom"), start)
+	throttle.flush(cfg, start)
+
+	if len(notifier.sent) != 2 {
+		t.Fatalf("sent %d notifications, want one for each error class: %v", len(notifier.sent), notifier.sent)
+	}
+	throttling := notifier.sent[0]
+	if throttling.Event != ErrorEvent || !strings.Contains(throttling.Subject, "3 groups: Throttling") {
+		t.Errorf("unexpected notification %+v", throttling)
+	}
+	if got := strings.Count(throttling.Message, "\n") + 1; got != 3 {
+		t.Errorf("the notification lists %d groups, want 3: %s", got, throttling.Message)
+	}
+
+	// the same errors are deduplicated within the window
+	throttle.add("us-east-1", "asg-1", throttled, start.Add(30*time.Minute))
+	throttle.flush(cfg, start.Add(30*time.Minute))
+	if len(notifier.sent) != 2 {
+		t.Errorf("the same error was notified again within the window: %v", notifier.sent[2:])
+	}
+
+	// the rate limit was reached during the window
+	throttle.add("us-east-1", "asg-5", throttled, start.Add(40*time.Minute))
+	throttle.flush(cfg, start.Add(40*time.Minute))
+	if len(notifier.sent) != 2 {
+		t.Errorf("the rate limit wasn't enforced: %v", notifier.sent[2:])
+	}
+
+	// both are reset once the window is over
+	throttle.add("us-east-1", "asg-1", throttled, start.Add(2*time.Hour))
+	throttle.flush(cfg, start.Add(2*time.Hour))
+	if len(notifier.sent) != 3 {
+		t.Errorf("the error wasn't notified again after the window: %v", notifier.sent)
+	}
+}
+
+func Test_reportGroupError(t *testing.T) {
+	cfg := &Config{groupErrors: newNotificationThrottle(time.Hour, 0)}
+	r := &region{name: "us-east-1", conf: cfg}
+
+	r.reportGroupError("asg-1", nil)
+	r.reportGroupError("asg-1", awserr.New(auditModeErrorCode, "blocked", nil))
+	if len(cfg.groupErrors.pending) != 0 {
+		t.Errorf("collected errors which aren't failures: %v", cfg.groupErrors.pending)
+	}
+
+	r.reportGroupError("asg-1", errors.New("boom"))
+	if len(cfg.groupErrors.pending["boom"]) != 1 {
+		t.Errorf("the error of the group wasn't collected: %v", cfg.groupErrors.pending)
+	}
+
+	// nothing is collected when the errors aren't notified
+	(&region{name: "us-east-1", conf: &Config{}}).reportGroupError("asg-1", errors.New("boom"))
+}
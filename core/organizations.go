@@ -0,0 +1,191 @@
+package autospotting
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/service/organizations"
+	"github.com/aws/aws-sdk-go/service/organizations/organizationsiface"
+)
+
+// DefaultOrganizationRoleName is the default name of the role assumed in the
+// accounts discovered in the organization.
+const DefaultOrganizationRoleName = "AutoSpotting"
+
+// organizationFilter selects the accounts of the organization, either those
+// of an organizational unit or root and of its nested units, or those having
+// a given tag.
+type organizationFilter struct {
+	parentID string
+	tagKey   string
+	tagValue string
+}
+
+// parseOrganizationFilters parses the OrganizationAccounts parameter, given
+// as space or comma separated organizational unit or root IDs, such as
+// ou-ab12-34cd56ef or r-ab12, and <tag key>=<tag value> account tags. The
+// invalid entries are logged and skipped.
+func parseOrganizationFilters(spec string) []organizationFilter {
+	var filters []organizationFilter
+
+	for _, entry := range strings.FieldsFunc(spec, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	}) {
+		switch {
+		case strings.Contains(entry, "="):
+			kv := strings.SplitN(entry, "=", 2)
+			if kv[0] == "" {
+				logger.Printf("Ignoring invalid organization account filter '%s'\n", entry)
+				continue
+			}
+			filters = append(filters, organizationFilter{tagKey: kv[0], tagValue: kv[1]})
+		case strings.HasPrefix(entry, "ou-") || strings.HasPrefix(entry, "r-"):
+			filters = append(filters, organizationFilter{parentID: entry})
+		default:
+			logger.Printf("Ignoring invalid organization account filter '%s'\n", entry)
+		}
+	}
+	return filters
+}
+
+// discoverAccounts returns the IDs of the active accounts of the
+// organization matching any of the given filters, sorted.
+func discoverAccounts(svc organizationsiface.OrganizationsAPI, filters []organizationFilter) ([]string, error) {
+	found := map[string]bool{}
+
+	var tagFilters []organizationFilter
+	for _, f := range filters {
+		if f.parentID == "" {
+			tagFilters = append(tagFilters, f)
+			continue
+		}
+		if err := listParentAccounts(svc, f.parentID, found); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(tagFilters) > 0 {
+		var accounts []*organizations.Account
+		if err := svc.ListAccountsPages(&organizations.ListAccountsInput{},
+			func(page *organizations.ListAccountsOutput, lastPage bool) bool {
+				accounts = append(accounts, page.Accounts...)
+				return true
+			}); err != nil {
+			return nil, err
+		}
+
+		for _, account := range accounts {
+			id := aws.StringValue(account.Id)
+			if found[id] || aws.StringValue(account.Status) != organizations.AccountStatusActive {
+				continue
+			}
+			matched, err := accountHasTag(svc, id, tagFilters)
+			if err != nil {
+				return nil, err
+			}
+			found[id] = matched
+		}
+	}
+
+	var ids []string
+	for id, ok := range found {
+		if ok {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// listParentAccounts adds the active accounts of the given organizational
+// unit or root and of its nested units.
+func listParentAccounts(svc organizationsiface.OrganizationsAPI, parentID string, found map[string]bool) error {
+	if err := svc.ListAccountsForParentPages(&organizations.ListAccountsForParentInput{
+		ParentId: aws.String(parentID),
+	}, func(page *organizations.ListAccountsForParentOutput, lastPage bool) bool {
+		for _, account := range page.Accounts {
+			if aws.StringValue(account.Status) == organizations.AccountStatusActive {
+				found[aws.StringValue(account.Id)] = true
+			}
+		}
+		return true
+	}); err != nil {
+		return err
+	}
+
+	var children []string
+	if err := svc.ListOrganizationalUnitsForParentPages(&organizations.ListOrganizationalUnitsForParentInput{
+		ParentId: aws.String(parentID),
+	}, func(page *organizations.ListOrganizationalUnitsForParentOutput, lastPage bool) bool {
+		for _, ou := range page.OrganizationalUnits {
+			children = append(children, aws.StringValue(ou.Id))
+		}
+		return true
+	}); err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		if err := listParentAccounts(svc, child, found); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accountHasTag tells if the account has any of the tags of the filters.
+func accountHasTag(svc organizationsiface.OrganizationsAPI, id string, filters []organizationFilter) (bool, error) {
+	matched := false
+	err := svc.ListTagsForResourcePages(&organizations.ListTagsForResourceInput{
+		ResourceId: aws.String(id),
+	}, func(page *organizations.ListTagsForResourceOutput, lastPage bool) bool {
+		for _, tag := range page.Tags {
+			for _, f := range filters {
+				if aws.StringValue(tag.Key) == f.tagKey && aws.StringValue(tag.Value) == f.tagValue {
+					matched = true
+				}
+			}
+		}
+		return !matched
+	})
+	return matched, err
+}
+
+// organizationRoles returns the roles assumed in the accounts of the
+// organization selected by the OrganizationAccounts parameter, using the
+// credentials of the given configuration, which need to belong to the
+// management account or to a delegated administrator of the organization.
+func organizationRoles(cfg *Config) ([]crossAccountRole, error) {
+	filters := parseOrganizationFilters(cfg.OrganizationAccounts)
+	if len(filters) == 0 {
+		return nil, nil
+	}
+
+	ids, err := discoverAccounts(organizations.New(newSession(cfg, cfg.MainRegion)), filters)
+	if err != nil {
+		return nil, err
+	}
+
+	partition := endpoints.AwsPartitionID
+	if p, ok := endpoints.PartitionForRegion(endpoints.DefaultPartitions(), cfg.MainRegion); ok {
+		partition = p.ID()
+	}
+
+	roleName := cfg.OrganizationRoleName
+	if roleName == "" {
+		roleName = DefaultOrganizationRoleName
+	}
+
+	var roles []crossAccountRole
+	for _, id := range ids {
+		roles = append(roles, crossAccountRole{
+			arn:       fmt.Sprintf("arn:%s:iam::%s:role/%s", partition, id, roleName),
+			accountID: id,
+		})
+	}
+	logger.Println("Discovered", len(roles), "accounts in the organization")
+	return roles, nil
+}
@@ -0,0 +1,92 @@
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/organizations"
+)
+
+func Test_parseOrganizationFilters(t *testing.T) {
+	got := parseOrganizationFilters("ou-ab12-34cd56ef, r-ab12 autospotting=enabled =x 123456789012 team=")
+	want := []organizationFilter{
+		{parentID: "ou-ab12-34cd56ef"},
+		{parentID: "r-ab12"},
+		{tagKey: "autospotting", tagValue: "enabled"},
+		{tagKey: "team"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseOrganizationFilters() = %+v, want %+v", got, want)
+	}
+}
+
+func Test_discoverAccounts(t *testing.T) {
+	account := func(id, status string) *organizations.Account {
+		return &organizations.Account{Id: aws.String(id), Status: aws.String(status)}
+	}
+	svc := &mockOrganizations{
+		accounts: []*organizations.Account{
+			account("111111111111", organizations.AccountStatusActive),
+			account("222222222222", organizations.AccountStatusActive),
+			account("333333333333", organizations.AccountStatusActive),
+			account("444444444444", organizations.AccountStatusSuspended),
+			account("555555555555", organizations.AccountStatusActive),
+		},
+		parentAccounts: map[string][]*organizations.Account{
+			"ou-prod":        {account("111111111111", organizations.AccountStatusActive)},
+			"ou-prod-nested": {account("222222222222", organizations.AccountStatusActive)},
+			"ou-dev":         {account("444444444444", organizations.AccountStatusSuspended)},
+		},
+		parentUnits: map[string][]string{"ou-prod": {"ou-prod-nested"}},
+		tags: map[string]map[string]string{
+			"333333333333": {"autospotting": "enabled"},
+			"444444444444": {"autospotting": "enabled"},
+			"555555555555": {"autospotting": "disabled"},
+		},
+	}
+
+	tests := []struct {
+		name string
+		spec string
+		want []string
+	}{
+		{name: "nested organizational units", spec: "ou-prod", want: []string{"111111111111", "222222222222"}},
+		{name: "suspended accounts", spec: "ou-dev", want: nil},
+		{name: "account tag", spec: "autospotting=enabled", want: []string{"333333333333"}},
+		{
+			name: "any filter",
+			spec: "ou-prod autospotting=enabled",
+			want: []string{"111111111111", "222222222222", "333333333333"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := discoverAccounts(svc, parseOrganizationFilters(tt.spec))
+			if err != nil {
+				t.Fatalf("discoverAccounts() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("discoverAccounts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	svc.listerr = errors.New("AWSOrganizationsNotInUseException")
+	if _, err := discoverAccounts(svc, parseOrganizationFilters("ou-prod")); err == nil {
+		t.Error("discoverAccounts() expected an error")
+	}
+}
+
+func Test_mergeRoles(t *testing.T) {
+	configured := []crossAccountRole{{arn: "arn:aws:iam::111111111111:role/Custom", accountID: "111111111111"}}
+	discovered := []crossAccountRole{
+		{arn: "arn:aws:iam::111111111111:role/AutoSpotting", accountID: "111111111111"},
+		{arn: "arn:aws:iam::222222222222:role/AutoSpotting", accountID: "222222222222"},
+	}
+	want := []crossAccountRole{configured[0], discovered[1]}
+	if got := mergeRoles(configured, discovered); !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeRoles() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,213 @@
+package autospotting
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// DefaultOwnershipTags is the default value of the OwnershipTags setting,
+// matching the tags set by all the previous versions of AutoSpotting.
+const DefaultOwnershipTags = launchedByTag + "=true:" + launchedForASGTag
+
+// OwnershipTag is a tag identifying the instances and spot requests launched
+// by AutoSpotting, and the key of the tag naming the group they were launched
+// for.
+type OwnershipTag struct {
+	Key string
+
+	// Empty matches any value of the tag
+	Value string
+
+	// Empty when the group of the resources isn't known
+	GroupKey string
+}
+
+func (t OwnershipTag) String() string {
+	s := t.Key
+	if t.Value != "" {
+		s += "=" + t.Value
+	}
+	if t.GroupKey != "" {
+		s += ":" + t.GroupKey
+	}
+	return s
+}
+
+func (t OwnershipTag) matches(tags []*ec2.Tag) bool {
+	for _, tag := range tags {
+		if aws.StringValue(tag.Key) == t.Key && (t.Value == "" || aws.StringValue(tag.Value) == t.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// Ownership identifies the instances and spot requests launched by
+// AutoSpotting.
+type Ownership struct {
+	// Set on the launched resources
+	Tag OwnershipTag
+
+	// Also identifying launched resources, such as the tags set by older
+	// versions or forks of AutoSpotting
+	Recognized []OwnershipTag
+
+	// The resources having any of these tags are never considered launched
+	// by AutoSpotting, even when having one of the other tags
+	Ignored []OwnershipTag
+}
+
+// ParseOwnership parses the OwnershipTags setting, given as space or comma
+// separated <key>[=<value>][:<group tag key>] entries. The first one is set
+// on the launched resources, and the others are recognized as well. The
+// entries prefixed with ! are the tags of the resources deliberately ignored.
+// For example "launched-by-autospotting=true:launched-for-asg
+// spot-fork=enabled:spot-fork-group !managed-by=terraform".
+func ParseOwnership(spec string) (Ownership, error) {
+	var o Ownership
+	var owning []OwnershipTag
+
+	for _, entry := range strings.FieldsFunc(spec, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	}) {
+		ignored := strings.HasPrefix(entry, "!")
+		t := OwnershipTag{Key: strings.TrimPrefix(entry, "!")}
+
+		if colon := strings.LastIndex(t.Key, ":"); colon >= 0 {
+			t.Key, t.GroupKey = t.Key[:colon], t.Key[colon+1:]
+		}
+		if eq := strings.Index(t.Key, "="); eq >= 0 {
+			t.Key, t.Value = t.Key[:eq], t.Key[eq+1:]
+		}
+
+		switch {
+		case t.Key == "":
+			return o, fmt.Errorf("missing tag key in %q", entry)
+		case ignored && t.GroupKey != "":
+			return o, fmt.Errorf("ignored tag %q can't name a group tag", entry)
+		case ignored:
+			o.Ignored = append(o.Ignored, t)
+		default:
+			owning = append(owning, t)
+		}
+	}
+
+	if len(owning) == 0 {
+		return o, fmt.Errorf("no tag identifying the launched resources in %q", spec)
+	}
+	if owning[0].Value == "" || owning[0].GroupKey == "" {
+		return o, fmt.Errorf("the tag %q set on the launched resources needs a value and a group tag key",
+			owning[0].String())
+	}
+	o.Tag = owning[0]
+	if len(owning) > 1 {
+		o.Recognized = owning[1:]
+	}
+	return o, nil
+}
+
+var defaultOwnership, _ = ParseOwnership(DefaultOwnershipTags)
+
+// owningTags returns the tags identifying the launched resources.
+func (o Ownership) owningTags() []OwnershipTag {
+	return append([]OwnershipTag{o.Tag}, o.Recognized...)
+}
+
+// owner tells if the resource having the given tags was launched by
+// AutoSpotting, and for which group if known.
+func (o Ownership) owner(tags []*ec2.Tag) (bool, string) {
+	for _, t := range o.Ignored {
+		if t.matches(tags) {
+			return false, ""
+		}
+	}
+	for _, t := range o.owningTags() {
+		if !t.matches(tags) {
+			continue
+		}
+		for _, tag := range tags {
+			if t.GroupKey != "" && aws.StringValue(tag.Key) == t.GroupKey {
+				return true, aws.StringValue(tag.Value)
+			}
+		}
+		return true, ""
+	}
+	return false, ""
+}
+
+// groupKeys returns the keys of the tags naming the group of the launched
+// resources.
+func (o Ownership) groupKeys() []string {
+	var keys []string
+	for _, t := range o.owningTags() {
+		if t.GroupKey != "" && indexOf(keys, t.GroupKey) < 0 {
+			keys = append(keys, t.GroupKey)
+		}
+	}
+	return keys
+}
+
+// Ownership returns the tags identifying the resources launched by
+// AutoSpotting, given by the OwnershipTags setting. An invalid setting is
+// logged and the default tags are used instead.
+func (cfg *Config) Ownership() Ownership {
+	if cfg == nil {
+		return defaultOwnership
+	}
+	if cfg.ownership != nil {
+		return *cfg.ownership
+	}
+	return cfg.parseOwnership()
+}
+
+func (cfg *Config) parseOwnership() Ownership {
+	if strings.TrimSpace(cfg.OwnershipTags) == "" {
+		return defaultOwnership
+	}
+	o, err := ParseOwnership(cfg.OwnershipTags)
+	if err != nil {
+		logger.Println("Ignoring the invalid ownership tags:", err.Error())
+		return defaultOwnership
+	}
+	return o
+}
+
+// describeOwnedSpotRequests returns the spot requests launched by
+// AutoSpotting matching the given filters.
+func (r *region) describeOwnedSpotRequests(filters []*ec2.Filter) ([]*ec2.SpotInstanceRequest, error) {
+	ownership := r.conf.Ownership()
+	seen := map[string]bool{}
+	var requests []*ec2.SpotInstanceRequest
+
+	for _, t := range ownership.owningTags() {
+		tagFilter := &ec2.Filter{Name: aws.String("tag:" + t.Key), Values: []*string{aws.String(t.Value)}}
+		if t.Value == "" {
+			tagFilter = &ec2.Filter{Name: aws.String("tag-key"), Values: []*string{aws.String(t.Key)}}
+		}
+
+		out, err := r.services.ec2.DescribeSpotInstanceRequests(&ec2.DescribeSpotInstanceRequestsInput{
+			Filters: append(append([]*ec2.Filter{}, filters...), tagFilter),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if out == nil {
+			continue
+		}
+		for _, req := range out.SpotInstanceRequests {
+			id := aws.StringValue(req.SpotInstanceRequestId)
+			if seen[id] {
+				continue
+			}
+			if owned, _ := ownership.owner(req.Tags); !owned {
+				continue
+			}
+			seen[id] = true
+			requests = append(requests, req)
+		}
+	}
+	return requests, nil
+}
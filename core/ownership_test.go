@@ -0,0 +1,187 @@
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/AutoSpotting/AutoSpotting/core/autospottingtest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func tags(kv ...string) []*ec2.Tag {
+	var t []*ec2.Tag
+	for i := 0; i < len(kv); i += 2 {
+		t = append(t, &ec2.Tag{Key: aws.String(kv[i]), Value: aws.String(kv[i+1])})
+	}
+	return t
+}
+
+func TestParseOwnership(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		want    Ownership
+		wantErr bool
+	}{
+		{
+			name: "default",
+			spec: DefaultOwnershipTags,
+			want: Ownership{Tag: OwnershipTag{Key: "launched-by-autospotting", Value: "true", GroupKey: "launched-for-asg"}},
+		},
+		{
+			name: "recognized and ignored tags",
+			spec: "owner=spot:owner-asg, legacy-spot:legacy-asg old-fork !managed-by=terraform",
+			want: Ownership{
+				Tag: OwnershipTag{Key: "owner", Value: "spot", GroupKey: "owner-asg"},
+				Recognized: []OwnershipTag{
+					{Key: "legacy-spot", GroupKey: "legacy-asg"},
+					{Key: "old-fork"},
+				},
+				Ignored: []OwnershipTag{{Key: "managed-by", Value: "terraform"}},
+			},
+		},
+		{name: "empty", spec: " ", wantErr: true},
+		{name: "only ignored tags", spec: "!managed-by", wantErr: true},
+		{name: "primary tag without value", spec: "owner:owner-asg", wantErr: true},
+		{name: "primary tag without group key", spec: "owner=spot", wantErr: true},
+		{name: "missing key", spec: "owner=spot:owner-asg =x", wantErr: true},
+		{name: "ignored tag naming a group", spec: "owner=spot:owner-asg !x:y", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseOwnership(tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseOwnership() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseOwnership() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOwnership_owner(t *testing.T) {
+	o, err := ParseOwnership("launched-by-autospotting=true:launched-for-asg legacy-spot:legacy-asg !managed-by=terraform")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		tags      []*ec2.Tag
+		wantOwned bool
+		wantGroup string
+	}{
+		{
+			name:      "primary tags",
+			tags:      tags("launched-by-autospotting", "true", "launched-for-asg", "asg"),
+			wantOwned: true,
+			wantGroup: "asg",
+		},
+		{
+			name: "primary tag with another value",
+			tags: tags("launched-by-autospotting", "false", "launched-for-asg", "asg"),
+		},
+		{
+			name:      "recognized tag with any value",
+			tags:      tags("legacy-spot", "yes", "legacy-asg", "old"),
+			wantOwned: true,
+			wantGroup: "old",
+		},
+		{
+			name:      "detached instance",
+			tags:      tags("launched-by-autospotting", "true"),
+			wantOwned: true,
+		},
+		{
+			name: "ignored tag",
+			tags: tags("launched-by-autospotting", "true", "launched-for-asg", "asg", "managed-by", "terraform"),
+		},
+		{
+			name: "untagged",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			owned, group := o.owner(tt.tags)
+			if owned != tt.wantOwned || group != tt.wantGroup {
+				t.Errorf("owner() = %v, %q, want %v, %q", owned, group, tt.wantOwned, tt.wantGroup)
+			}
+		})
+	}
+
+	if got, want := o.groupKeys(), []string{"launched-for-asg", "legacy-asg"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("groupKeys() = %v, want %v", got, want)
+	}
+}
+
+func TestConfig_Ownership(t *testing.T) {
+	if got := (&Config{}).Ownership(); !reflect.DeepEqual(got, defaultOwnership) {
+		t.Errorf("Ownership() = %+v, want the default", got)
+	}
+	if got := (&Config{OwnershipTags: "owner"}).Ownership(); !reflect.DeepEqual(got, defaultOwnership) {
+		t.Errorf("Ownership() = %+v, want the default for an invalid setting", got)
+	}
+	if got := (&Config{OwnershipTags: "owner=spot:owner-asg"}).Ownership(); got.Tag.Key != "owner" {
+		t.Errorf("Ownership() = %+v", got)
+	}
+}
+
+func TestInstanceOwnership(t *testing.T) {
+	r := &region{conf: &Config{OwnershipTags: "owner=spot:owner-asg launched-by-autospotting=true:launched-for-asg"}}
+	i := &instance{
+		Instance: &ec2.Instance{InstanceId: aws.String("i-spot")},
+		asg:      &autoScalingGroup{name: "asg"},
+		region:   r,
+	}
+
+	got := i.identificationTags()
+	if aws.StringValue(got[0].Key) != "owner" || aws.StringValue(got[0].Value) != "spot" ||
+		aws.StringValue(got[1].Key) != "owner-asg" || aws.StringValue(got[1].Value) != "asg" {
+		t.Errorf("identificationTags() = %v", got)
+	}
+
+	i.Tags = tags("launched-by-autospotting", "true", "launched-for-asg", "old")
+	if group := i.ownerGroup(r.conf.Ownership()); group != "old" {
+		t.Errorf("ownerGroup() = %q, want the group of the recognized tag", group)
+	}
+}
+
+func TestDescribeOwnedSpotRequests(t *testing.T) {
+	cloud := autospottingtest.NewCloud()
+	fr := cloud.Region("us-east-1")
+
+	request := func(id string, t []*ec2.Tag) {
+		fr.AddSpotInstanceRequest(&ec2.SpotInstanceRequest{
+			SpotInstanceRequestId: aws.String(id),
+			State:                 aws.String(ec2.SpotInstanceStateOpen),
+			Tags:                  t,
+		})
+	}
+	request("sir-current", tags("owner", "spot", "owner-asg", "asg"))
+	request("sir-legacy", tags("launched-by-autospotting", "true", "launched-for-asg", "asg"))
+	request("sir-both", tags("owner", "spot", "launched-by-autospotting", "true"))
+	request("sir-ignored", tags("owner", "spot", "managed-by", "terraform"))
+	request("sir-other", tags("team", "web"))
+
+	r := &region{
+		name:     "us-east-1",
+		conf:     &Config{OwnershipTags: "owner=spot:owner-asg launched-by-autospotting:launched-for-asg !managed-by=terraform"},
+		services: connections{ec2: cloud.EC2("us-east-1")},
+	}
+
+	requests, err := r.describeOwnedSpotRequests([]*ec2.Filter{
+		{Name: aws.String("state"), Values: []*string{aws.String(ec2.SpotInstanceStateOpen)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, req := range requests {
+		ids = append(ids, *req.SpotInstanceRequestId)
+	}
+	if want := []string{"sir-both", "sir-current", "sir-legacy"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("describeOwnedSpotRequests() = %v, want %v", ids, want)
+	}
+}
@@ -0,0 +1,106 @@
+package autospotting
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// partitionKey returns the key of the instance tag mapping the instances of
+// the group to partitions, as configured by its PartitionTag, or an empty
+// string when the group isn't partitioned.
+func (a *autoScalingGroup) partitionKey() string {
+	if a.Group == nil {
+		return ""
+	}
+	if value := a.getTagValue(PartitionTag); value != nil {
+		return *value
+	}
+	return ""
+}
+
+// partition returns the partition of the instance, given by the value of its
+// tag having the given key. The instances missing this tag share the same
+// partition.
+func (i *instance) partition(key string) string {
+	for _, tag := range i.Tags {
+		if aws.StringValue(tag.Key) == key {
+			return aws.StringValue(tag.Value)
+		}
+	}
+	return ""
+}
+
+// distinctPartitions returns the given instances, keeping only the first one
+// of each partition when the group is partitioned.
+func (a *autoScalingGroup) distinctPartitions(instances []*instance) []*instance {
+	key := a.partitionKey()
+	if key == "" {
+		return instances
+	}
+
+	seen := make(map[string]bool)
+	var distinct []*instance
+	for _, i := range instances {
+		if p := i.partition(key); !seen[p] {
+			seen[p] = true
+			distinct = append(distinct, i)
+		}
+	}
+	return distinct
+}
+
+// partitionsHealthy tells if the partitioned group can replace another
+// instance: all its members have to be in service and healthy, and its spot
+// instances have to pass its readiness probe, confirming that the partitions
+// replaced previously recovered. The groups which aren't partitioned are
+// always considered healthy.
+func (a *autoScalingGroup) partitionsHealthy() bool {
+	if a.partitionKey() == "" {
+		return true
+	}
+
+	if !a.membersHealthy() {
+		return false
+	}
+
+	for i := range a.instances.instances() {
+		if i.isSpot() && !i.isHealthy(a) {
+			return false
+		}
+	}
+	return true
+}
+
+// membersHealthy tells if all the members of the group are in service and
+// healthy.
+func (a *autoScalingGroup) membersHealthy() bool {
+	for _, member := range a.Instances {
+		if aws.StringValue(member.LifecycleState) != autoscaling.LifecycleStateInService ||
+			aws.StringValue(member.HealthStatus) != "Healthy" {
+			logger.Println(a.region.name, a.name, "Waiting for", aws.StringValue(member.InstanceId),
+				"to be in service and healthy")
+			return false
+		}
+	}
+	return true
+}
+
+// onDemandInstanceReplacedBy returns the unprotected on-demand instance of
+// the group replaced by the spot instance, in its availability zone and, when
+// the group is partitioned, in its partition, which the spot instance got
+// from the tags of the on-demand instance it was launched for.
+func (a *autoScalingGroup) onDemandInstanceReplacedBy(spot *instance) *instance {
+	key := a.partitionKey()
+	if key == "" {
+		return a.getUnprotectedOnDemandInstanceInAZ(spot.Placement.AvailabilityZone)
+	}
+
+	partition := spot.partition(key)
+	for i := range a.instances.instances() {
+		if i.partition(key) == partition && a.isReplaceableOnDemandInstance(i) &&
+			aws.StringValue(i.Placement.AvailabilityZone) == aws.StringValue(spot.Placement.AvailabilityZone) {
+			return i
+		}
+	}
+	return nil
+}
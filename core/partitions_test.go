@@ -0,0 +1,170 @@
+package autospotting
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// newPartitionedGroup returns a group of on-demand instances mapped to the
+// given partitions by their cluster-role tag.
+func newPartitionedGroup(partitions ...string) *autoScalingGroup {
+	a := newReplacementBatchGroup(len(partitions), mockEC2{})
+	a.Tags = []*autoscaling.TagDescription{
+		{Key: aws.String(PartitionTag), Value: aws.String("cluster-role")},
+	}
+	for n, p := range partitions {
+		a.Instances[n].LifecycleState = aws.String(autoscaling.LifecycleStateInService)
+		a.Instances[n].HealthStatus = aws.String("Healthy")
+		if p == "" {
+			continue
+		}
+		a.instances.get(fmt.Sprintf("i-ondemand-%d", n)).Tags = []*ec2.Tag{
+			{Key: aws.String("cluster-role"), Value: aws.String(p)},
+		}
+	}
+	return a
+}
+
+func instanceIDs(instances []*instance) []string {
+	var ids []string
+	for _, i := range instances {
+		ids = append(ids, *i.InstanceId)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func TestPartitionKey(t *testing.T) {
+	tests := []struct {
+		name string
+		asg  *autoScalingGroup
+		want string
+	}{
+		{name: "no group", asg: &autoScalingGroup{}, want: ""},
+		{name: "not partitioned", asg: newReplacementBatchGroup(1, mockEC2{}), want: ""},
+		{name: "partitioned", asg: newPartitionedGroup("primary"), want: "cluster-role"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.asg.partitionKey(); got != tt.want {
+				t.Errorf("partitionKey() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPartition(t *testing.T) {
+	a := newPartitionedGroup("primary", "")
+	if got := a.instances.get("i-ondemand-0").partition("cluster-role"); got != "primary" {
+		t.Errorf("partition() = %q, want %q", got, "primary")
+	}
+	if got := a.instances.get("i-ondemand-1").partition("cluster-role"); got != "" {
+		t.Errorf("partition() of an untagged instance = %q, want empty", got)
+	}
+}
+
+func TestDistinctPartitions(t *testing.T) {
+	a := newPartitionedGroup("primary", "primary", "replica", "")
+	candidates := []*instance{
+		a.instances.get("i-ondemand-0"),
+		a.instances.get("i-ondemand-1"),
+		a.instances.get("i-ondemand-2"),
+		a.instances.get("i-ondemand-3"),
+	}
+
+	got := instanceIDs(a.distinctPartitions(candidates))
+	if want := []string{"i-ondemand-0", "i-ondemand-2", "i-ondemand-3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("distinctPartitions() = %v, want %v", got, want)
+	}
+
+	a.Tags = nil
+	if got := a.distinctPartitions(candidates); len(got) != len(candidates) {
+		t.Errorf("distinctPartitions() of a group which isn't partitioned returned %d instances, want %d",
+			len(got), len(candidates))
+	}
+}
+
+func TestOnDemandInstancesToReplaceOnePerPartition(t *testing.T) {
+	a := newPartitionedGroup("primary", "primary", "replica", "replica")
+	a.config.ReplacementBatchSize = 4
+
+	got := a.onDemandInstancesToReplace(a.instances.get("i-ondemand-0"))
+	partitions := map[string]bool{}
+	for _, i := range got {
+		p := i.partition("cluster-role")
+		if partitions[p] {
+			t.Errorf("onDemandInstancesToReplace() returned two instances of the partition %q", p)
+		}
+		partitions[p] = true
+	}
+	if len(got) != 2 {
+		t.Errorf("onDemandInstancesToReplace() returned %v, want one instance per partition", instanceIDs(got))
+	}
+	if got[0].partition("cluster-role") != "primary" {
+		t.Errorf("onDemandInstancesToReplace() starts with %s, want the first instance", *got[0].InstanceId)
+	}
+}
+
+func TestPartitionsHealthy(t *testing.T) {
+	tests := []struct {
+		name   string
+		tagged bool
+		state  string
+		health string
+		want   bool
+	}{
+		{name: "not partitioned", state: autoscaling.LifecycleStatePending, health: "Healthy", want: true},
+		{name: "healthy", tagged: true, state: autoscaling.LifecycleStateInService, health: "Healthy", want: true},
+		{name: "pending member", tagged: true, state: autoscaling.LifecycleStatePending, health: "Healthy", want: false},
+		{name: "unhealthy member", tagged: true, state: autoscaling.LifecycleStateInService, health: "Unhealthy", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newPartitionedGroup("primary", "replica")
+			if !tt.tagged {
+				a.Tags = nil
+			}
+			a.Instances[1].LifecycleState = aws.String(tt.state)
+			a.Instances[1].HealthStatus = aws.String(tt.health)
+
+			if got := a.partitionsHealthy(); got != tt.want {
+				t.Errorf("partitionsHealthy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnDemandInstanceReplacedBy(t *testing.T) {
+	a := newPartitionedGroup("primary", "replica")
+	spot := &instance{
+		Instance: &ec2.Instance{
+			InstanceId:        aws.String("i-spot"),
+			InstanceLifecycle: aws.String(ec2.InstanceLifecycleTypeSpot),
+			Placement:         &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+			Tags: []*ec2.Tag{
+				{Key: aws.String("cluster-role"), Value: aws.String("replica")},
+			},
+		},
+	}
+
+	got := a.onDemandInstanceReplacedBy(spot)
+	if got == nil || *got.InstanceId != "i-ondemand-1" {
+		t.Errorf("onDemandInstanceReplacedBy() = %v, want i-ondemand-1", got)
+	}
+
+	spot.Tags[0].Value = aws.String("arbiter")
+	if got := a.onDemandInstanceReplacedBy(spot); got != nil {
+		t.Errorf("onDemandInstanceReplacedBy() = %s, want none for an unknown partition", *got.InstanceId)
+	}
+
+	a.Tags = nil
+	if got := a.onDemandInstanceReplacedBy(spot); got == nil {
+		t.Error("onDemandInstanceReplacedBy() of a group which isn't partitioned found no instance")
+	}
+}
@@ -0,0 +1,175 @@
+package autospotting
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// PriceTrendTag is set on the groups while a cheaper instance type is
+// beating the type of their current spot instances, its value records that
+// type and the number of consecutive runs it was cheaper, such as c5.large/2
+const PriceTrendTag = "autospotting-price-trend"
+
+// priceTrend is the cheaper instance type observed across runs
+type priceTrend struct {
+	instanceType string
+	runs         int
+}
+
+func parsePriceTrend(value string) priceTrend {
+	parts := strings.Split(value, "/")
+	if len(parts) != 2 {
+		return priceTrend{}
+	}
+	runs, err := strconv.Atoi(parts[1])
+	if err != nil || runs < 0 {
+		return priceTrend{}
+	}
+	return priceTrend{instanceType: parts[0], runs: runs}
+}
+
+func (p priceTrend) String() string {
+	return fmt.Sprintf("%s/%d", p.instanceType, p.runs)
+}
+
+// currentSpotInstanceType returns the type of the most recently launched
+// running spot instance of the group, or an empty string if there is none.
+func (a *autoScalingGroup) currentSpotInstanceType() string {
+	var latest *instance
+	for inst := range a.instances.instances() {
+		if !inst.isSpot() || inst.State == nil ||
+			aws.StringValue(inst.State.Name) != "running" {
+			continue
+		}
+		if latest == nil || aws.TimeValue(inst.LaunchTime).After(aws.TimeValue(latest.LaunchTime)) {
+			latest = inst
+		}
+	}
+	if latest == nil {
+		return ""
+	}
+	return aws.StringValue(latest.InstanceType)
+}
+
+// loadPriceTrend reads the price trend persisted by the previous runs.
+func (a *autoScalingGroup) loadPriceTrend() priceTrend {
+	if v := a.getTagValue(PriceTrendTag); v != nil {
+		return parsePriceTrend(*v)
+	}
+	return priceTrend{}
+}
+
+// storePriceTrend persists the price trend for the next runs, removing the
+// tag once there is no trend to follow anymore.
+func (a *autoScalingGroup) storePriceTrend(trend priceTrend) error {
+	if trend == a.loadPriceTrend() {
+		return nil
+	}
+
+	tag := &autoscaling.Tag{
+		ResourceId:        aws.String(a.name),
+		ResourceType:      aws.String("auto-scaling-group"),
+		Key:               aws.String(PriceTrendTag),
+		Value:             aws.String(trend.String()),
+		PropagateAtLaunch: aws.Bool(false),
+	}
+
+	var err error
+	if trend.runs == 0 {
+		_, err = a.region.services.autoScaling.DeleteTags(&autoscaling.DeleteTagsInput{
+			Tags: []*autoscaling.Tag{tag},
+		})
+	} else {
+		_, err = a.region.services.autoScaling.CreateOrUpdateTags(&autoscaling.CreateOrUpdateTagsInput{
+			Tags: []*autoscaling.Tag{tag},
+		})
+	}
+	if err != nil {
+		logger.Println(a.region.name, a.name, "Failed to record the price trend", err.Error())
+	}
+	return err
+}
+
+// applyPriceHysteresis keeps the group on the instance type of its current
+// spot instances until a cheaper type has beaten it by the configured margin
+// for the configured number of consecutive runs, so that groups don't keep
+// swapping between types whose prices cross repeatedly. The candidates are
+// returned unchanged once the swap is allowed, otherwise with the current
+// type moved to the front. The decision is taken once per run.
+func (i *instance) applyPriceHysteresis(candidates []instanceTypeInformation) []instanceTypeInformation {
+	a := i.asg
+	runs := i.region.conf.PriceHysteresisRuns
+	if runs <= 0 || len(candidates) < 2 {
+		return candidates
+	}
+
+	current := a.currentSpotInstanceType()
+	pos := -1
+	for idx, c := range candidates {
+		if c.instanceType == current {
+			pos = idx
+			break
+		}
+	}
+
+	// nothing to stick to, or the current type is already the cheapest
+	if pos <= 0 {
+		if a.priceSwapAllowed == nil {
+			a.priceSwapAllowed = aws.Bool(true)
+			a.storePriceTrend(priceTrend{})
+		}
+		return candidates
+	}
+
+	if a.priceSwapAllowed == nil {
+		a.priceSwapAllowed = aws.Bool(i.evaluatePriceTrend(candidates[0], candidates[pos], runs))
+	}
+
+	if *a.priceSwapAllowed {
+		return candidates
+	}
+
+	logger.Println(a.region.name, a.name, "Keeping the current instance type", current,
+		"until", candidates[0].instanceType, "stays cheaper for", runs, "consecutive runs")
+
+	result := append([]instanceTypeInformation{candidates[pos]}, candidates[:pos]...)
+	return append(result, candidates[pos+1:]...)
+}
+
+// evaluatePriceTrend records whether the cheapest candidate beats the current
+// instance type by the configured margin during this run, and reports if it
+// did so for enough consecutive runs to swap to it.
+func (i *instance) evaluatePriceTrend(cheapest, current instanceTypeInformation, runs int) bool {
+	a := i.asg
+	margin := i.region.conf.PriceHysteresisPercentage
+
+	cheapestPrice := i.calculatePrice(cheapest)
+	currentPrice := i.calculatePrice(current)
+
+	if cheapestPrice > currentPrice*(1-margin/100) {
+		debug.Println(a.name, cheapest.instanceType, "at", cheapestPrice,
+			"isn't sufficiently cheaper than", current.instanceType, "at", currentPrice)
+		a.storePriceTrend(priceTrend{})
+		return false
+	}
+
+	trend := a.loadPriceTrend()
+	if trend.instanceType != cheapest.instanceType {
+		trend = priceTrend{instanceType: cheapest.instanceType}
+	}
+	trend.runs++
+
+	logger.Println(a.region.name, a.name, cheapest.instanceType, "is cheaper than",
+		current.instanceType, "for", trend.runs, "of", runs, "consecutive runs")
+
+	if trend.runs >= runs {
+		a.storePriceTrend(priceTrend{})
+		return true
+	}
+	a.storePriceTrend(trend)
+	return false
+}
@@ -0,0 +1,160 @@
+package autospotting
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func TestParsePriceTrend(t *testing.T) {
+	tests := []struct {
+		value string
+		want  priceTrend
+	}{
+		{value: "c5.large/2", want: priceTrend{instanceType: "c5.large", runs: 2}},
+		{value: "c5.large", want: priceTrend{}},
+		{value: "c5.large/two", want: priceTrend{}},
+		{value: "c5.large/-1", want: priceTrend{}},
+	}
+	for _, tt := range tests {
+		if got := parsePriceTrend(tt.value); got != tt.want {
+			t.Errorf("parsePriceTrend(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestApplyPriceHysteresis(t *testing.T) {
+	spotPrice := func(instanceType string, price float64) instanceTypeInformation {
+		return instanceTypeInformation{
+			instanceType: instanceType,
+			pricing:      prices{spot: spotPriceMap{"us-east-1a": price}},
+		}
+	}
+	candidates := []instanceTypeInformation{
+		spotPrice("c5.large", 0.05),
+		spotPrice("m5.large", 0.06),
+		spotPrice("r5.large", 0.07),
+	}
+
+	tests := []struct {
+		name        string
+		runs        int
+		margin      float64
+		current     string
+		trend       string
+		want        string
+		wantTrend   string
+		wantAllowed bool
+	}{
+		{
+			name:        "disabled",
+			current:     "m5.large",
+			want:        "c5.large",
+			wantAllowed: true,
+		},
+		{
+			name:        "no spot instances yet",
+			runs:        3,
+			want:        "c5.large",
+			wantAllowed: true,
+		},
+		{
+			name:        "current type already the cheapest",
+			runs:        3,
+			current:     "c5.large",
+			trend:       "m5.large/2",
+			want:        "c5.large",
+			wantAllowed: true,
+		},
+		{
+			name:      "first run of a cheaper type",
+			runs:      3,
+			margin:    5,
+			current:   "m5.large",
+			want:      "m5.large",
+			wantTrend: "c5.large/1",
+		},
+		{
+			name:      "another type was cheaper before",
+			runs:      3,
+			margin:    5,
+			current:   "m5.large",
+			trend:     "t3.large/2",
+			want:      "m5.large",
+			wantTrend: "c5.large/1",
+		},
+		{
+			name:        "cheaper for enough runs",
+			runs:        3,
+			margin:      5,
+			current:     "m5.large",
+			trend:       "c5.large/2",
+			want:        "c5.large",
+			wantAllowed: true,
+		},
+		{
+			name:    "not cheaper by the margin",
+			runs:    3,
+			margin:  20,
+			current: "m5.large",
+			trend:   "c5.large/2",
+			want:    "m5.large",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tags := map[string]string{}
+			asg := &autoScalingGroup{
+				name:  "asg",
+				Group: &autoscaling.Group{},
+				region: &region{
+					conf: &Config{
+						PriceHysteresisRuns:       tt.runs,
+						PriceHysteresisPercentage: tt.margin,
+					},
+					services: connections{autoScaling: mockASG{tags: tags}},
+				},
+			}
+			if tt.trend != "" {
+				tags[PriceTrendTag] = tt.trend
+				asg.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(PriceTrendTag), Value: aws.String(tt.trend)},
+				}
+			}
+
+			onDemand := newTestInstance("i-ondemand", "m5.large", time.Minute)
+			members := instanceMap{"i-ondemand": onDemand}
+			if tt.current != "" {
+				members["i-old"] = newTestInstance("i-old", "r5.large", time.Hour)
+				members["i-new"] = newTestInstance("i-new", tt.current, 2*time.Minute)
+				members["i-old"].InstanceLifecycle = aws.String("spot")
+				members["i-new"].InstanceLifecycle = aws.String("spot")
+			}
+			asg.instances = makeInstancesWithCatalog(members)
+			onDemand.asg = asg
+			onDemand.region = asg.region
+
+			got := onDemand.applyPriceHysteresis(candidates)
+			if len(got) != len(candidates) {
+				t.Fatalf("applyPriceHysteresis() returned %d candidates, want %d", len(got), len(candidates))
+			}
+			if got[0].instanceType != tt.want {
+				t.Errorf("applyPriceHysteresis() starts with %s, want %s", got[0].instanceType, tt.want)
+			}
+			if tags[PriceTrendTag] != tt.wantTrend {
+				t.Errorf("price trend tag = %q, want %q", tags[PriceTrendTag], tt.wantTrend)
+			}
+			if tt.runs > 0 && *asg.priceSwapAllowed != tt.wantAllowed {
+				t.Errorf("priceSwapAllowed = %v, want %v", *asg.priceSwapAllowed, tt.wantAllowed)
+			}
+
+			// the decision is only taken once per run
+			onDemand.applyPriceHysteresis(candidates)
+			if tags[PriceTrendTag] != tt.wantTrend {
+				t.Errorf("price trend tag changed to %q on the second call", tags[PriceTrendTag])
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package autospotting
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// priority returns the processing priority of the group, given by its
+// PriorityTag.
+func (a *autoScalingGroup) priority() int {
+	value := a.getTagValue(PriorityTag)
+	if value == nil {
+		return 0
+	}
+
+	p, err := strconv.Atoi(strings.TrimSpace(*value))
+	if err != nil {
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s, expected an integer\n",
+			PriorityTag, *value, a.name)
+		return 0
+	}
+	return p
+}
+
+// onDemandCost returns the hourly cost of the running on-demand instances of
+// the group, which is what could be saved by replacing them.
+func (a *autoScalingGroup) onDemandCost() float64 {
+	var cost float64
+	for _, member := range a.Instances {
+		i := a.region.instances.get(*member.InstanceId)
+		if i != nil && !i.isSpot() {
+			cost += i.typeInfo.pricing.onDemand
+		}
+	}
+	return cost
+}
+
+// sortGroupsByPriority orders the enabled groups by their priority, and then
+// by their potential savings, so that the most important groups are processed
+// first in case the run can't get to all of them.
+func (r *region) sortGroupsByPriority() {
+	priorities := make(map[string]int)
+	costs := make(map[string]float64)
+	for _, asg := range r.enabledASGs {
+		priorities[asg.name] = asg.priority()
+		costs[asg.name] = asg.onDemandCost()
+	}
+
+	sort.SliceStable(r.enabledASGs, func(i, j int) bool {
+		a, b := r.enabledASGs[i].name, r.enabledASGs[j].name
+		if priorities[a] != priorities[b] {
+			return priorities[a] > priorities[b]
+		}
+		return costs[a] > costs[b]
+	})
+}
@@ -0,0 +1,102 @@
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_autoScalingGroup_priority(t *testing.T) {
+	tests := []struct {
+		name string
+		tags []*autoscaling.TagDescription
+		want int
+	}{
+		{name: "no tag", want: 0},
+		{
+			name: "valid tag",
+			tags: []*autoscaling.TagDescription{{Key: aws.String(PriorityTag), Value: aws.String(" 10 ")}},
+			want: 10,
+		},
+		{
+			name: "negative priority",
+			tags: []*autoscaling.TagDescription{{Key: aws.String(PriorityTag), Value: aws.String("-5")}},
+			want: -5,
+		},
+		{
+			name: "invalid tag",
+			tags: []*autoscaling.TagDescription{{Key: aws.String(PriorityTag), Value: aws.String("high")}},
+			want: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{name: "asg", Group: &autoscaling.Group{Tags: tt.tags}}
+			if got := a.priority(); got != tt.want {
+				t.Errorf("priority() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	a := &autoScalingGroup{
+		name:   "asg",
+		Group:  &autoscaling.Group{},
+		region: &region{conf: &Config{GroupOverrides: map[string]map[string]string{"asg": {"priority": "3"}}}},
+	}
+	if got := a.priority(); got != 3 {
+		t.Errorf("priority() = %v, want the priority overridden for the group", got)
+	}
+}
+
+func Test_region_sortGroupsByPriority(t *testing.T) {
+	onDemand := func(id string, price float64) *instance {
+		return &instance{
+			Instance: &ec2.Instance{InstanceId: aws.String(id)},
+			typeInfo: instanceTypeInformation{pricing: prices{onDemand: price}},
+		}
+	}
+	spot := onDemand("i-spot", 1.0)
+	spot.InstanceLifecycle = aws.String("spot")
+
+	r := &region{
+		instances: makeInstancesWithCatalog(instanceMap{
+			"i-small": onDemand("i-small", 0.1),
+			"i-large": onDemand("i-large", 0.4),
+			"i-spot":  spot,
+		}),
+	}
+
+	group := func(name string, priority string, members ...string) autoScalingGroup {
+		g := &autoscaling.Group{}
+		if priority != "" {
+			g.Tags = []*autoscaling.TagDescription{{Key: aws.String(PriorityTag), Value: aws.String(priority)}}
+		}
+		for _, m := range members {
+			g.Instances = append(g.Instances, &autoscaling.Instance{InstanceId: aws.String(m)})
+		}
+		return autoScalingGroup{Group: g, name: name, region: r}
+	}
+
+	r.enabledASGs = []autoScalingGroup{
+		group("spot-only", "", "i-spot"),
+		group("small", "", "i-small"),
+		group("large", "", "i-large"),
+		group("important", "10"),
+		group("unimportant", "-1", "i-large"),
+	}
+
+	r.sortGroupsByPriority()
+
+	var got []string
+	for _, asg := range r.enabledASGs {
+		got = append(got, asg.name)
+	}
+	want := []string{"important", "large", "small", "spot-only", "unimportant"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sortGroupsByPriority() = %v, want %v", got, want)
+	}
+}
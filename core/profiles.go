@@ -0,0 +1,111 @@
+package autospotting
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// credentialProfile is a named profile of the shared AWS configuration and
+// the region used for listing the regions of its partition.
+type credentialProfile struct {
+	name       string
+	mainRegion string
+}
+
+// parseProfiles parses the Profiles parameter, given as space or comma
+// separated <profile>[:<main region>] entries, such as
+// "default:us-east-1 govcloud:us-gov-west-1". The entries without a main
+// region use the given one.
+func parseProfiles(spec, mainRegion string) []credentialProfile {
+	var profiles []credentialProfile
+
+	for _, entry := range strings.FieldsFunc(spec, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n'
+	}) {
+		p := credentialProfile{name: entry, mainRegion: mainRegion}
+		if colon := strings.Index(entry, ":"); colon >= 0 {
+			p.name, p.mainRegion = entry[:colon], entry[colon+1:]
+		}
+		if p.name == "" || p.mainRegion == "" {
+			logger.Printf("Ignoring invalid credential profile '%s'\n", entry)
+			continue
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles
+}
+
+// newProfileSession creates a session in the given region, using the
+// credentials of the given profile of the shared AWS configuration, or the
+// default credentials when no profile is given.
+func newProfileSession(profile, region string) (*session.Session, error) {
+	if profile == "" {
+		return session.NewSession(&aws.Config{Region: aws.String(region)})
+	}
+	return session.NewSessionWithOptions(session.Options{
+		Config:            aws.Config{Region: aws.String(region)},
+		Profile:           profile,
+		SharedConfigState: session.SharedConfigEnable,
+	})
+}
+
+// checkProfile verifies that the credentials of the profile can be loaded,
+// since the SDK falls back to the default credentials when the profile
+// doesn't exist.
+func checkProfile(p credentialProfile) error {
+	sess, err := newProfileSession(p.name, p.mainRegion)
+	if err != nil {
+		return err
+	}
+	_, err = sess.Config.Credentials.Get()
+	return err
+}
+
+// profileConfigs returns the configurations used for processing each of the
+// credential profiles of the run, which only differ by their profile and
+// main region and share the state of the run, or the given configuration
+// when no profiles are configured or the AWS API clients are created by a
+// ClientProvider. The profiles which can't be loaded are logged and skipped.
+func profileConfigs(cfg *Config) []*Config {
+	if strings.TrimSpace(cfg.Profiles) == "" || cfg.ClientProvider != nil {
+		return []*Config{cfg}
+	}
+
+	var configs []*Config
+	for _, p := range parseProfiles(cfg.Profiles, cfg.MainRegion) {
+		if err := checkProfile(p); err != nil {
+			logger.Println("Ignoring credential profile", p.name, err.Error())
+			continue
+		}
+		c := *cfg
+		c.profile, c.MainRegion = p.name, p.mainRegion
+		configs = append(configs, &c)
+	}
+	return configs
+}
+
+// profileConfig returns the configuration used for processing the groups of
+// the given profile, which needs to be one of the configured profiles, or the
+// given configuration when the AWS API clients are created by a
+// ClientProvider.
+func profileConfig(cfg *Config, name string) (*Config, error) {
+	if cfg.ClientProvider != nil {
+		return cfg, nil
+	}
+
+	for _, p := range parseProfiles(cfg.Profiles, cfg.MainRegion) {
+		if p.name != name {
+			continue
+		}
+		if err := checkProfile(p); err != nil {
+			return nil, err
+		}
+		c := *cfg
+		c.profile, c.MainRegion = p.name, p.mainRegion
+		return &c, nil
+	}
+	return nil, fmt.Errorf("the credential profile %s isn't configured", name)
+}
@@ -0,0 +1,144 @@
+package autospotting
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/AutoSpotting/AutoSpotting/core/autospottingtest"
+)
+
+func Test_parseProfiles(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []credentialProfile
+	}{
+		{spec: "", want: nil},
+		{
+			spec: "commercial:us-east-1, govcloud:us-gov-west-1",
+			want: []credentialProfile{
+				{name: "commercial", mainRegion: "us-east-1"},
+				{name: "govcloud", mainRegion: "us-gov-west-1"},
+			},
+		},
+		{
+			spec: "dev prod:eu-west-1",
+			want: []credentialProfile{
+				{name: "dev", mainRegion: "us-east-1"},
+				{name: "prod", mainRegion: "eu-west-1"},
+			},
+		},
+		{spec: ":eu-west-1 dev:", want: nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			if got := parseProfiles(tt.spec, "us-east-1"); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseProfiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// useSharedConfig points the SDK to shared configuration files having
+// credentials for the given profiles, and returns a function restoring the
+// environment.
+func useSharedConfig(t *testing.T, profiles ...string) func() {
+	dir, err := ioutil.TempDir("", "profiles")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var config, credentials string
+	for _, p := range profiles {
+		config += fmt.Sprintf("[profile %s]\nregion = us-east-1\n", p)
+		credentials += fmt.Sprintf("[%s]\naws_access_key_id = AKID-%s\naws_secret_access_key = SECRET\n", p, p)
+	}
+	configFile := filepath.Join(dir, "config")
+	credentialsFile := filepath.Join(dir, "credentials")
+	if err := ioutil.WriteFile(configFile, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(credentialsFile, []byte(credentials), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	env := map[string]string{
+		"AWS_CONFIG_FILE":             configFile,
+		"AWS_SHARED_CREDENTIALS_FILE": credentialsFile,
+		"AWS_EC2_METADATA_DISABLED":   "true",
+		"AWS_ACCESS_KEY_ID":           "",
+		"AWS_SECRET_ACCESS_KEY":       "",
+	}
+	saved := map[string]string{}
+	for name, value := range env {
+		saved[name] = os.Getenv(name)
+		os.Setenv(name, value)
+	}
+
+	return func() {
+		for name, value := range saved {
+			os.Setenv(name, value)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func Test_profileConfigs(t *testing.T) {
+	defer useSharedConfig(t, "commercial", "govcloud")()
+
+	cfg := &Config{MainRegion: "us-east-1", changes: newChangeLog()}
+	if got := profileConfigs(cfg); len(got) != 1 || got[0] != cfg {
+		t.Errorf("profileConfigs() without profiles = %v, want the run's configuration", got)
+	}
+
+	cfg.Profiles = "commercial missing govcloud:us-gov-west-1"
+	got := profileConfigs(cfg)
+	if len(got) != 2 {
+		t.Fatalf("profileConfigs() returned %d configurations, want 2", len(got))
+	}
+	if got[0].profile != "commercial" || got[0].MainRegion != "us-east-1" ||
+		got[1].profile != "govcloud" || got[1].MainRegion != "us-gov-west-1" {
+		t.Errorf("profileConfigs() = %+v", got)
+	}
+	if got[1].changes != cfg.changes {
+		t.Error("profileConfigs() doesn't share the state of the run")
+	}
+	if cfg.profile != "" || cfg.MainRegion != "us-east-1" {
+		t.Error("profileConfigs() changed the run's configuration")
+	}
+
+	creds, err := newSession(got[1], got[1].MainRegion).Config.Credentials.Get()
+	if err != nil || creds.AccessKeyID != "AKID-govcloud" {
+		t.Errorf("newSession() credentials = %v, %v", creds, err)
+	}
+
+	cfg.ClientProvider = autospottingtest.NewCloud()
+	if got := profileConfigs(cfg); len(got) != 1 || got[0] != cfg {
+		t.Errorf("profileConfigs() with a client provider = %v, want the run's configuration", got)
+	}
+}
+
+func Test_profileConfig(t *testing.T) {
+	defer useSharedConfig(t, "commercial", "govcloud")()
+
+	cfg := &Config{MainRegion: "us-east-1", Profiles: "commercial govcloud:us-gov-west-1"}
+	got, err := profileConfig(cfg, "govcloud")
+	if err != nil || got.profile != "govcloud" || got.MainRegion != "us-gov-west-1" {
+		t.Errorf("profileConfig() = %+v, %v", got, err)
+	}
+	if cfg.profile != "" || cfg.MainRegion != "us-east-1" {
+		t.Error("profileConfig() changed the run's configuration")
+	}
+
+	if _, err := profileConfig(cfg, "missing"); err == nil {
+		t.Error("profileConfig() expected an error for a profile which isn't configured")
+	}
+
+	cfg.ClientProvider = autospottingtest.NewCloud()
+	if got, err := profileConfig(cfg, "missing"); err != nil || got != cfg {
+		t.Errorf("profileConfig() with a client provider = %v, %v, want the run's configuration", got, err)
+	}
+}
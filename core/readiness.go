@@ -0,0 +1,157 @@
+package autospotting
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// readinessProbeTimeout limits each readiness probe, so that an unresponsive
+// instance doesn't hold the processing of its group for too long.
+const readinessProbeTimeout = 5 * time.Second
+
+// readinessProbe returns the URL and the expected status code of the
+// readiness probe configured on the group, if any.
+func (a *autoScalingGroup) readinessProbe() (string, int, bool) {
+	value := a.getTagValue(ReadinessURLTag)
+	if value == nil || strings.TrimSpace(*value) == "" {
+		return "", 0, false
+	}
+
+	status := http.StatusOK
+	if s := a.getTagValue(ReadinessStatusTag); s != nil {
+		code, err := strconv.Atoi(strings.TrimSpace(*s))
+		if err != nil {
+			logger.Printf("Ignoring invalid %s tag value '%s' on group %s, expecting %d\n",
+				ReadinessStatusTag, *s, a.name, status)
+		} else {
+			status = code
+		}
+	}
+	return strings.TrimSpace(*value), status, true
+}
+
+// passesReadinessChecks returns true if the instance passes the additional
+// readiness checks configured for its group, which are retried on the next
+// run otherwise.
+func (i *instance) passesReadinessChecks(asg *autoScalingGroup) bool {
+	return i.isHealthy(asg) && i.isRegisteredInSSM(asg) &&
+		i.isCloudInitDone(asg) && i.passesReadinessDocument(asg) &&
+		i.isHealthyInElasticBeanstalk(asg)
+}
+
+// readinessDocument returns the SSM document and its parameters configured on
+// the group to check the readiness of new instances, if any.
+func (a *autoScalingGroup) readinessDocument() (string, map[string][]*string, bool) {
+	value := a.getTagValue(ReadinessDocumentTag)
+	if value == nil || strings.TrimSpace(*value) == "" {
+		return "", nil, false
+	}
+
+	var parameters map[string][]*string
+	if p := a.getTagValue(ReadinessDocumentParametersTag); p != nil && strings.TrimSpace(*p) != "" {
+		var values map[string][]string
+		if err := json.Unmarshal([]byte(*p), &values); err != nil {
+			logger.Printf("Ignoring invalid %s tag value '%s' on group %s: %s\n",
+				ReadinessDocumentParametersTag, *p, a.name, err.Error())
+		} else {
+			parameters = make(map[string][]*string, len(values))
+			for k, v := range values {
+				parameters[k] = aws.StringSlice(v)
+			}
+		}
+	}
+	return strings.TrimSpace(*value), parameters, true
+}
+
+// passesReadinessDocument returns true if the readiness document configured
+// on the group succeeded on the instance, or if there is none.
+func (i *instance) passesReadinessDocument(asg *autoScalingGroup) bool {
+	document, parameters, ok := asg.readinessDocument()
+	if !ok {
+		return true
+	}
+	return i.ssmCommandSucceeded(asg, readinessCommandTag, document, parameters)
+}
+
+// isHealthy returns true if the instance passes the readiness probe
+// configured on its group, or if there is none. Unhealthy instances are
+// probed again on the next run.
+func (i *instance) isHealthy(asg *autoScalingGroup) bool {
+	probeURL, status, ok := asg.readinessProbe()
+	if !ok {
+		return true
+	}
+
+	if err := i.probeReadiness(probeURL, status); err != nil {
+		logger.Println(asg.name, "The spot instance", *i.InstanceId,
+			"isn't ready yet:", err.Error())
+		return false
+	}
+
+	logger.Println(asg.name, "The spot instance", *i.InstanceId, "passed the readiness probe")
+	return true
+}
+
+// probeReadiness requests the given URL from the private IP address of the
+// instance, keeping the original host name for the Host header and for the
+// TLS server name, and checks the status code of the response.
+func (i *instance) probeReadiness(probeURL string, status int) error {
+	address := i.privateAddress()
+	if address == "" {
+		return fmt.Errorf("no private IP address")
+	}
+
+	u, err := url.Parse(probeURL)
+	if err != nil {
+		return fmt.Errorf("invalid readiness URL %s: %s", probeURL, err.Error())
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported readiness URL scheme %s", u.Scheme)
+	}
+
+	host, originalHost := u.Hostname(), u.Host
+	if port := u.Port(); port != "" {
+		u.Host = net.JoinHostPort(address, port)
+	} else if strings.Contains(address, ":") {
+		u.Host = "[" + address + "]"
+	} else {
+		u.Host = address
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+	req.Host = originalHost
+
+	client := &http.Client{
+		Timeout: readinessProbeTimeout,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{ServerName: host},
+		},
+		// the redirects would point to the original host name
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != status {
+		return fmt.Errorf("%s returned %d, expecting %d", probeURL, resp.StatusCode, status)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package autospotting
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestInstanceIsHealthy(t *testing.T) {
+	var host string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host = r.Host
+		switch r.URL.Path {
+		case "/health":
+			w.WriteHeader(http.StatusOK)
+		case "/starting":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/redirect":
+			http.Redirect(w, r, "/health", http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	port := u.Port()
+
+	inst := &instance{Instance: &ec2.Instance{
+		InstanceId:       aws.String("i-spot"),
+		PrivateIpAddress: aws.String("127.0.0.1"),
+	}}
+
+	tests := []struct {
+		name string
+		tags map[string]string
+		want bool
+	}{
+		{
+			name: "no probe configured",
+			want: true,
+		},
+		{
+			name: "healthy",
+			tags: map[string]string{ReadinessURLTag: "http://app.internal:" + port + "/health"},
+			want: true,
+		},
+		{
+			name: "not ready yet",
+			tags: map[string]string{ReadinessURLTag: "http://app.internal:" + port + "/starting"},
+			want: false,
+		},
+		{
+			name: "expected status",
+			tags: map[string]string{
+				ReadinessURLTag:    "http://app.internal:" + port + "/starting",
+				ReadinessStatusTag: "503",
+			},
+			want: true,
+		},
+		{
+			name: "redirects are not followed",
+			tags: map[string]string{ReadinessURLTag: "http://app.internal:" + port + "/redirect"},
+			want: false,
+		},
+		{
+			name: "unsupported scheme",
+			tags: map[string]string{ReadinessURLTag: "tcp://app.internal:" + port},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := inst.isHealthy(newTestGroup(tt.tags)); got != tt.want {
+				t.Errorf("isHealthy() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	inst.isHealthy(newTestGroup(map[string]string{ReadinessURLTag: "http://app.internal:" + port + "/health"}))
+	if host != "app.internal:"+port {
+		t.Errorf("the original host wasn't kept in the request: %s", host)
+	}
+
+	noIP := &instance{Instance: &ec2.Instance{InstanceId: aws.String("i-spot")}}
+	if noIP.isHealthy(newTestGroup(map[string]string{ReadinessURLTag: server.URL})) {
+		t.Error("expected an instance without private IP address not to be healthy")
+	}
+}
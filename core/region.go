@@ -2,10 +2,13 @@ package autospotting
 
 import (
 	"errors"
+	"hash/fnv"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
@@ -35,6 +38,43 @@ type region struct {
 
 	tagsToFilterASGsBy []Tag
 
+	// spot vCPU quota usage, nil when the quotas aren't checked
+	quotas *spotQuotas
+
+	// spot capacity failures seen during this run, shared by all the groups
+	capacity *capacityFailures
+
+	// Spot placement scores fetched during this run
+	spotScores spotPlacementScoreCache
+
+	// names of the groups enabled by their tags, even when they are not
+	// processed during this run, nil if they couldn't be determined
+	taggedASGs map[string]bool
+
+	// number of active zonal reserved instances by instance type and
+	// availability zone, loaded once when first needed
+	reservationsOnce sync.Once
+	reservations     map[string]int64
+
+	// License Manager license configurations by ARN, nil when they couldn't
+	// be fetched
+	licensesMu sync.Mutex
+	licenses   map[string]*licenseConfiguration
+
+	// architectures of the AMIs used for converting instances to another
+	// architecture, by AMI ID, and the AMIs read from SSM parameters, by
+	// parameter name
+	imagesMu           sync.Mutex
+	imageArchitectures map[string]string
+	imageParameters    map[string]string
+
+	// boot modes of the AMIs and instance types described during this run
+	bootModeCache bootModeCache
+
+	// whether the spot instances of the groups may be launched with each
+	// instance type, checked by dry runs during this run
+	launchPermissions launchPermissionCache
+
 	wg sync.WaitGroup
 }
 
@@ -82,12 +122,20 @@ func (r *region) processRegion() {
 	logger.Println("Scanning for enabled AutoScaling groups in ", r.name)
 	r.scanForEnabledAutoScalingGroups()
 
+	r.cancelUnmanagedSpotRequests()
+
 	// only process further the region if there are any enabled autoscaling groups
-	// within it
-	if r.hasEnabledAutoScalingGroups() {
+	// within it, or standalone instances may need to be replaced
+	if r.hasEnabledAutoScalingGroups() || r.conf.StandaloneInstanceSupport {
+
+		// the standalone instances may run in any availability zone
+		var zones []string
+		if !r.conf.StandaloneInstanceSupport {
+			zones = r.enabledZones()
+		}
 
 		logger.Println("Scanning full instance information in", r.name)
-		r.determineInstanceTypeInformation(r.conf)
+		r.determineInstanceTypeInformation(r.conf, zones)
 
 		debug.Println(spew.Sdump(r.instanceTypeInformation))
 
@@ -97,8 +145,17 @@ func (r *region) processRegion() {
 			logger.Printf("Failed to scan instances in %s error: %s\n", r.name, err)
 		}
 
+		r.quotas = r.newSpotQuotas()
+		r.capacity = newCapacityFailures()
+
 		logger.Println("Processing enabled AutoScaling groups in", r.name)
 		r.processEnabledAutoScalingGroups()
+
+		r.processStandaloneInstances()
+
+		r.runChaos()
+
+		r.cleanupOrphans()
 	} else {
 		logger.Println(r.name, "has no enabled AutoScaling groups")
 	}
@@ -190,12 +247,40 @@ func (r *region) addInstance(inst *ec2.Instance) {
 	})
 }
 
-func (r *region) determineInstanceTypeInformation(cfg *Config) {
+// determineInstanceTypeInformation loads the instance types available in the
+// region, along with their spot prices in the given availability zones, or in
+// all the zones of the region when none are given.
+func (r *region) determineInstanceTypeInformation(cfg *Config, zones []string) {
+
+	r.loadInstanceTypeInformation(cfg)
+
+	// this is safe to do once outside of the loop because the call will only
+	// return entries about the available instance types, so no invalid instance
+	// types would be returned
+
+	if err := r.requestSpotPrices(zones); err != nil {
+		logger.Println(err.Error())
+	}
+
+	debug.Println(spew.Sdump(r.instanceTypeInformation))
+}
+
+// loadInstanceTypeInformation populates the hardware specs and on-demand
+// pricing of all the instance types available in the region from the static
+// data given in the configuration.
+func (r *region) loadInstanceTypeInformation(cfg *Config) {
 
 	r.instanceTypeInformation = make(map[string]instanceTypeInformation)
 
 	var info instanceTypeInformation
 
+	// a zero multiplier means it wasn't set at all, such as when used as a
+	// library, so we should use the actual on-demand price
+	multiplier := cfg.OnDemandPriceMultiplier
+	if multiplier == 0 {
+		multiplier = 1.0
+	}
+
 	for _, it := range *cfg.InstanceData {
 
 		var price prices
@@ -203,7 +288,7 @@ func (r *region) determineInstanceTypeInformation(cfg *Config) {
 		debug.Println(it)
 
 		// populate on-demand information
-		price.onDemand = it.Pricing[r.name].Linux.OnDemand * cfg.OnDemandPriceMultiplier
+		price.onDemand = it.Pricing[r.name].Linux.OnDemand * multiplier
 		price.spot = make(spotPriceMap)
 		price.ebsSurcharge = it.Pricing[r.name].EBSSurcharge
 
@@ -235,24 +320,24 @@ func (r *region) determineInstanceTypeInformation(cfg *Config) {
 			r.instanceTypeInformation[it.InstanceType] = info
 		}
 	}
-	// this is safe to do once outside of the loop because the call will only
-	// return entries about the available instance types, so no invalid instance
-	// types would be returned
-
-	if err := r.requestSpotPrices(); err != nil {
-		logger.Println(err.Error())
-	}
-
-	debug.Println(spew.Sdump(r.instanceTypeInformation))
 }
 
-func (r *region) requestSpotPrices() error {
+func (r *region) requestSpotPrices(zones []string) error {
 
 	s := spotPrices{conn: r.services}
 
-	// Retrieve all current spot prices from the current region.
+	instanceTypes := make([]string, 0, len(r.instanceTypeInformation))
+	for it := range r.instanceTypeInformation {
+		instanceTypes = append(instanceTypes, it)
+	}
+
+	// Retrieve the current spot prices of the instance types available in the
+	// region, by instance family and availability zone, sharing them with the
+	// other groups and commands of the run. The zone names differ between
+	// accounts, so the prices are cached by account.
 	// TODO: add support for other OSes
-	err := s.fetch(r.conf.SpotProductDescription, 0, nil, nil)
+	scope := strings.Join([]string{r.conf.profile, r.conf.accountID, r.name}, "/")
+	err := s.fetchBatches(r.conf.spotPrices, scope, r.conf.SpotProductDescription, zones, instanceTypes)
 
 	if err != nil {
 		return errors.New("Couldn't fetch spot prices in " + r.name)
@@ -260,7 +345,15 @@ func (r *region) requestSpotPrices() error {
 
 	// logger.Println("Spot Price list in ", r.name, ":\n", s.data)
 
-	for _, priceInfo := range s.data {
+	r.applySpotPrices(s.data)
+
+	return nil
+}
+
+// applySpotPrices stores the given spot price history entries into the
+// pricing information of their respective instance types.
+func (r *region) applySpotPrices(data []*ec2.SpotPrice) {
+	for _, priceInfo := range data {
 
 		instType, az := *priceInfo.InstanceType, *priceInfo.AvailabilityZone
 
@@ -282,8 +375,6 @@ func (r *region) requestSpotPrices() error {
 		r.instanceTypeInformation[instType].pricing.spot[az] = price
 
 	}
-
-	return nil
 }
 
 func tagsMatch(asgTag *autoscaling.TagDescription, filteringTag Tag) bool {
@@ -352,27 +443,114 @@ func (r *region) isStackUpdating(stackName *string) (string, bool) {
 	return "", false
 }
 
+// isSnoozed returns true if the group is tagged to be left alone until a
+// moment in the future, which is also returned.
+func isSnoozed(group *autoscaling.Group, now time.Time) (time.Time, bool) {
+	value := getTagValueFromASGWithMatchingTag(group, Tag{Key: SnoozeUntilTag, Value: "*"})
+	if value == nil {
+		return time.Time{}, false
+	}
+
+	until, err := time.Parse(time.RFC3339, strings.TrimSpace(*value))
+	if err != nil {
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s, expected "+
+			"a RFC3339 timestamp such as 2019-07-01T00:00:00Z\n",
+			SnoozeUntilTag, *value, *group.AutoScalingGroupName)
+		return time.Time{}, false
+	}
+
+	return until, now.Before(until)
+}
+
+// isDeploymentInProgress returns true if the group is tagged with the given
+// deployment freeze tag set to true, such as deployment-in-progress=true.
+func isDeploymentInProgress(group *autoscaling.Group, tagKey string) bool {
+	if tagKey == "" {
+		return false
+	}
+
+	value := getTagValueFromASGWithMatchingTag(group, Tag{Key: tagKey, Value: "*"})
+	if value == nil {
+		return false
+	}
+
+	inProgress, err := strconv.ParseBool(strings.TrimSpace(*value))
+	if err != nil {
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s\n",
+			tagKey, *value, *group.AutoScalingGroupName)
+		return false
+	}
+	return inProgress
+}
+
+// rolloutBucket deterministically maps a group name to a number between 0
+// and 99, so that the groups included in a partial rollout stay the same
+// across runs and only new ones get added as the percentage grows.
+func rolloutBucket(asgName string) uint32 {
+	h := fnv.New32a()
+	h.Write([]byte(asgName))
+	return h.Sum32() % 100
+}
+
+// isInRollout returns true if the group is among the given percentage of
+// groups AutoSpotting is rolled out to. Percentages outside the 0-100 range
+// (exclusive) don't restrict the rollout.
+func isInRollout(asgName string, percentage float64) bool {
+	if percentage <= 0 || percentage >= 100 {
+		return true
+	}
+	return float64(rolloutBucket(asgName)) < percentage
+}
+
+// matchesTagFilters tells if the group is enabled by its tags, according to
+// the configured filtering mode and tag filters.
+func (r *region) matchesTagFilters(group *autoscaling.Group, tagsToMatch []Tag) bool {
+	optInFilterMode := (r.conf.TagFilteringMode != "opt-out")
+
+	// Go lacks a logical XOR operator, this is the equivalent to that logical
+	// expression. The goal is to add the matching ASGs when running in opt-in
+	// mode and the other way round.
+	return optInFilterMode == isASGWithMatchingTags(group, tagsToMatch)
+}
+
 func (r *region) findMatchingASGsInPageOfResults(groups []*autoscaling.Group,
 	tagsToMatch []Tag) []autoScalingGroup {
 
 	var asgs []autoScalingGroup
-	var optInFilterMode = (r.conf.TagFilteringMode != "opt-out")
 
 	tagCloudFormationStackName := Tag{Key: "aws:cloudformation:stack-name", Value: "*"}
 
 	for _, group := range groups {
 		asgName := *group.AutoScalingGroupName
-		groupMatchesExpectedTags := isASGWithMatchingTags(group, tagsToMatch)
-		// Go lacks a logical XOR operator, this is the equivalent to that logical
-		// expression. The goal is to add the matching ASGs when running in opt-in
-		// mode and the other way round.
-		if optInFilterMode != groupMatchesExpectedTags {
+		if !r.matchesTagFilters(group, tagsToMatch) {
 			logger.Printf("Skipping group %s because its tags, the currently "+
 				"configured filtering mode (%s) and tag filters do not align\n",
 				asgName, r.conf.TagFilteringMode)
 			continue
 		}
 
+		if r.taggedASGs != nil {
+			r.taggedASGs[asgName] = true
+		}
+
+		if !isInRollout(asgName, r.conf.RolloutPercentage) {
+			logger.Printf("Skipping group %s because it is outside the current "+
+				"rollout of %.1f%% of the groups\n", asgName, r.conf.RolloutPercentage)
+			continue
+		}
+
+		if until, snoozed := isSnoozed(group, time.Now()); snoozed {
+			logger.Printf("Skipping group %s because it is snoozed until %s\n",
+				asgName, until.Format(time.RFC3339))
+			continue
+		}
+
+		if isDeploymentInProgress(group, r.conf.DeploymentFreezeTag) {
+			logger.Printf("Skipping group %s because its %s tag indicates a "+
+				"deployment in progress\n", asgName, r.conf.DeploymentFreezeTag)
+			continue
+		}
+
 		if stackName := getTagValueFromASGWithMatchingTag(group, tagCloudFormationStackName); stackName != nil {
 			logger.Println("Stack: ", *stackName)
 			if status, updating := r.isStackUpdating(stackName); updating {
@@ -382,9 +560,20 @@ func (r *region) findMatchingASGsInPageOfResults(groups []*autoscaling.Group,
 			}
 		}
 
+		if r.conf.ElasticBeanstalkSupport {
+			if environmentID := elasticBeanstalkEnvironment(group); environmentID != nil {
+				if status, updating := r.isEnvironmentUpdating(environmentID); updating {
+					logger.Printf("Skipping group %s because Elastic Beanstalk environment %s is %s\n",
+						asgName, *environmentID, status)
+					continue
+				}
+			}
+		}
+
 		logger.Printf("Enabling group %s for processing because its tags, the "+
 			"currently configured  filtering mode (%s) and tag filters are aligned\n",
 			asgName, r.conf.TagFilteringMode)
+		addLogGroup(asgName)
 		asgs = append(asgs, autoScalingGroup{
 			Group:  group,
 			name:   asgName,
@@ -398,6 +587,8 @@ func (r *region) scanForEnabledAutoScalingGroups() {
 
 	svc := r.services.autoScaling
 
+	r.taggedASGs = make(map[string]bool)
+
 	pageNum := 0
 	err := svc.DescribeAutoScalingGroupsPages(
 		&autoscaling.DescribeAutoScalingGroupsInput{},
@@ -412,10 +603,30 @@ func (r *region) scanForEnabledAutoScalingGroups() {
 
 	if err != nil {
 		logger.Println("Failed to describe AutoScalingGroups in", r.name, err.Error())
+		r.taggedASGs = nil
 	}
 
 }
 
+// enabledZones returns the availability zones of the enabled groups.
+func (r *region) enabledZones() []string {
+	seen := make(map[string]bool)
+	var zones []string
+	for _, asg := range r.enabledASGs {
+		if asg.Group == nil {
+			continue
+		}
+		for _, az := range asg.AvailabilityZones {
+			if !seen[*az] {
+				seen[*az] = true
+				zones = append(zones, *az)
+			}
+		}
+	}
+	sort.Strings(zones)
+	return zones
+}
+
 func (r *region) hasEnabledAutoScalingGroups() bool {
 
 	return len(r.enabledASGs) > 0
@@ -423,14 +634,35 @@ func (r *region) hasEnabledAutoScalingGroups() bool {
 }
 
 func (r *region) processEnabledAutoScalingGroups() {
+	r.sortGroupsByPriority()
+
+	// limits the number of groups processed in parallel when configured, so
+	// that the groups are processed in the order of their priority
+	var slots chan struct{}
+	if r.conf.MaxConcurrentGroups > 0 {
+		slots = make(chan struct{}, r.conf.MaxConcurrentGroups)
+	}
+
 	for _, asg := range r.enabledASGs {
 
+		// the canary groups were already processed at the beginning of the run
+		if r.conf.isCanary(asg.name) {
+			continue
+		}
+
 		// Pass default configs to the group
 		asg.config = r.conf.AutoScalingConfig
 
+		if slots != nil {
+			slots <- struct{}{}
+		}
+
 		r.wg.Add(1)
 		go func(a autoScalingGroup) {
-			a.process()
+			r.reportGroupError(a.name, a.process())
+			if slots != nil {
+				<-slots
+			}
 			r.wg.Done()
 		}(asg)
 	}
@@ -0,0 +1,122 @@
+package autospotting
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// FailoverRequest describes the replacement capacity which could be
+// provisioned in the failover region of a group, because none of the
+// compatible spot instance types could be launched in its home region for
+// lack of spot capacity.
+type FailoverRequest struct {
+	RunID            string `json:"run_id"`
+	Account          string `json:"account,omitempty"`
+	Region           string `json:"region"`
+	FailoverRegion   string `json:"failover_region"`
+	AutoScalingGroup string `json:"asg"`
+
+	// The on-demand instance which couldn't be replaced
+	InstanceID    string  `json:"instance_id"`
+	InstanceType  string  `json:"instance_type"`
+	OnDemandPrice float64 `json:"on_demand_price"`
+
+	// The compatible spot instance types which couldn't be launched
+	InstanceTypes []string `json:"instance_types"`
+
+	Error string `json:"error"`
+}
+
+func (r FailoverRequest) String() string {
+	return fmt.Sprintf("The spot capacity of %s in %s is exhausted for the compatible instance types %s, "+
+		"replacing the on-demand instance %s (%s) may be possible in %s",
+		r.AutoScalingGroup, r.Region, strings.Join(r.InstanceTypes, ", "), r.InstanceID, r.InstanceType,
+		r.FailoverRegion)
+}
+
+// RegionFailoverHook is called for each on-demand instance of a group tagged
+// with a failover region which couldn't be replaced because its home region
+// ran out of spot capacity, so that replacement capacity can be provisioned
+// in the failover region, for example by scaling out a standby group there.
+// Library users can implement it, while the RegionFailoverWebhookURL posts
+// the requests to an HTTP endpoint.
+type RegionFailoverHook interface {
+	Failover(r FailoverRequest) error
+}
+
+// webhookFailoverHook posts the failover requests as JSON to an HTTP
+// endpoint.
+type webhookFailoverHook struct {
+	client *http.Client
+	url    string
+}
+
+func (w *webhookFailoverHook) Failover(r FailoverRequest) error {
+	return postNotification(w.client, w.url, r)
+}
+
+// failoverHooks returns the hooks the failover requests are given to.
+func failoverHooks(cfg *Config) []RegionFailoverHook {
+	var hooks []RegionFailoverHook
+	if cfg.RegionFailoverHook != nil {
+		hooks = append(hooks, cfg.RegionFailoverHook)
+	}
+	if url := cfg.secret("region failover webhook URL", cfg.RegionFailoverWebhookURL); url != "" {
+		hooks = append(hooks, &webhookFailoverHook{client: &http.Client{Timeout: 10 * time.Second}, url: url})
+	}
+	return hooks
+}
+
+// failoverRegion returns the failover region of the group, given by its
+// RegionFailoverTag, or an empty string when it isn't region-flexible.
+func (a *autoScalingGroup) failoverRegion() string {
+	value := a.getTagValue(RegionFailoverTag)
+	if value == nil {
+		return ""
+	}
+
+	region := strings.TrimSpace(*value)
+	if m := regionPattern.FindStringSubmatch(region); m == nil || m[1] != region || region == a.region.name {
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s, expected the name of another region\n",
+			RegionFailoverTag, *value, a.name)
+		return ""
+	}
+	return region
+}
+
+// proposeFailover reports that the on-demand instance could be replaced in
+// the failover region of its group, after none of the compatible spot
+// instance types could be launched for lack of capacity, and hands the
+// request over to the failover hooks.
+func (i *instance) proposeFailover(instanceTypes []instanceTypeInformation, err error) {
+	region := i.asg.failoverRegion()
+	if region == "" {
+		return
+	}
+
+	r := FailoverRequest{
+		RunID:            i.region.conf.runID,
+		Account:          i.region.conf.accountID,
+		Region:           i.region.name,
+		FailoverRegion:   region,
+		AutoScalingGroup: i.asg.name,
+		InstanceID:       *i.InstanceId,
+		InstanceType:     *i.InstanceType,
+		OnDemandPrice:    i.price,
+		Error:            err.Error(),
+	}
+	for _, t := range instanceTypes {
+		r.InstanceTypes = append(r.InstanceTypes, t.instanceType)
+	}
+
+	logger.Println(i.region.name, i.asg.name, r.String())
+	notify(i.region.conf, WarningEvent, "AutoSpotting spot capacity exhausted in "+i.asg.name, r.String())
+
+	for _, hook := range failoverHooks(i.region.conf) {
+		if err := hook.Failover(r); err != nil {
+			logger.Println(i.region.name, i.asg.name, "Failed to request the failover to", region+":", err.Error())
+		}
+	}
+}
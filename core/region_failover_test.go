@@ -0,0 +1,122 @@
+package autospotting
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+// recordingFailoverHook records the failover requests it's given.
+type recordingFailoverHook struct {
+	requests []FailoverRequest
+}
+
+func (h *recordingFailoverHook) Failover(r FailoverRequest) error {
+	h.requests = append(h.requests, r)
+	return nil
+}
+
+func TestFailoverRegion(t *testing.T) {
+	tests := []struct {
+		name string
+		tag  *string
+		want string
+	}{
+		{name: "not region-flexible"},
+		{name: "failover region", tag: aws.String(" us-west-2 "), want: "us-west-2"},
+		{name: "availability zone", tag: aws.String("us-west-2a")},
+		{name: "home region", tag: aws.String("us-east-1")},
+		{name: "invalid", tag: aws.String("west")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newReplacementBatchGroup(0, mockEC2{})
+			if tt.tag != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(RegionFailoverTag), Value: tt.tag}}
+			}
+			if got := a.failoverRegion(); got != tt.want {
+				t.Errorf("failoverRegion() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProposeFailover(t *testing.T) {
+	tests := []struct {
+		name  string
+		rierr map[string]error
+		want  []string
+	}{
+		{
+			name: "spot capacity exhausted",
+			rierr: map[string]error{
+				"m5.large": errors.New("InsufficientInstanceCapacity"),
+				"c5.large": errors.New("InsufficientInstanceCapacity"),
+			},
+			want: []string{"m5.large", "c5.large"},
+		},
+		{
+			name: "other launch failures",
+			rierr: map[string]error{
+				"m5.large": errors.New("InsufficientInstanceCapacity"),
+				"c5.large": errors.New("InvalidParameterValue"),
+			},
+		},
+		{
+			name:  "launched",
+			rierr: map[string]error{"m5.large": errors.New("InsufficientInstanceCapacity")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hook := &recordingFailoverHook{}
+			a := newReplacementBatchGroup(1, mockEC2{rierr: tt.rierr})
+			a.Tags = []*autoscaling.TagDescription{{Key: aws.String(RegionFailoverTag), Value: aws.String("us-west-2")}}
+			a.region.conf.RegionFailoverHook = hook
+
+			a.launchSpotReplacements(a.onDemandInstancesToReplace(a.instances.get("i-ondemand-0")))
+
+			if tt.want == nil {
+				if len(hook.requests) != 0 {
+					t.Errorf("launch proposed the failovers %+v, want none", hook.requests)
+				}
+				return
+			}
+			if len(hook.requests) != 1 {
+				t.Fatalf("launch proposed the failovers %+v, want one", hook.requests)
+			}
+			r := hook.requests[0]
+			if r.FailoverRegion != "us-west-2" || r.Region != "us-east-1" || r.AutoScalingGroup != "mygroup" ||
+				r.InstanceID != "i-ondemand-0" || r.RunID != "run-1" || !reflect.DeepEqual(r.InstanceTypes, tt.want) {
+				t.Errorf("launch proposed the failover %+v", r)
+			}
+		})
+	}
+}
+
+func TestWebhookFailoverHook(t *testing.T) {
+	var received FailoverRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&received)
+	}))
+	defer srv.Close()
+
+	hooks := failoverHooks(&Config{RegionFailoverWebhookURL: srv.URL})
+	if len(hooks) != 1 {
+		t.Fatalf("failoverHooks() = %v, want the webhook", hooks)
+	}
+
+	r := FailoverRequest{Region: "us-east-1", FailoverRegion: "us-west-2", AutoScalingGroup: "batch"}
+	if err := hooks[0].Failover(r); err != nil {
+		t.Fatalf("Failover() unexpected error: %s", err.Error())
+	}
+	if received.FailoverRegion != "us-west-2" || received.AutoScalingGroup != "batch" {
+		t.Errorf("webhook received %+v, want %+v", received, r)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"math"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/autoscaling"
@@ -200,7 +201,7 @@ func TestOnDemandPriceMultiplier(t *testing.T) {
 					dspherr: nil,
 				},
 			}}
-		r.determineInstanceTypeInformation(cfg)
+		r.determineInstanceTypeInformation(cfg, nil)
 
 		actualPrice := r.instanceTypeInformation["m1.small"].pricing.onDemand
 		if math.Abs(actualPrice-tt.want) > 0.000001 {
@@ -313,6 +314,76 @@ func TestFilterAsgs(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "Test snoozed groups",
+			want: []string{"asg2", "asg3"},
+			tregion: &region{
+				tagsToFilterASGsBy: []Tag{{Key: "spot-enabled", Value: "true"}},
+				conf:               &Config{},
+				services: connections{
+					autoScaling: mockASG{
+						dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{
+							AutoScalingGroups: []*autoscaling.Group{
+								{
+									Tags: []*autoscaling.TagDescription{
+										{Key: aws.String("spot-enabled"), Value: aws.String("true"), ResourceId: aws.String("asg1")},
+										{Key: aws.String(SnoozeUntilTag), Value: aws.String("2999-01-01T00:00:00Z"), ResourceId: aws.String("asg1")},
+									},
+									AutoScalingGroupName: aws.String("asg1"),
+								},
+								{
+									Tags: []*autoscaling.TagDescription{
+										{Key: aws.String("spot-enabled"), Value: aws.String("true"), ResourceId: aws.String("asg2")},
+										{Key: aws.String(SnoozeUntilTag), Value: aws.String("2019-01-01T00:00:00Z"), ResourceId: aws.String("asg2")},
+									},
+									AutoScalingGroupName: aws.String("asg2"),
+								},
+								{
+									Tags: []*autoscaling.TagDescription{
+										{Key: aws.String("spot-enabled"), Value: aws.String("true"), ResourceId: aws.String("asg3")},
+									},
+									AutoScalingGroupName: aws.String("asg3"),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "Test partial rollout",
+			want: []string{"asg2", "asg3"},
+			tregion: &region{
+				tagsToFilterASGsBy: []Tag{{Key: "spot-enabled", Value: "true"}},
+				conf:               &Config{RolloutPercentage: 60},
+				services: connections{
+					autoScaling: mockASG{
+						dasgo: &autoscaling.DescribeAutoScalingGroupsOutput{
+							AutoScalingGroups: []*autoscaling.Group{
+								{
+									Tags: []*autoscaling.TagDescription{
+										{Key: aws.String("spot-enabled"), Value: aws.String("true"), ResourceId: aws.String("asg1")},
+									},
+									AutoScalingGroupName: aws.String("asg1"),
+								},
+								{
+									Tags: []*autoscaling.TagDescription{
+										{Key: aws.String("spot-enabled"), Value: aws.String("true"), ResourceId: aws.String("asg2")},
+									},
+									AutoScalingGroupName: aws.String("asg2"),
+								},
+								{
+									Tags: []*autoscaling.TagDescription{
+										{Key: aws.String("spot-enabled"), Value: aws.String("true"), ResourceId: aws.String("asg3")},
+									},
+									AutoScalingGroupName: aws.String("asg3"),
+								},
+							},
+						},
+					},
+				},
+			},
+		},
 		{
 			name: "Test opt-out mode",
 			// Run on all groups except for those tagged with spot-enabled=false
@@ -613,6 +684,107 @@ func TestFilterAsgs(t *testing.T) {
 	}
 }
 
+func Test_isSnoozed(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name        string
+		tags        []*autoscaling.TagDescription
+		wantSnoozed bool
+	}{
+		{
+			name: "no snooze tag",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String("spot-enabled"), Value: aws.String("true")},
+			},
+		},
+		{
+			name: "snoozed until later",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(SnoozeUntilTag), Value: aws.String("2019-07-01T00:00:00Z")},
+			},
+			wantSnoozed: true,
+		},
+		{
+			name: "snooze with timezone offset",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(SnoozeUntilTag), Value: aws.String("2019-06-01T14:30:00+02:00")},
+			},
+			wantSnoozed: true,
+		},
+		{
+			name: "snooze expired",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(SnoozeUntilTag), Value: aws.String("2019-06-01T11:59:59Z")},
+			},
+		},
+		{
+			name: "invalid snooze value",
+			tags: []*autoscaling.TagDescription{
+				{Key: aws.String(SnoozeUntilTag), Value: aws.String("next week")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{AutoScalingGroupName: aws.String("asg"), Tags: tt.tags}
+			if _, got := isSnoozed(group, now); got != tt.wantSnoozed {
+				t.Errorf("isSnoozed() = %v, want %v", got, tt.wantSnoozed)
+			}
+		})
+	}
+}
+
+func Test_isDeploymentInProgress(t *testing.T) {
+	tests := []struct {
+		name   string
+		tagKey string
+		tags   []*autoscaling.TagDescription
+		want   bool
+	}{
+		{
+			name:   "no tag",
+			tagKey: DefaultDeploymentFreezeTag,
+		},
+		{
+			name:   "deployment in progress",
+			tagKey: DefaultDeploymentFreezeTag,
+			tags:   []*autoscaling.TagDescription{{Key: aws.String("deployment-in-progress"), Value: aws.String("true")}},
+			want:   true,
+		},
+		{
+			name:   "deployment finished",
+			tagKey: DefaultDeploymentFreezeTag,
+			tags:   []*autoscaling.TagDescription{{Key: aws.String("deployment-in-progress"), Value: aws.String("false")}},
+		},
+		{
+			name:   "invalid value",
+			tagKey: DefaultDeploymentFreezeTag,
+			tags:   []*autoscaling.TagDescription{{Key: aws.String("deployment-in-progress"), Value: aws.String("yes please")}},
+		},
+		{
+			name:   "custom tag",
+			tagKey: "deploying",
+			tags:   []*autoscaling.TagDescription{{Key: aws.String("deploying"), Value: aws.String("1")}},
+			want:   true,
+		},
+		{
+			name: "detection disabled",
+			tags: []*autoscaling.TagDescription{{Key: aws.String("deployment-in-progress"), Value: aws.String("true")}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &autoscaling.Group{AutoScalingGroupName: aws.String("asg"), Tags: tt.tags}
+			if got := isDeploymentInProgress(group, tt.tagKey); got != tt.want {
+				t.Errorf("isDeploymentInProgress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestIsStackUpdating(t *testing.T) {
 	stackName := "dummyStackName"
 
@@ -809,3 +981,27 @@ func Test_region_processDescribeInstancesPage(t *testing.T) {
 		})
 	}
 }
+
+func Test_isInRollout(t *testing.T) {
+	tests := []struct {
+		name       string
+		asgName    string
+		percentage float64
+		want       bool
+	}{
+		{name: "unset percentage", asgName: "asg1", percentage: 0, want: true},
+		{name: "full rollout", asgName: "asg1", percentage: 100, want: true},
+		{name: "group in rollout", asgName: "asg4", percentage: 5, want: true},
+		{name: "group outside rollout", asgName: "asg2", percentage: 25, want: false},
+		{name: "group at the rollout boundary", asgName: "asg2", percentage: 40, want: false},
+		{name: "group just inside the rollout", asgName: "asg2", percentage: 40.5, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isInRollout(tt.asgName, tt.percentage); got != tt.want {
+				t.Errorf("isInRollout() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package autospotting
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// onDemandInstancesToReplace returns the on-demand instances whose spot
+// replacements are launched on this run, starting with the given one, up to
+// the replacement batch size of the group, without going below its on-demand
+// minimum or exceeding the replacements still allowed on this run. The
+// batches of the partitioned groups contain at most one instance of each
+// partition.
+func (a *autoScalingGroup) onDemandInstancesToReplace(first *instance) []*instance {
+	size := int64(a.config.ReplacementBatchSize)
+	onDemandRunning, _ := a.alreadyRunningInstanceCount(false, "")
+	if excess := onDemandRunning - a.minOnDemand; size > excess {
+		size = excess
+	}
+	if allowance := int64(a.replacementAllowance()); allowance >= 0 && size > allowance {
+		size = allowance
+	}
+
+	candidates := []*instance{first}
+	for i := range a.instances.instances() {
+		if i == first || !a.isReplaceableOnDemandInstance(i) {
+			continue
+		}
+		candidates = append(candidates, i)
+	}
+
+	batch := a.distinctPartitions(candidates)
+	if size < 1 {
+		size = 1
+	}
+	if int64(len(batch)) > size {
+		batch = batch[:size]
+	}
+	return batch
+}
+
+// isReplaceableOnDemandInstance tells if the instance is a running on-demand
+// instance which isn't protected, and ran long enough to be replaced.
+func (a *autoScalingGroup) isReplaceableOnDemandInstance(i *instance) bool {
+	return aws.StringValue(i.State.Name) == ec2.InstanceStateNameRunning &&
+		!i.isSpot() &&
+		!i.isProtectedFromScaleIn() && !i.isProtectedFromTermination() &&
+		!i.isYoungerThan(a.minInstanceUptime())
+}
+
+// launchSpotReplacements launches the spot replacements of a batch of
+// on-demand instances and waits for all of them. The instance types are
+// chosen one instance at a time, since the price hysteresis is shared by the
+// group, then the spot instances are launched concurrently.
+func (a *autoScalingGroup) launchSpotReplacements(batch []*instance) error {
+	if len(batch) == 1 {
+		return batch[0].launchSpotReplacement()
+	}
+
+	logger.Println(a.region.name, a.name, "Launching", len(batch), "spot replacements concurrently")
+
+	errs := make([]error, len(batch))
+	candidates := make([][]instanceTypeInformation, len(batch))
+
+	// the subnets are assigned before the launches, so that they're spread
+	// over the subnets of the group and the subnets of the group are described
+	// only once, outside of the goroutines
+	var planned []string
+	for idx, odInst := range batch {
+		instanceTypes, err := odInst.spotReplacementCandidates()
+		if err != nil {
+			odInst.trace.finish(a.region.conf)
+			errs[idx] = err
+			continue
+		}
+		candidates[idx] = instanceTypes
+		odInst.spotSubnetID = odInst.spotSubnet(planned)
+		planned = append(planned, aws.StringValue(odInst.spotSubnetID))
+	}
+
+	var wg sync.WaitGroup
+	for idx, odInst := range batch {
+		if errs[idx] != nil {
+			continue
+		}
+
+		wg.Add(1)
+		go func(idx int, odInst *instance, instanceTypes []instanceTypeInformation) {
+			defer wg.Done()
+			errs[idx] = odInst.launchSpotInstance(instanceTypes)
+			odInst.trace.finish(a.region.conf)
+		}(idx, odInst, candidates[idx])
+	}
+	wg.Wait()
+
+	var failed int
+	var err error
+	for _, e := range errs {
+		if e == nil {
+			continue
+		}
+		failed++
+		if err == nil {
+			err = e
+		}
+	}
+	logger.Println(a.region.name, a.name, "Launched", len(batch)-failed, "of", len(batch), "spot replacements")
+	return err
+}
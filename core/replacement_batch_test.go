@@ -0,0 +1,234 @@
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// newReplacementBatchGroup returns a group of on-demand m4.large instances,
+// with their spot replacements of type m5.large or c5.large.
+func newReplacementBatchGroup(onDemand int, ec2Mock mockEC2) *autoScalingGroup {
+	ec2Mock.diao = &ec2.DescribeInstanceAttributeOutput{}
+	r := &region{
+		name:     "us-east-1",
+		conf:     &Config{runID: "run-1", launchTraces: &launchTraceLog{}},
+		capacity: newCapacityFailures(),
+		services: connections{ec2: ec2Mock},
+		instanceTypeInformation: map[string]instanceTypeInformation{
+			"m5.large": {
+				instanceType: "m5.large", vCPU: 2, memory: 8, PhysicalProcessor: "Intel",
+				pricing: prices{spot: map[string]float64{"us-east-1a": 0.03}},
+			},
+			"c5.large": {
+				instanceType: "c5.large", vCPU: 2, memory: 8, PhysicalProcessor: "Intel",
+				pricing: prices{spot: map[string]float64{"us-east-1a": 0.04}},
+			},
+		},
+	}
+
+	a := &autoScalingGroup{
+		name:      "mygroup",
+		region:    r,
+		instances: makeInstances(),
+		config:    AutoScalingConfig{PriceWeight: 1},
+		Group: &autoscaling.Group{
+			AutoScalingGroupName: aws.String("mygroup"),
+			LaunchTemplate: &autoscaling.LaunchTemplateSpecification{
+				LaunchTemplateName: aws.String("web"),
+				Version:            aws.String("$Latest"),
+			},
+		},
+	}
+
+	for n := 0; n < onDemand; n++ {
+		id := fmt.Sprintf("i-ondemand-%d", n)
+		a.Instances = append(a.Instances, &autoscaling.Instance{
+			InstanceId:           aws.String(id),
+			AvailabilityZone:     aws.String("us-east-1a"),
+			ProtectedFromScaleIn: aws.Bool(false),
+		})
+		a.instances.add(&instance{
+			Instance: &ec2.Instance{
+				InstanceId:         aws.String(id),
+				InstanceType:       aws.String("m4.large"),
+				Placement:          &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+				State:              &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+				VirtualizationType: aws.String("hvm"),
+			},
+			typeInfo: instanceTypeInformation{
+				instanceType: "m4.large", vCPU: 2, memory: 8, PhysicalProcessor: "Intel",
+			},
+			price:  0.1,
+			asg:    a,
+			region: r,
+		})
+	}
+	return a
+}
+
+func TestOnDemandInstancesToReplace(t *testing.T) {
+	tests := []struct {
+		name        string
+		onDemand    int
+		minOnDemand int64
+		batchSize   int
+		want        int
+	}{
+		{name: "batch size not configured", onDemand: 4, batchSize: 0, want: 1},
+		{name: "one instance per run", onDemand: 4, batchSize: 1, want: 1},
+		{name: "batch of instances", onDemand: 4, batchSize: 3, want: 3},
+		{name: "batch larger than the group", onDemand: 2, batchSize: 5, want: 2},
+		{name: "on-demand minimum", onDemand: 4, minOnDemand: 2, batchSize: 3, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newReplacementBatchGroup(tt.onDemand, mockEC2{})
+			a.minOnDemand = tt.minOnDemand
+			a.config.ReplacementBatchSize = tt.batchSize
+
+			first := a.instances.get("i-ondemand-0")
+			got := a.onDemandInstancesToReplace(first)
+			if len(got) != tt.want {
+				t.Fatalf("onDemandInstancesToReplace() returned %d instances, want %d", len(got), tt.want)
+			}
+			if got[0] != first {
+				t.Errorf("onDemandInstancesToReplace() starts with %s, want %s", *got[0].InstanceId, *first.InstanceId)
+			}
+			seen := map[*instance]bool{}
+			for _, i := range got {
+				if seen[i] {
+					t.Errorf("onDemandInstancesToReplace() returned %s twice", *i.InstanceId)
+				}
+				seen[i] = true
+			}
+		})
+	}
+}
+
+func TestOnDemandInstancesToReplaceSkipsProtectedInstances(t *testing.T) {
+	a := newReplacementBatchGroup(3, mockEC2{})
+	a.config.ReplacementBatchSize = 3
+	a.Instances[1].ProtectedFromScaleIn = aws.Bool(true)
+
+	got := a.onDemandInstancesToReplace(a.instances.get("i-ondemand-0"))
+	var ids []string
+	for _, i := range got {
+		ids = append(ids, *i.InstanceId)
+	}
+	sort.Strings(ids)
+	if want := []string{"i-ondemand-0", "i-ondemand-2"}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("onDemandInstancesToReplace() = %v, want %v", ids, want)
+	}
+}
+
+func TestLaunchSpotReplacementsSpreadOverSubnets(t *testing.T) {
+	// the instances of the group are all running in subnet-a
+	tests := []struct {
+		method string
+		want   []string
+	}{
+		{method: RoundRobinSubnetSelection, want: []string{"subnet-a", "subnet-b", "subnet-c"}},
+		{method: LeastUsedSubnetSelection, want: []string{"subnet-b", "subnet-b", "subnet-c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			calls := &runInstancesCalls{}
+			a := newReplacementBatchGroup(3, mockEC2{
+				ri: calls,
+				dso: &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+					{SubnetId: aws.String("subnet-a"), AvailabilityZone: aws.String("us-east-1a")},
+					{SubnetId: aws.String("subnet-b"), AvailabilityZone: aws.String("us-east-1a")},
+					{SubnetId: aws.String("subnet-c"), AvailabilityZone: aws.String("us-east-1a")},
+				}},
+			})
+			a.VPCZoneIdentifier = aws.String("subnet-a,subnet-b,subnet-c")
+			a.config.ReplacementBatchSize = 3
+			a.config.SubnetSelection = tt.method
+			a.region.instances = a.instances
+			for i := range a.instances.instances() {
+				i.SubnetId = aws.String("subnet-a")
+			}
+
+			batch := a.onDemandInstancesToReplace(a.instances.get("i-ondemand-0"))
+			if err := a.launchSpotReplacements(batch); err != nil {
+				t.Fatalf("launchSpotReplacements() error = %v", err)
+			}
+
+			got := append([]string{}, calls.subnets...)
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("launchSpotReplacements() launched in %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLaunchSpotReplacements(t *testing.T) {
+	tests := []struct {
+		name      string
+		onDemand  int
+		rierr     map[string]error
+		wantTypes []string
+		wantErr   bool
+	}{
+		{
+			name:      "single replacement",
+			onDemand:  1,
+			wantTypes: []string{"m5.large"},
+		},
+		{
+			name:      "concurrent replacements",
+			onDemand:  3,
+			wantTypes: []string{"m5.large", "m5.large", "m5.large"},
+		},
+		{
+			name:      "failed launches fall back to the next instance type",
+			onDemand:  2,
+			rierr:     map[string]error{"m5.large": errors.New("InsufficientInstanceCapacity")},
+			wantTypes: []string{"c5.large", "c5.large"},
+		},
+		{
+			name:     "all launches failing",
+			onDemand: 2,
+			rierr: map[string]error{
+				"m5.large": errors.New("InsufficientInstanceCapacity"),
+				"c5.large": errors.New("InsufficientInstanceCapacity"),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := &runInstancesCalls{}
+			a := newReplacementBatchGroup(tt.onDemand, mockEC2{ri: calls, rierr: tt.rierr})
+			a.config.ReplacementBatchSize = tt.onDemand
+
+			batch := a.onDemandInstancesToReplace(a.instances.get("i-ondemand-0"))
+			err := a.launchSpotReplacements(batch)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("launchSpotReplacements() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			if !reflect.DeepEqual(calls.instanceTypes, tt.wantTypes) {
+				t.Errorf("launchSpotReplacements() launched %v, want %v", calls.instanceTypes, tt.wantTypes)
+			}
+
+			traces := a.region.conf.launchTraces.list()
+			if len(traces) != tt.onDemand {
+				t.Fatalf("launchSpotReplacements() recorded %d launch traces, want %d", len(traces), tt.onDemand)
+			}
+			for _, trace := range traces {
+				if tt.wantErr != (trace.Chosen == "") {
+					t.Errorf("launch trace of %s chose %q", trace.OnDemandInstanceID, trace.Chosen)
+				}
+			}
+		})
+	}
+}
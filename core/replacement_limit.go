@@ -0,0 +1,87 @@
+package autospotting
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// replacementLimiter counts the on-demand instances replaced with spot
+// instances during an execution, across all the groups and regions, so that
+// it never replaces more than the MaxReplacementsPerRun of the configuration.
+type replacementLimiter struct {
+	sync.Mutex
+	max   int
+	count int
+}
+
+// newReplacementLimiter returns the limiter of the current run, or nil when
+// the replacements aren't limited.
+func newReplacementLimiter(max int) *replacementLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &replacementLimiter{max: max}
+}
+
+// remaining returns how many replacements are still allowed on this run, or
+// -1 when they aren't limited.
+func (l *replacementLimiter) remaining() int {
+	if l == nil {
+		return -1
+	}
+	l.Lock()
+	defer l.Unlock()
+	return l.max - l.count
+}
+
+// take counts a replacement, returning false when the limit of the run was
+// already reached.
+func (l *replacementLimiter) take() bool {
+	if l == nil {
+		return true
+	}
+	l.Lock()
+	defer l.Unlock()
+	if l.count >= l.max {
+		return false
+	}
+	l.count++
+	return true
+}
+
+// replacementAllowance returns how many on-demand instances of the group can
+// still be replaced on this run, given the limit of the group, the
+// replacements already done by the run and the changes of the group within
+// the current hour, or -1 when they aren't limited.
+func (a *autoScalingGroup) replacementAllowance() int {
+	allowance := a.region.conf.replacements.remaining()
+	if limit := a.config.MaxReplacementsPerRun; limit > 0 && (allowance < 0 || limit < allowance) {
+		allowance = limit
+	}
+	if limit := a.changeAllowance(time.Now()); limit >= 0 && (allowance < 0 || limit < allowance) {
+		allowance = limit
+	}
+	return allowance
+}
+
+// LoadMaxReplacementsPerRun loads the maximum number of on-demand instances
+// of the group replaced on each run from its tag, falling back to the global
+// value when the tag is missing or invalid.
+func (a *autoScalingGroup) LoadMaxReplacementsPerRun() {
+	a.config.MaxReplacementsPerRun = a.region.conf.MaxReplacementsPerRun
+
+	tagValue, key := a.getFirstTagValue(MaxReplacementsPerRunTag, MaxReplacementsPerRunAliasTag)
+	if tagValue == nil {
+		debug.Println("Couldn't find tag", MaxReplacementsPerRunTag, "on the group", a.name, "using the default configuration")
+		return
+	}
+
+	value, err := strconv.Atoi(*tagValue)
+	if err != nil || value < 0 {
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s\n", key, *tagValue, a.name)
+		return
+	}
+	logger.Printf("Loaded MaxReplacementsPerRun value %v from tag %v\n", value, key)
+	a.config.MaxReplacementsPerRun = value
+}
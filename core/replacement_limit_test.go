@@ -0,0 +1,101 @@
+package autospotting
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+)
+
+func TestReplacementLimiter(t *testing.T) {
+	var unlimited *replacementLimiter
+	if newReplacementLimiter(0) != nil {
+		t.Errorf("newReplacementLimiter(0) limited the replacements")
+	}
+	if !unlimited.take() || unlimited.remaining() != -1 {
+		t.Errorf("a nil limiter limited the replacements")
+	}
+
+	l := newReplacementLimiter(2)
+	if !l.take() || l.remaining() != 1 {
+		t.Fatalf("take() didn't count the first replacement, %d remaining", l.remaining())
+	}
+	if !l.take() || l.remaining() != 0 {
+		t.Fatalf("take() didn't count the second replacement, %d remaining", l.remaining())
+	}
+	if l.take() {
+		t.Errorf("take() allowed a replacement over the limit")
+	}
+}
+
+func TestReplacementAllowance(t *testing.T) {
+	tests := []struct {
+		name       string
+		runLimit   int
+		taken      int
+		groupLimit int
+		batchSize  int
+		want       int
+		wantBatch  int
+	}{
+		{name: "unlimited", batchSize: 3, want: -1, wantBatch: 3},
+		{name: "run limit", runLimit: 5, taken: 3, batchSize: 3, want: 2, wantBatch: 2},
+		{name: "group limit", groupLimit: 1, batchSize: 3, want: 1, wantBatch: 1},
+		{name: "group limit within the run limit", runLimit: 5, taken: 4, groupLimit: 2, batchSize: 3, want: 1, wantBatch: 1},
+		{name: "run limit within the group limit", runLimit: 10, groupLimit: 2, batchSize: 3, want: 2, wantBatch: 2},
+		{name: "run limit reached", runLimit: 2, taken: 2, batchSize: 3, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newReplacementBatchGroup(4, mockEC2{})
+			a.region.conf.replacements = newReplacementLimiter(tt.runLimit)
+			for n := 0; n < tt.taken; n++ {
+				a.region.conf.replacements.take()
+			}
+			a.config.MaxReplacementsPerRun = tt.groupLimit
+			a.config.ReplacementBatchSize = tt.batchSize
+
+			if got := a.replacementAllowance(); got != tt.want {
+				t.Errorf("replacementAllowance() = %d, want %d", got, tt.want)
+			}
+			if tt.wantBatch == 0 {
+				return
+			}
+			if got := a.onDemandInstancesToReplace(a.instances.get("i-ondemand-0")); len(got) != tt.wantBatch {
+				t.Errorf("onDemandInstancesToReplace() returned %d instances, want %d", len(got), tt.wantBatch)
+			}
+		})
+	}
+}
+
+func TestLoadMaxReplacementsPerRun(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		tag  *string
+		want int
+	}{
+		{name: "no tag", want: 5},
+		{name: "tag", key: MaxReplacementsPerRunTag, tag: aws.String("2"), want: 2},
+		{name: "hyphenated tag", key: MaxReplacementsPerRunAliasTag, tag: aws.String("3"), want: 3},
+		{name: "unlimited group", key: MaxReplacementsPerRunTag, tag: aws.String("0"), want: 0},
+		{name: "invalid tag", key: MaxReplacementsPerRunTag, tag: aws.String("-1"), want: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group: &autoscaling.Group{},
+				region: &region{conf: &Config{
+					AutoScalingConfig: AutoScalingConfig{MaxReplacementsPerRun: 5},
+				}},
+			}
+			if tt.tag != nil {
+				a.Tags = []*autoscaling.TagDescription{{Key: aws.String(tt.key), Value: tt.tag}}
+			}
+			a.LoadMaxReplacementsPerRun()
+			if a.config.MaxReplacementsPerRun != tt.want {
+				t.Errorf("LoadMaxReplacementsPerRun() = %d, want %d", a.config.MaxReplacementsPerRun, tt.want)
+			}
+		})
+	}
+}
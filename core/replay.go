@@ -0,0 +1,379 @@
+package autospotting
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+)
+
+// The CloudTrail events relevant for reconstructing the capacity changes
+// performed on AutoScaling groups.
+var replayedEventNames = map[string]bool{
+	"RunInstances":                        true,
+	"TerminateInstances":                  true,
+	"AttachInstances":                     true,
+	"DetachInstances":                     true,
+	"TerminateInstanceInAutoScalingGroup": true,
+	"UpdateAutoScalingGroup":              true,
+}
+
+// ReplayConfig stores the configuration of the CloudTrail replay tool.
+type ReplayConfig struct {
+	// Location of the CloudTrail logs, given as s3://bucket/prefix
+	Source string
+
+	// Time interval of the replayed events
+	From, To time.Time
+
+	// Only replay events done by principals whose ARN contains this string,
+	// such as the name of the AutoSpotting Lambda function's IAM role. When
+	// empty all events are replayed.
+	Principal string
+
+	// Only replay events affecting this AutoScaling group
+	AutoScalingGroup string
+
+	// Region used for connecting to S3 if the bucket region can't be
+	// determined
+	Region string
+
+	Output io.Writer
+}
+
+// cloudTrailRecord is the subset of the CloudTrail record fields we need.
+type cloudTrailRecord struct {
+	EventTime    time.Time `json:"eventTime"`
+	EventName    string    `json:"eventName"`
+	AWSRegion    string    `json:"awsRegion"`
+	ErrorCode    string    `json:"errorCode"`
+	UserIdentity struct {
+		ARN string `json:"arn"`
+	} `json:"userIdentity"`
+	RequestParameters json.RawMessage `json:"requestParameters"`
+	ResponseElements  json.RawMessage `json:"responseElements"`
+}
+
+type cloudTrailLog struct {
+	Records []cloudTrailRecord `json:"Records"`
+}
+
+// ReplayEvent is a capacity change reconstructed from CloudTrail.
+type ReplayEvent struct {
+	Time             time.Time
+	Region           string
+	Action           string
+	AutoScalingGroup string
+	InstanceIDs      []string
+	Principal        string
+	Details          string
+	Error            string
+}
+
+func (e ReplayEvent) String() string {
+	asg := e.AutoScalingGroup
+	if asg == "" {
+		asg = "-"
+	}
+	s := fmt.Sprintf("%s %s %s %s %s",
+		e.Time.UTC().Format(time.RFC3339), e.Region, asg, e.Action,
+		strings.Join(e.InstanceIDs, ","))
+	if e.Details != "" {
+		s += " " + e.Details
+	}
+	if e.Error != "" {
+		s += " FAILED: " + e.Error
+	}
+	return s
+}
+
+// parseCloudTrailLog parses a CloudTrail log file, which may be gzipped.
+func parseCloudTrailLog(r io.Reader) ([]cloudTrailRecord, error) {
+	br := bufio.NewReader(r)
+
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	var l cloudTrailLog
+	if err := json.NewDecoder(r).Decode(&l); err != nil {
+		return nil, err
+	}
+	return l.Records, nil
+}
+
+// The request and response fields used by the replayed events, in the
+// format in which CloudTrail records them.
+type replayParameters struct {
+	AutoScalingGroupName           string   `json:"autoScalingGroupName"`
+	InstanceID                     string   `json:"instanceId"`
+	InstanceIDs                    []string `json:"instanceIds"`
+	ShouldDecrementDesiredCapacity *bool    `json:"shouldDecrementDesiredCapacity"`
+	MinSize                        *int64   `json:"minSize"`
+	MaxSize                        *int64   `json:"maxSize"`
+	DesiredCapacity                *int64   `json:"desiredCapacity"`
+	InstanceType                   string   `json:"instanceType"`
+	InstancesSet                   struct {
+		Items []struct {
+			InstanceID string `json:"instanceId"`
+		} `json:"items"`
+	} `json:"instancesSet"`
+	TagSpecificationSet struct {
+		Items []struct {
+			Tags []struct {
+				Key   string `json:"key"`
+				Value string `json:"value"`
+			} `json:"tags"`
+		} `json:"items"`
+	} `json:"tagSpecificationSet"`
+	Activity struct {
+		AutoScalingGroupName string `json:"autoScalingGroupName"`
+	} `json:"activity"`
+}
+
+func unmarshalParameters(raw json.RawMessage) replayParameters {
+	var p replayParameters
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &p); err != nil {
+			debug.Println("Couldn't parse CloudTrail parameters:", err.Error())
+		}
+	}
+	return p
+}
+
+func (p replayParameters) setInstanceIDs() []string {
+	var ids []string
+	for _, i := range p.InstancesSet.Items {
+		ids = append(ids, i.InstanceID)
+	}
+	return ids
+}
+
+func (p replayParameters) tag(key string) string {
+	for _, item := range p.TagSpecificationSet.Items {
+		for _, t := range item.Tags {
+			if t.Key == key {
+				return t.Value
+			}
+		}
+	}
+	return ""
+}
+
+func decrementDetails(decrement *bool) string {
+	if decrement == nil {
+		return ""
+	}
+	return fmt.Sprintf("decrement_desired_capacity=%t", *decrement)
+}
+
+// reconstructEvents converts the relevant CloudTrail records into a
+// chronological list of capacity changes, keeping track of the group
+// membership of the instances in order to also attribute the EC2 events to
+// their AutoScaling groups.
+func reconstructEvents(records []cloudTrailRecord, cfg ReplayConfig) []ReplayEvent {
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].EventTime.Before(records[j].EventTime)
+	})
+
+	instanceGroups := make(map[string]string)
+	var events []ReplayEvent
+
+	for _, rec := range records {
+		if !replayedEventNames[rec.EventName] {
+			continue
+		}
+
+		req := unmarshalParameters(rec.RequestParameters)
+		resp := unmarshalParameters(rec.ResponseElements)
+
+		e := ReplayEvent{
+			Time:      rec.EventTime,
+			Region:    rec.AWSRegion,
+			Action:    rec.EventName,
+			Principal: rec.UserIdentity.ARN,
+			Error:     rec.ErrorCode,
+		}
+
+		switch rec.EventName {
+		case "RunInstances":
+			e.InstanceIDs = resp.setInstanceIDs()
+			e.AutoScalingGroup = req.tag("launched-for-asg")
+			e.Details = "type=" + req.InstanceType
+		case "TerminateInstances":
+			e.InstanceIDs = req.setInstanceIDs()
+		case "AttachInstances":
+			e.InstanceIDs = req.InstanceIDs
+			e.AutoScalingGroup = req.AutoScalingGroupName
+		case "DetachInstances":
+			e.InstanceIDs = req.InstanceIDs
+			e.AutoScalingGroup = req.AutoScalingGroupName
+			e.Details = decrementDetails(req.ShouldDecrementDesiredCapacity)
+		case "TerminateInstanceInAutoScalingGroup":
+			e.InstanceIDs = []string{req.InstanceID}
+			e.AutoScalingGroup = resp.Activity.AutoScalingGroupName
+			e.Details = decrementDetails(req.ShouldDecrementDesiredCapacity)
+		case "UpdateAutoScalingGroup":
+			e.AutoScalingGroup = req.AutoScalingGroupName
+			var sizes []string
+			if req.MinSize != nil {
+				sizes = append(sizes, fmt.Sprintf("min=%d", *req.MinSize))
+			}
+			if req.MaxSize != nil {
+				sizes = append(sizes, fmt.Sprintf("max=%d", *req.MaxSize))
+			}
+			if req.DesiredCapacity != nil {
+				sizes = append(sizes, fmt.Sprintf("desired=%d", *req.DesiredCapacity))
+			}
+			e.Details = strings.Join(sizes, " ")
+		}
+
+		for _, id := range e.InstanceIDs {
+			if e.AutoScalingGroup != "" {
+				instanceGroups[id] = e.AutoScalingGroup
+			} else if asg, ok := instanceGroups[id]; ok {
+				e.AutoScalingGroup = asg
+			}
+		}
+
+		if !cfg.From.IsZero() && e.Time.Before(cfg.From) ||
+			!cfg.To.IsZero() && e.Time.After(cfg.To) {
+			continue
+		}
+
+		if cfg.Principal != "" && !strings.Contains(e.Principal, cfg.Principal) {
+			continue
+		}
+
+		if cfg.AutoScalingGroup != "" && e.AutoScalingGroup != cfg.AutoScalingGroup {
+			continue
+		}
+
+		events = append(events, e)
+	}
+	return events
+}
+
+func parseS3URL(source string) (string, string, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme != "s3" || u.Host == "" {
+		return "", "", errors.New("expected an S3 location given as s3://bucket/prefix")
+	}
+	return u.Host, strings.TrimPrefix(u.Path, "/"), nil
+}
+
+// fetchCloudTrailRecords downloads and parses all the CloudTrail log files
+// found under the given S3 prefix.
+func fetchCloudTrailRecords(svc s3iface.S3API, bucket, prefix string) ([]cloudTrailRecord, error) {
+	var keys []string
+
+	err := svc.ListObjectsV2Pages(&s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	}, func(page *s3.ListObjectsV2Output, lastPage bool) bool {
+		for _, o := range page.Contents {
+			if strings.HasSuffix(*o.Key, ".json.gz") || strings.HasSuffix(*o.Key, ".json") {
+				keys = append(keys, *o.Key)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	logger.Println("Found", len(keys), "CloudTrail log files in", bucket, prefix)
+
+	var records []cloudTrailRecord
+	for _, key := range keys {
+		out, err := svc.GetObject(&s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		recs, err := parseCloudTrailLog(out.Body)
+		out.Body.Close()
+		if err != nil {
+			logger.Println("Skipping unparseable CloudTrail log file", key, err.Error())
+			continue
+		}
+		records = append(records, recs...)
+	}
+	return records, nil
+}
+
+// Replay reconstructs from CloudTrail logs the capacity changes done to the
+// AutoScaling groups within a time interval, and prints them in
+// chronological order. It can be used for investigating incidents where the
+// capacity of a group changed unexpectedly.
+func Replay(cfg ReplayConfig) error {
+
+	if logger == nil {
+		disableLogging()
+	}
+
+	bucket, prefix, err := parseS3URL(cfg.Source)
+	if err != nil {
+		return err
+	}
+
+	sess := newBucketSession(&Config{}, bucket, cfg.Region)
+
+	records, err := fetchCloudTrailRecords(s3.New(sess), bucket, prefix)
+	if err != nil {
+		return err
+	}
+
+	return writeReplay(cfg, reconstructEvents(records, cfg))
+}
+
+func writeReplay(cfg ReplayConfig, events []ReplayEvent) error {
+	perGroup := make(map[string]int)
+
+	for _, e := range events {
+		if _, err := fmt.Fprintln(cfg.Output, e.String()); err != nil {
+			return err
+		}
+		perGroup[e.AutoScalingGroup]++
+	}
+
+	var groups []string
+	for g := range perGroup {
+		groups = append(groups, g)
+	}
+	sort.Strings(groups)
+
+	fmt.Fprintf(cfg.Output, "\n%d events found\n", len(events))
+	for _, g := range groups {
+		name := g
+		if name == "" {
+			name = "(unknown group)"
+		}
+		fmt.Fprintf(cfg.Output, "  %s: %d events\n", name, perGroup[g])
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+package autospotting
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const testCloudTrailLog = `{"Records": [
+ {"eventTime": "2019-05-01T10:00:00Z", "eventName": "RunInstances", "awsRegion": "eu-west-1",
+  "userIdentity": {"arn": "arn:aws:sts::123:assumed-role/AutoSpotting-role/AutoSpotting"},
+  "requestParameters": {"instanceType": "m5.large", "tagSpecificationSet": {"items": [{"tags": [{"key": "launched-for-asg", "value": "web"}]}]}},
+  "responseElements": {"instancesSet": {"items": [{"instanceId": "i-spot"}]}}},
+ {"eventTime": "2019-05-01T10:05:00Z", "eventName": "AttachInstances", "awsRegion": "eu-west-1",
+  "userIdentity": {"arn": "arn:aws:sts::123:assumed-role/AutoSpotting-role/AutoSpotting"},
+  "requestParameters": {"autoScalingGroupName": "web", "instanceIds": ["i-spot"]}},
+ {"eventTime": "2019-05-01T10:05:01Z", "eventName": "DescribeInstances", "awsRegion": "eu-west-1"},
+ {"eventTime": "2019-05-01T10:05:02Z", "eventName": "TerminateInstanceInAutoScalingGroup", "awsRegion": "eu-west-1",
+  "userIdentity": {"arn": "arn:aws:sts::123:assumed-role/AutoSpotting-role/AutoSpotting"},
+  "requestParameters": {"instanceId": "i-od", "shouldDecrementDesiredCapacity": true},
+  "responseElements": {"activity": {"autoScalingGroupName": "web"}}},
+ {"eventTime": "2019-05-01T11:00:00Z", "eventName": "TerminateInstances", "awsRegion": "eu-west-1",
+  "userIdentity": {"arn": "arn:aws:iam::123:user/alice"},
+  "requestParameters": {"instancesSet": {"items": [{"instanceId": "i-spot"}]}},
+  "errorCode": "UnauthorizedOperation"}
+]}`
+
+func gzipped(s string) []byte {
+	var b bytes.Buffer
+	w := gzip.NewWriter(&b)
+	w.Write([]byte(s))
+	w.Close()
+	return b.Bytes()
+}
+
+func Test_parseCloudTrailLog(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   []byte
+		want    int
+		wantErr bool
+	}{
+		{name: "plain JSON", input: []byte(testCloudTrailLog), want: 5},
+		{name: "gzipped JSON", input: gzipped(testCloudTrailLog), want: 5},
+		{name: "invalid", input: []byte("foo"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseCloudTrailLog(bytes.NewReader(tt.input))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseCloudTrailLog() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != tt.want {
+				t.Errorf("parseCloudTrailLog() returned %d records, want %d", len(got), tt.want)
+			}
+		})
+	}
+}
+
+func Test_reconstructEvents(t *testing.T) {
+	records, _ := parseCloudTrailLog(strings.NewReader(testCloudTrailLog))
+
+	tests := []struct {
+		name string
+		cfg  ReplayConfig
+		want []string
+	}{
+		{
+			name: "all events",
+			cfg:  ReplayConfig{},
+			want: []string{
+				"2019-05-01T10:00:00Z eu-west-1 web RunInstances i-spot type=m5.large",
+				"2019-05-01T10:05:00Z eu-west-1 web AttachInstances i-spot",
+				"2019-05-01T10:05:02Z eu-west-1 web TerminateInstanceInAutoScalingGroup i-od decrement_desired_capacity=true",
+				"2019-05-01T11:00:00Z eu-west-1 web TerminateInstances i-spot FAILED: UnauthorizedOperation",
+			},
+		},
+		{
+			name: "filtered by principal",
+			cfg:  ReplayConfig{Principal: "alice"},
+			want: []string{
+				"2019-05-01T11:00:00Z eu-west-1 web TerminateInstances i-spot FAILED: UnauthorizedOperation",
+			},
+		},
+		{
+			name: "filtered by time",
+			cfg: ReplayConfig{
+				From: time.Date(2019, 5, 1, 10, 1, 0, 0, time.UTC),
+				To:   time.Date(2019, 5, 1, 10, 30, 0, 0, time.UTC),
+			},
+			want: []string{
+				"2019-05-01T10:05:00Z eu-west-1 web AttachInstances i-spot",
+				"2019-05-01T10:05:02Z eu-west-1 web TerminateInstanceInAutoScalingGroup i-od decrement_desired_capacity=true",
+			},
+		},
+		{
+			name: "filtered by group",
+			cfg:  ReplayConfig{AutoScalingGroup: "other"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, e := range reconstructEvents(records, tt.cfg) {
+				got = append(got, e.String())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("reconstructEvents() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseS3URL(t *testing.T) {
+	tests := []struct {
+		source     string
+		wantBucket string
+		wantPrefix string
+		wantErr    bool
+	}{
+		{source: "s3://bucket/AWSLogs/123/", wantBucket: "bucket", wantPrefix: "AWSLogs/123/"},
+		{source: "s3://bucket", wantBucket: "bucket"},
+		{source: "https://bucket/foo", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.source, func(t *testing.T) {
+			bucket, prefix, err := parseS3URL(tt.source)
+			if (err != nil) != tt.wantErr || bucket != tt.wantBucket || prefix != tt.wantPrefix {
+				t.Errorf("parseS3URL() = %v, %v, %v want %v, %v, error %v",
+					bucket, prefix, err, tt.wantBucket, tt.wantPrefix, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_fetchCloudTrailRecords(t *testing.T) {
+	svc := mockS3{
+		lo: &s3.ListObjectsV2Output{
+			Contents: []*s3.Object{
+				{Key: aws.String("AWSLogs/1.json.gz")},
+				{Key: aws.String("AWSLogs/digest.txt")},
+			},
+		},
+		objects: map[string][]byte{
+			"AWSLogs/1.json.gz": gzipped(testCloudTrailLog),
+		},
+	}
+
+	records, err := fetchCloudTrailRecords(svc, "bucket", "AWSLogs/")
+	if err != nil {
+		t.Fatalf("fetchCloudTrailRecords() error = %v", err)
+	}
+	if len(records) != 5 {
+		t.Errorf("fetchCloudTrailRecords() returned %d records, want 5", len(records))
+	}
+}
+
+func Test_writeReplay(t *testing.T) {
+	var out bytes.Buffer
+	events := []ReplayEvent{
+		{Time: time.Date(2019, 5, 1, 10, 0, 0, 0, time.UTC), Region: "eu-west-1",
+			AutoScalingGroup: "web", Action: "AttachInstances", InstanceIDs: []string{"i-1"}},
+		{Time: time.Date(2019, 5, 1, 10, 1, 0, 0, time.UTC), Region: "eu-west-1",
+			Action: "TerminateInstances", InstanceIDs: []string{"i-2"}},
+	}
+
+	writeReplay(ReplayConfig{Output: &out}, events)
+
+	want := "2019-05-01T10:00:00Z eu-west-1 web AttachInstances i-1\n" +
+		"2019-05-01T10:01:00Z eu-west-1 - TerminateInstances i-2\n" +
+		"\n2 events found\n" +
+		"  (unknown group): 1 events\n" +
+		"  web: 1 events\n"
+
+	if out.String() != want {
+		t.Errorf("writeReplay() output:\n%s\nwant:\n%s", out.String(), want)
+	}
+}
@@ -0,0 +1,92 @@
+package autospotting
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func reservationKey(instanceType, az string) string {
+	return instanceType + "/" + az
+}
+
+// zonalReservations returns the number of active zonal reserved instances by
+// instance type and availability zone, which is loaded once per run.
+func (r *region) zonalReservations() map[string]int64 {
+	r.reservationsOnce.Do(func() {
+		r.reservations = make(map[string]int64)
+
+		out, err := r.services.ec2.DescribeReservedInstances(&ec2.DescribeReservedInstancesInput{
+			Filters: []*ec2.Filter{
+				{Name: aws.String("state"), Values: []*string{aws.String(ec2.ReservedInstanceStateActive)}},
+				{Name: aws.String("scope"), Values: []*string{aws.String(ec2.ScopeAvailabilityZone)}},
+			},
+		})
+		if err != nil {
+			logger.Println(r.name, "Failed to describe the reserved instances:", err.Error())
+			return
+		}
+
+		for _, ri := range out.ReservedInstances {
+			key := reservationKey(aws.StringValue(ri.InstanceType), aws.StringValue(ri.AvailabilityZone))
+			r.reservations[key] += aws.Int64Value(ri.InstanceCount)
+		}
+	})
+	return r.reservations
+}
+
+// isCoveredByReservations returns true, with the reason, if the on-demand
+// instances of the group are fully covered by reservations, in which case
+// replacing them with spot instances wouldn't save anything. The reserved
+// instances are shared with the other instances of the account, so a group
+// is only covered when the zonal reserved instances cover all the on-demand
+// instances of the region having its instance types and availability zones.
+func (a *autoScalingGroup) isCoveredByReservations() (string, bool) {
+	if value := a.getTagValue(ReservedTag); value != nil {
+		reserved, err := strconv.ParseBool(*value)
+		if err == nil {
+			return fmt.Sprintf("covered by reservations according to the %s tag", ReservedTag), reserved
+		}
+		logger.Printf("Ignoring invalid %s tag value '%s' on group %s\n", ReservedTag, *value, a.name)
+	}
+
+	if !a.region.conf.CheckReservations {
+		return "", false
+	}
+
+	needed := make(map[string]int64)
+	for inst := range a.instances.instances() {
+		if !inst.isSpot() && *inst.State.Name == ec2.InstanceStateNameRunning {
+			needed[reservationKey(*inst.InstanceType, *inst.Placement.AvailabilityZone)] = 0
+		}
+	}
+	if len(needed) == 0 {
+		return "", false
+	}
+
+	reservations := a.region.zonalReservations()
+	for key := range needed {
+		if reservations[key] == 0 {
+			return "", false
+		}
+	}
+
+	for inst := range a.region.instances.instances() {
+		if inst.isSpot() || aws.StringValue(inst.State.Name) != ec2.InstanceStateNameRunning {
+			continue
+		}
+		key := reservationKey(*inst.InstanceType, *inst.Placement.AvailabilityZone)
+		if _, ok := needed[key]; ok {
+			needed[key]++
+		}
+	}
+
+	for key, count := range needed {
+		if count > reservations[key] {
+			return "", false
+		}
+	}
+	return "all its on-demand instances are covered by zonal reserved instances", true
+}
@@ -0,0 +1,147 @@
+package autospotting
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestIsCoveredByReservations(t *testing.T) {
+	newInstance := func(id, instanceType, az string, spot bool) *instance {
+		i := &instance{Instance: &ec2.Instance{
+			InstanceId:   aws.String(id),
+			InstanceType: aws.String(instanceType),
+			Placement:    &ec2.Placement{AvailabilityZone: aws.String(az)},
+			State:        &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		}}
+		if spot {
+			i.InstanceLifecycle = aws.String("spot")
+		}
+		return i
+	}
+	reserved := func(instanceType, az string, count int64) *ec2.ReservedInstances {
+		return &ec2.ReservedInstances{
+			InstanceType:     aws.String(instanceType),
+			AvailabilityZone: aws.String(az),
+			InstanceCount:    aws.Int64(count),
+		}
+	}
+
+	tests := []struct {
+		name   string
+		tags   map[string]string
+		check  bool
+		group  []*instance
+		others []*instance
+		ris    []*ec2.ReservedInstances
+		rierr  error
+		want   bool
+	}{
+		{
+			name:  "fully covered",
+			check: true,
+			group: []*instance{newInstance("i-1", "m5.large", "us-east-1a", false), newInstance("i-2", "m5.large", "us-east-1a", false)},
+			ris:   []*ec2.ReservedInstances{reserved("m5.large", "us-east-1a", 1), reserved("m5.large", "us-east-1a", 1)},
+			want:  true,
+		},
+		{
+			name:  "spot instances don't need reservations",
+			check: true,
+			group: []*instance{newInstance("i-1", "m5.large", "us-east-1a", false), newInstance("i-2", "c5.large", "us-east-1b", true)},
+			ris:   []*ec2.ReservedInstances{reserved("m5.large", "us-east-1a", 1)},
+			want:  true,
+		},
+		{
+			name:  "partially covered",
+			check: true,
+			group: []*instance{newInstance("i-1", "m5.large", "us-east-1a", false), newInstance("i-2", "m5.large", "us-east-1b", false)},
+			ris:   []*ec2.ReservedInstances{reserved("m5.large", "us-east-1a", 5)},
+			want:  false,
+		},
+		{
+			name:   "reservations used by other instances",
+			check:  true,
+			group:  []*instance{newInstance("i-1", "m5.large", "us-east-1a", false)},
+			others: []*instance{newInstance("i-3", "m5.large", "us-east-1a", false)},
+			ris:    []*ec2.ReservedInstances{reserved("m5.large", "us-east-1a", 1)},
+			want:   false,
+		},
+		{
+			name:  "check disabled",
+			check: false,
+			group: []*instance{newInstance("i-1", "m5.large", "us-east-1a", false)},
+			ris:   []*ec2.ReservedInstances{reserved("m5.large", "us-east-1a", 1)},
+			want:  false,
+		},
+		{
+			name:  "no on-demand instances",
+			check: true,
+			group: []*instance{newInstance("i-1", "m5.large", "us-east-1a", true)},
+			want:  false,
+		},
+		{
+			name:  "describe error",
+			check: true,
+			group: []*instance{newInstance("i-1", "m5.large", "us-east-1a", false)},
+			rierr: errors.New("denied"),
+			want:  false,
+		},
+		{
+			name:  "tagged as reserved",
+			tags:  map[string]string{ReservedTag: "true"},
+			check: false,
+			group: []*instance{newInstance("i-1", "m5.large", "us-east-1a", false)},
+			want:  true,
+		},
+		{
+			name:  "tagged as not reserved",
+			tags:  map[string]string{ReservedTag: "false"},
+			check: true,
+			group: []*instance{newInstance("i-1", "m5.large", "us-east-1a", false)},
+			ris:   []*ec2.ReservedInstances{reserved("m5.large", "us-east-1a", 1)},
+			want:  false,
+		},
+		{
+			name:  "invalid tag",
+			tags:  map[string]string{ReservedTag: "maybe"},
+			check: true,
+			group: []*instance{newInstance("i-1", "m5.large", "us-east-1a", false)},
+			ris:   []*ec2.ReservedInstances{reserved("m5.large", "us-east-1a", 1)},
+			want:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{
+				name: "us-east-1",
+				conf: &Config{CheckReservations: tt.check},
+				services: connections{ec2: mockEC2{
+					drio:   &ec2.DescribeReservedInstancesOutput{ReservedInstances: tt.ris},
+					drierr: tt.rierr,
+				}},
+				instances: makeInstances(),
+			}
+			a := newTestGroup(tt.tags)
+			a.region = r
+			a.instances = makeInstances()
+			for _, i := range tt.group {
+				a.instances.add(i)
+				r.instances.add(i)
+			}
+			for _, i := range tt.others {
+				r.instances.add(i)
+			}
+
+			reason, got := a.isCoveredByReservations()
+			if got != tt.want {
+				t.Errorf("isCoveredByReservations() = %v, want %v", got, tt.want)
+			}
+			if got && reason == "" {
+				t.Error("expected a reason for skipping the group")
+			}
+		})
+	}
+}
@@ -1,45 +1,87 @@
 package autospotting
 
 import (
+	"strings"
 	"time"
 
 	"github.com/robfig/cron"
 )
 
+// DefaultCronTimezone is the default time zone in which the schedules are
+// evaluated.
+const DefaultCronTimezone = "UTC"
+
 // insideSchedule returns true if the time given in the t parameter is matching
-// the implified cronrab-like interval restricted to only hours and days of the
-// week. Because the cron library be use only supports the local time, the
-// crontab entry will have to be created accoringly. When executed in Lambda the
-// runtime's local time will always be UTC, so users have to be made aware of
-// this through the documentation.
-func insideSchedule(t time.Time, crontab string) (bool, error) {
-	specParser := cron.NewParser(cron.Hour | cron.Dow)
-	sched, err := specParser.Parse(crontab)
+// the schedule, evaluated in the given time zone, or in the location of t when
+// the time zone is empty.
+//
+// The schedule is either the simplified cron-like interval restricted to only
+// the hours and the days of the week, such as "9-18 1-5", or a standard cron
+// expression made of five fields: minute, hour, day of month, month and day of
+// week. The latter matches the minutes in which the schedule fires, such as
+// "*/15 * * * 1-5" for every 15 minutes on weekdays, or "* 9-17 * * 1-5" for
+// each minute of the office hours.
+func insideSchedule(t time.Time, crontab string, timezone string) (bool, error) {
+	sched, err := parseSchedule(crontab)
 
-	debug.Println(crontab)
+	debug.Println(crontab, timezone)
 
 	if err != nil {
 		logger.Println(err)
 		return false, err
 	}
 
-	// When inside the cron interval, the next event from exactly an hour ago and the
-	// next event from now are exactly one hour apart
-	prev := sched.Next(t.Add(-1 * time.Hour))
-	next := sched.Next(t)
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			logger.Println(err)
+			return false, err
+		}
+		t = t.In(loc)
+	}
+
+	if !isStandardSchedule(crontab) {
+		// When inside the cron interval, the next event from exactly an hour ago and the
+		// next event from now are exactly one hour apart
+		prev := sched.Next(t.Add(-1 * time.Hour))
+		next := sched.Next(t)
 
-	if next == prev.Add(1*time.Hour) {
-		return true, nil
+		return next == prev.Add(1*time.Hour), nil
 	}
-	return false, nil
+
+	// The schedule fires in the current minute when its next event from just
+	// before the start of the minute is the start of the minute
+	minute := t.Truncate(time.Minute)
+	return sched.Next(minute.Add(-time.Second)).Equal(minute), nil
+}
+
+// isStandardSchedule tells if the crontab is a standard five fields cron
+// expression, as opposed to the simplified hours and days of the week format.
+func isStandardSchedule(crontab string) bool {
+	return len(strings.Fields(crontab)) == 5
+}
+
+// parseSchedule parses the crontab, either a standard cron expression or the
+// simplified interval only made of the hours and the days of the week.
+func parseSchedule(crontab string) (cron.Schedule, error) {
+	if isStandardSchedule(crontab) {
+		return cron.NewParser(cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow).Parse(crontab)
+	}
+	return cron.NewParser(cron.Hour | cron.Dow).Parse(crontab)
+}
+
+// validScheduleState tells if the schedule state is one of the allowed "on"
+// and "off" values.
+func validScheduleState(state string) bool {
+	return state == "on" || state == "off"
 }
 
 // returns true if the schedule is "on" and we're inside the interval also
 // returns true if the schedule is "off" and we're outside the interval returns
 // false in case of cron parsing error and other schedule parameter combinations
-func cronRunAction(t time.Time, crontab string, scheduleType string) bool {
-	inside, err := insideSchedule(t, crontab)
-	debug.Println("Inside schedule for", crontab, ":", inside)
+func cronRunAction(t time.Time, crontab string, timezone string, scheduleType string) bool {
+	inside, err := insideSchedule(t, crontab, timezone)
+	debug.Println("Inside schedule for", crontab, timezone, ":", inside)
 
 	if err != nil {
 		return false
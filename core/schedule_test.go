@@ -5,6 +5,9 @@ import (
 	"strings"
 	"testing"
 	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
 )
 
 func Test_insideSchedule(t *testing.T) {
@@ -90,7 +93,7 @@ func Test_insideSchedule(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got, err := insideSchedule(tt.t, tt.crontab); got != tt.want ||
+			if got, err := insideSchedule(tt.t, tt.crontab, ""); got != tt.want ||
 				// the err is checked for matching wantErr, doesn't need to be identical
 				!(err == tt.wantErr || strings.Contains(err.Error(), tt.wantErr.Error())) {
 				t.Errorf("insideSchedule() = %v, %v want %v, %v", got, err, tt.want, tt.wantErr)
@@ -99,6 +102,89 @@ func Test_insideSchedule(t *testing.T) {
 	}
 }
 
+func Test_insideStandardSchedule(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("time zone database unavailable:", err.Error())
+	}
+
+	tests := []struct {
+		name     string
+		t        time.Time
+		crontab  string
+		timezone string
+		want     bool
+		wantErr  bool
+	}{
+		{
+			name:    "Every minute",
+			crontab: "* * * * *",
+			t:       time.Date(2019, time.May, 9, 10, 7, 30, 0, time.UTC),
+			want:    true,
+		},
+		{
+			name:    "Every 15 minutes on weekdays, firing",
+			crontab: "*/15 * * * 1-5",
+			t:       time.Date(2019, time.May, 9, 10, 15, 20, 0, time.UTC),
+			want:    true,
+		},
+		{
+			name:    "Every 15 minutes on weekdays, between events",
+			crontab: "*/15 * * * 1-5",
+			t:       time.Date(2019, time.May, 9, 10, 16, 0, 0, time.UTC),
+			want:    false,
+		},
+		{
+			name:    "Every 15 minutes on weekdays, during the weekend",
+			crontab: "*/15 * * * 1-5",
+			t:       time.Date(2019, time.May, 11, 10, 15, 0, 0, time.UTC),
+			want:    false,
+		},
+		{
+			name:     "Office hours in Berlin",
+			crontab:  "* 9-17 * * 1-5",
+			timezone: "Europe/Berlin",
+			t:        time.Date(2019, time.May, 9, 7, 30, 0, 0, time.UTC),
+			want:     true,
+		},
+		{
+			name:     "Office hours in Berlin, evening in UTC",
+			crontab:  "* 9-17 * * 1-5",
+			timezone: "Europe/Berlin",
+			t:        time.Date(2019, time.May, 9, 16, 30, 0, 0, time.UTC),
+			want:     false,
+		},
+		{
+			name:     "Simplified format in Berlin",
+			crontab:  "9-18 1-5",
+			timezone: "Europe/Berlin",
+			t:        time.Date(2019, time.May, 9, 10, 0, 0, 0, berlin).UTC(),
+			want:     true,
+		},
+		{
+			name:     "Unknown time zone",
+			crontab:  "* * * * *",
+			timezone: "Mars/Olympus_Mons",
+			t:        time.Date(2019, time.May, 9, 10, 0, 0, 0, time.UTC),
+			wantErr:  true,
+		},
+		{
+			name:    "Invalid expression",
+			crontab: "61 * * * *",
+			t:       time.Date(2019, time.May, 9, 10, 0, 0, 0, time.UTC),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := insideSchedule(tt.t, tt.crontab, tt.timezone)
+			if got != tt.want || (err != nil) != tt.wantErr {
+				t.Errorf("insideSchedule() = %v, %v want %v, error %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
 func Test_runAction(t *testing.T) {
 
 	tests := []struct {
@@ -144,9 +230,82 @@ func Test_runAction(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := cronRunAction(tt.t, tt.crontab, tt.scheduleType); got != tt.want {
+			if got := cronRunAction(tt.t, tt.crontab, "", tt.scheduleType); got != tt.want {
 				t.Errorf("runAction() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
+
+func Test_groupScheduleOverrides(t *testing.T) {
+	conf := &Config{
+		AutoScalingConfig: AutoScalingConfig{
+			CronSchedule:      "* *",
+			CronScheduleState: "on",
+			CronTimezone:      "UTC",
+		},
+	}
+
+	tests := []struct {
+		name    string
+		tags    map[string]string
+		t       time.Time
+		wantRun bool
+	}{
+		{
+			name:    "Untagged production group during the day",
+			t:       time.Date(2019, time.May, 9, 10, 0, 0, 0, time.UTC),
+			wantRun: true,
+		},
+		{
+			name:    "Development group converted at night, during the day",
+			tags:    map[string]string{CronScheduleAliasTag: "22-23,0-5 *"},
+			t:       time.Date(2019, time.May, 9, 10, 0, 0, 0, time.UTC),
+			wantRun: false,
+		},
+		{
+			name:    "Development group converted at night, at night",
+			tags:    map[string]string{CronScheduleAliasTag: "22-23,0-5 *"},
+			t:       time.Date(2019, time.May, 9, 23, 30, 0, 0, time.UTC),
+			wantRun: true,
+		},
+		{
+			name: "Group left alone during the office hours, during the day",
+			tags: map[string]string{
+				CronScheduleAliasTag:      "9-18 1-5",
+				CronScheduleStateAliasTag: "off",
+			},
+			t:       time.Date(2019, time.May, 9, 10, 0, 0, 0, time.UTC),
+			wantRun: false,
+		},
+		{
+			name: "Group left alone during the office hours, during the weekend",
+			tags: map[string]string{
+				CronScheduleAliasTag:      "9-18 1-5",
+				CronScheduleStateAliasTag: "off",
+			},
+			t:       time.Date(2019, time.May, 11, 10, 0, 0, 0, time.UTC),
+			wantRun: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				Group:  &autoscaling.Group{},
+				name:   "mygroup",
+				region: &region{conf: conf},
+			}
+			for k, v := range tt.tags {
+				a.Tags = append(a.Tags, &autoscaling.TagDescription{Key: aws.String(k), Value: aws.String(v)})
+			}
+			a.LoadCronSchedule()
+			a.LoadCronScheduleState()
+			a.LoadCronTimezone()
+
+			if got := cronRunAction(tt.t, a.config.CronSchedule, a.config.CronTimezone,
+				a.config.CronScheduleState); got != tt.wantRun {
+				t.Errorf("cronRunAction() = %v, want %v", got, tt.wantRun)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package autospotting
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// spotAdvisorURL is the data behind the Spot Instance Advisor, giving the
+// frequency of the spot interruptions of each instance type over the last
+// month, by region and operating system.
+var spotAdvisorURL = "https://spot-bid-advisor.s3.amazonaws.com/spot-advisor-data.json"
+
+type spotAdvisorData struct {
+	// the interruption frequency ranges, such as <5% or >20%, by index
+	Ranges []struct {
+		Index int    `json:"index"`
+		Label string `json:"label"`
+		// the upper bound of the range, as a percentage
+		Max float64 `json:"max"`
+	} `json:"ranges"`
+
+	// the interruption frequency range of each instance type, by region and
+	// operating system
+	SpotAdvisor map[string]map[string]map[string]struct {
+		Range int `json:"r"`
+	} `json:"spot_advisor"`
+}
+
+// spotAdvisor downloads the Spot Instance Advisor data once per run, only
+// when the interruption history is used for ranking the instance types.
+type spotAdvisor struct {
+	once sync.Once
+	data *spotAdvisorData
+}
+
+func (s *spotAdvisor) load() *spotAdvisorData {
+	if s == nil {
+		return nil
+	}
+	s.once.Do(func() {
+		data, err := fetchSpotAdvisorData(&http.Client{Timeout: 10 * time.Second}, spotAdvisorURL)
+		if err != nil {
+			logger.Println("Couldn't load the spot interruption history:", err.Error())
+			return
+		}
+		s.data = data
+	})
+	return s.data
+}
+
+func fetchSpotAdvisorData(client *http.Client, url string) (*spotAdvisorData, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned status %d", url, resp.StatusCode)
+	}
+
+	var data spotAdvisorData
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// interruptionFrequency returns the interruption frequency range of the
+// instance type scaled between 0, for the least interrupted types, and 1, or
+// false if the instance type isn't known.
+func (d *spotAdvisorData) interruptionFrequency(region, platform, instanceType string) (float64, bool) {
+	if d == nil || len(d.Ranges) < 2 {
+		return 0, false
+	}
+	types, ok := d.SpotAdvisor[region][platform]
+	if !ok {
+		return 0, false
+	}
+	t, ok := types[instanceType]
+	if !ok {
+		return 0, false
+	}
+	return math.Min(float64(t.Range)/float64(len(d.Ranges)-1), 1), true
+}
+
+// interruptionFrequencyMin returns the lower bound of the interruption
+// frequency range of the instance type as a percentage, which is the upper
+// bound of the previous range, or false if the instance type isn't known.
+func (d *spotAdvisorData) interruptionFrequencyMin(region, platform, instanceType string) (float64, bool) {
+	if d == nil {
+		return 0, false
+	}
+	t, ok := d.SpotAdvisor[region][platform][instanceType]
+	if !ok {
+		return 0, false
+	}
+	if t.Range == 0 {
+		return 0, true
+	}
+	for _, r := range d.Ranges {
+		if r.Index == t.Range-1 {
+			return r.Max, true
+		}
+	}
+	return 0, false
+}
+
+// interruptionRange returns the label of the interruption frequency range of
+// the instance type, such as <5%, or false if the instance type isn't known.
+func (d *spotAdvisorData) interruptionRange(region, platform, instanceType string) (string, bool) {
+	if d == nil {
+		return "", false
+	}
+	t, ok := d.SpotAdvisor[region][platform][instanceType]
+	if !ok {
+		return "", false
+	}
+	for _, r := range d.Ranges {
+		if r.Index == t.Range {
+			return r.Label, true
+		}
+	}
+	return "", false
+}
+
+// advisorPlatform is the operating system of the instance as named by the
+// Spot Instance Advisor.
+func (i *instance) advisorPlatform() string {
+	if aws.StringValue(i.Platform) == "windows" {
+		return "Windows"
+	}
+	return "Linux"
+}
+
+// isInterruptionFrequencyAcceptable tells if the interruption frequency range
+// of the candidate reported by the Spot Instance Advisor starts below the
+// MaxInterruptionFrequency of the group. The instance types it doesn't know
+// about are accepted, as well as all of them when its data can't be loaded.
+func (i *instance) isInterruptionFrequencyAcceptable(candidate instanceTypeInformation) bool {
+	limit := i.asg.config.MaxInterruptionFrequency
+	if limit <= 0 {
+		return true
+	}
+	frequency, ok := i.region.conf.spotAdvisor.load().interruptionFrequencyMin(
+		i.region.name, i.advisorPlatform(), candidate.instanceType)
+	return !ok || frequency < limit
+}
+
+// priceScore is the savings of the candidate compared to the price of the
+// instance, between 0 and 1.
+func (i *instance) priceScore(c acceptableInstance) float64 {
+	if i.price <= 0 {
+		return 0
+	}
+	return math.Max(0, math.Min(1, 1-c.price/i.price))
+}
+
+// stabilityScore is the inverse of the interruption frequency of the
+// candidate, with the instance types without any interruption history ranked
+// halfway.
+func (i *instance) stabilityScore(c acceptableInstance, advisor *spotAdvisorData) float64 {
+	frequency, ok := advisor.interruptionFrequency(i.region.name, i.advisorPlatform(), c.instanceTI.instanceType)
+	if !ok {
+		return 0.5
+	}
+	return 1 - frequency
+}
+
+// placementScore decreases with the spot capacity failures of the family of
+// the candidate in the availability zone of the instance seen during the run,
+// and is scaled by its Spot placement score in that availability zone when
+// available.
+func (i *instance) placementScore(c acceptableInstance) float64 {
+	az := aws.StringValue(i.Placement.AvailabilityZone)
+	failures := i.region.capacity.count(az, c.instanceTI.instanceType)
+	score := 1 - math.Min(float64(failures), capacityFailureThreshold)/capacityFailureThreshold
+	if capacity, ok := i.region.spotPlacementScore(az, c.instanceTI.instanceType); ok {
+		score *= capacity
+	}
+	return score
+}
+
+// headroomScore is the additional vCPUs and memory of the candidate compared
+// to the instance type, each counted up to twice the current size.
+func (i *instance) headroomScore(c acceptableInstance) float64 {
+	headroom := func(candidate, current float64) float64 {
+		if current <= 0 {
+			return 0
+		}
+		return math.Max(0, math.Min(1, candidate/current-1))
+	}
+	return (headroom(float64(c.instanceTI.vCPU), float64(i.typeInfo.vCPU)) +
+		headroom(float64(c.instanceTI.memory), float64(i.typeInfo.memory))) / 2
+}
+
+// score combines the price, interruption history, placement and spec headroom
+// of the candidate using the weights configured for the group, between 0 and 1.
+func (i *instance) score(c acceptableInstance, advisor *spotAdvisorData) float64 {
+	cfg := i.asg.config
+	total := cfg.PriceWeight + cfg.InterruptionWeight + cfg.PlacementWeight + cfg.HeadroomWeight
+	if total <= 0 {
+		return 0
+	}
+
+	score := cfg.PriceWeight * i.priceScore(c)
+	if cfg.InterruptionWeight > 0 {
+		score += cfg.InterruptionWeight * i.stabilityScore(c, advisor)
+	}
+	if cfg.PlacementWeight > 0 {
+		score += cfg.PlacementWeight * i.placementScore(c)
+	}
+	if cfg.HeadroomWeight > 0 {
+		score += cfg.HeadroomWeight * i.headroomScore(c)
+	}
+	return score / total
+}
+
+// rankByScore sorts the candidates sorted by price by their weighted score,
+// keeping the price order between the candidates with the same score. The
+// candidates are only ranked by price unless other weights are configured.
+func (i *instance) rankByScore(candidates []acceptableInstance) []acceptableInstance {
+	cfg := i.asg.config
+	if cfg.InterruptionWeight <= 0 && cfg.PlacementWeight <= 0 && cfg.HeadroomWeight <= 0 {
+		return candidates
+	}
+
+	var advisor *spotAdvisorData
+	if cfg.InterruptionWeight > 0 {
+		advisor = i.region.conf.spotAdvisor.load()
+	}
+
+	scores := make(map[string]float64, len(candidates))
+	for _, c := range candidates {
+		scores[c.instanceTI.instanceType] = i.score(c, advisor)
+		i.trace.score(c.instanceTI.instanceType, scores[c.instanceTI.instanceType])
+	}
+	sort.SliceStable(candidates, func(x, y int) bool {
+		return scores[candidates[x].instanceTI.instanceType] > scores[candidates[y].instanceTI.instanceType]
+	})
+	debug.Println(i.asg.name, "Instance types ranked by score:", candidates, scores)
+	return candidates
+}
@@ -0,0 +1,221 @@
+package autospotting
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const testSpotAdvisorData = `{
+	"ranges": [
+		{"index": 0, "label": "<5%", "max": 5},
+		{"index": 1, "label": "5-10%", "max": 11},
+		{"index": 2, "label": "10-15%", "max": 16},
+		{"index": 3, "label": "15-20%", "max": 22},
+		{"index": 4, "label": ">20%", "max": 100}
+	],
+	"spot_advisor": {
+		"us-east-1": {
+			"Linux": {
+				"m5.large": {"s": 70, "r": 0},
+				"c5.large": {"s": 80, "r": 4},
+				"r5.large": {"s": 75, "r": 2}
+			}
+		}
+	}
+}`
+
+func TestFetchSpotAdvisorData(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/data.json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testSpotAdvisorData))
+	}))
+	defer server.Close()
+
+	data, err := fetchSpotAdvisorData(server.Client(), server.URL+"/data.json")
+	if err != nil {
+		t.Fatalf("fetchSpotAdvisorData() error = %v", err)
+	}
+
+	tests := []struct {
+		instanceType string
+		platform     string
+		want         float64
+		wantOK       bool
+	}{
+		{instanceType: "m5.large", platform: "Linux", want: 0, wantOK: true},
+		{instanceType: "r5.large", platform: "Linux", want: 0.5, wantOK: true},
+		{instanceType: "c5.large", platform: "Linux", want: 1, wantOK: true},
+		{instanceType: "t3.large", platform: "Linux"},
+		{instanceType: "m5.large", platform: "Windows"},
+	}
+	for _, tt := range tests {
+		got, ok := data.interruptionFrequency("us-east-1", tt.platform, tt.instanceType)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("interruptionFrequency(%s, %s) = %v, %v, want %v, %v",
+				tt.platform, tt.instanceType, got, ok, tt.want, tt.wantOK)
+		}
+	}
+
+	if _, err := fetchSpotAdvisorData(server.Client(), server.URL+"/missing.json"); err == nil {
+		t.Error("fetchSpotAdvisorData() expected an error for a missing document")
+	}
+
+	var missing *spotAdvisorData
+	if _, ok := missing.interruptionFrequency("us-east-1", "Linux", "m5.large"); ok {
+		t.Error("interruptionFrequency() found an instance type without any data")
+	}
+}
+
+func TestRankByScore(t *testing.T) {
+	var data spotAdvisorData
+	if err := json.Unmarshal([]byte(testSpotAdvisorData), &data); err != nil {
+		t.Fatal(err)
+	}
+	advisor := &spotAdvisor{data: &data}
+	advisor.once.Do(func() {})
+
+	candidates := func() []acceptableInstance {
+		return []acceptableInstance{
+			{instanceTI: instanceTypeInformation{instanceType: "c5.large", vCPU: 2, memory: 4}, price: 0.02},
+			{instanceTI: instanceTypeInformation{instanceType: "m5.large", vCPU: 2, memory: 8}, price: 0.04},
+			{instanceTI: instanceTypeInformation{instanceType: "r5.xlarge", vCPU: 4, memory: 32}, price: 0.06},
+		}
+	}
+
+	tests := []struct {
+		name     string
+		config   AutoScalingConfig
+		failures []string
+		scores   map[string]map[string]int64
+		want     []string
+	}{
+		{
+			name:   "only ranked by price by default",
+			config: AutoScalingConfig{PriceWeight: 1},
+			want:   []string{"c5.large", "m5.large", "r5.xlarge"},
+		},
+		{
+			name:   "no weights configured",
+			config: AutoScalingConfig{},
+			want:   []string{"c5.large", "m5.large", "r5.xlarge"},
+		},
+		{
+			name:   "interruption history",
+			config: AutoScalingConfig{PriceWeight: 1, InterruptionWeight: 1},
+			want:   []string{"m5.large", "r5.xlarge", "c5.large"},
+		},
+		{
+			name:     "placement",
+			config:   AutoScalingConfig{PriceWeight: 1, PlacementWeight: 1},
+			failures: []string{"c5.large", "c5.large", "m5.large"},
+			want:     []string{"r5.xlarge", "m5.large", "c5.large"},
+		},
+		{
+			name:   "spot placement scores",
+			config: AutoScalingConfig{PriceWeight: 1, PlacementWeight: 1},
+			scores: map[string]map[string]int64{
+				"c5.large":  {"use1-az1": 1},
+				"m5.large":  {"use1-az1": 10},
+				"r5.xlarge": {"use1-az1": 4},
+			},
+			want: []string{"m5.large", "c5.large", "r5.xlarge"},
+		},
+		{
+			name:   "spec headroom",
+			config: AutoScalingConfig{HeadroomWeight: 1},
+			want:   []string{"r5.xlarge", "c5.large", "m5.large"},
+		},
+		{
+			name:   "savings and spec headroom",
+			config: AutoScalingConfig{PriceWeight: 4, HeadroomWeight: 1},
+			want:   []string{"c5.large", "r5.xlarge", "m5.large"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			capacity := newCapacityFailures()
+			for _, f := range tt.failures {
+				capacity.record("us-east-1a", f)
+			}
+			var services connections
+			if tt.scores != nil {
+				services = connections{
+					ec2:                 mockEC2{dazo: testAvailabilityZones},
+					spotPlacementScores: &mockSpotPlacementScores{gsps: tt.scores},
+				}
+			}
+
+			i := &instance{
+				Instance: &ec2.Instance{
+					Placement: &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+				},
+				price:    0.1,
+				typeInfo: instanceTypeInformation{instanceType: "m4.large", vCPU: 2, memory: 8},
+				asg:      &autoScalingGroup{config: tt.config},
+				region: &region{
+					name:     "us-east-1",
+					conf:     &Config{spotAdvisor: advisor},
+					capacity: capacity,
+					services: services,
+				},
+			}
+
+			var got []string
+			for _, c := range i.rankByScore(candidates()) {
+				got = append(got, c.instanceTI.instanceType)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rankByScore() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsInterruptionFrequencyAcceptable(t *testing.T) {
+	var data spotAdvisorData
+	if err := json.Unmarshal([]byte(testSpotAdvisorData), &data); err != nil {
+		t.Fatal(err)
+	}
+	advisor := &spotAdvisor{data: &data}
+	advisor.once.Do(func() {})
+
+	tests := []struct {
+		name         string
+		limit        float64
+		instanceType string
+		platform     *string
+		advisor      *spotAdvisor
+		want         bool
+	}{
+		{name: "disabled", instanceType: "c5.large", advisor: advisor, want: true},
+		{name: "rarely interrupted", limit: 5, instanceType: "m5.large", advisor: advisor, want: true},
+		{name: "within the limit", limit: 15, instanceType: "r5.large", advisor: advisor, want: true},
+		{name: "interrupted too often", limit: 20, instanceType: "c5.large", advisor: advisor},
+		{name: "range starting above the limit", limit: 10, instanceType: "r5.large", advisor: advisor},
+		{name: "unknown instance type", limit: 5, instanceType: "t3.large", advisor: advisor, want: true},
+		{name: "unknown platform", limit: 5, instanceType: "c5.large", platform: aws.String("windows"),
+			advisor: advisor, want: true},
+		{name: "data not loaded", limit: 5, instanceType: "c5.large", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{Platform: tt.platform},
+				asg:      &autoScalingGroup{config: AutoScalingConfig{MaxInterruptionFrequency: tt.limit}},
+				region:   &region{name: "us-east-1", conf: &Config{spotAdvisor: tt.advisor}},
+			}
+			if got := i.isInterruptionFrequencyAcceptable(instanceTypeInformation{instanceType: tt.instanceType}); got != tt.want {
+				t.Errorf("isInterruptionFrequencyAcceptable(%s) = %v, want %v", tt.instanceType, got, tt.want)
+			}
+		})
+	}
+}
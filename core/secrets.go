@@ -0,0 +1,154 @@
+package autospotting
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/arn"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+const (
+	// secretsManagerPrefix marks the settings read from a Secrets Manager
+	// secret, given as secretsmanager:<secret name or ARN>[#<JSON key>]
+	secretsManagerPrefix = "secretsmanager:"
+
+	// secretFilePrefix marks the settings read from a file, such as a
+	// mounted Kubernetes secret, given as file:<path>
+	secretFilePrefix = "file:"
+
+	// secretCacheTTL is how long the secrets are reused across the runs of a
+	// warm Lambda function, and so how long it takes to pick up a rotated
+	// secret.
+	secretCacheTTL = 5 * time.Minute
+)
+
+// SecretsManagerClientProvider can optionally be implemented by a
+// ClientProvider which also creates Secrets Manager clients, needed for the
+// settings read from Secrets Manager. These settings are ignored when using a
+// ClientProvider not implementing it.
+type SecretsManagerClientProvider interface {
+	SecretsManager(region string) secretsmanageriface.SecretsManagerAPI
+}
+
+type cachedSecret struct {
+	value   string
+	expires time.Time
+}
+
+// secretCache keeps the values of the secrets referenced by the settings.
+type secretCache struct {
+	mu     sync.Mutex
+	values map[string]cachedSecret
+	ttl    time.Duration
+}
+
+func newSecretCache(ttl time.Duration) *secretCache {
+	return &secretCache{values: map[string]cachedSecret{}, ttl: ttl}
+}
+
+var secrets = newSecretCache(secretCacheTTL)
+
+// isSecretReference tells if the value of a setting references a secret.
+func isSecretReference(value string) bool {
+	return strings.HasPrefix(value, secretsManagerPrefix) || strings.HasPrefix(value, secretFilePrefix)
+}
+
+// get returns the value of the given secret reference, read again once the
+// cached value expires.
+func (c *secretCache) get(cfg *Config, reference string, now time.Time) (string, error) {
+	c.mu.Lock()
+	cached, ok := c.values[reference]
+	c.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.value, nil
+	}
+
+	value, err := readSecret(cfg, reference)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	c.values[reference] = cachedSecret{value: value, expires: now.Add(c.ttl)}
+	c.mu.Unlock()
+	return value, nil
+}
+
+// invalidate drops the cached value of a secret, such as when it was
+// rejected because it was rotated meanwhile.
+func (c *secretCache) invalidate(reference string) {
+	c.mu.Lock()
+	delete(c.values, reference)
+	c.mu.Unlock()
+}
+
+func readSecret(cfg *Config, reference string) (string, error) {
+	if strings.HasPrefix(reference, secretFilePrefix) {
+		data, err := ioutil.ReadFile(strings.TrimPrefix(reference, secretFilePrefix))
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	id := strings.TrimPrefix(reference, secretsManagerPrefix)
+	var key string
+	if hash := strings.LastIndex(id, "#"); hash >= 0 {
+		id, key = id[:hash], id[hash+1:]
+	}
+
+	region := cfg.MainRegion
+	if a, err := arn.Parse(id); err == nil {
+		region = a.Region
+	}
+
+	var svc secretsmanageriface.SecretsManagerAPI
+	if cfg.ClientProvider == nil {
+		svc = secretsmanager.New(newSession(cfg, region))
+	} else if p, ok := cfg.ClientProvider.(SecretsManagerClientProvider); ok {
+		svc = p.SecretsManager(region)
+	} else {
+		return "", fmt.Errorf("the client provider doesn't create Secrets Manager clients")
+	}
+
+	out, err := svc.GetSecretValue(&secretsmanager.GetSecretValueInput{SecretId: aws.String(id)})
+	if err != nil {
+		return "", err
+	}
+	value := aws.StringValue(out.SecretString)
+	if key == "" {
+		return value, nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("secret %s isn't a JSON object: %s", id, err.Error())
+	}
+	field, ok := fields[key].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s has no %s string field", id, key)
+	}
+	return field, nil
+}
+
+// secret returns the value of a setting, reading it from Secrets Manager or
+// from a file when it references a secret. The secrets which can't be read
+// are logged and considered empty.
+func (cfg *Config) secret(name, value string) string {
+	if !isSecretReference(value) {
+		return value
+	}
+	secret, err := secrets.get(cfg, value, time.Now())
+	if err != nil {
+		logger.Println("Couldn't read the", name, "secret:", err.Error())
+		return ""
+	}
+	return secret
+}
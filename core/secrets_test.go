@@ -0,0 +1,179 @@
+package autospotting
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/secretsmanager/secretsmanageriface"
+)
+
+type secretsClientProvider struct {
+	mockClientProvider
+	sm      *mockSecretsManager
+	regions []string
+}
+
+func (p *secretsClientProvider) SecretsManager(region string) secretsmanageriface.SecretsManagerAPI {
+	p.regions = append(p.regions, region)
+	return p.sm
+}
+
+func Test_readSecret(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(file, []byte("file-token\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		reference  string
+		want       string
+		wantRegion string
+		wantErr    bool
+	}{
+		{reference: "file:" + file, want: "file-token"},
+		{reference: "file:" + filepath.Join(dir, "missing"), wantErr: true},
+		{reference: "secretsmanager:autospotting/slack", want: "plain", wantRegion: "us-east-1"},
+		{reference: "secretsmanager:autospotting/tokens#pagerduty", want: "pd-key", wantRegion: "us-east-1"},
+		{reference: "secretsmanager:autospotting/tokens#datadog", wantErr: true},
+		{reference: "secretsmanager:autospotting/slack#key", wantErr: true},
+		{
+			reference:  "secretsmanager:arn:aws:secretsmanager:eu-west-1:123456789012:secret:autospotting-abc",
+			want:       "from-arn",
+			wantRegion: "eu-west-1",
+		},
+		{reference: "secretsmanager:autospotting/missing", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.reference, func(t *testing.T) {
+			provider := &secretsClientProvider{sm: &mockSecretsManager{gsv: map[string]string{
+				"autospotting/slack":  "plain",
+				"autospotting/tokens": `{"pagerduty": "pd-key"}`,
+				"arn:aws:secretsmanager:eu-west-1:123456789012:secret:autospotting-abc": "from-arn",
+			}}}
+			cfg := &Config{MainRegion: "us-east-1", ClientProvider: provider}
+
+			got, err := readSecret(cfg, tt.reference)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readSecret() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("readSecret() = %q, want %q", got, tt.want)
+			}
+			if tt.wantRegion != "" && (len(provider.regions) != 1 || provider.regions[0] != tt.wantRegion) {
+				t.Errorf("readSecret() used the regions %v, want %s", provider.regions, tt.wantRegion)
+			}
+		})
+	}
+
+	if _, err := readSecret(&Config{ClientProvider: mockClientProvider{}}, "secretsmanager:autospotting"); err == nil {
+		t.Error("expected an error when the client provider doesn't create Secrets Manager clients")
+	}
+}
+
+func Test_secretCache(t *testing.T) {
+	sm := &mockSecretsManager{gsv: map[string]string{"autospotting/slack": "v1"}}
+	cfg := &Config{MainRegion: "us-east-1", ClientProvider: &secretsClientProvider{sm: sm}}
+	c := newSecretCache(time.Minute)
+	now := time.Now()
+
+	get := func(at time.Time, want string) {
+		t.Helper()
+		got, err := c.get(cfg, "secretsmanager:autospotting/slack", at)
+		if err != nil || got != want {
+			t.Errorf("get() = %q, %v, want %q", got, err, want)
+		}
+	}
+
+	get(now, "v1")
+	sm.gsv["autospotting/slack"] = "v2"
+	get(now.Add(30*time.Second), "v1")
+	if sm.calls != 1 {
+		t.Errorf("expected the cached secret to be reused, got %d calls", sm.calls)
+	}
+
+	// the rotated secret is read again once expired or invalidated
+	get(now.Add(2*time.Minute), "v2")
+	sm.gsv["autospotting/slack"] = "v3"
+	c.invalidate("secretsmanager:autospotting/slack")
+	get(now.Add(2*time.Minute), "v3")
+
+	sm.gsverr = errors.New("AccessDenied")
+	c.invalidate("secretsmanager:autospotting/slack")
+	if _, err := c.get(cfg, "secretsmanager:autospotting/slack", now); err == nil {
+		t.Error("expected the Secrets Manager error to be returned")
+	}
+}
+
+func TestConfig_secret(t *testing.T) {
+	defer func(c *secretCache) { secrets = c }(secrets)
+	secrets = newSecretCache(time.Minute)
+
+	sm := &mockSecretsManager{gsv: map[string]string{"autospotting/notifications": "error=pagerduty:pd-key"}}
+	cfg := &Config{MainRegion: "us-east-1", ClientProvider: &secretsClientProvider{sm: sm}}
+
+	if got := cfg.secret("notifications", "error=pagerduty:plain"); got != "error=pagerduty:plain" {
+		t.Errorf("secret() = %q, want the plain value", got)
+	}
+	if got := cfg.secret("notifications", "secretsmanager:autospotting/notifications"); got != "error=pagerduty:pd-key" {
+		t.Errorf("secret() = %q, want the secret's value", got)
+	}
+	if got := cfg.secret("notifications", "secretsmanager:autospotting/missing"); got != "" {
+		t.Errorf("secret() = %q, want an empty value for a missing secret", got)
+	}
+
+	cfg.Notifications = "secretsmanager:autospotting/notifications"
+	routes := loadNotificationRoutes(cfg)
+	if len(routes) != 1 || routes[0].Events[0] != ErrorEvent {
+		t.Errorf("loadNotificationRoutes() = %+v, want the route from the secret", routes)
+	}
+}
+
+func TestHandleSlackCommandWithRotatedSecret(t *testing.T) {
+	defer func(c *secretCache) { secrets = c }(secrets)
+	secrets = newSecretCache(time.Hour)
+
+	sm := &mockSecretsManager{gsv: map[string]string{"autospotting/slack": "old"}}
+	cfg := &Config{
+		MainRegion:         "us-east-1",
+		ClientProvider:     &secretsClientProvider{sm: sm},
+		SlackSigningSecret: "secretsmanager:autospotting/slack",
+		Regions:            "eu-west-1",
+	}
+
+	request := func(secret string) events.APIGatewayProxyRequest {
+		body := "command=%2Fautospotting&text=status&user_name=oncall"
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		return events.APIGatewayProxyRequest{
+			HTTPMethod: "POST",
+			Body:       body,
+			Headers: map[string]string{
+				"x-slack-request-timestamp": ts,
+				"x-slack-signature":         slackSignature(secret, ts, body),
+			},
+		}
+	}
+
+	if resp := HandleSlackCommand(cfg, request("old")); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected response: %v", resp)
+	}
+
+	sm.gsv["autospotting/slack"] = "new"
+	if resp := HandleSlackCommand(cfg, request("new")); resp.StatusCode != http.StatusOK {
+		t.Errorf("expected the rotated secret to be used, got %v", resp)
+	}
+	if resp := HandleSlackCommand(cfg, request("other")); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected an invalid signature to be rejected, got %v", resp)
+	}
+}
@@ -0,0 +1,109 @@
+package autospotting
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// SpotCandidate is a spot instance type found to be compatible with and
+// cheaper than a given on-demand instance.
+type SpotCandidate struct {
+	InstanceType string
+
+	// Spot price in the availability zone of the on-demand instance, including
+	// the EBS optimization and CPU credit surcharges where applicable.
+	Price float64
+}
+
+// SpotCandidateQuery describes an on-demand instance for which we want to find
+// compatible spot instance types, together with the market data used for the
+// price comparison.
+type SpotCandidateQuery struct {
+	// The region where the instance is running
+	Region string
+
+	// The on-demand instance, it needs to have at least the InstanceType and
+	// Placement attributes set. The VirtualizationType defaults to HVM.
+	Instance *ec2.Instance
+
+	// Current spot prices, as returned by the DescribeSpotPriceHistory API
+	SpotPrices []*ec2.SpotPrice
+
+	// Lists of allowed and disallowed instance types, supporting globs
+	AllowedInstanceTypes    []string
+	DisallowedInstanceTypes []string
+}
+
+// FindSpotCandidates returns the list of spot instance types compatible with
+// the on-demand instance described in the query, sorted ascending by price.
+// It uses the same logic that AutoSpotting uses when replacing instances from
+// AutoScaling groups, and it doesn't call any AWS APIs, so it can be used by
+// other tools for embedding the instance type selection logic.
+func FindSpotCandidates(cfg *Config, q SpotCandidateQuery) ([]SpotCandidate, error) {
+
+	if cfg == nil || cfg.InstanceData == nil {
+		return nil, errors.New("missing instance type data in the configuration")
+	}
+
+	if q.Instance == nil || q.Instance.InstanceType == nil ||
+		q.Instance.Placement == nil || q.Instance.Placement.AvailabilityZone == nil {
+		return nil, errors.New("incomplete instance information")
+	}
+
+	if logger == nil {
+		setupLogging(cfg)
+	}
+
+	i, err := newOfflineInstance(cfg, q.Region, *q.Instance, q.SpotPrices)
+	if err != nil {
+		return nil, err
+	}
+
+	types, err := i.getCompatibleSpotInstanceTypesListSortedAscendingByPrice(
+		q.AllowedInstanceTypes, q.DisallowedInstanceTypes)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []SpotCandidate
+	for _, t := range types {
+		candidates = append(candidates, SpotCandidate{
+			InstanceType: t.instanceType,
+			Price:        i.calculatePrice(t),
+		})
+	}
+	return candidates, nil
+}
+
+// newOfflineInstance returns the on-demand instance in a region whose
+// instance types are described by the instance type data of the
+// configuration and whose spot prices are the given ones, so that its spot
+// candidates can be found without calling any AWS APIs.
+func newOfflineInstance(cfg *Config, regionName string, inst ec2.Instance, spotPrices []*ec2.SpotPrice) (*instance, error) {
+	r := &region{name: regionName, conf: cfg}
+	r.loadInstanceTypeInformation(cfg)
+	r.applySpotPrices(spotPrices)
+
+	typeInfo, found := r.instanceTypeInformation[*inst.InstanceType]
+	if !found {
+		return nil, errors.New("unknown instance type " + *inst.InstanceType +
+			" in region " + regionName)
+	}
+
+	// inst is a copy so we can safely fill in defaults
+	if inst.VirtualizationType == nil {
+		inst.VirtualizationType = aws.String(ec2.VirtualizationTypeHvm)
+	}
+
+	return &instance{
+		Instance: &inst,
+		typeInfo: typeInfo,
+		price:    typeInfo.pricing.onDemand,
+		region:   r,
+		asg:      &autoScalingGroup{Group: &autoscaling.Group{}, region: r},
+	}, nil
+}
@@ -0,0 +1,130 @@
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	ec2instancesinfo "github.com/cristim/ec2-instances-info"
+)
+
+func testInstanceData() *ec2instancesinfo.InstanceData {
+	pricing := func(price float64) map[string]ec2instancesinfo.RegionPrices {
+		return map[string]ec2instancesinfo.RegionPrices{
+			"us-east-1": {
+				Linux: ec2instancesinfo.LinuxPricing{OnDemand: price},
+			},
+		}
+	}
+	return &ec2instancesinfo.InstanceData{
+		0: {InstanceType: "m5.large", VCPU: 2, Memory: 8, PhysicalProcessor: "Intel", Pricing: pricing(0.096)},
+		1: {InstanceType: "m5.xlarge", VCPU: 4, Memory: 16, PhysicalProcessor: "Intel", Pricing: pricing(0.192)},
+		2: {InstanceType: "c5.large", VCPU: 2, Memory: 4, PhysicalProcessor: "Intel", Pricing: pricing(0.085)},
+		3: {InstanceType: "m5a.large", VCPU: 2, Memory: 8, PhysicalProcessor: "AMD", Pricing: pricing(0.086)},
+	}
+}
+
+func testSpotPrice(instanceType string, price string) *ec2.SpotPrice {
+	return &ec2.SpotPrice{
+		InstanceType:     aws.String(instanceType),
+		AvailabilityZone: aws.String("us-east-1a"),
+		SpotPrice:        aws.String(price),
+	}
+}
+
+func TestFindSpotCandidates(t *testing.T) {
+
+	onDemand := &ec2.Instance{
+		InstanceType: aws.String("m5.large"),
+		Placement:    &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+	}
+
+	prices := []*ec2.SpotPrice{
+		testSpotPrice("m5.large", "0.04"),
+		testSpotPrice("m5.xlarge", "0.03"),
+		testSpotPrice("c5.large", "0.01"),
+		testSpotPrice("m5a.large", "0.035"),
+	}
+
+	tests := []struct {
+		name    string
+		cfg     *Config
+		query   SpotCandidateQuery
+		want    []SpotCandidate
+		wantErr bool
+	}{
+		{
+			name:    "missing instance data",
+			cfg:     &Config{},
+			query:   SpotCandidateQuery{Region: "us-east-1", Instance: onDemand},
+			wantErr: true,
+		},
+		{
+			name:    "missing instance",
+			cfg:     &Config{InstanceData: testInstanceData()},
+			query:   SpotCandidateQuery{Region: "us-east-1"},
+			wantErr: true,
+		},
+		{
+			name: "unknown instance type",
+			cfg:  &Config{InstanceData: testInstanceData()},
+			query: SpotCandidateQuery{
+				Region: "us-east-1",
+				Instance: &ec2.Instance{
+					InstanceType: aws.String("x1.foo"),
+					Placement:    &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "compatible types sorted by price",
+			cfg:  &Config{InstanceData: testInstanceData()},
+			query: SpotCandidateQuery{
+				Region:     "us-east-1",
+				Instance:   onDemand,
+				SpotPrices: prices,
+			},
+			want: []SpotCandidate{
+				{InstanceType: "m5.xlarge", Price: 0.03},
+				{InstanceType: "m5a.large", Price: 0.035},
+				{InstanceType: "m5.large", Price: 0.04},
+			},
+		},
+		{
+			name: "disallowed types are excluded",
+			cfg:  &Config{InstanceData: testInstanceData()},
+			query: SpotCandidateQuery{
+				Region:                  "us-east-1",
+				Instance:                onDemand,
+				SpotPrices:              prices,
+				DisallowedInstanceTypes: []string{"m5a.*", "m5.xlarge"},
+			},
+			want: []SpotCandidate{
+				{InstanceType: "m5.large", Price: 0.04},
+			},
+		},
+		{
+			name: "no spot prices available",
+			cfg:  &Config{InstanceData: testInstanceData()},
+			query: SpotCandidateQuery{
+				Region:   "us-east-1",
+				Instance: onDemand,
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FindSpotCandidates(tt.cfg, tt.query)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("FindSpotCandidates() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("FindSpotCandidates() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package autospotting
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/signer/v4"
+	"github.com/aws/aws-sdk-go/private/protocol/jsonrpc"
+)
+
+// The version of the AWS SDK we use predates the Service Quotas API, so this
+// is a minimal client implementing the only call we need, built just like the
+// SDK's own JSON-RPC clients.
+
+// serviceQuotasAPI is the subset of the Service Quotas API used by
+// AutoSpotting.
+type serviceQuotasAPI interface {
+	GetServiceQuota(*getServiceQuotaInput) (*getServiceQuotaOutput, error)
+	RequestServiceQuotaIncrease(*requestServiceQuotaIncreaseInput) (*requestServiceQuotaIncreaseOutput, error)
+}
+
+type getServiceQuotaInput struct {
+	_ struct{} `type:"structure"`
+
+	ServiceCode *string `type:"string"`
+	QuotaCode   *string `type:"string"`
+}
+
+type getServiceQuotaOutput struct {
+	_ struct{} `type:"structure"`
+
+	Quota *serviceQuota `type:"structure"`
+}
+
+type serviceQuota struct {
+	_ struct{} `type:"structure"`
+
+	QuotaCode *string  `type:"string"`
+	QuotaName *string  `type:"string"`
+	Value     *float64 `type:"double"`
+}
+
+type requestServiceQuotaIncreaseInput struct {
+	_ struct{} `type:"structure"`
+
+	ServiceCode  *string  `type:"string"`
+	QuotaCode    *string  `type:"string"`
+	DesiredValue *float64 `type:"double"`
+}
+
+type requestServiceQuotaIncreaseOutput struct {
+	_ struct{} `type:"structure"`
+
+	RequestedQuota *requestedServiceQuotaChange `type:"structure"`
+}
+
+type requestedServiceQuotaChange struct {
+	_ struct{} `type:"structure"`
+
+	Id           *string  `type:"string"`
+	CaseId       *string  `type:"string"`
+	Status       *string  `type:"string"`
+	DesiredValue *float64 `type:"double"`
+}
+
+type serviceQuotas struct {
+	*client.Client
+}
+
+func newServiceQuotas(p client.ConfigProvider, cfgs ...*aws.Config) *serviceQuotas {
+	c := p.ClientConfig("servicequotas", cfgs...)
+
+	svc := &serviceQuotas{
+		Client: client.New(
+			*c.Config,
+			metadata.ClientInfo{
+				ServiceName:   "servicequotas",
+				ServiceID:     "Service Quotas",
+				SigningName:   c.SigningName,
+				SigningRegion: c.SigningRegion,
+				Endpoint:      c.Endpoint,
+				APIVersion:    "2019-06-24",
+				JSONVersion:   "1.1",
+				TargetPrefix:  "ServiceQuotasV20190624",
+			},
+			c.Handlers,
+		),
+	}
+
+	svc.Handlers.Sign.PushBackNamed(v4.SignRequestHandler)
+	svc.Handlers.Build.PushBackNamed(jsonrpc.BuildHandler)
+	svc.Handlers.Unmarshal.PushBackNamed(jsonrpc.UnmarshalHandler)
+	svc.Handlers.UnmarshalMeta.PushBackNamed(jsonrpc.UnmarshalMetaHandler)
+	svc.Handlers.UnmarshalError.PushBackNamed(jsonrpc.UnmarshalErrorHandler)
+
+	return svc
+}
+
+// GetServiceQuota returns the applied value of the given quota.
+func (s *serviceQuotas) GetServiceQuota(input *getServiceQuotaInput) (*getServiceQuotaOutput, error) {
+	op := &request.Operation{
+		Name:       "GetServiceQuota",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+
+	output := &getServiceQuotaOutput{}
+	req := s.NewRequest(op, input, output)
+	return output, req.Send()
+}
+
+// RequestServiceQuotaIncrease opens a request for increasing the given quota
+// to the desired value.
+func (s *serviceQuotas) RequestServiceQuotaIncrease(input *requestServiceQuotaIncreaseInput) (*requestServiceQuotaIncreaseOutput, error) {
+	op := &request.Operation{
+		Name:       "RequestServiceQuotaIncrease",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+
+	output := &requestServiceQuotaIncreaseOutput{}
+	req := s.NewRequest(op, input, output)
+	return output, req.Send()
+}
@@ -0,0 +1,155 @@
+package autospotting
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// The version of the AWS SDK we use predates the Spot placement scores API,
+// so this is a minimal implementation of the only call we need, sent by the
+// SDK's own EC2 client.
+
+// maxSpotPlacementScoreTypes is the number of instance types whose Spot
+// placement scores are fetched in each region during a run, since the API
+// limits the number of different requests. The cheapest candidates are
+// scored first.
+const maxSpotPlacementScoreTypes = 10
+
+// spotPlacementScoresAPI is the Spot placement scores API of EC2.
+type spotPlacementScoresAPI interface {
+	GetSpotPlacementScores(*getSpotPlacementScoresInput) (*getSpotPlacementScoresOutput, error)
+}
+
+type getSpotPlacementScoresInput struct {
+	_ struct{} `type:"structure"`
+
+	InstanceTypes          []*string `locationName:"InstanceType" type:"list"`
+	MaxResults             *int64    `type:"integer"`
+	NextToken              *string   `type:"string"`
+	RegionNames            []*string `locationName:"RegionName" type:"list"`
+	SingleAvailabilityZone *bool     `type:"boolean"`
+	TargetCapacity         *int64    `type:"integer"`
+}
+
+type getSpotPlacementScoresOutput struct {
+	_ struct{} `type:"structure"`
+
+	NextToken           *string               `locationName:"nextToken" type:"string"`
+	SpotPlacementScores []*spotPlacementScore `locationName:"spotPlacementScoreSet" locationNameList:"item" type:"list"`
+}
+
+type spotPlacementScore struct {
+	_ struct{} `type:"structure"`
+
+	AvailabilityZoneID *string `locationName:"availabilityZoneId" type:"string"`
+	Region             *string `locationName:"region" type:"string"`
+	Score              *int64  `locationName:"score" type:"integer"`
+}
+
+type spotPlacementScores struct {
+	*ec2.EC2
+}
+
+// GetSpotPlacementScores returns the scores of the availability zones or
+// regions for launching the given spot capacity.
+func (s spotPlacementScores) GetSpotPlacementScores(input *getSpotPlacementScoresInput) (*getSpotPlacementScoresOutput, error) {
+	op := &request.Operation{
+		Name:       "GetSpotPlacementScores",
+		HTTPMethod: "POST",
+		HTTPPath:   "/",
+	}
+
+	output := &getSpotPlacementScoresOutput{}
+	req := s.NewRequest(op, input, output)
+	return output, req.Send()
+}
+
+// spotPlacementScoreCache keeps the Spot placement scores of the instance
+// types of a region fetched during the run, by availability zone ID.
+type spotPlacementScoreCache struct {
+	mu       sync.Mutex
+	zoneIDs  map[string]string
+	scores   map[string]map[string]int64
+	disabled bool
+}
+
+// spotPlacementScore returns the Spot placement score of the instance type in
+// the availability zone, between 0 and 1, or false when the scores aren't
+// available in the region. The instance types which weren't scored, such as
+// those beyond the maxSpotPlacementScoreTypes cheapest ones, are ranked
+// halfway.
+func (r *region) spotPlacementScore(az, instanceType string) (float64, bool) {
+	svc := r.services.spotPlacementScores
+	if svc == nil {
+		return 0, false
+	}
+
+	c := &r.spotScores
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.disabled {
+		return 0, false
+	}
+
+	if c.zoneIDs == nil {
+		out, err := r.services.ec2.DescribeAvailabilityZones(&ec2.DescribeAvailabilityZonesInput{})
+		if err != nil {
+			logger.Println(r.name, "Not using the Spot placement scores, failed to describe the availability zones:",
+				err.Error())
+			c.disabled = true
+			return 0, false
+		}
+		c.zoneIDs = make(map[string]string)
+		for _, zone := range out.AvailabilityZones {
+			c.zoneIDs[aws.StringValue(zone.ZoneName)] = aws.StringValue(zone.ZoneId)
+		}
+		c.scores = make(map[string]map[string]int64)
+	}
+
+	scores, ok := c.scores[instanceType]
+	if !ok && len(c.scores) < maxSpotPlacementScoreTypes {
+		var err error
+		if scores, err = fetchSpotPlacementScores(svc, r.name, instanceType); err != nil {
+			logger.Println(r.name, "Not using the Spot placement scores, failed to fetch them:", err.Error())
+			c.disabled = true
+			return 0, false
+		}
+		c.scores[instanceType] = scores
+	}
+
+	score, ok := scores[c.zoneIDs[az]]
+	if !ok {
+		return 0.5, true
+	}
+	return float64(score) / 10, true
+}
+
+// fetchSpotPlacementScores returns the Spot placement scores of the
+// availability zones of the region for launching a spot instance of the given
+// type, by availability zone ID.
+func fetchSpotPlacementScores(svc spotPlacementScoresAPI, region, instanceType string) (map[string]int64, error) {
+	scores := make(map[string]int64)
+	input := &getSpotPlacementScoresInput{
+		InstanceTypes:          []*string{aws.String(instanceType)},
+		RegionNames:            []*string{aws.String(region)},
+		SingleAvailabilityZone: aws.Bool(true),
+		TargetCapacity:         aws.Int64(1),
+	}
+	for {
+		out, err := svc.GetSpotPlacementScores(input)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range out.SpotPlacementScores {
+			scores[aws.StringValue(s.AvailabilityZoneID)] = aws.Int64Value(s.Score)
+		}
+		if aws.StringValue(out.NextToken) == "" {
+			return scores, nil
+		}
+		input.NextToken = out.NextToken
+	}
+}
@@ -0,0 +1,109 @@
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+var testAvailabilityZones = &ec2.DescribeAvailabilityZonesOutput{
+	AvailabilityZones: []*ec2.AvailabilityZone{
+		{ZoneName: aws.String("us-east-1a"), ZoneId: aws.String("use1-az1")},
+		{ZoneName: aws.String("us-east-1b"), ZoneId: aws.String("use1-az2")},
+	},
+}
+
+func TestSpotPlacementScore(t *testing.T) {
+	svc := &mockSpotPlacementScores{gsps: map[string]map[string]int64{
+		"m5.large": {"use1-az1": 9, "use1-az2": 3},
+	}}
+	r := &region{
+		name: "us-east-1",
+		services: connections{
+			ec2:                 mockEC2{dazo: testAvailabilityZones},
+			spotPlacementScores: svc,
+		},
+	}
+
+	tests := []struct {
+		az, instanceType string
+		want             float64
+	}{
+		{az: "us-east-1a", instanceType: "m5.large", want: 0.9},
+		{az: "us-east-1b", instanceType: "m5.large", want: 0.3},
+		{az: "us-east-1a", instanceType: "c5.large", want: 0.5},
+	}
+	for _, tt := range tests {
+		got, ok := r.spotPlacementScore(tt.az, tt.instanceType)
+		if !ok || got != tt.want {
+			t.Errorf("spotPlacementScore(%s, %s) = %v, %v, want %v, true", tt.az, tt.instanceType, got, ok, tt.want)
+		}
+	}
+
+	if want := []string{"m5.large", "c5.large"}; !reflect.DeepEqual(svc.calls, want) {
+		t.Errorf("GetSpotPlacementScores() called for %v, want %v", svc.calls, want)
+	}
+}
+
+func TestSpotPlacementScoreLimitsTheInstanceTypes(t *testing.T) {
+	svc := &mockSpotPlacementScores{}
+	r := &region{
+		name: "us-east-1",
+		services: connections{
+			ec2:                 mockEC2{dazo: testAvailabilityZones},
+			spotPlacementScores: svc,
+		},
+	}
+
+	for n := 0; n < maxSpotPlacementScoreTypes+2; n++ {
+		if _, ok := r.spotPlacementScore("us-east-1a", fmt.Sprintf("type-%d", n)); !ok {
+			t.Fatalf("spotPlacementScore() of type-%d not available", n)
+		}
+	}
+	if len(svc.calls) != maxSpotPlacementScoreTypes {
+		t.Errorf("GetSpotPlacementScores() called %d times, want %d", len(svc.calls), maxSpotPlacementScoreTypes)
+	}
+}
+
+func TestSpotPlacementScoreUnavailable(t *testing.T) {
+	tests := []struct {
+		name     string
+		services connections
+	}{
+		{
+			name:     "no client",
+			services: connections{ec2: mockEC2{dazo: testAvailabilityZones}},
+		},
+		{
+			name: "availability zones failing",
+			services: connections{
+				ec2:                 mockEC2{dazerr: errors.New("UnauthorizedOperation")},
+				spotPlacementScores: &mockSpotPlacementScores{},
+			},
+		},
+		{
+			name: "scores failing",
+			services: connections{
+				ec2:                 mockEC2{dazo: testAvailabilityZones},
+				spotPlacementScores: &mockSpotPlacementScores{gspserr: errors.New("UnauthorizedOperation")},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &region{name: "us-east-1", services: tt.services}
+			for n := 0; n < 2; n++ {
+				if _, ok := r.spotPlacementScore("us-east-1a", "m5.large"); ok {
+					t.Error("spotPlacementScore() available")
+				}
+			}
+			if svc, ok := tt.services.spotPlacementScores.(*mockSpotPlacementScores); ok && len(svc.calls) > 1 {
+				t.Errorf("GetSpotPlacementScores() called %d times after failing", len(svc.calls))
+			}
+		})
+	}
+}
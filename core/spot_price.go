@@ -1,6 +1,9 @@
 package autospotting
 
 import (
+	"sort"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -12,13 +15,98 @@ type spotPrices struct {
 	conn connections
 }
 
-// fetch queries all spot prices in the current region
+// spotPriceCache keeps the spot prices fetched during the current run, by
+// account, region, instance family and availability zone, so that each batch
+// is only requested once no matter how many groups and commands need it.
+type spotPriceCache struct {
+	sync.Mutex
+	batches map[string][]*ec2.SpotPrice
+}
+
+func (c *spotPriceCache) get(key string) ([]*ec2.SpotPrice, bool) {
+	if c == nil {
+		return nil, false
+	}
+	c.Lock()
+	defer c.Unlock()
+	data, ok := c.batches[key]
+	return data, ok
+}
+
+func (c *spotPriceCache) put(key string, data []*ec2.SpotPrice) {
+	if c == nil {
+		return
+	}
+	c.Lock()
+	defer c.Unlock()
+	if c.batches == nil {
+		c.batches = make(map[string][]*ec2.SpotPrice)
+	}
+	c.batches[key] = data
+}
+
+// fetchBatches queries the spot prices of the given instance types, one batch
+// per instance family and availability zone, or per family across all the
+// zones of the region when no zones are given. The batches already fetched
+// during the run are taken from the cache, under the given scope identifying
+// the account and the region.
+func (s *spotPrices) fetchBatches(cache *spotPriceCache, scope string,
+	product string, zones []string, instanceTypes []string) error {
+
+	families := make(map[string][]*string)
+	for _, it := range instanceTypes {
+		f := instanceFamily(it)
+		families[f] = append(families[f], aws.String(it))
+	}
+	names := make([]string, 0, len(families))
+	for f := range families {
+		names = append(names, f)
+	}
+	sort.Strings(names)
+
+	if len(zones) == 0 {
+		zones = []string{""}
+	}
+
+	var data []*ec2.SpotPrice
+	requests := 0
+	for _, f := range names {
+		for _, az := range zones {
+			key := strings.Join([]string{scope, product, f, az}, "/")
+			if batch, ok := cache.get(key); ok {
+				data = append(data, batch...)
+				continue
+			}
+
+			var availabilityZone *string
+			if az != "" {
+				availabilityZone = aws.String(az)
+			}
+			requests++
+			if err := s.fetch(product, 0, availabilityZone, families[f]); err != nil {
+				return err
+			}
+			cache.put(key, s.data)
+			data = append(data, s.data...)
+		}
+	}
+	s.data = data
+
+	logger.Println(s.conn.region, "Fetched", len(data), "spot prices of", len(names),
+		"instance families in", len(zones), "zones using", requests, "requests")
+	return nil
+}
+
+// fetch queries the spot prices of the given instance types in the current
+// region, optionally limited to a single availability zone. The results are
+// paginated, since the first page only covers a fraction of the instance
+// types and availability zones of the large regions.
 func (s *spotPrices) fetch(product string,
 	duration time.Duration,
 	availabilityZone *string,
 	instanceTypes []*string) error {
 
-	logger.Println(s.conn.region, "Requesting spot prices")
+	debug.Println(s.conn.region, "Requesting spot prices", aws.StringValue(availabilityZone), aws.StringValueSlice(instanceTypes))
 
 	ec2Conn := s.conn.ec2
 	params := &ec2.DescribeSpotPriceHistoryInput{
@@ -31,14 +119,22 @@ func (s *spotPrices) fetch(product string,
 		InstanceTypes:    instanceTypes,
 	}
 
-	resp, err := ec2Conn.DescribeSpotPriceHistory(params)
+	var data []*ec2.SpotPrice
+	pages := 0
+	err := ec2Conn.DescribeSpotPriceHistoryPages(params,
+		func(page *ec2.DescribeSpotPriceHistoryOutput, lastPage bool) bool {
+			pages++
+			data = append(data, page.SpotPriceHistory...)
+			return true
+		})
 
 	if err != nil {
 		logger.Println(s.conn.region, "Failed requesting spot prices:", err.Error())
 		return err
 	}
 
-	s.data = resp.SpotPriceHistory
+	debug.Println(s.conn.region, "Fetched", len(data), "spot prices in", pages, "pages")
+	s.data = data
 
 	return nil
 }
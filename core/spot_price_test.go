@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/AutoSpotting/AutoSpotting/core/autospottingtest"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/ec2"
 )
@@ -61,6 +62,24 @@ func Test_fetch(t *testing.T) {
 			},
 			err: errors.New(""),
 		},
+		{
+			name: "multiple pages",
+			config: &spotPrices{
+				conn: connections{
+					ec2: mockEC2{
+						dsphp: []*ec2.DescribeSpotPriceHistoryOutput{
+							{SpotPriceHistory: []*ec2.SpotPrice{{SpotPrice: aws.String("1")}}},
+							{SpotPriceHistory: []*ec2.SpotPrice{{SpotPrice: aws.String("2")}}},
+						},
+					},
+				},
+			},
+			data: []*ec2.SpotPrice{
+				{SpotPrice: aws.String("1")},
+				{SpotPrice: aws.String("2")},
+			},
+			err: errors.New(""),
+		},
 	}
 
 	for _, tc := range tests {
@@ -82,3 +101,68 @@ func Test_fetch(t *testing.T) {
 		})
 	}
 }
+
+func Test_requestSpotPrices(t *testing.T) {
+	cloud := autospottingtest.NewCloud()
+	for _, az := range []string{"us-east-1a", "us-east-1b"} {
+		cloud.Region("us-east-1").SetSpotPrice("m5.large", az, 0.03)
+		cloud.Region("us-east-1").SetSpotPrice("m5.xlarge", az, 0.06)
+		cloud.Region("us-east-1").SetSpotPrice("c5.large", az, 0.04)
+	}
+	cfg := &Config{spotPrices: &spotPriceCache{}}
+
+	newRegion := func(cfg *Config) *region {
+		r := &region{name: "us-east-1", conf: cfg, services: connections{ec2: cloud.EC2("us-east-1")}}
+		r.instanceTypeInformation = make(map[string]instanceTypeInformation)
+		for _, it := range []string{"m5.large", "m5.xlarge", "c5.large"} {
+			r.instanceTypeInformation[it] = instanceTypeInformation{
+				instanceType: it,
+				pricing:      prices{onDemand: 0.1, spot: make(spotPriceMap)},
+			}
+		}
+		return r
+	}
+	requests := func() int {
+		n := 0
+		for _, c := range cloud.Calls() {
+			if c.Operation == "DescribeSpotPriceHistory" {
+				n++
+			}
+		}
+		return n
+	}
+
+	// a request per instance family in the zone of the groups
+	r := newRegion(cfg)
+	if err := r.requestSpotPrices([]string{"us-east-1a"}); err != nil {
+		t.Fatalf("requestSpotPrices() error = %v", err)
+	}
+	if n := requests(); n != 2 {
+		t.Errorf("requestSpotPrices() made %d requests, want 2", n)
+	}
+	if p := r.instanceTypeInformation["m5.xlarge"].pricing.spot; p["us-east-1a"] != 0.06 || len(p) != 1 {
+		t.Errorf("requestSpotPrices() prices = %v", p)
+	}
+
+	// shared with the other groups and commands of the run
+	r = newRegion(cfg)
+	if err := r.requestSpotPrices([]string{"us-east-1a", "us-east-1b"}); err != nil {
+		t.Fatalf("requestSpotPrices() error = %v", err)
+	}
+	if n := requests(); n != 4 {
+		t.Errorf("requestSpotPrices() made %d requests, want only those of the new zone", n)
+	}
+	if p := r.instanceTypeInformation["c5.large"].pricing.spot; p["us-east-1a"] != 0.04 || p["us-east-1b"] != 0.04 {
+		t.Errorf("requestSpotPrices() prices = %v", p)
+	}
+
+	// but not with the other accounts, whose zone names differ
+	other := *cfg
+	other.accountID = "111111111111"
+	if err := newRegion(&other).requestSpotPrices([]string{"us-east-1a"}); err != nil {
+		t.Fatalf("requestSpotPrices() error = %v", err)
+	}
+	if n := requests(); n != 6 {
+		t.Errorf("requestSpotPrices() made %d requests, want the prices of the other account", n)
+	}
+}
@@ -0,0 +1,279 @@
+package autospotting
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+)
+
+// The Service Quotas codes of the spot vCPU quotas, grouped by instance family
+const (
+	standardSpotQuota = "L-34B43A08" // All Standard (A, C, D, H, I, M, R, T, Z) Spot Instance Requests
+	gSpotQuota        = "L-3819A6DF" // All G and VT Spot Instance Requests
+	pSpotQuota        = "L-7212CCBC" // All P Spot Instance Requests
+	xSpotQuota        = "L-E3A00192" // All X Spot Instance Requests
+	fSpotQuota        = "L-88CF9481" // All F Spot Instance Requests
+	infSpotQuota      = "L-B5D1601B" // All Inf Spot Instance Requests
+)
+
+// spotQuotaCode returns the code of the spot vCPU quota covering the given
+// instance type, or an empty string for the families without a known quota.
+func spotQuotaCode(instanceType string) string {
+	family := strings.ToLower(strings.SplitN(instanceType, ".", 2)[0])
+
+	switch {
+	case family == "":
+		return ""
+	case strings.HasPrefix(family, "inf"):
+		return infSpotQuota
+	case strings.HasPrefix(family, "vt"):
+		return gSpotQuota
+	case strings.HasPrefix(family, "dl"), strings.HasPrefix(family, "hpc"),
+		strings.HasPrefix(family, "mac"), strings.HasPrefix(family, "trn"),
+		strings.HasPrefix(family, "u-"):
+		return ""
+	}
+
+	switch family[0] {
+	case 'a', 'c', 'd', 'h', 'i', 'm', 'r', 't', 'z':
+		return standardSpotQuota
+	case 'g':
+		return gSpotQuota
+	case 'p':
+		return pSpotQuota
+	case 'x':
+		return xSpotQuota
+	case 'f':
+		return fSpotQuota
+	}
+	return ""
+}
+
+// spotQuotas keeps track of the spot vCPU quotas of a region and of their
+// usage, so that we don't attempt launches that would exceed them.
+type spotQuotas struct {
+	mu sync.Mutex
+
+	svc       serviceQuotasAPI
+	region    string
+	threshold float64
+
+	// percentage by which the quotas are automatically increased when
+	// getting close to them, zero disables the increase requests
+	increasePercentage float64
+
+	// applied quota values by quota code, negative when unknown
+	limits map[string]float64
+	usage  map[string]float64
+	warned map[string]bool
+
+	// called when the usage of a quota crosses the warning threshold
+	warn func(message string)
+}
+
+// newSpotQuotas computes the current usage of the spot vCPU quotas from the
+// running spot instances of the region, the quota values themselves are
+// fetched when first needed.
+func (r *region) newSpotQuotas() *spotQuotas {
+	if !r.conf.CheckSpotQuotas || r.services.serviceQuotas == nil {
+		return nil
+	}
+
+	q := &spotQuotas{
+		svc:       r.services.serviceQuotas,
+		region:    r.name,
+		threshold: r.conf.SpotQuotaWarningThreshold,
+		limits:    make(map[string]float64),
+		usage:     make(map[string]float64),
+		warned:    make(map[string]bool),
+		warn: func(message string) {
+			notify(r.conf, WarningEvent, "AutoSpotting spot quota warning in "+r.name, message)
+		},
+		increasePercentage: r.conf.SpotQuotaIncreasePercentage,
+	}
+
+	for i := range r.instances.instances() {
+		if !i.isSpot() {
+			continue
+		}
+		if code := spotQuotaCode(*i.InstanceType); code != "" {
+			q.usage[code] += float64(i.typeInfo.vCPU)
+		}
+	}
+	return q
+}
+
+// limit returns the value of the given quota, or a negative value if it
+// couldn't be determined. Must be called with the lock held.
+func (q *spotQuotas) limit(code string) float64 {
+	if l, ok := q.limits[code]; ok {
+		return l
+	}
+
+	q.limits[code] = -1
+
+	out, err := q.svc.GetServiceQuota(&getServiceQuotaInput{
+		ServiceCode: aws.String("ec2"),
+		QuotaCode:   aws.String(code),
+	})
+	if err != nil {
+		logger.Println(q.region, "Couldn't determine the spot quota", code,
+			"launching without checking it:", err.Error())
+		return -1
+	}
+	if out.Quota != nil && out.Quota.Value != nil {
+		q.limits[code] = *out.Quota.Value
+	}
+	return q.limits[code]
+}
+
+// reserve accounts for the vCPUs of a new spot instance of the given type,
+// returning false if launching it would exceed its spot quota. Reservations
+// are always allowed when the quota is unknown.
+func (q *spotQuotas) reserve(instanceType string, vCPU int) bool {
+	if q == nil {
+		return true
+	}
+
+	code := spotQuotaCode(instanceType)
+	if code == "" {
+		return true
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	limit := q.limit(code)
+	if limit < 0 {
+		return true
+	}
+
+	if q.usage[code]+float64(vCPU) > limit {
+		logger.Printf("%s Launching %s would exceed the spot quota %s: %.0f of %.0f vCPUs already used\n",
+			q.region, instanceType, code, q.usage[code], limit)
+		q.alert(code, limit)
+		return false
+	}
+	q.usage[code] += float64(vCPU)
+
+	if q.threshold > 0 && q.usage[code]*100/limit >= q.threshold {
+		q.alert(code, limit)
+	}
+	return true
+}
+
+// exhausted records that a launch of the given instance type was rejected
+// because of its spot quota, so that no further launches are attempted
+// against that quota during this run.
+func (q *spotQuotas) exhausted(instanceType string) {
+	if q == nil {
+		return
+	}
+
+	code := spotQuotaCode(instanceType)
+	if code == "" {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	limit := q.limit(code)
+	if limit < 0 {
+		// the quota couldn't be fetched, our current usage is the best
+		// estimation we have
+		limit = q.usage[code]
+		q.limits[code] = limit
+	}
+	q.usage[code] = limit
+	q.alert(code, limit)
+}
+
+// alert reports once per run that the usage of a quota is getting close to
+// its value, suggesting an increase which is also requested if configured.
+// Must be called with the lock held.
+func (q *spotQuotas) alert(code string, limit float64) {
+	if q.warned[code] {
+		return
+	}
+	q.warned[code] = true
+
+	suggested := suggestedQuotaValue(limit, q.increasePercentage)
+
+	message := fmt.Sprintf("%s: %.0f of the %.0f vCPUs allowed by the spot quota %s are in use",
+		q.region, q.usage[code], limit, code)
+	if limit > 0 {
+		message += fmt.Sprintf(" (%.1f%%)", q.usage[code]*100/limit)
+	}
+
+	if q.increasePercentage > 0 {
+		message += ". " + q.requestIncrease(code, suggested)
+	} else {
+		message += fmt.Sprintf(". Consider requesting an increase of the quota to %.0f vCPUs", suggested)
+	}
+
+	logger.Println("Warning:", message)
+	if q.warn != nil {
+		q.warn(message)
+	}
+}
+
+// requestIncrease opens a Service Quotas increase request for the given
+// quota, returning a description of the outcome.
+func (q *spotQuotas) requestIncrease(code string, value float64) string {
+	out, err := q.svc.RequestServiceQuotaIncrease(&requestServiceQuotaIncreaseInput{
+		ServiceCode:  aws.String("ec2"),
+		QuotaCode:    aws.String(code),
+		DesiredValue: aws.Float64(value),
+	})
+	if err != nil {
+		logger.Println(q.region, "Failed to request an increase of the spot quota", code, err.Error())
+		return fmt.Sprintf("Requesting an increase of the quota to %.0f vCPUs failed: %s", value, err.Error())
+	}
+
+	result := fmt.Sprintf("Requested an increase of the quota to %.0f vCPUs", value)
+	if out.RequestedQuota != nil && out.RequestedQuota.Id != nil {
+		result += ", request " + *out.RequestedQuota.Id
+	}
+	return result
+}
+
+// suggestedQuotaValue returns the value a quota should be increased to, by
+// the given percentage or by 50% if not set, rounded up.
+func suggestedQuotaValue(limit, percentage float64) float64 {
+	if percentage <= 0 {
+		percentage = 50
+	}
+	return math.Ceil(limit * (1 + percentage/100))
+}
+
+// isSpotQuotaError returns true for the errors returned by RunInstances when
+// the spot quotas of the account were exceeded.
+func isSpotQuotaError(err error) bool {
+	return err != nil &&
+		(strings.Contains(err.Error(), "MaxSpotInstanceCountExceeded") ||
+			strings.Contains(err.Error(), "VcpuLimitExceeded"))
+}
+
+// release gives back the vCPUs reserved for a spot instance which failed to
+// launch.
+func (q *spotQuotas) release(instanceType string, vCPU int) {
+	if q == nil {
+		return
+	}
+
+	code := spotQuotaCode(instanceType)
+	if code == "" {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.usage[code]; ok {
+		q.usage[code] -= float64(vCPU)
+	}
+}
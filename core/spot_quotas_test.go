@@ -0,0 +1,262 @@
+package autospotting
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_spotQuotaCode(t *testing.T) {
+	tests := []struct {
+		instanceType string
+		want         string
+	}{
+		{instanceType: "m5.large", want: standardSpotQuota},
+		{instanceType: "t3a.micro", want: standardSpotQuota},
+		{instanceType: "z1d.large", want: standardSpotQuota},
+		{instanceType: "g4dn.xlarge", want: gSpotQuota},
+		{instanceType: "vt1.3xlarge", want: gSpotQuota},
+		{instanceType: "p3.2xlarge", want: pSpotQuota},
+		{instanceType: "x1e.xlarge", want: xSpotQuota},
+		{instanceType: "f1.2xlarge", want: fSpotQuota},
+		{instanceType: "inf1.xlarge", want: infSpotQuota},
+		{instanceType: "mac1.metal", want: ""},
+		{instanceType: "u-6tb1.metal", want: ""},
+		{instanceType: "", want: ""},
+	}
+	for _, tt := range tests {
+		if got := spotQuotaCode(tt.instanceType); got != tt.want {
+			t.Errorf("spotQuotaCode(%s) = %q, want %q", tt.instanceType, got, tt.want)
+		}
+	}
+}
+
+func Test_newSpotQuotas(t *testing.T) {
+	spot := &instance{
+		Instance: &ec2.Instance{
+			InstanceId:        aws.String("i-spot"),
+			InstanceType:      aws.String("m5.large"),
+			InstanceLifecycle: aws.String("spot"),
+		},
+		typeInfo: instanceTypeInformation{vCPU: 2},
+	}
+	onDemand := &instance{
+		Instance: &ec2.Instance{
+			InstanceId:   aws.String("i-ondemand"),
+			InstanceType: aws.String("m5.xlarge"),
+		},
+		typeInfo: instanceTypeInformation{vCPU: 4},
+	}
+	gpu := &instance{
+		Instance: &ec2.Instance{
+			InstanceId:        aws.String("i-gpu"),
+			InstanceType:      aws.String("p3.2xlarge"),
+			InstanceLifecycle: aws.String("spot"),
+		},
+		typeInfo: instanceTypeInformation{vCPU: 8},
+	}
+
+	r := &region{
+		name: "us-east-1",
+		conf: &Config{CheckSpotQuotas: true},
+		instances: makeInstancesWithCatalog(instanceMap{
+			"i-spot":     spot,
+			"i-ondemand": onDemand,
+			"i-gpu":      gpu,
+		}),
+		services: connections{serviceQuotas: &mockServiceQuotas{}},
+	}
+
+	q := r.newSpotQuotas()
+	if q == nil {
+		t.Fatal("expected the quotas to be tracked")
+	}
+	if q.usage[standardSpotQuota] != 2 || q.usage[pSpotQuota] != 8 {
+		t.Errorf("unexpected usage: %v", q.usage)
+	}
+
+	r.conf.CheckSpotQuotas = false
+	if r.newSpotQuotas() != nil {
+		t.Error("expected no quota tracking when disabled")
+	}
+
+	r.conf.CheckSpotQuotas = true
+	r.services.serviceQuotas = nil
+	if r.newSpotQuotas() != nil {
+		t.Error("expected no quota tracking without a Service Quotas client")
+	}
+}
+
+func Test_spotQuotas_reserve(t *testing.T) {
+	newQuotas := func(svc *mockServiceQuotas, used float64) (*spotQuotas, *[]string) {
+		var warnings []string
+		return &spotQuotas{
+			svc:       svc,
+			region:    "us-east-1",
+			threshold: 80,
+			limits:    make(map[string]float64),
+			usage:     map[string]float64{standardSpotQuota: used},
+			warned:    make(map[string]bool),
+			warn:      func(m string) { warnings = append(warnings, m) },
+		}, &warnings
+	}
+
+	t.Run("within the quota", func(t *testing.T) {
+		svc := &mockServiceQuotas{gsq: map[string]float64{standardSpotQuota: 100}}
+		q, warnings := newQuotas(svc, 10)
+
+		if !q.reserve("m5.large", 2) {
+			t.Fatal("expected the launch to be allowed")
+		}
+		if q.usage[standardSpotQuota] != 12 {
+			t.Errorf("expected 12 vCPUs used, got %v", q.usage[standardSpotQuota])
+		}
+		if len(*warnings) != 0 {
+			t.Errorf("unexpected warnings: %v", *warnings)
+		}
+
+		q.release("m5.large", 2)
+		if q.usage[standardSpotQuota] != 10 {
+			t.Errorf("expected 10 vCPUs used after release, got %v", q.usage[standardSpotQuota])
+		}
+
+		q.reserve("m5.large", 2)
+		if svc.calls != 1 {
+			t.Errorf("expected the quota to be fetched once, got %d calls", svc.calls)
+		}
+	})
+
+	t.Run("exceeding the quota", func(t *testing.T) {
+		svc := &mockServiceQuotas{gsq: map[string]float64{standardSpotQuota: 100}}
+		q, _ := newQuotas(svc, 98)
+
+		if q.reserve("m5.xlarge", 4) {
+			t.Fatal("expected the launch to be skipped")
+		}
+		if q.usage[standardSpotQuota] != 98 {
+			t.Errorf("usage changed on a skipped launch: %v", q.usage[standardSpotQuota])
+		}
+		if !q.reserve("m5.large", 2) {
+			t.Error("expected a smaller instance to still fit")
+		}
+	})
+
+	t.Run("crossing the warning threshold", func(t *testing.T) {
+		svc := &mockServiceQuotas{gsq: map[string]float64{standardSpotQuota: 100}}
+		q, warnings := newQuotas(svc, 76)
+
+		q.reserve("m5.xlarge", 4)
+		q.reserve("m5.xlarge", 4)
+		if len(*warnings) != 1 {
+			t.Errorf("expected a single warning, got %v", *warnings)
+		}
+	})
+
+	t.Run("reaching the quota", func(t *testing.T) {
+		svc := &mockServiceQuotas{gsq: map[string]float64{standardSpotQuota: 100}}
+		q, warnings := newQuotas(svc, 98)
+
+		q.reserve("m5.xlarge", 4)
+		if len(*warnings) != 1 || !strings.Contains((*warnings)[0], "increase of the quota to 150 vCPUs") {
+			t.Errorf("expected a warning suggesting an increase, got %v", *warnings)
+		}
+		if len(svc.rsqi) != 0 {
+			t.Error("unexpected quota increase request")
+		}
+	})
+
+	t.Run("requesting an increase", func(t *testing.T) {
+		svc := &mockServiceQuotas{gsq: map[string]float64{standardSpotQuota: 100}}
+		q, warnings := newQuotas(svc, 78)
+		q.increasePercentage = 20
+
+		q.reserve("m5.large", 2)
+		q.reserve("m5.large", 2)
+		if len(svc.rsqi) != 1 {
+			t.Fatalf("expected one increase request, got %d", len(svc.rsqi))
+		}
+		if *svc.rsqi[0].QuotaCode != standardSpotQuota || *svc.rsqi[0].DesiredValue != 120 {
+			t.Errorf("unexpected increase request: %v", svc.rsqi[0])
+		}
+		if len(*warnings) != 1 || !strings.Contains((*warnings)[0], "request-1") {
+			t.Errorf("expected the warning to mention the request, got %v", *warnings)
+		}
+	})
+
+	t.Run("unknown quota", func(t *testing.T) {
+		svc := &mockServiceQuotas{gsqerr: errors.New("AccessDeniedException")}
+		q, _ := newQuotas(svc, 1000)
+
+		if !q.reserve("m5.large", 2) {
+			t.Error("expected the launch to be allowed when the quota is unknown")
+		}
+		q.reserve("m5.large", 2)
+		if svc.calls != 1 {
+			t.Errorf("expected the failed lookup not to be retried, got %d calls", svc.calls)
+		}
+	})
+
+	t.Run("not tracked", func(t *testing.T) {
+		var q *spotQuotas
+		if !q.reserve("m5.large", 2) {
+			t.Error("expected launches to be allowed when quotas aren't tracked")
+		}
+		q.release("m5.large", 2)
+	})
+}
+
+func Test_spotQuotas_exhausted(t *testing.T) {
+	svc := &mockServiceQuotas{gsqerr: errors.New("AccessDeniedException")}
+	var warnings []string
+	q := &spotQuotas{
+		svc:    svc,
+		region: "us-east-1",
+		limits: make(map[string]float64),
+		usage:  map[string]float64{standardSpotQuota: 40},
+		warned: make(map[string]bool),
+		warn:   func(m string) { warnings = append(warnings, m) },
+	}
+
+	q.exhausted("m5.large")
+	if q.reserve("c5.large", 2) {
+		t.Error("expected no further launches against an exhausted quota")
+	}
+	if !q.reserve("p3.2xlarge", 8) {
+		t.Error("expected launches against other quotas to be allowed")
+	}
+	if len(warnings) != 1 {
+		t.Errorf("expected a single warning, got %v", warnings)
+	}
+
+	var untracked *spotQuotas
+	untracked.exhausted("m5.large")
+}
+
+func Test_isSpotQuotaError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: nil, want: false},
+		{err: errors.New("MaxSpotInstanceCountExceeded: Max spot instance count exceeded"), want: true},
+		{err: errors.New("VcpuLimitExceeded: You have requested more vCPU capacity"), want: true},
+		{err: errors.New("InsufficientInstanceCapacity"), want: false},
+	}
+	for _, tt := range tests {
+		if got := isSpotQuotaError(tt.err); got != tt.want {
+			t.Errorf("isSpotQuotaError(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
+
+func Test_suggestedQuotaValue(t *testing.T) {
+	if got := suggestedQuotaValue(100, 0); got != 150 {
+		t.Errorf("expected 150, got %v", got)
+	}
+	if got := suggestedQuotaValue(5, 10); got != 6 {
+		t.Errorf("expected 6, got %v", got)
+	}
+}
@@ -0,0 +1,124 @@
+package autospotting
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+)
+
+// unfinishedSpotRequestStates are the states of the spot requests which may
+// still launch instances, persistent requests being disabled while their
+// instance is stopped.
+var unfinishedSpotRequestStates = []*string{
+	aws.String(ec2.SpotInstanceStateOpen),
+	aws.String(ec2.SpotInstanceStateActive),
+	aws.String("disabled"),
+}
+
+// spotMarketOptions returns the market options of the spot instance launched
+// with the given maximum price. Persistent requests stop the instance when
+// it's interrupted and start it again once capacity is available, since they
+// can't terminate it.
+func (i *instance) spotMarketOptions(price float64) *ec2.InstanceMarketOptionsRequest {
+	options := &ec2.SpotMarketOptions{
+		MaxPrice: aws.String(strconv.FormatFloat(price, 'g', 10, 64)),
+	}
+
+	if i.asg.config.SpotRequestType == PersistentSpotRequestType {
+		options.SpotInstanceType = aws.String(ec2.SpotInstanceTypePersistent)
+		options.InstanceInterruptionBehavior = aws.String(ec2.InstanceInterruptionBehaviorStop)
+	}
+
+	return &ec2.InstanceMarketOptionsRequest{
+		MarketType:  aws.String(ec2.MarketTypeSpot),
+		SpotOptions: options,
+	}
+}
+
+// cancelPersistentSpotRequest cancels the persistent spot request which
+// launched the given instance, if any, so that it doesn't launch another
+// instance outside the group once this one is terminated or detached.
+func cancelPersistentSpotRequest(svc ec2iface.EC2API, instanceID *string) error {
+	out, err := svc.DescribeSpotInstanceRequests(&ec2.DescribeSpotInstanceRequestsInput{
+		Filters: []*ec2.Filter{
+			{Name: aws.String("instance-id"), Values: []*string{instanceID}},
+			{Name: aws.String("type"), Values: []*string{aws.String(ec2.SpotInstanceTypePersistent)}},
+			{Name: aws.String("state"), Values: unfinishedSpotRequestStates},
+		},
+	})
+	if err != nil {
+		logger.Println("Failed to describe the spot request of", *instanceID, err.Error())
+		return err
+	}
+	if out == nil || len(out.SpotInstanceRequests) == 0 {
+		return nil
+	}
+
+	var ids []*string
+	for _, req := range out.SpotInstanceRequests {
+		ids = append(ids, req.SpotInstanceRequestId)
+	}
+
+	logger.Println("Cancelling persistent spot request", strings.Join(aws.StringValueSlice(ids), " "),
+		"of", *instanceID)
+	if _, err := svc.CancelSpotInstanceRequests(&ec2.CancelSpotInstanceRequestsInput{
+		SpotInstanceRequestIds: ids,
+	}); err != nil {
+		logger.Println("Failed to cancel the spot request of", *instanceID, err.Error())
+		return err
+	}
+	return nil
+}
+
+// cancelUnmanagedSpotRequests cancels the persistent spot requests launched
+// by AutoSpotting which would otherwise launch instances outside of their
+// groups: those whose instance was terminated, for example by a scale-in
+// activity, and those of the groups which are no longer enabled.
+func (r *region) cancelUnmanagedSpotRequests() []string {
+	if r.taggedASGs == nil {
+		return nil
+	}
+
+	requests, err := r.describeOwnedSpotRequests([]*ec2.Filter{
+		{Name: aws.String("type"), Values: []*string{aws.String(ec2.SpotInstanceTypePersistent)}},
+		{Name: aws.String("state"), Values: unfinishedSpotRequestStates},
+	})
+	if err != nil {
+		logger.Println(r.name, "Failed to describe spot requests:", err.Error())
+		return nil
+	}
+
+	ownership := r.conf.Ownership()
+	var ids []*string
+	for _, req := range requests {
+		_, asgName := ownership.owner(req.Tags)
+
+		switch {
+		case !r.taggedASGs[asgName]:
+			logger.Println(r.name, "Spot request", *req.SpotInstanceRequestId,
+				"belongs to group", asgName, "which is no longer enabled")
+		case aws.StringValue(req.State) == ec2.SpotInstanceStateOpen:
+			logger.Println(r.name, "Spot request", *req.SpotInstanceRequestId,
+				"of group", asgName, "would replace its terminated instance",
+				aws.StringValue(req.InstanceId))
+		default:
+			continue
+		}
+		ids = append(ids, req.SpotInstanceRequestId)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	logger.Println(r.name, "Cancelling persistent spot requests", strings.Join(aws.StringValueSlice(ids), " "))
+	if _, err := r.services.ec2.CancelSpotInstanceRequests(&ec2.CancelSpotInstanceRequestsInput{
+		SpotInstanceRequestIds: ids,
+	}); err != nil {
+		logger.Println(r.name, "Failed to cancel spot requests:", err.Error())
+		return nil
+	}
+	return aws.StringValueSlice(ids)
+}
@@ -0,0 +1,114 @@
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/AutoSpotting/AutoSpotting/core/autospottingtest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestSpotMarketOptions(t *testing.T) {
+	i := &instance{asg: &autoScalingGroup{}}
+
+	got := i.spotMarketOptions(0.5)
+	if *got.MarketType != ec2.MarketTypeSpot || *got.SpotOptions.MaxPrice != "0.5" ||
+		got.SpotOptions.SpotInstanceType != nil {
+		t.Errorf("unexpected one-time market options %v", got)
+	}
+
+	i.asg.config.SpotRequestType = PersistentSpotRequestType
+	got = i.spotMarketOptions(0.5)
+	if aws.StringValue(got.SpotOptions.SpotInstanceType) != ec2.SpotInstanceTypePersistent ||
+		aws.StringValue(got.SpotOptions.InstanceInterruptionBehavior) != ec2.InstanceInterruptionBehaviorStop {
+		t.Errorf("unexpected persistent market options %v", got)
+	}
+}
+
+func TestCancelPersistentSpotRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		dsiro   *ec2.DescribeSpotInstanceRequestsOutput
+		dsirerr error
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:  "no persistent request",
+			dsiro: &ec2.DescribeSpotInstanceRequestsOutput{},
+		},
+		{
+			name: "persistent request",
+			dsiro: &ec2.DescribeSpotInstanceRequestsOutput{SpotInstanceRequests: []*ec2.SpotInstanceRequest{
+				{SpotInstanceRequestId: aws.String("sir-1")},
+			}},
+			want: []string{"sir-1"},
+		},
+		{
+			name:    "describe error",
+			dsirerr: errors.New("denied"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var cancelled []string
+			svc := mockEC2{dsiro: tt.dsiro, dsirerr: tt.dsirerr, csir: &cancelled}
+			err := cancelPersistentSpotRequest(svc, aws.String("i-1"))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("cancelPersistentSpotRequest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(cancelled, tt.want) {
+				t.Errorf("cancelled %v, want %v", cancelled, tt.want)
+			}
+		})
+	}
+}
+
+func TestCancelUnmanagedSpotRequests(t *testing.T) {
+	cloud := autospottingtest.NewCloud()
+	fr := cloud.Region("us-east-1")
+
+	request := func(id, asg, state, requestType string) {
+		fr.AddSpotInstanceRequest(&ec2.SpotInstanceRequest{
+			SpotInstanceRequestId: aws.String(id),
+			InstanceId:            aws.String("i-" + id),
+			State:                 aws.String(state),
+			Type:                  aws.String(requestType),
+			Tags: []*ec2.Tag{
+				{Key: aws.String(launchedByTag), Value: aws.String("true")},
+				{Key: aws.String(launchedForASGTag), Value: aws.String(asg)},
+			},
+		})
+	}
+	request("sir-running", "asg", ec2.SpotInstanceStateActive, ec2.SpotInstanceTypePersistent)
+	request("sir-stopped", "asg", "disabled", ec2.SpotInstanceTypePersistent)
+	request("sir-terminated", "asg", ec2.SpotInstanceStateOpen, ec2.SpotInstanceTypePersistent)
+	request("sir-untagged", "other", ec2.SpotInstanceStateActive, ec2.SpotInstanceTypePersistent)
+	request("sir-one-time", "other", ec2.SpotInstanceStateActive, ec2.SpotInstanceTypeOneTime)
+
+	r := &region{
+		name:     "us-east-1",
+		services: connections{ec2: cloud.EC2("us-east-1")},
+	}
+	if got := r.cancelUnmanagedSpotRequests(); got != nil {
+		t.Errorf("expected nothing to be cancelled without the enabled groups, got %v", got)
+	}
+
+	r.taggedASGs = map[string]bool{"asg": true}
+	got := r.cancelUnmanagedSpotRequests()
+	if want := []string{"sir-terminated", "sir-untagged"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("cancelUnmanagedSpotRequests() = %v, want %v", got, want)
+	}
+	for id, want := range map[string]string{
+		"sir-running":    ec2.SpotInstanceStateActive,
+		"sir-terminated": ec2.SpotInstanceStateCancelled,
+		"sir-one-time":   ec2.SpotInstanceStateActive,
+	} {
+		if got := *fr.SpotInstanceRequest(id).State; got != want {
+			t.Errorf("spot request %s is %s, want %s", id, got, want)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package autospotting
 import (
 	"encoding/json"
 	"errors"
+	"strings"
 
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/aws"
@@ -11,6 +12,7 @@ import (
 	"github.com/aws/aws-sdk-go/service/autoscaling/autoscalingiface"
 	"github.com/aws/aws-sdk-go/service/ec2"
 	"github.com/aws/aws-sdk-go/service/ec2/ec2iface"
+	"github.com/aws/aws-sdk-go/service/sqs/sqsiface"
 )
 
 const (
@@ -21,8 +23,21 @@ const (
 
 //SpotTermination is used to detach an instance, used when a spot instance is due for termination
 type SpotTermination struct {
-	asSvc  autoscalingiface.AutoScalingAPI
-	ec2Svc ec2iface.EC2API
+	region    string
+	session   *session.Session
+	asSvc     autoscalingiface.AutoScalingAPI
+	ec2Svc    ec2iface.EC2API
+	ownership Ownership
+
+	// takes the lock of the group, created from the configuration when nil
+	locker *groupLocker
+
+	// sends the events to the EventQueueURL, created from the configuration
+	// when nil
+	sqsSvc sqsiface.SQSAPI
+
+	// records the spot interruptions, created from the configuration when nil
+	interruptions interruptionStore
 }
 
 //InstanceData represents JSON structure of the Detail property of CloudWatch event when a spot instance is terminated
@@ -37,13 +52,34 @@ func NewSpotTermination(region string) SpotTermination {
 
 	logger.Println("Connection to region ", region)
 
-	session := session.Must(
-		session.NewSession(&aws.Config{Region: aws.String(region)}))
+	session := traceAPICalls(session.Must(
+		session.NewSession(&aws.Config{Region: aws.String(region)})))
 
 	return SpotTermination{
+		region:    region,
+		session:   session,
+		asSvc:     autoscaling.New(session),
+		ec2Svc:    ec2.New(session),
+		ownership: defaultOwnership,
+	}
+}
 
-		asSvc:  autoscaling.New(session),
-		ec2Svc: ec2.New(session),
+// SetOwnership sets the tags identifying the spot instances launched by
+// AutoSpotting, whose group tags are deleted when detaching them.
+func (s *SpotTermination) SetOwnership(o Ownership) {
+	s.ownership = o
+}
+
+// DrainNode drains the spot instance from its Kubernetes or ECS cluster
+// before the termination notification action, when it's a node or container
+// instance drained according to the configuration.
+func (s *SpotTermination) DrainNode(cfg *Config, instanceID *string) {
+	if cfg == nil || (!cfg.DrainKubernetesNodes && !cfg.DrainECSContainerInstances) {
+		return
+	}
+	if i := s.describeInstance(instanceID); i != nil {
+		drainKubernetesNode(s.session, cfg, i)
+		drainECSContainerInstance(s.session, cfg, i)
 	}
 }
 
@@ -64,6 +100,23 @@ func GetInstanceIDDueForTermination(event events.CloudWatchEvent) (*string, erro
 	return nil, nil
 }
 
+// GetInstanceIDWithRebalanceRecommendation returns the ID of the instance
+// from the given rebalance recommendation CloudWatch event.
+func GetInstanceIDWithRebalanceRecommendation(event events.CloudWatchEvent) (*string, error) {
+
+	var detailData instanceData
+	if err := json.Unmarshal(event.Detail, &detailData); err != nil {
+		logger.Println(err.Error())
+		return nil, err
+	}
+
+	if detailData.InstanceID != "" {
+		return &detailData.InstanceID, nil
+	}
+
+	return nil, nil
+}
+
 //DetachInstance detaches the instance from autoscaling group without decrementing the desired capacity
 //This makes sure that the autoscaling group spawns a new instance as soon as this instance is detached
 func (s *SpotTermination) detachInstance(instanceID *string, asgName string) error {
@@ -86,6 +139,10 @@ func (s *SpotTermination) detachInstance(instanceID *string, asgName string) err
 
 	logger.Printf("Detached instance %s successfully", *instanceID)
 
+	if s.ec2Svc != nil {
+		cancelPersistentSpotRequest(s.ec2Svc, instanceID)
+	}
+
 	s.deleteTagInstanceLaunchedForAsg(instanceID)
 
 	return nil
@@ -99,6 +156,11 @@ func (s *SpotTermination) terminateInstance(instanceID *string, asgName string)
 	logger.Println(asgName,
 		"Terminating instance:",
 		*instanceID)
+	// the persistent spot request would otherwise launch a new instance
+	if s.ec2Svc != nil {
+		cancelPersistentSpotRequest(s.ec2Svc, instanceID)
+	}
+
 	// terminate the spot instance
 	terminateParams := autoscaling.TerminateInstanceInAutoScalingGroupInput{
 		InstanceId:                     instanceID,
@@ -141,41 +203,58 @@ func (s *SpotTermination) ExecuteAction(instanceID *string, terminationNotificat
 		return err
 	}
 
-	switch terminationNotificationAction {
-	case "detach":
+	switch s.chooseAction(asgName, terminationNotificationAction) {
+	case DetachTerminationNotificationAction:
 		s.detachInstance(instanceID, asgName)
-	case "terminate":
+	default:
 		s.terminateInstance(instanceID, asgName)
+	}
+
+	return nil
+}
+
+// chooseAction resolves the configured termination notification action to
+// the actual action(terminate|detach) taken for the given group.
+func (s *SpotTermination) chooseAction(asgName string, terminationNotificationAction string) string {
+	switch terminationNotificationAction {
+	case DetachTerminationNotificationAction:
+		return DetachTerminationNotificationAction
+	case TerminateTerminationNotificationAction:
+		return TerminateTerminationNotificationAction
 	default:
 		if s.asgHasTerminationLifecycleHook(&asgName) {
-			s.terminateInstance(instanceID, asgName)
-		} else {
-			s.detachInstance(instanceID, asgName)
+			return TerminateTerminationNotificationAction
 		}
+		return DetachTerminationNotificationAction
 	}
-
-	return nil
 }
 
 func (s *SpotTermination) deleteTagInstanceLaunchedForAsg(instanceID *string) error {
+	ownership := s.ownership
+	if ownership.Tag.Key == "" {
+		ownership = defaultOwnership
+	}
+
+	var tags []*ec2.Tag
+	for _, key := range ownership.groupKeys() {
+		tags = append(tags, &ec2.Tag{Key: aws.String(key)})
+	}
+	keys := strings.Join(ownership.groupKeys(), "', '")
+
 	ec2Params := ec2.DeleteTagsInput{
 		Resources: []*string{
 			aws.String(*instanceID),
 		},
-		Tags: []*ec2.Tag{
-			{
-				Key: aws.String("launched-for-asg"),
-			},
-		},
+		Tags: tags,
 	}
 	_, err := s.ec2Svc.DeleteTags(&ec2Params)
 
 	if err != nil {
-		logger.Printf("Failed to delete Tag 'launched-for-asg' from spot instance %s with err: %s\n", *instanceID, err.Error())
+		logger.Printf("Failed to delete Tag '%s' from spot instance %s with err: %s\n", keys, *instanceID, err.Error())
 		return err
 	}
 
-	logger.Printf("Tag 'launched-for-asg' deleted from spot instance %s", *instanceID)
+	logger.Printf("Tag '%s' deleted from spot instance %s", keys, *instanceID)
 
 	return nil
 }
@@ -76,6 +76,50 @@ func TestGetInstanceIDDueForTermination(t *testing.T) {
 	}
 }
 
+func TestGetInstanceIDWithRebalanceRecommendation(t *testing.T) {
+
+	expectedInstanceID := "i-123456"
+
+	tests := []struct {
+		name            string
+		cloudWatchEvent events.CloudWatchEvent
+		expected        *string
+	}{
+		{
+			name: "Invalid Detail in CloudWatch event",
+			cloudWatchEvent: events.CloudWatchEvent{
+				Detail: []byte(""),
+			},
+		},
+		{
+			name: "Detail in event is empty",
+			cloudWatchEvent: events.CloudWatchEvent{
+				Detail: []byte("{}"),
+			},
+		},
+		{
+			name: "Detail has rebalance recommendation data",
+			cloudWatchEvent: events.CloudWatchEvent{
+				Detail: []byte(`{"instance-id": "i-123456"}`),
+			},
+			expected: &expectedInstanceID,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			instanceID, _ := GetInstanceIDWithRebalanceRecommendation(tc.cloudWatchEvent)
+
+			if tc.expected == nil && instanceID != nil {
+				t.Errorf("Expected nil instanceID, actual: %s", *instanceID)
+			}
+
+			if tc.expected != nil && (instanceID == nil || *tc.expected != *instanceID) {
+				t.Errorf("InstanceID expected: %v\nactual: %v", tc.expected, instanceID)
+			}
+		})
+	}
+}
 func TestDetachInstance(t *testing.T) {
 
 	asgName := "dummyASGName"
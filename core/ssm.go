@@ -0,0 +1,157 @@
+package autospotting
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+const (
+	// readinessCommandTag stores on a spot instance the ID of the SSM command
+	// running its readiness document, so that the next runs check its outcome
+	// instead of sending it again.
+	readinessCommandTag = "autospotting-readiness-command"
+
+	// cloudInitCommandTag stores on a spot instance the ID of the SSM command
+	// waiting for cloud-init to be done.
+	cloudInitCommandTag = "autospotting-cloud-init-command"
+)
+
+// isRegisteredInSSM returns true if the instance is Online in SSM, or if its
+// group doesn't require it.
+func (i *instance) isRegisteredInSSM(asg *autoScalingGroup) bool {
+	if !asg.config.WaitForSSM {
+		return true
+	}
+
+	svc := i.region.services.ssm
+	if svc == nil {
+		logger.Println(asg.name, "No SSM client available, not waiting for",
+			*i.InstanceId, "to register in SSM")
+		return true
+	}
+
+	out, err := svc.DescribeInstanceInformation(&ssm.DescribeInstanceInformationInput{
+		Filters: []*ssm.InstanceInformationStringFilter{{
+			Key:    aws.String("InstanceIds"),
+			Values: []*string{i.InstanceId},
+		}},
+	})
+	if err != nil {
+		logger.Println(asg.name, "Failed to get the SSM status of", *i.InstanceId, err.Error())
+		return false
+	}
+
+	for _, info := range out.InstanceInformationList {
+		if aws.StringValue(info.InstanceId) == *i.InstanceId &&
+			aws.StringValue(info.PingStatus) == ssm.PingStatusOnline {
+			logger.Println(asg.name, "The spot instance", *i.InstanceId, "is Online in SSM")
+			return true
+		}
+	}
+
+	logger.Println(asg.name, "The spot instance", *i.InstanceId, "isn't Online in SSM yet")
+	return false
+}
+
+// isCloudInitDone returns true once cloud-init is done on the instance, or if
+// its group doesn't require it. The check runs 'cloud-init status --wait' over
+// SSM, which fails if cloud-init reported errors.
+func (i *instance) isCloudInitDone(asg *autoScalingGroup) bool {
+	if !asg.config.WaitForCloudInit {
+		return true
+	}
+	return i.ssmCommandSucceeded(asg, cloudInitCommandTag, "AWS-RunShellScript",
+		map[string][]*string{
+			"commands": {aws.String("cloud-init status --wait")},
+		})
+}
+
+// ssmCommandSucceeded returns true once the given SSM document succeeded on
+// the instance. The document is sent on the first call and the ID of the
+// command is stored in the stateTag of the instance, later calls check its
+// outcome and send it again if it failed.
+func (i *instance) ssmCommandSucceeded(asg *autoScalingGroup, stateTag, document string,
+	parameters map[string][]*string) bool {
+
+	svc := i.region.services.ssm
+	if svc == nil {
+		logger.Println(asg.name, "No SSM client available, not running", document,
+			"on", *i.InstanceId)
+		return true
+	}
+
+	commandID := i.getTagValue(stateTag)
+	if commandID == "" {
+		i.sendSSMCommand(asg, stateTag, document, parameters)
+		return false
+	}
+
+	out, err := svc.GetCommandInvocation(&ssm.GetCommandInvocationInput{
+		CommandId:  aws.String(commandID),
+		InstanceId: i.InstanceId,
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeInvocationDoesNotExist {
+			logger.Println(asg.name, "The", document, "command", commandID,
+				"wasn't found on", *i.InstanceId, "sending it again")
+			i.sendSSMCommand(asg, stateTag, document, parameters)
+			return false
+		}
+		logger.Println(asg.name, "Failed to get the outcome of the", document,
+			"command", commandID, "on", *i.InstanceId, err.Error())
+		return false
+	}
+
+	switch status := aws.StringValue(out.Status); status {
+	case ssm.CommandInvocationStatusSuccess:
+		logger.Println(asg.name, "The", document, "command succeeded on", *i.InstanceId)
+		return true
+	case ssm.CommandInvocationStatusPending,
+		ssm.CommandInvocationStatusInProgress,
+		ssm.CommandInvocationStatusDelayed:
+		logger.Println(asg.name, "The", document, "command is still", status, "on", *i.InstanceId)
+		return false
+	default:
+		logger.Printf("%s The %s command %s on %s with exit code %d: %s\n",
+			asg.name, document, status, *i.InstanceId, aws.Int64Value(out.ResponseCode),
+			strings.TrimSpace(aws.StringValue(out.StandardErrorContent)))
+		i.sendSSMCommand(asg, stateTag, document, parameters)
+		return false
+	}
+}
+
+// sendSSMCommand runs the given SSM document on the instance and stores the
+// ID of the command in the stateTag of the instance.
+func (i *instance) sendSSMCommand(asg *autoScalingGroup, stateTag, document string,
+	parameters map[string][]*string) {
+
+	out, err := i.region.services.ssm.SendCommand(&ssm.SendCommandInput{
+		Comment:      aws.String("AutoSpotting readiness check for " + asg.name),
+		DocumentName: aws.String(document),
+		InstanceIds:  []*string{i.InstanceId},
+		Parameters:   parameters,
+	})
+	if err != nil {
+		logger.Println(asg.name, "Failed to run", document, "on", *i.InstanceId, err.Error())
+		return
+	}
+
+	commandID := out.Command.CommandId
+	logger.Println(asg.name, "Running", document, "on", *i.InstanceId,
+		"as command", *commandID)
+
+	tag := &ec2.Tag{Key: aws.String(stateTag), Value: commandID}
+	if _, err := i.region.services.ec2.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{i.InstanceId},
+		Tags:      []*ec2.Tag{tag},
+	}); err != nil {
+		logger.Println(asg.name, "Failed to tag", *i.InstanceId, "with the command",
+			*commandID, err.Error())
+		return
+	}
+	i.setTag(tag)
+}
@@ -0,0 +1,189 @@
+package autospotting
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+func TestInstanceIsRegisteredInSSM(t *testing.T) {
+	online := &ssm.DescribeInstanceInformationOutput{
+		InstanceInformationList: []*ssm.InstanceInformation{
+			{InstanceId: aws.String("i-spot"), PingStatus: aws.String(ssm.PingStatusOnline)},
+		},
+	}
+	lost := &ssm.DescribeInstanceInformationOutput{
+		InstanceInformationList: []*ssm.InstanceInformation{
+			{InstanceId: aws.String("i-spot"), PingStatus: aws.String(ssm.PingStatusConnectionLost)},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		waitForSSM bool
+		ssm        *mockSSM
+		want       bool
+	}{
+		{
+			name: "not required",
+			ssm:  &mockSSM{diierr: errors.New("unexpected call")},
+			want: true,
+		},
+		{
+			name:       "online",
+			waitForSSM: true,
+			ssm:        &mockSSM{dii: online},
+			want:       true,
+		},
+		{
+			name:       "not registered yet",
+			waitForSSM: true,
+			ssm:        &mockSSM{dii: &ssm.DescribeInstanceInformationOutput{}},
+			want:       false,
+		},
+		{
+			name:       "connection lost",
+			waitForSSM: true,
+			ssm:        &mockSSM{dii: lost},
+			want:       false,
+		},
+		{
+			name:       "error",
+			waitForSSM: true,
+			ssm:        &mockSSM{diierr: errors.New("AccessDenied")},
+			want:       false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := &instance{
+				Instance: &ec2.Instance{InstanceId: aws.String("i-spot")},
+				region:   &region{services: connections{ssm: tt.ssm}},
+			}
+			asg := &autoScalingGroup{name: "asg", config: AutoScalingConfig{WaitForSSM: tt.waitForSSM}}
+
+			if got := i.isRegisteredInSSM(asg); got != tt.want {
+				t.Errorf("isRegisteredInSSM() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+
+	noClient := &instance{
+		Instance: &ec2.Instance{InstanceId: aws.String("i-spot")},
+		region:   &region{},
+	}
+	if !noClient.isRegisteredInSSM(&autoScalingGroup{name: "asg", config: AutoScalingConfig{WaitForSSM: true}}) {
+		t.Error("expected the check to be skipped without SSM client")
+	}
+}
+
+func TestInstancePassesReadinessDocument(t *testing.T) {
+	group := newTestGroup(map[string]string{
+		ReadinessDocumentTag:           "AWS-RunShellScript",
+		ReadinessDocumentParametersTag: `{"commands":["/opt/app/check.sh"]}`,
+	})
+
+	newInstance := func(svc *mockSSM, tags ...*ec2.Tag) *instance {
+		return &instance{
+			Instance: &ec2.Instance{InstanceId: aws.String("i-spot"), Tags: tags},
+			region: &region{services: connections{
+				ec2: mockEC2{},
+				ssm: svc,
+			}},
+		}
+	}
+	sent := &ec2.Tag{Key: aws.String(readinessCommandTag), Value: aws.String("command-0")}
+
+	t.Run("no document configured", func(t *testing.T) {
+		svc := &mockSSM{}
+		if !newInstance(svc).passesReadinessDocument(newTestGroup(nil)) || len(svc.sc) != 0 {
+			t.Error("expected the check to be skipped")
+		}
+	})
+
+	t.Run("sent on the first check", func(t *testing.T) {
+		svc := &mockSSM{}
+		i := newInstance(svc)
+		if i.passesReadinessDocument(group) {
+			t.Error("expected the instance not to be ready before the command completes")
+		}
+		if len(svc.sc) != 1 || *svc.sc[0].DocumentName != "AWS-RunShellScript" ||
+			*svc.sc[0].Parameters["commands"][0] != "/opt/app/check.sh" {
+			t.Errorf("unexpected commands sent: %v", svc.sc)
+		}
+		if got := i.getTagValue(readinessCommandTag); got != "command-1" {
+			t.Errorf("the command ID wasn't recorded on the instance: %q", got)
+		}
+	})
+
+	tests := []struct {
+		name      string
+		status    string
+		err       error
+		want      bool
+		sentAgain bool
+	}{
+		{name: "succeeded", status: ssm.CommandInvocationStatusSuccess, want: true},
+		{name: "in progress", status: ssm.CommandInvocationStatusInProgress},
+		{name: "failed", status: ssm.CommandInvocationStatusFailed, sentAgain: true},
+		{name: "timed out", status: ssm.CommandInvocationStatusTimedOut, sentAgain: true},
+		{
+			name:      "unknown command",
+			err:       awserr.New(ssm.ErrCodeInvocationDoesNotExist, "not found", nil),
+			sentAgain: true,
+		},
+		{name: "API error", err: errors.New("Throttling")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &mockSSM{
+				gci: &ssm.GetCommandInvocationOutput{
+					Status:       aws.String(tt.status),
+					ResponseCode: aws.Int64(1),
+				},
+				gcierr: tt.err,
+			}
+			if got := newInstance(svc, sent).passesReadinessDocument(group); got != tt.want {
+				t.Errorf("passesReadinessDocument() = %t, want %t", got, tt.want)
+			}
+			if (len(svc.sc) > 0) != tt.sentAgain {
+				t.Errorf("command sent again: %t, expected %t", len(svc.sc) > 0, tt.sentAgain)
+			}
+		})
+	}
+}
+
+func TestInstanceIsCloudInitDone(t *testing.T) {
+	newInstance := func(svc *mockSSM) *instance {
+		return &instance{
+			Instance: &ec2.Instance{InstanceId: aws.String("i-spot")},
+			region: &region{services: connections{
+				ec2: mockEC2{},
+				ssm: svc,
+			}},
+		}
+	}
+
+	svc := &mockSSM{}
+	if !newInstance(svc).isCloudInitDone(&autoScalingGroup{name: "asg"}) || len(svc.sc) != 0 {
+		t.Error("expected the check to be skipped when not required")
+	}
+
+	asg := &autoScalingGroup{name: "asg", config: AutoScalingConfig{WaitForCloudInit: true}}
+	i := newInstance(svc)
+	if i.isCloudInitDone(asg) {
+		t.Error("expected cloud-init not to be done before the command completes")
+	}
+	if len(svc.sc) != 1 || *svc.sc[0].Parameters["commands"][0] != "cloud-init status --wait" {
+		t.Errorf("unexpected commands sent: %v", svc.sc)
+	}
+
+	svc.gci = &ssm.GetCommandInvocationOutput{Status: aws.String(ssm.CommandInvocationStatusSuccess)}
+	if !i.isCloudInitDone(asg) {
+		t.Error("expected cloud-init to be done once the command succeeded")
+	}
+}
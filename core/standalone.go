@@ -0,0 +1,442 @@
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// StandaloneTag is the name of the tag which, when set to true, opts in the
+// standalone EC2 instances, which aren't part of any AutoScaling group, for
+// being replaced with spot instances when StandaloneInstanceSupport is enabled.
+const StandaloneTag = "autospotting-standalone"
+
+// autoScalingGroupNameTag is set by AutoScaling on the members of the groups
+const autoScalingGroupNameTag = "aws:autoscaling:groupName"
+
+// isStandalone tells if the instance is a running on-demand instance opted in
+// by its StandaloneTag, which isn't part of any AutoScaling group and keeps
+// all its data on EBS volumes.
+func (i *instance) isStandalone() bool {
+	var optedIn bool
+	for _, tag := range i.Tags {
+		switch aws.StringValue(tag.Key) {
+		case autoScalingGroupNameTag:
+			return false
+		case StandaloneTag:
+			optedIn = strings.EqualFold(strings.TrimSpace(aws.StringValue(tag.Value)), "true")
+		}
+	}
+	return optedIn &&
+		aws.StringValue(i.State.Name) == ec2.InstanceStateNameRunning &&
+		!i.isSpot() &&
+		aws.StringValue(i.RootDeviceType) == ec2.DeviceTypeEbs &&
+		len(i.NetworkInterfaces) > 0
+}
+
+// standaloneInstances returns the standalone instances of the region which
+// can be replaced with spot instances, sorted by ID.
+func (r *region) standaloneInstances() []*instance {
+	var standalone []*instance
+	for i := range r.instances.instances() {
+		if i.isStandalone() && !i.isYoungerThan(r.conf.MinInstanceUptime) {
+			standalone = append(standalone, i)
+		}
+	}
+	sort.Slice(standalone, func(x, y int) bool {
+		return *standalone[x].InstanceId < *standalone[y].InstanceId
+	})
+	return standalone
+}
+
+// processStandaloneInstances replaces the standalone instances opted in by
+// their StandaloneTag with spot instances, within the cron schedule and the
+// replacements allowed on this run.
+func (r *region) processStandaloneInstances() {
+	if !r.conf.StandaloneInstanceSupport {
+		return
+	}
+
+	if !cronRunAction(time.Now(), r.conf.CronSchedule, r.conf.CronTimezone, r.conf.CronScheduleState) {
+		logger.Println(r.name, "Skipping the standalone instances, outside the enabled cron run schedule")
+		return
+	}
+
+	for _, i := range r.standaloneInstances() {
+		if !r.conf.replacements.take() {
+			logger.Println(r.name, "Leaving the standalone instances for the next run,",
+				"reached the maximum number of replacements of this run")
+			return
+		}
+		if err := i.replaceStandalone(); err != nil {
+			logger.Println(r.name, "Couldn't replace the standalone instance", *i.InstanceId, err.Error())
+		}
+	}
+}
+
+// replaceStandalone replaces the standalone on-demand instance with a spot
+// instance of the cheapest compatible type, which keeps its network
+// interfaces, and therefore its private and Elastic IP addresses, its data
+// volumes and a copy of its root volume. The on-demand instance is stopped, an
+// AMI of its root volume is created, then it's terminated while keeping its
+// network interfaces and data volumes, which are given to the spot instance
+// launched from the AMI. If no spot instance can be launched, an on-demand
+// instance of the original type is launched instead. The spot instance is
+// launched by a persistent spot request, so that it's stopped instead of
+// terminated when interrupted.
+func (i *instance) replaceStandalone() error {
+	r := i.region
+	svc := r.services.ec2
+
+	i.price = i.typeInfo.pricing.onDemand
+	i.asg = &autoScalingGroup{Group: &autoscaling.Group{}, name: *i.InstanceId, region: r, config: r.conf.AutoScalingConfig}
+	i.asg.config.SpotRequestType = PersistentSpotRequestType
+
+	if i.isProtectedFromTermination() {
+		return errors.New("the instance is protected from termination")
+	}
+
+	instanceTypes, err := i.getCompatibleSpotInstanceTypesListSortedAscendingByPrice(
+		i.asg.getAllowedInstanceTypes(i), i.asg.getDisallowedInstanceTypes())
+	if err != nil {
+		return err
+	}
+
+	input, err := i.standaloneRunInstancesInput()
+	if err != nil {
+		return err
+	}
+	addresses, err := svc.DescribeAddresses(&ec2.DescribeAddressesInput{
+		Filters: []*ec2.Filter{{Name: aws.String("instance-id"), Values: []*string{i.InstanceId}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Println(r.name, "Stopping the standalone instance", *i.InstanceId, "to replace it with a spot instance")
+	if _, err := svc.StopInstances(&ec2.StopInstancesInput{InstanceIds: []*string{i.InstanceId}}); err != nil {
+		if isAuditModeError(err) {
+			logger.Println(r.name, "Would replace the standalone instance", *i.InstanceId, "of type",
+				*i.InstanceType, "with a spot instance of type", instanceTypes[0].instanceType)
+		}
+		return err
+	}
+	if err := svc.WaitUntilInstanceStopped(&ec2.DescribeInstancesInput{InstanceIds: []*string{i.InstanceId}}); err != nil {
+		return i.restartStandalone(err)
+	}
+
+	imageID, err := i.createStandaloneImage()
+	if err != nil {
+		return i.restartStandalone(err)
+	}
+	input.ImageId = imageID
+
+	if err := i.keepStandaloneResources(); err != nil {
+		i.deleteStandaloneImage(imageID)
+		return i.restartStandalone(err)
+	}
+
+	logger.Println(r.name, "Terminating the standalone instance", *i.InstanceId)
+	if _, err := svc.TerminateInstances(&ec2.TerminateInstancesInput{InstanceIds: []*string{i.InstanceId}}); err != nil {
+		i.deleteStandaloneImage(imageID)
+		return i.restartStandalone(err)
+	}
+
+	// from now on the AMI is kept on failures, allowing to launch the
+	// instance again manually using the kept network interfaces and volumes
+	if err := svc.WaitUntilInstanceTerminated(&ec2.DescribeInstancesInput{InstanceIds: []*string{i.InstanceId}}); err != nil {
+		return err
+	}
+	for _, eni := range input.NetworkInterfaces {
+		if err := svc.WaitUntilNetworkInterfaceAvailable(&ec2.DescribeNetworkInterfacesInput{
+			NetworkInterfaceIds: []*string{eni.NetworkInterfaceId},
+		}); err != nil {
+			return err
+		}
+	}
+
+	replacement, err := i.launchStandalone(input, instanceTypes)
+	if err != nil {
+		logger.Println(r.name, "Couldn't launch a replacement of", *i.InstanceId, "keeping its AMI", *imageID)
+		return err
+	}
+
+	if err := i.attachStandaloneResources(replacement, addresses.Addresses); err != nil {
+		return err
+	}
+	i.deleteStandaloneImage(imageID)
+
+	logger.Println(r.name, "Replaced the standalone instance", *i.InstanceId, "with", *replacement)
+	return nil
+}
+
+// standaloneRunInstancesInput returns the launch parameters of the replacement
+// of the standalone instance, using its network interfaces, without the image
+// and the instance type.
+func (i *instance) standaloneRunInstancesInput() (*ec2.RunInstancesInput, error) {
+	input := &ec2.RunInstancesInput{
+		EbsOptimized: i.EbsOptimized,
+		KeyName:      i.KeyName,
+		MaxCount:     aws.Int64(1),
+		MinCount:     aws.Int64(1),
+	}
+
+	for _, eni := range i.NetworkInterfaces {
+		if eni.Attachment == nil {
+			continue
+		}
+		input.NetworkInterfaces = append(input.NetworkInterfaces, &ec2.InstanceNetworkInterfaceSpecification{
+			DeviceIndex:        eni.Attachment.DeviceIndex,
+			NetworkInterfaceId: eni.NetworkInterfaceId,
+		})
+	}
+	sort.Slice(input.NetworkInterfaces, func(x, y int) bool {
+		return *input.NetworkInterfaces[x].DeviceIndex < *input.NetworkInterfaces[y].DeviceIndex
+	})
+
+	if i.IamInstanceProfile != nil {
+		input.IamInstanceProfile = &ec2.IamInstanceProfileSpecification{Arn: i.IamInstanceProfile.Arn}
+	}
+	if mo := i.MetadataOptions; mo != nil {
+		input.MetadataOptions = &ec2.InstanceMetadataOptionsRequest{
+			HttpEndpoint:            mo.HttpEndpoint,
+			HttpPutResponseHopLimit: mo.HttpPutResponseHopLimit,
+			HttpTokens:              mo.HttpTokens,
+		}
+	}
+	if i.Monitoring != nil && aws.StringValue(i.Monitoring.State) == ec2.MonitoringStateEnabled {
+		input.Monitoring = &ec2.RunInstancesMonitoringEnabled{Enabled: aws.Bool(true)}
+	}
+
+	var tags []*ec2.Tag
+	for _, tag := range i.Tags {
+		if !strings.HasPrefix(aws.StringValue(tag.Key), "aws:") {
+			tags = append(tags, tag)
+		}
+	}
+	tags = append(tags, &ec2.Tag{Key: aws.String(sourceInstanceTag), Value: i.InstanceId})
+	input.TagSpecifications = []*ec2.TagSpecification{{
+		ResourceType: aws.String(ec2.ResourceTypeInstance),
+		Tags:         tags,
+	}}
+
+	userData, err := i.region.services.ec2.DescribeInstanceAttribute(&ec2.DescribeInstanceAttributeInput{
+		Attribute:  aws.String(ec2.InstanceAttributeNameUserData),
+		InstanceId: i.InstanceId,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if userData.UserData != nil && aws.StringValue(userData.UserData.Value) != "" {
+		input.UserData = userData.UserData.Value
+	}
+	return input, nil
+}
+
+// dataVolumes returns the EBS volumes of the instance other than its root
+// volume.
+func (i *instance) dataVolumes() []statefulVolume {
+	var volumes []statefulVolume
+	for _, bdm := range i.BlockDeviceMappings {
+		if bdm.Ebs == nil || aws.StringValue(bdm.DeviceName) == aws.StringValue(i.RootDeviceName) {
+			continue
+		}
+		volumes = append(volumes, statefulVolume{device: bdm.DeviceName, volumeID: bdm.Ebs.VolumeId})
+	}
+	return volumes
+}
+
+// createStandaloneImage creates an AMI of the root volume of the stopped
+// instance, the data volumes are attached to its replacement instead.
+func (i *instance) createStandaloneImage() (*string, error) {
+	svc := i.region.services.ec2
+
+	input := &ec2.CreateImageInput{
+		InstanceId:  i.InstanceId,
+		Name:        aws.String(fmt.Sprintf("autospotting-%s-%d", *i.InstanceId, time.Now().Unix())),
+		Description: aws.String("Root volume of " + *i.InstanceId + " replaced by AutoSpotting"),
+		NoReboot:    aws.Bool(true),
+	}
+	for _, v := range i.dataVolumes() {
+		input.BlockDeviceMappings = append(input.BlockDeviceMappings, &ec2.BlockDeviceMapping{
+			DeviceName: v.device,
+			NoDevice:   aws.String(""),
+		})
+	}
+
+	logger.Println(i.region.name, "Creating an AMI of the root volume of", *i.InstanceId)
+	out, err := svc.CreateImage(input)
+	if err != nil {
+		return nil, err
+	}
+	if err := svc.WaitUntilImageAvailable(&ec2.DescribeImagesInput{ImageIds: []*string{out.ImageId}}); err != nil {
+		i.deleteStandaloneImage(out.ImageId)
+		return nil, err
+	}
+	return out.ImageId, nil
+}
+
+// deleteStandaloneImage deregisters the AMI created for the replacement of
+// the instance and deletes its snapshots.
+func (i *instance) deleteStandaloneImage(imageID *string) {
+	svc := i.region.services.ec2
+
+	out, err := svc.DescribeImages(&ec2.DescribeImagesInput{ImageIds: []*string{imageID}})
+	if err != nil {
+		logger.Println(i.region.name, "Failed to describe the AMI", *imageID, err.Error())
+		return
+	}
+
+	if _, err := svc.DeregisterImage(&ec2.DeregisterImageInput{ImageId: imageID}); err != nil {
+		logger.Println(i.region.name, "Failed to deregister the AMI", *imageID, err.Error())
+		return
+	}
+	for _, image := range out.Images {
+		for _, bdm := range image.BlockDeviceMappings {
+			if bdm.Ebs == nil || bdm.Ebs.SnapshotId == nil {
+				continue
+			}
+			if _, err := svc.DeleteSnapshot(&ec2.DeleteSnapshotInput{SnapshotId: bdm.Ebs.SnapshotId}); err != nil {
+				logger.Println(i.region.name, "Failed to delete the snapshot", *bdm.Ebs.SnapshotId, err.Error())
+			}
+		}
+	}
+}
+
+// keepStandaloneResources keeps the network interfaces and the data volumes
+// of the instance once it's terminated.
+func (i *instance) keepStandaloneResources() error {
+	svc := i.region.services.ec2
+
+	for _, eni := range i.NetworkInterfaces {
+		if eni.Attachment == nil || !aws.BoolValue(eni.Attachment.DeleteOnTermination) {
+			continue
+		}
+		if _, err := svc.ModifyNetworkInterfaceAttribute(&ec2.ModifyNetworkInterfaceAttributeInput{
+			NetworkInterfaceId: eni.NetworkInterfaceId,
+			Attachment: &ec2.NetworkInterfaceAttachmentChanges{
+				AttachmentId:        eni.Attachment.AttachmentId,
+				DeleteOnTermination: aws.Bool(false),
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	var mappings []*ec2.InstanceBlockDeviceMappingSpecification
+	for _, v := range i.dataVolumes() {
+		mappings = append(mappings, &ec2.InstanceBlockDeviceMappingSpecification{
+			DeviceName: v.device,
+			Ebs:        &ec2.EbsInstanceBlockDeviceSpecification{DeleteOnTermination: aws.Bool(false)},
+		})
+	}
+	if len(mappings) == 0 {
+		return nil
+	}
+	_, err := svc.ModifyInstanceAttribute(&ec2.ModifyInstanceAttributeInput{
+		InstanceId:          i.InstanceId,
+		BlockDeviceMappings: mappings,
+	})
+	return err
+}
+
+// restartStandalone starts again the instance whose replacement failed
+// before it was terminated, returning the error of the replacement.
+func (i *instance) restartStandalone(err error) error {
+	logger.Println(i.region.name, "Starting again", *i.InstanceId, "after failing to replace it:", err.Error())
+	if _, serr := i.region.services.ec2.StartInstances(&ec2.StartInstancesInput{
+		InstanceIds: []*string{i.InstanceId},
+	}); serr != nil {
+		logger.Println(i.region.name, "Failed to start", *i.InstanceId, serr.Error())
+	}
+	return err
+}
+
+// launchStandalone launches the replacement of the standalone instance as a
+// spot instance of the first of the given types which can be launched, or
+// as an on-demand instance of its original type when none of them can,
+// returning the ID of the replacement.
+func (i *instance) launchStandalone(input *ec2.RunInstancesInput, instanceTypes []instanceTypeInformation) (*string, error) {
+	svc := i.region.services.ec2
+	az := aws.StringValue(i.Placement.AvailabilityZone)
+
+	for _, it := range instanceTypes {
+		if !i.region.quotas.reserve(it.instanceType, it.vCPU) {
+			continue
+		}
+
+		spot := *input
+		spot.InstanceType = aws.String(it.instanceType)
+		spot.InstanceMarketOptions = i.spotMarketOptions(i.getPricetoBid(i.price, it.pricing.spot[az]))
+
+		logger.Println(az, "Launching a spot instance of type", it.instanceType, "replacing", *i.InstanceId)
+		resp, err := svc.RunInstances(&spot)
+		if err == nil {
+			return resp.Instances[0].InstanceId, nil
+		}
+		i.region.quotas.release(it.instanceType, it.vCPU)
+		if strings.Contains(err.Error(), "InsufficientInstanceCapacity") {
+			i.region.capacity.record(az, it.instanceType)
+		}
+		logger.Println(az, "Couldn't launch a spot instance of type", it.instanceType, err.Error())
+	}
+
+	onDemand := *input
+	onDemand.InstanceType = i.InstanceType
+	logger.Println(az, "Launching an on-demand instance of type", *i.InstanceType, "replacing", *i.InstanceId)
+	resp, err := svc.RunInstances(&onDemand)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Instances[0].InstanceId, nil
+}
+
+// attachStandaloneResources attaches the data volumes of the standalone
+// instance to its replacement, and associates again its Elastic IP addresses
+// with their network interfaces in case they lost their association.
+func (i *instance) attachStandaloneResources(replacement *string, addresses []*ec2.Address) error {
+	svc := i.region.services.ec2
+
+	if err := svc.WaitUntilInstanceRunning(&ec2.DescribeInstancesInput{
+		InstanceIds: []*string{replacement},
+	}); err != nil {
+		return err
+	}
+
+	for _, v := range i.dataVolumes() {
+		logger.Println(i.region.name, "Attaching the volume", *v.volumeID, "to", *replacement, "as", *v.device)
+		if _, err := svc.AttachVolume(&ec2.AttachVolumeInput{
+			Device:     v.device,
+			InstanceId: replacement,
+			VolumeId:   v.volumeID,
+		}); err != nil {
+			return err
+		}
+		if err := svc.WaitUntilVolumeInUse(&ec2.DescribeVolumesInput{
+			VolumeIds: []*string{v.volumeID},
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, address := range addresses {
+		if address.AllocationId == nil || address.NetworkInterfaceId == nil {
+			continue
+		}
+		if _, err := svc.AssociateAddress(&ec2.AssociateAddressInput{
+			AllocationId:       address.AllocationId,
+			NetworkInterfaceId: address.NetworkInterfaceId,
+			PrivateIpAddress:   address.PrivateIpAddress,
+			AllowReassociation: aws.Bool(true),
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
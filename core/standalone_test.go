@@ -0,0 +1,271 @@
+package autospotting
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func newStandaloneInstance(id string, tags ...*ec2.Tag) *ec2.Instance {
+	return &ec2.Instance{
+		InstanceId:         aws.String(id),
+		InstanceType:       aws.String("m5.large"),
+		LaunchTime:         aws.Time(time.Now().Add(-time.Hour)),
+		Placement:          &ec2.Placement{AvailabilityZone: aws.String("us-east-1a")},
+		State:              &ec2.InstanceState{Name: aws.String(ec2.InstanceStateNameRunning)},
+		RootDeviceType:     aws.String(ec2.DeviceTypeEbs),
+		RootDeviceName:     aws.String("/dev/xvda"),
+		VirtualizationType: aws.String(ec2.VirtualizationTypeHvm),
+		BlockDeviceMappings: []*ec2.InstanceBlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-root")}},
+			{DeviceName: aws.String("/dev/xvdf"), Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-data")}},
+		},
+		NetworkInterfaces: []*ec2.InstanceNetworkInterface{
+			{
+				NetworkInterfaceId: aws.String("eni-secondary"),
+				Attachment: &ec2.InstanceNetworkInterfaceAttachment{
+					AttachmentId: aws.String("eni-attach-2"), DeviceIndex: aws.Int64(1),
+					DeleteOnTermination: aws.Bool(false),
+				},
+			},
+			{
+				NetworkInterfaceId: aws.String("eni-primary"),
+				Attachment: &ec2.InstanceNetworkInterfaceAttachment{
+					AttachmentId: aws.String("eni-attach-1"), DeviceIndex: aws.Int64(0),
+					DeleteOnTermination: aws.Bool(true),
+				},
+			},
+		},
+		Tags: tags,
+	}
+}
+
+func newStandaloneRegion(ec2Mock mockEC2) *region {
+	if ec2Mock.diao == nil {
+		ec2Mock.diao = &ec2.DescribeInstanceAttributeOutput{}
+	}
+	r := &region{
+		name:      "us-east-1",
+		conf:      &Config{StandaloneInstanceSupport: true},
+		capacity:  newCapacityFailures(),
+		services:  connections{ec2: ec2Mock},
+		instances: makeInstances(),
+		instanceTypeInformation: map[string]instanceTypeInformation{
+			"m5.large": {
+				instanceType: "m5.large", vCPU: 2, memory: 8, PhysicalProcessor: "Intel",
+				pricing: prices{onDemand: 0.096, spot: map[string]float64{"us-east-1a": 0.04}},
+			},
+			"c5.large": {
+				instanceType: "c5.large", vCPU: 2, memory: 8, PhysicalProcessor: "Intel",
+				pricing: prices{onDemand: 0.085, spot: map[string]float64{"us-east-1a": 0.03}},
+			},
+		},
+	}
+	return r
+}
+
+func TestIsStandalone(t *testing.T) {
+	optIn := &ec2.Tag{Key: aws.String(StandaloneTag), Value: aws.String("true")}
+
+	tests := []struct {
+		name   string
+		modify func(*ec2.Instance)
+		want   bool
+	}{
+		{name: "opted in", want: true},
+		{name: "not opted in", modify: func(i *ec2.Instance) { i.Tags = nil }},
+		{
+			name: "opted out",
+			modify: func(i *ec2.Instance) {
+				i.Tags = []*ec2.Tag{{Key: aws.String(StandaloneTag), Value: aws.String("false")}}
+			},
+		},
+		{
+			name: "member of a group",
+			modify: func(i *ec2.Instance) {
+				i.Tags = append(i.Tags, &ec2.Tag{Key: aws.String(autoScalingGroupNameTag), Value: aws.String("web")})
+			},
+		},
+		{name: "spot instance", modify: func(i *ec2.Instance) { i.InstanceLifecycle = aws.String("spot") }},
+		{
+			name:   "instance store root volume",
+			modify: func(i *ec2.Instance) { i.RootDeviceType = aws.String(ec2.DeviceTypeInstanceStore) },
+		},
+		{
+			name:   "stopped instance",
+			modify: func(i *ec2.Instance) { i.State.Name = aws.String(ec2.InstanceStateNameStopped) },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inst := newStandaloneInstance("i-standalone", optIn)
+			if tt.modify != nil {
+				tt.modify(inst)
+			}
+			if got := (&instance{Instance: inst}).isStandalone(); got != tt.want {
+				t.Errorf("isStandalone() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStandaloneInstances(t *testing.T) {
+	optIn := &ec2.Tag{Key: aws.String(StandaloneTag), Value: aws.String("true")}
+	r := newStandaloneRegion(mockEC2{})
+	r.conf.MinInstanceUptime = 30 * time.Minute
+
+	fresh := newStandaloneInstance("i-fresh", optIn)
+	fresh.LaunchTime = aws.Time(time.Now())
+	for _, inst := range []*ec2.Instance{
+		newStandaloneInstance("i-b", optIn), newStandaloneInstance("i-a", optIn),
+		newStandaloneInstance("i-other"), fresh,
+	} {
+		r.addInstance(inst)
+	}
+
+	var got []string
+	for _, i := range r.standaloneInstances() {
+		got = append(got, *i.InstanceId)
+	}
+	if want := []string{"i-a", "i-b"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("standaloneInstances() = %v, want %v", got, want)
+	}
+}
+
+func TestReplaceStandalone(t *testing.T) {
+	disableLogging()
+
+	tests := []struct {
+		name       string
+		ec2Mock    mockEC2
+		disallowed string
+		wantCalls  []string
+		wantTypes  []string
+		wantErr    bool
+	}{
+		{
+			name: "replaced with a spot instance",
+			ec2Mock: mockEC2{
+				dao: &ec2.DescribeAddressesOutput{Addresses: []*ec2.Address{{
+					AllocationId: aws.String("eipalloc-1"), NetworkInterfaceId: aws.String("eni-primary"),
+					PrivateIpAddress: aws.String("10.0.0.10"),
+				}}},
+				dimo: &ec2.DescribeImagesOutput{Images: []*ec2.Image{{
+					BlockDeviceMappings: []*ec2.BlockDeviceMapping{
+						{Ebs: &ec2.EbsBlockDevice{SnapshotId: aws.String("snap-root")}},
+					},
+				}}},
+			},
+			wantCalls: []string{
+				"StopInstances i-standalone",
+				"CreateImage i-standalone",
+				"ModifyNetworkInterfaceAttribute eni-primary",
+				"ModifyInstanceAttribute i-standalone",
+				"TerminateInstances i-standalone",
+				"AssociateAddress eipalloc-1",
+				"DeregisterImage ami-standalone",
+				"DeleteSnapshot snap-root",
+			},
+			wantTypes: []string{"c5.large"},
+		},
+		{
+			name:       "on-demand fallback",
+			disallowed: "m5.large",
+			ec2Mock: mockEC2{
+				dimo:  &ec2.DescribeImagesOutput{},
+				rierr: map[string]error{"c5.large": errors.New("InsufficientInstanceCapacity")},
+			},
+			wantCalls: []string{
+				"StopInstances i-standalone",
+				"CreateImage i-standalone",
+				"ModifyNetworkInterfaceAttribute eni-primary",
+				"ModifyInstanceAttribute i-standalone",
+				"TerminateInstances i-standalone",
+				"DeregisterImage ami-standalone",
+			},
+			wantTypes: []string{"m5.large"},
+		},
+		{
+			name:      "image creation failure",
+			ec2Mock:   mockEC2{cierr: errors.New("image failed")},
+			wantCalls: []string{"StopInstances i-standalone", "CreateImage i-standalone", "StartInstances i-standalone"},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			volumes := []string{}
+			tt.ec2Mock.calls = &calls
+			tt.ec2Mock.vcall = &volumes
+			tt.ec2Mock.ri = &runInstancesCalls{}
+
+			r := newStandaloneRegion(tt.ec2Mock)
+			r.conf.DisallowedInstanceTypes = tt.disallowed
+			r.addInstance(newStandaloneInstance("i-standalone",
+				&ec2.Tag{Key: aws.String(StandaloneTag), Value: aws.String("true")}))
+			i := r.instances.get("i-standalone")
+
+			err := i.replaceStandalone()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("replaceStandalone() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("replaceStandalone() called %v, want %v", calls, tt.wantCalls)
+			}
+			if !reflect.DeepEqual(tt.ec2Mock.ri.instanceTypes, tt.wantTypes) {
+				t.Errorf("replaceStandalone() launched %v, want %v", tt.ec2Mock.ri.instanceTypes, tt.wantTypes)
+			}
+			if tt.wantErr {
+				return
+			}
+			if want := []string{fmt.Sprintf("attach vol-data i-spot-%d", len(tt.wantTypes))}; !reflect.DeepEqual(volumes, want) {
+				t.Errorf("replaceStandalone() attached %v, want %v", volumes, want)
+			}
+		})
+	}
+}
+
+func TestStandaloneRunInstancesInput(t *testing.T) {
+	r := newStandaloneRegion(mockEC2{diao: &ec2.DescribeInstanceAttributeOutput{
+		UserData: &ec2.AttributeValue{Value: aws.String("IyEvYmluL3No")},
+	}})
+	inst := newStandaloneInstance("i-standalone",
+		&ec2.Tag{Key: aws.String("Name"), Value: aws.String("pet")},
+		&ec2.Tag{Key: aws.String("aws:cloudformation:stack-name"), Value: aws.String("stack")})
+	inst.IamInstanceProfile = &ec2.IamInstanceProfile{Arn: aws.String("arn:aws:iam::123:instance-profile/pet")}
+	r.addInstance(inst)
+
+	input, err := r.instances.get("i-standalone").standaloneRunInstancesInput()
+	if err != nil {
+		t.Fatalf("standaloneRunInstancesInput() error = %v", err)
+	}
+
+	var enis []string
+	for _, eni := range input.NetworkInterfaces {
+		enis = append(enis, *eni.NetworkInterfaceId)
+	}
+	if want := []string{"eni-primary", "eni-secondary"}; !reflect.DeepEqual(enis, want) {
+		t.Errorf("network interfaces = %v, want %v", enis, want)
+	}
+	if aws.StringValue(input.UserData) != "IyEvYmluL3No" {
+		t.Errorf("user data = %v, want the one of the instance", aws.StringValue(input.UserData))
+	}
+	if aws.StringValue(input.IamInstanceProfile.Arn) != "arn:aws:iam::123:instance-profile/pet" {
+		t.Errorf("instance profile = %v, want the one of the instance", input.IamInstanceProfile)
+	}
+
+	tags := map[string]string{}
+	for _, tag := range input.TagSpecifications[0].Tags {
+		tags[*tag.Key] = *tag.Value
+	}
+	want := map[string]string{"Name": "pet", sourceInstanceTag: "i-standalone"}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("tags = %v, want %v", tags, want)
+	}
+}
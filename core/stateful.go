@@ -0,0 +1,146 @@
+package autospotting
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// statefulDevices returns the device names of the EBS volumes handed over
+// from the replaced on-demand instances to their spot replacements, as
+// configured by the StatefulVolumesTag of the group.
+func (a *autoScalingGroup) statefulDevices() map[string]bool {
+	value := a.getTagValue(StatefulVolumesTag)
+	if value == nil {
+		return nil
+	}
+
+	devices := make(map[string]bool)
+	for _, device := range strings.Split(*value, ",") {
+		if device = strings.TrimSpace(device); device != "" {
+			devices[device] = true
+		}
+	}
+	return devices
+}
+
+// statefulVolume is an EBS volume handed over between instances.
+type statefulVolume struct {
+	device   *string
+	volumeID *string
+}
+
+// statefulVolumes returns the volumes attached to the instance on the given
+// devices, the root volume is never handed over.
+func (i *instance) statefulVolumes(devices map[string]bool) []statefulVolume {
+	var volumes []statefulVolume
+	for _, bdm := range i.BlockDeviceMappings {
+		if bdm.Ebs == nil || !devices[aws.StringValue(bdm.DeviceName)] ||
+			aws.StringValue(bdm.DeviceName) == aws.StringValue(i.RootDeviceName) {
+			continue
+		}
+		volumes = append(volumes, statefulVolume{
+			device:   bdm.DeviceName,
+			volumeID: bdm.Ebs.VolumeId,
+		})
+	}
+	return volumes
+}
+
+// handOverStatefulVolumes moves the stateful EBS volumes of the on-demand
+// instance to the spot instance replacing it, using the same device names,
+// before the spot instance is put into service. If anything fails the volumes
+// are attached back to the on-demand instance and the replacement is aborted.
+func (a *autoScalingGroup) handOverStatefulVolumes(odInst, spotInst *instance) error {
+	devices := a.statefulDevices()
+	if len(devices) == 0 {
+		return nil
+	}
+
+	volumes := odInst.statefulVolumes(devices)
+	if len(volumes) == 0 {
+		logger.Println(a.name, "No stateful volumes attached to", *odInst.InstanceId)
+		return nil
+	}
+
+	if *odInst.Placement.AvailabilityZone != *spotInst.Placement.AvailabilityZone {
+		return fmt.Errorf("%s and %s are in different availability zones",
+			*odInst.InstanceId, *spotInst.InstanceId)
+	}
+
+	svc := a.region.services.ec2
+
+	detached, err := a.moveVolumes(volumes, odInst, spotInst)
+	if err == nil {
+		return nil
+	}
+
+	logger.Println(a.name, "Failed to hand over the stateful volumes of", *odInst.InstanceId,
+		"to", *spotInst.InstanceId, "attaching them back:", err.Error())
+
+	for _, v := range detached {
+		svc.DetachVolume(&ec2.DetachVolumeInput{
+			InstanceId: spotInst.InstanceId,
+			VolumeId:   v.volumeID,
+		})
+		svc.WaitUntilVolumeAvailable(&ec2.DescribeVolumesInput{VolumeIds: []*string{v.volumeID}})
+
+		if _, aerr := svc.AttachVolume(&ec2.AttachVolumeInput{
+			Device:     v.device,
+			InstanceId: odInst.InstanceId,
+			VolumeId:   v.volumeID,
+		}); aerr != nil {
+			logger.Println(a.name, "Failed to attach", *v.volumeID, "back to",
+				*odInst.InstanceId, aerr.Error())
+		}
+	}
+	return err
+}
+
+// moveVolumes detaches the volumes from the source instance and attaches them
+// to the destination instance, returning the volumes detached so far.
+func (a *autoScalingGroup) moveVolumes(volumes []statefulVolume, src, dst *instance) ([]statefulVolume, error) {
+	svc := a.region.services.ec2
+
+	var detached []statefulVolume
+	for _, v := range volumes {
+		logger.Println(a.name, "Detaching the stateful volume", *v.volumeID,
+			"from", *src.InstanceId)
+
+		if _, err := svc.DetachVolume(&ec2.DetachVolumeInput{
+			InstanceId: src.InstanceId,
+			VolumeId:   v.volumeID,
+		}); err != nil {
+			return detached, err
+		}
+		detached = append(detached, v)
+
+		if err := svc.WaitUntilVolumeAvailable(&ec2.DescribeVolumesInput{
+			VolumeIds: []*string{v.volumeID},
+		}); err != nil {
+			return detached, err
+		}
+	}
+
+	for _, v := range volumes {
+		logger.Println(a.name, "Attaching the stateful volume", *v.volumeID,
+			"to", *dst.InstanceId, "as", *v.device)
+
+		if _, err := svc.AttachVolume(&ec2.AttachVolumeInput{
+			Device:     v.device,
+			InstanceId: dst.InstanceId,
+			VolumeId:   v.volumeID,
+		}); err != nil {
+			return detached, err
+		}
+
+		if err := svc.WaitUntilVolumeInUse(&ec2.DescribeVolumesInput{
+			VolumeIds: []*string{v.volumeID},
+		}); err != nil {
+			return detached, err
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,103 @@
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestHandOverStatefulVolumes(t *testing.T) {
+	odVolumes := []*ec2.InstanceBlockDeviceMapping{
+		{DeviceName: aws.String("/dev/xvda"), Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-root")}},
+		{DeviceName: aws.String("/dev/sdf"), Ebs: &ec2.EbsInstanceBlockDevice{VolumeId: aws.String("vol-data")}},
+	}
+
+	tests := []struct {
+		name    string
+		tags    map[string]string
+		spotAZ  string
+		averr   error
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:   "not stateful",
+			spotAZ: "us-east-1a",
+		},
+		{
+			name:   "volume moved",
+			tags:   map[string]string{StatefulVolumesTag: "/dev/xvda, /dev/sdf"},
+			spotAZ: "us-east-1a",
+			want:   []string{"detach vol-data i-ondemand", "attach vol-data i-spot"},
+		},
+		{
+			name:   "no such device",
+			tags:   map[string]string{StatefulVolumesTag: "/dev/sdg"},
+			spotAZ: "us-east-1a",
+		},
+		{
+			name:    "different availability zones",
+			tags:    map[string]string{StatefulVolumesTag: "/dev/sdf"},
+			spotAZ:  "us-east-1b",
+			wantErr: true,
+		},
+		{
+			name:   "attached back on failure",
+			tags:   map[string]string{StatefulVolumesTag: "/dev/sdf"},
+			spotAZ: "us-east-1a",
+			averr:  errors.New("VolumeInUse"),
+			want: []string{
+				"detach vol-data i-ondemand",
+				"attach vol-data i-spot",
+				"detach vol-data i-spot",
+				"attach vol-data i-ondemand",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls []string
+			a := newTestGroup(tt.tags)
+			a.region = &region{services: connections{
+				ec2: mockEC2{averr: tt.averr, vcall: &calls},
+			}}
+
+			onDemand := newTestInstance("i-ondemand", "m5.large", time.Hour)
+			onDemand.RootDeviceName = aws.String("/dev/xvda")
+			onDemand.BlockDeviceMappings = odVolumes
+			spot := newTestInstance("i-spot", "m5.large", time.Minute)
+			spot.RootDeviceName = aws.String("/dev/xvda")
+			spot.Placement.AvailabilityZone = aws.String(tt.spotAZ)
+
+			err := a.handOverStatefulVolumes(onDemand, spot)
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("handOverStatefulVolumes() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(calls, tt.want) {
+				t.Errorf("handOverStatefulVolumes() calls = %v, want %v", calls, tt.want)
+			}
+		})
+	}
+}
+
+func TestConvertBlockDeviceMappingsSkipsStatefulVolumes(t *testing.T) {
+	i := &instance{asg: newTestGroup(map[string]string{StatefulVolumesTag: "/dev/sdf"})}
+	lc := &launchConfiguration{LaunchConfiguration: &autoscaling.LaunchConfiguration{
+		BlockDeviceMappings: []*autoscaling.BlockDeviceMapping{
+			{DeviceName: aws.String("/dev/xvda")},
+			{DeviceName: aws.String("/dev/sdf")},
+		},
+	}}
+
+	bdms := i.convertBlockDeviceMappings(lc)
+	if len(bdms) != 1 || *bdms[0].DeviceName != "/dev/xvda" {
+		t.Errorf("expected only the root volume to be launched, got %v", bdms)
+	}
+}
@@ -0,0 +1,233 @@
+package autospotting
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+// spotSubnets returns the IDs of the subnets the spot instances can be
+// launched in, given by the SpotSubnetsTag of the group if set, otherwise the
+// subnets the group is configured to use.
+func (a *autoScalingGroup) spotSubnets() []string {
+	value := a.VPCZoneIdentifier
+	if override := a.getTagValue(SpotSubnetsTag); override != nil {
+		value = override
+	}
+
+	var subnets []string
+	for _, id := range strings.Split(aws.StringValue(value), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			subnets = append(subnets, id)
+		}
+	}
+	return subnets
+}
+
+// subnetsInAZ returns the sorted IDs of the spot subnets located in the given
+// availability zone.
+func (a *autoScalingGroup) subnetsInAZ(az string) ([]string, error) {
+	subnets := a.spotSubnets()
+	if len(subnets) == 0 {
+		return nil, nil
+	}
+
+	if a.subnetZones == nil {
+		described, err := a.region.describeSubnets(subnets)
+		if err != nil {
+			return nil, err
+		}
+
+		a.subnetZones = make(map[string]string)
+		for _, s := range described {
+			a.subnetZones[*s.SubnetId] = aws.StringValue(s.AvailabilityZone)
+			debug.Println(a.region.name, a.name, "Subnet", *s.SubnetId, "of VPC", aws.StringValue(s.VpcId),
+				"is owned by account", aws.StringValue(s.OwnerId))
+		}
+	}
+
+	var inAZ []string
+	for _, s := range subnets {
+		if a.subnetZones[s] == az {
+			inAZ = append(inAZ, s)
+		}
+	}
+	sort.Strings(inAZ)
+	return inAZ, nil
+}
+
+// describeSubnets returns the given subnets, which may be owned by another
+// account and shared with this one through RAM. The subnets which can't be
+// described, such as those whose share was meanwhile removed, are logged and
+// skipped instead of failing for all of them, since a single unknown subnet
+// fails the whole DescribeSubnets call.
+func (r *region) describeSubnets(ids []string) ([]*ec2.Subnet, error) {
+	out, err := r.services.ec2.DescribeSubnets(&ec2.DescribeSubnetsInput{
+		SubnetIds: aws.StringSlice(ids),
+	})
+	if err == nil {
+		return out.Subnets, nil
+	}
+	if !isSubnetNotFoundError(err) {
+		return nil, err
+	}
+
+	var subnets []*ec2.Subnet
+	for _, id := range ids {
+		out, err := r.services.ec2.DescribeSubnets(&ec2.DescribeSubnetsInput{
+			SubnetIds: []*string{aws.String(id)},
+		})
+		if isSubnetNotFoundError(err) {
+			logger.Println(r.name, "Ignoring subnet", id, "which isn't available to this account,",
+				"such as a subnet no longer shared through RAM")
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		subnets = append(subnets, out.Subnets...)
+	}
+	return subnets, nil
+}
+
+func isSubnetNotFoundError(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && aerr.Code() == "InvalidSubnetID.NotFound"
+}
+
+// checkSpotSubnets returns an error when the spot subnets are restricted by
+// the SpotSubnetsTag and none of them is in the given availability zone.
+func (a *autoScalingGroup) checkSpotSubnets(az string) error {
+	if a.getTagValue(SpotSubnetsTag) == nil {
+		return nil
+	}
+
+	subnets, err := a.subnetsInAZ(az)
+	if err != nil {
+		return fmt.Errorf("failed to describe the spot subnets: %s", err.Error())
+	}
+	if len(subnets) == 0 {
+		return fmt.Errorf("none of the subnets from the %s tag is in %s", SpotSubnetsTag, az)
+	}
+	return nil
+}
+
+// groupInstances returns the running and pending instances of the group,
+// including the spot instances launched for it which weren't attached yet.
+func (a *autoScalingGroup) groupInstances() []*instance {
+	var result []*instance
+	ownership := a.region.conf.Ownership()
+	for inst := range a.region.instances.instances() {
+		if a.instances.get(*inst.InstanceId) == nil && inst.ownerGroup(ownership) != a.name {
+			continue
+		}
+		if state := aws.StringValue(inst.State.Name); state != ec2.InstanceStateNameRunning &&
+			state != ec2.InstanceStateNamePending {
+			continue
+		}
+		result = append(result, inst)
+	}
+	return result
+}
+
+// spotSubnet returns the subnet used for the spot instance replacing this
+// on-demand instance, according to the subnet selection of its group. The
+// planned subnets, in launch order, are those of the other replacements of the
+// same batch which weren't launched yet. It falls back to the subnet of the
+// on-demand instance, unless that one isn't allowed by the SpotSubnetsTag of
+// the group.
+func (i *instance) spotSubnet(planned []string) *string {
+	method := i.asg.config.SubnetSelection
+	restricted := i.asg.getTagValue(SpotSubnetsTag) != nil
+
+	if i.SubnetId == nil ||
+		(!restricted && (method == "" || method == SameSubnetSelection)) {
+		return i.SubnetId
+	}
+
+	candidates, err := i.asg.subnetsInAZ(*i.Placement.AvailabilityZone)
+	if err != nil {
+		logger.Println(i.asg.name, "Failed to describe the subnets of the group,",
+			"using the subnet of", *i.InstanceId, err.Error())
+		return i.SubnetId
+	}
+	if len(candidates) == 0 {
+		return i.SubnetId
+	}
+
+	var subnet string
+	switch method {
+	case RoundRobinSubnetSelection:
+		subnet = nextSubnet(candidates, i.asg.groupInstances(), planned)
+	case LeastUsedSubnetSelection:
+		subnet = leastUsedSubnet(candidates, i.asg.groupInstances(), planned)
+	default:
+		if indexOf(candidates, *i.SubnetId) >= 0 {
+			return i.SubnetId
+		}
+		method = LeastUsedSubnetSelection
+		subnet = leastUsedSubnet(candidates, i.asg.groupInstances(), planned)
+	}
+
+	logger.Println(i.asg.name, "Using the", method, "subnet", subnet,
+		"for the replacement of", *i.InstanceId)
+	return aws.String(subnet)
+}
+
+// nextSubnet returns the candidate subnet following the one of the most
+// recently launched or planned instance, so that consecutive launches rotate
+// over the candidates even across runs.
+func nextSubnet(candidates []string, instances []*instance, planned []string) string {
+	for n := len(planned) - 1; n >= 0; n-- {
+		if idx := indexOf(candidates, planned[n]); idx >= 0 {
+			return candidates[(idx+1)%len(candidates)]
+		}
+	}
+
+	var latest *instance
+	for _, inst := range instances {
+		if indexOf(candidates, aws.StringValue(inst.SubnetId)) < 0 {
+			continue
+		}
+		if latest == nil || aws.TimeValue(inst.LaunchTime).After(aws.TimeValue(latest.LaunchTime)) {
+			latest = inst
+		}
+	}
+	if latest == nil {
+		return candidates[0]
+	}
+	return candidates[(indexOf(candidates, *latest.SubnetId)+1)%len(candidates)]
+}
+
+// leastUsedSubnet returns the candidate subnet running the fewest instances,
+// including the planned ones, the first one in case of a tie.
+func leastUsedSubnet(candidates []string, instances []*instance, planned []string) string {
+	usage := make(map[string]int)
+	for _, inst := range instances {
+		usage[aws.StringValue(inst.SubnetId)]++
+	}
+	for _, s := range planned {
+		usage[s]++
+	}
+
+	best := candidates[0]
+	for _, s := range candidates[1:] {
+		if usage[s] < usage[best] {
+			best = s
+		}
+	}
+	return best
+}
+
+func indexOf(list []string, value string) int {
+	for i, v := range list {
+		if v == value {
+			return i
+		}
+	}
+	return -1
+}
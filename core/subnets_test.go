@@ -0,0 +1,221 @@
+package autospotting
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func TestInstanceSpotSubnet(t *testing.T) {
+	subnets := &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+		{SubnetId: aws.String("subnet-b"), AvailabilityZone: aws.String("us-east-1a")},
+		{SubnetId: aws.String("subnet-a"), AvailabilityZone: aws.String("us-east-1a")},
+		{SubnetId: aws.String("subnet-c"), AvailabilityZone: aws.String("us-east-1a")},
+		{SubnetId: aws.String("subnet-d"), AvailabilityZone: aws.String("us-east-1b")},
+	}}
+
+	// the on-demand instance being replaced and the most recent launch are in
+	// subnet-a, subnet-c is unused
+	onDemand := newTestInstance("i-ondemand", "m5.large", time.Minute)
+	members := instanceMap{
+		"i-ondemand": onDemand,
+		"i-old":      newTestInstance("i-old", "m5.large", time.Hour),
+		"i-other":    newTestInstance("i-other", "m5.large", 2*time.Hour),
+	}
+	onDemand.SubnetId = aws.String("subnet-a")
+	members["i-old"].SubnetId = aws.String("subnet-b")
+	members["i-other"].SubnetId = aws.String("subnet-b")
+
+	tests := []struct {
+		name    string
+		method  string
+		allowed string
+		dserr   error
+		want    string
+	}{
+		{name: "default", want: "subnet-a"},
+		{name: "same as replaced", method: SameSubnetSelection, want: "subnet-a"},
+		{name: "round robin", method: RoundRobinSubnetSelection, want: "subnet-b"},
+		{name: "least used", method: LeastUsedSubnetSelection, want: "subnet-c"},
+		{
+			name:    "replaced subnet not allowed",
+			allowed: "subnet-b,subnet-c",
+			want:    "subnet-c",
+		},
+		{
+			name:    "replaced subnet allowed",
+			allowed: "subnet-a,subnet-b",
+			want:    "subnet-a",
+		},
+		{
+			name:    "round robin over the allowed subnets",
+			method:  RoundRobinSubnetSelection,
+			allowed: "subnet-c,subnet-b",
+			want:    "subnet-c",
+		},
+		{
+			name:   "fallback on errors",
+			method: LeastUsedSubnetSelection,
+			dserr:  errors.New("UnauthorizedOperation"),
+			want:   "subnet-a",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			asg := &autoScalingGroup{
+				name: "asg",
+				Group: &autoscaling.Group{
+					VPCZoneIdentifier: aws.String("subnet-a, subnet-b,subnet-c,subnet-d"),
+				},
+				instances: makeInstancesWithCatalog(members),
+				region: &region{
+					services:  connections{ec2: mockEC2{dso: subnets, dserr: tt.dserr}},
+					instances: makeInstancesWithCatalog(members),
+				},
+				config: AutoScalingConfig{SubnetSelection: tt.method},
+			}
+			if tt.allowed != "" {
+				asg.Tags = []*autoscaling.TagDescription{
+					{Key: aws.String(SpotSubnetsTag), Value: aws.String(tt.allowed)},
+				}
+			}
+			onDemand.asg = asg
+
+			if got := aws.StringValue(onDemand.spotSubnet(nil)); got != tt.want {
+				t.Errorf("spotSubnet() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextSubnet(t *testing.T) {
+	candidates := []string{"subnet-a", "subnet-b"}
+
+	if got := nextSubnet(candidates, nil, nil); got != "subnet-a" {
+		t.Errorf("nextSubnet() = %s, expected the first subnet without instances", got)
+	}
+
+	latest := []*instance{
+		newTestInstance("i-1", "m5.large", time.Hour),
+		newTestInstance("i-2", "m5.large", time.Minute),
+	}
+	latest[0].SubnetId = aws.String("subnet-a")
+	latest[1].SubnetId = aws.String("subnet-b")
+	if got := nextSubnet(candidates, latest, nil); got != "subnet-a" {
+		t.Errorf("nextSubnet() = %s, expected to wrap around", got)
+	}
+
+	if got := nextSubnet(candidates, latest, []string{"subnet-a", "subnet-d"}); got != "subnet-b" {
+		t.Errorf("nextSubnet() = %s, expected to follow the last planned launch", got)
+	}
+}
+
+func TestLeastUsedSubnet(t *testing.T) {
+	candidates := []string{"subnet-a", "subnet-b", "subnet-c"}
+	instances := []*instance{newTestInstance("i-1", "m5.large", time.Hour)}
+	instances[0].SubnetId = aws.String("subnet-a")
+
+	if got := leastUsedSubnet(candidates, instances, nil); got != "subnet-b" {
+		t.Errorf("leastUsedSubnet() = %s, want subnet-b", got)
+	}
+	if got := leastUsedSubnet(candidates, instances, []string{"subnet-b"}); got != "subnet-c" {
+		t.Errorf("leastUsedSubnet() = %s, expected to count the planned launches", got)
+	}
+}
+
+func TestCheckSpotSubnets(t *testing.T) {
+	subnets := &ec2.DescribeSubnetsOutput{Subnets: []*ec2.Subnet{
+		{SubnetId: aws.String("subnet-a"), AvailabilityZone: aws.String("us-east-1a")},
+		{SubnetId: aws.String("subnet-d"), AvailabilityZone: aws.String("us-east-1b")},
+	}}
+
+	tests := []struct {
+		name    string
+		tags    map[string]string
+		dserr   error
+		wantErr bool
+	}{
+		{name: "not restricted", dserr: errors.New("unexpected call")},
+		{name: "allowed subnet in the AZ", tags: map[string]string{SpotSubnetsTag: "subnet-a,subnet-d"}},
+		{name: "no allowed subnet in the AZ", tags: map[string]string{SpotSubnetsTag: "subnet-d"}, wantErr: true},
+		{
+			name:    "API error",
+			tags:    map[string]string{SpotSubnetsTag: "subnet-a"},
+			dserr:   errors.New("UnauthorizedOperation"),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestGroup(tt.tags)
+			a.region = &region{services: connections{ec2: mockEC2{dso: subnets, dserr: tt.dserr}}}
+
+			if err := a.checkSpotSubnets("us-east-1a"); (err != nil) != tt.wantErr {
+				t.Errorf("checkSpotSubnets() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSubnetsInAZWithSharedSubnets(t *testing.T) {
+	subnets := map[string]*ec2.Subnet{
+		"subnet-own": {
+			SubnetId: aws.String("subnet-own"), AvailabilityZone: aws.String("us-east-1a"),
+			OwnerId: aws.String("111111111111"), VpcId: aws.String("vpc-own"),
+		},
+		"subnet-shared": {
+			SubnetId: aws.String("subnet-shared"), AvailabilityZone: aws.String("us-east-1a"),
+			OwnerId: aws.String("222222222222"), VpcId: aws.String("vpc-shared"),
+		},
+		"subnet-shared-b": {
+			SubnetId: aws.String("subnet-shared-b"), AvailabilityZone: aws.String("us-east-1b"),
+			OwnerId: aws.String("222222222222"), VpcId: aws.String("vpc-shared"),
+		},
+	}
+
+	tests := []struct {
+		name    string
+		subnets string
+		want    []string
+	}{
+		{
+			name:    "shared subnets",
+			subnets: "subnet-shared,subnet-own,subnet-shared-b",
+			want:    []string{"subnet-own", "subnet-shared"},
+		},
+		{
+			name:    "subnet no longer shared",
+			subnets: "subnet-shared,subnet-unshared",
+			want:    []string{"subnet-shared"},
+		},
+		{
+			name:    "only unknown subnets",
+			subnets: "subnet-unshared,subnet-deleted",
+		},
+		{
+			name:    "single unknown subnet",
+			subnets: "subnet-unshared",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &autoScalingGroup{
+				name:   "asg",
+				Group:  &autoscaling.Group{VPCZoneIdentifier: aws.String(tt.subnets)},
+				region: &region{name: "us-east-1", services: connections{ec2: mockEC2{dsbyid: subnets}}},
+			}
+			got, err := a.subnetsInAZ("us-east-1a")
+			if err != nil {
+				t.Fatalf("subnetsInAZ() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("subnetsInAZ() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package autospotting
+
+import (
+	"strconv"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+const (
+	// replacingTag is set on a spot instance while it replaces the on-demand
+	// instance given as value, so that a replacement interrupted half way, for
+	// example by a Lambda timeout, can be finished by the next run.
+	replacingTag = "autospotting-replacing"
+
+	// originalMaxSizeTag stores the MaxSize of the group before it got
+	// temporarily increased for the replacement.
+	originalMaxSizeTag = "autospotting-original-max-size"
+
+	// originalMinSizeTag stores the MinSize of the group before it got
+	// temporarily decreased for the replacement.
+	originalMinSizeTag = "autospotting-original-min-size"
+)
+
+// replacement is the transaction record of a replacement, persisted as tags
+// on the spot instance.
+type replacement struct {
+	spot            *instance
+	onDemandID      string
+	originalMaxSize *int64
+	originalMinSize *int64
+}
+
+// beginReplacement persists the transaction record on the spot instance
+// before any change is made to the group.
+func (a *autoScalingGroup) beginReplacement(spot, onDemand *instance, originalMaxSize, originalMinSize *int64) error {
+	tags := []*ec2.Tag{{
+		Key:   aws.String(replacingTag),
+		Value: onDemand.InstanceId,
+	}}
+	if originalMaxSize != nil {
+		tags = append(tags, &ec2.Tag{
+			Key:   aws.String(originalMaxSizeTag),
+			Value: aws.String(strconv.FormatInt(*originalMaxSize, 10)),
+		})
+	}
+	if originalMinSize != nil {
+		tags = append(tags, &ec2.Tag{
+			Key:   aws.String(originalMinSizeTag),
+			Value: aws.String(strconv.FormatInt(*originalMinSize, 10)),
+		})
+	}
+
+	_, err := a.region.services.ec2.CreateTags(&ec2.CreateTagsInput{
+		Resources: []*string{spot.InstanceId},
+		Tags:      tags,
+	})
+	if err != nil {
+		logger.Println(a.region.name, a.name, "Failed to record the replacement of",
+			*onDemand.InstanceId, "on", *spot.InstanceId, err.Error())
+		return err
+	}
+	return nil
+}
+
+// endReplacement removes the transaction record once the replacement is
+// complete.
+func (a *autoScalingGroup) endReplacement(spot *instance) error {
+	_, err := a.region.services.ec2.DeleteTags(&ec2.DeleteTagsInput{
+		Resources: []*string{spot.InstanceId},
+		Tags: []*ec2.Tag{
+			{Key: aws.String(replacingTag)},
+			{Key: aws.String(originalMaxSizeTag)},
+			{Key: aws.String(originalMinSizeTag)},
+		},
+	})
+	if err != nil {
+		logger.Println(a.region.name, a.name, "Failed to clear the replacement record of",
+			*spot.InstanceId, err.Error())
+		return err
+	}
+	return nil
+}
+
+// pendingReplacements returns the transaction records left behind on the
+// spot instances launched for this group by previous runs.
+func (a *autoScalingGroup) pendingReplacements() []replacement {
+	var pending []replacement
+	ownership := a.region.conf.Ownership()
+
+	// only the instances launched for this group are inspected any further,
+	// the other ones may be changed meanwhile by the goroutines of their groups
+	for inst := range a.region.instances.instances() {
+		if inst.ownerGroup(ownership) != a.name {
+			continue
+		}
+
+		var r replacement
+		for _, tag := range inst.Tags {
+			switch *tag.Key {
+			case replacingTag:
+				r.onDemandID = *tag.Value
+			case originalMaxSizeTag:
+				if size, err := strconv.ParseInt(*tag.Value, 10, 64); err == nil {
+					r.originalMaxSize = aws.Int64(size)
+				}
+			case originalMinSizeTag:
+				if size, err := strconv.ParseInt(*tag.Value, 10, 64); err == nil {
+					r.originalMinSize = aws.Int64(size)
+				}
+			}
+		}
+
+		if r.onDemandID != "" {
+			r.spot = inst
+			pending = append(pending, r)
+		}
+	}
+	return pending
+}
+
+// recoverReplacements completes the replacements interrupted by a previous
+// run, or rolls them back when they didn't get to change the group. It
+// returns true if the group was changed, in which case the rest of the
+// processing is better left to the next run, once the group settled.
+func (a *autoScalingGroup) recoverReplacements() bool {
+	changed := false
+
+	for _, r := range a.pendingReplacements() {
+		spotAttached := a.hasMemberInstance(r.spot)
+		onDemand := a.region.instances.get(r.onDemandID)
+		onDemandAttached := onDemand != nil && a.hasMemberInstance(onDemand)
+
+		switch {
+		case !spotAttached && onDemandAttached:
+			// nothing happened yet, the spot instance is attached again by
+			// the regular processing
+			logger.Println(a.region.name, a.name, "Rolling back the interrupted replacement of",
+				r.onDemandID, "with", *r.spot.InstanceId)
+
+		case spotAttached && onDemandAttached:
+			logger.Println(a.region.name, a.name, "Completing the interrupted replacement of",
+				r.onDemandID, "with", *r.spot.InstanceId, "by terminating", r.onDemandID)
+			if err := a.terminateInstanceInAutoScalingGroup(onDemand.InstanceId); err != nil {
+				continue
+			}
+			changed = true
+
+		default:
+			// the on-demand instance was already terminated or detached
+			logger.Println(a.region.name, a.name, "Completing the interrupted replacement of",
+				r.onDemandID, "with", *r.spot.InstanceId)
+			if !spotAttached {
+				if err := a.attachSpotInstance(*r.spot.InstanceId); err != nil {
+					continue
+				}
+				changed = true
+			}
+			if onDemand != nil {
+				if err := onDemand.terminate(); err != nil {
+					continue
+				}
+				changed = true
+			}
+		}
+
+		if r.originalMaxSize != nil && *a.MaxSize != *r.originalMaxSize {
+			logger.Println(a.region.name, a.name, "Restoring MaxSize to", *r.originalMaxSize)
+			if err := a.setAutoScalingMaxSize(*r.originalMaxSize); err == nil {
+				changed = true
+			}
+		}
+
+		if r.originalMinSize != nil && *a.MinSize != *r.originalMinSize {
+			logger.Println(a.region.name, a.name, "Restoring MinSize to", *r.originalMinSize)
+			if err := a.setAutoScalingMinSize(*r.originalMinSize); err == nil {
+				changed = true
+			}
+		}
+
+		a.endReplacement(r.spot)
+	}
+	return changed
+}
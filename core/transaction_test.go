@@ -0,0 +1,263 @@
+package autospotting
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/AutoSpotting/AutoSpotting/core/autospottingtest"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/autoscaling"
+	"github.com/aws/aws-sdk-go/service/ec2"
+)
+
+func Test_autoScalingGroup_recoverReplacements(t *testing.T) {
+	replacing := []*ec2.Tag{{Key: aws.String(replacingTag), Value: aws.String("i-ondemand")}}
+	withMaxSize := append([]*ec2.Tag{
+		{Key: aws.String(originalMaxSizeTag), Value: aws.String("1")},
+	}, replacing...)
+	withMinSize := append([]*ec2.Tag{
+		{Key: aws.String(originalMinSizeTag), Value: aws.String("1")},
+	}, replacing...)
+
+	tests := []struct {
+		name          string
+		members       []string
+		desired, max  int64
+		loweredMin    bool
+		spotTags      []*ec2.Tag
+		onDemandState string
+		wantChanged   bool
+		wantMembers   int
+		wantDesired   int64
+		wantMax       int64
+		wantOnDemand  string
+	}{
+		{
+			name:          "no transaction record",
+			members:       []string{"i-ondemand"},
+			desired:       1,
+			max:           2,
+			onDemandState: "running",
+			wantMembers:   1,
+			wantDesired:   1,
+			wantMax:       2,
+			wantOnDemand:  "running",
+		},
+		{
+			name:          "nothing done yet is rolled back",
+			members:       []string{"i-ondemand"},
+			desired:       1,
+			max:           2,
+			spotTags:      replacing,
+			onDemandState: "running",
+			wantMembers:   1,
+			wantDesired:   1,
+			wantMax:       2,
+			wantOnDemand:  "running",
+		},
+		{
+			name:          "attached spot completes by terminating the on-demand instance",
+			members:       []string{"i-ondemand", "i-spot"},
+			desired:       2,
+			max:           2,
+			spotTags:      withMaxSize,
+			onDemandState: "running",
+			wantChanged:   true,
+			wantMembers:   1,
+			wantDesired:   1,
+			wantMax:       1,
+			wantOnDemand:  "terminated",
+		},
+		{
+			name:          "terminated on-demand instance completes by attaching the spot",
+			members:       nil,
+			desired:       0,
+			max:           2,
+			spotTags:      replacing,
+			onDemandState: "terminated",
+			wantChanged:   true,
+			wantMembers:   1,
+			wantDesired:   1,
+			wantMax:       2,
+			wantOnDemand:  "terminated",
+		},
+		{
+			name:          "lowered MinSize is restored after attaching the spot",
+			members:       nil,
+			desired:       0,
+			max:           1,
+			loweredMin:    true,
+			spotTags:      withMinSize,
+			onDemandState: "terminated",
+			wantChanged:   true,
+			wantMembers:   1,
+			wantDesired:   1,
+			wantMax:       1,
+			wantOnDemand:  "terminated",
+		},
+		{
+			name:          "detached on-demand instance gets terminated",
+			members:       []string{"i-spot"},
+			desired:       1,
+			max:           2,
+			spotTags:      replacing,
+			onDemandState: "running",
+			wantChanged:   true,
+			wantMembers:   1,
+			wantDesired:   1,
+			wantMax:       2,
+			wantOnDemand:  "terminated",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, fr := commandTestConfig()
+			fr.Instance("i-spot").Tags = append(
+				tags("launched-by-autospotting", "true", "launched-for-asg", "asg"), tt.spotTags...)
+			fr.Instance("i-ondemand").State.Name = aws.String(tt.onDemandState)
+
+			g := fr.Group("asg")
+			g.DesiredCapacity, g.MaxSize, g.Instances = aws.Int64(tt.desired), aws.Int64(tt.max), nil
+			for _, id := range tt.members {
+				g.Instances = append(g.Instances, &autoscaling.Instance{
+					InstanceId:           aws.String(id),
+					AvailabilityZone:     aws.String("us-east-1a"),
+					LifecycleState:       aws.String("InService"),
+					ProtectedFromScaleIn: aws.Bool(false),
+				})
+			}
+			if tt.loweredMin {
+				g.MinSize = aws.Int64(0)
+			}
+
+			a := &newTestRegion(cfg, "asg").enabledASGs[0]
+			a.scanInstances()
+
+			if got := a.recoverReplacements(); got != tt.wantChanged {
+				t.Errorf("recoverReplacements() = %v, want %v", got, tt.wantChanged)
+			}
+
+			if len(g.Instances) != tt.wantMembers || *g.DesiredCapacity != tt.wantDesired ||
+				*g.MaxSize != tt.wantMax {
+				t.Errorf("group has %d members, desired %d and max %d, expected %d, %d and %d",
+					len(g.Instances), *g.DesiredCapacity, *g.MaxSize,
+					tt.wantMembers, tt.wantDesired, tt.wantMax)
+			}
+			if *g.MinSize != 1 {
+				t.Errorf("group has min %d, expected 1", *g.MinSize)
+			}
+
+			if state := *fr.Instance("i-ondemand").State.Name; state != tt.wantOnDemand {
+				t.Errorf("on-demand instance is %s, expected %s", state, tt.wantOnDemand)
+			}
+
+			for _, tag := range fr.Instance("i-spot").Tags {
+				if *tag.Key == replacingTag || *tag.Key == originalMaxSizeTag ||
+					*tag.Key == originalMinSizeTag {
+					t.Errorf("transaction record %s wasn't cleared", *tag.Key)
+				}
+			}
+		})
+	}
+}
+
+func Test_autoScalingGroup_replaceOnDemandInstanceWithSpot_clearsRecord(t *testing.T) {
+	cfg, fr := commandTestConfig()
+	g := fr.Group("asg")
+	g.DesiredCapacity, g.MaxSize, g.Instances = aws.Int64(1), aws.Int64(1), g.Instances[:1]
+
+	a := &newTestRegion(cfg, "asg").enabledASGs[0]
+	a.scanInstances()
+	a.config = AutoScalingConfig{TerminationMethod: AutoScalingTerminationMethod}
+
+	if err := a.replaceOnDemandInstanceWithSpot("i-spot"); err != nil {
+		t.Fatalf("replaceOnDemandInstanceWithSpot() error = %v", err)
+	}
+
+	if len(g.Instances) != 1 || *g.Instances[0].InstanceId != "i-spot" || *g.MaxSize != 1 {
+		t.Errorf("unexpected group state after the replacement: %v", g)
+	}
+
+	for _, tag := range fr.Instance("i-spot").Tags {
+		if *tag.Key == replacingTag || *tag.Key == originalMaxSizeTag {
+			t.Errorf("transaction record %s wasn't cleared", *tag.Key)
+		}
+	}
+}
+
+func Test_autoScalingGroup_replaceOnDemandInstanceWithSpot_sizeLimits(t *testing.T) {
+	tests := []struct {
+		name         string
+		desired, max int64
+		strategy     string
+		wantCalls    []string
+	}{
+		{
+			name:     "at min and max size, raising MaxSize",
+			desired:  1,
+			max:      1,
+			strategy: RaiseMaxSizeStrategy,
+			wantCalls: []string{"UpdateAutoScalingGroup", "AttachInstances",
+				"TerminateInstanceInAutoScalingGroup", "UpdateAutoScalingGroup"},
+		},
+		{
+			name:     "at min and max size, detaching first",
+			desired:  1,
+			max:      1,
+			strategy: DetachFirstStrategy,
+			wantCalls: []string{"UpdateAutoScalingGroup", "TerminateInstanceInAutoScalingGroup",
+				"AttachInstances", "UpdateAutoScalingGroup"},
+		},
+		{
+			name:      "at min size only",
+			desired:   1,
+			max:       2,
+			strategy:  RaiseMaxSizeStrategy,
+			wantCalls: []string{"AttachInstances", "TerminateInstanceInAutoScalingGroup"},
+		},
+		{
+			name:      "at max size only",
+			desired:   2,
+			max:       2,
+			strategy:  RaiseMaxSizeStrategy,
+			wantCalls: []string{"TerminateInstanceInAutoScalingGroup", "AttachInstances"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, fr := commandTestConfig()
+			g := fr.Group("asg")
+			g.DesiredCapacity, g.MaxSize, g.Instances = aws.Int64(tt.desired), aws.Int64(tt.max), g.Instances[:1]
+
+			a := &newTestRegion(cfg, "asg").enabledASGs[0]
+			a.scanInstances()
+			a.config = AutoScalingConfig{
+				TerminationMethod: AutoScalingTerminationMethod,
+				MaxSizeStrategy:   tt.strategy,
+			}
+
+			if err := a.replaceOnDemandInstanceWithSpot("i-spot"); err != nil {
+				t.Fatalf("replaceOnDemandInstanceWithSpot() error = %v", err)
+			}
+
+			var calls []string
+			for _, c := range a.region.services.provider.(*autospottingtest.Cloud).MutatingCalls() {
+				if c.Operation != "CreateTags" && c.Operation != "DeleteTags" {
+					calls = append(calls, c.Operation)
+				}
+			}
+			if !reflect.DeepEqual(calls, tt.wantCalls) {
+				t.Errorf("replaceOnDemandInstanceWithSpot() called %v, want %v", calls, tt.wantCalls)
+			}
+
+			if len(g.Instances) != 1 || *g.Instances[0].InstanceId != "i-spot" {
+				t.Errorf("group members = %v, want the spot instance", g.Instances)
+			}
+			if *g.MinSize != 1 || *g.MaxSize != tt.max || *g.DesiredCapacity != tt.desired {
+				t.Errorf("group size = %d/%d/%d, want 1/%d/%d",
+					*g.MinSize, *g.DesiredCapacity, *g.MaxSize, tt.desired, tt.max)
+			}
+		})
+	}
+}
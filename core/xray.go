@@ -0,0 +1,297 @@
+package autospotting
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+const (
+	// xrayTraceHeaderKey is the key of the X-Ray trace header of the Lambda
+	// invocation in the context given to the handler.
+	xrayTraceHeaderKey = "x-amzn-trace-id"
+
+	// defaultXRayDaemonAddress is where the X-Ray daemon listens for
+	// segments, unless given by the AWS_XRAY_DAEMON_ADDRESS environment
+	// variable as it is in Lambda.
+	defaultXRayDaemonAddress = "127.0.0.1:2000"
+
+	// xrayHeader precedes each segment document sent to the X-Ray daemon.
+	xrayHeader = `{"format": "json", "version": 1}` + "\n"
+)
+
+// xraySubsegment is the document of an X-Ray subsegment sent to the daemon
+// once it's completed.
+type xraySubsegment struct {
+	Name      string     `json:"name"`
+	ID        string     `json:"id"`
+	TraceID   string     `json:"trace_id"`
+	ParentID  string     `json:"parent_id"`
+	Type      string     `json:"type"`
+	StartTime float64    `json:"start_time"`
+	EndTime   float64    `json:"end_time"`
+	Namespace string     `json:"namespace,omitempty"`
+	Error     bool       `json:"error,omitempty"`
+	Fault     bool       `json:"fault,omitempty"`
+	Cause     *xrayCause `json:"cause,omitempty"`
+	AWS       *xrayAWS   `json:"aws,omitempty"`
+	HTTP      *xrayHTTP  `json:"http,omitempty"`
+}
+
+type xrayCause struct {
+	Exceptions []xrayException `json:"exceptions"`
+}
+
+type xrayException struct {
+	ID      string `json:"id"`
+	Message string `json:"message"`
+}
+
+type xrayAWS struct {
+	Operation string `json:"operation"`
+	Region    string `json:"region,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+type xrayHTTP struct {
+	Response struct {
+		Status int `json:"status"`
+	} `json:"response"`
+}
+
+// xrayTracer sends the subsegments of the current Lambda invocation to the
+// X-Ray daemon: one for the whole invocation, one for the processing of each
+// region, and one for each AWS API call, nested in the subsegment of its
+// region when it's being processed.
+type xrayTracer struct {
+	traceID string
+	out     io.Writer
+
+	// the subsegment of the invocation, parent of the other ones
+	root *xraySubsegment
+
+	mu      sync.Mutex
+	regions map[string]string
+}
+
+// tracer traces the current Lambda invocation, nil when it isn't traced.
+// Lambda only runs one invocation of a function instance at a time.
+var (
+	tracerMu sync.RWMutex
+	tracer   *xrayTracer
+)
+
+func currentTracer() *xrayTracer {
+	tracerMu.RLock()
+	defer tracerMu.RUnlock()
+	return tracer
+}
+
+// parseXRayTraceHeader returns the trace ID and the parent segment ID given
+// by a trace header such as
+// Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1,
+// and whether the trace is sampled.
+func parseXRayTraceHeader(header string) (string, string, bool) {
+	var root, parent string
+	var sampled bool
+	for _, field := range strings.Split(header, ";") {
+		kv := strings.SplitN(strings.TrimSpace(field), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "Root":
+			root = kv[1]
+		case "Parent":
+			parent = kv[1]
+		case "Sampled":
+			sampled = kv[1] == "1"
+		}
+	}
+	return root, parent, sampled && root != "" && parent != ""
+}
+
+// xrayDaemonAddress returns the UDP address of the X-Ray daemon, given as
+// host:port or as tcp:host:port udp:host:port.
+func xrayDaemonAddress() string {
+	address := os.Getenv("AWS_XRAY_DAEMON_ADDRESS")
+	for _, field := range strings.Fields(address) {
+		if strings.HasPrefix(field, "udp:") {
+			return strings.TrimPrefix(field, "udp:")
+		}
+	}
+	if address == "" || strings.Contains(address, "tcp:") {
+		return defaultXRayDaemonAddress
+	}
+	return address
+}
+
+func newXRaySegmentID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// StartXRayTrace traces the Lambda invocation of the context with X-Ray,
+// when enabled by the XRayTracing configuration and sampled by Lambda. It
+// returns the function completing the trace, given the error of the
+// invocation.
+func StartXRayTrace(ctx context.Context, cfg *Config, name string) func(error) {
+	if cfg == nil || !cfg.XRayTracing || ctx == nil {
+		return func(error) {}
+	}
+	header, _ := ctx.Value(xrayTraceHeaderKey).(string)
+	traceID, parentID, sampled := parseXRayTraceHeader(header)
+	if !sampled {
+		return func(error) {}
+	}
+
+	conn, err := net.Dial("udp", xrayDaemonAddress())
+	if err != nil {
+		logger.Println("Not tracing with X-Ray, couldn't connect to its daemon:", err.Error())
+		return func(error) {}
+	}
+
+	t := startXRayTracer(conn, traceID, parentID, name)
+	return func(err error) {
+		t.stop(err)
+		conn.Close()
+	}
+}
+
+func startXRayTracer(out io.Writer, traceID, parentID, name string) *xrayTracer {
+	t := &xrayTracer{
+		traceID: traceID,
+		out:     out,
+		regions: map[string]string{},
+	}
+	t.root = t.newSubsegment(name, parentID, time.Now())
+
+	tracerMu.Lock()
+	tracer = t
+	tracerMu.Unlock()
+	return t
+}
+
+func (t *xrayTracer) stop(err error) {
+	tracerMu.Lock()
+	if tracer == t {
+		tracer = nil
+	}
+	tracerMu.Unlock()
+
+	t.root.setError(err)
+	t.send(t.root, time.Now())
+}
+
+func (t *xrayTracer) newSubsegment(name, parentID string, start time.Time) *xraySubsegment {
+	return &xraySubsegment{
+		Name:      name,
+		ID:        newXRaySegmentID(),
+		TraceID:   t.traceID,
+		ParentID:  parentID,
+		Type:      "subsegment",
+		StartTime: float64(start.UnixNano()) / 1e9,
+	}
+}
+
+func (s *xraySubsegment) setError(err error) {
+	if err == nil {
+		return
+	}
+	s.Error = true
+	s.Cause = &xrayCause{Exceptions: []xrayException{{ID: newXRaySegmentID(), Message: err.Error()}}}
+}
+
+// send completes the subsegment and sends it to the daemon, only logging the
+// errors since tracing mustn't affect the run.
+func (t *xrayTracer) send(s *xraySubsegment, end time.Time) {
+	s.EndTime = float64(end.UnixNano()) / 1e9
+	data, err := json.Marshal(s)
+	if err != nil {
+		return
+	}
+	if _, err := t.out.Write(append([]byte(xrayHeader), data...)); err != nil {
+		debug.Println("Couldn't send the X-Ray subsegment", s.Name+":", err.Error())
+	}
+}
+
+// traceRegion records the processing of the region in its own subsegment,
+// returning the function completing it.
+func traceRegion(region string) func() {
+	t := currentTracer()
+	if t == nil {
+		return func() {}
+	}
+
+	s := t.newSubsegment(region, t.root.ID, time.Now())
+	t.mu.Lock()
+	if _, ok := t.regions[region]; !ok {
+		t.regions[region] = s.ID
+	}
+	t.mu.Unlock()
+
+	return func() {
+		t.mu.Lock()
+		if t.regions[region] == s.ID {
+			delete(t.regions, region)
+		}
+		t.mu.Unlock()
+		t.send(s, time.Now())
+	}
+}
+
+// parentOf returns the parent of the subsegment of an AWS API call done in
+// the region.
+func (t *xrayTracer) parentOf(region string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if id, ok := t.regions[region]; ok {
+		return id
+	}
+	return t.root.ID
+}
+
+// traceAPICalls makes the clients created from the session record their AWS
+// API calls as X-Ray subsegments, when the invocation is traced.
+func traceAPICalls(sess *session.Session) *session.Session {
+	sess.Handlers.Complete.PushBack(traceAPICall)
+	return sess
+}
+
+func traceAPICall(r *request.Request) {
+	t := currentTracer()
+	if t == nil {
+		return
+	}
+
+	region := aws.StringValue(r.Config.Region)
+	name := r.ClientInfo.ServiceID
+	if name == "" {
+		name = r.ClientInfo.ServiceName
+	}
+
+	s := t.newSubsegment(name, t.parentOf(region), r.Time)
+	s.Namespace = "aws"
+	s.AWS = &xrayAWS{Operation: r.Operation.Name, Region: region, RequestID: r.RequestID}
+	if r.HTTPResponse != nil {
+		s.HTTP = &xrayHTTP{}
+		s.HTTP.Response.Status = r.HTTPResponse.StatusCode
+	}
+	s.setError(r.Error)
+	if s.HTTP != nil && s.HTTP.Response.Status >= 500 {
+		s.Error, s.Fault = false, true
+	}
+	t.send(s, time.Now())
+}
@@ -0,0 +1,148 @@
+package autospotting
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client/metadata"
+	"github.com/aws/aws-sdk-go/aws/request"
+)
+
+func TestParseXRayTraceHeader(t *testing.T) {
+	tests := []struct {
+		header      string
+		wantRoot    string
+		wantParent  string
+		wantSampled bool
+	}{
+		{
+			header:      "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1",
+			wantRoot:    "1-5759e988-bd862e3fe1be46a994272793",
+			wantParent:  "53995c3f42cd8ad8",
+			wantSampled: true,
+		},
+		{
+			header:     "Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=0",
+			wantRoot:   "1-5759e988-bd862e3fe1be46a994272793",
+			wantParent: "53995c3f42cd8ad8",
+		},
+		{
+			header: "",
+		},
+	}
+	for _, tt := range tests {
+		root, parent, sampled := parseXRayTraceHeader(tt.header)
+		if root != tt.wantRoot || parent != tt.wantParent || sampled != tt.wantSampled {
+			t.Errorf("parseXRayTraceHeader(%q) = %q, %q, %v", tt.header, root, parent, sampled)
+		}
+	}
+}
+
+func TestXRayDaemonAddress(t *testing.T) {
+	defer os.Unsetenv("AWS_XRAY_DAEMON_ADDRESS")
+
+	tests := []struct {
+		env  string
+		want string
+	}{
+		{"", defaultXRayDaemonAddress},
+		{"169.254.79.2:2000", "169.254.79.2:2000"},
+		{"tcp:127.0.0.1:2000 udp:127.0.0.2:2000", "127.0.0.2:2000"},
+	}
+	for _, tt := range tests {
+		os.Setenv("AWS_XRAY_DAEMON_ADDRESS", tt.env)
+		if got := xrayDaemonAddress(); got != tt.want {
+			t.Errorf("xrayDaemonAddress() with %q = %q, want %q", tt.env, got, tt.want)
+		}
+	}
+}
+
+func TestStartXRayTraceDisabled(t *testing.T) {
+	sampled := context.WithValue(context.Background(), xrayTraceHeaderKey,
+		"Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=1")
+	notSampled := context.WithValue(context.Background(), xrayTraceHeaderKey,
+		"Root=1-5759e988-bd862e3fe1be46a994272793;Parent=53995c3f42cd8ad8;Sampled=0")
+
+	for name, start := range map[string]func() func(error){
+		"disabled":    func() func(error) { return StartXRayTrace(sampled, &Config{}, "test") },
+		"not sampled": func() func(error) { return StartXRayTrace(notSampled, &Config{XRayTracing: true}, "test") },
+	} {
+		end := start()
+		if currentTracer() != nil {
+			t.Errorf("StartXRayTrace() traced the invocation when %s", name)
+		}
+		end(nil)
+	}
+}
+
+// xraySubsegments decodes the subsegments sent to the daemon.
+func xraySubsegments(t *testing.T, out *bytes.Buffer) map[string]xraySubsegment {
+	result := map[string]xraySubsegment{}
+	for _, doc := range strings.Split(out.String(), xrayHeader)[1:] {
+		var s xraySubsegment
+		if err := json.Unmarshal([]byte(doc), &s); err != nil {
+			t.Fatalf("sent an invalid subsegment %q: %v", doc, err)
+		}
+		result[s.Name] = s
+	}
+	return result
+}
+
+func TestXRayTracer(t *testing.T) {
+	disableLogging()
+	var out bytes.Buffer
+	tr := startXRayTracer(&out, "1-5759e988-bd862e3fe1be46a994272793", "53995c3f42cd8ad8", "AutoSpotting")
+
+	endRegion := traceRegion("us-east-1")
+	traceAPICall(&request.Request{
+		Config:       aws.Config{Region: aws.String("us-east-1")},
+		ClientInfo:   metadata.ClientInfo{ServiceID: "EC2"},
+		Operation:    &request.Operation{Name: "DescribeSpotPriceHistory"},
+		HTTPResponse: &http.Response{StatusCode: 200},
+		RequestID:    "req-1",
+		Time:         time.Now(),
+	})
+	endRegion()
+	traceAPICall(&request.Request{
+		Config:       aws.Config{Region: aws.String("us-east-1")},
+		ClientInfo:   metadata.ClientInfo{ServiceID: "Auto Scaling"},
+		Operation:    &request.Operation{Name: "AttachInstances"},
+		HTTPResponse: &http.Response{StatusCode: 503},
+		Error:        errors.New("ServiceUnavailable"),
+		Time:         time.Now(),
+	})
+	tr.stop(errors.New("timeout"))
+
+	if currentTracer() != nil {
+		t.Errorf("the tracer wasn't removed once stopped")
+	}
+
+	got := xraySubsegments(t, &out)
+	root, region, ec2, asg := got["AutoSpotting"], got["us-east-1"], got["EC2"], got["Auto Scaling"]
+	if root.ParentID != "53995c3f42cd8ad8" || !root.Error || root.Cause == nil {
+		t.Errorf("sent the invocation subsegment %+v", root)
+	}
+	if region.ParentID != root.ID {
+		t.Errorf("sent the region subsegment %+v, want it nested in %s", region, root.ID)
+	}
+	if ec2.ParentID != region.ID || ec2.Namespace != "aws" || ec2.AWS == nil ||
+		ec2.AWS.Operation != "DescribeSpotPriceHistory" || ec2.AWS.RequestID != "req-1" {
+		t.Errorf("sent the EC2 subsegment %+v, want it nested in the region %s", ec2, region.ID)
+	}
+	if asg.ParentID != root.ID || !asg.Fault || asg.Error {
+		t.Errorf("sent the AutoScaling subsegment %+v, want a fault nested in %s", asg, root.ID)
+	}
+	for _, s := range got {
+		if s.TraceID != "1-5759e988-bd862e3fe1be46a994272793" || s.Type != "subsegment" || s.EndTime < s.StartTime {
+			t.Errorf("sent the invalid subsegment %+v", s)
+		}
+	}
+}
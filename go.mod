@@ -4,13 +4,11 @@ go 1.12
 
 require (
 	github.com/aws/aws-lambda-go v1.10.0
-	github.com/aws/aws-sdk-go v1.19.19
+	github.com/aws/aws-sdk-go v1.36.0
 	github.com/cristim/ec2-instances-info v0.0.0-20190426213706-502122c2c226
 	github.com/davecgh/go-spew v1.1.1
 	github.com/namsral/flag v0.0.0-20170814194028-67f268f20922
-	github.com/pkg/errors v0.8.1 // indirect
 	github.com/robfig/cron v1.1.0
 	github.com/stretchr/testify v1.3.0 // indirect
-	golang.org/x/net v0.0.0-20190424112056-4829fb13d2c6 // indirect
-	golang.org/x/text v0.3.2 // indirect
+	gopkg.in/yaml.v2 v2.4.0
 )